@@ -1,6 +1,6 @@
-import { QueryClient, QueryClientProvider } from '@tanstack/react-query'
+import { QueryClient, QueryClientProvider, useQuery } from '@tanstack/react-query'
 import { useState, useEffect, createContext, useContext, ReactNode } from 'react'
-import { LayoutDashboard, Activity, CreditCard, Server, Gamepad2, Bell, Download, Filter, Users, Eye, EyeOff, ChevronsRight, ChevronsLeft, HelpCircle, ChevronDown, ChevronRight } from 'lucide-react'
+import { LayoutDashboard, Activity, CreditCard, Server, Gamepad2, Bell, Download, Filter, Users, Eye, EyeOff, ChevronsRight, ChevronsLeft, HelpCircle, ChevronDown, ChevronRight, AlertTriangle } from 'lucide-react'
 import { ThemeProvider } from './ThemeContext'
 import { TimeRangeProvider, useTimeRange, TIME_RANGES } from './TimeRangeContext'
 import { AuthProvider, useAuth, LoginPage, UserMenu } from './AuthContext'
@@ -65,6 +65,52 @@ import { DollarSign } from 'lucide-react'
 
 const queryClient = new QueryClient()
 
+const API_BASE_URL = import.meta.env.VITE_API_URL || ''
+
+// Health of Pulse itself (GET /api/system/health)
+interface SystemHealth {
+  status: 'green' | 'yellow' | 'red'
+  factors: Array<{ name: string; status: 'green' | 'yellow' | 'red'; value: number; message?: string }>
+  checked_at: string
+}
+
+async function fetchSystemHealth(): Promise<SystemHealth | null> {
+  const token = localStorage.getItem('pulse-token')
+  const res = await fetch(`${API_BASE_URL}/api/system/health`, {
+    headers: token ? { Authorization: `Bearer ${token}` } : {},
+  })
+  if (!res.ok) return null
+  return res.json()
+}
+
+// Banner shown while Pulse itself is degraded, so missing data is not
+// mistaken for a product outage
+function SystemHealthBanner() {
+  const { data } = useQuery({
+    queryKey: ['system-health'],
+    queryFn: fetchSystemHealth,
+    refetchInterval: 30000,
+    retry: false,
+  })
+
+  if (!data || data.status === 'green') return null
+
+  const isRed = data.status === 'red'
+  const messages = data.factors.filter(f => f.status !== 'green' && f.message).map(f => f.message)
+
+  return (
+    <div className={`mb-6 p-4 rounded-xl flex items-start gap-3 ${isRed ? 'bg-red-500/10 text-red-500' : 'bg-yellow-500/10 text-yellow-500'}`}>
+      <AlertTriangle size={20} className="flex-shrink-0 mt-0.5" />
+      <div className="text-sm">
+        <p className="font-semibold">
+          {isRed ? 'Pulse is degraded: data below may be incomplete' : 'Pulse is running behind: recent data may be delayed'}
+        </p>
+        {messages.length > 0 && <p className="mt-1 opacity-80">{messages.join('; ')}</p>}
+      </div>
+    </div>
+  )
+}
+
 // Pages available to all users
 type CommonPage = 'overview' | 'vitals' | 'api' | 'games' | 'alerts' | 'faq'
 // Pages with restricted access (admin controls)
@@ -1122,6 +1168,8 @@ function Dashboard() {
           </div>
         </div>
 
+        <SystemHealthBanner />
+
         {/* Page Content */}
         {renderPage()}
       </main>
@@ -9,6 +9,35 @@ const GOOGLE_CLIENT_ID = import.meta.env.VITE_GOOGLE_CLIENT_ID || 'YOUR_GOOGLE_C
 // API Base URL for backend authentication
 const API_BASE_URL = import.meta.env.VITE_API_URL || ''
 
+// SSO login with the OIDC provider configured on the backend (OIDC_ISSUER_URL)
+const OIDC_ENABLED = import.meta.env.VITE_OIDC_ENABLED === 'true'
+
+const OIDC_ERRORS: Record<string, string> = {
+  access_denied: 'SSO login was cancelled or denied.',
+  login_expired: 'SSO login expired. Please try again.',
+  invalid_token: 'SSO login could not be verified.',
+  email_required: 'Your SSO account has no verified email.',
+  provider_unavailable: 'SSO provider is unavailable. Please try again later.',
+}
+
+// After an OIDC login the backend redirects here with the session tokens
+// (or an error code) in the URL fragment; it is removed from the URL at once
+function consumeOIDCRedirect(): string | null {
+  const params = new URLSearchParams(window.location.hash.slice(1))
+  const token = params.get('token')
+  const oidcError = params.get('oidc_error')
+  if (!token && !oidcError) return null
+
+  window.history.replaceState(null, '', window.location.pathname + window.location.search)
+  if (token) {
+    localStorage.setItem('pulse-token', token)
+    const refreshToken = params.get('refresh_token')
+    if (refreshToken) localStorage.setItem('pulse-refresh-token', refreshToken)
+    return null
+  }
+  return OIDC_ERRORS[oidcError!] || 'SSO login failed. Please try again.'
+}
+
 // Разрешённые домены для авторизации
 const ALLOWED_DOMAINS = ['starcrown.partners']
 
@@ -42,7 +71,7 @@ export function useAuth() {
 }
 
 // Auth API functions
-async function apiLogin(login: string, password: string): Promise<{ success: boolean; token?: string; user?: User; error?: string }> {
+async function apiLogin(login: string, password: string): Promise<{ success: boolean; token?: string; refreshToken?: string; user?: User; error?: string }> {
   try {
     const res = await fetch(`${API_BASE_URL}/api/auth/login`, {
       method: 'POST',
@@ -53,12 +82,29 @@ async function apiLogin(login: string, password: string): Promise<{ success: boo
     if (!res.ok) {
       return { success: false, error: data.error || 'Login failed' }
     }
-    return { success: true, token: data.token, user: data.user }
+    return { success: true, token: data.token, refreshToken: data.refresh_token, user: data.user }
   } catch (err) {
     return { success: false, error: 'Network error. Please try again.' }
   }
 }
 
+// Exchanges the refresh token for a new token pair; the session is extended
+// on every refresh, so only idle sessions expire
+async function apiRefresh(refreshToken: string): Promise<{ token: string; refreshToken: string; user: User } | null> {
+  try {
+    const res = await fetch(`${API_BASE_URL}/api/auth/refresh`, {
+      method: 'POST',
+      headers: { 'Content-Type': 'application/json' },
+      body: JSON.stringify({ refresh_token: refreshToken }),
+    })
+    if (!res.ok) return null
+    const data = await res.json()
+    return { token: data.token, refreshToken: data.refresh_token, user: data.user }
+  } catch {
+    return null
+  }
+}
+
 async function apiVerify(token: string): Promise<{ valid: boolean; user?: User }> {
   try {
     const res = await fetch(`${API_BASE_URL}/api/auth/verify`, {
@@ -89,15 +135,29 @@ function AuthProviderInner({ children }: { children: ReactNode }) {
   const [authError, setAuthError] = useState<string | null>(null)
 
   useEffect(() => {
+    const oidcError = consumeOIDCRedirect()
+    if (oidcError) setAuthError(oidcError)
+
     // Check for saved session token and verify with backend
     const token = localStorage.getItem('pulse-token')
     if (token) {
-      apiVerify(token).then(result => {
+      apiVerify(token).then(async result => {
         if (result.valid && result.user) {
           setUser(result.user)
+          setIsLoading(false)
+          return
+        }
+        // Access token expired - try the refresh token before logging out
+        const refreshToken = localStorage.getItem('pulse-refresh-token')
+        const refreshed = refreshToken ? await apiRefresh(refreshToken) : null
+        if (refreshed) {
+          localStorage.setItem('pulse-token', refreshed.token)
+          localStorage.setItem('pulse-refresh-token', refreshed.refreshToken)
+          localStorage.setItem('pulse-user', JSON.stringify(refreshed.user))
+          setUser(refreshed.user)
         } else {
-          // Token invalid or expired - clear storage
           localStorage.removeItem('pulse-token')
+          localStorage.removeItem('pulse-refresh-token')
           localStorage.removeItem('pulse-user')
         }
         setIsLoading(false)
@@ -130,6 +190,7 @@ function AuthProviderInner({ children }: { children: ReactNode }) {
     setUser(null)
     setAuthError(null)
     localStorage.removeItem('pulse-token')
+    localStorage.removeItem('pulse-refresh-token')
     localStorage.removeItem('pulse-user')
   }
 
@@ -152,13 +213,14 @@ export function AuthProvider({ children }: { children: ReactNode }) {
 
 // Login Page Component
 export function LoginPage() {
+  const { authError } = useAuth()
   const [mode, setMode] = useState<'login' | 'register'>('login')
   const [login, setLogin] = useState('') // email or nickname for login
   const [email, setEmail] = useState('') // username part only (without @domain)
   const [password, setPassword] = useState('')
   const [confirmPassword, setConfirmPassword] = useState('')
   const [nickname, setNickname] = useState('')
-  const [error, setError] = useState<string | null>(null)
+  const [error, setError] = useState<string | null>(authError)
   const [isSubmitting, setIsSubmitting] = useState(false)
 
   const handleEmailLogin = async (e: React.FormEvent) => {
@@ -171,6 +233,7 @@ export function LoginPage() {
 
       if (result.success && result.token && result.user) {
         localStorage.setItem('pulse-token', result.token)
+        if (result.refreshToken) localStorage.setItem('pulse-refresh-token', result.refreshToken)
         localStorage.setItem('pulse-user', JSON.stringify(result.user))
         window.location.reload()
       } else {
@@ -269,6 +332,7 @@ export function LoginPage() {
         if (data.success && data.token && data.user) {
           // Save session token and user data
           localStorage.setItem('pulse-token', data.token)
+          if (data.refresh_token) localStorage.setItem('pulse-refresh-token', data.refresh_token)
           localStorage.setItem('pulse-user', JSON.stringify(data.user))
           window.location.reload()
         } else {
@@ -413,6 +477,15 @@ export function LoginPage() {
           />
         </div>
 
+        {OIDC_ENABLED && (
+          <a
+            href={`${API_BASE_URL}/api/auth/oidc/login`}
+            className="mt-3 w-full inline-flex justify-center px-4 py-2 rounded-lg border border-theme text-theme-primary text-sm font-medium hover:bg-white/5 transition-colors"
+          >
+            Sign in with SSO
+          </a>
+        )}
+
         <p className="text-xs text-theme-muted mt-6">
           Only @starcrown.partners emails are allowed
         </p>
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"io"
 	"log/slog"
 	"net/http"
 	"os"
@@ -9,23 +10,52 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/mcbile/product-pulse/internal/alerting"
+	"github.com/mcbile/product-pulse/internal/anomaly"
+	"github.com/mcbile/product-pulse/internal/canary"
 	"github.com/mcbile/product-pulse/internal/collector"
 	"github.com/mcbile/product-pulse/internal/config"
+	"github.com/mcbile/product-pulse/internal/enrich"
+	"github.com/mcbile/product-pulse/internal/export"
 	"github.com/mcbile/product-pulse/internal/handler"
+	"github.com/mcbile/product-pulse/internal/health"
+	"github.com/mcbile/product-pulse/internal/idtoken"
+	"github.com/mcbile/product-pulse/internal/ingest"
+	"github.com/mcbile/product-pulse/internal/jobs"
+	"github.com/mcbile/product-pulse/internal/maintenance"
 	"github.com/mcbile/product-pulse/internal/middleware"
+	"github.com/mcbile/product-pulse/internal/model"
+	"github.com/mcbile/product-pulse/internal/notify"
+	"github.com/mcbile/product-pulse/internal/quality"
+	"github.com/mcbile/product-pulse/internal/recommend"
+	"github.com/mcbile/product-pulse/internal/rollup"
+	"github.com/mcbile/product-pulse/internal/schemadrift"
+	"github.com/mcbile/product-pulse/internal/sdk"
+	"github.com/mcbile/product-pulse/internal/shadow"
+	"github.com/mcbile/product-pulse/internal/slo"
+	"github.com/mcbile/product-pulse/internal/stability"
 	"github.com/mcbile/product-pulse/internal/storage"
+	"github.com/mcbile/product-pulse/internal/support"
+	"github.com/redis/go-redis/v9"
 )
 
 func main() {
+	// collector support-bundle builds a bundle without starting the collector
+	if len(os.Args) > 1 && os.Args[1] == "support-bundle" {
+		os.Exit(supportBundle(os.Args[2:]))
+	}
+	startedAt := time.Now()
+
 	// Load config
 	cfg := config.Load()
 
-	// Setup logger
+	// Setup logger; recent lines are kept for support bundles
 	logLevel := slog.LevelInfo
 	if cfg.Debug {
 		logLevel = slog.LevelDebug
 	}
-	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
+	recentLogs := support.NewLogBuffer(support.DefaultLogLines)
+	logger := slog.New(slog.NewJSONHandler(io.MultiWriter(os.Stdout, recentLogs), &slog.HandlerOptions{
 		Level: logLevel,
 	}))
 	slog.SetDefault(logger)
@@ -37,102 +67,1027 @@ func main() {
 		os.Exit(1)
 	}
 	defer db.Close()
+	db.ConfigureCopy(cfg.CopyPartition, cfg.CopyParallelism)
+
+	// Shared session and rate limit state (SESSION_STORE=redis)
+	var redisClient *redis.Client
+	switch cfg.SessionStore {
+	case "postgres":
+	case "redis":
+		opts, err := redis.ParseURL(cfg.RedisURL)
+		if err != nil {
+			slog.Error("invalid REDIS_URL", "error", err)
+			os.Exit(1)
+		}
+		redisClient = redis.NewClient(opts)
+		defer redisClient.Close()
+
+		pingCtx, pingCancel := context.WithTimeout(context.Background(), 5*time.Second)
+		err = redisClient.Ping(pingCtx).Err()
+		pingCancel()
+		if err != nil {
+			slog.Error("failed to connect to redis", "error", err)
+			os.Exit(1)
+		}
+		slog.Info("sessions and rate limits shared via redis", "addr", opts.Addr)
+	default:
+		slog.Error("invalid SESSION_STORE, expected postgres or redis", "value", cfg.SessionStore)
+		os.Exit(1)
+	}
+
+	// Spilled events may hold player IDs and payment metadata
+	spillKey, err := collector.ParseSpillKey(cfg.SpillEncryptionKey)
+	if err != nil {
+		slog.Error("invalid spill encryption key", "error", err)
+		os.Exit(1)
+	}
+	if cfg.SpillDir != "" && spillKey == nil {
+		slog.Warn("SPILL_ENCRYPTION_KEY not set, spilled events are stored unencrypted", "dir", cfg.SpillDir)
+	}
+	if cfg.WALDir != "" && spillKey == nil {
+		slog.Warn("SPILL_ENCRYPTION_KEY not set, WAL records are stored unencrypted", "dir", cfg.WALDir)
+	}
 
-	// Create batch collector
-	batchCollector := collector.NewBatchCollector(collector.BatchConfig{
+	// Create batch collectors for frontend events and backend metrics
+	batchConfig := collector.BatchConfig{
 		BatchSize:     cfg.BatchSize,
 		FlushInterval: cfg.FlushInterval,
 		Workers:       cfg.Workers,
+		SpillDir:      cfg.SpillDir,
+		SpillMaxBytes: cfg.SpillMaxBytes,
+		SpillKey:      spillKey,
+		WALDir:        cfg.WALDir,
+
+		WALMaxBytes:     cfg.WALMaxBytes,
+		WALSyncInterval: cfg.WALSyncInterval,
+
+		RetryAttempts:   cfg.FlushRetryAttempts,
+		RetryBackoff:    cfg.FlushRetryBackoff,
+		RetryMaxBackoff: cfg.FlushRetryMaxBackoff,
+		RetryJitter:     cfg.FlushRetryJitter,
+
+		HighWatermark: cfg.QueueHighWatermark,
+		RetryAfter:    cfg.QueueRetryAfter,
+
+		DedupeWindow:    cfg.EventDedupeWindow,
+		SessionAffinity: cfg.SessionAffinity,
+	}
+
+	// Shadow writes to a candidate backend (optional). Collectors write
+	// through the shadow writer, which passes every batch on to db.
+	var sink interface {
+		collector.Storage
+		collector.BackendStorage
+	} = db
+	var shadowWriter *shadow.Writer
+	if cfg.ShadowClickHouseURL != "" {
+		clickhouse, err := shadow.NewClickHouse(cfg.ShadowClickHouseURL, cfg.ShadowClickHouseDatabase)
+		if err != nil {
+			slog.Error("invalid shadow storage", "error", err)
+			os.Exit(1)
+		}
+		shadowWriter = shadow.NewWriter(shadow.Config{
+			Window:     cfg.ShadowCompareWindow,
+			Divergence: cfg.ShadowDivergence,
+		}, db, clickhouse)
+		sink = shadowWriter
+	}
+
+	batchCollector := collector.NewBatchCollector(batchConfig, sink)
+	backendCollectors := collector.NewBackend(batchConfig, sink)
+
+	// Watermarks per rollup; late buckets are re-aggregated by a job
+	rollupTracker := rollup.NewTracker(rollup.Config{
+		Lateness: cfg.RollupLateness,
 	}, db)
+	rollupTracker.Attach(batchCollector, backendCollectors)
 
-	// Start collector
+	// Kill switches: events of killed sites and types are dropped by the
+	// collectors, whichever way they arrive
+	killSwitches := middleware.NewKillSwitches(db, 30*time.Second)
+	batchCollector.DiscardBy(func(e model.EnrichedEvent) bool { return killSwitches.Discard(e.SiteID, e.EventType) })
+	backendCollectors.DiscardBy(killSwitches.Discard)
+
+	// Start collectors
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
+	if err := killSwitches.Start(ctx); err != nil {
+		slog.Error("failed to load kill switches", "error", err)
+		os.Exit(1)
+	}
+
+	// Ingest usage (requests, events, bytes) per site and metric type, and
+	// daily quotas
+	usageMeter := middleware.NewUsageMeter(db, 30*time.Second)
+	if err := usageMeter.Start(ctx); err != nil {
+		slog.Error("failed to load site quotas", "error", err)
+		os.Exit(1)
+	}
 	batchCollector.Start(ctx)
+	backendCollectors.Start(ctx)
+	if shadowWriter != nil {
+		shadowWriter.Start(ctx)
+	}
+
+	// Alert notifications with rate limits and quiet hours
+	notifyLocation, err := time.LoadLocation(cfg.NotifyTimezone)
+	if err != nil {
+		slog.Error("invalid notification time zone", "error", err)
+		os.Exit(1)
+	}
+	quietHours, err := notify.ParseQuietHours(cfg.NotifyQuietHours, notifyLocation)
+	if err != nil {
+		slog.Error("invalid quiet hours", "error", err)
+		os.Exit(1)
+	}
+	notifyLimits, err := notify.ParseRateLimits(cfg.NotifyRateLimits)
+	if err != nil {
+		slog.Error("invalid notification rate limits", "error", err)
+		os.Exit(1)
+	}
+	var channels []notify.Channel
+	for _, name := range cfg.NotifyChannels {
+		switch name {
+		case "log":
+			channels = append(channels, notify.LogChannel{})
+		case "slack":
+			webhooks, err := notify.ParseSlackWebhooks(cfg.SlackWebhooks)
+			if err != nil {
+				slog.Error("invalid slack webhooks", "error", err)
+				os.Exit(1)
+			}
+			slack, err := notify.NewSlackChannel(notify.SlackConfig{
+				Webhooks:     webhooks,
+				Template:     cfg.SlackTemplate,
+				DashboardURL: cfg.DashboardURL,
+			})
+			if err != nil {
+				slog.Error("invalid slack channel", "error", err)
+				os.Exit(1)
+			}
+			channels = append(channels, slack)
+		case "pagerduty":
+			pagerDuty, err := notify.NewPagerDutyChannel(notify.PagerDutyConfig{
+				RoutingKey:   cfg.PagerDutyRoutingKey,
+				MinSeverity:  cfg.PagerDutyMinSeverity,
+				EventsURL:    cfg.PagerDutyEventsURL,
+				DashboardURL: cfg.DashboardURL,
+			})
+			if err != nil {
+				slog.Error("invalid pagerduty channel", "error", err)
+				os.Exit(1)
+			}
+			channels = append(channels, pagerDuty)
+		case "email":
+			email, err := notify.NewEmailChannel(notify.EmailConfig{
+				Host:         cfg.SMTPHost,
+				Port:         cfg.SMTPPort,
+				Username:     cfg.SMTPUsername,
+				Password:     cfg.SMTPPassword,
+				From:         cfg.SMTPFrom,
+				TLS:          cfg.SMTPTLS,
+				DashboardURL: cfg.DashboardURL,
+				AllSites:     handler.HasAllSites,
+			}, db)
+			if err != nil {
+				slog.Error("invalid email channel", "error", err)
+				os.Exit(1)
+			}
+			channels = append(channels, email)
+		case "webhook":
+			channels = append(channels, notify.NewWebhookChannel(notify.WebhookConfig{
+				MaxAttempts:   cfg.WebhookMaxAttempts,
+				Backoff:       cfg.WebhookBackoff,
+				RuleAlertType: alerting.AlertType,
+				DashboardURL:  cfg.DashboardURL,
+			}, db))
+		default:
+			slog.Error("unknown notification channel", "channel", name)
+			os.Exit(1)
+		}
+	}
+	notifier := notify.New(notify.Config{
+		QuietHours: quietHours,
+		RateLimits: notifyLimits,
+	}, db, channels...)
+	alertStore := notifier.Wrap(db)
+
+	// Scheduled jobs
+	scheduler := jobs.NewScheduler(jobs.Config{
+		Timeout:          cfg.JobTimeout,
+		FailureThreshold: cfg.JobFailureThreshold,
+	}, alertStore)
+	registerJob := func(job jobs.Job) {
+		if err := scheduler.Register(ctx, job); err != nil {
+			slog.Error("failed to register job", "job", job.Name, "error", err)
+			os.Exit(1)
+		}
+	}
+
+	registerJob(jobs.Job{
+		Name:     "rollup_reaggregate",
+		Schedule: jobs.Every(cfg.RollupRefreshInterval),
+		Run:      rollupTracker.Reaggregate,
+	})
+
+	registerJob(jobs.Job{
+		Name:     "session_cleanup",
+		Schedule: jobs.Every(15 * time.Minute),
+		Run: func(ctx context.Context) error {
+			n, err := db.DeleteExpiredSessions(ctx)
+			if err != nil {
+				return err
+			}
+			if n > 0 {
+				slog.Info("expired sessions deleted", "count", n)
+			}
+			if _, err := db.DeleteExpiredOIDCLogins(ctx); err != nil {
+				return err
+			}
+			return nil
+		},
+	})
+
+	// Server-side exports are encrypted at rest and deleted after EXPORT_TTL
+	var exports *export.Exports
+	if cfg.ExportDir != "" {
+		exportKey, err := collector.ParseSpillKey(cfg.ExportEncryptionKey)
+		if err != nil || exportKey == nil {
+			slog.Error("EXPORT_DIR needs EXPORT_ENCRYPTION_KEY, 32 bytes hex or base64 encoded", "error", err)
+			os.Exit(1)
+		}
+		exportCipher, err := export.NewAESGCM(exportKey)
+		if err != nil {
+			slog.Error("invalid export encryption key", "error", err)
+			os.Exit(1)
+		}
+		exportStore, err := export.NewDirStore(cfg.ExportDir)
+		if err != nil {
+			slog.Error("failed to open export dir", "dir", cfg.ExportDir, "error", err)
+			os.Exit(1)
+		}
+		exports, err = export.New(export.Config{
+			Store:  exportStore,
+			Cipher: exportCipher,
+			Secret: []byte(cfg.ExportURLSecret),
+			TTL:    cfg.ExportTTL,
+			URLTTL: cfg.ExportURLTTL,
+		})
+		if err != nil {
+			slog.Error("invalid export config, EXPORT_URL_SECRET must be at least 16 bytes", "error", err)
+			os.Exit(1)
+		}
+		registerJob(jobs.Job{
+			Name:     "export_cleanup",
+			Schedule: jobs.Every(15 * time.Minute),
+			Run:      exports.Cleanup,
+		})
+	}
+
+	registerJob(jobs.Job{
+		Name:     "capture_cleanup",
+		Schedule: jobs.Every(time.Hour),
+		Run: func(ctx context.Context) error {
+			n, err := db.DeleteEndedCaptures(ctx, time.Now().Add(-7*24*time.Hour))
+			if err != nil {
+				return err
+			}
+			if n > 0 {
+				slog.Info("ended captures deleted", "count", n)
+			}
+			return nil
+		},
+	})
+
+	// Game launch canaries in a headless browser (optional)
+	if len(cfg.CanaryTargets) > 0 {
+		targets, err := canary.ParseTargets(cfg.CanaryTargets)
+		if err != nil {
+			slog.Error("invalid canary configuration", "error", err)
+			os.Exit(1)
+		}
+		if cfg.CanaryBrowserURL == "" {
+			slog.Error("CANARY_TARGETS requires CANARY_BROWSER_URL")
+			os.Exit(1)
+		}
+		registerJob(jobs.Job{
+			Name:     "game_canaries",
+			Schedule: jobs.Every(cfg.CanaryInterval),
+			Run: canary.NewRunner(canary.Config{
+				Targets:         targets,
+				Timeout:         cfg.CanaryTimeout,
+				BrowserURL:      cfg.CanaryBrowserURL,
+				ReadyExpression: cfg.CanaryReadyExpression,
+			}, db).RunOnce,
+		})
+	}
+
+	// Release health alerts (optional)
+	if len(cfg.StabilityAlertRules) > 0 {
+		rules, err := stability.ParseRules(cfg.StabilityAlertRules)
+		if err != nil {
+			slog.Error("invalid stability alert rules", "error", err)
+			os.Exit(1)
+		}
+		registerJob(jobs.Job{
+			Name:     "release_health",
+			Schedule: jobs.Every(cfg.StabilityInterval),
+			Run: stability.NewChecker(stability.Config{
+				Rules:       rules,
+				Window:      cfg.StabilityWindow,
+				MinSessions: int64(cfg.StabilityMinSessions),
+			}, alertStore).Evaluate,
+		})
+	}
+
+	// Schema drift alerts (optional)
+	if cfg.SchemaDriftAlertEvents > 0 {
+		registerJob(jobs.Job{
+			Name:     "schema_drift",
+			Schedule: jobs.Every(cfg.SchemaDriftInterval),
+			Run: schemadrift.NewChecker(schemadrift.Config{
+				Window:    cfg.SchemaDriftWindow,
+				MinEvents: int64(cfg.SchemaDriftAlertEvents),
+			}, alertStore).Evaluate,
+		})
+	}
+
+	// Digests of notifications held back by quiet hours and rate limits
+	if len(channels) > 0 {
+		registerJob(jobs.Job{
+			Name:     "notification_digest",
+			Schedule: jobs.Every(cfg.NotifyDigestInterval),
+			Run:      notifier.FlushDigests,
+		})
+	}
+
+	// Service level objectives; without objectives a run does nothing
+	registerJob(jobs.Job{
+		Name:     "slo_evaluation",
+		Schedule: jobs.Every(cfg.SLOInterval),
+		Run: slo.NewEvaluator(slo.Config{
+			BurnWindow: cfg.SLOBurnWindow,
+			BurnAlert:  cfg.SLOBurnAlert,
+			MinEvents:  int64(cfg.AlertMinSamples),
+		}, alertStore).Evaluate,
+	})
+
+	// Anomaly detection on API latency, PSP and game launch failures,
+	// scored per 5 minute rollup bucket (optional)
+	if cfg.AnomalyThreshold > 0 {
+		registerJob(jobs.Job{
+			Name:     "anomaly_detection",
+			Schedule: jobs.Every(5 * time.Minute),
+			Run: anomaly.NewDetector(anomaly.Config{
+				Threshold: cfg.AnomalyThreshold,
+				Alpha:     cfg.AnomalyAlpha,
+				History:   cfg.AnomalyHistory,
+				MinEvents: int64(cfg.AlertMinSamples),
+			}, alertStore).Detect,
+		})
+	}
+
+	// Row count comparison of primary and shadow storage (optional)
+	if shadowWriter != nil {
+		registerJob(jobs.Job{
+			Name:     "shadow_compare",
+			Schedule: jobs.Every(cfg.ShadowCompareWindow),
+			Run:      shadowWriter.Compare,
+		})
+	}
+
+	// Threshold alert rules stored in Postgres, each on its own interval;
+	// ALERT_RULES seeds rules that do not exist yet
+	alertEngine := alerting.NewEngine(alerting.Config{
+		Interval:   cfg.AlertInterval,
+		Window:     cfg.AlertWindow,
+		MinSamples: int64(cfg.AlertMinSamples),
+		Weeks:      cfg.AlertBaselineWeeks,
+	}, alertStore)
+	if len(cfg.AlertRules) > 0 {
+		rules, err := alerting.ParseRules(cfg.AlertRules)
+		if err != nil {
+			slog.Error("invalid alert rules", "error", err)
+			os.Exit(1)
+		}
+		if err := alertEngine.Seed(ctx, rules, "ALERT_RULES"); err != nil {
+			slog.Error("failed to seed alert rules", "error", err)
+			os.Exit(1)
+		}
+	}
+	alertEngine.Start(ctx)
+
+	// Adaptive thresholds follow their baseline, learned again every night
+	registerJob(jobs.Job{
+		Name:     "alert_baselines",
+		Schedule: "0 3 * * *",
+		Run:      alertEngine.RecomputeBaselines,
+	})
+
+	// Planner statistics of hot columns, refreshed nightly and on demand
+	// after backfills
+	if cfg.AnalyzeStatisticsTarget < 1 || cfg.AnalyzeStatisticsTarget > 10000 {
+		slog.Error("invalid ANALYZE_STATISTICS_TARGET, must be 1-10000", "value", cfg.AnalyzeStatisticsTarget)
+		os.Exit(1)
+	}
+	tableAnalyzer := maintenance.NewAnalyzer(ctx, maintenance.Config{
+		StatisticsTarget: cfg.AnalyzeStatisticsTarget,
+		Timeout:          cfg.AnalyzeTimeout,
+	}, db)
+	registerJob(jobs.Job{
+		Name:     "table_analyze",
+		Schedule: cfg.AnalyzeSchedule,
+		Timeout:  cfg.AnalyzeTimeout,
+		Run:      tableAnalyzer.Run,
+	})
+
+	scheduler.Start(ctx)
+
+	// Minimum SDK versions for deprecation warnings
+	sdkPolicy, err := sdk.ParsePolicy(cfg.SDKMinVersions)
+	if err != nil {
+		slog.Error("invalid sdk minimum versions", "error", err)
+		os.Exit(1)
+	}
+
+	// Field size policies for oversized metadata / error messages, maximum event age
+	fieldLimits, err := quality.ParsePolicies(cfg.FieldSizePolicies)
+	if err != nil {
+		slog.Error("invalid field size policies", "error", err)
+		os.Exit(1)
+	}
+	if err := fieldLimits.SetMaxEventAges(cfg.MaxEventAge); err != nil {
+		slog.Error("invalid max event age", "error", err)
+		os.Exit(1)
+	}
+
+	// Enrichment stages of frontend events, in order
+	enrichPipeline, err := enrich.Parse(cfg.EnrichPipeline)
+	if err != nil {
+		slog.Error("invalid enrichment pipeline", "error", err)
+		os.Exit(1)
+	}
+
+	// Data residency: sites pinned to a region are only stored there
+	if cfg.DataRegion != "" && !middleware.ValidRegion(cfg.DataRegion) {
+		slog.Error("invalid DATA_REGION, expected lowercase letters, digits and dashes", "value", cfg.DataRegion)
+		os.Exit(1)
+	}
+	residencyForwards, err := middleware.ParseResidencyForwards(cfg.ResidencyForwards)
+	if err != nil {
+		slog.Error("invalid RESIDENCY_FORWARD", "error", err)
+		os.Exit(1)
+	}
+	residency := middleware.NewResidency(db, cfg.DataRegion, residencyForwards, 30*time.Second)
+	if err := residency.Start(ctx); err != nil {
+		slog.Error("failed to load site residencies", "error", err)
+		os.Exit(1)
+	}
+
+	// NATS JetStream ingest (optional)
+	var natsSource *ingest.NATSSource
+	if cfg.NATSURL != "" {
+		natsSource = ingest.NewNATSSource(ingest.NATSConfig{
+			URL:           cfg.NATSURL,
+			Stream:        cfg.NATSStream,
+			SubjectPrefix: cfg.NATSSubjectPrefix,
+			Durable:       cfg.NATSDurable,
+		}, batchCollector, backendCollectors, fieldLimits, enrichPipeline)
+		natsSource.SetResidency(residency)
+		natsSource.SetKillSwitches(killSwitches)
+		natsSource.SetUsage(usageMeter)
+		if err := natsSource.Start(ctx); err != nil {
+			slog.Error("failed to start nats ingest", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	// StatsD UDP listener (optional)
+	var statsdListener *ingest.StatsDListener
+	if cfg.StatsDAddr != "" {
+		statsdListener = ingest.NewStatsDListener(ingest.StatsDConfig{
+			Addr: cfg.StatsDAddr,
+		}, backendCollectors.API)
+		if err := statsdListener.Start(ctx); err != nil {
+			slog.Error("failed to start statsd listener", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	// API keys, signing secrets and service accounts of collect requests
+	siteAuth := middleware.NewSiteAuth(db, 30*time.Second, cfg.RequireAPIKey)
+	if err := siteAuth.Start(ctx); err != nil {
+		slog.Error("failed to load site credentials", "error", err)
+		os.Exit(1)
+	}
+
+	// Time-boxed request recording for support escalations
+	recorder := middleware.NewRecorder(db, 10*time.Second)
+	if err := recorder.Start(ctx); err != nil {
+		slog.Error("failed to load diagnostic captures", "error", err)
+		os.Exit(1)
+	}
 
 	// Setup HTTP handlers
 	mux := http.NewServeMux()
 
-	collectHandler := handler.NewCollectHandler(batchCollector, cfg.AllowedOrigins)
+	collectHandler := handler.NewCollectHandler(batchCollector, fieldLimits, enrichPipeline, cfg.AllowedOrigins)
 	mux.HandleFunc("POST /collect", collectHandler.Handle)
 	mux.HandleFunc("OPTIONS /collect", collectHandler.HandleCORS)
 
-	healthHandler := handler.NewHealthHandler(db)
+	// /ready fails on sustained queue saturation or a growing spill (optional)
+	readiness := handler.NewReadiness(handler.ReadinessConfig{
+		QueueSaturation: cfg.ReadyQueueSaturation,
+		QueueFor:        cfg.ReadyQueueFor,
+		SpillGrowth:     cfg.ReadySpillGrowth,
+	}, batchCollector, backendCollectors)
+	if readiness != nil {
+		readiness.Start(ctx)
+	}
+	healthHandler := handler.NewHealthHandler(db, readiness)
 	mux.HandleFunc("GET /health", healthHandler.Handle)
 	mux.HandleFunc("GET /ready", healthHandler.HandleReady)
 
-	metricsHandler := handler.NewMetricsHandler(batchCollector)
+	metricsHandler := handler.NewMetricsHandler(batchCollector, backendCollectors, usageMeter)
 	mux.HandleFunc("GET /metrics", metricsHandler.Handle)
 
 	// Go client collect endpoints (API, PSP, Game, WebSocket)
-	apiCollectHandler := handler.NewAPICollectHandler(db, cfg.AllowedOrigins)
+	apiCollectHandler := handler.NewAPICollectHandler(backendCollectors.API, fieldLimits, cfg.AllowedOrigins)
 	mux.HandleFunc("POST /collect/api", apiCollectHandler.Handle)
 
-	pspCollectHandler := handler.NewPSPCollectHandler(db, cfg.AllowedOrigins)
+	pspCollectHandler := handler.NewPSPCollectHandler(backendCollectors.PSP, fieldLimits, cfg.AllowedOrigins)
 	mux.HandleFunc("POST /collect/psp", pspCollectHandler.Handle)
 
-	gameCollectHandler := handler.NewGameCollectHandler(db, cfg.AllowedOrigins)
+	gameCollectHandler := handler.NewGameCollectHandler(backendCollectors.Game, fieldLimits, cfg.AllowedOrigins)
 	mux.HandleFunc("POST /collect/game", gameCollectHandler.Handle)
 
-	wsCollectHandler := handler.NewWSCollectHandler(db, cfg.AllowedOrigins)
+	wsCollectHandler := handler.NewWSCollectHandler(backendCollectors.WS, fieldLimits, cfg.AllowedOrigins)
 	mux.HandleFunc("POST /collect/ws", wsCollectHandler.Handle)
 
-	// Dashboard API endpoints
-	dashboardHandler := handler.NewDashboardHandler(db, cfg.AllowedOrigins)
+	// All metric types in one envelope (used by pulse.Client)
+	batchCollectHandler := handler.NewBatchCollectHandler(batchCollector, backendCollectors, fieldLimits, enrichPipeline, cfg.AllowedOrigins)
+	mux.HandleFunc("POST /collect/batch", batchCollectHandler.Handle)
+	mux.HandleFunc("OPTIONS /collect/batch", batchCollectHandler.HandleCORS)
+
+	// Historical backend metrics, exempt from MAX_EVENT_AGE
+	backfillCollectHandler := handler.NewBackfillCollectHandler(batchCollector, backendCollectors, fieldLimits, cfg.AllowedOrigins)
+	mux.HandleFunc("POST /collect/backfill", backfillCollectHandler.Handle)
+
+	// CSP violation reports (report-uri / report-to)
+	cspCollectHandler := handler.NewCSPCollectHandler(db, cfg.AllowedOrigins)
+	mux.HandleFunc("POST /collect/csp", cspCollectHandler.Handle)
+	mux.HandleFunc("OPTIONS /collect/csp", cspCollectHandler.HandleCORS)
+
+	// Runtime directives polled by SDKs and Go clients
+	if cfg.SDKConfigPollInterval < 10*time.Second {
+		slog.Error("SDK_CONFIG_POLL_INTERVAL must be at least 10s", "value", cfg.SDKConfigPollInterval)
+		os.Exit(1)
+	}
+	sdkConfigHandler := handler.NewSDKConfigHandler(db, killSwitches, cfg.SDKConfigPollInterval, cfg.AllowedOrigins)
+	if err := sdkConfigHandler.Start(ctx, 30*time.Second); err != nil {
+		slog.Error("failed to load sdk configs", "error", err)
+		os.Exit(1)
+	}
+	mux.HandleFunc("GET /collect/config", sdkConfigHandler.HandleCollect)
+
+	// Producer registry
+	producerHandler := handler.NewProducerHandler(db, cfg.AllowedOrigins)
+	mux.HandleFunc("POST /collect/register", producerHandler.HandleRegister)
+
+	// Data quality (field size policy actions)
+	dataQualityHandler := handler.NewDataQualityHandler(fieldLimits, cfg.AllowedOrigins)
+
+	// Rollup watermarks and late data
+	rollupRecomputer := rollup.NewRecomputer(ctx, db)
+	rollupHandler := handler.NewRollupHandler(rollupTracker, rollupRecomputer, cfg.AllowedOrigins)
+
+	// SDK version distribution
+	sdkHandler := handler.NewSDKHandler(db, sdkPolicy, cfg.AllowedOrigins)
+
+	// Authentication endpoints
+	var googleVerifier *idtoken.Verifier
+	if cfg.GoogleClientID != "" {
+		googleVerifier = idtoken.NewGoogleVerifier(cfg.GoogleClientID)
+	} else {
+		slog.Warn("GOOGLE_CLIENT_ID not set - Google login disabled")
+	}
+	var sessions handler.SessionStore = db
+	if redisClient != nil {
+		sessions = storage.NewRedisSessions(redisClient, db)
+	}
+	authHandler := handler.NewAuthHandler(db, sessions, googleVerifier, cfg.AccessTokenTTL, cfg.RefreshTokenTTL, cfg.AllowedOrigins)
+	if !handler.ValidSessionBinding(cfg.SessionBinding) {
+		slog.Error("invalid SESSION_BINDING, expected off, warn or enforce", "value", cfg.SessionBinding)
+		os.Exit(1)
+	}
+	if cfg.SessionBindingIPv4Prefix < 0 || cfg.SessionBindingIPv4Prefix > 32 ||
+		cfg.SessionBindingIPv6Prefix < 0 || cfg.SessionBindingIPv6Prefix > 128 {
+		slog.Error("invalid SESSION_BINDING_IPV4_PREFIX or SESSION_BINDING_IPV6_PREFIX",
+			"ipv4", cfg.SessionBindingIPv4Prefix, "ipv6", cfg.SessionBindingIPv6Prefix)
+		os.Exit(1)
+	}
+	authHandler.SetSessionBinding(cfg.SessionBinding, cfg.SessionBindingIPv4Prefix, cfg.SessionBindingIPv6Prefix)
+	authHandler.SetAllowedDomains(cfg.AllowedEmailDomains)
+	mux.HandleFunc("POST /api/auth/login", authHandler.HandleLogin)
+	mux.HandleFunc("POST /api/auth/google", authHandler.HandleGoogleLogin)
+	mux.HandleFunc("POST /api/auth/refresh", authHandler.HandleRefresh)
+	mux.HandleFunc("POST /api/auth/logout", authHandler.HandleLogout)
+	mux.HandleFunc("GET /api/auth/verify", authHandler.HandleVerify)
+	mux.HandleFunc("OPTIONS /api/auth/", authHandler.HandleCORS)
+
+	// Generic OIDC login (optional)
+	if cfg.OIDCIssuerURL != "" {
+		if cfg.OIDCClientID == "" || cfg.OIDCRedirectURL == "" || cfg.OIDCDashboardURL == "" {
+			slog.Error("OIDC_ISSUER_URL needs OIDC_CLIENT_ID, OIDC_REDIRECT_URL and OIDC_DASHBOARD_URL")
+			os.Exit(1)
+		}
+		authHandler.EnableOIDC(idtoken.NewProvider(idtoken.ProviderConfig{
+			IssuerURL:    cfg.OIDCIssuerURL,
+			ClientID:     cfg.OIDCClientID,
+			ClientSecret: cfg.OIDCClientSecret,
+			RedirectURL:  cfg.OIDCRedirectURL,
+			Scopes:       cfg.OIDCScopes,
+		}), cfg.OIDCDashboardURL, cfg.OIDCRequireVerifiedEmail)
+		slog.Info("OIDC login enabled", "issuer", cfg.OIDCIssuerURL)
+	}
+	mux.HandleFunc("GET /api/auth/oidc/login", authHandler.HandleOIDCLogin)
+	mux.HandleFunc("GET /api/auth/oidc/callback", authHandler.HandleOIDCCallback)
+
+	// Dashboard API endpoints. With DASHBOARD_AUTH_REQUIRED, metrics and
+	// alerts need a login and client users only see their granted sites.
+	dashboardHandler := handler.NewDashboardHandler(db, cfg.RawQueryTimeout, cfg.AllowedOrigins)
+	dashboardAuth := func(next http.HandlerFunc) http.HandlerFunc { return next }
+	if cfg.DashboardAuthRequired {
+		dashboardAuth = authHandler.RequireAuth
+	} else {
+		slog.Warn("DASHBOARD_AUTH_REQUIRED=false - dashboard metrics are public")
+	}
+
+	// Dashboard queries are logged to query_log for /api/admin/query-stats
+	var queryLog *handler.QueryLog
+	if cfg.QueryLogEnabled {
+		queryLog = handler.NewQueryLog(db)
+		queryLog.Start(ctx)
+	}
+	dashboardQuery := func(pattern string, h http.HandlerFunc) {
+		mux.HandleFunc(pattern, dashboardAuth(queryLog.Wrap(pattern, h)))
+	}
 
 	// Overview
-	mux.HandleFunc("GET /api/metrics/overview", dashboardHandler.HandleOverview)
+	dashboardQuery("GET /api/metrics/overview", dashboardHandler.HandleOverview)
 
 	// API Performance
-	mux.HandleFunc("GET /api/metrics/api", dashboardHandler.HandleAPIPerformance)
-	mux.HandleFunc("GET /api/metrics/api/timeseries", dashboardHandler.HandleAPITimeSeries)
+	dashboardQuery("GET /api/metrics/api", dashboardHandler.HandleAPIPerformance)
+	dashboardQuery("GET /api/metrics/api/timeseries", dashboardHandler.HandleAPITimeSeries)
 
 	// PSP Health
-	mux.HandleFunc("GET /api/metrics/psp", dashboardHandler.HandlePSPHealth)
-	mux.HandleFunc("GET /api/metrics/psp/timeseries", dashboardHandler.HandlePSPTimeSeries)
+	dashboardQuery("GET /api/metrics/psp", dashboardHandler.HandlePSPHealth)
+	dashboardQuery("GET /api/metrics/psp/timeseries", dashboardHandler.HandlePSPTimeSeries)
+	dashboardQuery("GET /api/metrics/campaigns", dashboardHandler.HandleCampaigns)
+	dashboardQuery("GET /api/metrics/withdrawals", dashboardHandler.HandleWithdrawals)
+	dashboardQuery("GET /api/metrics/withdrawals/pending", dashboardHandler.HandlePendingWithdrawals)
+	dashboardQuery("GET /api/meta/currencies", dashboardHandler.HandleCurrencies)
 
 	// Web Vitals
-	mux.HandleFunc("GET /api/metrics/vitals", dashboardHandler.HandleWebVitals)
-	mux.HandleFunc("GET /api/metrics/vitals/timeseries", dashboardHandler.HandleWebVitalsTimeSeries)
+	dashboardQuery("GET /api/metrics/vitals", dashboardHandler.HandleWebVitals)
+	dashboardQuery("GET /api/metrics/vitals/timeseries", dashboardHandler.HandleWebVitalsTimeSeries)
 
 	// Games
-	mux.HandleFunc("GET /api/metrics/games", dashboardHandler.HandleGameHealth)
-	mux.HandleFunc("GET /api/metrics/games/timeseries", dashboardHandler.HandleGameTimeSeries)
+	dashboardQuery("GET /api/metrics/games", dashboardHandler.HandleGameHealth)
+	dashboardQuery("GET /api/metrics/games/timeseries", dashboardHandler.HandleGameTimeSeries)
+
+	// WebSocket latency percentiles
+	dashboardQuery("GET /api/metrics/ws", dashboardHandler.HandleWebSocketLatency)
+
+	// CSP
+	dashboardQuery("GET /api/metrics/csp", dashboardHandler.HandleCSPViolations)
+
+	// Stability (crash-free rates)
+	dashboardQuery("GET /api/metrics/stability", dashboardHandler.HandleStability)
+
+	// Player journey across all metric tables
+	dashboardQuery("GET /api/players/{player_id}/timeline", dashboardHandler.HandlePlayerTimeline)
+
+	// Raw events as NDJSON, streamed
+	dashboardQuery("GET /api/export/{source}", dashboardHandler.HandleExport)
+
+	// Raw events as encrypted artifacts, downloaded through signed URLs.
+	// Creating one writes to disk, so it needs a session even without
+	// DASHBOARD_AUTH_REQUIRED; the download URL's signature is its credential.
+	if exports != nil {
+		exportHandler := handler.NewExportHandler(db, exports, cfg.AllowedOrigins)
+		mux.HandleFunc("POST /api/exports/{source}", authHandler.RequireAuth(exportHandler.HandleCreate))
+		mux.HandleFunc("GET "+export.DownloadPath+"{name}", exportHandler.HandleDownload)
+	}
+
+	// Error explorer
+	dashboardQuery("GET /api/errors", dashboardHandler.HandleErrors)
+	dashboardQuery("GET /api/errors/{fingerprint}/samples", dashboardHandler.HandleErrorSamples)
 
 	// Alerts
-	mux.HandleFunc("GET /api/alerts", dashboardHandler.HandleAlerts)
-	mux.HandleFunc("POST /api/alerts/{alertTime}/acknowledge", dashboardHandler.HandleAcknowledgeAlert)
+	dashboardQuery("GET /api/alerts", dashboardHandler.HandleAlerts)
+	dashboardQuery("GET /api/alerts/groups", dashboardHandler.HandleAlertGroups)
+	dashboardQuery("GET /api/alerts/stats/daily", dashboardHandler.HandleAlertDailyStats)
+	dashboardQuery("GET /api/alerts/stats/response", dashboardHandler.HandleAlertResponseStats)
+	// Changing alert state needs a session with write access, even when
+	// dashboard reads are public
+	mux.HandleFunc("POST /api/alerts/{alertTime}/acknowledge", authHandler.RequireWrite(dashboardHandler.HandleAcknowledgeAlert))
+	mux.HandleFunc("POST /api/alerts/bulk", authHandler.RequireWrite(dashboardHandler.HandleBulkAlerts))
+
+	// New alerts for chat bots, by long polling with a resumable cursor
+	alertStreamHandler := handler.NewAlertStreamHandler(db, cfg.AllowedOrigins)
+	mux.HandleFunc("GET /api/alerts/stream", dashboardAuth(alertStreamHandler.Handle))
+
+	// Saved dashboards, per user; they need a session even without
+	// DASHBOARD_AUTH_REQUIRED
+	savedDashboardHandler := handler.NewSavedDashboardHandler(db, cfg.AllowedOrigins)
+	mux.HandleFunc("GET /api/dashboards", authHandler.RequireAuth(savedDashboardHandler.HandleList))
+	mux.HandleFunc("POST /api/dashboards", authHandler.RequireAuth(savedDashboardHandler.HandleCreate))
+	mux.HandleFunc(handler.DashboardOpenRoute, authHandler.RequireAuth(queryLog.Wrap(handler.DashboardOpenRoute, savedDashboardHandler.HandleGet)))
+	mux.HandleFunc("PUT /api/dashboards/{id}", authHandler.RequireAuth(savedDashboardHandler.HandleUpdate))
+	mux.HandleFunc("DELETE /api/dashboards/{id}", authHandler.RequireAuth(savedDashboardHandler.HandleDelete))
+
+	// Alert email subscriptions of the current user
+	subscriptionHandler := handler.NewSubscriptionHandler(db, cfg.AllowedOrigins)
+	mux.HandleFunc("GET /api/notifications/email", authHandler.RequireAuth(subscriptionHandler.HandleGet))
+	mux.HandleFunc("PUT /api/notifications/email", authHandler.RequireAuth(subscriptionHandler.HandlePut))
+
+	// Catalog of PSPs and game providers; maintaining it is admin only
+	providerHandler := handler.NewProviderHandler(db, cfg.AllowedOrigins)
+	dashboardQuery("GET /api/providers", providerHandler.HandleList)
+	dashboardQuery("GET /api/providers/{kind}/{name}", providerHandler.HandleGet)
+	mux.HandleFunc("PUT /api/providers/{kind}/{name}", authHandler.RequireAdmin(providerHandler.HandlePut))
+	mux.HandleFunc("DELETE /api/providers/{kind}/{name}", authHandler.RequireAdmin(providerHandler.HandleDelete))
+
+	// Service level objectives; defining them is admin only
+	sloHandler := handler.NewSLOHandler(db, cfg.AllowedOrigins)
+	dashboardQuery("GET /api/slo", sloHandler.HandleList)
+	mux.HandleFunc("POST /api/slo", authHandler.RequireAdmin(sloHandler.HandleCreate))
+	mux.HandleFunc("PUT /api/slo/{name}", authHandler.RequireAdmin(sloHandler.HandleUpdate))
+	mux.HandleFunc("DELETE /api/slo/{name}", authHandler.RequireAdmin(sloHandler.HandleDelete))
+
+	// Health of Pulse itself, shown as a banner when data may be missing
+	systemHealthHandler := handler.NewSystemHealthHandler(db, batchCollector, backendCollectors, scheduler, cfg.JobFailureThreshold, cfg.AllowedOrigins)
+	dashboardQuery("GET /api/system/health", systemHealthHandler.Handle)
+
+	// Producers, SDK versions, schema drift and data quality of the user's
+	// sites; rollup watermarks span all sites
+	dashboardQuery("GET /api/producers", producerHandler.HandleList)
+	dashboardQuery("GET /api/sdk/versions", sdkHandler.HandleVersions)
+	dashboardQuery("GET /api/sdk/drift", sdkHandler.HandleDrift)
+	mux.HandleFunc("GET /api/data-quality", dashboardAuth(dataQualityHandler.Handle))
+	mux.HandleFunc("GET /api/rollups", dashboardAuth(rollupHandler.Handle))
+
+	// Live metrics for wall dashboards (Server-Sent Events)
+	streamHandler := handler.NewStreamHandler(db, cfg.StreamInterval, cfg.StreamWindow, cfg.AllowedOrigins)
+	mux.HandleFunc("GET /api/stream", dashboardAuth(streamHandler.Handle))
+
+	// Sampling and aggregation recommendations from recent ingest volumes
+	analyzer := recommend.NewAnalyzer(recommend.Config{
+		Window:   cfg.RecommendationWindow,
+		CacheTTL: cfg.RecommendationCacheTTL,
+	}, db)
+	recommendationHandler := handler.NewRecommendationHandler(analyzer, cfg.AllowedOrigins)
+	dashboardQuery("GET /api/recommendations", recommendationHandler.Handle)
+
+	// Health verdicts for automated consumers (cashier routing, lobby fallback)
+	decider := health.NewDecider(health.Config{
+		Window:        cfg.DecisionWindow,
+		CacheTTL:      cfg.DecisionCacheTTL,
+		Timeout:       cfg.DecisionTimeout,
+		MinSamples:    cfg.DecisionMinSamples,
+		DegradedBelow: cfg.DecisionDegradedBelow,
+		DownBelow:     cfg.DecisionDownBelow,
+	}, db)
+	healthDecisionHandler := handler.NewHealthDecisionHandler(decider, cfg.AllowedOrigins)
+	if cfg.DecisionPublic {
+		// Verdicts only name the component and its health, so deployments
+		// whose consumers cannot hold a token may serve them openly
+		mux.HandleFunc("GET /api/health/decision", healthDecisionHandler.Handle)
+	} else {
+		mux.HandleFunc("GET /api/health/decision", siteAuth.RequireScope(middleware.HealthScope, healthDecisionHandler.Handle))
+	}
 
 	// CORS preflight for dashboard
 	mux.HandleFunc("OPTIONS /api/", dashboardHandler.HandleCORS)
 
-	// Authentication endpoints
-	authHandler := handler.NewAuthHandler(cfg.AllowedOrigins)
-	mux.HandleFunc("POST /api/auth/login", authHandler.HandleLogin)
-	mux.HandleFunc("POST /api/auth/google", authHandler.HandleGoogleLogin)
-	mux.HandleFunc("POST /api/auth/logout", authHandler.HandleLogout)
-	mux.HandleFunc("GET /api/auth/verify", authHandler.HandleVerify)
-	mux.HandleFunc("OPTIONS /api/auth/", authHandler.HandleCORS)
+	// Users, roles and site grants (admin)
+	userHandler := handler.NewUserHandler(db, cfg.AllowedOrigins)
+	mux.HandleFunc("GET /api/users", authHandler.RequireAdmin(userHandler.HandleList))
+	mux.HandleFunc("PUT /api/users/{email}/role", authHandler.RequireAdmin(userHandler.HandleSetRole))
+	mux.HandleFunc("PUT /api/users/{email}/sites", authHandler.RequireAdmin(userHandler.HandleSetSites))
+
+	// Scheduled jobs (admin)
+	jobsHandler := handler.NewJobsHandler(scheduler, cfg.AllowedOrigins)
+	mux.HandleFunc("GET /api/jobs", authHandler.RequireAdmin(jobsHandler.HandleList))
+	mux.HandleFunc("POST /api/jobs/{name}/run", authHandler.RequireAdmin(jobsHandler.HandleRun))
+	mux.HandleFunc("POST /api/jobs/{name}/pause", authHandler.RequireAdmin(jobsHandler.HandlePause))
+	mux.HandleFunc("POST /api/jobs/{name}/resume", authHandler.RequireAdmin(jobsHandler.HandleResume))
+
+	// Site credentials (admin)
+	credentialHandler := handler.NewCredentialHandler(db, siteAuth, cfg.CredentialGracePeriod, cfg.AllowedOrigins)
+	mux.HandleFunc("GET /api/sites/{site}/credentials", authHandler.RequireAdmin(credentialHandler.HandleList))
+	mux.HandleFunc("POST /api/sites/{site}/credentials", authHandler.RequireAdmin(credentialHandler.HandleCreate))
+	mux.HandleFunc("POST /api/sites/{site}/credentials/{id}/rotate", authHandler.RequireAdmin(credentialHandler.HandleRotate))
+	mux.HandleFunc("DELETE /api/sites/{site}/credentials/{id}", authHandler.RequireAdmin(credentialHandler.HandleRevoke))
+
+	// Site data residency (admin)
+	residencyHandler := handler.NewResidencyHandler(db, residency, cfg.AllowedOrigins)
+	mux.HandleFunc("GET /api/sites/residency", authHandler.RequireAdmin(residencyHandler.HandleList))
+	mux.HandleFunc("GET /api/sites/{site}/residency", authHandler.RequireAdmin(residencyHandler.HandleGet))
+	mux.HandleFunc("PUT /api/sites/{site}/residency", authHandler.RequireAdmin(residencyHandler.HandlePut))
+	mux.HandleFunc("DELETE /api/sites/{site}/residency", authHandler.RequireAdmin(residencyHandler.HandleDelete))
+
+	// SDK remote config (admin)
+	mux.HandleFunc("GET /api/sites/sdk-config", authHandler.RequireAdmin(sdkConfigHandler.HandleList))
+	mux.HandleFunc("GET /api/sites/{site}/sdk-config", authHandler.RequireAdmin(sdkConfigHandler.HandleGet))
+	mux.HandleFunc("PUT /api/sites/{site}/sdk-config", authHandler.RequireAdmin(sdkConfigHandler.HandlePut))
+	mux.HandleFunc("DELETE /api/sites/{site}/sdk-config", authHandler.RequireAdmin(sdkConfigHandler.HandleDelete))
+
+	// Kill switches (admin)
+	killSwitchHandler := handler.NewKillSwitchHandler(db, killSwitches, cfg.AllowedOrigins)
+	mux.HandleFunc("GET /api/kill-switches", authHandler.RequireAdmin(killSwitchHandler.HandleList))
+	mux.HandleFunc("PUT /api/sites/{site}/kill-switches/{type}", authHandler.RequireAdmin(killSwitchHandler.HandlePut))
+	mux.HandleFunc("DELETE /api/sites/{site}/kill-switches/{type}", authHandler.RequireAdmin(killSwitchHandler.HandleDelete))
+
+	// Ingest usage and daily quotas (admin)
+	usageHandler := handler.NewUsageHandler(db, usageMeter, cfg.AllowedOrigins)
+	mux.HandleFunc("GET /api/usage", authHandler.RequireAdmin(usageHandler.Handle))
+	mux.HandleFunc("GET /api/quotas", authHandler.RequireAdmin(usageHandler.HandleQuotas))
+	mux.HandleFunc("PUT /api/sites/{site}/quota", authHandler.RequireAdmin(usageHandler.HandlePutQuota))
+	mux.HandleFunc("DELETE /api/sites/{site}/quota", authHandler.RequireAdmin(usageHandler.HandleDeleteQuota))
+
+	// Service accounts (admin)
+	serviceAccountHandler := handler.NewServiceAccountHandler(db, siteAuth, cfg.AllowedOrigins)
+	mux.HandleFunc("GET /api/service-accounts", authHandler.RequireAdmin(serviceAccountHandler.HandleList))
+	mux.HandleFunc("POST /api/service-accounts", authHandler.RequireAdmin(serviceAccountHandler.HandleCreate))
+	mux.HandleFunc("PUT /api/service-accounts/{id}/scopes", authHandler.RequireAdmin(serviceAccountHandler.HandleUpdateScopes))
+	mux.HandleFunc("DELETE /api/service-accounts/{id}", authHandler.RequireAdmin(serviceAccountHandler.HandleRevoke))
+
+	// Enrichment pipeline and stage timings (admin)
+	enrichmentHandler := handler.NewEnrichmentHandler(enrichPipeline, cfg.AllowedOrigins)
+	mux.HandleFunc("GET /api/admin/enrichment", authHandler.RequireAdmin(enrichmentHandler.Handle))
+
+	// Table sizes, chunk health and rollup freshness (admin)
+	storageStatsHandler := handler.NewStorageStatsHandler(db, cfg.AllowedOrigins)
+	mux.HandleFunc("GET /api/admin/storage/stats", authHandler.RequireAdmin(storageStatsHandler.Handle))
+
+	// Rollup recomputation after backfills and corrections (admin)
+	mux.HandleFunc("POST /api/admin/rollups/recompute", authHandler.RequireAdmin(rollupHandler.HandleRecompute))
+	mux.HandleFunc("GET /api/admin/rollups/recompute", authHandler.RequireAdmin(rollupHandler.HandleRecomputeList))
+	mux.HandleFunc("GET /api/admin/rollups/recompute/{id}", authHandler.RequireAdmin(rollupHandler.HandleRecomputeStatus))
+
+	// Planner statistics refresh after backfills (admin)
+	analyzeHandler := handler.NewAnalyzeHandler(tableAnalyzer, cfg.AllowedOrigins)
+	mux.HandleFunc("POST /api/admin/analyze", authHandler.RequireAdmin(analyzeHandler.HandleStart))
+	mux.HandleFunc("GET /api/admin/analyze", authHandler.RequireAdmin(analyzeHandler.HandleStatus))
+
+	// Dashboard query statistics (admin)
+	queryStatsHandler := handler.NewQueryStatsHandler(db, cfg.AllowedOrigins)
+	mux.HandleFunc("GET /api/admin/query-stats", authHandler.RequireAdmin(queryStatsHandler.Handle))
+
+	// Audit log of dashboard logins (admin)
+	auditHandler := handler.NewAuditHandler(db, cfg.AllowedOrigins)
+	mux.HandleFunc("GET /api/admin/audit", authHandler.RequireAdmin(auditHandler.Handle))
+
+	// Diagnostic request captures (admin)
+	captureHandler := handler.NewCaptureHandler(db, recorder, cfg.AllowedOrigins)
+	mux.HandleFunc("GET /api/admin/captures", authHandler.RequireAdmin(captureHandler.HandleList))
+	mux.HandleFunc("POST /api/admin/captures", authHandler.RequireAdmin(captureHandler.HandleCreate))
+	mux.HandleFunc("GET /api/admin/captures/{id}/records", authHandler.RequireAdmin(captureHandler.HandleRecords))
+	mux.HandleFunc("DELETE /api/admin/captures/{id}", authHandler.RequireAdmin(captureHandler.HandleStop))
+
+	// Support bundle of this collector for bug reports (admin)
+	supportHandler := handler.NewSupportHandler(db, support.Sources{
+		Origin:  "admin endpoint",
+		Config:  cfg,
+		Storage: db,
+		Jobs: func(ctx context.Context) (any, error) {
+			return scheduler.List(ctx)
+		},
+		Stats: func(context.Context) (any, error) {
+			return map[string]any{
+				"collector":     batchCollector.GetStats(),
+				"backend":       backendCollectors.GetStats(),
+				"kill_switches": killSwitches.Stats(),
+			}, nil
+		},
+		Logs:      recentLogs,
+		StartedAt: startedAt,
+	}, cfg.AllowedOrigins)
+	mux.HandleFunc("GET /api/admin/support-bundle", authHandler.RequireAdmin(supportHandler.Handle))
+
+	// Shadow storage comparison (admin)
+	if shadowWriter != nil {
+		shadowHandler := handler.NewShadowHandler(shadowWriter, cfg.AllowedOrigins)
+		mux.HandleFunc("GET /api/shadow", authHandler.RequireAdmin(shadowHandler.Handle))
+	}
+
+	// Threshold alert rules (admin)
+	alertRulesHandler := handler.NewAlertRulesHandler(alertEngine, cfg.AllowedOrigins)
+	mux.HandleFunc("GET /api/alerts/rules", authHandler.RequireAdmin(alertRulesHandler.HandleList))
+	mux.HandleFunc("POST /api/alerts/rules", authHandler.RequireAdmin(alertRulesHandler.HandleCreate))
+	mux.HandleFunc("GET /api/alerts/rules/{name}", authHandler.RequireAdmin(alertRulesHandler.HandleGet))
+	mux.HandleFunc("PUT /api/alerts/rules/{name}", authHandler.RequireAdmin(alertRulesHandler.HandleUpdate))
+	mux.HandleFunc("DELETE /api/alerts/rules/{name}", authHandler.RequireAdmin(alertRulesHandler.HandleDelete))
+
+	// Outbound webhooks of the webhook channel and their delivery log (admin)
+	webhooksHandler := handler.NewWebhooksHandler(db, cfg.AllowedOrigins)
+	mux.HandleFunc("GET /api/webhooks", authHandler.RequireAdmin(webhooksHandler.HandleList))
+	mux.HandleFunc("GET /api/webhooks/{name}", authHandler.RequireAdmin(webhooksHandler.HandleGet))
+	mux.HandleFunc("PUT /api/webhooks/{name}", authHandler.RequireAdmin(webhooksHandler.HandlePut))
+	mux.HandleFunc("DELETE /api/webhooks/{name}", authHandler.RequireAdmin(webhooksHandler.HandleDelete))
+	mux.HandleFunc("GET /api/webhooks/{name}/deliveries", authHandler.RequireAdmin(webhooksHandler.HandleDeliveries))
 
 	// Setup middleware chain
+	trustedProxies, err := middleware.ParseTrustedProxies(cfg.TrustedProxies)
+	if err != nil {
+		slog.Error("invalid trusted proxies", "error", err)
+		os.Exit(1)
+	}
+	rateLimitKeys, err := middleware.ParseKeyPolicy(cfg.RateLimitKeys)
+	if err != nil {
+		slog.Error("invalid rate limit keys", "error", err)
+		os.Exit(1)
+	}
 	rateLimiter := middleware.NewRateLimiter(cfg.RateLimitRPS, cfg.RateLimitBurst, cfg.RateLimitEnabled)
+	if redisClient != nil {
+		rateLimiter = middleware.NewRedisRateLimiter(redisClient, "", cfg.RateLimitRPS, cfg.RateLimitBurst, cfg.RateLimitEnabled)
+	}
+	rateLimiter.SetKeyPolicy(rateLimitKeys)
 	bodySizeLimiter := middleware.NewBodySizeLimiter(cfg.MaxBodySize)
+	producerTracker := middleware.NewProducerTracker(db, 30*time.Second)
+	producerTracker.Start(ctx)
+	sdkTracker := middleware.NewSDKTracker(db, sdkPolicy, 30*time.Second)
+	sdkTracker.Start(ctx)
+	schemaDriftTracker := middleware.NewSchemaDriftTracker(db, 30*time.Second)
+	schemaDriftTracker.Start(ctx)
 
-	// Middleware chain: RateLimit -> BodySize -> Logging -> Handler
-	finalHandler := rateLimiter.Middleware(
-		bodySizeLimiter.Middleware(
-			loggingMiddleware(mux, logger),
+	// Middleware chain: Residency -> KillSwitch -> Recorder -> RateLimit (per IP) -> BodySize -> SiteAuth -> RateLimit (site, key, player) -> Usage -> ProducerTracker -> SDKTracker -> SchemaDrift -> Logging -> Handler.
+	// Residency comes first, so nothing of a site resident elsewhere (not
+	// even a diagnostic capture) is kept here; the receiving region rate
+	// limits and checks the site's credentials. Requests refused by a kill
+	// switch cost no more than that check.
+	finalHandler := residency.Middleware(
+		killSwitches.Middleware(
+			recorder.Middleware(
+				rateLimiter.Middleware(
+					bodySizeLimiter.Middleware(
+						siteAuth.Middleware(
+							rateLimiter.VerifiedMiddleware(
+								usageMeter.Middleware(
+									producerTracker.Middleware(
+										sdkTracker.Middleware(
+											schemaDriftTracker.Middleware(
+												loggingMiddleware(mux, logger),
+											),
+										),
+									),
+								),
+							),
+						),
+					),
+				),
+			),
 		),
 	)
 
-	// Create server
+	// Public routes (status pages, embeds) bypass the chain above: their own
+	// rate limit, no collect or dashboard auth, and none of the /api/* CORS
+	// origins, so exposing a status page never loosens /api/*
+	publicComponents, err := handler.ParsePublicComponents(cfg.PublicStatusComponents)
+	if err != nil {
+		slog.Error("invalid public status components", "error", err)
+		os.Exit(1)
+	}
+	publicHandler := handler.NewPublicHandler(decider, publicComponents, cfg.PublicCacheTTL, cfg.PublicAllowedOrigins)
+	publicMux := http.NewServeMux()
+	publicMux.HandleFunc("GET /public/status", publicHandler.HandleStatus)
+	publicMux.HandleFunc("OPTIONS /public/", publicHandler.HandleCORS)
+
+	publicLimiter := middleware.NewRateLimiter(cfg.PublicRateLimitRPS, cfg.PublicRateLimitBurst, cfg.RateLimitEnabled)
+	if redisClient != nil {
+		publicLimiter = middleware.NewRedisRateLimiter(redisClient, "public", cfg.PublicRateLimitRPS, cfg.PublicRateLimitBurst, cfg.RateLimitEnabled)
+	}
+	publicLimiter.SetKeyPolicy(rateLimitKeys)
+	rootMux := http.NewServeMux()
+	rootMux.Handle("/public/", publicLimiter.Middleware(loggingMiddleware(publicMux, logger)))
+	rootMux.Handle("/", finalHandler)
+
+	// Create server; client addresses are resolved before any other
+	// middleware, so all of them agree on who sent a request
 	server := &http.Server{
 		Addr:         ":" + cfg.Port,
-		Handler:      finalHandler,
+		Handler:      trustedProxies.Middleware(rootMux),
 		ReadTimeout:  5 * time.Second,
 		WriteTimeout: 10 * time.Second,
 		IdleTimeout:  120 * time.Second,
 	}
+	// Shutdown waits for in-flight requests but never cancels them, so live
+	// streams and alert long polls are ended as soon as it starts; otherwise
+	// one open wall dashboard would use up the drain timeout
+	server.RegisterOnShutdown(streamHandler.Close)
+	server.RegisterOnShutdown(alertStreamHandler.Close)
 
 	// Graceful shutdown
 	done := make(chan os.Signal, 1)
@@ -147,21 +1102,47 @@ func main() {
 	}()
 
 	<-done
-	slog.Info("shutting down...")
+	slog.Info("shutting down...", "drain_timeout", cfg.ShutdownDrainTimeout)
+	shutdownStart := time.Now()
+
+	// One deadline covers every ingest path, so the process exits within
+	// the orchestrator's grace period
+	drainCtx, drainCancel := context.WithTimeout(context.Background(), cfg.ShutdownDrainTimeout)
+	defer drainCancel()
+
+	// Stop accepting new events. The HTTP server waits for in-flight
+	// requests, so everything they queue (or insert directly, like CSP
+	// reports) is in before the collectors are drained.
+	if err := server.Shutdown(drainCtx); err != nil {
+		slog.Error("http server shutdown error", "error", err)
+	}
+	slog.Info("http server stopped", "duration_ms", time.Since(shutdownStart).Milliseconds())
 
-	// Stop accepting new events
-	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer shutdownCancel()
+	// Stop pulling from NATS and StatsD. Received NATS messages are acked
+	// as the collectors flush them, so the connection stays open until then.
+	if natsSource != nil {
+		natsSource.StopConsuming(drainCtx)
+	}
+	if statsdListener != nil {
+		statsdListener.Stop()
+	}
 
-	// Flush remaining events
-	batchCollector.Shutdown()
+	// Flush remaining events and metrics of every collector
+	if err := collector.DrainAll(drainCtx, batchCollector, backendCollectors); err != nil {
+		slog.Error("collectors not fully drained", "error", err)
+	}
 
-	// Shutdown HTTP server
-	if err := server.Shutdown(shutdownCtx); err != nil {
-		slog.Error("shutdown error", "error", err)
+	// Send the final acks and close the NATS connection
+	if natsSource != nil {
+		natsSource.Close(drainCtx)
 	}
 
-	slog.Info("shutdown complete")
+	// Cancel running jobs and wait for them to record their result
+	cancel()
+	scheduler.Wait()
+	alertEngine.Wait()
+
+	slog.Info("shutdown complete", "duration_ms", time.Since(shutdownStart).Milliseconds())
 }
 
 func loggingMiddleware(next http.Handler, logger *slog.Logger) http.Handler {
@@ -192,3 +1173,8 @@ func (rw *responseWriter) WriteHeader(code int) {
 	rw.status = code
 	rw.ResponseWriter.WriteHeader(code)
 }
+
+// Unwrap lets http.ResponseController flush streamed responses
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/mcbile/product-pulse/internal/config"
+	"github.com/mcbile/product-pulse/internal/storage"
+	"github.com/mcbile/product-pulse/internal/support"
+)
+
+// supportBundle runs `collector support-bundle`: it builds a support
+// bundle from the environment's config and the database without starting
+// the collector, for when the admin endpoint is out of reach. Stats come
+// from a running collector's /metrics if -metrics-url is given; recent
+// logs are only in bundles of the admin endpoint.
+func supportBundle(args []string) int {
+	fs := flag.NewFlagSet("support-bundle", flag.ContinueOnError)
+	out := fs.String("o", "", "output file (default pulse-support-<time>.zip)")
+	metricsURL := fs.String("metrics-url", "", "/metrics URL of a running collector to include its stats")
+	timeout := fs.Duration("timeout", 30*time.Second, "time allowed to gather the bundle")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *out == "" {
+		*out = support.Filename(time.Now())
+	}
+
+	cfg := config.Load()
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	src := support.Sources{Origin: "cli", Config: cfg}
+	var dbErr error
+	db, err := storage.NewPostgres(cfg.DatabaseURL)
+	if err != nil {
+		dbErr = err
+		fmt.Fprintf(os.Stderr, "database unavailable, bundle has no schema, pool or jobs: %v\n", err)
+	} else {
+		defer db.Close()
+		src.Storage = db
+	}
+	src.Jobs = func(ctx context.Context) (any, error) {
+		if dbErr != nil {
+			return nil, dbErr
+		}
+		return db.GetJobs(ctx)
+	}
+	if *metricsURL != "" {
+		src.Stats = func(ctx context.Context) (any, error) {
+			return fetchStats(ctx, *metricsURL)
+		}
+	}
+
+	f, err := os.Create(*out)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "create bundle: %v\n", err)
+		return 1
+	}
+	if err := support.Write(ctx, f, src); err != nil {
+		f.Close()
+		fmt.Fprintf(os.Stderr, "write bundle: %v\n", err)
+		return 1
+	}
+	if err := f.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "write bundle: %v\n", err)
+		return 1
+	}
+
+	fmt.Println(*out)
+	return 0
+}
+
+// fetchStats reads the collector stats served at /metrics
+func fetchStats(ctx context.Context, url string) (any, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("metrics: status %d", resp.StatusCode)
+	}
+
+	var stats json.RawMessage
+	if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+		return nil, fmt.Errorf("decode metrics: %w", err)
+	}
+	return stats, nil
+}
@@ -0,0 +1,49 @@
+// Command pulsectl queries a Product Pulse collector from a terminal, for
+// when the dashboard is slow or down.
+//
+//	pulsectl query psp --since 30m
+//	pulsectl query psp/timeseries psp=Trustly -o csv
+//	pulsectl query --save brl psp currency=BRL && pulsectl query @brl
+//
+// The collector and credentials come from PULSE_URL (default
+// http://localhost:8080) and PULSE_TOKEN, a dashboard access token.
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+const usage = `usage: pulsectl <command> [flags]
+
+commands:
+  query    query metrics, errors and alerts (pulsectl query -h)
+
+environment:
+  PULSE_URL    collector base URL (default http://localhost:8080)
+  PULSE_TOKEN  dashboard access token
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	switch os.Args[1] {
+	case "query":
+		os.Exit(runQuery(os.Args[2:], os.Stdout, os.Stderr))
+	case "-h", "-help", "--help", "help":
+		fmt.Print(usage)
+	default:
+		fmt.Fprintf(os.Stderr, "pulsectl: unknown command %q\n\n%s", os.Args[1], usage)
+		os.Exit(2)
+	}
+}
+
+func getEnv(key, defaultVal string) string {
+	if val := os.Getenv(key); val != "" {
+		return val
+	}
+	return defaultVal
+}
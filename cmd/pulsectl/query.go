@@ -0,0 +1,431 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// querySources maps query sources to their collector endpoints. Any other
+// source starting with / is requested as is.
+var querySources = map[string]string{
+	"overview":            "/api/metrics/overview",
+	"api":                 "/api/metrics/api",
+	"api/timeseries":      "/api/metrics/api/timeseries",
+	"psp":                 "/api/metrics/psp",
+	"psp/timeseries":      "/api/metrics/psp/timeseries",
+	"campaigns":           "/api/metrics/campaigns",
+	"withdrawals":         "/api/metrics/withdrawals",
+	"withdrawals/pending": "/api/metrics/withdrawals/pending",
+	"vitals":              "/api/metrics/vitals",
+	"vitals/timeseries":   "/api/metrics/vitals/timeseries",
+	"games":               "/api/metrics/games",
+	"games/timeseries":    "/api/metrics/games/timeseries",
+	"ws":                  "/api/metrics/ws",
+	"csp":                 "/api/metrics/csp",
+	"stability":           "/api/metrics/stability",
+	"errors":              "/api/errors",
+	"alerts":              "/api/alerts",
+	"alerts/groups":       "/api/alerts/groups",
+	"currencies":          "/api/meta/currencies",
+	"decision":            "/api/health/decision",
+	"recommendations":     "/api/recommendations",
+	"providers":           "/api/providers",
+	"slo":                 "/api/slo",
+	"system":              "/api/system/health",
+}
+
+// maxCellWidth truncates table cells, e.g. JSON encoded percentiles
+const maxCellWidth = 60
+
+const queryUsage = `usage: pulsectl query [flags] <source|@alias|/api/path> [param=value ...]
+
+Queries the collector's dashboard API. Params are passed as query
+parameters, e.g. psp=Trustly, sort=-success_rate, limit=20, site=product-prod.
+
+sources:
+  %s
+
+aliases:
+  pulsectl query --save NAME <source> [param=value ...]   save a query
+  pulsectl query @NAME [param=value ...]                   run it, params override
+  pulsectl query --aliases                                 list saved queries
+  pulsectl query --delete NAME                             delete one
+
+flags:
+`
+
+// queryAlias is a saved query
+type queryAlias struct {
+	Source string            `json:"source"`
+	Params map[string]string `json:"params,omitempty"`
+	Since  string            `json:"since,omitempty"`
+}
+
+type queryOptions struct {
+	output  string
+	since   time.Duration
+	site    string
+	url     string
+	token   string
+	timeout time.Duration
+	save    string
+	delete  string
+	aliases bool
+}
+
+// runQuery runs `pulsectl query` and returns the exit code
+func runQuery(args []string, stdout, stderr io.Writer) int {
+	var opts queryOptions
+	fs := flag.NewFlagSet("query", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.StringVar(&opts.output, "o", "table", "output: table, csv or json")
+	fs.DurationVar(&opts.since, "since", 0, "query from this long ago (sets start), e.g. 30m; default the endpoint's")
+	fs.StringVar(&opts.site, "site", "", "site to query")
+	fs.StringVar(&opts.url, "url", getEnv("PULSE_URL", "http://localhost:8080"), "collector base URL")
+	fs.StringVar(&opts.token, "token", os.Getenv("PULSE_TOKEN"), "dashboard access token")
+	fs.DurationVar(&opts.timeout, "timeout", 30*time.Second, "request timeout")
+	fs.StringVar(&opts.save, "save", "", "save the query as an alias instead of running it")
+	fs.StringVar(&opts.delete, "delete", "", "delete a saved alias")
+	fs.BoolVar(&opts.aliases, "aliases", false, "list saved aliases")
+	fs.Usage = func() {
+		sources := make([]string, 0, len(querySources))
+		for s := range querySources {
+			sources = append(sources, s)
+		}
+		sort.Strings(sources)
+		fmt.Fprintf(stderr, queryUsage, strings.Join(sources, ", "))
+		fs.PrintDefaults()
+	}
+
+	positional, err := parseInterspersed(fs, args)
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+	if opts.output != "table" && opts.output != "csv" && opts.output != "json" {
+		fmt.Fprintln(stderr, "pulsectl: -o must be table, csv or json")
+		return 2
+	}
+
+	aliases, err := loadAliases()
+	if err != nil {
+		fmt.Fprintf(stderr, "pulsectl: %v\n", err)
+		return 1
+	}
+
+	switch {
+	case opts.aliases:
+		printAliases(stdout, aliases)
+		return 0
+	case opts.delete != "":
+		if _, ok := aliases[opts.delete]; !ok {
+			fmt.Fprintf(stderr, "pulsectl: no alias %q\n", opts.delete)
+			return 1
+		}
+		delete(aliases, opts.delete)
+		return saveAliases(aliases, stderr)
+	}
+
+	if len(positional) == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	q, err := buildQuery(positional, aliases)
+	if err != nil {
+		fmt.Fprintf(stderr, "pulsectl: %v\n", err)
+		return 2
+	}
+	if opts.since > 0 {
+		q.Since = opts.since.String()
+	}
+	if opts.site != "" {
+		q.Params["site"] = opts.site
+	}
+
+	if opts.save != "" {
+		if strings.HasPrefix(opts.save, "@") || strings.ContainsAny(opts.save, " /") {
+			fmt.Fprintln(stderr, "pulsectl: alias names cannot start with @ or contain spaces or /")
+			return 2
+		}
+		aliases[opts.save] = q
+		return saveAliases(aliases, stderr)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), opts.timeout)
+	defer cancel()
+	if err := query(ctx, opts, q, stdout, stderr); err != nil {
+		fmt.Fprintf(stderr, "pulsectl: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// parseInterspersed parses flags placed before, between or after the
+// positional arguments, which it returns
+func parseInterspersed(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// buildQuery turns a source or @alias and param=value arguments into a
+// query; params given here override those of the alias
+func buildQuery(args []string, aliases map[string]queryAlias) (queryAlias, error) {
+	q := queryAlias{Source: args[0], Params: make(map[string]string)}
+	if name, ok := strings.CutPrefix(args[0], "@"); ok {
+		alias, found := aliases[name]
+		if !found {
+			return q, fmt.Errorf("no alias %q, see pulsectl query --aliases", name)
+		}
+		q.Source, q.Since = alias.Source, alias.Since
+		for k, v := range alias.Params {
+			q.Params[k] = v
+		}
+	}
+	if _, ok := querySources[q.Source]; !ok && !strings.HasPrefix(q.Source, "/") {
+		return q, fmt.Errorf("unknown source %q, see pulsectl query -h", q.Source)
+	}
+
+	for _, arg := range args[1:] {
+		key, value, ok := strings.Cut(arg, "=")
+		if !ok || key == "" {
+			return q, fmt.Errorf("expected param=value, got %q", arg)
+		}
+		q.Params[key] = value
+	}
+	return q, nil
+}
+
+// query requests q and writes the result in the output format
+func query(ctx context.Context, opts queryOptions, q queryAlias, stdout, stderr io.Writer) error {
+	path := q.Source
+	if p, ok := querySources[q.Source]; ok {
+		path = p
+	}
+	u, err := url.Parse(strings.TrimRight(opts.url, "/") + path)
+	if err != nil {
+		return fmt.Errorf("invalid url: %w", err)
+	}
+
+	params := u.Query()
+	for k, v := range q.Params {
+		params.Set(k, v)
+	}
+	if q.Since != "" {
+		since, err := time.ParseDuration(q.Since)
+		if err != nil {
+			return fmt.Errorf("invalid since %q: %w", q.Since, err)
+		}
+		params.Set("start", time.Now().Add(-since).UTC().Format(time.RFC3339))
+	}
+	if opts.output != "json" {
+		params.Set("format", "csv")
+	}
+	u.RawQuery = params.Encode()
+
+	body, header, err := get(ctx, u.String(), opts.token)
+	if errors.Is(err, errCSVNotSupported) && opts.output == "table" {
+		// Endpoints answering with nested objects have no CSV, show their JSON
+		params.Del("format")
+		u.RawQuery = params.Encode()
+		opts.output = "json"
+		body, header, err = get(ctx, u.String(), opts.token)
+	}
+	if err != nil {
+		return err
+	}
+
+	if table := header.Get("X-Pulse-Degraded"); table != "" {
+		fmt.Fprintf(stderr, "pulsectl: raw query timed out, answered from %s\n", table)
+	}
+
+	switch opts.output {
+	case "csv":
+		_, err = stdout.Write(body)
+	case "json":
+		var out bytes.Buffer
+		if json.Indent(&out, body, "", "  ") != nil {
+			_, err = stdout.Write(body)
+			break
+		}
+		out.WriteByte('\n')
+		_, err = out.WriteTo(stdout)
+	default:
+		err = writeTable(stdout, body)
+	}
+	if err != nil {
+		return err
+	}
+
+	if total := header.Get("X-Total-Count"); total != "" && opts.output == "table" {
+		fmt.Fprintf(stderr, "%s rows in total\n", total)
+	}
+	return nil
+}
+
+var errCSVNotSupported = errors.New("csv is not supported here")
+
+// get requests url with the access token and returns the body of a 200
+func get(ctx context.Context, url, token string) ([]byte, http.Header, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	switch {
+	case resp.StatusCode == http.StatusNotAcceptable:
+		return nil, nil, errCSVNotSupported
+	case resp.StatusCode == http.StatusUnauthorized:
+		return nil, nil, errors.New("unauthorized, set PULSE_TOKEN to a dashboard access token")
+	case resp.StatusCode != http.StatusOK:
+		return nil, nil, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return body, resp.Header, nil
+}
+
+// writeTable writes CSV as aligned columns
+func writeTable(w io.Writer, body []byte) error {
+	records, err := csv.NewReader(bytes.NewReader(body)).ReadAll()
+	if err != nil {
+		return fmt.Errorf("read csv: %w", err)
+	}
+	if len(records) <= 1 {
+		fmt.Fprintln(w, "no rows")
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for i, record := range records {
+		for j, cell := range record {
+			if i == 0 {
+				cell = strings.ToUpper(cell)
+			}
+			if len(cell) > maxCellWidth {
+				cell = cell[:maxCellWidth-3] + "..."
+			}
+			if j > 0 {
+				io.WriteString(tw, "\t")
+			}
+			io.WriteString(tw, cell)
+		}
+		io.WriteString(tw, "\n")
+	}
+	return tw.Flush()
+}
+
+// aliasesPath is the file of saved queries: PULSECTL_ALIASES or
+// pulsectl/aliases.json in the user's config directory
+func aliasesPath() (string, error) {
+	if path := os.Getenv("PULSECTL_ALIASES"); path != "" {
+		return path, nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "pulsectl", "aliases.json"), nil
+}
+
+func loadAliases() (map[string]queryAlias, error) {
+	aliases := make(map[string]queryAlias)
+	path, err := aliasesPath()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return aliases, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &aliases); err != nil {
+		return nil, fmt.Errorf("read aliases %s: %w", path, err)
+	}
+	return aliases, nil
+}
+
+func saveAliases(aliases map[string]queryAlias, stderr io.Writer) int {
+	path, err := aliasesPath()
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(path), 0o700)
+	}
+	if err == nil {
+		var data []byte
+		data, err = json.MarshalIndent(aliases, "", "  ")
+		if err == nil {
+			err = os.WriteFile(path, append(data, '\n'), 0o600)
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "pulsectl: save aliases: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+func printAliases(w io.Writer, aliases map[string]queryAlias) {
+	names := make([]string, 0, len(aliases))
+	for name := range aliases {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, name := range names {
+		a := aliases[name]
+		keys := make([]string, 0, len(a.Params))
+		for k := range a.Params {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		line := "@" + name + "\t" + a.Source
+		for _, k := range keys {
+			line += " " + k + "=" + a.Params[k]
+		}
+		if a.Since != "" {
+			line += " --since " + a.Since
+		}
+		fmt.Fprintln(tw, line)
+	}
+	tw.Flush()
+}
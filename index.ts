@@ -22,10 +22,30 @@ export interface PulseConfig {
   debug?: boolean
   /** Sample rate 0-1 (default: 1) */
   sampleRate?: number
+  /** Site API key, required once the site has credentials */
+  apiKey?: string
   /** Custom headers for requests */
   headers?: Record<string, string>
   /** Player ID resolver */
   getPlayerId?: () => string | null
+  /** App/site release version, used for crash-free rates */
+  release?: string
+  /** Platform override: web, ios, android, webview (default: device type) */
+  platform?: string
+  /** Bonus/promotion campaign the player arrived with, see setCampaign */
+  campaign?: string
+  /** Poll the collector's /collect/config for site directives (default: true) */
+  remoteConfig?: boolean
+}
+
+/** Site directives from the collector's /collect/config */
+interface RemoteConfig {
+  sample_rate: number
+  disabled_types: string[]
+  endpoint?: string
+  disabled: boolean
+  /** Seconds until the next poll */
+  poll_interval: number
 }
 
 interface MetricEvent {
@@ -37,6 +57,9 @@ interface MetricEvent {
   country: string | null
   event_type: string
   page_path: string
+  release?: string
+  platform?: string
+  campaign?: string
   // Web Vitals
   lcp_ms?: number
   fid_ms?: number
@@ -48,9 +71,14 @@ interface MetricEvent {
   metric_name?: string
   metric_value?: number
   metadata?: Record<string, unknown>
+  /** Unique per event; lets the collector drop retried duplicates */
+  event_id: string
 }
 
-type EventType = 'page_load' | 'web_vital' | 'interaction' | 'error' | 'custom'
+type EventType = 'page_load' | 'web_vital' | 'interaction' | 'error' | 'crash' | 'custom'
+
+/** SDK version, sent as X-Pulse-SDK so the collector can flag outdated SDKs */
+export const SDK_VERSION = '1.4.0'
 
 // ============================================
 // UTILS
@@ -124,6 +152,13 @@ class PulseSDK {
   private observers: PerformanceObserver[] = []
   private clsValue = 0
   private clsEntries: PerformanceEntry[] = []
+  private deprecationWarned = false
+  /** Set when the collector answered 429; no batches are sent before it */
+  private retryAt = 0
+  private remote: RemoteConfig | null = null
+  private remoteTimer: ReturnType<typeof setTimeout> | null = null
+  /** Draw of this session against the remote sample rate */
+  private sampleDraw = Math.random()
 
   init(config: PulseConfig): void {
     if (typeof window === 'undefined') return
@@ -135,8 +170,13 @@ class PulseSDK {
       flushInterval: config.flushInterval ?? 5000,
       debug: config.debug ?? false,
       sampleRate: config.sampleRate ?? 1,
+      apiKey: config.apiKey ?? '',
       headers: config.headers ?? {},
       getPlayerId: config.getPlayerId ?? (() => null),
+      release: config.release ?? '',
+      platform: config.platform ?? '',
+      campaign: config.campaign ?? '',
+      remoteConfig: config.remoteConfig ?? true,
     }
 
     // Check sample rate
@@ -146,6 +186,9 @@ class PulseSDK {
     }
 
     this.sessionId = getSessionId()
+    if (this.config.remoteConfig) {
+      this.fetchRemoteConfig()
+    }
     this.startFlushTimer()
     this.observeWebVitals()
     this.observeErrors()
@@ -211,6 +254,16 @@ class PulseSDK {
     }
   }
 
+  /**
+   * Tag following events with a bonus/promotion campaign (e.g. after a
+   * promo landing page), or clear the tag with null
+   */
+  setCampaign(campaign: string | null): void {
+    if (this.config) {
+      this.config.campaign = campaign ?? ''
+    }
+  }
+
   /**
    * Destroy SDK
    */
@@ -218,6 +271,9 @@ class PulseSDK {
     if (this.flushTimer) {
       clearInterval(this.flushTimer)
     }
+    if (this.remoteTimer) {
+      clearTimeout(this.remoteTimer)
+    }
     this.observers.forEach((o) => o.disconnect())
     this.flush()
   }
@@ -228,6 +284,7 @@ class PulseSDK {
 
   private push(eventType: EventType, data: Partial<MetricEvent>): void {
     if (!this.config) return
+    if (!this.remoteAllows(eventType)) return
 
     const event: MetricEvent = {
       time: new Date().toISOString(),
@@ -238,7 +295,11 @@ class PulseSDK {
       country: null, // Resolved server-side via IP
       event_type: eventType,
       page_path: window.location.pathname,
+      release: this.config.release || undefined,
+      platform: this.config.platform || undefined,
+      campaign: this.config.campaign || undefined,
       ...data,
+      event_id: generateId(),
     }
 
     this.queue.push(event)
@@ -251,21 +312,32 @@ class PulseSDK {
 
   private async sendBatch(): Promise<void> {
     if (!this.config || this.queue.length === 0) return
+    if (Date.now() < this.retryAt) return
 
     const batch = this.queue.splice(0, this.config.batchSize)
 
     try {
-      const response = await fetch(this.config.endpoint, {
+      const response = await fetch(this.collectURL(), {
         method: 'POST',
         headers: {
           'Content-Type': 'application/json',
           'X-Site-Id': this.config.siteId,
+          'X-Pulse-SDK': `js/${SDK_VERSION}`,
+          ...(this.config.apiKey ? { 'X-Pulse-Key': this.config.apiKey } : {}),
           ...this.config.headers,
         },
         body: JSON.stringify({ events: batch }),
         keepalive: true,
       })
 
+      this.checkDeprecation(response)
+
+      if (response.status === 429) {
+        // Collector overloaded, back off as asked
+        const seconds = Number(response.headers.get('Retry-After')) || 5
+        this.retryAt = Date.now() + seconds * 1000
+      }
+
       if (!response.ok) {
         // Re-queue on failure
         this.queue.unshift(...batch)
@@ -279,6 +351,61 @@ class PulseSDK {
     }
   }
 
+  /**
+   * Whether the site's remote config keeps an event: the site and the event
+   * type are not disabled and the session is in the sample
+   */
+  private remoteAllows(eventType: EventType): boolean {
+    const remote = this.remote
+    if (!remote) return true
+    if (remote.disabled || remote.disabled_types.includes(eventType)) return false
+    return this.sampleDraw < remote.sample_rate
+  }
+
+  /** Collect URL: the remote config's endpoint when it rotates the site */
+  private collectURL(): string {
+    if (this.remote?.endpoint) return `${this.remote.endpoint}/collect`
+    return this.config!.endpoint
+  }
+
+  /**
+   * Fetch the site's directives and poll again when the collector asks.
+   * Always asks the configured collector, without custom headers so no
+   * CORS preflight is needed; failures keep the last directives.
+   */
+  private async fetchRemoteConfig(): Promise<void> {
+    if (!this.config) return
+
+    let next = 300
+    try {
+      const base = this.config.endpoint.replace(/\/+$/, '')
+      const response = await fetch(`${base}/config?site=${encodeURIComponent(this.config.siteId)}`, {
+        cache: 'no-cache',
+      })
+      if (response.ok) {
+        const remote: RemoteConfig = await response.json()
+        this.remote = remote
+        next = remote.poll_interval || next
+        if (remote.disabled) {
+          this.queue = []
+        }
+        this.log('Remote config', remote)
+      }
+    } catch (err) {
+      this.log('Remote config error', err)
+    }
+
+    this.remoteTimer = setTimeout(() => this.fetchRemoteConfig(), next * 1000)
+  }
+
+  private checkDeprecation(response: Response): void {
+    const message = response.headers.get('X-Pulse-SDK-Deprecated')
+    if (message && !this.deprecationWarned) {
+      this.deprecationWarned = true
+      console.warn('[Pulse]', message)
+    }
+  }
+
   private startFlushTimer(): void {
     if (!this.config) return
     this.flushTimer = setInterval(() => {
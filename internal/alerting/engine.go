@@ -0,0 +1,494 @@
+// Package alerting evaluates threshold rules stored in Postgres on PSP,
+// game, API and Web Vitals metrics and maintains their alerts in
+// alert_events. Thresholds are fixed or adaptive: a percentage off a
+// baseline learned from the rollups over trailing weeks.
+package alerting
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/mcbile/product-pulse/internal/storage"
+	"github.com/mcbile/product-pulse/pkg/clock"
+)
+
+// AlertType used for threshold alerts in alert_events
+const AlertType = "threshold"
+
+// errNoBaseline is recorded for adaptive rules whose baseline has not been
+// learned yet
+var errNoBaseline = errors.New("no baseline yet: not enough rollup history")
+
+// Storage is the subset of storage used by the rule engine
+type Storage interface {
+	GetAlertRules(ctx context.Context) ([]storage.AlertRule, error)
+	GetAlertRule(ctx context.Context, name string) (storage.AlertRule, error)
+	CreateAlertRule(ctx context.Context, r storage.AlertRule) (storage.AlertRule, error)
+	UpdateAlertRule(ctx context.Context, r storage.AlertRule) (storage.AlertRule, error)
+	DeleteAlertRule(ctx context.Context, name string) (bool, error)
+	SetAlertRuleState(ctx context.Context, name, state string, at time.Time) (bool, error)
+	RecordAlertRuleEvaluation(ctx context.Context, name string, at time.Time, value *float64, samples int64, errMsg string) error
+	SetAlertRuleBaseline(ctx context.Context, name string, baseline *float64, threshold float64, at time.Time) error
+	GetAlertMetric(ctx context.Context, metric, aggregation, target, site string, start time.Time) (storage.AlertMetricValue, error)
+	GetAlertBaseline(ctx context.Context, metric, aggregation, target string, start, end time.Time, window time.Duration) (storage.AlertMetricValue, error)
+	InsertAlert(ctx context.Context, alert storage.AlertRow) error
+	ResolveAlerts(ctx context.Context, alertType, metricName string) error
+}
+
+// Config for the rule engine
+type Config struct {
+	Interval   time.Duration // Evaluation interval of rules created without one
+	Window     time.Duration // Lookback of rules created without one
+	MinSamples int64         // Windows with fewer samples leave the rule's state unchanged
+	Weeks      int           // Baseline history of adaptive rules created without one
+	Tick       time.Duration // How often due rules are checked
+	Reload     time.Duration // How often rules are re-read, to pick up changes made on other instances
+	Clock      clock.Clock   // nil uses the system clock
+}
+
+// RuleStatus is a stored rule with its schedule on this instance
+type RuleStatus struct {
+	storage.AlertRule
+	Interval         string     `json:"interval"`
+	Window           string     `json:"window"`
+	Running          bool       `json:"running"`
+	LastDurationMS   int64      `json:"last_duration_ms"`
+	NextEvaluationAt *time.Time `json:"next_evaluation_at,omitempty"` // nil for disabled rules
+	SkippedOverlaps  int64      `json:"skipped_overlaps"`             // Evaluations skipped because the previous one was still running
+}
+
+type ruleState struct {
+	rule    storage.AlertRule
+	next    time.Time
+	running bool
+
+	lastDuration time.Duration
+	skipped      int64
+}
+
+// Engine evaluates every enabled rule on its own interval over its own
+// window, so a 30s PSP success rule and a 15m Web Vitals rule can share one
+// engine. An evaluation still running when its rule comes due again (a slow
+// query over a long window) is not overlapped: the due run is skipped and
+// counted. Rules live in Postgres; changes made through the engine apply
+// right away, changes made on other instances within Config.Reload.
+//
+// A breached rule moves to firing and raises an alert; the first
+// evaluation that is not breached moves it to resolved and resolves the
+// alert. Every collector evaluates every rule, the state transition in
+// storage makes sure only one of them raises or resolves the alert.
+type Engine struct {
+	config  Config
+	storage Storage
+
+	mu    sync.Mutex
+	rules map[string]*ruleState
+	ctx   context.Context
+	wg    sync.WaitGroup
+}
+
+// NewEngine creates a rule engine
+func NewEngine(config Config, storage Storage) *Engine {
+	if config.Interval <= 0 {
+		config.Interval = time.Minute
+	}
+	if config.Window <= 0 {
+		config.Window = 5 * time.Minute
+	}
+	if config.Tick <= 0 {
+		config.Tick = time.Second
+	}
+	if config.Reload <= 0 {
+		config.Reload = 30 * time.Second
+	}
+	if config.Weeks <= 0 {
+		config.Weeks = 4
+	}
+	config.Clock = clock.OrReal(config.Clock)
+
+	return &Engine{config: config, storage: storage, rules: make(map[string]*ruleState)}
+}
+
+// Start loads the rules and evaluates due rules until ctx is cancelled
+func (e *Engine) Start(ctx context.Context) {
+	e.mu.Lock()
+	e.ctx = ctx
+	e.mu.Unlock()
+
+	if err := e.Reload(ctx); err != nil {
+		slog.Error("failed to load alert rules", "error", err)
+	}
+
+	go func() {
+		ticker := e.config.Clock.NewTicker(e.config.Tick)
+		defer ticker.Stop()
+		lastReload := e.config.Clock.Now()
+
+		for {
+			select {
+			case now := <-ticker.C():
+				if now.Sub(lastReload) >= e.config.Reload {
+					lastReload = now
+					if err := e.Reload(ctx); err != nil {
+						slog.Error("failed to reload alert rules", "error", err)
+					}
+				}
+				e.runDue(now)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	e.mu.Lock()
+	slog.Info("alert rule engine started", "rules", len(e.rules))
+	e.mu.Unlock()
+}
+
+// Wait blocks until running evaluations have finished
+func (e *Engine) Wait() {
+	e.wg.Wait()
+}
+
+// Reload syncs the scheduled rules with storage. New rules are due right
+// away; rules whose interval changed are next due one new interval from
+// now.
+func (e *Engine) Reload(ctx context.Context) error {
+	rules, err := e.storage.GetAlertRules(ctx)
+	if err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	now := e.config.Clock.Now()
+	keep := make(map[string]bool, len(rules))
+	for _, rule := range rules {
+		if !rule.Enabled {
+			continue
+		}
+		keep[rule.Name] = true
+		s, ok := e.rules[rule.Name]
+		switch {
+		case !ok:
+			e.rules[rule.Name] = &ruleState{rule: rule, next: now}
+		case s.rule.Interval != rule.Interval:
+			s.next = now.Add(rule.Interval)
+			fallthrough
+		default:
+			s.rule = rule
+		}
+	}
+	for name := range e.rules {
+		if !keep[name] {
+			delete(e.rules, name)
+		}
+	}
+	return nil
+}
+
+func (e *Engine) runDue(now time.Time) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for _, s := range e.rules {
+		if now.Before(s.next) {
+			continue
+		}
+		s.next = now.Add(s.rule.Interval)
+		if s.running {
+			s.skipped++
+			slog.Warn("alert rule evaluation still running, skipping",
+				"rule", s.rule.Name, "interval", s.rule.Interval, "skipped", s.skipped)
+			continue
+		}
+
+		s.running = true
+		rule := s.rule
+		e.wg.Add(1)
+		go func() {
+			defer e.wg.Done()
+			e.run(s, rule)
+		}()
+	}
+}
+
+// run evaluates a rule once, bounded by its interval, and records the
+// outcome
+func (e *Engine) run(s *ruleState, rule storage.AlertRule) {
+	ctx, cancel := context.WithTimeout(e.ctx, rule.Interval)
+	defer cancel()
+
+	start := e.config.Clock.Now().UTC()
+	value, err := e.evaluate(ctx, rule, start)
+	duration := e.config.Clock.Since(start)
+
+	var v *float64
+	var errMsg string
+	if err != nil {
+		if !errors.Is(err, errNoBaseline) {
+			slog.Error("alert rule evaluation failed", "rule", rule.Name, "error", err)
+		}
+		errMsg = err.Error()
+	} else {
+		v = &value.Value
+	}
+	// Recorded even when shutdown cancelled the evaluation
+	rctx, rcancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer rcancel()
+	if err := e.storage.RecordAlertRuleEvaluation(rctx, rule.Name, start, v, value.Samples, errMsg); err != nil {
+		slog.Error("failed to record alert rule evaluation", "rule", rule.Name, "error", err)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	s.running = false
+	s.lastDuration = duration
+}
+
+// evaluate reads the rule's metric over its window and moves the rule to
+// firing or resolved
+func (e *Engine) evaluate(ctx context.Context, rule storage.AlertRule, now time.Time) (storage.AlertMetricValue, error) {
+	if rule.Adaptive && rule.Baseline == nil {
+		return storage.AlertMetricValue{}, errNoBaseline
+	}
+	value, err := e.storage.GetAlertMetric(ctx, rule.Metric, rule.Aggregation, rule.Target, rule.SiteID, now.Add(-rule.Window))
+	if err != nil {
+		return storage.AlertMetricValue{}, err
+	}
+	if value.Samples < e.config.MinSamples {
+		return value, nil
+	}
+
+	if !breached(rule, value.Value) {
+		resolved, err := e.storage.SetAlertRuleState(ctx, rule.Name, storage.AlertRuleResolved, now)
+		if err != nil || !resolved {
+			return value, err
+		}
+		slog.Info("alert rule resolved", "rule", rule.Name, "value", value.Value)
+		return value, e.storage.ResolveAlerts(ctx, AlertType, rule.Name)
+	}
+
+	fired, err := e.storage.SetAlertRuleState(ctx, rule.Name, storage.AlertRuleFiring, now)
+	if err != nil || !fired {
+		return value, err
+	}
+	slog.Warn("alert rule firing",
+		"rule", rule.Name, "value", value.Value, "condition", rule.Operator, "threshold", rule.Threshold, "adaptive", rule.Adaptive)
+	return value, e.storage.InsertAlert(ctx, storage.AlertRow{
+		Time:           now,
+		AlertType:      AlertType,
+		Severity:       rule.Severity,
+		SourceTable:    storage.AlertMetrics[rule.Metric].Table,
+		MetricName:     rule.Name,
+		Target:         rule.Target,
+		SiteID:         rule.SiteID,
+		ThresholdValue: rule.Threshold,
+		ActualValue:    value.Value,
+		Message: fmt.Sprintf("%s: %s %s at %.2f over the last %s, threshold %s (%d samples)",
+			rule.Name, rule.Aggregation, rule.Metric, value.Value, rule.Window, describeThreshold(rule), value.Samples),
+	})
+}
+
+// withDefaults fills in the engine's interval, window and baseline weeks
+// for rules created without them
+func (e *Engine) withDefaults(r storage.AlertRule) storage.AlertRule {
+	if r.Interval == 0 {
+		r.Interval = e.config.Interval
+	}
+	if r.Window == 0 {
+		r.Window = e.config.Window
+	}
+	if r.Adaptive && r.BaselineWeeks == 0 {
+		r.BaselineWeeks = e.config.Weeks
+	}
+	return r
+}
+
+// learnBaseline sets the baseline of an adaptive rule from the complete
+// days of its trailing weeks, and its threshold from the baseline. With
+// fewer than MinSamples events the baseline is cleared and the rule is not
+// evaluated.
+func (e *Engine) learnBaseline(ctx context.Context, r *storage.AlertRule) error {
+	now := e.config.Clock.Now().UTC()
+	end := now.Truncate(24 * time.Hour)
+	start := end.AddDate(0, 0, -7*r.BaselineWeeks)
+	value, err := e.storage.GetAlertBaseline(ctx, r.Metric, r.Aggregation, r.Target, start, end, r.Window)
+	if err != nil {
+		return err
+	}
+
+	r.BaselineAt = &now
+	if value.Samples < max(e.config.MinSamples, 1) {
+		r.Baseline, r.Threshold = nil, 0
+		return nil
+	}
+	r.Baseline = &value.Value
+	r.Threshold = adaptiveThreshold(*r, value.Value)
+	return nil
+}
+
+// RecomputeBaselines learns the baseline of every enabled adaptive rule
+// again, so its threshold follows organic growth. It runs nightly as the
+// alert_baselines job.
+func (e *Engine) RecomputeBaselines(ctx context.Context) error {
+	rules, err := e.storage.GetAlertRules(ctx)
+	if err != nil {
+		return err
+	}
+
+	var failed, total int
+	for _, r := range rules {
+		if !r.Adaptive || !r.Enabled {
+			continue
+		}
+		total++
+		if err := e.learnBaseline(ctx, &r); err != nil {
+			slog.Error("failed to learn alert rule baseline", "rule", r.Name, "error", err)
+			failed++
+			continue
+		}
+		if err := e.storage.SetAlertRuleBaseline(ctx, r.Name, r.Baseline, r.Threshold, *r.BaselineAt); err != nil {
+			return err
+		}
+		if r.Baseline == nil {
+			slog.Warn("not enough history for alert rule baseline", "rule", r.Name, "weeks", r.BaselineWeeks)
+		} else {
+			slog.Debug("alert rule baseline learned", "rule", r.Name, "baseline", *r.Baseline, "threshold", r.Threshold)
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d alert rule baselines failed", failed, total)
+	}
+	return e.Reload(ctx)
+}
+
+// Create validates and stores a new rule and schedules it; adaptive rules
+// learn their baseline first
+func (e *Engine) Create(ctx context.Context, r storage.AlertRule) (RuleStatus, error) {
+	r = e.withDefaults(r)
+	if err := Validate(&r); err != nil {
+		return RuleStatus{}, err
+	}
+	if r.Adaptive {
+		if err := e.learnBaseline(ctx, &r); err != nil {
+			return RuleStatus{}, err
+		}
+	}
+	created, err := e.storage.CreateAlertRule(ctx, r)
+	if err != nil {
+		return RuleStatus{}, err
+	}
+	return e.reloaded(ctx, created)
+}
+
+// Update validates and stores a changed rule; adaptive rules learn their
+// baseline again. Disabling a rule resolves its open alerts.
+func (e *Engine) Update(ctx context.Context, r storage.AlertRule) (RuleStatus, error) {
+	r = e.withDefaults(r)
+	if err := Validate(&r); err != nil {
+		return RuleStatus{}, err
+	}
+	if r.Adaptive {
+		if err := e.learnBaseline(ctx, &r); err != nil {
+			return RuleStatus{}, err
+		}
+	}
+	updated, err := e.storage.UpdateAlertRule(ctx, r)
+	if err != nil {
+		return RuleStatus{}, err
+	}
+	if !updated.Enabled {
+		if err := e.storage.ResolveAlerts(ctx, AlertType, updated.Name); err != nil {
+			return RuleStatus{}, err
+		}
+	}
+	return e.reloaded(ctx, updated)
+}
+
+// Delete removes a rule and resolves its open alerts
+func (e *Engine) Delete(ctx context.Context, name string) error {
+	deleted, err := e.storage.DeleteAlertRule(ctx, name)
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		return storage.ErrAlertRuleNotFound
+	}
+	if err := e.storage.ResolveAlerts(ctx, AlertType, name); err != nil {
+		return err
+	}
+	return e.Reload(ctx)
+}
+
+// reloaded picks up a changed rule and returns its status
+func (e *Engine) reloaded(ctx context.Context, r storage.AlertRule) (RuleStatus, error) {
+	if err := e.Reload(ctx); err != nil {
+		return RuleStatus{}, err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.status(r), nil
+}
+
+// Get returns one rule with its schedule
+func (e *Engine) Get(ctx context.Context, name string) (RuleStatus, error) {
+	r, err := e.storage.GetAlertRule(ctx, name)
+	if err != nil {
+		return RuleStatus{}, err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.status(r), nil
+}
+
+// List returns all rules with their schedules and last evaluations, by name
+func (e *Engine) List(ctx context.Context) ([]RuleStatus, error) {
+	rules, err := e.storage.GetAlertRules(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	list := make([]RuleStatus, 0, len(rules))
+	for _, r := range rules {
+		list = append(list, e.status(r))
+	}
+	return list, nil
+}
+
+// Seed creates the rules that do not exist yet, so rules from
+// configuration survive alongside those managed through the API. Existing
+// rules of the same name are left as they are.
+func (e *Engine) Seed(ctx context.Context, rules []storage.AlertRule, createdBy string) error {
+	for _, r := range rules {
+		r.CreatedBy = createdBy
+		if _, err := e.Create(ctx, r); err != nil && !errors.Is(err, storage.ErrAlertRuleExists) {
+			return fmt.Errorf("seed alert rule %s: %w", r.Name, err)
+		}
+	}
+	return nil
+}
+
+// status adds this instance's schedule to a stored rule; Engine.mu must be
+// held
+func (e *Engine) status(r storage.AlertRule) RuleStatus {
+	st := RuleStatus{
+		AlertRule: r,
+		Interval:  r.Interval.String(),
+		Window:    r.Window.String(),
+	}
+	if s, ok := e.rules[r.Name]; ok {
+		next := s.next
+		st.Running = s.running
+		st.LastDurationMS = s.lastDuration.Milliseconds()
+		st.NextEvaluationAt = &next
+		st.SkippedOverlaps = s.skipped
+	}
+	return st
+}
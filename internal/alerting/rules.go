@@ -0,0 +1,211 @@
+package alerting
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mcbile/product-pulse/internal/storage"
+)
+
+// ErrInvalidRule is returned for rules that cannot be evaluated
+var ErrInvalidRule = errors.New("invalid alert rule")
+
+// minInterval bounds how often a rule can be evaluated
+const minInterval = time.Second
+
+// maxBaselineWeeks bounds the history adaptive thresholds learn from
+const maxBaselineWeeks = 12
+
+// Severities accepted by rules
+var severities = map[string]bool{"info": true, "warning": true, "critical": true}
+
+// legacyMetrics maps the metric names of ALERT_RULES before rules had an
+// aggregation to metric and aggregation
+var legacyMetrics = map[string][2]string{
+	"psp_success_rate":         {"psp_success", "rate"},
+	"game_launch_success_rate": {"game_launch_success", "rate"},
+	"api_error_rate":           {"api_errors", "rate"},
+	"api_p95_ms":               {"api_duration_ms", "p95"},
+	"lcp_p75_ms":               {"lcp_ms", "p75"},
+	"inp_p75_ms":               {"inp_ms", "p75"},
+	"cls_p75":                  {"cls", "p75"},
+	"fcp_p75_ms":               {"fcp_ms", "p75"},
+	"ttfb_p75_ms":              {"ttfb_ms", "p75"},
+}
+
+// breached reports whether value violates the rule's threshold
+func breached(r storage.AlertRule, value float64) bool {
+	if r.Operator == ">" {
+		return value > r.Threshold
+	}
+	return value < r.Threshold
+}
+
+// Validate checks a rule before it is stored and fills in the metric's
+// default aggregation and the warning severity
+func Validate(r *storage.AlertRule) error {
+	if r.Name == "" || len(r.Name) > 100 {
+		return fmt.Errorf("%w: name is required and at most 100 characters", ErrInvalidRule)
+	}
+	m, ok := storage.AlertMetrics[r.Metric]
+	if !ok {
+		return fmt.Errorf("%w: unknown metric %q", ErrInvalidRule, r.Metric)
+	}
+	if r.Aggregation == "" {
+		r.Aggregation = m.Aggregations[0]
+	}
+	if !m.Supports(r.Aggregation) {
+		return fmt.Errorf("%w: metric %s supports %s", ErrInvalidRule, r.Metric, strings.Join(m.Aggregations, ", "))
+	}
+	if r.Operator != "<" && r.Operator != ">" {
+		return fmt.Errorf("%w: operator must be < or >", ErrInvalidRule)
+	}
+	if r.Severity == "" {
+		r.Severity = "warning"
+	}
+	if !severities[r.Severity] {
+		return fmt.Errorf("%w: severity must be info, warning or critical", ErrInvalidRule)
+	}
+	if r.Interval < minInterval || r.Window < time.Second {
+		return fmt.Errorf("%w: interval and window must be at least %s", ErrInvalidRule, minInterval)
+	}
+	for _, name := range r.Webhooks {
+		if name == "" || len(name) > 100 {
+			return fmt.Errorf("%w: webhook names must be between 1 and 100 characters", ErrInvalidRule)
+		}
+	}
+	if !r.Adaptive {
+		r.BaselinePercent, r.BaselineWeeks, r.Baseline, r.BaselineAt = 0, 0, nil, nil
+		return nil
+	}
+	if r.SiteID != "" {
+		return fmt.Errorf("%w: adaptive thresholds cover all sites, the rollups have no site", ErrInvalidRule)
+	}
+	if !m.SupportsBaseline(r.Aggregation) {
+		if list := m.BaselineAggregations(); len(list) > 0 {
+			return fmt.Errorf("%w: adaptive thresholds on %s support %s", ErrInvalidRule, r.Metric, strings.Join(list, ", "))
+		}
+		return fmt.Errorf("%w: metric %s has no rollup for adaptive thresholds", ErrInvalidRule, r.Metric)
+	}
+	if r.BaselinePercent <= -100 {
+		return fmt.Errorf("%w: baseline_percent must be above -100", ErrInvalidRule)
+	}
+	if r.BaselineWeeks < 1 || r.BaselineWeeks > maxBaselineWeeks {
+		return fmt.Errorf("%w: baseline_weeks must be between 1 and %d", ErrInvalidRule, maxBaselineWeeks)
+	}
+	return nil
+}
+
+// adaptiveThreshold is the threshold of an adaptive rule for a baseline
+func adaptiveThreshold(r storage.AlertRule, baseline float64) float64 {
+	return baseline * (1 + r.BaselinePercent/100)
+}
+
+// describeThreshold formats a rule's threshold for alert messages
+func describeThreshold(r storage.AlertRule) string {
+	if !r.Adaptive || r.Baseline == nil {
+		return fmt.Sprintf("%s %.2f", r.Operator, r.Threshold)
+	}
+	return fmt.Sprintf("%s %.2f (baseline %.2f %+g%%)", r.Operator, r.Threshold, *r.Baseline, r.BaselinePercent)
+}
+
+// ParseRules parses entries in the form
+// [name=]metric[.aggregation][:target]<threshold[@interval[/window]][!severity],
+// with > instead of < for upper bounds, e.g. "psp_success:Trustly<95@30s/5m!critical"
+// or "lcp_ms.p75:mobile>2500@15m/1h". A threshold of baseline[+-N%] makes
+// the rule adaptive, e.g. "api_duration_ms.p95:checkout>baseline+30%". The
+// metric names of rules without aggregation (psp_success_rate, api_p95_ms,
+// ...) are accepted as well. Names default to metric[:target]; intervals,
+// windows and baseline weeks left out are zero.
+func ParseRules(entries []string) ([]storage.AlertRule, error) {
+	rules := make([]storage.AlertRule, 0, len(entries))
+	seen := make(map[string]bool)
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		rule, err := parseRule(entry)
+		if err != nil {
+			return nil, err
+		}
+		if seen[rule.Name] {
+			return nil, fmt.Errorf("duplicate alert rule name %q", rule.Name)
+		}
+		seen[rule.Name] = true
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+func parseRule(entry string) (storage.AlertRule, error) {
+	rule := storage.AlertRule{Severity: "warning", Enabled: true}
+
+	rest := entry
+	if i := strings.LastIndex(rest, "!"); i >= 0 {
+		rule.Severity = strings.TrimSpace(rest[i+1:])
+		rest = rest[:i]
+		if !severities[rule.Severity] {
+			return storage.AlertRule{}, fmt.Errorf("invalid severity in alert rule %q", entry)
+		}
+	}
+	if i := strings.LastIndex(rest, "@"); i >= 0 {
+		interval, window, hasWindow := strings.Cut(rest[i+1:], "/")
+		rest = rest[:i]
+		var err error
+		if rule.Interval, err = time.ParseDuration(strings.TrimSpace(interval)); err != nil || rule.Interval <= 0 {
+			return storage.AlertRule{}, fmt.Errorf("invalid interval in alert rule %q", entry)
+		}
+		if hasWindow {
+			if rule.Window, err = time.ParseDuration(strings.TrimSpace(window)); err != nil || rule.Window <= 0 {
+				return storage.AlertRule{}, fmt.Errorf("invalid window in alert rule %q", entry)
+			}
+		}
+	}
+
+	i := strings.IndexAny(rest, "<>")
+	if i < 0 {
+		return storage.AlertRule{}, fmt.Errorf("invalid alert rule %q, expected [name=]metric[:target]<threshold", entry)
+	}
+	rule.Operator = rest[i : i+1]
+	threshold := strings.TrimSpace(rest[i+1:])
+	if offset, ok := strings.CutPrefix(threshold, "baseline"); ok {
+		rule.Adaptive = true
+		if offset = strings.TrimSpace(offset); offset != "" {
+			percent, err := strconv.ParseFloat(strings.TrimSpace(strings.TrimSuffix(offset, "%")), 64)
+			if err != nil || !strings.HasSuffix(offset, "%") || (offset[0] != '+' && offset[0] != '-') {
+				return storage.AlertRule{}, fmt.Errorf("invalid baseline offset in alert rule %q, expected baseline+N%% or baseline-N%%", entry)
+			}
+			rule.BaselinePercent = percent
+		}
+	} else {
+		value, err := strconv.ParseFloat(threshold, 64)
+		if err != nil {
+			return storage.AlertRule{}, fmt.Errorf("invalid threshold in alert rule %q", entry)
+		}
+		rule.Threshold = value
+	}
+
+	target := strings.TrimSpace(rest[:i])
+	if name, t, ok := strings.Cut(target, "="); ok {
+		rule.Name = strings.TrimSpace(name)
+		target = strings.TrimSpace(t)
+	}
+	metric, selector, _ := strings.Cut(target, ":")
+	metric = strings.TrimSpace(metric)
+	rule.Metric, rule.Aggregation, _ = strings.Cut(metric, ".")
+	if legacy, ok := legacyMetrics[rule.Metric]; ok && rule.Aggregation == "" {
+		rule.Metric, rule.Aggregation = legacy[0], legacy[1]
+	}
+	rule.Target = strings.TrimSpace(selector)
+	if _, ok := storage.AlertMetrics[rule.Metric]; !ok {
+		return storage.AlertRule{}, fmt.Errorf("unknown metric %q in alert rule %q", rule.Metric, entry)
+	}
+	if rule.Name == "" {
+		rule.Name = target
+	}
+	return rule, nil
+}
@@ -0,0 +1,183 @@
+// Package anomaly learns a baseline for API latency, PSP failure rate and
+// game launch failure rate from the rollups and raises alerts when a
+// target deviates from it, without hand-tuned thresholds.
+package anomaly
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/mcbile/product-pulse/internal/storage"
+)
+
+// AlertType used for anomaly alerts in alert_events
+const AlertType = "anomaly"
+
+// bucket is the width of the points the baseline is learned from
+const bucket = 5 * time.Minute
+
+// minHistory is how many buckets a target needs before it is scored
+const minHistory = 12
+
+// Storage is the subset of storage used by the detector
+type Storage interface {
+	GetAnomalyPoints(ctx context.Context, series string, start, end time.Time) ([]storage.AnomalyPoint, error)
+	InsertAlert(ctx context.Context, alert storage.AlertRow) error
+	HasOpenAlert(ctx context.Context, alertType, metricName string) (bool, error)
+	ResolveAlerts(ctx context.Context, alertType, metricName string) error
+}
+
+// minDeviation keeps quiet series (a PSP that never fails) from alerting
+// on the first blip: the standard deviation used for scoring is at least
+// this, or 5% of the baseline, whichever is larger
+var minDeviation = map[string]float64{
+	"api_latency_ms":           5, // ms
+	"psp_failure_rate":         1, // percentage points
+	"game_launch_failure_rate": 1,
+}
+
+// Config for the detector
+type Config struct {
+	Threshold float64       // z-score that raises an alert
+	Alpha     float64       // EWMA smoothing factor, higher adapts faster
+	History   time.Duration // Lookback the baseline is learned from
+	MinEvents int64         // Events a bucket needs to be scored
+}
+
+// Score is the latest bucket of a target against its baseline
+type Score struct {
+	Series   string
+	Key      string
+	Bucket   time.Time
+	Value    float64
+	Baseline float64 // EWMA of the previous buckets
+	StdDev   float64 // EW standard deviation, at least the series' minimum
+	Z        float64
+}
+
+// Detector scores the newest complete bucket of every target against an
+// exponentially weighted mean and variance of the buckets before it. Only
+// increases are anomalies: latency and failure rates dropping is good news.
+// The baseline is recomputed from the rollups on every run, so nothing is
+// lost on restarts; a sustained shift becomes the new baseline over roughly
+// 1/Alpha buckets and its alert resolves.
+type Detector struct {
+	config  Config
+	storage Storage
+}
+
+// NewDetector creates a new anomaly detector
+func NewDetector(config Config, storage Storage) *Detector {
+	if config.Alpha <= 0 || config.Alpha >= 1 {
+		config.Alpha = 0.05
+	}
+	if config.History <= 0 {
+		config.History = 24 * time.Hour
+	}
+	return &Detector{config: config, storage: storage}
+}
+
+// Detect scores every series and maintains their alerts
+func (d *Detector) Detect(ctx context.Context) error {
+	// The last bucket ended at least one bucket ago, so the rollups have
+	// caught up with it
+	end := time.Now().UTC().Truncate(bucket).Add(-bucket)
+
+	series := make([]string, 0, len(storage.AnomalySources))
+	for name := range storage.AnomalySources {
+		series = append(series, name)
+	}
+	sort.Strings(series)
+
+	for _, name := range series {
+		points, err := d.storage.GetAnomalyPoints(ctx, name, end.Add(-d.config.History), end)
+		if err != nil {
+			return err
+		}
+		for _, s := range d.score(name, points, end.Add(-bucket)) {
+			if err := d.alert(ctx, s); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// score returns the score of every target whose last point is the bucket
+// starting at latest, with enough events and history
+func (d *Detector) score(series string, points []storage.AnomalyPoint, latest time.Time) []Score {
+	var scores []Score
+	for i := 0; i < len(points); {
+		j := i
+		for j < len(points) && points[j].Key == points[i].Key {
+			j++
+		}
+		history, last := points[i:j-1], points[j-1]
+		i = j
+
+		if !last.Bucket.Equal(latest) || last.Events < d.config.MinEvents || len(history) < minHistory {
+			continue
+		}
+
+		mean, variance := history[0].Value, 0.0
+		for _, p := range history[1:] {
+			diff := p.Value - mean
+			incr := d.config.Alpha * diff
+			mean += incr
+			variance = (1 - d.config.Alpha) * (variance + diff*incr)
+		}
+		stddev := max(math.Sqrt(variance), minDeviation[series], 0.05*math.Abs(mean))
+
+		scores = append(scores, Score{
+			Series:   series,
+			Key:      last.Key,
+			Bucket:   last.Bucket,
+			Value:    last.Value,
+			Baseline: mean,
+			StdDev:   stddev,
+			Z:        (last.Value - mean) / stddev,
+		})
+	}
+	return scores
+}
+
+// alert fires while a target's z-score is at or above the threshold and
+// resolves once it drops below
+func (d *Detector) alert(ctx context.Context, s Score) error {
+	metricName := fmt.Sprintf("anomaly:%s:%s", s.Series, s.Key)
+	open, err := d.storage.HasOpenAlert(ctx, AlertType, metricName)
+	if err != nil {
+		return err
+	}
+
+	anomalous := s.Z >= d.config.Threshold
+	switch {
+	case anomalous && !open:
+		slog.Warn("anomaly detected",
+			"series", s.Series,
+			"key", s.Key,
+			"value", s.Value,
+			"baseline", s.Baseline,
+			"z", s.Z,
+		)
+		return d.storage.InsertAlert(ctx, storage.AlertRow{
+			Time:           time.Now().UTC(),
+			AlertType:      AlertType,
+			Severity:       "warning",
+			SourceTable:    storage.AnomalySources[s.Series].Table,
+			MetricName:     metricName,
+			Target:         s.Key,
+			ThresholdValue: s.Baseline + d.config.Threshold*s.StdDev,
+			ActualValue:    s.Value,
+			Message: fmt.Sprintf("%s of %s at %.2f in the 5 minutes from %s, baseline %.2f ± %.2f (z = %.1f)",
+				s.Series, s.Key, s.Value, s.Bucket.Format("15:04"), s.Baseline, s.StdDev, s.Z),
+		})
+	case !anomalous && open:
+		return d.storage.ResolveAlerts(ctx, AlertType, metricName)
+	}
+	return nil
+}
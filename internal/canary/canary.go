@@ -0,0 +1,371 @@
+package canary
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/mcbile/product-pulse/internal/model"
+)
+
+// GameTypeCanary tags canary launches so they can be told apart from organic
+// player launches in game_metrics
+const GameTypeCanary = "canary"
+
+// Storage is the subset of storage used by the canary runner
+type Storage interface {
+	InsertGameMetrics(ctx context.Context, metrics []model.GameMetric) error
+}
+
+// Target is a provider demo game launched on every round
+type Target struct {
+	Provider string
+	GameID   string
+	URL      string
+}
+
+// DefaultReadyExpression reports a game as launched once a canvas is on
+// the page or in a same-origin frame, which is where HTML5 game clients
+// render
+const DefaultReadyExpression = `(() => {
+	const hasCanvas = d => !!d && !!d.querySelector('canvas');
+	if (hasCanvas(document)) return true;
+	for (const f of document.querySelectorAll('iframe')) {
+		try { if (hasCanvas(f.contentDocument)) return true; } catch (e) {}
+	}
+	return false;
+})()`
+
+// readyPollInterval is how often the ready expression is evaluated after
+// the page has loaded
+const readyPollInterval = 250 * time.Millisecond
+
+// Config for the canary runner
+type Config struct {
+	Targets []Target
+	Timeout time.Duration
+
+	// BrowserURL is the DevTools endpoint of a headless Chrome the games
+	// are launched in, e.g. http://chrome:9222
+	BrowserURL string
+
+	// ReadyExpression is a JavaScript expression evaluated in the page
+	// after it has loaded; the game counts as launched once it is true.
+	// Empty means DefaultReadyExpression.
+	ReadyExpression string
+}
+
+// Runner launches provider demo games in a headless browser and records
+// the results as GameMetric rows, so provider outages are visible off-peak
+// when organic launch volume is too low for rate-based alerts.
+type Runner struct {
+	config  Config
+	storage Storage
+}
+
+// ParseTargets parses entries in the form provider[/game_id]=demo_url.
+// CANARY_TARGETS separates entries by whitespace, as demo URLs may contain
+// commas.
+func ParseTargets(entries []string) ([]Target, error) {
+	targets := make([]Target, 0, len(entries))
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		name, url, ok := strings.Cut(entry, "=")
+		if !ok || name == "" || url == "" {
+			return nil, fmt.Errorf("invalid canary target %q, expected provider[/game_id]=url", entry)
+		}
+
+		provider, gameID, _ := strings.Cut(name, "/")
+		targets = append(targets, Target{
+			Provider: strings.TrimSpace(provider),
+			GameID:   strings.TrimSpace(gameID),
+			URL:      strings.TrimSpace(url),
+		})
+	}
+	return targets, nil
+}
+
+// NewRunner creates a new canary runner
+func NewRunner(config Config, storage Storage) *Runner {
+	if config.Timeout <= 0 {
+		config.Timeout = 15 * time.Second
+	}
+	if config.ReadyExpression == "" {
+		config.ReadyExpression = DefaultReadyExpression
+	}
+
+	return &Runner{
+		config:  config,
+		storage: storage,
+	}
+}
+
+// RunOnce launches every target and stores the results. A browser that
+// cannot be reached fails the round instead of being recorded against the
+// providers.
+func (r *Runner) RunOnce(ctx context.Context) error {
+	b, err := newBrowser(r.config.BrowserURL)
+	if err != nil {
+		return err
+	}
+
+	metrics := make([]model.GameMetric, 0, len(r.config.Targets))
+	var browserErr error
+	for _, t := range r.config.Targets {
+		metric, err := r.check(ctx, b, t)
+		if err != nil {
+			browserErr = fmt.Errorf("canary browser: %w", err)
+			break
+		}
+		metrics = append(metrics, metric)
+	}
+
+	if len(metrics) > 0 {
+		if err := r.storage.InsertGameMetrics(ctx, metrics); err != nil {
+			return fmt.Errorf("store canary results: %w", err)
+		}
+	}
+	return browserErr
+}
+
+// launch is what was observed while a game loaded
+type launch struct {
+	errorType   string
+	err         error
+	pageLoadMS  float64 // 0 if the load event never fired
+	status      int     // Status of the main document response
+	exceptions  int     // Uncaught JavaScript exceptions
+	failed      int     // Requests that failed, not counting canceled ones
+	firstExcept string
+}
+
+// check launches a single target in a fresh tab. Load time runs from
+// navigation until the ready expression holds, so it covers the game
+// client's scripts and assets, not only the document.
+func (r *Runner) check(ctx context.Context, b *browser, t Target) (model.GameMetric, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.config.Timeout)
+	defer cancel()
+
+	tab, err := b.newTab(ctx)
+	if err != nil {
+		return model.GameMetric{}, err
+	}
+	defer b.closeTab(tab.ID)
+
+	conn, err := b.attach(ctx, tab)
+	if err != nil {
+		return model.GameMetric{}, err
+	}
+	defer conn.Close()
+
+	start := time.Now()
+	l, err := r.launch(ctx, conn, tab.ID, t.URL)
+	if err != nil {
+		return model.GameMetric{}, err
+	}
+	loadTime := float64(time.Since(start).Milliseconds())
+
+	gameType := GameTypeCanary
+	metric := model.GameMetric{
+		Time:       start.UTC(),
+		Provider:   t.Provider,
+		GameType:   &gameType,
+		LoadTimeMS: &loadTime,
+	}
+	if t.GameID != "" {
+		metric.GameID = &t.GameID
+	}
+	metadata := map[string]interface{}{
+		"canary":          true,
+		"check":           "headless_launch",
+		"url":             t.URL,
+		"exceptions":      l.exceptions,
+		"failed_requests": l.failed,
+	}
+	if l.pageLoadMS > 0 {
+		metadata["page_load_ms"] = l.pageLoadMS
+	}
+	if l.status > 0 {
+		metadata["status"] = l.status
+	}
+	if l.firstExcept != "" {
+		metadata["first_exception"] = l.firstExcept
+	}
+	metric.Metadata, _ = json.Marshal(metadata)
+
+	if l.err != nil {
+		msg := l.err.Error()
+		metric.ErrorType = &l.errorType
+		metric.ErrorMessage = &msg
+		slog.Warn("game canary failed",
+			"provider", t.Provider,
+			"game_id", t.GameID,
+			"error_type", l.errorType,
+			"error", l.err,
+		)
+		return metric, nil
+	}
+
+	metric.LaunchSuccess = true
+	return metric, nil
+}
+
+// launch navigates the tab to url and follows DevTools events until the
+// game is ready, fails or runs out of time. The returned error is a
+// browser failure; game failures are reported in launch.
+func (r *Runner) launch(ctx context.Context, conn *cdpConn, frameID, url string) (launch, error) {
+	var l launch
+	for _, domain := range []string{"Page", "Network", "Runtime"} {
+		if _, err := conn.Send(domain+".enable", struct{}{}); err != nil {
+			return l, err
+		}
+	}
+
+	start := time.Now()
+	navigateID, err := conn.Send("Page.navigate", map[string]string{"url": url})
+	if err != nil {
+		return l, err
+	}
+
+	poll := time.NewTicker(readyPollInterval)
+	defer poll.Stop()
+	var evaluateID int64
+	evaluate := func() error {
+		if evaluateID != 0 {
+			return nil // Previous evaluation still running
+		}
+		evaluateID, err = conn.Send("Runtime.evaluate", map[string]interface{}{
+			"expression":    r.config.ReadyExpression,
+			"returnByValue": true,
+		})
+		return err
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			if !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return l, ctx.Err()
+			}
+			if l.pageLoadMS == 0 {
+				l.errorType = "timeout"
+				l.err = fmt.Errorf("page did not load within %s", r.config.Timeout)
+			} else {
+				l.errorType = "launch_timeout"
+				l.err = fmt.Errorf("game not ready within %s", r.config.Timeout)
+			}
+			return l, nil
+
+		case <-poll.C:
+			if l.pageLoadMS > 0 {
+				if err := evaluate(); err != nil {
+					return l, err
+				}
+			}
+
+		case msg, ok := <-conn.Messages:
+			if !ok {
+				return l, conn.ReadErr()
+			}
+
+			switch {
+			case msg.ID == navigateID:
+				var result struct {
+					ErrorText string `json:"errorText"`
+				}
+				json.Unmarshal(msg.Result, &result)
+				if msg.Error != nil {
+					result.ErrorText = msg.Error.Message
+				}
+				if result.ErrorText != "" {
+					l.errorType = "navigation"
+					l.err = fmt.Errorf("navigation failed: %s", result.ErrorText)
+					return l, nil
+				}
+
+			case msg.ID != 0 && msg.ID == evaluateID:
+				evaluateID = 0
+				var result struct {
+					Result struct {
+						Value interface{} `json:"value"`
+					} `json:"result"`
+				}
+				json.Unmarshal(msg.Result, &result)
+				if ready, _ := result.Result.Value.(bool); ready {
+					return l, nil
+				}
+
+			case msg.Method == "Network.responseReceived":
+				var params struct {
+					Type     string `json:"type"`
+					FrameID  string `json:"frameId"`
+					Response struct {
+						Status int `json:"status"`
+					} `json:"response"`
+				}
+				json.Unmarshal(msg.Params, &params)
+				// The main frame of a tab has the tab's ID
+				if params.Type != "Document" || params.FrameID != frameID {
+					continue
+				}
+				l.status = params.Response.Status
+				if l.status >= 400 {
+					l.errorType = "http_status"
+					l.err = fmt.Errorf("demo URL returned %d", l.status)
+					return l, nil
+				}
+
+			case msg.Method == "Page.loadEventFired":
+				if l.pageLoadMS == 0 {
+					l.pageLoadMS = float64(time.Since(start).Milliseconds())
+					if l.pageLoadMS == 0 {
+						l.pageLoadMS = 1
+					}
+				}
+				if err := evaluate(); err != nil {
+					return l, err
+				}
+
+			case msg.Method == "Runtime.exceptionThrown":
+				l.exceptions++
+				if l.firstExcept == "" {
+					var params struct {
+						ExceptionDetails struct {
+							Text      string `json:"text"`
+							Exception struct {
+								Description string `json:"description"`
+							} `json:"exception"`
+						} `json:"exceptionDetails"`
+					}
+					json.Unmarshal(msg.Params, &params)
+					l.firstExcept = params.ExceptionDetails.Exception.Description
+					if l.firstExcept == "" {
+						l.firstExcept = params.ExceptionDetails.Text
+					}
+				}
+
+			case msg.Method == "Network.loadingFailed":
+				var params struct {
+					Canceled bool `json:"canceled"`
+				}
+				json.Unmarshal(msg.Params, &params)
+				if !params.Canceled {
+					l.failed++
+				}
+
+			case msg.Method == "Inspector.targetCrashed":
+				l.errorType = "crash"
+				l.err = errors.New("page crashed")
+				return l, nil
+			}
+		}
+	}
+}
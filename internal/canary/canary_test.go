@@ -0,0 +1,213 @@
+package canary
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mcbile/product-pulse/internal/model"
+)
+
+func TestParseTargetsKeepsCommasInURLs(t *testing.T) {
+	list := "pragmatic/vs20olympgate=https://demo.example.com/open?game=vs20olympgate&langs=en,de\n  evolution=https://evo.example.com/lobby "
+	targets, err := ParseTargets(strings.Fields(list))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Target{
+		{Provider: "pragmatic", GameID: "vs20olympgate", URL: "https://demo.example.com/open?game=vs20olympgate&langs=en,de"},
+		{Provider: "evolution", URL: "https://evo.example.com/lobby"},
+	}
+	if len(targets) != len(want) || targets[0] != want[0] || targets[1] != want[1] {
+		t.Fatalf("got %+v, want %+v", targets, want)
+	}
+
+	if _, err := ParseTargets([]string{"https://demo.example.com/open"}); err == nil {
+		t.Error("entry without provider accepted")
+	}
+}
+
+type memoryStorage struct {
+	metrics []model.GameMetric
+}
+
+func (m *memoryStorage) InsertGameMetrics(ctx context.Context, metrics []model.GameMetric) error {
+	m.metrics = append(m.metrics, metrics...)
+	return nil
+}
+
+// fakeBrowser serves the DevTools endpoints a headless Chrome does. Pages
+// behave by path: /game loads and renders a canvas on the second check,
+// /down answers 502, /slow loads but never renders and any other path
+// fails to resolve.
+type fakeBrowser struct {
+	t      *testing.T
+	mu     sync.Mutex
+	tabs   map[string]string // Tab ID to the page it was navigated to
+	closed []string
+}
+
+func (f *fakeBrowser) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Host != devtoolsHost {
+		http.Error(w, "Host header is specified and is not an IP address or localhost.", http.StatusInternalServerError)
+		return
+	}
+	switch {
+	case r.Method == http.MethodPut && r.URL.Path == "/json/new":
+		f.mu.Lock()
+		id := fmt.Sprintf("TAB%d", len(f.tabs)+1)
+		f.tabs[id] = ""
+		f.mu.Unlock()
+		json.NewEncoder(w).Encode(devtoolsTarget{ID: id, WebSocketDebuggerURL: "ws://localhost/devtools/page/" + id})
+	case strings.HasPrefix(r.URL.Path, "/json/close/"):
+		f.mu.Lock()
+		f.closed = append(f.closed, strings.TrimPrefix(r.URL.Path, "/json/close/"))
+		f.mu.Unlock()
+		w.Write([]byte("Target is closing"))
+	case strings.HasPrefix(r.URL.Path, "/devtools/page/"):
+		f.serveTab(w, r, strings.TrimPrefix(r.URL.Path, "/devtools/page/"))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (f *fakeBrowser) serveTab(w http.ResponseWriter, r *http.Request, tabID string) {
+	conn, rw, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		f.t.Error(err)
+		return
+	}
+	defer conn.Close()
+	rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + wsAccept(r.Header.Get("Sec-WebSocket-Key")) + "\r\n\r\n")
+	rw.Flush()
+
+	ws := &wsConn{conn: conn, br: rw.Reader}
+	send := func(v interface{}) {
+		data, _ := json.Marshal(v)
+		ws.WriteText(data)
+	}
+	event := func(method string, params interface{}) {
+		send(map[string]interface{}{"method": method, "params": params})
+	}
+
+	var path string
+	evaluations := 0
+	for {
+		data, err := ws.ReadMessage()
+		if err != nil {
+			return
+		}
+		var cmd struct {
+			ID     int64             `json:"id"`
+			Method string            `json:"method"`
+			Params map[string]string `json:"params"`
+		}
+		json.Unmarshal(data, &cmd)
+
+		switch cmd.Method {
+		case "Page.navigate":
+			u, _ := url.Parse(cmd.Params["url"])
+			path = u.Path
+			f.mu.Lock()
+			f.tabs[tabID] = path
+			f.mu.Unlock()
+			if path != "/game" && path != "/down" && path != "/slow" {
+				send(map[string]interface{}{"id": cmd.ID, "result": map[string]string{"frameId": tabID, "errorText": "net::ERR_NAME_NOT_RESOLVED"}})
+				continue
+			}
+			send(map[string]interface{}{"id": cmd.ID, "result": map[string]string{"frameId": tabID}})
+			status := 200
+			if path == "/down" {
+				status = 502
+			}
+			event("Network.responseReceived", map[string]interface{}{"type": "Document", "frameId": tabID, "response": map[string]int{"status": status}})
+			event("Network.loadingFailed", map[string]interface{}{"canceled": true})
+			event("Runtime.exceptionThrown", map[string]interface{}{"exceptionDetails": map[string]interface{}{
+				"text": "Uncaught", "exception": map[string]string{"description": "TypeError: x is undefined"},
+			}})
+			event("Page.loadEventFired", map[string]float64{"timestamp": 1})
+		case "Runtime.evaluate":
+			evaluations++
+			ready := path == "/game" && evaluations > 1
+			send(map[string]interface{}{"id": cmd.ID, "result": map[string]interface{}{"result": map[string]interface{}{"type": "boolean", "value": ready}}})
+		default:
+			send(map[string]interface{}{"id": cmd.ID, "result": struct{}{}})
+		}
+	}
+}
+
+func TestRunnerLaunchesGamesInBrowser(t *testing.T) {
+	fake := &fakeBrowser{t: t, tabs: map[string]string{}}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	store := &memoryStorage{}
+	r := NewRunner(Config{
+		Targets: []Target{
+			{Provider: "up", GameID: "g1", URL: "https://demo.example.com/game"},
+			{Provider: "down", URL: "https://demo.example.com/down"},
+			{Provider: "slow", URL: "https://demo.example.com/slow"},
+			{Provider: "dns", URL: "https://demo.invalid/"},
+		},
+		Timeout:    time.Second,
+		BrowserURL: srv.URL,
+	}, store)
+	if err := r.RunOnce(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(store.metrics) != 4 {
+		t.Fatalf("stored %d metrics, want 4", len(store.metrics))
+	}
+	up := store.metrics[0]
+	if !up.LaunchSuccess || up.ErrorType != nil || up.LoadTimeMS == nil || *up.GameType != GameTypeCanary {
+		t.Errorf("rendered game recorded as failed: %+v", up)
+	}
+	var metadata map[string]interface{}
+	json.Unmarshal(up.Metadata, &metadata)
+	if metadata["check"] != "headless_launch" || metadata["exceptions"] != 1.0 || metadata["failed_requests"] != 0.0 ||
+		metadata["first_exception"] != "TypeError: x is undefined" || metadata["page_load_ms"] == nil {
+		t.Errorf("metadata %s", up.Metadata)
+	}
+
+	for i, want := range []string{"", "http_status", "launch_timeout", "navigation"} {
+		m := store.metrics[i]
+		if want == "" {
+			continue
+		}
+		if m.LaunchSuccess || m.ErrorType == nil || *m.ErrorType != want {
+			t.Errorf("%s: got %+v, want %s failure", m.Provider, m, want)
+		}
+	}
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if len(fake.closed) != 4 {
+		t.Errorf("closed tabs %v, want all 4", fake.closed)
+	}
+}
+
+func TestRunnerFailsWithoutBrowser(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	srv.Close()
+
+	store := &memoryStorage{}
+	r := NewRunner(Config{
+		Targets:    []Target{{Provider: "up", URL: "https://demo.example.com/game"}},
+		BrowserURL: srv.URL,
+	}, store)
+	if err := r.RunOnce(context.Background()); err == nil {
+		t.Error("round without a browser succeeded")
+	}
+	if len(store.metrics) != 0 {
+		t.Errorf("browser outage recorded against providers: %+v", store.metrics)
+	}
+}
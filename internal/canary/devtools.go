@@ -0,0 +1,205 @@
+package canary
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// ============================================
+// CHROME DEVTOOLS PROTOCOL
+// ============================================
+
+// devtoolsHost is sent as the Host of DevTools requests: Chrome only
+// answers requests addressed to an IP or localhost, which a browser in
+// another container (http://chrome:9222) is not.
+const devtoolsHost = "localhost"
+
+// browser is the DevTools HTTP endpoint of a headless Chrome, e.g. one
+// started with --headless --remote-debugging-port=9222
+type browser struct {
+	endpoint   *url.URL
+	httpClient *http.Client
+}
+
+// devtoolsTarget is a browser tab
+type devtoolsTarget struct {
+	ID                   string `json:"id"`
+	WebSocketDebuggerURL string `json:"webSocketDebuggerUrl"`
+}
+
+func newBrowser(endpoint string) (*browser, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid browser URL: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return nil, fmt.Errorf("invalid browser URL %q, expected http://host:port", endpoint)
+	}
+	return &browser{
+		endpoint:   u,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+// newTab opens a blank tab
+func (b *browser) newTab(ctx context.Context) (devtoolsTarget, error) {
+	var target devtoolsTarget
+	resp, err := b.request(ctx, http.MethodPut, "/json/new?about:blank")
+	if err != nil {
+		return target, err
+	}
+	defer resp.Body.Close()
+
+	if err := json.NewDecoder(resp.Body).Decode(&target); err != nil {
+		return target, fmt.Errorf("decode new tab: %w", err)
+	}
+	if target.ID == "" || target.WebSocketDebuggerURL == "" {
+		return target, errors.New("browser returned a tab without a debugger URL")
+	}
+	return target, nil
+}
+
+// closeTab closes a tab opened by newTab
+func (b *browser) closeTab(id string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	resp, err := b.request(ctx, http.MethodGet, "/json/close/"+url.PathEscape(id))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (b *browser) request(ctx context.Context, method, path string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(b.endpoint.String(), "/")+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Host = devtoolsHost
+
+	resp, err := b.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("browser unreachable: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		resp.Body.Close()
+		return nil, fmt.Errorf("browser %s %s returned %d: %s", method, path, resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return resp, nil
+}
+
+// attach connects to the tab's debugger. The URL the browser reports names
+// the host it was asked on, so it is pointed back at the configured
+// endpoint.
+func (b *browser) attach(ctx context.Context, target devtoolsTarget) (*cdpConn, error) {
+	u, err := url.Parse(target.WebSocketDebuggerURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid debugger URL: %w", err)
+	}
+	u.Scheme = "ws"
+	if b.endpoint.Scheme == "https" {
+		u.Scheme = "wss"
+	}
+	u.Host = b.endpoint.Host
+
+	ws, err := dialWebSocket(ctx, u.String(), devtoolsHost)
+	if err != nil {
+		return nil, fmt.Errorf("attach to tab: %w", err)
+	}
+	return newCDPConn(ws), nil
+}
+
+// cdpMessage is a command response (ID set) or an event (Method set)
+type cdpMessage struct {
+	ID     int64           `json:"id,omitempty"`
+	Method string          `json:"method,omitempty"`
+	Params json.RawMessage `json:"params,omitempty"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+}
+
+// cdpConn is a DevTools session with a single tab. Messages are read by a
+// goroutine into Messages, so callers can wait on them alongside timers.
+type cdpConn struct {
+	ws     *wsConn
+	lastID atomic.Int64
+
+	Messages chan cdpMessage
+	readErr  error // Set before Messages is closed
+	done     chan struct{}
+}
+
+func newCDPConn(ws *wsConn) *cdpConn {
+	c := &cdpConn{
+		ws:       ws,
+		Messages: make(chan cdpMessage, 64),
+		done:     make(chan struct{}),
+	}
+	go c.readLoop()
+	return c
+}
+
+func (c *cdpConn) readLoop() {
+	defer close(c.Messages)
+	for {
+		data, err := c.ws.ReadMessage()
+		if err != nil {
+			c.readErr = err
+			return
+		}
+		var msg cdpMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			continue
+		}
+		select {
+		case c.Messages <- msg:
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// ReadErr returns why Messages was closed
+func (c *cdpConn) ReadErr() error {
+	if c.readErr == nil {
+		return errWSClosed
+	}
+	return c.readErr
+}
+
+// Send issues a command and returns its ID, which the response carries
+func (c *cdpConn) Send(method string, params interface{}) (int64, error) {
+	id := c.lastID.Add(1)
+	data, err := json.Marshal(map[string]interface{}{
+		"id":     id,
+		"method": method,
+		"params": params,
+	})
+	if err != nil {
+		return 0, err
+	}
+	if err := c.ws.WriteText(data); err != nil {
+		return 0, fmt.Errorf("send %s: %w", method, err)
+	}
+	return id, nil
+}
+
+// Close ends the session
+func (c *cdpConn) Close() error {
+	close(c.done)
+	return c.ws.Close()
+}
@@ -0,0 +1,246 @@
+package canary
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// ============================================
+// WEBSOCKET CLIENT
+// ============================================
+
+// The DevTools protocol runs over a WebSocket. Only what it needs is
+// implemented: text messages, no extensions, no Origin header (browsers
+// refuse DevTools connections from origins they were not started with).
+
+const (
+	wsOpContinuation = 0x0
+	wsOpText         = 0x1
+	wsOpBinary       = 0x2
+	wsOpClose        = 0x8
+	wsOpPing         = 0x9
+	wsOpPong         = 0xA
+
+	// maxWSMessage bounds a message read, so a runaway page cannot exhaust
+	// the collector's memory through its DevTools events
+	maxWSMessage = 32 << 20
+
+	wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+)
+
+var errWSClosed = errors.New("websocket closed by peer")
+
+// wsConn is a client WebSocket connection. One goroutine may read while
+// another writes: every frame goes out in a single Write.
+type wsConn struct {
+	conn net.Conn
+	br   *bufio.Reader
+}
+
+// dialWebSocket opens a ws:// or wss:// URL. host, if not empty, is sent
+// as the Host header instead of the URL's.
+func dialWebSocket(ctx context.Context, rawURL, host string) (*wsConn, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		port := "80"
+		if u.Scheme == "wss" {
+			port = "443"
+		}
+		addr = net.JoinHostPort(u.Hostname(), port)
+	}
+
+	var conn net.Conn
+	switch u.Scheme {
+	case "ws":
+		var d net.Dialer
+		conn, err = d.DialContext(ctx, "tcp", addr)
+	case "wss":
+		d := tls.Dialer{Config: &tls.Config{ServerName: u.Hostname()}}
+		conn, err = d.DialContext(ctx, "tcp", addr)
+	default:
+		return nil, fmt.Errorf("unsupported websocket scheme %q", u.Scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	nonce := make([]byte, 16)
+	rand.Read(nonce)
+	key := base64.StdEncoding.EncodeToString(nonce)
+	if host == "" {
+		host = u.Host
+	}
+	req := "GET " + u.RequestURI() + " HTTP/1.1\r\n" +
+		"Host: " + host + "\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Key: " + key + "\r\n" +
+		"Sec-WebSocket-Version: 13\r\n\r\n"
+	if _, err := io.WriteString(conn, req); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("websocket handshake: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		conn.Close()
+		return nil, fmt.Errorf("websocket handshake: status %d", resp.StatusCode)
+	}
+	if resp.Header.Get("Sec-WebSocket-Accept") != wsAccept(key) {
+		conn.Close()
+		return nil, errors.New("websocket handshake: invalid Sec-WebSocket-Accept")
+	}
+	// The context bounds the handshake only; Close ends the connection
+	conn.SetDeadline(time.Time{})
+	return &wsConn{conn: conn, br: br}, nil
+}
+
+// wsAccept is the Sec-WebSocket-Accept answering key
+func wsAccept(key string) string {
+	h := sha1.Sum([]byte(key + wsGUID))
+	return base64.StdEncoding.EncodeToString(h[:])
+}
+
+// WriteText sends a text message
+func (c *wsConn) WriteText(p []byte) error {
+	return c.writeFrame(wsOpText, p)
+}
+
+// writeFrame sends a single, masked frame, as clients must
+func (c *wsConn) writeFrame(op byte, p []byte) error {
+	header := make([]byte, 2, 14)
+	header[0] = 0x80 | op
+	switch n := len(p); {
+	case n < 126:
+		header[1] = 0x80 | byte(n)
+	case n <= 0xFFFF:
+		header[1] = 0x80 | 126
+		header = binary.BigEndian.AppendUint16(header, uint16(n))
+	default:
+		header[1] = 0x80 | 127
+		header = binary.BigEndian.AppendUint64(header, uint64(n))
+	}
+	var mask [4]byte
+	rand.Read(mask[:])
+	header = append(header, mask[:]...)
+
+	frame := make([]byte, len(header)+len(p))
+	copy(frame, header)
+	for i, b := range p {
+		frame[len(header)+i] = b ^ mask[i%4]
+	}
+	_, err := c.conn.Write(frame)
+	return err
+}
+
+// ReadMessage returns the next text or binary message, answering pings on
+// the way
+func (c *wsConn) ReadMessage() ([]byte, error) {
+	var msg []byte
+	for {
+		fin, op, payload, err := c.readFrame()
+		if err != nil {
+			return nil, err
+		}
+		switch op {
+		case wsOpPing:
+			if err := c.writeFrame(wsOpPong, payload); err != nil {
+				return nil, err
+			}
+			continue
+		case wsOpPong:
+			continue
+		case wsOpClose:
+			return nil, errWSClosed
+		case wsOpText, wsOpBinary, wsOpContinuation:
+		default:
+			return nil, fmt.Errorf("unknown websocket opcode %d", op)
+		}
+
+		if len(msg)+len(payload) > maxWSMessage {
+			return nil, fmt.Errorf("websocket message over %d bytes", maxWSMessage)
+		}
+		msg = append(msg, payload...)
+		if fin {
+			return msg, nil
+		}
+	}
+}
+
+func (c *wsConn) readFrame() (fin bool, op byte, payload []byte, err error) {
+	var head [2]byte
+	if _, err = io.ReadFull(c.br, head[:]); err != nil {
+		return
+	}
+	fin = head[0]&0x80 != 0
+	op = head[0] & 0x0F
+	masked := head[1]&0x80 != 0
+	n := uint64(head[1] & 0x7F)
+	switch n {
+	case 126:
+		var ext [2]byte
+		if _, err = io.ReadFull(c.br, ext[:]); err != nil {
+			return
+		}
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err = io.ReadFull(c.br, ext[:]); err != nil {
+			return
+		}
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+	if n > maxWSMessage {
+		err = fmt.Errorf("websocket frame over %d bytes", maxWSMessage)
+		return
+	}
+
+	var mask [4]byte
+	if masked {
+		if _, err = io.ReadFull(c.br, mask[:]); err != nil {
+			return
+		}
+	}
+	payload = make([]byte, n)
+	if _, err = io.ReadFull(c.br, payload); err != nil {
+		return
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	return
+}
+
+// Close sends a close frame and closes the connection
+func (c *wsConn) Close() error {
+	c.conn.SetWriteDeadline(time.Now().Add(time.Second))
+	c.writeFrame(wsOpClose, nil)
+	return c.conn.Close()
+}
@@ -0,0 +1,123 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/mcbile/product-pulse/internal/model"
+)
+
+// BackendStorage is the subset of storage used by the backend metric
+// collectors
+type BackendStorage interface {
+	CopyAPIMetrics(ctx context.Context, metrics []model.APIMetric) error
+	InsertAPIMetrics(ctx context.Context, metrics []model.APIMetric) error
+	CopyPSPMetrics(ctx context.Context, metrics []model.PSPMetric) error
+	InsertPSPMetrics(ctx context.Context, metrics []model.PSPMetric) error
+	CopyGameMetrics(ctx context.Context, metrics []model.GameMetric) error
+	InsertGameMetrics(ctx context.Context, metrics []model.GameMetric) error
+	CopyWebSocketMetrics(ctx context.Context, metrics []model.WebSocketMetric) error
+	InsertWebSocketMetrics(ctx context.Context, metrics []model.WebSocketMetric) error
+}
+
+// Backend groups the collectors for metrics sent by backend services, which
+// get the same buffering, batching and COPY path as frontend events
+type Backend struct {
+	API  *Collector[model.APIMetric]
+	PSP  *Collector[model.PSPMetric]
+	Game *Collector[model.GameMetric]
+	WS   *Collector[model.WebSocketMetric]
+}
+
+// NewBackend creates one collector per backend metric type
+func NewBackend(config BatchConfig, storage BackendStorage) *Backend {
+	b := &Backend{
+		API: New(config, Sink[model.APIMetric]{
+			Name:   "api",
+			Copy:   storage.CopyAPIMetrics,
+			Insert: storage.InsertAPIMetrics,
+		}),
+		PSP: New(config, Sink[model.PSPMetric]{
+			Name:   "psp",
+			Copy:   storage.CopyPSPMetrics,
+			Insert: storage.InsertPSPMetrics,
+		}),
+		Game: New(config, Sink[model.GameMetric]{
+			Name:   "game",
+			Copy:   storage.CopyGameMetrics,
+			Insert: storage.InsertGameMetrics,
+		}),
+		WS: New(config, Sink[model.WebSocketMetric]{
+			Name:   "ws",
+			Copy:   storage.CopyWebSocketMetrics,
+			Insert: storage.InsertWebSocketMetrics,
+		}),
+	}
+
+	if config.SessionAffinity {
+		b.API.RouteBy(func(m model.APIMetric) string { return routingKey(m.PlayerID) }, nil)
+		b.PSP.RouteBy(func(m model.PSPMetric) string { return routingKey(m.PlayerID) }, nil)
+		b.Game.RouteBy(func(m model.GameMetric) string { return routingKey(m.SessionID, m.PlayerID) }, nil)
+		b.WS.RouteBy(func(m model.WebSocketMetric) string { return routingKey(m.PlayerID) }, nil)
+	}
+	return b
+}
+
+// DiscardBy drops the metrics for which fn, called with their site and
+// metric type (api, psp, game, ws), returns true. It must be called before
+// Start.
+func (b *Backend) DiscardBy(fn func(site, metricType string) bool) {
+	b.API.DiscardBy(func(m model.APIMetric) bool { return fn(m.SiteID, "api") })
+	b.PSP.DiscardBy(func(m model.PSPMetric) bool { return fn(m.SiteID, "psp") })
+	b.Game.DiscardBy(func(m model.GameMetric) bool { return fn(m.SiteID, "game") })
+	b.WS.DiscardBy(func(m model.WebSocketMetric) bool { return fn(m.SiteID, "ws") })
+}
+
+// Start starts all backend collectors
+func (b *Backend) Start(ctx context.Context) {
+	b.API.Start(ctx)
+	b.PSP.Start(ctx)
+	b.Game.Start(ctx)
+	b.WS.Start(ctx)
+}
+
+// Shutdown flushes and stops all backend collectors
+func (b *Backend) Shutdown() {
+	b.Drain(context.Background())
+}
+
+// Drain flushes and stops all backend collectors, giving up when ctx ends
+func (b *Backend) Drain(ctx context.Context) error {
+	return DrainAll(ctx, b.API, b.PSP, b.Game, b.WS)
+}
+
+// Drainer is a collector that flushes its queue before stopping
+type Drainer interface {
+	Drain(ctx context.Context) error
+}
+
+// DrainAll drains collectors concurrently and returns their errors joined
+func DrainAll(ctx context.Context, drainers ...Drainer) error {
+	errs := make([]error, len(drainers))
+	var wg sync.WaitGroup
+	for i, d := range drainers {
+		wg.Add(1)
+		go func(i int, d Drainer) {
+			defer wg.Done()
+			errs[i] = d.Drain(ctx)
+		}(i, d)
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// GetStats returns statistics per metric type
+func (b *Backend) GetStats() map[string]model.CollectorStats {
+	return map[string]model.CollectorStats{
+		"api":  b.API.GetStats(),
+		"psp":  b.PSP.GetStats(),
+		"game": b.Game.GetStats(),
+		"ws":   b.WS.GetStats(),
+	}
+}
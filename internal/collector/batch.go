@@ -2,19 +2,63 @@ package collector
 
 import (
 	"context"
+	"errors"
 	"log/slog"
+	"math/rand"
+	"path/filepath"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/mcbile/product-pulse/internal/model"
 	"github.com/mcbile/product-pulse/internal/storage"
+	"github.com/mcbile/product-pulse/internal/trace"
+	"github.com/mcbile/product-pulse/pkg/clock"
 )
 
+// ErrQueueFull is reported when an event cannot be queued for flushing
+var ErrQueueFull = errors.New("collector queue full")
+
 type BatchConfig struct {
 	BatchSize     int
 	FlushInterval time.Duration
 	Workers       int
+
+	// Overflow to disk when the queue is full; empty SpillDir disables it
+	SpillDir      string
+	SpillMaxBytes int64
+	SpillKey      []byte // AES-256 key for spill and WAL records, nil stores them in plain text
+
+	// Write-ahead log of queued events, replayed after a crash; empty
+	// WALDir disables it. Events beyond WALMaxBytes (0 for no limit) are
+	// queued without logging. With WALSyncInterval the WAL is also synced
+	// to disk periodically, otherwise only the OS page cache holds it.
+	WALDir          string
+	WALMaxBytes     int64
+	WALSyncInterval time.Duration
+
+	// Flush retries with exponential backoff before events count as failed
+	RetryAttempts   int           // Total attempts per flush, including the first
+	RetryBackoff    time.Duration // Delay before the first retry, doubled each time
+	RetryMaxBackoff time.Duration
+	RetryJitter     float64 // Fraction of the delay randomized, 0-1
+
+	// Backpressure: ingest endpoints reject requests with 429 while the
+	// queue is above HighWatermark, instead of accepting and dropping
+	HighWatermark float64       // Fraction of queue capacity, 0 disables
+	RetryAfter    time.Duration // Delay suggested to rejected clients
+
+	// Events with an ID already accepted within this window are dropped as
+	// client retries; 0 disables deduplication
+	DedupeWindow time.Duration
+
+	// Route events of one session (or player) to the same worker, see
+	// RouteBy
+	SessionAffinity bool
+
+	// Time source for flush intervals, retry backoff and queue times; nil
+	// uses the system clock
+	Clock clock.Clock
 }
 
 type Storage interface {
@@ -22,12 +66,43 @@ type Storage interface {
 	CopyFrontendMetrics(ctx context.Context, events []model.EnrichedEvent) error
 }
 
-type BatchCollector struct {
-	config  BatchConfig
-	storage *storage.Postgres
+// Sink writes batches of one item type. Copy is the fast path, Insert the
+// fallback used when COPY fails.
+type Sink[T any] struct {
+	Name   string // Used in logs
+	Copy   func(ctx context.Context, items []T) error
+	Insert func(ctx context.Context, items []T) error
+}
+
+// Collector buffers items in a queue and writes them in batches from a pool
+// of workers
+type Collector[T any] struct {
+	config BatchConfig
+	sink   Sink[T]
 
 	// Event queue
-	eventCh chan model.EnrichedEvent
+	eventCh chan queuedEvent[T]
+
+	// Per worker queues for events routed by key, nil unless RouteBy
+	workerCh []chan queuedEvent[T]
+	ring     Ring
+	routeKey func(item T) string
+
+	// On-disk overflow for eventCh, nil if disabled
+	spill *spillQueue[T]
+
+	// Write-ahead log of eventCh, nil if disabled
+	wal *writeAheadLog
+
+	// Called with every batch that was persisted
+	onFlush func(items []T)
+
+	// Recently accepted event IDs, nil if deduplication is disabled
+	dedupe  *dedupeWindow
+	eventID func(item T) string
+
+	// Reports events to drop instead of queueing, nil if none are
+	discard func(item T) bool
 
 	// Stats
 	stats Stats
@@ -37,6 +112,26 @@ type BatchCollector struct {
 	shutdown chan struct{}
 }
 
+// BatchCollector collects frontend events
+type BatchCollector = Collector[model.EnrichedEvent]
+
+// queuedEvent pairs an event with an optional callback invoked once the
+// batch containing it has been written (or has failed permanently), and
+// the ID of the request batch it arrived in
+type queuedEvent[T any] struct {
+	event   T
+	ack     func(error)
+	batchID string
+	queued  time.Time
+	walSeq  uint64 // WAL segment holding the event, 0 if not logged
+}
+
+// batchTiming tracks the events of one request batch within a flush
+type batchTiming struct {
+	events int
+	queued time.Time // Oldest event
+}
+
 type Stats struct {
 	EventsReceived   atomic.Int64
 	EventsProcessed  atomic.Int64
@@ -44,91 +139,305 @@ type Stats struct {
 	BatchesProcessed atomic.Int64
 	TotalFlushTimeNs atomic.Int64
 	TotalBatchSize   atomic.Int64
+	EventsSpilled    atomic.Int64
+	FlushRetries     atomic.Int64
+	Throttled        atomic.Int64
+	WALReplayed      atomic.Int64
+	WALSkipped       atomic.Int64
+	Deduplicated     atomic.Int64
+	Discarded        atomic.Int64
+	IngestLagNs      atomic.Int64 // Queue wait of the oldest event in the last flush
 }
 
-func NewBatchCollector(config BatchConfig, storage *storage.Postgres) *BatchCollector {
-	return &BatchCollector{
+// New creates a collector writing to sink. Spill and WAL segments are kept
+// in subdirectories of config.SpillDir and config.WALDir named after the
+// sink.
+func New[T any](config BatchConfig, sink Sink[T]) *Collector[T] {
+	if config.RetryAttempts < 1 {
+		config.RetryAttempts = 1
+	}
+	if config.RetryBackoff <= 0 {
+		config.RetryBackoff = 500 * time.Millisecond
+	}
+	if config.RetryMaxBackoff < config.RetryBackoff {
+		config.RetryMaxBackoff = config.RetryBackoff
+	}
+	if config.RetryAfter <= 0 {
+		config.RetryAfter = time.Second
+	}
+	config.Clock = clock.OrReal(config.Clock)
+
+	c := &Collector[T]{
 		config:   config,
-		storage:  storage,
-		eventCh:  make(chan model.EnrichedEvent, config.BatchSize*10),
+		sink:     sink,
+		eventCh:  make(chan queuedEvent[T], config.BatchSize*10),
 		shutdown: make(chan struct{}),
 	}
+
+	if config.SpillDir != "" {
+		spill, err := openSpillQueue[T](filepath.Join(config.SpillDir, sink.Name), config.SpillMaxBytes, config.SpillKey)
+		if err != nil {
+			slog.Error("spill queue disabled", "collector", sink.Name, "error", err)
+		} else {
+			c.spill = spill
+		}
+	}
+
+	if config.WALDir != "" {
+		wal, err := openWAL(filepath.Join(config.WALDir, sink.Name), config.SpillKey, config.WALMaxBytes)
+		if err != nil {
+			slog.Error("wal disabled", "collector", sink.Name, "error", err)
+		} else {
+			c.wal = wal
+		}
+	}
+
+	return c
+}
+
+// NewBatchCollector creates the frontend event collector
+func NewBatchCollector(config BatchConfig, storage Storage) *BatchCollector {
+	c := New(config, Sink[model.EnrichedEvent]{
+		Name:   "frontend",
+		Copy:   storage.CopyFrontendMetrics,
+		Insert: storage.InsertFrontendMetrics,
+	})
+	c.DedupeBy(frontendEventID)
+	if config.SessionAffinity {
+		c.RouteBy(func(e model.EnrichedEvent) string {
+			if e.SessionID != "" {
+				return e.SessionID
+			}
+			return routingKey(e.PlayerID)
+		}, nil)
+	}
+	return c
+}
+
+// frontendEventID identifies an event for deduplication. SDKs generate
+// IDs per site, so IDs of different sites may collide.
+func frontendEventID(e model.EnrichedEvent) string {
+	if e.EventID == nil || *e.EventID == "" {
+		return ""
+	}
+	return e.SiteID + "/" + *e.EventID
+}
+
+// OnFlush registers fn to be called with every batch that was persisted.
+// It must be called before Start.
+func (c *Collector[T]) OnFlush(fn func(items []T)) {
+	c.onFlush = fn
+}
+
+// DedupeBy drops events whose ID, as returned by fn, was already accepted
+// within config.DedupeWindow. Events with an empty ID are never dropped.
+// It must be called before Start.
+func (c *Collector[T]) DedupeBy(fn func(item T) string) {
+	if c.config.DedupeWindow <= 0 {
+		return
+	}
+	c.dedupe = newDedupeWindow(c.config.DedupeWindow, c.config.Clock)
+	c.eventID = fn
 }
 
-func (c *BatchCollector) Start(ctx context.Context) {
+// DiscardBy drops events for which fn returns true: they are accepted,
+// acknowledged and never written. It must be called before Start.
+func (c *Collector[T]) DiscardBy(fn func(item T) bool) {
+	c.discard = fn
+}
+
+// RouteBy sends events with the same key, as returned by fn, to the same
+// worker, so all events of a session are flushed by one worker (a
+// prerequisite for per-session aggregation). Keys are assigned to workers
+// by ring, a HashRing if nil. Events with an empty key go to any worker.
+// It must be called before Start.
+func (c *Collector[T]) RouteBy(fn func(item T) string, ring Ring) {
+	workers := max(c.config.Workers, 1)
+	if ring == nil {
+		ring = NewHashRing(workers)
+	} else {
+		ring.Resize(workers)
+	}
+	c.ring = ring
+	c.routeKey = fn
+
+	// Each worker queue gets its share of the shared queue's capacity
+	size := max(cap(c.eventCh)/workers, c.config.BatchSize)
+	c.workerCh = make([]chan queuedEvent[T], workers)
+	for i := range c.workerCh {
+		c.workerCh[i] = make(chan queuedEvent[T], size)
+	}
+}
+
+// queue returns the queue for event: its worker's queue if routed by key
+func (c *Collector[T]) queue(event T) chan queuedEvent[T] {
+	if c.routeKey != nil {
+		if key := c.routeKey(event); key != "" {
+			return c.workerCh[c.ring.Node(key)]
+		}
+	}
+	return c.eventCh
+}
+
+func (c *Collector[T]) Start(ctx context.Context) {
 	// Start worker goroutines
 	for i := 0; i < c.config.Workers; i++ {
 		c.wg.Add(1)
 		go c.worker(ctx, i)
 	}
+	if c.spill != nil {
+		c.wg.Add(1)
+		go c.drainSpill(ctx)
+	}
+	if c.wal != nil {
+		c.wg.Add(1)
+		go c.replayWAL(ctx)
+	}
+	if c.wal != nil && c.config.WALSyncInterval > 0 {
+		c.wg.Add(1)
+		go c.syncWAL(ctx)
+	}
 
 	slog.Info("batch collector started",
+		"collector", c.sink.Name,
 		"workers", c.config.Workers,
 		"batch_size", c.config.BatchSize,
 		"flush_interval", c.config.FlushInterval,
+		"spill", c.spill != nil,
+		"wal", c.wal != nil,
 	)
 }
 
-func (c *BatchCollector) worker(ctx context.Context, id int) {
+func (c *Collector[T]) worker(ctx context.Context, id int) {
 	defer c.wg.Done()
 
-	batch := make([]model.EnrichedEvent, 0, c.config.BatchSize)
-	ticker := time.NewTicker(c.config.FlushInterval)
+	batch := make([]T, 0, c.config.BatchSize)
+	var acks []func(error)
+	logged := make(map[uint64]int) // Events per WAL segment
+	var oldest time.Time           // Queue time of the oldest event in batch
+	ticker := c.config.Clock.NewTicker(c.config.FlushInterval)
 	defer ticker.Stop()
 
+	// Events routed to this worker, nil (never ready) without RouteBy
+	var own chan queuedEvent[T]
+	if c.workerCh != nil {
+		own = c.workerCh[id]
+	}
+
+	// Per request batch timing, only kept when debug logging is on
+	tracing := trace.Enabled(ctx)
+	batches := make(map[string]*batchTiming)
+
+	add := func(qe queuedEvent[T]) {
+		batch = append(batch, qe.event)
+		if oldest.IsZero() || qe.queued.Before(oldest) {
+			oldest = qe.queued
+		}
+		if qe.ack != nil {
+			acks = append(acks, qe.ack)
+		}
+		if qe.walSeq != 0 {
+			logged[qe.walSeq]++
+		}
+		if tracing && qe.batchID != "" {
+			bt, ok := batches[qe.batchID]
+			if !ok {
+				bt = &batchTiming{queued: qe.queued}
+				batches[qe.batchID] = bt
+			}
+			bt.events++
+		}
+	}
+
 	flush := func() {
 		if len(batch) == 0 {
 			return
 		}
 
-		start := time.Now()
-		toFlush := make([]model.EnrichedEvent, len(batch))
+		start := c.config.Clock.Now()
+		if !oldest.IsZero() {
+			c.stats.IngestLagNs.Store(start.Sub(oldest).Nanoseconds())
+			oldest = time.Time{}
+		}
+		toFlush := make([]T, len(batch))
 		copy(toFlush, batch)
 		batch = batch[:0]
-
-		// Use COPY for better performance
-		if err := c.storage.CopyFrontendMetrics(ctx, toFlush); err != nil {
-			slog.Error("flush failed",
-				"worker", id,
-				"batch_size", len(toFlush),
-				"error", err,
-			)
-			c.stats.EventsFailed.Add(int64(len(toFlush)))
-
-			// Fallback to INSERT on COPY failure
-			if err := c.storage.InsertFrontendMetrics(ctx, toFlush); err != nil {
-				slog.Error("insert fallback failed",
-					"worker", id,
-					"error", err,
-				)
-			} else {
-				c.stats.EventsProcessed.Add(int64(len(toFlush)))
-				c.stats.EventsFailed.Add(-int64(len(toFlush))) // Correct the failed count
+		toAck := acks
+		acks = nil
+		toRelease := logged
+		logged = make(map[uint64]int)
+
+		flushCtx := ctx
+		var flushID string
+		traced := batches
+		if tracing {
+			batches = make(map[string]*batchTiming)
+			flushID = trace.NewID()
+			ids := make([]string, 0, len(traced))
+			for batchID := range traced {
+				ids = append(ids, batchID)
 			}
-		} else {
-			c.stats.EventsProcessed.Add(int64(len(toFlush)))
+			flushCtx = trace.WithFlush(ctx, trace.Flush{ID: flushID, Collector: c.sink.Name, BatchIDs: ids})
+		}
+
+		copyTime, insertTime, flushErr := c.write(flushCtx, toFlush, id, flushID)
+
+		for _, ack := range toAck {
+			ack(flushErr)
+		}
+		// Keep the WAL records if the flush was cut short by cancellation,
+		// so the events are replayed after restart
+		if len(toRelease) > 0 && (flushErr == nil || ctx.Err() == nil) {
+			c.wal.release(toRelease)
+		}
+		if flushErr == nil && c.onFlush != nil {
+			c.onFlush(toFlush)
 		}
 
 		c.stats.BatchesProcessed.Add(1)
-		c.stats.TotalFlushTimeNs.Add(time.Since(start).Nanoseconds())
+		c.stats.TotalFlushTimeNs.Add(c.config.Clock.Since(start).Nanoseconds())
 		c.stats.TotalBatchSize.Add(int64(len(toFlush)))
 
 		slog.Debug("batch flushed",
+			"collector", c.sink.Name,
 			"worker", id,
+			"flush_id", flushID,
 			"size", len(toFlush),
-			"duration_ms", time.Since(start).Milliseconds(),
+			"duration_ms", c.config.Clock.Since(start).Milliseconds(),
 		)
+
+		// Where each request batch spent its time: waiting in the queue,
+		// then in COPY and the INSERT fallback
+		for batchID, bt := range traced {
+			slog.Debug("request batch written",
+				"collector", c.sink.Name,
+				"batch_id", batchID,
+				"flush_id", flushID,
+				"events", bt.events,
+				"queue_wait_ms", start.Sub(bt.queued).Milliseconds(),
+				"copy_ms", copyTime.Milliseconds(),
+				"insert_ms", insertTime.Milliseconds(),
+				"total_ms", c.config.Clock.Since(bt.queued).Milliseconds(),
+				"error", flushErr,
+			)
+		}
 	}
 
 	for {
 		select {
-		case event := <-c.eventCh:
-			batch = append(batch, event)
+		case qe := <-c.eventCh:
+			add(qe)
 			if len(batch) >= c.config.BatchSize {
 				flush()
 			}
 
-		case <-ticker.C:
+		case qe := <-own:
+			add(qe)
+			if len(batch) >= c.config.BatchSize {
+				flush()
+			}
+
+		case <-ticker.C():
 			flush()
 
 		case <-c.shutdown:
@@ -136,14 +445,16 @@ func (c *BatchCollector) worker(ctx context.Context, id int) {
 			draining := true
 			for draining {
 				select {
-				case event := <-c.eventCh:
-					batch = append(batch, event)
+				case qe := <-c.eventCh:
+					add(qe)
+				case qe := <-own:
+					add(qe)
 				default:
 					draining = false
 				}
 			}
 			flush()
-			slog.Info("worker shutdown", "worker", id)
+			slog.Info("worker shutdown", "collector", c.sink.Name, "worker", id)
 			return
 
 		case <-ctx.Done():
@@ -153,35 +464,280 @@ func (c *BatchCollector) worker(ctx context.Context, id int) {
 	}
 }
 
-// Push adds an event to the queue
-func (c *BatchCollector) Push(event model.EnrichedEvent) {
-	c.stats.EventsReceived.Add(1)
+// write persists items with COPY, falling back to INSERT for rows COPY
+// could not write. Failures are retried with exponential backoff so
+// transient database outages (failover, restart) do not lose events; rows
+// the schema rejects are not retried.
+func (c *Collector[T]) write(ctx context.Context, items []T, worker int, flushID string) (copyTime, insertTime time.Duration, err error) {
+	pending := items
+	attempt := 1
+	for ; ; attempt++ {
+		var ct, it time.Duration
+		pending, ct, it, err = c.writeOnce(ctx, pending, worker, flushID)
+		copyTime += ct
+		insertTime += it
+		if err == nil {
+			return copyTime, insertTime, nil
+		}
+		if attempt >= c.config.RetryAttempts || ctx.Err() != nil || Permanent(err) {
+			break
+		}
 
-	select {
-	case c.eventCh <- event:
-	default:
-		// Queue full, drop event and log
-		c.stats.EventsFailed.Add(1)
-		slog.Warn("event dropped, queue full")
+		delay := c.backoff(attempt)
+		c.stats.FlushRetries.Add(1)
+		slog.Warn("retrying flush",
+			"collector", c.sink.Name,
+			"worker", worker,
+			"flush_id", flushID,
+			"attempt", attempt,
+			"pending", len(pending),
+			"retry_in", delay,
+		)
+
+		timer := c.config.Clock.NewTimer(delay)
+		select {
+		case <-timer.C():
+		case <-ctx.Done():
+			timer.Stop()
+		}
+	}
+
+	c.stats.EventsFailed.Add(int64(len(pending)))
+	slog.Error("flush gave up",
+		"collector", c.sink.Name,
+		"worker", worker,
+		"flush_id", flushID,
+		"attempts", attempt,
+		"failed", len(pending),
+		"error", err,
+	)
+	return copyTime, insertTime, err
+}
+
+// writeOnce makes one COPY + INSERT fallback attempt and returns the items
+// that are still unwritten
+func (c *Collector[T]) writeOnce(ctx context.Context, items []T, worker int, flushID string) (pending []T, copyTime, insertTime time.Duration, err error) {
+	// Use COPY for better performance
+	start := c.config.Clock.Now()
+	err = c.sink.Copy(ctx, items)
+	copyTime = c.config.Clock.Since(start)
+	if err == nil {
+		c.stats.EventsProcessed.Add(int64(len(items)))
+		return nil, copyTime, 0, nil
+	}
+
+	slog.Error("flush failed",
+		"collector", c.sink.Name,
+		"worker", worker,
+		"flush_id", flushID,
+		"batch_size", len(items),
+		"error", err,
+	)
+
+	// Partitions that were copied successfully must not be inserted again
+	retry := items
+	var partial *storage.PartialCopyError[T]
+	if errors.As(err, &partial) {
+		retry = partial.Failed
+		c.stats.EventsProcessed.Add(int64(len(items) - len(retry)))
 	}
+
+	// Fallback to INSERT on COPY failure
+	start = c.config.Clock.Now()
+	err = c.sink.Insert(ctx, retry)
+	insertTime = c.config.Clock.Since(start)
+	if err != nil {
+		slog.Error("insert fallback failed",
+			"collector", c.sink.Name,
+			"worker", worker,
+			"flush_id", flushID,
+			"error", err,
+		)
+		return retry, copyTime, insertTime, err
+	}
+
+	c.stats.EventsProcessed.Add(int64(len(retry)))
+	return nil, copyTime, insertTime, nil
+}
+
+// Permanent reports whether a flush error cannot be fixed by writing the
+// same rows again, because the schema rejected them
+func Permanent(err error) bool {
+	return errors.Is(err, storage.ErrValidation) || errors.Is(err, storage.ErrConflict)
+}
+
+// backoff returns the delay before retry number attempt
+func (c *Collector[T]) backoff(attempt int) time.Duration {
+	delay := c.config.RetryBackoff << (attempt - 1)
+	if delay > c.config.RetryMaxBackoff || delay <= 0 {
+		delay = c.config.RetryMaxBackoff
+	}
+	if c.config.RetryJitter > 0 {
+		delay += time.Duration((rand.Float64()*2 - 1) * c.config.RetryJitter * float64(delay))
+	}
+	return delay
 }
 
-// PushBatch adds multiple events
-func (c *BatchCollector) PushBatch(events []model.EnrichedEvent) {
+// Push adds an event from the given request batch to the queue
+func (c *Collector[T]) Push(batchID string, event T) {
+	c.PushWithAck(batchID, event, nil)
+}
+
+// PushWithAck adds an event to the queue and calls ack with the result of
+// the flush that persists it. If the queue is full, ack is called
+// immediately with ErrQueueFull; such events are never spilled, since the
+// ack could not survive a restart.
+func (c *Collector[T]) PushWithAck(batchID string, event T, ack func(error)) {
+	if !c.push(batchID, event, ack) && ack != nil {
+		ack(ErrQueueFull)
+	}
+}
+
+// PushBatch adds multiple events and returns how many were dropped because
+// the queue (and spill queue, if enabled) was full
+func (c *Collector[T]) PushBatch(batchID string, events []T) int {
+	dropped := 0
 	for _, e := range events {
-		c.Push(e)
+		if !c.push(batchID, e, nil) {
+			dropped++
+		}
 	}
+
+	slog.Debug("request batch queued",
+		"collector", c.sink.Name,
+		"batch_id", batchID,
+		"events", len(events),
+		"dropped", dropped,
+	)
+	return dropped
 }
 
-// Shutdown gracefully stops the collector
-func (c *BatchCollector) Shutdown() {
+func (c *Collector[T]) push(batchID string, event T, ack func(error)) bool {
+	c.stats.EventsReceived.Add(1)
+
+	if c.discard != nil && c.discard(event) {
+		c.stats.Discarded.Add(1)
+		if ack != nil {
+			ack(nil)
+		}
+		return true
+	}
+
+	// Retried events are acknowledged without being queued again
+	var id string
+	if c.dedupe != nil {
+		id = c.eventID(event)
+		if id != "" && c.dedupe.seen(id) {
+			c.stats.Deduplicated.Add(1)
+			if ack != nil {
+				ack(nil)
+			}
+			return true
+		}
+	}
+
+	// Events with an ack are redelivered by their source and need no WAL
+	qe := queuedEvent[T]{event: event, ack: ack, batchID: batchID, queued: c.config.Clock.Now()}
+	if c.wal != nil && ack == nil {
+		if c.pushLogged(qe) {
+			return true
+		}
+	} else {
+		select {
+		case c.queue(event) <- qe:
+			return true
+		default:
+		}
+	}
+
+	// Queue full, spill to disk if possible. The batch ID is not kept;
+	// replayed events are traced under the segment they were spilled to.
+	if c.spill != nil && ack == nil {
+		err := c.spill.write(event)
+		if err == nil {
+			c.stats.EventsSpilled.Add(1)
+			return true
+		}
+		if !errors.Is(err, errSpillFull) {
+			slog.Error("failed to spill event", "collector", c.sink.Name, "error", err)
+		}
+	}
+
+	// Drop event and log. Its ID is forgotten so a retry is accepted.
+	if id != "" {
+		c.dedupe.forget(id)
+	}
+	c.stats.EventsFailed.Add(1)
+	slog.Warn("event dropped, queue full", "collector", c.sink.Name)
+	return false
+}
+
+// Admit reports whether a request may be queued. While the queue is above
+// the high watermark it returns false with the delay the client should wait
+// before retrying, and counts the request as throttled.
+func (c *Collector[T]) Admit() (time.Duration, bool) {
+	if c.config.HighWatermark <= 0 || c.Saturation() < c.config.HighWatermark*100 {
+		return 0, true
+	}
+	c.stats.Throttled.Add(1)
+	return c.config.RetryAfter, false
+}
+
+// Saturation returns queue depth as a percentage of queue capacity,
+// including the worker queues of RouteBy
+func (c *Collector[T]) Saturation() float64 {
+	capacity := cap(c.eventCh)
+	for _, ch := range c.workerCh {
+		capacity += cap(ch)
+	}
+	return float64(c.QueueSize()) / float64(capacity) * 100
+}
+
+// Shutdown gracefully stops the collector. Events still spilled to disk are
+// drained after the next start.
+func (c *Collector[T]) Shutdown() {
+	c.Drain(context.Background())
+}
+
+// Drain stops the workers once they have flushed the queue. If ctx ends
+// first, Drain returns ctx.Err() and the workers keep flushing in the
+// background; events still queued are lost unless the WAL is enabled.
+func (c *Collector[T]) Drain(ctx context.Context) error {
+	start := c.config.Clock.Now()
 	close(c.shutdown)
-	c.wg.Wait()
-	slog.Info("batch collector shutdown complete")
+
+	done := make(chan struct{})
+	go func() {
+		c.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		slog.Warn("batch collector drain timed out",
+			"collector", c.sink.Name,
+			"queued", c.QueueSize(),
+			"duration_ms", c.config.Clock.Since(start).Milliseconds(),
+		)
+		return ctx.Err()
+	}
+
+	if c.spill != nil {
+		c.spill.close()
+	}
+	if c.wal != nil {
+		c.wal.close()
+	}
+	slog.Info("batch collector shutdown complete",
+		"collector", c.sink.Name,
+		"duration_ms", c.config.Clock.Since(start).Milliseconds(),
+	)
+	return nil
 }
 
 // GetStats returns current collector statistics
-func (c *BatchCollector) GetStats() model.CollectorStats {
+func (c *Collector[T]) GetStats() model.CollectorStats {
 	batchCount := c.stats.BatchesProcessed.Load()
 	totalSize := c.stats.TotalBatchSize.Load()
 	totalFlushTime := c.stats.TotalFlushTimeNs.Load()
@@ -192,18 +748,38 @@ func (c *BatchCollector) GetStats() model.CollectorStats {
 		avgFlushTime = float64(totalFlushTime) / float64(batchCount) / 1e6 // to ms
 	}
 
-	return model.CollectorStats{
+	stats := model.CollectorStats{
 		EventsReceived:   c.stats.EventsReceived.Load(),
 		EventsProcessed:  c.stats.EventsProcessed.Load(),
 		EventsFailed:     c.stats.EventsFailed.Load(),
 		BatchesProcessed: batchCount,
-		QueueSize:        len(c.eventCh),
+		QueueSize:        c.QueueSize(),
 		AvgBatchSize:     avgBatchSize,
 		AvgFlushTimeMS:   avgFlushTime,
+		EventsSpilled:    c.stats.EventsSpilled.Load(),
+		FlushRetries:     c.stats.FlushRetries.Load(),
+		QueueSaturation:  c.Saturation(),
+		Throttled:        c.stats.Throttled.Load(),
+		WALReplayed:      c.stats.WALReplayed.Load(),
+		WALSkipped:       c.stats.WALSkipped.Load(),
+		Deduplicated:     c.stats.Deduplicated.Load(),
+		Discarded:        c.stats.Discarded.Load(),
+		IngestLagMS:      float64(c.stats.IngestLagNs.Load()) / 1e6,
+	}
+	if c.spill != nil {
+		stats.SpillBytes = c.spill.bytes()
 	}
+	if c.wal != nil {
+		stats.WALBytes = c.wal.bytes()
+	}
+	return stats
 }
 
 // QueueSize returns current queue depth
-func (c *BatchCollector) QueueSize() int {
-	return len(c.eventCh)
+func (c *Collector[T]) QueueSize() int {
+	n := len(c.eventCh)
+	for _, ch := range c.workerCh {
+		n += len(ch)
+	}
+	return n
 }
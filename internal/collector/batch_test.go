@@ -0,0 +1,163 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mcbile/product-pulse/internal/model"
+	"github.com/mcbile/product-pulse/pkg/clock"
+)
+
+// recordingSink records the batches written to it; Copy fails as long as
+// failures is positive
+type recordingSink struct {
+	mu       sync.Mutex
+	batches  [][]int
+	failures int
+	written  chan struct{}
+}
+
+func newRecordingSink() *recordingSink {
+	return &recordingSink{written: make(chan struct{}, 16)}
+}
+
+func (s *recordingSink) sink() Sink[int] {
+	return Sink[int]{
+		Name: "test",
+		Copy: func(ctx context.Context, items []int) error {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			if s.failures > 0 {
+				s.failures--
+				return errors.New("connection reset")
+			}
+			s.batches = append(s.batches, append([]int(nil), items...))
+			s.written <- struct{}{}
+			return nil
+		},
+		Insert: func(ctx context.Context, items []int) error {
+			return errors.New("connection reset")
+		},
+	}
+}
+
+func (s *recordingSink) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.batches)
+}
+
+func (s *recordingSink) wait(t *testing.T) []int {
+	t.Helper()
+	select {
+	case <-s.written:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no batch written")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.batches[len(s.batches)-1]
+}
+
+// waitQueued waits until the worker has taken every pushed event
+func waitQueued[T any](t *testing.T, c *Collector[T]) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for c.QueueSize() > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("events not picked up")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestCollectorFlushesOnInterval(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC))
+	sink := newRecordingSink()
+	c := New(BatchConfig{BatchSize: 100, FlushInterval: time.Second, Workers: 1, Clock: clk}, sink.sink())
+	c.Start(context.Background())
+	defer c.Drain(context.Background())
+	clk.BlockUntil(1)
+
+	c.Push("", 1)
+	c.Push("", 2)
+	waitQueued(t, c)
+
+	clk.Advance(999 * time.Millisecond)
+	if n := sink.count(); n != 0 {
+		t.Fatalf("flushed %d batches before the interval", n)
+	}
+
+	clk.Advance(time.Millisecond)
+	if got := sink.wait(t); len(got) != 2 {
+		t.Fatalf("flushed %v, want both events", got)
+	}
+	if lag := c.GetStats().IngestLagMS; lag != 1000 {
+		t.Errorf("ingest lag %vms, want 1000ms of fake time", lag)
+	}
+}
+
+func TestCollectorFlushesFullBatchWithoutTick(t *testing.T) {
+	clk := clock.NewFake(time.Now())
+	sink := newRecordingSink()
+	c := New(BatchConfig{BatchSize: 3, FlushInterval: time.Hour, Workers: 1, Clock: clk}, sink.sink())
+	c.Start(context.Background())
+	defer c.Drain(context.Background())
+
+	c.PushBatch("", []int{1, 2, 3})
+	if got := sink.wait(t); len(got) != 3 {
+		t.Fatalf("flushed %v, want the full batch", got)
+	}
+}
+
+func TestCollectorRetriesAfterBackoff(t *testing.T) {
+	clk := clock.NewFake(time.Now())
+	sink := newRecordingSink()
+	sink.failures = 1
+	c := New(BatchConfig{
+		BatchSize:     100,
+		FlushInterval: time.Second,
+		Workers:       1,
+		RetryAttempts: 2,
+		RetryBackoff:  5 * time.Second,
+		Clock:         clk,
+	}, sink.sink())
+	c.Start(context.Background())
+	defer c.Drain(context.Background())
+	clk.BlockUntil(1)
+
+	c.Push("", 1)
+	waitQueued(t, c)
+	clk.Advance(time.Second)
+
+	// The failed flush waits on a backoff timer next to the ticker
+	clk.BlockUntil(2)
+	clk.Advance(5*time.Second - time.Millisecond)
+	if n := sink.count(); n != 0 {
+		t.Fatalf("retried before the backoff")
+	}
+	clk.Advance(time.Millisecond)
+	if got := sink.wait(t); len(got) != 1 {
+		t.Fatalf("retry wrote %v", got)
+	}
+	if retries := c.GetStats().FlushRetries; retries != 1 {
+		t.Errorf("%d retries, want 1", retries)
+	}
+}
+
+func TestFrontendEventIDsAreScopedToTheirSite(t *testing.T) {
+	id := "0b7e6a52"
+	a := model.EnrichedEvent{FrontendEvent: model.FrontendEvent{SiteID: "casino-a", EventID: &id}}
+	b := model.EnrichedEvent{FrontendEvent: model.FrontendEvent{SiteID: "casino-b", EventID: &id}}
+	if frontendEventID(a) == frontendEventID(b) {
+		t.Errorf("same ID %q for events of different sites", frontendEventID(a))
+	}
+
+	empty := ""
+	if got := frontendEventID(model.EnrichedEvent{FrontendEvent: model.FrontendEvent{SiteID: "casino-a", EventID: &empty}}); got != "" {
+		t.Errorf("event without ID deduplicated as %q", got)
+	}
+}
@@ -0,0 +1,67 @@
+package collector
+
+import (
+	"sync"
+	"time"
+
+	"github.com/mcbile/product-pulse/pkg/clock"
+)
+
+// dedupeMaxIDs caps one generation of a dedupe window. A full generation is
+// rotated early, which shortens the window under heavy load instead of
+// growing memory without bound.
+const dedupeMaxIDs = 1 << 20
+
+// dedupeWindow remembers recently accepted event IDs so that SDK retries
+// after a network timeout are not counted twice. IDs are kept in two
+// generations that rotate every window, so an ID is remembered for at least
+// one window and at most two.
+type dedupeWindow struct {
+	window time.Duration
+	clock  clock.Clock
+
+	mu       sync.Mutex
+	current  map[string]struct{}
+	previous map[string]struct{}
+	rotated  time.Time
+}
+
+func newDedupeWindow(window time.Duration, clock clock.Clock) *dedupeWindow {
+	return &dedupeWindow{
+		window:   window,
+		clock:    clock,
+		current:  make(map[string]struct{}),
+		previous: make(map[string]struct{}),
+		rotated:  clock.Now(),
+	}
+}
+
+// seen records id and reports whether it was already recorded
+func (d *dedupeWindow) seen(id string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.clock.Since(d.rotated) >= d.window || len(d.current) >= dedupeMaxIDs {
+		d.previous = d.current
+		d.current = make(map[string]struct{})
+		d.rotated = d.clock.Now()
+	}
+
+	if _, ok := d.current[id]; ok {
+		return true
+	}
+	if _, ok := d.previous[id]; ok {
+		return true
+	}
+	d.current[id] = struct{}{}
+	return false
+}
+
+// forget removes id, so a retry of an event that could not be queued is
+// accepted
+func (d *dedupeWindow) forget(id string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.current, id)
+	delete(d.previous, id)
+}
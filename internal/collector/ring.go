@@ -0,0 +1,106 @@
+package collector
+
+import (
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// ringReplicas is the number of points per worker on a HashRing. More
+// points spread keys more evenly at the cost of a larger ring.
+const ringReplicas = 128
+
+// Ring maps routing keys to one of n workers. Implementations must be safe
+// for concurrent use and should move as few keys as possible on Resize.
+type Ring interface {
+	Resize(n int)
+	Node(key string) int
+}
+
+// HashRing is a consistent hash ring. Each worker owns ringReplicas points;
+// a key belongs to the first point at or after its hash. When the worker
+// count changes, only the keys of the added or removed workers move (about
+// 1/n of them), so per-session state on the other workers stays valid.
+type HashRing struct {
+	mu     sync.RWMutex
+	hashes []uint64 // Sorted
+	nodes  []int    // Worker of hashes[i]
+}
+
+// NewHashRing creates a ring of n workers
+func NewHashRing(n int) *HashRing {
+	r := &HashRing{}
+	r.Resize(n)
+	return r
+}
+
+// Resize rebuilds the ring for n workers. Points depend only on the worker
+// index, so growing from n to n+1 workers adds points without moving the
+// existing ones.
+func (r *HashRing) Resize(n int) {
+	if n < 1 {
+		n = 1
+	}
+
+	type point struct {
+		hash uint64
+		node int
+	}
+	points := make([]point, 0, n*ringReplicas)
+	for node := 0; node < n; node++ {
+		for i := 0; i < ringReplicas; i++ {
+			points = append(points, point{hashKey(strconv.Itoa(node) + "#" + strconv.Itoa(i)), node})
+		}
+	}
+	sort.Slice(points, func(i, j int) bool { return points[i].hash < points[j].hash })
+
+	hashes := make([]uint64, len(points))
+	nodes := make([]int, len(points))
+	for i, p := range points {
+		hashes[i] = p.hash
+		nodes[i] = p.node
+	}
+
+	r.mu.Lock()
+	r.hashes = hashes
+	r.nodes = nodes
+	r.mu.Unlock()
+}
+
+// Node returns the worker owning key
+func (r *HashRing) Node(key string) int {
+	h := hashKey(key)
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	i := sort.Search(len(r.hashes), func(i int) bool { return r.hashes[i] >= h })
+	if i == len(r.hashes) {
+		i = 0
+	}
+	return r.nodes[i]
+}
+
+// hashKey is FNV-1a with a final mix, since plain FNV spreads short,
+// similar keys (session IDs, worker points) poorly
+func hashKey(key string) uint64 {
+	f := fnv.New64a()
+	f.Write([]byte(key))
+	h := f.Sum64()
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
+
+// routingKey returns the first non-empty key, or "" to route to any worker
+func routingKey(keys ...*string) string {
+	for _, k := range keys {
+		if k != nil && *k != "" {
+			return *k
+		}
+	}
+	return ""
+}
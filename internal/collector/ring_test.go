@@ -0,0 +1,83 @@
+package collector
+
+import (
+	"fmt"
+	"testing"
+)
+
+func sessionKeys(n int) []string {
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("sess_%08x", i*7919)
+	}
+	return keys
+}
+
+func TestHashRingRoutesKeysStably(t *testing.T) {
+	a, b := NewHashRing(8), NewHashRing(8)
+	for _, key := range sessionKeys(1000) {
+		node := a.Node(key)
+		if node < 0 || node >= 8 {
+			t.Fatalf("%s routed to worker %d of 8", key, node)
+		}
+		if again := a.Node(key); again != node {
+			t.Fatalf("%s routed to %d, then %d", key, node, again)
+		}
+		// Rings of the same size agree, e.g. across restarts
+		if other := b.Node(key); other != node {
+			t.Fatalf("%s routed to %d and %d by equal rings", key, node, other)
+		}
+	}
+}
+
+func TestHashRingSpreadsKeys(t *testing.T) {
+	const workers, keys = 8, 20000
+	r := NewHashRing(workers)
+	counts := make([]int, workers)
+	for _, key := range sessionKeys(keys) {
+		counts[r.Node(key)]++
+	}
+
+	mean := keys / workers
+	for node, n := range counts {
+		if n < mean*3/4 || n > mean*5/4 {
+			t.Errorf("worker %d got %d keys, want within 25%% of %d (all: %v)", node, n, mean, counts)
+		}
+	}
+}
+
+func TestHashRingResizeMovesFewKeys(t *testing.T) {
+	keys := sessionKeys(20000)
+	r := NewHashRing(8)
+	before := make([]int, len(keys))
+	for i, key := range keys {
+		before[i] = r.Node(key)
+	}
+
+	r.Resize(9)
+	moved := 0
+	for i, key := range keys {
+		node := r.Node(key)
+		if node == before[i] {
+			continue
+		}
+		moved++
+		if node != 8 {
+			t.Fatalf("%s moved from worker %d to %d, not to the added worker", key, before[i], node)
+		}
+	}
+	// About 1/9 of the keys move to the new worker
+	if moved < len(keys)/18 || moved > len(keys)*2/9 {
+		t.Errorf("%d of %d keys moved, want about %d", moved, len(keys), len(keys)/9)
+	}
+}
+
+func TestRoutingKey(t *testing.T) {
+	empty, session, player := "", "sess_1", "player_1"
+	if got := routingKey(nil, &empty, &session, &player); got != session {
+		t.Errorf("got %q, want the first non-empty key", got)
+	}
+	if got := routingKey(nil, &empty); got != "" {
+		t.Errorf("got %q, want no key", got)
+	}
+}
@@ -0,0 +1,453 @@
+package collector
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// spillSegmentBytes is the size at which a new spill segment is started
+const spillSegmentBytes = 8 << 20
+
+var errSpillFull = errors.New("spill queue full")
+
+// Spill and WAL records are framed so a torn write at crash time only loses
+// that record: magic, flags, payload length and a CRC-32C over flags, length
+// and payload, followed by the payload (JSON, AES-256-GCM sealed if a key is
+// configured).
+const (
+	spillMagic0, spillMagic1 = 0xB5, 0x1A
+	spillHeaderLen           = 11 // magic(2) flags(1) length(4) crc(4)
+	spillFlagEncrypted       = 1 << 0
+	spillMaxRecord           = 64 << 20
+)
+
+var crc32c = crc32.MakeTable(crc32.Castagnoli)
+
+// ParseSpillKey decodes a 32-byte AES-256 key given as 64 hex characters or
+// standard base64. The key encrypts both spill and WAL records.
+func ParseSpillKey(s string) ([]byte, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, nil
+	}
+	key, err := hex.DecodeString(s)
+	if err != nil {
+		key, err = base64.StdEncoding.DecodeString(s)
+	}
+	if err != nil || len(key) != 32 {
+		return nil, errors.New("spill encryption key must be 32 bytes, hex or base64 encoded")
+	}
+	return key, nil
+}
+
+// spillQueue is an on-disk FIFO of segment files holding framed JSON items.
+// It takes events the in-memory queue has no room for; segments are
+// replayed oldest first and deleted once fully re-queued, so delivery is
+// at-least-once across restarts.
+type spillQueue[T any] struct {
+	recordCodec
+	dir      string
+	maxBytes int64
+
+	mu       sync.Mutex
+	segments []uint64 // Sequence numbers, oldest first
+	sizes    map[uint64]int64
+	size     int64    // Bytes on disk
+	file     *os.File // Segment being written, nil if none
+	fileSeq  uint64
+	nextSeq  uint64
+}
+
+func openSpillQueue[T any](dir string, maxBytes int64, key []byte) (*spillQueue[T], error) {
+	codec, err := newRecordCodec(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("create spill dir: %w", err)
+	}
+
+	segments, sizes, err := listSegments(dir)
+	if err != nil {
+		return nil, fmt.Errorf("read spill dir: %w", err)
+	}
+
+	q := &spillQueue[T]{
+		recordCodec: codec,
+		dir:         dir,
+		maxBytes:    maxBytes,
+		sizes:       make(map[uint64]int64),
+	}
+	for i, seq := range segments {
+		q.segments = append(q.segments, seq)
+		q.sizes[seq] = sizes[i]
+		q.size += sizes[i]
+	}
+	if n := len(q.segments); n > 0 {
+		q.nextSeq = q.segments[n-1] + 1
+	}
+
+	return q, nil
+}
+
+// listSegments returns the sequence numbers and sizes of the segment files
+// in dir, oldest first
+func listSegments(dir string) ([]uint64, []int64, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	type segment struct {
+		seq  uint64
+		size int64
+	}
+	var found []segment
+	for _, e := range entries {
+		name, ok := strings.CutSuffix(e.Name(), ".seg")
+		if !ok || e.IsDir() {
+			continue
+		}
+		seq, err := strconv.ParseUint(name, 10, 64)
+		if err != nil {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			return nil, nil, fmt.Errorf("stat segment: %w", err)
+		}
+		found = append(found, segment{seq, info.Size()})
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i].seq < found[j].seq })
+
+	seqs := make([]uint64, len(found))
+	sizes := make([]int64, len(found))
+	for i, f := range found {
+		seqs[i], sizes[i] = f.seq, f.size
+	}
+	return seqs, sizes, nil
+}
+
+func segmentPath(dir string, seq uint64) string {
+	return filepath.Join(dir, fmt.Sprintf("%020d.seg", seq))
+}
+
+func (q *spillQueue[T]) path(seq uint64) string {
+	return segmentPath(q.dir, seq)
+}
+
+// write appends an item to the newest segment, starting a new one when it
+// is full
+func (q *spillQueue[T]) write(item T) error {
+	payload, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+	line, err := q.frame(payload)
+	if err != nil {
+		return err
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.size+int64(len(line)) > q.maxBytes {
+		return errSpillFull
+	}
+
+	if q.file != nil && q.sizes[q.fileSeq]+int64(len(line)) > spillSegmentBytes {
+		q.closeSegment()
+	}
+	if q.file == nil {
+		seq := q.nextSeq
+		f, err := os.OpenFile(q.path(seq), os.O_CREATE|os.O_EXCL|os.O_WRONLY|os.O_APPEND, 0o600)
+		if err != nil {
+			return fmt.Errorf("create spill segment: %w", err)
+		}
+		q.nextSeq++
+		q.file = f
+		q.fileSeq = seq
+		q.segments = append(q.segments, seq)
+		q.sizes[seq] = 0
+	}
+
+	n, err := q.file.Write(line)
+	q.sizes[q.fileSeq] += int64(n)
+	q.size += int64(n)
+	return err
+}
+
+// oldest returns the oldest segment, closing it first if it is still being
+// written so new items go to a fresh segment
+func (q *spillQueue[T]) oldest() (uint64, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.segments) == 0 {
+		return 0, false
+	}
+	seq := q.segments[0]
+	if q.file != nil && q.fileSeq == seq {
+		q.closeSegment()
+	}
+	return seq, true
+}
+
+// remove deletes the oldest segment after it has been replayed
+func (q *spillQueue[T]) remove(seq uint64) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.segments) == 0 || q.segments[0] != seq {
+		return nil
+	}
+	q.segments = q.segments[1:]
+	q.size -= q.sizes[seq]
+	delete(q.sizes, seq)
+	return os.Remove(q.path(seq))
+}
+
+// bytes returns the size of all segments on disk
+func (q *spillQueue[T]) bytes() int64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.size
+}
+
+func (q *spillQueue[T]) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.closeSegment()
+}
+
+func (q *spillQueue[T]) closeSegment() {
+	if q.file == nil {
+		return
+	}
+	if err := q.file.Close(); err != nil {
+		slog.Error("failed to close spill segment", "path", q.file.Name(), "error", err)
+	}
+	q.file = nil
+}
+
+// drainSpill re-queues spilled events oldest first, blocking until the
+// in-memory queue has capacity
+func (c *Collector[T]) drainSpill(ctx context.Context) {
+	defer c.wg.Done()
+
+	ticker := c.config.Clock.NewTicker(c.config.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		seq, ok := c.spill.oldest()
+		if !ok {
+			select {
+			case <-ticker.C():
+				continue
+			case <-c.shutdown:
+				return
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		n, err := c.replaySegment(ctx, seq)
+		if err != nil {
+			// Stopped mid-segment; the rest is replayed after restart
+			if errors.Is(err, errStopped) {
+				return
+			}
+			slog.Error("failed to replay spill segment",
+				"collector", c.sink.Name,
+				"path", c.spill.path(seq),
+				"error", err,
+			)
+		}
+		if err := c.spill.remove(seq); err != nil {
+			slog.Error("failed to remove spill segment", "collector", c.sink.Name, "error", err)
+		}
+		slog.Info("spill segment drained", "collector", c.sink.Name, "events", n)
+	}
+}
+
+var errStopped = errors.New("collector stopped")
+
+func (c *Collector[T]) replaySegment(ctx context.Context, seq uint64) (int, error) {
+	data, err := os.ReadFile(c.spill.path(seq))
+	if err != nil {
+		return 0, err
+	}
+
+	batchID := fmt.Sprintf("spill-%d", seq)
+	n := 0
+	for len(data) > 0 {
+		payload, rest, skipped := c.spill.unframe(data)
+		if skipped > 0 {
+			slog.Warn("skipping corrupt spill data",
+				"collector", c.sink.Name,
+				"path", c.spill.path(seq),
+				"bytes", skipped,
+			)
+		}
+		data = rest
+		if payload == nil {
+			continue
+		}
+
+		var event T
+		if err := json.Unmarshal(payload, &event); err != nil {
+			slog.Warn("skipping undecodable spilled event", "collector", c.sink.Name, "error", err)
+			continue
+		}
+		if err := c.requeue(ctx, batchID, event); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// requeue queues a replayed event, waiting until the queue has room. With
+// a WAL the event is logged again before it is queued.
+func (c *Collector[T]) requeue(ctx context.Context, batchID string, event T) error {
+	qe := queuedEvent[T]{event: event, batchID: batchID, queued: c.config.Clock.Now()}
+	if c.wal == nil {
+		select {
+		case c.queue(event) <- qe:
+			return nil
+		case <-c.shutdown:
+			return errStopped
+		case <-ctx.Done():
+			return errStopped
+		}
+	}
+
+	for {
+		if c.pushLogged(qe) {
+			return nil
+		}
+		select {
+		case <-time.After(10 * time.Millisecond):
+		case <-c.shutdown:
+			return errStopped
+		case <-ctx.Done():
+			return errStopped
+		}
+	}
+}
+
+// recordCodec frames records, sealing payloads if a key is configured
+type recordCodec struct {
+	aead cipher.AEAD // nil if events are stored unencrypted
+}
+
+func newRecordCodec(key []byte) (recordCodec, error) {
+	if len(key) == 0 {
+		return recordCodec{}, nil
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return recordCodec{}, fmt.Errorf("record cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return recordCodec{}, fmt.Errorf("record cipher: %w", err)
+	}
+	return recordCodec{aead: aead}, nil
+}
+
+// frame wraps a JSON payload in a record, encrypting it if a key is set
+func (q recordCodec) frame(payload []byte) ([]byte, error) {
+	var flags byte
+	if q.aead != nil {
+		nonce := make([]byte, q.aead.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			return nil, fmt.Errorf("record nonce: %w", err)
+		}
+		payload = q.aead.Seal(nonce, nonce, payload, nil)
+		flags |= spillFlagEncrypted
+	}
+
+	rec := make([]byte, spillHeaderLen+len(payload))
+	rec[0], rec[1], rec[2] = spillMagic0, spillMagic1, flags
+	binary.BigEndian.PutUint32(rec[3:7], uint32(len(payload)))
+	copy(rec[spillHeaderLen:], payload)
+	binary.BigEndian.PutUint32(rec[7:11], recordCRC(rec))
+	return rec, nil
+}
+
+// unframe reads the record at the start of data and returns its payload
+// and the remaining data. Bytes that do not form a valid record are
+// skipped up to the next record magic and counted in skipped; payload is
+// nil if no valid record could be read.
+func (q recordCodec) unframe(data []byte) (payload, rest []byte, skipped int) {
+	for len(data) > 0 {
+		if payload, n, ok := q.decodeRecord(data); ok {
+			return payload, data[n:], skipped
+		}
+
+		// Resynchronize on the next magic
+		next := bytes.Index(data[1:], []byte{spillMagic0, spillMagic1})
+		if next < 0 {
+			return nil, nil, skipped + len(data)
+		}
+		skipped += next + 1
+		data = data[next+1:]
+	}
+	return nil, nil, skipped
+}
+
+func (q recordCodec) decodeRecord(data []byte) ([]byte, int, bool) {
+	if len(data) < spillHeaderLen || data[0] != spillMagic0 || data[1] != spillMagic1 {
+		return nil, 0, false
+	}
+	size := int(binary.BigEndian.Uint32(data[3:7]))
+	if size > spillMaxRecord || len(data) < spillHeaderLen+size {
+		return nil, 0, false // Torn write at the end of a segment
+	}
+	rec := data[:spillHeaderLen+size]
+	if binary.BigEndian.Uint32(rec[7:11]) != recordCRC(rec) {
+		return nil, 0, false
+	}
+
+	payload := rec[spillHeaderLen:]
+	if rec[2]&spillFlagEncrypted != 0 {
+		if q.aead == nil || len(payload) < q.aead.NonceSize() {
+			slog.Warn("cannot decrypt event record, no matching key configured")
+			return nil, len(rec), true
+		}
+		nonce, sealed := payload[:q.aead.NonceSize()], payload[q.aead.NonceSize():]
+		plain, err := q.aead.Open(nil, nonce, sealed, nil)
+		if err != nil {
+			slog.Warn("cannot decrypt event record", "error", err)
+			return nil, len(rec), true
+		}
+		payload = plain
+	}
+	return payload, len(rec), true
+}
+
+// recordCRC checksums a record's flags, length and payload
+func recordCRC(rec []byte) uint32 {
+	crc := crc32.Update(0, crc32c, rec[2:7])
+	return crc32.Update(crc, crc32c, rec[spillHeaderLen:])
+}
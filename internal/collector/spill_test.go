@@ -0,0 +1,121 @@
+package collector
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"testing"
+)
+
+func testCodec(t *testing.T, key byte) recordCodec {
+	t.Helper()
+	codec, err := newRecordCodec(bytes.Repeat([]byte{key}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return codec
+}
+
+func frameAll(t *testing.T, codec recordCodec, payloads ...string) []byte {
+	t.Helper()
+	var data []byte
+	for _, p := range payloads {
+		rec, err := codec.frame([]byte(p))
+		if err != nil {
+			t.Fatal(err)
+		}
+		data = append(data, rec...)
+	}
+	return data
+}
+
+// unframeAll returns the payloads of all valid records in data and the
+// number of bytes skipped
+func unframeAll(codec recordCodec, data []byte) ([]string, int) {
+	var payloads []string
+	skipped := 0
+	for len(data) > 0 {
+		payload, rest, n := codec.unframe(data)
+		skipped += n
+		if payload != nil {
+			payloads = append(payloads, string(payload))
+		}
+		data = rest
+	}
+	return payloads, skipped
+}
+
+func TestRecordRoundTrip(t *testing.T) {
+	for name, codec := range map[string]recordCodec{"plain": {}, "encrypted": testCodec(t, 1)} {
+		data := frameAll(t, codec, `{"a":1}`, `{"b":2}`, `{}`)
+		got, skipped := unframeAll(codec, data)
+		if skipped != 0 || len(got) != 3 || got[0] != `{"a":1}` || got[1] != `{"b":2}` || got[2] != `{}` {
+			t.Errorf("%s: got %q, skipped %d", name, got, skipped)
+		}
+	}
+}
+
+func TestRecordEncryptionHidesPayload(t *testing.T) {
+	data := frameAll(t, testCodec(t, 1), `{"player_id":"p_123"}`)
+	if bytes.Contains(data, []byte("p_123")) {
+		t.Fatal("encrypted record contains the plain payload")
+	}
+	if data[2]&spillFlagEncrypted == 0 {
+		t.Fatal("encrypted flag not set")
+	}
+}
+
+func TestRecordRejectsCorruptCRC(t *testing.T) {
+	codec := testCodec(t, 1)
+	first := frameAll(t, codec, `{"a":1}`)
+	data := append(bytes.Clone(first), frameAll(t, codec, `{"b":2}`)...)
+	data[len(first)-1] ^= 0xFF // Last payload byte of the first record
+
+	got, skipped := unframeAll(codec, data)
+	if len(got) != 1 || got[0] != `{"b":2}` {
+		t.Fatalf("got %q, want only the intact record", got)
+	}
+	if skipped != len(first) {
+		t.Errorf("skipped %d bytes, want the %d of the corrupt record", skipped, len(first))
+	}
+
+	// So is a record whose stored CRC was damaged
+	data = frameAll(t, codec, `{"a":1}`)
+	data[8] ^= 0x01 // Inside the CRC field
+	if got, _ := unframeAll(codec, data); len(got) != 0 {
+		t.Errorf("record with corrupt CRC accepted: %q", got)
+	}
+}
+
+func TestRecordRejectsWrongKey(t *testing.T) {
+	data := frameAll(t, testCodec(t, 1), `{"a":1}`, `{"b":2}`)
+
+	for name, codec := range map[string]recordCodec{"other key": testCodec(t, 2), "no key": {}} {
+		got, skipped := unframeAll(codec, data)
+		if len(got) != 0 {
+			t.Errorf("%s: decrypted %q", name, got)
+		}
+		// The records are intact, so they are consumed rather than skipped
+		if skipped != 0 {
+			t.Errorf("%s: skipped %d bytes", name, skipped)
+		}
+	}
+}
+
+func TestParseSpillKey(t *testing.T) {
+	key := bytes.Repeat([]byte{0xAB}, 32)
+	for _, s := range []string{hex.EncodeToString(key), base64.StdEncoding.EncodeToString(key), " " + hex.EncodeToString(key) + "\n"} {
+		got, err := ParseSpillKey(s)
+		if err != nil || !bytes.Equal(got, key) {
+			t.Errorf("ParseSpillKey(%q) = %x, %v", s, got, err)
+		}
+	}
+	if got, err := ParseSpillKey(""); got != nil || err != nil {
+		t.Errorf("empty key: %x, %v", got, err)
+	}
+	for _, s := range []string{"abcd", hex.EncodeToString(key[:16]), "not a key"} {
+		if _, err := ParseSpillKey(s); err == nil {
+			t.Errorf("ParseSpillKey(%q) accepted", s)
+		}
+	}
+}
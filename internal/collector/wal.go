@@ -0,0 +1,300 @@
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+)
+
+// walSegmentBytes is the size at which a new WAL segment is started
+const walSegmentBytes = 8 << 20
+
+// errWALFull is returned by append when the WAL is at its size limit
+var errWALFull = errors.New("wal full")
+
+// writeAheadLog records events before they are queued so a crash between
+// accept and flush does not lose them. Every queued event references the
+// segment holding its record; a segment is deleted as soon as all of its
+// events have been flushed, so only unflushed events are replayed after a
+// restart (delivery is at-least-once).
+type writeAheadLog struct {
+	recordCodec
+	dir          string
+	maxBytes     int64 // 0 for no limit
+	segmentBytes int64 // Size at which a new segment is started
+
+	mu       sync.Mutex
+	file     *os.File // Segment being written, nil if none
+	fileSeq  uint64
+	fileSize int64
+	nextSeq  uint64
+	pending  map[uint64]int   // Unflushed events per segment
+	sizes    map[uint64]int64 // Bytes per segment on disk
+	total    int64            // Sum of sizes
+	replay   []uint64         // Segments left by the previous run, oldest first
+}
+
+func openWAL(dir string, key []byte, maxBytes int64) (*writeAheadLog, error) {
+	codec, err := newRecordCodec(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("create wal dir: %w", err)
+	}
+
+	segments, sizes, err := listSegments(dir)
+	if err != nil {
+		return nil, fmt.Errorf("read wal dir: %w", err)
+	}
+
+	w := &writeAheadLog{
+		recordCodec:  codec,
+		dir:          dir,
+		maxBytes:     maxBytes,
+		segmentBytes: walSegmentBytes,
+		nextSeq:      1, // Zero marks events that are not logged
+		pending:      make(map[uint64]int),
+		sizes:        make(map[uint64]int64),
+		replay:       segments,
+	}
+	for i, seq := range segments {
+		w.sizes[seq] = sizes[i]
+		w.total += sizes[i]
+	}
+	if n := len(segments); n > 0 {
+		w.nextSeq = segments[n-1] + 1
+	}
+	return w, nil
+}
+
+// append logs a framed record and queues its event with send, which is
+// given the record's segment and must not block. The record is only kept
+// if send succeeds. A write error is returned with queued set: the event
+// is queued but not durable. errWALFull is returned, without calling send,
+// if the record would take the WAL beyond its size limit.
+func (w *writeAheadLog) append(record []byte, send func(seq uint64) bool) (queued bool, err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.maxBytes > 0 && w.total+int64(len(record)) > w.maxBytes {
+		return false, errWALFull
+	}
+
+	if w.file != nil && w.fileSize+int64(len(record)) > w.segmentBytes {
+		w.seal()
+	}
+	if w.file == nil {
+		seq := w.nextSeq
+		f, err := os.OpenFile(segmentPath(w.dir, seq), os.O_CREATE|os.O_EXCL|os.O_WRONLY|os.O_APPEND, 0o600)
+		if err != nil {
+			return false, fmt.Errorf("create wal segment: %w", err)
+		}
+		w.nextSeq++
+		w.file = f
+		w.fileSeq = seq
+		w.fileSize = 0
+		w.pending[seq] = 0
+	}
+
+	// Queue first so a full queue leaves nothing to replay. Workers release
+	// events under w.mu, so this event cannot be released before its
+	// record is written.
+	if !send(w.fileSeq) {
+		return false, nil
+	}
+	w.pending[w.fileSeq]++
+
+	n, err := w.file.Write(record)
+	w.fileSize += int64(n)
+	w.sizes[w.fileSeq] += int64(n)
+	w.total += int64(n)
+	return true, err
+}
+
+// release marks flushed events, given as counts per segment, and deletes
+// segments that have no unflushed events left
+func (w *writeAheadLog) release(counts map[uint64]int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for seq, n := range counts {
+		w.pending[seq] -= n
+		if w.pending[seq] > 0 {
+			continue
+		}
+		delete(w.pending, seq)
+		if w.file != nil && w.fileSeq == seq {
+			w.closeFile()
+		}
+		w.remove(seq)
+	}
+}
+
+// seal closes the segment being written; w.mu must be held
+func (w *writeAheadLog) seal() {
+	seq := w.fileSeq
+	w.closeFile()
+	if w.pending[seq] == 0 {
+		delete(w.pending, seq)
+		w.remove(seq)
+	}
+}
+
+// remove deletes a segment file; w.mu must be held
+func (w *writeAheadLog) remove(seq uint64) {
+	if err := os.Remove(segmentPath(w.dir, seq)); err != nil && !os.IsNotExist(err) {
+		slog.Error("failed to remove wal segment", "path", segmentPath(w.dir, seq), "error", err)
+		return
+	}
+	w.total -= w.sizes[seq]
+	delete(w.sizes, seq)
+}
+
+// removeReplayed deletes a segment left by the previous run once its
+// events are queued again
+func (w *writeAheadLog) removeReplayed(seq uint64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.remove(seq)
+}
+
+// sync flushes the segment being written to stable storage, so its records
+// also survive a machine crash, not just a process crash
+func (w *writeAheadLog) sync() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.file == nil {
+		return nil
+	}
+	return w.file.Sync()
+}
+
+// bytes returns the size of the WAL on disk
+func (w *writeAheadLog) bytes() int64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.total
+}
+
+func (w *writeAheadLog) closeFile() {
+	if w.file == nil {
+		return
+	}
+	if err := w.file.Close(); err != nil {
+		slog.Error("failed to close wal segment", "path", w.file.Name(), "error", err)
+	}
+	w.file = nil
+}
+
+// close stops writing. Segments with unflushed events stay on disk and are
+// replayed after the next start.
+func (w *writeAheadLog) close() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.closeFile()
+}
+
+// pushLogged logs an event and queues it without blocking. It returns false
+// if the queue is full. Events that cannot be logged are queued anyway.
+func (c *Collector[T]) pushLogged(qe queuedEvent[T]) bool {
+	send := func(seq uint64) bool {
+		qe.walSeq = seq
+		select {
+		case c.queue(qe.event) <- qe:
+			return true
+		default:
+			return false
+		}
+	}
+
+	payload, err := json.Marshal(qe.event)
+	if err == nil {
+		var record []byte
+		if record, err = c.wal.frame(payload); err == nil {
+			var queued bool
+			queued, err = c.wal.append(record, send)
+			if errors.Is(err, errWALFull) {
+				c.stats.WALSkipped.Add(1)
+				return send(0)
+			}
+			if err == nil || queued {
+				if err != nil {
+					slog.Error("failed to write wal record", "collector", c.sink.Name, "error", err)
+				}
+				return queued
+			}
+		}
+	}
+
+	slog.Error("failed to log event, queueing without wal", "collector", c.sink.Name, "error", err)
+	return send(0)
+}
+
+// replayWAL re-queues the events of segments left by the previous run. They
+// are logged again in new segments before the old ones are deleted.
+func (c *Collector[T]) replayWAL(ctx context.Context) {
+	defer c.wg.Done()
+
+	for _, seq := range c.wal.replay {
+		path := segmentPath(c.wal.dir, seq)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			slog.Error("failed to read wal segment", "collector", c.sink.Name, "path", path, "error", err)
+			continue
+		}
+
+		batchID := fmt.Sprintf("wal-%d", seq)
+		n := 0
+		for len(data) > 0 {
+			payload, rest, skipped := c.wal.unframe(data)
+			if skipped > 0 {
+				slog.Warn("skipping corrupt wal data", "collector", c.sink.Name, "path", path, "bytes", skipped)
+			}
+			data = rest
+			if payload == nil {
+				continue
+			}
+
+			var event T
+			if err := json.Unmarshal(payload, &event); err != nil {
+				slog.Warn("skipping undecodable wal event", "collector", c.sink.Name, "error", err)
+				continue
+			}
+			if err := c.requeue(ctx, batchID, event); err != nil {
+				// Stopped mid-segment; it is replayed again after restart
+				return
+			}
+			n++
+		}
+
+		c.wal.removeReplayed(seq)
+		c.stats.WALReplayed.Add(int64(n))
+		slog.Info("wal segment replayed", "collector", c.sink.Name, "events", n)
+	}
+}
+
+// syncWAL periodically flushes the WAL to stable storage until shutdown
+func (c *Collector[T]) syncWAL(ctx context.Context) {
+	defer c.wg.Done()
+
+	ticker := c.config.Clock.NewTicker(c.config.WALSyncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C():
+			if err := c.wal.sync(); err != nil {
+				slog.Error("failed to sync wal", "collector", c.sink.Name, "error", err)
+			}
+		case <-c.shutdown:
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
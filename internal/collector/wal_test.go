@@ -0,0 +1,115 @@
+package collector
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// logEvents appends events to w and returns the segment of each
+func logEvents(t *testing.T, w *writeAheadLog, events ...int) map[int]uint64 {
+	t.Helper()
+	segments := make(map[int]uint64)
+	for _, e := range events {
+		payload, _ := json.Marshal(e)
+		record, err := w.frame(payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		queued, err := w.append(record, func(seq uint64) bool {
+			segments[e] = seq
+			return true
+		})
+		if !queued || err != nil {
+			t.Fatalf("append %d: queued %v, %v", e, queued, err)
+		}
+	}
+	return segments
+}
+
+// replay starts a collector on the WAL in dir, waits until it replayed
+// want events and returns everything it flushed on drain
+func replay(t *testing.T, dir string, want int64) []int {
+	t.Helper()
+	sink := newRecordingSink()
+	c := New(BatchConfig{BatchSize: 100, FlushInterval: time.Hour, Workers: 1, WALDir: dir}, sink.sink())
+	c.Start(context.Background())
+
+	deadline := time.Now().Add(5 * time.Second)
+	for c.GetStats().WALReplayed < want {
+		if time.Now().After(deadline) {
+			t.Fatalf("replayed %d events, want %d", c.GetStats().WALReplayed, want)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if err := c.Drain(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	var flushed []int
+	for _, b := range sink.batches {
+		flushed = append(flushed, b...)
+	}
+	slices.Sort(flushed)
+	return flushed
+}
+
+func TestWALReplaysOnlyUnreleasedEvents(t *testing.T) {
+	dir := t.TempDir()
+	w, err := openWAL(filepath.Join(dir, "test"), nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Two single-digit records per segment
+	w.segmentBytes = 2 * (spillHeaderLen + 1)
+	segments := logEvents(t, w, 1, 2, 3, 4, 5, 6)
+	if segments[1] == segments[3] || segments[3] == segments[5] {
+		t.Fatalf("events not spread over segments: %v", segments)
+	}
+
+	// Flushing 1-4 releases their segments; 5 and 6 were never flushed
+	flushed := make(map[uint64]int)
+	for _, e := range []int{1, 2, 3, 4} {
+		flushed[segments[e]]++
+	}
+	w.release(flushed)
+	w.close()
+
+	if got := replay(t, dir, 2); !slices.Equal(got, []int{5, 6}) {
+		t.Fatalf("replayed %v, want [5 6]", got)
+	}
+
+	// Replayed events were logged again and released by their flush
+	entries, _ := os.ReadDir(filepath.Join(dir, "test"))
+	if len(entries) != 0 {
+		t.Errorf("%d segments left after replay", len(entries))
+	}
+}
+
+func TestWALSkipsTornLastRecord(t *testing.T) {
+	dir := t.TempDir()
+	w, err := openWAL(filepath.Join(dir, "test"), nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	segments := logEvents(t, w, 1, 2, 3)
+	w.close()
+
+	// A crash while writing the last record leaves only part of it
+	path := segmentPath(filepath.Join(dir, "test"), segments[3])
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(path, info.Size()-3); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := replay(t, dir, 2); !slices.Equal(got, []int{1, 2}) {
+		t.Fatalf("replayed %v, want [1 2]", got)
+	}
+}
@@ -1,6 +1,7 @@
 package config
 
 import (
+	"net/url"
 	"os"
 	"strconv"
 	"strings"
@@ -16,13 +17,237 @@ type Config struct {
 	AllowedOrigins []string
 	Debug          bool
 
+	// Parallel COPY: flushes spanning several hypertable chunks are split
+	// into one COPY per chunk
+	CopyParallelism int
+	CopyPartition   time.Duration // Should match chunk_time_interval
+
 	// Rate limiting
 	RateLimitEnabled bool
-	RateLimitRPS     float64 // Requests per second per IP
-	RateLimitBurst   int     // Burst size
+	RateLimitRPS     float64  // Requests per second per IP
+	RateLimitBurst   int      // Burst size
+	RateLimitKeys    []string // group=strategy[@rps/burst] entries, see middleware.ParseKeyPolicy
+
+	// Proxies whose X-Forwarded-For and X-Real-IP are believed, CIDR ranges
+	// or addresses
+	TrustedProxies []string
 
 	// Body size limit
 	MaxBodySize int64 // Max request body size in bytes
+
+	// Per-field size policies: [site/]field=action:max_bytes entries
+	FieldSizePolicies []string
+
+	// Oldest accepted event time: [site=]duration entries, 0 disables
+	MaxEventAge []string
+
+	// Enrichment of frontend events: [site/]stage[=on|off][:option=value;...]
+	// entries, see enrich.Parse
+	EnrichPipeline []string
+
+	// Game launch canaries
+	CanaryTargets         []string      // provider[/game_id]=demo_url entries, separated by whitespace
+	CanaryInterval        time.Duration // Time between canary rounds
+	CanaryTimeout         time.Duration // Per-launch timeout
+	CanaryBrowserURL      string        // DevTools endpoint of the headless Chrome games launch in
+	CanaryReadyExpression string        // JavaScript that is true once a game has launched
+
+	// NATS JetStream ingest
+	NATSURL           string // Empty disables JetStream ingest
+	NATSStream        string
+	NATSSubjectPrefix string
+	NATSDurable       string
+
+	// StatsD UDP ingest
+	StatsDAddr string // Empty disables the listener, e.g. ":8125"
+
+	// Release health (crash-free rate) alerting
+	StabilityAlertRules  []string // release[/platform]<min_pct entries
+	StabilityInterval    time.Duration
+	StabilityWindow      time.Duration
+	StabilityMinSessions int
+
+	// Threshold alert rules
+	AlertRules         []string      // [name=]metric[.aggregation][:target]<threshold|baseline±N%[@interval[/window]][!severity] entries, created if missing
+	AlertInterval      time.Duration // Evaluation interval of rules created without one
+	AlertWindow        time.Duration // Lookback window of rules created without one
+	AlertMinSamples    int
+	AlertBaselineWeeks int // Baseline history of adaptive rules created without one
+
+	// Service level objectives (defined via /api/slo)
+	SLOInterval   time.Duration
+	SLOBurnWindow time.Duration
+	SLOBurnAlert  float64 // 0 disables burn rate alerts
+
+	// Anomaly detection over the rollups
+	AnomalyThreshold float64 // z-score that raises an alert, 0 disables detection
+	AnomalyAlpha     float64 // EWMA smoothing factor
+	AnomalyHistory   time.Duration
+
+	// SDK deprecation warnings
+	SDKMinVersions []string // sdk=min_version entries, e.g. go=1.3.0
+
+	// Schema drift alerting
+	SchemaDriftAlertEvents int // Events with a drifting field within the window that raise an alert, 0 disables
+	SchemaDriftInterval    time.Duration
+	SchemaDriftWindow      time.Duration
+
+	// Disk overflow for full collector queues
+	SpillDir           string // Empty disables spilling
+	SpillMaxBytes      int64
+	SpillEncryptionKey string // 32 bytes, hex or base64; also encrypts the WAL
+
+	// Write-ahead log of accepted events
+	WALDir          string        // Empty disables the WAL
+	WALMaxBytes     int64         // Per collector; events beyond it are not logged, 0 for no limit
+	WALSyncInterval time.Duration // fsync period, 0 leaves it to the OS
+
+	// Server-side exports, encrypted and downloaded through signed URLs
+	ExportDir           string // Empty disables POST /api/exports
+	ExportEncryptionKey string // 32 bytes, hex or base64; required with ExportDir
+	ExportURLSecret     string // Signs download URLs; required with ExportDir
+	ExportTTL           time.Duration
+	ExportURLTTL        time.Duration
+
+	// Flush retries on database errors
+	FlushRetryAttempts   int
+	FlushRetryBackoff    time.Duration
+	FlushRetryMaxBackoff time.Duration
+	FlushRetryJitter     float64
+
+	// Backpressure: collect endpoints answer 429 above the high watermark
+	QueueHighWatermark float64 // Fraction of queue capacity, 0 disables
+	QueueRetryAfter    time.Duration
+
+	// Readiness: /ready fails while events cannot be persisted
+	ReadyQueueSaturation float64       // Fraction of queue capacity, 0 disables
+	ReadyQueueFor        time.Duration // How long queues must stay above it
+	ReadySpillGrowth     time.Duration // Spill growing over this window, 0 disables
+
+	// Frontend events with an event_id seen within the window are dropped
+	EventDedupeWindow time.Duration // 0 disables
+
+	// Events of one session (or player) are flushed by the same worker
+	SessionAffinity bool
+
+	// Time allowed for in-flight requests and queued events on shutdown
+	ShutdownDrainTimeout time.Duration
+
+	// Shadow writes to a candidate storage backend, compared with the primary
+	ShadowClickHouseURL      string // Empty disables shadow writes
+	ShadowClickHouseDatabase string
+	ShadowCompareWindow      time.Duration
+	ShadowDivergence         float64 // Row count difference reported as divergent, 0-1
+
+	// Health decisions for automated consumers (/api/health/decision)
+	DecisionWindow        time.Duration
+	DecisionCacheTTL      time.Duration
+	DecisionTimeout       time.Duration
+	DecisionMinSamples    int64
+	DecisionDegradedBelow float64 // Success rate, 0-1
+	DecisionDownBelow     float64
+	DecisionPublic        bool // Serve verdicts without a service account token
+	// Public routes for status pages and embeds (/public/*)
+	PublicStatusComponents []string // [label=]kind:name entries, empty disables
+	PublicAllowedOrigins   []string
+	PublicCacheTTL         time.Duration
+	PublicRateLimitRPS     float64 // Per IP, separate from RATE_LIMIT_*
+	PublicRateLimitBurst   int
+
+	// Sampling recommendations (/api/recommendations)
+	RecommendationWindow   time.Duration // Ingest inspected per analysis
+	RecommendationCacheTTL time.Duration
+
+	// Live metrics stream (/api/stream)
+	StreamInterval time.Duration // Time between pushed updates
+	StreamWindow   time.Duration // Window of the rolling aggregates
+
+	// Late data re-aggregation for continuous aggregates
+	RollupLateness        time.Duration
+	RollupRefreshInterval time.Duration
+
+	// Job scheduler
+	JobTimeout          time.Duration
+	JobFailureThreshold int // Consecutive failures before an alert fires
+
+	// Planner statistics of hot columns (table_analyze job)
+	AnalyzeSchedule         string        // Schedule of the table_analyze job
+	AnalyzeStatisticsTarget int           // Statistics target of hot columns, 1-10000
+	AnalyzeTimeout          time.Duration // Per analysis of all hot tables
+
+	// Site credentials
+	CredentialGracePeriod time.Duration // How long replaced credentials stay valid
+	RequireAPIKey         bool          // Backend collect endpoints need a credential for every site
+
+	// Data residency (site_residency)
+	DataRegion        string   // Region of this collector's storage, e.g. eu
+	ResidencyForwards []string // region=url entries, collectors requests of other regions' sites are forwarded to
+
+	// SDK remote config (site_sdk_config)
+	SDKConfigPollInterval time.Duration // How often SDKs and Go clients poll /collect/config
+
+	// Alert notifications
+	NotifyChannels       []string // Built-in channels to enable: log, slack, pagerduty, email, webhook
+	NotifyRateLimits     []string // channel=count/period entries, e.g. *=20/1h
+	NotifyQuietHours     []string // channel=HH:MM-HH:MM[@min_severity] entries
+	NotifyTimezone       string   // Time zone of quiet hours
+	NotifyDigestInterval time.Duration
+	SlackWebhooks        []string // severity=url entries, * for the other severities
+	SlackTemplate        string   // Message template, empty for the default
+	PagerDutyRoutingKey  string   // Events API v2 integration key
+	PagerDutyMinSeverity string   // Alerts below are not sent to PagerDuty
+	PagerDutyEventsURL   string
+	SMTPHost             string
+	SMTPPort             int
+	SMTPUsername         string // Empty for servers without authentication
+	SMTPPassword         string
+	SMTPFrom             string // From address of alert emails
+	SMTPTLS              string // starttls, tls or none
+	WebhookMaxAttempts   int    // Attempts per alert and webhook
+	WebhookBackoff       time.Duration
+	DashboardURL         string // Base URL of the dashboard for links in notifications
+
+	// Google login: ID tokens must be issued to this OAuth client
+	GoogleClientID string // Empty disables Google login
+
+	// Email domains allowed to log in with Google or OIDC, "*" for any
+	AllowedEmailDomains []string
+
+	// Login with a generic OIDC provider (Okta, Azure AD, Keycloak) using
+	// the authorization code flow with PKCE
+	OIDCIssuerURL            string // Empty disables OIDC login
+	OIDCClientID             string
+	OIDCClientSecret         string // Empty for public clients
+	OIDCRedirectURL          string // The collector's /api/auth/oidc/callback
+	OIDCDashboardURL         string // Where the browser returns after login
+	OIDCScopes               []string
+	OIDCRequireVerifiedEmail bool
+
+	// Dashboard metrics and alerts need a login, scoped to the user's sites
+	DashboardAuthRequired bool
+
+	// Log dashboard API queries to query_log for /api/admin/query-stats
+	QueryLogEnabled bool
+
+	// Raw metric queries (percentiles, campaign breakdowns) running longer
+	// are answered from the rollups, marked degraded; 0 waits for them
+	RawQueryTimeout time.Duration
+
+	// Dashboard sessions: access tokens are refreshed with a refresh token,
+	// whose lifetime restarts on every refresh
+	AccessTokenTTL  time.Duration
+	RefreshTokenTTL time.Duration
+
+	// Binding of sessions to the client's IP prefix and User-Agent: off,
+	// warn (audit log, session follows the client) or enforce (session ends)
+	SessionBinding           string
+	SessionBindingIPv4Prefix int
+	SessionBindingIPv6Prefix int
+
+	// Shared state for collectors behind a load balancer: with "redis",
+	// sessions and rate limit buckets are kept in REDIS_URL
+	SessionStore string // postgres or redis
+	RedisURL     string // redis://[:password@]host:port/db
 }
 
 func Load() *Config {
@@ -35,13 +260,190 @@ func Load() *Config {
 		AllowedOrigins: getEnvSlice("ALLOWED_ORIGINS", []string{"*"}),
 		Debug:          getEnvBool("DEBUG", false),
 
+		CopyParallelism: getEnvInt("COPY_PARALLELISM", 4),
+		CopyPartition:   getEnvDuration("COPY_PARTITION_INTERVAL", 24*time.Hour),
+
 		// Rate limiting defaults: 100 req/s per IP, burst of 200
 		RateLimitEnabled: getEnvBool("RATE_LIMIT_ENABLED", true),
 		RateLimitRPS:     getEnvFloat("RATE_LIMIT_RPS", 100),
 		RateLimitBurst:   getEnvInt("RATE_LIMIT_BURST", 200),
+		RateLimitKeys:    getEnvSlice("RATE_LIMIT_KEYS", nil),
+
+		// Loopback and private ranges, where load balancers usually run
+		TrustedProxies: getEnvSlice("TRUSTED_PROXIES", []string{"127.0.0.0/8", "10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "::1/128", "fc00::/7"}),
 
 		// Body size limit: 1MB default
 		MaxBodySize: getEnvInt64("MAX_BODY_SIZE", 1<<20),
+
+		// Oversized metadata is truncated by default (see quality.DefaultPolicies)
+		FieldSizePolicies: getEnvSlice("FIELD_SIZE_POLICIES", nil),
+
+		// Older events are rejected except via /collect/backfill
+		MaxEventAge: getEnvSlice("MAX_EVENT_AGE", []string{"168h"}),
+
+		// Country from CDN headers, browser and device type from the user agent
+		EnrichPipeline: getEnvSlice("ENRICH_PIPELINE", []string{"geoip", "user_agent"}),
+
+		// Canaries are disabled unless targets are configured
+		CanaryTargets:         getEnvFields("CANARY_TARGETS", nil),
+		CanaryInterval:        getEnvDuration("CANARY_INTERVAL", 5*time.Minute),
+		CanaryTimeout:         getEnvDuration("CANARY_TIMEOUT", 15*time.Second),
+		CanaryBrowserURL:      getEnv("CANARY_BROWSER_URL", ""),
+		CanaryReadyExpression: getEnv("CANARY_READY_EXPRESSION", ""),
+
+		NATSURL:           getEnv("NATS_URL", ""),
+		NATSStream:        getEnv("NATS_STREAM", "PULSE"),
+		NATSSubjectPrefix: getEnv("NATS_SUBJECT_PREFIX", "pulse"),
+		NATSDurable:       getEnv("NATS_DURABLE", "pulse-collector"),
+
+		StatsDAddr: getEnv("STATSD_ADDR", ""),
+
+		StabilityAlertRules:  getEnvSlice("STABILITY_ALERT_RULES", nil),
+		StabilityInterval:    getEnvDuration("STABILITY_INTERVAL", time.Minute),
+		StabilityWindow:      getEnvDuration("STABILITY_WINDOW", time.Hour),
+		StabilityMinSessions: getEnvInt("STABILITY_MIN_SESSIONS", 100),
+
+		AlertRules:         getEnvSlice("ALERT_RULES", nil),
+		AlertInterval:      getEnvDuration("ALERT_INTERVAL", time.Minute),
+		AlertWindow:        getEnvDuration("ALERT_WINDOW", 5*time.Minute),
+		AlertMinSamples:    getEnvInt("ALERT_MIN_SAMPLES", 20),
+		AlertBaselineWeeks: getEnvInt("ALERT_BASELINE_WEEKS", 4),
+
+		SLOInterval:   getEnvDuration("SLO_INTERVAL", 5*time.Minute),
+		SLOBurnWindow: getEnvDuration("SLO_BURN_WINDOW", time.Hour),
+		SLOBurnAlert:  getEnvFloat("SLO_BURN_ALERT", 14.4),
+
+		AnomalyThreshold: getEnvFloat("ANOMALY_THRESHOLD", 4),
+		AnomalyAlpha:     getEnvFloat("ANOMALY_ALPHA", 0.05),
+		AnomalyHistory:   getEnvDuration("ANOMALY_HISTORY", 24*time.Hour),
+
+		SDKMinVersions: getEnvSlice("SDK_MIN_VERSIONS", nil),
+
+		SchemaDriftAlertEvents: getEnvInt("SCHEMA_DRIFT_ALERT_EVENTS", 0),
+		SchemaDriftInterval:    getEnvDuration("SCHEMA_DRIFT_INTERVAL", time.Minute),
+		SchemaDriftWindow:      getEnvDuration("SCHEMA_DRIFT_WINDOW", 15*time.Minute),
+
+		SpillDir:           getEnv("SPILL_DIR", ""),
+		SpillMaxBytes:      getEnvInt64("SPILL_MAX_BYTES", 1<<30),
+		SpillEncryptionKey: getEnv("SPILL_ENCRYPTION_KEY", ""),
+
+		WALDir:          getEnv("WAL_DIR", ""),
+		WALMaxBytes:     getEnvInt64("WAL_MAX_BYTES", 1<<30),
+		WALSyncInterval: getEnvDuration("WAL_SYNC_INTERVAL", 0),
+
+		ExportDir:           getEnv("EXPORT_DIR", ""),
+		ExportEncryptionKey: getEnv("EXPORT_ENCRYPTION_KEY", ""),
+		ExportURLSecret:     getEnv("EXPORT_URL_SECRET", ""),
+		ExportTTL:           getEnvDuration("EXPORT_TTL", 24*time.Hour),
+		ExportURLTTL:        getEnvDuration("EXPORT_URL_TTL", time.Hour),
+
+		FlushRetryAttempts:   getEnvInt("FLUSH_RETRY_ATTEMPTS", 5),
+		FlushRetryBackoff:    getEnvDuration("FLUSH_RETRY_BACKOFF", 500*time.Millisecond),
+		FlushRetryMaxBackoff: getEnvDuration("FLUSH_RETRY_MAX_BACKOFF", 15*time.Second),
+		FlushRetryJitter:     getEnvFloat("FLUSH_RETRY_JITTER", 0.2),
+
+		QueueHighWatermark: getEnvFloat("QUEUE_HIGH_WATERMARK", 0.8),
+		QueueRetryAfter:    getEnvDuration("QUEUE_RETRY_AFTER", 5*time.Second),
+
+		ReadyQueueSaturation: getEnvFloat("READY_QUEUE_SATURATION", 0),
+		ReadyQueueFor:        getEnvDuration("READY_QUEUE_FOR", 30*time.Second),
+		ReadySpillGrowth:     getEnvDuration("READY_SPILL_GROWTH", 0),
+
+		EventDedupeWindow: getEnvDuration("EVENT_DEDUPE_WINDOW", 10*time.Minute),
+
+		SessionAffinity: getEnvBool("SESSION_AFFINITY", false),
+
+		ShutdownDrainTimeout: getEnvDuration("SHUTDOWN_DRAIN_TIMEOUT", 30*time.Second),
+
+		ShadowClickHouseURL:      getEnv("SHADOW_CLICKHOUSE_URL", ""),
+		ShadowClickHouseDatabase: getEnv("SHADOW_CLICKHOUSE_DATABASE", "pulse"),
+		ShadowCompareWindow:      getEnvDuration("SHADOW_COMPARE_WINDOW", 10*time.Minute),
+		ShadowDivergence:         getEnvFloat("SHADOW_DIVERGENCE", 0.001),
+
+		DecisionWindow:        getEnvDuration("HEALTH_DECISION_WINDOW", 5*time.Minute),
+		DecisionCacheTTL:      getEnvDuration("HEALTH_DECISION_CACHE_TTL", 10*time.Second),
+		DecisionTimeout:       getEnvDuration("HEALTH_DECISION_TIMEOUT", 250*time.Millisecond),
+		DecisionMinSamples:    getEnvInt64("HEALTH_DECISION_MIN_SAMPLES", 20),
+		DecisionDegradedBelow: getEnvFloat("HEALTH_DECISION_DEGRADED_BELOW", 0.95),
+		DecisionDownBelow:     getEnvFloat("HEALTH_DECISION_DOWN_BELOW", 0.8),
+		DecisionPublic:        getEnvBool("HEALTH_DECISION_PUBLIC", false),
+
+		PublicStatusComponents: getEnvSlice("PUBLIC_STATUS_COMPONENTS", nil),
+		PublicAllowedOrigins:   getEnvSlice("PUBLIC_ALLOWED_ORIGINS", []string{"*"}),
+		PublicCacheTTL:         getEnvDuration("PUBLIC_CACHE_TTL", 30*time.Second),
+		PublicRateLimitRPS:     getEnvFloat("PUBLIC_RATE_LIMIT_RPS", 5),
+		PublicRateLimitBurst:   getEnvInt("PUBLIC_RATE_LIMIT_BURST", 20),
+
+		RecommendationWindow:   getEnvDuration("RECOMMENDATION_WINDOW", time.Hour),
+		RecommendationCacheTTL: getEnvDuration("RECOMMENDATION_CACHE_TTL", 15*time.Minute),
+
+		StreamInterval: getEnvDuration("STREAM_INTERVAL", 5*time.Second),
+		StreamWindow:   getEnvDuration("STREAM_WINDOW", time.Minute),
+
+		RollupLateness:        getEnvDuration("ROLLUP_LATENESS", 24*time.Hour),
+		RollupRefreshInterval: getEnvDuration("ROLLUP_REFRESH_INTERVAL", time.Minute),
+
+		JobTimeout:          getEnvDuration("JOB_TIMEOUT", 5*time.Minute),
+		JobFailureThreshold: getEnvInt("JOB_FAILURE_THRESHOLD", 3),
+
+		AnalyzeSchedule:         getEnv("ANALYZE_SCHEDULE", "30 4 * * *"),
+		AnalyzeStatisticsTarget: getEnvInt("ANALYZE_STATISTICS_TARGET", 1000),
+		AnalyzeTimeout:          getEnvDuration("ANALYZE_TIMEOUT", time.Hour),
+
+		CredentialGracePeriod: getEnvDuration("CREDENTIAL_GRACE_PERIOD", 24*time.Hour),
+		RequireAPIKey:         getEnvBool("REQUIRE_API_KEY", false),
+
+		DataRegion:        getEnv("DATA_REGION", ""),
+		ResidencyForwards: getEnvSlice("RESIDENCY_FORWARD", nil),
+
+		SDKConfigPollInterval: getEnvDuration("SDK_CONFIG_POLL_INTERVAL", 5*time.Minute),
+
+		NotifyChannels:       getEnvSlice("NOTIFY_CHANNELS", nil),
+		NotifyRateLimits:     getEnvSlice("NOTIFY_RATE_LIMITS", nil),
+		NotifyQuietHours:     getEnvSlice("NOTIFY_QUIET_HOURS", nil),
+		NotifyTimezone:       getEnv("NOTIFY_TIMEZONE", "UTC"),
+		NotifyDigestInterval: getEnvDuration("NOTIFY_DIGEST_INTERVAL", 5*time.Minute),
+		SlackWebhooks:        getEnvSlice("SLACK_WEBHOOKS", nil),
+		SlackTemplate:        getEnv("SLACK_TEMPLATE", ""),
+		PagerDutyRoutingKey:  getEnv("PAGERDUTY_ROUTING_KEY", ""),
+		PagerDutyMinSeverity: getEnv("PAGERDUTY_MIN_SEVERITY", "critical"),
+		PagerDutyEventsURL:   getEnv("PAGERDUTY_EVENTS_URL", "https://events.pagerduty.com/v2/enqueue"),
+		SMTPHost:             getEnv("SMTP_HOST", ""),
+		SMTPPort:             getEnvInt("SMTP_PORT", 587),
+		SMTPUsername:         getEnv("SMTP_USERNAME", ""),
+		SMTPPassword:         getEnv("SMTP_PASSWORD", ""),
+		SMTPFrom:             getEnv("SMTP_FROM", ""),
+		SMTPTLS:              getEnv("SMTP_TLS", "starttls"),
+		WebhookMaxAttempts:   getEnvInt("WEBHOOK_MAX_ATTEMPTS", 3),
+		WebhookBackoff:       getEnvDuration("WEBHOOK_BACKOFF", time.Second),
+		DashboardURL:         getEnv("DASHBOARD_URL", ""),
+
+		GoogleClientID:      getEnv("GOOGLE_CLIENT_ID", ""),
+		AllowedEmailDomains: getEnvSlice("ALLOWED_EMAIL_DOMAINS", []string{"starcrown.partners"}),
+
+		OIDCIssuerURL:            getEnv("OIDC_ISSUER_URL", ""),
+		OIDCClientID:             getEnv("OIDC_CLIENT_ID", ""),
+		OIDCClientSecret:         getEnv("OIDC_CLIENT_SECRET", ""),
+		OIDCRedirectURL:          getEnv("OIDC_REDIRECT_URL", ""),
+		OIDCDashboardURL:         getEnv("OIDC_DASHBOARD_URL", ""),
+		OIDCScopes:               getEnvSlice("OIDC_SCOPES", []string{"openid", "email", "profile"}),
+		OIDCRequireVerifiedEmail: getEnvBool("OIDC_REQUIRE_VERIFIED_EMAIL", true),
+
+		DashboardAuthRequired: getEnvBool("DASHBOARD_AUTH_REQUIRED", true),
+
+		QueryLogEnabled: getEnvBool("QUERY_LOG_ENABLED", true),
+
+		RawQueryTimeout: getEnvDuration("RAW_QUERY_TIMEOUT", 10*time.Second),
+
+		AccessTokenTTL:  getEnvDuration("ACCESS_TOKEN_TTL", time.Hour),
+		RefreshTokenTTL: getEnvDuration("REFRESH_TOKEN_TTL", 7*24*time.Hour),
+
+		SessionBinding:           getEnv("SESSION_BINDING", "off"),
+		SessionBindingIPv4Prefix: getEnvInt("SESSION_BINDING_IPV4_PREFIX", 24),
+		SessionBindingIPv6Prefix: getEnvInt("SESSION_BINDING_IPV6_PREFIX", 64),
+
+		SessionStore: getEnv("SESSION_STORE", "postgres"),
+		RedisURL:     getEnv("REDIS_URL", ""),
 	}
 }
 
@@ -86,6 +488,15 @@ func getEnvSlice(key string, defaultVal []string) []string {
 	return defaultVal
 }
 
+// getEnvFields splits on whitespace, for lists whose entries may contain
+// commas, such as URLs
+func getEnvFields(key string, defaultVal []string) []string {
+	if val := os.Getenv(key); val != "" {
+		return strings.Fields(val)
+	}
+	return defaultVal
+}
+
 func getEnvFloat(key string, defaultVal float64) float64 {
 	if val := os.Getenv(key); val != "" {
 		if f, err := strconv.ParseFloat(val, 64); err == nil {
@@ -103,3 +514,77 @@ func getEnvInt64(key string, defaultVal int64) int64 {
 	}
 	return defaultVal
 }
+
+// redactedValue replaces secrets in Redacted
+const redactedValue = "REDACTED"
+
+// Redacted returns a copy of the config that is safe to share, e.g. in a
+// support bundle: keys and passwords are replaced, and credentials are
+// removed from URLs
+func (c *Config) Redacted() Config {
+	r := *c
+	r.DatabaseURL = redactURL(c.DatabaseURL)
+	r.NATSURL = redactURL(c.NATSURL)
+	r.ShadowClickHouseURL = redactURL(c.ShadowClickHouseURL)
+	r.RedisURL = redactURL(c.RedisURL)
+	r.SpillEncryptionKey = redactSecret(c.SpillEncryptionKey)
+	r.ExportEncryptionKey = redactSecret(c.ExportEncryptionKey)
+	r.ExportURLSecret = redactSecret(c.ExportURLSecret)
+	r.PagerDutyRoutingKey = redactSecret(c.PagerDutyRoutingKey)
+	r.SMTPPassword = redactSecret(c.SMTPPassword)
+	r.OIDCClientSecret = redactSecret(c.OIDCClientSecret)
+	r.SlackWebhooks = redactEntries(c.SlackWebhooks, func(string) string { return redactedValue })
+	r.ResidencyForwards = redactEntries(c.ResidencyForwards, redactURL)
+	return r
+}
+
+// redactSecret replaces a set secret, keeping empty ones empty so the
+// bundle still shows what is not configured
+func redactSecret(s string) string {
+	if s == "" {
+		return ""
+	}
+	return redactedValue
+}
+
+// redactURL removes the password of a URL, or the whole URL if it cannot
+// be parsed
+func redactURL(s string) string {
+	if s == "" {
+		return ""
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return redactedValue
+	}
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), redactedValue)
+	}
+	q, changed := u.Query(), false
+	for key := range q {
+		if k := strings.ToLower(key); strings.Contains(k, "password") || strings.Contains(k, "secret") || strings.Contains(k, "token") {
+			q.Set(key, redactedValue)
+			changed = true
+		}
+	}
+	if changed {
+		u.RawQuery = q.Encode()
+	}
+	return u.String()
+}
+
+// redactEntries applies redact to the values of key=value entries
+func redactEntries(entries []string, redact func(string) string) []string {
+	if entries == nil {
+		return nil
+	}
+	out := make([]string, len(entries))
+	for i, e := range entries {
+		if key, value, ok := strings.Cut(e, "="); ok {
+			out[i] = key + "=" + redact(value)
+		} else {
+			out[i] = redact(e)
+		}
+	}
+	return out
+}
@@ -0,0 +1,313 @@
+// Package enrich runs the server-side enrichment of frontend events as an
+// ordered pipeline of stages (GeoIP, user agent parsing, bot detection, PII
+// scrubbing, path templating), configured once with per-site toggles and
+// options, and times every stage.
+package enrich
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mcbile/product-pulse/internal/model"
+)
+
+// DefaultPipeline is the pipeline when none is configured
+var DefaultPipeline = []string{"geoip", "user_agent"}
+
+// Request is what stages know about the request that carried the events
+type Request struct {
+	IP        string
+	UserAgent string
+	Header    http.Header
+}
+
+// Stage enriches one event at a time. Enrich returns false to drop the
+// event.
+type Stage interface {
+	Enrich(req Request, e *model.EnrichedEvent) bool
+}
+
+// stages creates each known stage from its options
+var stages = map[string]func(options map[string]string) (Stage, error){
+	"geoip":         newGeoIP,
+	"user_agent":    newUserAgent,
+	"bot":           newBot,
+	"pii":           newPII,
+	"path_template": newPathTemplate,
+}
+
+// StageConfig is one configured stage
+type StageConfig struct {
+	Name    string            `json:"name"`
+	Enabled bool              `json:"enabled"`
+	Options map[string]string `json:"options,omitempty"`
+}
+
+// StageStat is the work of one stage for a site since startup
+type StageStat struct {
+	SiteID     string  `json:"site_id"`
+	Stage      string  `json:"stage"`
+	Batches    int64   `json:"batches"`
+	Events     int64   `json:"events"`
+	Dropped    int64   `json:"dropped"`
+	TotalMS    float64 `json:"total_ms"`
+	AvgEventUS float64 `json:"avg_event_us"`
+	MaxBatchMS float64 `json:"max_batch_ms"`
+}
+
+type statKey struct {
+	site  string
+	stage string
+}
+
+type stageStat struct {
+	batches, events, dropped int64
+	total, max               time.Duration
+}
+
+type runner struct {
+	name  string
+	stage Stage
+}
+
+// Pipeline runs the configured stages in order. Sites without overrides
+// share the default stages. A nil Pipeline runs no stages.
+type Pipeline struct {
+	defaults []StageConfig
+	sites    map[string][]StageConfig
+
+	runners     []runner
+	siteRunners map[string][]runner
+
+	mu    sync.Mutex
+	stats map[statKey]*stageStat
+}
+
+// Parse parses the pipeline from entries in the form
+// [site/]stage[=on|off][:option=value;...], e.g.
+// "geoip:header=CF-IPCountry,user_agent,bot=off,casino-prod/bot=on:action=tag".
+// Entries without a site define the stages and their order; site entries
+// switch those stages on or off for a site and override their options.
+func Parse(entries []string) (*Pipeline, error) {
+	p := &Pipeline{
+		sites:       make(map[string][]StageConfig),
+		siteRunners: make(map[string][]runner),
+		stats:       make(map[statKey]*stageStat),
+	}
+
+	type override struct {
+		site string
+		cfg  StageConfig
+	}
+	var overrides []override
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		site, cfg, err := parseEntry(entry)
+		if err != nil {
+			return nil, err
+		}
+		if site != "" {
+			overrides = append(overrides, override{site, cfg})
+			continue
+		}
+		if indexOf(p.defaults, cfg.Name) >= 0 {
+			return nil, fmt.Errorf("enrichment stage %q listed twice", cfg.Name)
+		}
+		p.defaults = append(p.defaults, cfg)
+	}
+
+	for _, o := range overrides {
+		stages, ok := p.sites[o.site]
+		if !ok {
+			stages = cloneConfigs(p.defaults)
+		}
+		i := indexOf(stages, o.cfg.Name)
+		if i < 0 {
+			return nil, fmt.Errorf("enrichment stage %q of site %s is not in the pipeline", o.cfg.Name, o.site)
+		}
+		stages[i].Enabled = o.cfg.Enabled
+		for k, v := range o.cfg.Options {
+			stages[i].Options[k] = v
+		}
+		p.sites[o.site] = stages
+	}
+
+	var err error
+	if p.runners, err = build(p.defaults); err != nil {
+		return nil, err
+	}
+	for site, stages := range p.sites {
+		if p.siteRunners[site], err = build(stages); err != nil {
+			return nil, fmt.Errorf("site %s: %w", site, err)
+		}
+	}
+	return p, nil
+}
+
+// parseEntry parses one [site/]stage[=on|off][:option=value;...] entry
+func parseEntry(entry string) (string, StageConfig, error) {
+	head, options, _ := strings.Cut(entry, ":")
+	target, state, hasState := strings.Cut(head, "=")
+	site, name, hasSite := strings.Cut(strings.TrimSpace(target), "/")
+	if !hasSite {
+		site, name = "", site
+	}
+	cfg := StageConfig{Name: strings.TrimSpace(name), Enabled: true, Options: make(map[string]string)}
+	if _, ok := stages[cfg.Name]; !ok {
+		return "", cfg, fmt.Errorf("unknown enrichment stage %q in %q", cfg.Name, entry)
+	}
+	if hasState {
+		switch strings.TrimSpace(state) {
+		case "on":
+		case "off":
+			cfg.Enabled = false
+		default:
+			return "", cfg, fmt.Errorf("invalid state %q in enrichment stage %q, expected on or off", state, entry)
+		}
+	}
+	for _, option := range strings.Split(options, ";") {
+		if option = strings.TrimSpace(option); option == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(option, "=")
+		if !ok {
+			return "", cfg, fmt.Errorf("invalid option %q in enrichment stage %q, expected option=value", option, entry)
+		}
+		cfg.Options[strings.TrimSpace(k)] = strings.TrimSpace(v)
+	}
+	return strings.TrimSpace(site), cfg, nil
+}
+
+func build(configs []StageConfig) ([]runner, error) {
+	var runners []runner
+	for _, cfg := range configs {
+		if !cfg.Enabled {
+			continue
+		}
+		stage, err := stages[cfg.Name](cfg.Options)
+		if err != nil {
+			return nil, fmt.Errorf("enrichment stage %s: %w", cfg.Name, err)
+		}
+		runners = append(runners, runner{name: cfg.Name, stage: stage})
+	}
+	return runners, nil
+}
+
+func cloneConfigs(configs []StageConfig) []StageConfig {
+	clone := make([]StageConfig, len(configs))
+	for i, cfg := range configs {
+		clone[i] = cfg
+		clone[i].Options = make(map[string]string, len(cfg.Options))
+		for k, v := range cfg.Options {
+			clone[i].Options[k] = v
+		}
+	}
+	return clone
+}
+
+func indexOf(configs []StageConfig, name string) int {
+	for i, cfg := range configs {
+		if cfg.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// Run passes the events of a request through the site's stages, in
+// order, and returns the events no stage dropped
+func (p *Pipeline) Run(site string, req Request, events []model.EnrichedEvent) []model.EnrichedEvent {
+	if p == nil {
+		return events
+	}
+	runners, ok := p.siteRunners[site]
+	if !ok {
+		runners = p.runners
+	}
+
+	for _, r := range runners {
+		start := time.Now()
+		in := len(events)
+		kept := events[:0]
+		for i := range events {
+			if r.stage.Enrich(req, &events[i]) {
+				kept = append(kept, events[i])
+			}
+		}
+		events = kept
+		p.record(site, r.name, in, in-len(kept), time.Since(start))
+	}
+	return events
+}
+
+func (p *Pipeline) record(site, stage string, events, dropped int, took time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s := p.stats[statKey{site, stage}]
+	if s == nil {
+		s = &stageStat{}
+		p.stats[statKey{site, stage}] = s
+	}
+	s.batches++
+	s.events += int64(events)
+	s.dropped += int64(dropped)
+	s.total += took
+	s.max = max(s.max, took)
+}
+
+// Stages returns the default stages, in order, with their state and options
+func (p *Pipeline) Stages() []StageConfig {
+	if p == nil {
+		return []StageConfig{}
+	}
+	return p.defaults
+}
+
+// SiteStages returns the stages of sites with overrides
+func (p *Pipeline) SiteStages() map[string][]StageConfig {
+	if p == nil {
+		return map[string][]StageConfig{}
+	}
+	return p.sites
+}
+
+// Stats returns the work of every stage since startup, ordered by site
+// and pipeline order
+func (p *Pipeline) Stats() []StageStat {
+	if p == nil {
+		return []StageStat{}
+	}
+	p.mu.Lock()
+	stats := make([]StageStat, 0, len(p.stats))
+	for k, s := range p.stats {
+		stat := StageStat{
+			SiteID:     k.site,
+			Stage:      k.stage,
+			Batches:    s.batches,
+			Events:     s.events,
+			Dropped:    s.dropped,
+			TotalMS:    float64(s.total.Microseconds()) / 1000,
+			MaxBatchMS: float64(s.max.Microseconds()) / 1000,
+		}
+		if s.events > 0 {
+			stat.AvgEventUS = float64(s.total.Nanoseconds()) / float64(s.events) / 1000
+		}
+		stats = append(stats, stat)
+	}
+	p.mu.Unlock()
+
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].SiteID != stats[j].SiteID {
+			return stats[i].SiteID < stats[j].SiteID
+		}
+		return indexOf(p.defaults, stats[i].Stage) < indexOf(p.defaults, stats[j].Stage)
+	})
+	return stats
+}
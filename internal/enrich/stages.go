@@ -0,0 +1,301 @@
+package enrich
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/mcbile/product-pulse/internal/model"
+)
+
+// ============================================
+// GEOIP
+// ============================================
+
+// countryHeaders are set by CDNs and proxies in front of the collector
+var countryHeaders = []string{"CF-IPCountry", "CloudFront-Viewer-Country", "X-Country-Code"}
+
+// geoIP sets the country of events without one from the country header
+// of the CDN or proxy that resolved the client IP
+type geoIP struct {
+	headers []string
+}
+
+func newGeoIP(options map[string]string) (Stage, error) {
+	s := &geoIP{headers: countryHeaders}
+	for k, v := range options {
+		switch k {
+		case "header":
+			s.headers = []string{v}
+		default:
+			return nil, fmt.Errorf("unknown option %q", k)
+		}
+	}
+	return s, nil
+}
+
+func (s *geoIP) Enrich(req Request, e *model.EnrichedEvent) bool {
+	if e.FrontendEvent.Country != nil && *e.FrontendEvent.Country != "" {
+		return true
+	}
+	for _, h := range s.headers {
+		country := strings.ToUpper(strings.TrimSpace(req.Header.Get(h)))
+		// XX is unknown and T1 Tor at Cloudflare
+		if len(country) != 2 || country == "XX" || country == "T1" || !isLetters(country) {
+			continue
+		}
+		e.Country = country
+		e.FrontendEvent.Country = &country
+		return true
+	}
+	return true
+}
+
+func isLetters(s string) bool {
+	for _, c := range s {
+		if c < 'A' || c > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+// ============================================
+// USER AGENT
+// ============================================
+
+// browsers are matched in order, since most user agents name several
+var browsers = []struct{ token, name string }{
+	{"Edg/", "Edge"},
+	{"OPR/", "Opera"},
+	{"SamsungBrowser/", "Samsung Internet"},
+	{"Firefox/", "Firefox"},
+	{"FxiOS/", "Firefox"},
+	{"CriOS/", "Chrome"},
+	{"Chrome/", "Chrome"},
+	{"Safari/", "Safari"},
+}
+
+// userAgent fills in the browser and device type of events whose SDK did
+// not report them
+type userAgent struct{}
+
+func newUserAgent(options map[string]string) (Stage, error) {
+	for k := range options {
+		return nil, fmt.Errorf("unknown option %q", k)
+	}
+	return userAgent{}, nil
+}
+
+func (userAgent) Enrich(req Request, e *model.EnrichedEvent) bool {
+	if req.UserAgent == "" {
+		return true
+	}
+	if e.Browser == "" {
+		e.Browser = "Other"
+		for _, b := range browsers {
+			if strings.Contains(req.UserAgent, b.token) {
+				e.Browser = b.name
+				break
+			}
+		}
+	}
+	if e.DeviceType == "" {
+		e.DeviceType = deviceType(req.UserAgent)
+	}
+	return true
+}
+
+func deviceType(ua string) string {
+	switch {
+	case strings.Contains(ua, "iPad") || strings.Contains(ua, "Tablet") ||
+		(strings.Contains(ua, "Android") && !strings.Contains(ua, "Mobile")):
+		return "tablet"
+	case strings.Contains(ua, "Mobi") || strings.Contains(ua, "iPhone") || strings.Contains(ua, "Android"):
+		return "mobile"
+	}
+	return "desktop"
+}
+
+// ============================================
+// BOT DETECTION
+// ============================================
+
+// botPattern matches crawlers, monitoring and headless browsers
+var botPattern = regexp.MustCompile(`(?i)bot\b|crawl|spider|slurp|headless|lighthouse|pingdom|uptime|python-requests|curl/|wget/|go-http-client|java/|okhttp`)
+
+// bot drops events sent by bots, or with action=tag keeps them with
+// device type "bot" so they can be filtered out of dashboards
+type bot struct {
+	tag bool
+}
+
+func newBot(options map[string]string) (Stage, error) {
+	s := &bot{}
+	for k, v := range options {
+		switch {
+		case k == "action" && v == "drop":
+		case k == "action" && v == "tag":
+			s.tag = true
+		case k == "action":
+			return nil, fmt.Errorf("invalid action %q, expected drop or tag", v)
+		default:
+			return nil, fmt.Errorf("unknown option %q", k)
+		}
+	}
+	return s, nil
+}
+
+func (s *bot) Enrich(req Request, e *model.EnrichedEvent) bool {
+	if !botPattern.MatchString(req.UserAgent) {
+		return true
+	}
+	if !s.tag {
+		return false
+	}
+	e.DeviceType = "bot"
+	return true
+}
+
+// ============================================
+// PII SCRUBBING
+// ============================================
+
+var (
+	emailPattern  = regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)
+	digitsPattern = regexp.MustCompile(`\d(?:[ -]?\d){12,18}`)
+)
+
+// pii replaces email addresses and card numbers in page paths and
+// metadata string values with [email] and [card]
+type pii struct{}
+
+func newPII(options map[string]string) (Stage, error) {
+	for k := range options {
+		return nil, fmt.Errorf("unknown option %q", k)
+	}
+	return pii{}, nil
+}
+
+func (pii) Enrich(req Request, e *model.EnrichedEvent) bool {
+	e.PagePath = scrub(e.PagePath)
+	if len(e.Metadata) == 0 {
+		return true
+	}
+	// Numbers stay as sent, not rounded through float64
+	dec := json.NewDecoder(bytes.NewReader(e.Metadata))
+	dec.UseNumber()
+	var metadata interface{}
+	if err := dec.Decode(&metadata); err != nil {
+		return true
+	}
+	if scrubbed, err := json.Marshal(scrubValue(metadata)); err == nil {
+		e.Metadata = scrubbed
+	}
+	return true
+}
+
+func scrubValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case string:
+		return scrub(v)
+	case map[string]interface{}:
+		for k, item := range v {
+			v[k] = scrubValue(item)
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = scrubValue(item)
+		}
+	}
+	return v
+}
+
+func scrub(s string) string {
+	s = emailPattern.ReplaceAllString(s, "[email]")
+	return digitsPattern.ReplaceAllStringFunc(s, func(match string) string {
+		// Card numbers start with 2 to 6
+		if match[0] >= '2' && match[0] <= '6' && luhn(match) {
+			return "[card]"
+		}
+		return match
+	})
+}
+
+// luhn reports whether the digits of s pass the Luhn checksum of card
+// numbers, so order IDs and timestamps are left alone
+func luhn(s string) bool {
+	sum, double := 0, false
+	for i := len(s) - 1; i >= 0; i-- {
+		if s[i] < '0' || s[i] > '9' {
+			continue
+		}
+		d := int(s[i] - '0')
+		if double {
+			if d *= 2; d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+	return sum%10 == 0
+}
+
+// ============================================
+// PATH TEMPLATING
+// ============================================
+
+// idSegment matches path segments that are IDs: numbers, UUIDs and long
+// tokens with digits
+var idSegment = regexp.MustCompile(`^(?:\d+|[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}|[A-Za-z0-9_-]*\d[A-Za-z0-9_-]*)$`)
+
+// pathTemplate turns page paths into templates, "/game/8812/play?x=1"
+// into "/game/:id/play", so pages group in dashboards regardless of IDs.
+// Tokens with digits count as IDs from min_id_length (default 12)
+// characters.
+type pathTemplate struct {
+	minIDLength int
+}
+
+func newPathTemplate(options map[string]string) (Stage, error) {
+	s := &pathTemplate{minIDLength: 12}
+	for k, v := range options {
+		switch k {
+		case "min_id_length":
+			if _, err := fmt.Sscanf(v, "%d", &s.minIDLength); err != nil || s.minIDLength < 1 {
+				return nil, fmt.Errorf("invalid min_id_length %q", v)
+			}
+		default:
+			return nil, fmt.Errorf("unknown option %q", k)
+		}
+	}
+	return s, nil
+}
+
+func (s *pathTemplate) Enrich(req Request, e *model.EnrichedEvent) bool {
+	path, _, _ := strings.Cut(e.PagePath, "?")
+	path, _, _ = strings.Cut(path, "#")
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if segment == "" || !idSegment.MatchString(segment) {
+			continue
+		}
+		if isDigits(segment) || len(segment) == 36 || len(segment) >= s.minIDLength {
+			segments[i] = ":id"
+		}
+	}
+	e.PagePath = strings.Join(segments, "/")
+	return true
+}
+
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,183 @@
+package export
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Cipher encrypts artifacts as they are written and decrypts them as they
+// are read, so exports of any size are never held in memory
+type Cipher interface {
+	EncryptWriter(w io.Writer) (io.WriteCloser, error)
+	DecryptReader(r io.Reader) (io.Reader, error)
+}
+
+// Artifacts are sealed in chunks: a header of magic and a random nonce
+// prefix, then per chunk a final flag, the sealed length and the sealed
+// bytes. The nonce of a chunk is the prefix and the chunk's index, and the
+// final flag is authenticated, so chunks cannot be reordered, dropped or
+// cut off without decryption failing.
+const (
+	cipherMagic     = "PXE1"
+	noncePrefixLen  = 8
+	cipherChunkSize = 64 << 10
+	chunkHeaderLen  = 5 // final(1) length(4)
+)
+
+var errCorrupt = errors.New("export artifact corrupt or encrypted with another key")
+
+// AESGCM encrypts artifacts with AES-256-GCM
+type AESGCM struct {
+	aead cipher.AEAD
+}
+
+// NewAESGCM returns a cipher of a 32-byte key
+func NewAESGCM(key []byte) (*AESGCM, error) {
+	if len(key) != 32 {
+		return nil, errors.New("export encryption key must be 32 bytes")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("export cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("export cipher: %w", err)
+	}
+	return &AESGCM{aead: aead}, nil
+}
+
+func (c *AESGCM) nonce(prefix []byte, index uint32) []byte {
+	nonce := make([]byte, c.aead.NonceSize())
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[noncePrefixLen:], index)
+	return nonce
+}
+
+// EncryptWriter writes the header to w; data written is sealed in chunks,
+// the last one on Close
+func (c *AESGCM) EncryptWriter(w io.Writer) (io.WriteCloser, error) {
+	header := make([]byte, len(cipherMagic)+noncePrefixLen)
+	copy(header, cipherMagic)
+	if _, err := rand.Read(header[len(cipherMagic):]); err != nil {
+		return nil, fmt.Errorf("export nonce: %w", err)
+	}
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+	return &sealWriter{c: c, w: w, prefix: header[len(cipherMagic):], buf: make([]byte, 0, cipherChunkSize)}, nil
+}
+
+type sealWriter struct {
+	c      *AESGCM
+	w      io.Writer
+	prefix []byte
+	index  uint32
+	buf    []byte
+	closed bool
+}
+
+func (s *sealWriter) Write(p []byte) (int, error) {
+	n := 0
+	for len(p) > 0 {
+		room := cipherChunkSize - len(s.buf)
+		take := min(room, len(p))
+		s.buf = append(s.buf, p[:take]...)
+		p, n = p[take:], n+take
+		if len(s.buf) == cipherChunkSize {
+			if err := s.seal(false); err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, nil
+}
+
+func (s *sealWriter) seal(final bool) error {
+	var flag byte
+	if final {
+		flag = 1
+	}
+	sealed := s.c.aead.Seal(nil, s.c.nonce(s.prefix, s.index), s.buf, []byte{flag})
+	header := make([]byte, chunkHeaderLen)
+	header[0] = flag
+	binary.BigEndian.PutUint32(header[1:], uint32(len(sealed)))
+	if _, err := s.w.Write(header); err != nil {
+		return err
+	}
+	if _, err := s.w.Write(sealed); err != nil {
+		return err
+	}
+	s.index++
+	s.buf = s.buf[:0]
+	return nil
+}
+
+// Close seals the final chunk; it does not close the underlying writer
+func (s *sealWriter) Close() error {
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	return s.seal(true)
+}
+
+// DecryptReader checks the header of r and returns the plain text
+func (c *AESGCM) DecryptReader(r io.Reader) (io.Reader, error) {
+	header := make([]byte, len(cipherMagic)+noncePrefixLen)
+	if _, err := io.ReadFull(r, header); err != nil || string(header[:len(cipherMagic)]) != cipherMagic {
+		return nil, errCorrupt
+	}
+	return &openReader{c: c, r: r, prefix: header[len(cipherMagic):]}, nil
+}
+
+type openReader struct {
+	c      *AESGCM
+	r      io.Reader
+	prefix []byte
+	index  uint32
+	plain  []byte
+	done   bool
+}
+
+func (o *openReader) Read(p []byte) (int, error) {
+	for len(o.plain) == 0 {
+		if o.done {
+			return 0, io.EOF
+		}
+		if err := o.open(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, o.plain)
+	o.plain = o.plain[n:]
+	return n, nil
+}
+
+func (o *openReader) open() error {
+	header := make([]byte, chunkHeaderLen)
+	if _, err := io.ReadFull(o.r, header); err != nil {
+		return errCorrupt // Also a missing final chunk
+	}
+	size := binary.BigEndian.Uint32(header[1:])
+	if header[0] > 1 || size > cipherChunkSize+uint32(o.c.aead.Overhead()) {
+		return errCorrupt
+	}
+	sealed := make([]byte, size)
+	if _, err := io.ReadFull(o.r, sealed); err != nil {
+		return errCorrupt
+	}
+	plain, err := o.c.aead.Open(sealed[:0], o.c.nonce(o.prefix, o.index), sealed, header[:1])
+	if err != nil {
+		return errCorrupt
+	}
+	o.index++
+	o.plain = plain
+	o.done = header[0] == 1
+	return nil
+}
@@ -0,0 +1,198 @@
+package export
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/mcbile/product-pulse/pkg/clock"
+)
+
+// DownloadPath is the path signed download URLs point to, followed by the
+// artifact name
+const DownloadPath = "/exports/"
+
+// ErrURLExpired and ErrBadSignature are returned by Verify
+var (
+	ErrURLExpired   = errors.New("download link expired")
+	ErrBadSignature = errors.New("invalid download signature")
+)
+
+// Config of export artifacts
+type Config struct {
+	Store  Store
+	Cipher Cipher
+	Secret []byte        // Signs download URLs
+	TTL    time.Duration // Artifacts older than this are deleted by Cleanup
+	URLTTL time.Duration // Lifetime of download URLs, at most TTL
+	Clock  clock.Clock   // nil uses the system clock
+}
+
+// Exports writes, hands out and expires export artifacts
+type Exports struct {
+	config Config
+	clock  clock.Clock
+}
+
+// New returns exports of config
+func New(config Config) (*Exports, error) {
+	if config.Store == nil || config.Cipher == nil {
+		return nil, errors.New("exports need a store and a cipher")
+	}
+	if len(config.Secret) < 16 {
+		return nil, errors.New("export URL secret must be at least 16 bytes")
+	}
+	if config.TTL <= 0 {
+		config.TTL = 24 * time.Hour
+	}
+	if config.URLTTL <= 0 || config.URLTTL > config.TTL {
+		config.URLTTL = config.TTL
+	}
+	return &Exports{config: config, clock: clock.OrReal(config.Clock)}, nil
+}
+
+// TTL is how long artifacts are kept
+func (e *Exports) TTL() time.Duration {
+	return e.config.TTL
+}
+
+// Write stores what fn writes as a gzipped, encrypted artifact of source.
+// Nothing is kept if fn or storing fails.
+func (e *Exports) Write(ctx context.Context, source string, fn func(w io.Writer) error) (Artifact, error) {
+	random := make([]byte, 8)
+	if _, err := rand.Read(random); err != nil {
+		return Artifact{}, err
+	}
+	now := e.clock.Now().UTC()
+	name := source + "-" + now.Format("20060102T150405Z") + "-" + hex.EncodeToString(random) + ".ndjson.gz"
+
+	f, err := e.config.Store.Create(ctx, name)
+	if err != nil {
+		return Artifact{}, err
+	}
+	counter := &countingWriter{w: f}
+	err = e.write(counter, fn)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		if derr := e.config.Store.Delete(ctx, name); derr != nil {
+			slog.Warn("failed to delete incomplete export", "name", name, "error", derr)
+		}
+		return Artifact{}, err
+	}
+	return Artifact{Name: name, Size: counter.n, Created: now}, nil
+}
+
+func (e *Exports) write(w io.Writer, fn func(w io.Writer) error) error {
+	enc, err := e.config.Cipher.EncryptWriter(w)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(enc)
+	if err := fn(gz); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// SignedURL returns the path and query of a download URL of name, valid
+// until the returned time
+func (e *Exports) SignedURL(name string) (string, time.Time) {
+	expires := e.clock.Now().Add(e.config.URLTTL).Truncate(time.Second)
+	exp := strconv.FormatInt(expires.Unix(), 10)
+	q := url.Values{"expires": {exp}, "sig": {e.sign(name, exp)}}
+	return DownloadPath + url.PathEscape(name) + "?" + q.Encode(), expires
+}
+
+func (e *Exports) sign(name, expires string) string {
+	mac := hmac.New(sha256.New, e.config.Secret)
+	mac.Write([]byte(name + "\n" + expires))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks the expiry and signature of a download URL of name
+func (e *Exports) Verify(name, expires, sig string) error {
+	want, err := base64.RawURLEncoding.DecodeString(e.sign(name, expires))
+	if err != nil {
+		return err
+	}
+	got, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil || !hmac.Equal(got, want) {
+		return ErrBadSignature
+	}
+	unix, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil {
+		return ErrBadSignature
+	}
+	if !e.clock.Now().Before(time.Unix(unix, 0)) {
+		return ErrURLExpired
+	}
+	return nil
+}
+
+// Open returns the decrypted, still gzipped artifact name
+func (e *Exports) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	f, err := e.config.Store.Open(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := e.config.Cipher.DecryptReader(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{plain, f}, nil
+}
+
+// Cleanup deletes the artifacts older than the TTL, including unfinished
+// writes
+func (e *Exports) Cleanup(ctx context.Context) error {
+	artifacts, err := e.config.Store.List(ctx)
+	if err != nil {
+		return fmt.Errorf("list exports: %w", err)
+	}
+	cutoff := e.clock.Now().Add(-e.config.TTL)
+	deleted := 0
+	for _, a := range artifacts {
+		if !a.Created.Before(cutoff) {
+			continue
+		}
+		if err := e.config.Store.Delete(ctx, a.Name); err != nil {
+			return fmt.Errorf("delete export %s: %w", a.Name, err)
+		}
+		deleted++
+	}
+	if deleted > 0 {
+		slog.Info("expired exports deleted", "count", deleted)
+	}
+	return nil
+}
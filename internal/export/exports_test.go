@@ -0,0 +1,202 @@
+package export
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mcbile/product-pulse/pkg/clock"
+)
+
+func testKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, 32)
+}
+
+func newTestExports(t *testing.T, clk clock.Clock) (*Exports, string) {
+	t.Helper()
+	dir := t.TempDir()
+	store, err := NewDirStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewAESGCM(testKey(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := New(Config{Store: store, Cipher: c, Secret: []byte("0123456789abcdef"), TTL: time.Hour, URLTTL: 10 * time.Minute, Clock: clk})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e, dir
+}
+
+func encrypt(t *testing.T, c Cipher, plain []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := c.EncryptWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(plain); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func decrypt(c Cipher, sealed []byte) ([]byte, error) {
+	r, err := c.DecryptReader(bytes.NewReader(sealed))
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+func TestAESGCMRoundTrip(t *testing.T) {
+	c, _ := NewAESGCM(testKey(1))
+	for _, size := range []int{0, 1, cipherChunkSize, 3*cipherChunkSize + 17} {
+		plain := bytes.Repeat([]byte("event\n"), size/6+1)[:size]
+		sealed := encrypt(t, c, plain)
+		if bytes.Contains(sealed, []byte("event")) && size > 0 {
+			t.Fatalf("size %d: plain text in sealed artifact", size)
+		}
+		got, err := decrypt(c, sealed)
+		if err != nil {
+			t.Fatalf("size %d: %v", size, err)
+		}
+		if !bytes.Equal(got, plain) {
+			t.Fatalf("size %d: round trip changed the data", size)
+		}
+	}
+}
+
+func TestAESGCMRejectsTampering(t *testing.T) {
+	c, _ := NewAESGCM(testKey(1))
+	sealed := encrypt(t, c, bytes.Repeat([]byte("x"), 2*cipherChunkSize+5))
+
+	other, _ := NewAESGCM(testKey(2))
+	if _, err := decrypt(other, sealed); err == nil {
+		t.Error("wrong key: decrypted")
+	}
+
+	flipped := bytes.Clone(sealed)
+	flipped[len(flipped)/2] ^= 1
+	if _, err := decrypt(c, flipped); err == nil {
+		t.Error("flipped bit: decrypted")
+	}
+
+	// Cut after the first chunk: the final chunk is missing
+	firstChunk := len(cipherMagic) + noncePrefixLen + chunkHeaderLen + cipherChunkSize + 16
+	if _, err := decrypt(c, sealed[:firstChunk]); err == nil {
+		t.Error("truncated: decrypted")
+	}
+}
+
+func TestExportsWriteAndDownload(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC))
+	e, dir := newTestExports(t, clk)
+	ctx := context.Background()
+
+	a, err := e.Write(ctx, "api", func(w io.Writer) error {
+		_, err := io.WriteString(w, `{"endpoint":"/pay"}`+"\n")
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := os.ReadFile(filepath.Join(dir, a.Name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(raw, []byte("/pay")) || int64(len(raw)) != a.Size {
+		t.Fatalf("artifact not encrypted or size %d != %d", len(raw), a.Size)
+	}
+
+	link, expires := e.SignedURL(a.Name)
+	u, _ := url.Parse(link)
+	if !strings.HasPrefix(u.Path, DownloadPath) || !expires.Equal(clk.Now().Add(10*time.Minute)) {
+		t.Fatalf("url %q expires %v", link, expires)
+	}
+	if err := e.Verify(a.Name, u.Query().Get("expires"), u.Query().Get("sig")); err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+	if err := e.Verify("api-other.ndjson.gz", u.Query().Get("expires"), u.Query().Get("sig")); !errors.Is(err, ErrBadSignature) {
+		t.Errorf("other name: %v", err)
+	}
+	if err := e.Verify(a.Name, "9999999999", u.Query().Get("sig")); !errors.Is(err, ErrBadSignature) {
+		t.Errorf("extended expiry: %v", err)
+	}
+
+	f, err := e.Open(ctx, a.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(gz)
+	if err != nil || string(body) != `{"endpoint":"/pay"}`+"\n" {
+		t.Fatalf("download %q, %v", body, err)
+	}
+
+	clk.Advance(10 * time.Minute)
+	if err := e.Verify(a.Name, u.Query().Get("expires"), u.Query().Get("sig")); !errors.Is(err, ErrURLExpired) {
+		t.Errorf("after URL TTL: %v", err)
+	}
+}
+
+func TestExportsFailedWriteKeepsNothing(t *testing.T) {
+	e, dir := newTestExports(t, nil)
+	_, err := e.Write(context.Background(), "api", func(w io.Writer) error {
+		io.WriteString(w, "partial")
+		return errors.New("query failed")
+	})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 0 {
+		t.Fatalf("left %d files", len(entries))
+	}
+}
+
+func TestExportsCleanup(t *testing.T) {
+	clk := clock.NewFake(time.Now())
+	e, dir := newTestExports(t, clk)
+	ctx := context.Background()
+
+	old, err := e.Write(ctx, "psp", func(w io.Writer) error { return nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(filepath.Join(dir, old.Name), clk.Now(), clk.Now().Add(-2*time.Hour))
+	os.WriteFile(filepath.Join(dir, "unfinished"+tmpSuffix), nil, 0o600)
+	os.Chtimes(filepath.Join(dir, "unfinished"+tmpSuffix), clk.Now(), clk.Now().Add(-2*time.Hour))
+	fresh, err := e.Write(ctx, "psp", func(w io.Writer) error { return nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := e.Cleanup(ctx); err != nil {
+		t.Fatal(err)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 || entries[0].Name() != fresh.Name {
+		t.Fatalf("left %v, want only %s", entries, fresh.Name)
+	}
+	if _, err := e.Open(ctx, old.Name); !errors.Is(err, ErrNotFound) {
+		t.Errorf("open deleted: %v", err)
+	}
+}
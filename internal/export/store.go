@@ -0,0 +1,132 @@
+// Package export keeps server-side exports of raw events as encrypted
+// artifacts, hands them out through time-limited signed URLs and deletes
+// them once expired, so tenant data does not linger unprotected on disk.
+// Where artifacts live (Store) and how they are encrypted (Cipher) are
+// pluggable; the collector ships a directory store and AES-256-GCM.
+package export
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ErrNotFound is returned for artifacts that do not exist (anymore)
+var ErrNotFound = errors.New("export not found")
+
+// Artifact is a stored export
+type Artifact struct {
+	Name    string    `json:"name"`
+	Size    int64     `json:"size"`
+	Created time.Time `json:"created"`
+}
+
+// Store holds export artifacts. Artifacts being written are not listed or
+// opened before their writer is closed.
+type Store interface {
+	Create(ctx context.Context, name string) (io.WriteCloser, error)
+	Open(ctx context.Context, name string) (io.ReadCloser, error)
+	Delete(ctx context.Context, name string) error
+	List(ctx context.Context) ([]Artifact, error)
+}
+
+// tmpSuffix marks artifacts of DirStore still being written
+const tmpSuffix = ".tmp"
+
+// DirStore keeps artifacts as files of a directory, readable by the
+// collector's user only
+type DirStore struct {
+	dir string
+}
+
+// NewDirStore creates dir if needed and returns a store in it
+func NewDirStore(dir string) (*DirStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("create export dir: %w", err)
+	}
+	return &DirStore{dir: dir}, nil
+}
+
+// validName rejects names that could leave the directory
+func validName(name string) bool {
+	return name != "" && !strings.ContainsAny(name, `/\`) && !strings.HasPrefix(name, ".") && !strings.HasSuffix(name, tmpSuffix)
+}
+
+// Create writes to a temporary file, renamed to name on Close
+func (s *DirStore) Create(ctx context.Context, name string) (io.WriteCloser, error) {
+	if !validName(name) {
+		return nil, fmt.Errorf("invalid export name %q", name)
+	}
+	path := filepath.Join(s.dir, name)
+	f, err := os.OpenFile(path+tmpSuffix, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("create export: %w", err)
+	}
+	return &dirFile{File: f, path: path}, nil
+}
+
+// dirFile is an artifact being written
+type dirFile struct {
+	*os.File
+	path string
+}
+
+func (f *dirFile) Close() error {
+	err := f.File.Sync()
+	if cerr := f.File.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.path+tmpSuffix, f.path)
+	}
+	if err != nil {
+		os.Remove(f.path + tmpSuffix)
+		return fmt.Errorf("write export: %w", err)
+	}
+	return nil
+}
+
+func (s *DirStore) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	if !validName(name) {
+		return nil, ErrNotFound
+	}
+	f, err := os.Open(filepath.Join(s.dir, name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return f, err
+}
+
+func (s *DirStore) Delete(ctx context.Context, name string) error {
+	err := os.Remove(filepath.Join(s.dir, name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// List returns all artifacts, including temporary files of writes that
+// never finished (with their .tmp name), so cleanup removes those as well
+func (s *DirStore) List(ctx context.Context) ([]Artifact, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	artifacts := make([]Artifact, 0, len(entries))
+	for _, e := range entries {
+		if !e.Type().IsRegular() {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue // Deleted meanwhile
+		}
+		artifacts = append(artifacts, Artifact{Name: e.Name(), Size: info.Size(), Created: info.ModTime()})
+	}
+	return artifacts, nil
+}
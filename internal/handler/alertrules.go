@@ -0,0 +1,245 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/mcbile/product-pulse/internal/alerting"
+	"github.com/mcbile/product-pulse/internal/storage"
+)
+
+// ============================================
+// ALERT RULES HANDLER (admin)
+// ============================================
+
+// AlertRulesHandler manages threshold alert rules: create, list, change and
+// delete them, with their state and last evaluation
+type AlertRulesHandler struct {
+	engine         *alerting.Engine
+	allowedOrigins map[string]bool
+	allowAll       bool
+}
+
+func NewAlertRulesHandler(engine *alerting.Engine, origins []string) *AlertRulesHandler {
+	h := &AlertRulesHandler{
+		engine:         engine,
+		allowedOrigins: make(map[string]bool),
+	}
+	for _, o := range origins {
+		if o == "*" {
+			h.allowAll = true
+			break
+		}
+		h.allowedOrigins[o] = true
+	}
+	return h
+}
+
+// ruleRequest creates or changes a rule. Omitted fields keep their value on
+// updates; on creation interval, window and baseline weeks default to
+// ALERT_INTERVAL, ALERT_WINDOW and ALERT_BASELINE_WEEKS.
+type ruleRequest struct {
+	Name            string   `json:"name"`
+	Metric          *string  `json:"metric"`
+	Aggregation     *string  `json:"aggregation"`
+	Target          *string  `json:"target"`
+	SiteID          *string  `json:"site_id"`
+	Operator        *string  `json:"operator"`
+	Threshold       *float64 `json:"threshold"`
+	Adaptive        *bool    `json:"adaptive"`
+	BaselinePercent *float64 `json:"baseline_percent"`
+	BaselineWeeks   *int     `json:"baseline_weeks"`
+	Severity        *string  `json:"severity"`
+	Interval        *string  `json:"interval"`
+	Window          *string  `json:"window"`
+	Enabled         *bool    `json:"enabled"`
+	Webhooks        []string `json:"webhooks"`
+}
+
+// apply copies the fields set in req to rule; it answers 400 and returns
+// false for unparsable durations
+func (req *ruleRequest) apply(w http.ResponseWriter, rule *storage.AlertRule) bool {
+	if req.Metric != nil {
+		rule.Metric = *req.Metric
+		if req.Aggregation == nil {
+			rule.Aggregation = "" // The new metric's default
+		}
+	}
+	if req.Aggregation != nil {
+		rule.Aggregation = *req.Aggregation
+	}
+	if req.Target != nil {
+		rule.Target = *req.Target
+	}
+	if req.SiteID != nil {
+		rule.SiteID = *req.SiteID
+	}
+	if req.Operator != nil {
+		rule.Operator = *req.Operator
+	}
+	if req.Threshold != nil {
+		rule.Threshold = *req.Threshold
+	}
+	if req.Adaptive != nil {
+		rule.Adaptive = *req.Adaptive
+	}
+	if req.BaselinePercent != nil {
+		rule.BaselinePercent = *req.BaselinePercent
+	}
+	if req.BaselineWeeks != nil {
+		rule.BaselineWeeks = *req.BaselineWeeks
+	}
+	if req.Severity != nil {
+		rule.Severity = *req.Severity
+	}
+	if req.Enabled != nil {
+		rule.Enabled = *req.Enabled
+	}
+	if req.Webhooks != nil {
+		rule.Webhooks = req.Webhooks
+	}
+	var err error
+	if req.Interval != nil {
+		if rule.Interval, err = time.ParseDuration(*req.Interval); err != nil {
+			http.Error(w, "invalid interval", http.StatusBadRequest)
+			return false
+		}
+	}
+	if req.Window != nil {
+		if rule.Window, err = time.ParseDuration(*req.Window); err != nil {
+			http.Error(w, "invalid window", http.StatusBadRequest)
+			return false
+		}
+	}
+	return true
+}
+
+// HandleList returns all rules with state, schedule and last evaluation
+// GET /api/alerts/rules
+func (h *AlertRulesHandler) HandleList(w http.ResponseWriter, r *http.Request) {
+	h.setCORS(w, r)
+
+	rules, err := h.engine.List(r.Context())
+	if err != nil {
+		slog.Error("failed to list alert rules", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"rules": rules,
+	})
+}
+
+// HandleGet returns one rule
+// GET /api/alerts/rules/{name}
+func (h *AlertRulesHandler) HandleGet(w http.ResponseWriter, r *http.Request) {
+	h.setCORS(w, r)
+
+	status, err := h.engine.Get(r.Context(), r.PathValue("name"))
+	if !h.check(w, err) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+// HandleCreate stores a new rule, evaluated right away
+// POST /api/alerts/rules
+func (h *AlertRulesHandler) HandleCreate(w http.ResponseWriter, r *http.Request) {
+	h.setCORS(w, r)
+
+	var req ruleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+	user, _ := UserFromContext(r.Context())
+	rule := storage.AlertRule{Name: req.Name, Enabled: true, CreatedBy: user.Email}
+	if !req.apply(w, &rule) {
+		return
+	}
+
+	status, err := h.engine.Create(r.Context(), rule)
+	if errors.Is(err, storage.ErrAlertRuleExists) {
+		http.Error(w, "rule already exists", http.StatusConflict)
+		return
+	}
+	if !h.check(w, err) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(status)
+}
+
+// HandleUpdate changes a rule; omitted fields are kept. Disabling a rule
+// resolves its open alert.
+// PUT /api/alerts/rules/{name}
+func (h *AlertRulesHandler) HandleUpdate(w http.ResponseWriter, r *http.Request) {
+	h.setCORS(w, r)
+
+	var req ruleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+	current, err := h.engine.Get(r.Context(), r.PathValue("name"))
+	if !h.check(w, err) {
+		return
+	}
+	rule := current.AlertRule
+	if !req.apply(w, &rule) {
+		return
+	}
+
+	status, err := h.engine.Update(r.Context(), rule)
+	if !h.check(w, err) {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+// HandleDelete removes a rule and resolves its open alert
+// DELETE /api/alerts/rules/{name}
+func (h *AlertRulesHandler) HandleDelete(w http.ResponseWriter, r *http.Request) {
+	h.setCORS(w, r)
+
+	if !h.check(w, h.engine.Delete(r.Context(), r.PathValue("name"))) {
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// check answers errors of the engine and returns false if there was one
+func (h *AlertRulesHandler) check(w http.ResponseWriter, err error) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, storage.ErrAlertRuleNotFound):
+		http.Error(w, "rule not found", http.StatusNotFound)
+	case errors.Is(err, alerting.ErrInvalidRule):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		slog.Error("alert rule operation failed", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+	}
+	return false
+}
+
+func (h *AlertRulesHandler) setCORS(w http.ResponseWriter, r *http.Request) {
+	origin := r.Header.Get("Origin")
+	if h.allowAll {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	} else if h.allowedOrigins[origin] {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+	}
+}
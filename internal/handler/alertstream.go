@@ -0,0 +1,173 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/mcbile/product-pulse/internal/storage"
+)
+
+// ============================================
+// ALERT STREAM HANDLER
+// ============================================
+
+const (
+	defaultAlertWait  = 30 * time.Second
+	maxAlertWait      = time.Minute
+	alertPollInterval = 2 * time.Second
+	defaultAlertBatch = 100
+	maxAlertBatch     = 500
+)
+
+// AlertFeed is the storage used by the alert stream
+type AlertFeed interface {
+	GetAlertsAfter(ctx context.Context, cursor int64, limit int) ([]storage.AlertRow, error)
+	GetLatestAlertID(ctx context.Context) (int64, error)
+}
+
+// AlertStreamHandler serves new alerts to bots by long polling. Each
+// response carries a cursor; a bot that stores it and passes it back after
+// a restart gets every alert raised in between.
+type AlertStreamHandler struct {
+	db             AlertFeed
+	allowedOrigins map[string]bool
+	allowAll       bool
+
+	closing   chan struct{}
+	closeOnce sync.Once
+}
+
+func NewAlertStreamHandler(db AlertFeed, origins []string) *AlertStreamHandler {
+	h := &AlertStreamHandler{
+		db:             db,
+		allowedOrigins: make(map[string]bool),
+		closing:        make(chan struct{}),
+	}
+	for _, o := range origins {
+		if o == "*" {
+			h.allowAll = true
+			break
+		}
+		h.allowedOrigins[o] = true
+	}
+	return h
+}
+
+// Handle returns alerts raised after cursor, waiting up to wait for one if
+// there are none yet. Without a cursor it returns the current cursor right
+// away, so new bots start with the next alert instead of the history.
+// GET /api/alerts/stream?cursor=123&wait=30s&limit=100
+func (h *AlertStreamHandler) Handle(w http.ResponseWriter, r *http.Request) {
+	h.setCORS(w, r)
+	if !requireAllSites(w, r) {
+		return
+	}
+
+	q := r.URL.Query()
+	wait := defaultAlertWait
+	if v := q.Get("wait"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 || d > maxAlertWait {
+			http.Error(w, "wait must be between 0s and "+maxAlertWait.String(), http.StatusBadRequest)
+			return
+		}
+		wait = d
+	}
+	limit := defaultAlertBatch
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxAlertBatch {
+			http.Error(w, "limit must be between 1 and "+strconv.Itoa(maxAlertBatch), http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	ctx := r.Context()
+	if q.Get("cursor") == "" {
+		cursor, err := h.db.GetLatestAlertID(ctx)
+		if err != nil {
+			slog.Error("failed to get latest alert id", "error", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		h.write(w, nil, cursor)
+		return
+	}
+	cursor, err := strconv.ParseInt(q.Get("cursor"), 10, 64)
+	if err != nil || cursor < 0 {
+		http.Error(w, "invalid cursor", http.StatusBadRequest)
+		return
+	}
+
+	// The server's write timeout is meant for ordinary requests
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Now().Add(wait + 10*time.Second)); err != nil {
+		slog.Debug("cannot extend write deadline for alert stream", "error", err)
+	}
+
+	deadline := time.Now().Add(wait)
+	ticker := time.NewTicker(alertPollInterval)
+	defer ticker.Stop()
+
+	for {
+		alerts, err := h.db.GetAlertsAfter(ctx, cursor, limit)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			slog.Error("failed to get alerts after cursor", "cursor", cursor, "error", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		if len(alerts) > 0 || !time.Now().Before(deadline) {
+			if len(alerts) > 0 {
+				cursor = alerts[len(alerts)-1].ID
+			}
+			h.write(w, alerts, cursor)
+			return
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		case <-h.closing:
+			// Nothing new yet; the bot polls again with the same cursor
+			h.write(w, nil, cursor)
+			return
+		}
+	}
+}
+
+// Close answers waiting polls right away. Register it with
+// http.Server.RegisterOnShutdown, as Shutdown does not cancel request
+// contexts and would wait for polls until its deadline.
+func (h *AlertStreamHandler) Close() {
+	h.closeOnce.Do(func() { close(h.closing) })
+}
+
+func (h *AlertStreamHandler) write(w http.ResponseWriter, alerts []storage.AlertRow, cursor int64) {
+	if alerts == nil {
+		alerts = []storage.AlertRow{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"alerts": alerts,
+		"cursor": cursor,
+	})
+}
+
+func (h *AlertStreamHandler) setCORS(w http.ResponseWriter, r *http.Request) {
+	origin := r.Header.Get("Origin")
+	if h.allowAll {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	} else if h.allowedOrigins[origin] {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+	}
+}
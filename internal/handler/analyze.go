@@ -0,0 +1,96 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/mcbile/product-pulse/internal/maintenance"
+)
+
+// ============================================
+// TABLE ANALYZE HANDLER (admin)
+// ============================================
+
+// AnalyzeHandler lets admins refresh planner statistics of the metric
+// tables, e.g. right after a large backfill
+type AnalyzeHandler struct {
+	analyzer       *maintenance.Analyzer
+	allowedOrigins map[string]bool
+	allowAll       bool
+}
+
+func NewAnalyzeHandler(analyzer *maintenance.Analyzer, origins []string) *AnalyzeHandler {
+	h := &AnalyzeHandler{
+		analyzer:       analyzer,
+		allowedOrigins: make(map[string]bool),
+	}
+	for _, o := range origins {
+		if o == "*" {
+			h.allowAll = true
+			break
+		}
+		h.allowedOrigins[o] = true
+	}
+	return h
+}
+
+// HandleStart starts analyzing the given hot tables, all of them without a
+// body. It answers 202 with the analysis; poll GET for its progress.
+// POST /api/admin/analyze {"tables": ["psp_metrics"]}
+func (h *AnalyzeHandler) HandleStart(w http.ResponseWriter, r *http.Request) {
+	h.setCORS(w, r)
+
+	var req struct {
+		Tables []string `json:"tables"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	an, err := h.analyzer.Start(req.Tables)
+	switch {
+	case errors.Is(err, maintenance.ErrUnknownTable):
+		http.Error(w, "tables must be among "+strings.Join(maintenance.Tables(), ", "), http.StatusBadRequest)
+		return
+	case errors.Is(err, maintenance.ErrAnalysisRunning):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		slog.Error("failed to start table analysis", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(an)
+}
+
+// HandleStatus returns the running or last analysis, scheduled or manual
+// GET /api/admin/analyze
+func (h *AnalyzeHandler) HandleStatus(w http.ResponseWriter, r *http.Request) {
+	h.setCORS(w, r)
+
+	an, ok := h.analyzer.Last()
+	if !ok {
+		http.Error(w, "no analysis since startup", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(an)
+}
+
+func (h *AnalyzeHandler) setCORS(w http.ResponseWriter, r *http.Request) {
+	origin := r.Header.Get("Origin")
+	if h.allowAll {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	} else if h.allowedOrigins[origin] {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+	}
+}
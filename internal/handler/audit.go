@@ -0,0 +1,109 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/mcbile/product-pulse/internal/storage"
+)
+
+// ============================================
+// AUDIT LOG HANDLER (admin)
+// ============================================
+
+// AuditStorage reads the audit log
+type AuditStorage interface {
+	GetAuditEvents(ctx context.Context, event, email string, limit int) ([]storage.AuditEvent, error)
+}
+
+// auditWriter writes audit events
+type auditWriter interface {
+	InsertAuditEvent(ctx context.Context, e storage.AuditEvent) error
+}
+
+// auditChange records a change made by the admin of r in the audit log.
+// The write outlives the request, so changes of aborted requests are kept
+// too.
+func auditChange(r *http.Request, store auditWriter, event string, detail map[string]string) {
+	user, _ := UserFromContext(r.Context())
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), 5*time.Second)
+	defer cancel()
+	err := store.InsertAuditEvent(ctx, storage.AuditEvent{
+		Time:      time.Now().UTC(),
+		Event:     event,
+		Email:     user.Email,
+		IP:        getClientIP(r),
+		UserAgent: r.UserAgent(),
+		Detail:    detail,
+	})
+	if err != nil {
+		slog.Error("failed to write audit event", "event", event, "email", user.Email, "error", err)
+	}
+}
+
+// AuditHandler serves the audit log of dashboard logins
+type AuditHandler struct {
+	storage        AuditStorage
+	allowedOrigins map[string]bool
+	allowAll       bool
+}
+
+func NewAuditHandler(store AuditStorage, origins []string) *AuditHandler {
+	h := &AuditHandler{
+		storage:        store,
+		allowedOrigins: make(map[string]bool),
+	}
+	for _, o := range origins {
+		if o == "*" {
+			h.allowAll = true
+			break
+		}
+		h.allowedOrigins[o] = true
+	}
+	return h
+}
+
+// Handle returns the latest audit events, newest first, optionally of one
+// event type or user
+// GET /api/admin/audit?event=session_binding_mismatch&email=a@b.c&limit=100
+func (h *AuditHandler) Handle(w http.ResponseWriter, r *http.Request) {
+	h.setCORS(w, r)
+
+	limit := 100
+	if s := r.URL.Query().Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > maxListLimit {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	events, err := h.storage.GetAuditEvents(r.Context(), r.URL.Query().Get("event"), r.URL.Query().Get("email"), limit)
+	if err != nil {
+		slog.Error("failed to query audit log", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if events == nil {
+		events = []storage.AuditEvent{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"events": events,
+	})
+}
+
+func (h *AuditHandler) setCORS(w http.ResponseWriter, r *http.Request) {
+	origin := r.Header.Get("Origin")
+	if h.allowAll {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	} else if h.allowedOrigins[origin] {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+	}
+}
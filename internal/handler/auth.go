@@ -1,19 +1,22 @@
 package handler
 
 import (
+	"context"
 	"crypto/rand"
 	"crypto/sha256"
 	"crypto/subtle"
-	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
-	"fmt"
+	"errors"
 	"log/slog"
 	"net/http"
 	"os"
 	"strings"
-	"sync"
 	"time"
+
+	"github.com/mcbile/product-pulse/internal/idtoken"
+	"github.com/mcbile/product-pulse/internal/storage"
+	"github.com/mcbile/product-pulse/pkg/clock"
 )
 
 // ============================================
@@ -22,43 +25,85 @@ import (
 
 // User represents an authenticated user
 type User struct {
-	Email    string `json:"email"`
-	Name     string `json:"name"`
-	Nickname string `json:"nickname"`
-	Role     string `json:"role"` // "super_admin", "admin", or "client"
-	Picture  string `json:"picture"`
+	Email    string   `json:"email"`
+	Name     string   `json:"name"`
+	Nickname string   `json:"nickname"`
+	Role     string   `json:"role"`  // See ValidRole
+	Sites    []string `json:"sites"` // Granted sites, used by roles without access to all sites
+	Picture  string   `json:"picture"`
+}
+
+func userFromStorage(u storage.User) User {
+	return User{
+		Email:    u.Email,
+		Name:     u.Name,
+		Nickname: u.Nickname,
+		Role:     u.Role,
+		Sites:    u.Sites,
+		Picture:  u.Picture,
+	}
 }
 
-// Session represents an active session
-type Session struct {
-	Token     string
-	User      User
-	ExpiresAt time.Time
+// AuthStorage is the subset of storage used for users
+type AuthStorage interface {
+	SeedUser(ctx context.Context, user storage.User) error
+	RecordSignIn(ctx context.Context, user storage.User) (storage.User, error)
+	GetUserByLogin(ctx context.Context, login string) (storage.User, error)
+	TouchUserLogin(ctx context.Context, email string) error
+	InsertAuditEvent(ctx context.Context, e storage.AuditEvent) error
+	CreateOIDCLogin(ctx context.Context, login storage.OIDCLogin) error
+	TakeOIDCLogin(ctx context.Context, stateHash string) (storage.OIDCLogin, error)
 }
 
-// AdminUser represents hardcoded admin configuration
-type AdminUser struct {
-	PasswordHash string
-	Name         string
-	Nickname     string
+// SessionStore keeps dashboard sessions: the sessions table, or Redis
+// with SESSION_STORE=redis
+type SessionStore interface {
+	CreateSession(ctx context.Context, session storage.Session) error
+	RefreshSession(ctx context.Context, refreshHash string, next storage.Session) (storage.User, storage.SessionBinding, error)
+	GetSession(ctx context.Context, tokenHash string) (storage.User, storage.SessionBinding, error)
+	RebindSession(ctx context.Context, tokenHash string, b storage.SessionBinding) error
+	DeleteSession(ctx context.Context, tokenHash string) error
 }
 
-// AuthHandler handles authentication
+// AuthHandler handles authentication. Users and sessions are kept in the
+// database (sessions optionally in Redis), so logins survive restarts and
+// are shared by all replicas.
 type AuthHandler struct {
-	adminUsers     map[string]AdminUser // email -> admin config
-	sessions       map[string]*Session  // token -> session
-	sessionsMu     sync.RWMutex
+	storage        AuthStorage
+	sessions       SessionStore
+	google         *idtoken.Verifier // nil disables Google login
+	accessTTL      time.Duration     // Lifetime of access tokens
+	refreshTTL     time.Duration     // Sessions idle longer than this must log in again
 	allowedDomains []string
 	allowedOrigins map[string]bool
 	allowAll       bool
+
+	// OIDC login, see EnableOIDC
+	oidc                *idtoken.Provider // nil disables OIDC login
+	dashboardURL        string            // Where OIDC logins return to
+	oidcRequireVerified bool
+
+	// Session binding, see SetSessionBinding
+	bindingMode     string
+	bindingIPv4Bits int
+	bindingIPv6Bits int
+
+	clock clock.Clock // Time source for session and login expiry, see SetClock
 }
 
-func NewAuthHandler(origins []string) *AuthHandler {
+func NewAuthHandler(store AuthStorage, sessions SessionStore, google *idtoken.Verifier, accessTTL, refreshTTL time.Duration, origins []string) *AuthHandler {
 	h := &AuthHandler{
-		adminUsers:     make(map[string]AdminUser),
-		sessions:       make(map[string]*Session),
-		allowedDomains: []string{"starcrown.partners"},
-		allowedOrigins: make(map[string]bool),
+		storage:         store,
+		sessions:        sessions,
+		google:          google,
+		accessTTL:       accessTTL,
+		refreshTTL:      refreshTTL,
+		allowedDomains:  []string{"starcrown.partners"},
+		allowedOrigins:  make(map[string]bool),
+		bindingMode:     SessionBindingOff,
+		bindingIPv4Bits: 24,
+		bindingIPv6Bits: 64,
+		clock:           clock.Real,
 	}
 
 	for _, o := range origins {
@@ -68,22 +113,23 @@ func NewAuthHandler(origins []string) *AuthHandler {
 		}
 		h.allowedOrigins[o] = true
 	}
+	if h.refreshTTL < h.accessTTL {
+		h.refreshTTL = h.accessTTL
+	}
 
-	// Load admin users from environment
-	h.loadAdminUsers()
-
-	// Start session cleanup goroutine
-	go h.cleanupExpiredSessions()
+	// Seed admin users from environment
+	h.loadAdminUsers(context.Background())
 
 	return h
 }
 
-// loadAdminUsers loads admin credentials from environment variables
+// loadAdminUsers seeds admin users from environment variables into the
+// users table as super_admin
 // Format: ADMIN_USERS=email1:hash:name:nickname,email2:hash:name:nickname
 //
 // To generate password hash:
 //   echo -n "YourPassword" | sha256sum | cut -d' ' -f1
-func (h *AuthHandler) loadAdminUsers() {
+func (h *AuthHandler) loadAdminUsers(ctx context.Context) {
 	adminConfig := os.Getenv("ADMIN_USERS")
 	if adminConfig == "" {
 		slog.Warn("ADMIN_USERS not set - password login disabled, only Google OAuth available")
@@ -91,6 +137,7 @@ func (h *AuthHandler) loadAdminUsers() {
 	}
 
 	// Parse ADMIN_USERS env var
+	loaded := 0
 	users := strings.Split(adminConfig, ",")
 	for _, u := range users {
 		parts := strings.Split(u, ":")
@@ -99,15 +146,22 @@ func (h *AuthHandler) loadAdminUsers() {
 			continue
 		}
 		email := strings.ToLower(strings.TrimSpace(parts[0]))
-		h.adminUsers[email] = AdminUser{
-			PasswordHash: parts[1],
+		err := h.storage.SeedUser(ctx, storage.User{
+			Email:        email,
 			Name:         parts[2],
 			Nickname:     parts[3],
+			Role:         RoleSuperAdmin,
+			PasswordHash: parts[1],
+		})
+		if err != nil {
+			slog.Error("failed to seed admin user", "email", email, "error", err)
+			continue
 		}
+		loaded++
 		slog.Info("loaded admin user", "email", email)
 	}
 
-	if len(h.adminUsers) == 0 {
+	if loaded == 0 {
 		slog.Warn("no valid admin users loaded from ADMIN_USERS")
 	}
 }
@@ -128,56 +182,131 @@ func generateToken() string {
 	return hex.EncodeToString(b)
 }
 
-func (h *AuthHandler) createSession(user User) string {
-	token := generateToken()
-	session := &Session{
-		Token:     token,
-		User:      user,
-		ExpiresAt: time.Now().Add(24 * time.Hour), // 24h session
+// hashToken returns the SHA-256 of a session token; only the hash is stored
+func hashToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+// sessionTokens are the tokens handed to a client at login and refresh
+type sessionTokens struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"` // Seconds until the access token expires
+}
+
+// SetClock replaces the time source used to compute session and login
+// expiry, for tests
+func (h *AuthHandler) SetClock(c clock.Clock) {
+	h.clock = clock.OrReal(c)
+}
+
+// newSession generates a token pair and the session storing their hashes,
+// bound to the client of r
+func (h *AuthHandler) newSession(r *http.Request, email string) (sessionTokens, storage.Session) {
+	now := h.clock.Now()
+	tokens := sessionTokens{
+		Token:        generateToken(),
+		RefreshToken: generateToken(),
+		ExpiresIn:    int(h.accessTTL.Seconds()),
+	}
+	return tokens, storage.Session{
+		TokenHash:        hashToken(tokens.Token),
+		RefreshHash:      hashToken(tokens.RefreshToken),
+		Email:            email,
+		ExpiresAt:        now.Add(h.accessTTL),
+		RefreshExpiresAt: now.Add(h.refreshTTL),
+		Binding:          h.clientBinding(r),
 	}
+}
 
-	h.sessionsMu.Lock()
-	h.sessions[token] = session
-	h.sessionsMu.Unlock()
+// getSession returns the user of a session used by the client of r.
+// Unknown and expired tokens, and sessions ended for being used by another
+// client, give storage.ErrSessionNotFound.
+func (h *AuthHandler) getSession(r *http.Request, token string) (User, error) {
+	tokenHash := hashToken(token)
+	u, binding, err := h.sessions.GetSession(r.Context(), tokenHash)
+	if err != nil {
+		return User{}, err
+	}
+	if !h.checkBinding(r, tokenHash, u.Email, binding) {
+		return User{}, storage.ErrSessionNotFound
+	}
+	return userFromStorage(u), nil
+}
 
-	return token
+// writeSessionError answers a request whose session could not be loaded
+func writeSessionError(w http.ResponseWriter, err error) {
+	if errors.Is(err, storage.ErrSessionNotFound) {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid or expired token"})
+		return
+	}
+	slog.Error("failed to load session", "error", err)
+	w.WriteHeader(http.StatusServiceUnavailable)
+	json.NewEncoder(w).Encode(map[string]string{"error": "session store unavailable"})
 }
 
-func (h *AuthHandler) getSession(token string) (*Session, bool) {
-	h.sessionsMu.RLock()
-	session, ok := h.sessions[token]
-	h.sessionsMu.RUnlock()
+// AuditLogin is the audit event of a successful dashboard login
+const AuditLogin = "login"
 
-	if !ok {
-		return nil, false
+// Login methods, recorded with AuditLogin
+const (
+	LoginPassword = "password"
+	LoginGoogle   = "google"
+	LoginOIDC     = "oidc"
+)
+
+// createSession creates a session for user, who logged in with method, and
+// records the login in the audit log
+func (h *AuthHandler) createSession(r *http.Request, user User, method string) (sessionTokens, error) {
+	tokens, session := h.newSession(r, user.Email)
+	if err := h.sessions.CreateSession(r.Context(), session); err != nil {
+		return sessionTokens{}, err
 	}
+	h.audit(r, storage.AuditEvent{
+		Event:  AuditLogin,
+		Email:  user.Email,
+		Detail: map[string]string{"method": method, "role": user.Role},
+	})
+	return tokens, nil
+}
 
-	if time.Now().After(session.ExpiresAt) {
-		h.deleteSession(token)
-		return nil, false
+// startSession creates a session for user and writes the login response
+func (h *AuthHandler) startSession(w http.ResponseWriter, r *http.Request, user User, method string) bool {
+	tokens, err := h.createSession(r, user, method)
+	if err != nil {
+		slog.Error("failed to create session", "email", user.Email, "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "internal error"})
+		return false
 	}
 
-	return session, true
+	writeSession(w, tokens, user)
+	return true
 }
 
-func (h *AuthHandler) deleteSession(token string) {
-	h.sessionsMu.Lock()
-	delete(h.sessions, token)
-	h.sessionsMu.Unlock()
+func writeSession(w http.ResponseWriter, tokens sessionTokens, user User) {
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success":       true,
+		"token":         tokens.Token,
+		"refresh_token": tokens.RefreshToken,
+		"expires_in":    tokens.ExpiresIn,
+		"user":          user,
+	})
 }
 
-func (h *AuthHandler) cleanupExpiredSessions() {
-	ticker := time.NewTicker(15 * time.Minute)
-	for range ticker.C {
-		h.sessionsMu.Lock()
-		now := time.Now()
-		for token, session := range h.sessions {
-			if now.After(session.ExpiresAt) {
-				delete(h.sessions, token)
-			}
+// SetAllowedDomains replaces the email domains allowed to log in with
+// Google or OIDC; "*" allows any domain. Password logins are not affected.
+func (h *AuthHandler) SetAllowedDomains(domains []string) {
+	allowed := make([]string, 0, len(domains))
+	for _, d := range domains {
+		d = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(d), "@"))
+		if d != "" {
+			allowed = append(allowed, d)
 		}
-		h.sessionsMu.Unlock()
 	}
+	h.allowedDomains = allowed
 }
 
 func (h *AuthHandler) isAllowedDomain(email string) bool {
@@ -187,13 +316,18 @@ func (h *AuthHandler) isAllowedDomain(email string) bool {
 	}
 	domain := strings.ToLower(parts[1])
 	for _, allowed := range h.allowedDomains {
-		if domain == allowed {
+		if allowed == "*" || domain == allowed {
 			return true
 		}
 	}
 	return false
 }
 
+// domainDenied is the error of logins from domains not allowed
+func (h *AuthHandler) domainDenied() string {
+	return "Access denied. Only @" + strings.Join(h.allowedDomains, ", @") + " emails are allowed."
+}
+
 func (h *AuthHandler) setCORS(w http.ResponseWriter, r *http.Request) {
 	origin := r.Header.Get("Origin")
 	if h.allowAll {
@@ -223,34 +357,25 @@ func (h *AuthHandler) HandleLogin(w http.ResponseWriter, r *http.Request) {
 	login := strings.ToLower(strings.TrimSpace(req.Login))
 	password := req.Password
 
-	// Check admin users (by email or nickname)
-	for email, admin := range h.adminUsers {
-		if email == login || strings.ToLower(admin.Nickname) == login {
-			if h.verifyPassword(admin.PasswordHash, password) {
-				user := User{
-					Email:    email,
-					Name:     admin.Name,
-					Nickname: admin.Nickname,
-					Role:     "super_admin",
-					Picture:  "",
-				}
-				token := h.createSession(user)
-
-				slog.Info("admin login successful", "email", email)
-
-				json.NewEncoder(w).Encode(map[string]interface{}{
-					"success": true,
-					"token":   token,
-					"user":    user,
-				})
-				return
-			}
+	// Check registered users (by email or nickname). Users without a
+	// password can only sign in with Google.
+	stored, err := h.storage.GetUserByLogin(r.Context(), login)
+	if err != nil && !errors.Is(err, storage.ErrUserNotFound) {
+		slog.Error("failed to look up user", "login", login, "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "internal error"})
+		return
+	}
+	if err == nil && stored.PasswordHash != "" && h.verifyPassword(stored.PasswordHash, password) {
+		if err := h.storage.TouchUserLogin(r.Context(), stored.Email); err != nil {
+			slog.Warn("failed to record login", "email", stored.Email, "error", err)
+		}
+		if h.startSession(w, r, userFromStorage(stored), LoginPassword) {
+			slog.Info("login successful", "email", stored.Email, "role", stored.Role)
 		}
+		return
 	}
 
-	// TODO: Check registered users from database
-	// For now, only admin users are supported via backend auth
-
 	slog.Warn("login failed", "login", login)
 	w.WriteHeader(http.StatusUnauthorized)
 	json.NewEncoder(w).Encode(map[string]string{"error": "invalid credentials"})
@@ -263,12 +388,46 @@ func (h *AuthHandler) HandleLogout(w http.ResponseWriter, r *http.Request) {
 
 	token := extractToken(r)
 	if token != "" {
-		h.deleteSession(token)
+		if err := h.sessions.DeleteSession(r.Context(), hashToken(token)); err != nil {
+			slog.Error("failed to delete session", "error", err)
+		}
 	}
 
 	json.NewEncoder(w).Encode(map[string]bool{"success": true})
 }
 
+// HandleRefresh handles POST /api/auth/refresh - exchange a refresh token
+// for a new access and refresh token. Each refresh extends the session, so
+// only sessions idle for longer than the refresh token lifetime end.
+func (h *AuthHandler) HandleRefresh(w http.ResponseWriter, r *http.Request) {
+	h.setCORS(w, r)
+	w.Header().Set("Content-Type", "application/json")
+
+	var req struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.RefreshToken == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "refresh_token required"})
+		return
+	}
+
+	tokens, next := h.newSession(r, "")
+	stored, binding, err := h.sessions.RefreshSession(r.Context(), hashToken(req.RefreshToken), next)
+	if err != nil {
+		writeSessionError(w, err)
+		return
+	}
+	// The refreshed session is bound to this client; a mismatch with the
+	// previous binding ends it under enforce
+	if !h.checkBinding(r, next.TokenHash, stored.Email, binding) {
+		writeSessionError(w, storage.ErrSessionNotFound)
+		return
+	}
+
+	writeSession(w, tokens, userFromStorage(stored))
+}
+
 // HandleVerify handles GET /api/auth/verify - check if session is valid
 func (h *AuthHandler) HandleVerify(w http.ResponseWriter, r *http.Request) {
 	h.setCORS(w, r)
@@ -281,16 +440,15 @@ func (h *AuthHandler) HandleVerify(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	session, ok := h.getSession(token)
-	if !ok {
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(map[string]string{"error": "invalid or expired token"})
+	user, err := h.getSession(r, token)
+	if err != nil {
+		writeSessionError(w, err)
 		return
 	}
 
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"valid": true,
-		"user":  session.User,
+		"user":  user,
 	})
 }
 
@@ -324,26 +482,24 @@ func (h *AuthHandler) RequireAuth(next http.HandlerFunc) http.HandlerFunc {
 			return
 		}
 
-		session, ok := h.getSession(token)
-		if !ok {
-			w.WriteHeader(http.StatusUnauthorized)
-			json.NewEncoder(w).Encode(map[string]string{"error": "invalid or expired token"})
+		user, err := h.getSession(r, token)
+		if err != nil {
+			writeSessionError(w, err)
 			return
 		}
 
-		// Add user to context (simplified - in production use context.WithValue)
-		r.Header.Set("X-User-Email", session.User.Email)
-		r.Header.Set("X-User-Role", session.User.Role)
+		r.Header.Set("X-User-Email", user.Email)
+		r.Header.Set("X-User-Role", user.Role)
 
-		next(w, r)
+		next(w, r.WithContext(context.WithValue(r.Context(), userKey{}, user)))
 	}
 }
 
-// RequireAdmin middleware - requires admin role
+// RequireAdmin middleware - requires a role with admin permissions
 func (h *AuthHandler) RequireAdmin(next http.HandlerFunc) http.HandlerFunc {
 	return h.RequireAuth(func(w http.ResponseWriter, r *http.Request) {
-		role := r.Header.Get("X-User-Role")
-		if role != "admin" {
+		user, _ := UserFromContext(r.Context())
+		if !roles[user.Role].admin {
 			w.WriteHeader(http.StatusForbidden)
 			json.NewEncoder(w).Encode(map[string]string{"error": "admin access required"})
 			return
@@ -352,6 +508,21 @@ func (h *AuthHandler) RequireAdmin(next http.HandlerFunc) http.HandlerFunc {
 	})
 }
 
+// RequireWrite middleware - requires a role that may change dashboard state.
+// Unlike the dashboard read routes, these always need a session, also with
+// DASHBOARD_AUTH_REQUIRED=false.
+func (h *AuthHandler) RequireWrite(next http.HandlerFunc) http.HandlerFunc {
+	return h.RequireAuth(func(w http.ResponseWriter, r *http.Request) {
+		user, _ := UserFromContext(r.Context())
+		if !roles[user.Role].write {
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(map[string]string{"error": "write access required"})
+			return
+		}
+		next(w, r)
+	})
+}
+
 // HandleGoogleLogin handles POST /api/auth/google - authenticate via Google OAuth
 func (h *AuthHandler) HandleGoogleLogin(w http.ResponseWriter, r *http.Request) {
 	h.setCORS(w, r)
@@ -373,14 +544,32 @@ func (h *AuthHandler) HandleGoogleLogin(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	// Decode Google JWT (simplified - in production verify signature with Google's public keys)
-	claims, err := decodeGoogleJWT(req.Credential)
-	if err != nil {
-		slog.Warn("failed to decode Google JWT", "error", err)
+	if h.google == nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Google login is not configured"})
+		return
+	}
+
+	// Verify signature, issuer, audience and expiry of the Google ID token
+	claims, err := h.google.Verify(r.Context(), req.Credential)
+	if errors.Is(err, idtoken.ErrInvalidToken) {
+		slog.Warn("invalid Google ID token", "error", err)
 		w.WriteHeader(http.StatusUnauthorized)
 		json.NewEncoder(w).Encode(map[string]string{"error": "invalid Google token"})
 		return
 	}
+	if err != nil {
+		slog.Error("failed to verify Google ID token", "error", err)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Google login temporarily unavailable"})
+		return
+	}
+	if !claims.EmailVerified {
+		slog.Warn("Google login denied - email not verified", "email", claims.Email)
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Google email is not verified"})
+		return
+	}
 
 	email := strings.ToLower(claims.Email)
 
@@ -388,77 +577,27 @@ func (h *AuthHandler) HandleGoogleLogin(w http.ResponseWriter, r *http.Request)
 	if !h.isAllowedDomain(email) {
 		slog.Warn("Google login denied - domain not allowed", "email", email)
 		w.WriteHeader(http.StatusForbidden)
-		json.NewEncoder(w).Encode(map[string]string{"error": "Access denied. Only @starcrown.partners emails are allowed."})
+		json.NewEncoder(w).Encode(map[string]string{"error": h.domainDenied()})
 		return
 	}
 
-	// Determine role and nickname
-	role := "client"
-	nickname := claims.Name
-
-	// Check if user is in adminUsers (super_admin)
-	if admin, ok := h.adminUsers[email]; ok {
-		role = "super_admin"
-		nickname = admin.Nickname
-	}
-
-	user := User{
+	// New users join as clients; known users (including seeded admins)
+	// keep their role and nickname
+	stored, err := h.storage.RecordSignIn(r.Context(), storage.User{
 		Email:    email,
 		Name:     claims.Name,
-		Nickname: nickname,
-		Role:     role,
+		Nickname: claims.Name,
+		Role:     RoleClient,
 		Picture:  claims.Picture,
-	}
-
-	token := h.createSession(user)
-
-	slog.Info("Google login successful", "email", email, "role", role)
-
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"success": true,
-		"token":   token,
-		"user":    user,
 	})
-}
-
-// GoogleClaims represents claims from Google ID token
-type GoogleClaims struct {
-	Email   string `json:"email"`
-	Name    string `json:"name"`
-	Picture string `json:"picture"`
-}
-
-// decodeGoogleJWT decodes a Google ID token without signature verification
-// In production, you should verify the signature using Google's public keys
-func decodeGoogleJWT(token string) (*GoogleClaims, error) {
-	parts := strings.Split(token, ".")
-	if len(parts) != 3 {
-		return nil, fmt.Errorf("invalid JWT format")
-	}
-
-	// Decode payload (second part)
-	payload := parts[1]
-	// Add padding if needed
-	switch len(payload) % 4 {
-	case 2:
-		payload += "=="
-	case 3:
-		payload += "="
-	}
-
-	decoded, err := base64.URLEncoding.DecodeString(payload)
 	if err != nil {
-		// Try standard encoding
-		decoded, err = base64.StdEncoding.DecodeString(payload)
-		if err != nil {
-			return nil, fmt.Errorf("failed to decode payload: %w", err)
-		}
+		slog.Error("failed to record Google sign-in", "email", email, "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "internal error"})
+		return
 	}
 
-	var claims GoogleClaims
-	if err := json.Unmarshal(decoded, &claims); err != nil {
-		return nil, fmt.Errorf("failed to parse claims: %w", err)
+	if h.startSession(w, r, userFromStorage(stored), LoginGoogle) {
+		slog.Info("Google login successful", "email", email, "role", stored.Role)
 	}
-
-	return &claims, nil
 }
@@ -0,0 +1,255 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mcbile/product-pulse/internal/storage"
+	"github.com/mcbile/product-pulse/pkg/clock"
+)
+
+// memoryAuth keeps users, sessions and OIDC logins in memory, expiring
+// them by the clock the handler is given
+type memoryAuth struct {
+	mu       sync.Mutex
+	clock    clock.Clock
+	users    map[string]storage.User
+	sessions map[string]storage.Session // By token hash
+	logins   map[string]storage.OIDCLogin
+	audit    []storage.AuditEvent
+}
+
+func newMemoryAuth(clk clock.Clock) *memoryAuth {
+	return &memoryAuth{
+		clock:    clk,
+		users:    make(map[string]storage.User),
+		sessions: make(map[string]storage.Session),
+		logins:   make(map[string]storage.OIDCLogin),
+	}
+}
+
+func (m *memoryAuth) SeedUser(ctx context.Context, user storage.User) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.users[user.Email] = user
+	return nil
+}
+
+func (m *memoryAuth) RecordSignIn(ctx context.Context, user storage.User) (storage.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if stored, ok := m.users[user.Email]; ok {
+		return stored, nil
+	}
+	user.Role = RoleViewer
+	m.users[user.Email] = user
+	return user, nil
+}
+
+func (m *memoryAuth) GetUserByLogin(ctx context.Context, login string) (storage.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, u := range m.users {
+		if u.Email == login || u.Nickname == login {
+			return u, nil
+		}
+	}
+	return storage.User{}, storage.ErrUserNotFound
+}
+
+func (m *memoryAuth) TouchUserLogin(ctx context.Context, email string) error { return nil }
+
+func (m *memoryAuth) InsertAuditEvent(ctx context.Context, e storage.AuditEvent) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.audit = append(m.audit, e)
+	return nil
+}
+
+func (m *memoryAuth) CreateOIDCLogin(ctx context.Context, login storage.OIDCLogin) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.logins[login.StateHash] = login
+	return nil
+}
+
+func (m *memoryAuth) TakeOIDCLogin(ctx context.Context, stateHash string) (storage.OIDCLogin, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	l, ok := m.logins[stateHash]
+	delete(m.logins, stateHash)
+	if !ok || !m.clock.Now().Before(l.ExpiresAt) {
+		return storage.OIDCLogin{StateHash: stateHash}, storage.ErrOIDCLoginNotFound
+	}
+	return l, nil
+}
+
+func (m *memoryAuth) CreateSession(ctx context.Context, session storage.Session) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sessions[session.TokenHash] = session
+	return nil
+}
+
+func (m *memoryAuth) RefreshSession(ctx context.Context, refreshHash string, next storage.Session) (storage.User, storage.SessionBinding, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for hash, s := range m.sessions {
+		if s.RefreshHash != refreshHash {
+			continue
+		}
+		delete(m.sessions, hash)
+		if !m.clock.Now().Before(s.RefreshExpiresAt) {
+			break
+		}
+		next.Email = s.Email
+		m.sessions[next.TokenHash] = next
+		return m.users[s.Email], s.Binding, nil
+	}
+	return storage.User{}, storage.SessionBinding{}, storage.ErrSessionNotFound
+}
+
+func (m *memoryAuth) GetSession(ctx context.Context, tokenHash string) (storage.User, storage.SessionBinding, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.sessions[tokenHash]
+	if !ok || !m.clock.Now().Before(s.ExpiresAt) {
+		return storage.User{}, storage.SessionBinding{}, storage.ErrSessionNotFound
+	}
+	return m.users[s.Email], s.Binding, nil
+}
+
+func (m *memoryAuth) RebindSession(ctx context.Context, tokenHash string, b storage.SessionBinding) error {
+	return nil
+}
+
+func (m *memoryAuth) DeleteSession(ctx context.Context, tokenHash string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.sessions, tokenHash)
+	return nil
+}
+
+func newTestAuthHandler(t *testing.T, clk *clock.Fake) (*AuthHandler, *memoryAuth) {
+	t.Helper()
+	t.Setenv("ADMIN_USERS", "")
+	store := newMemoryAuth(clk)
+	store.users["ops@starcrown.partners"] = storage.User{
+		Email:        "ops@starcrown.partners",
+		Nickname:     "ops",
+		Role:         RoleAdmin,
+		PasswordHash: hashPassword("secret"),
+	}
+	h := NewAuthHandler(store, store, nil, 15*time.Minute, 24*time.Hour, nil)
+	h.SetClock(clk)
+	return h, store
+}
+
+func postJSON(h http.HandlerFunc, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h(w, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body)))
+	return w
+}
+
+func verify(h *AuthHandler, token string) int {
+	r := httptest.NewRequest(http.MethodGet, "/api/auth/verify", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	h.HandleVerify(w, r)
+	return w.Code
+}
+
+func TestAuthSessionExpiry(t *testing.T) {
+	clk := clock.NewFake(time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC))
+	h, _ := newTestAuthHandler(t, clk)
+
+	w := postJSON(h.HandleLogin, `{"login":"ops","password":"secret"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("login: %d %s", w.Code, w.Body)
+	}
+	var tokens sessionTokens
+	json.NewDecoder(w.Body).Decode(&tokens)
+	if tokens.ExpiresIn != 15*60 {
+		t.Errorf("expires_in %d, want %d", tokens.ExpiresIn, 15*60)
+	}
+
+	clk.Advance(15*time.Minute - time.Second)
+	if code := verify(h, tokens.Token); code != http.StatusOK {
+		t.Fatalf("access token rejected before expiry: %d", code)
+	}
+	clk.Advance(time.Second)
+	if code := verify(h, tokens.Token); code != http.StatusUnauthorized {
+		t.Fatalf("expired access token: %d", code)
+	}
+
+	// Refreshing extends the session from now
+	w = postJSON(h.HandleRefresh, `{"refresh_token":"`+tokens.RefreshToken+`"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("refresh: %d %s", w.Code, w.Body)
+	}
+	var refreshed sessionTokens
+	json.NewDecoder(w.Body).Decode(&refreshed)
+	clk.Advance(15*time.Minute - time.Second)
+	if code := verify(h, refreshed.Token); code != http.StatusOK {
+		t.Fatalf("refreshed token rejected: %d", code)
+	}
+
+	// Refresh tokens are single use and expire after the refresh TTL
+	if w := postJSON(h.HandleRefresh, `{"refresh_token":"`+tokens.RefreshToken+`"}`); w.Code != http.StatusUnauthorized {
+		t.Errorf("reused refresh token: %d", w.Code)
+	}
+	clk.Advance(24 * time.Hour)
+	if w := postJSON(h.HandleRefresh, `{"refresh_token":"`+refreshed.RefreshToken+`"}`); w.Code != http.StatusUnauthorized {
+		t.Errorf("expired refresh token: %d", w.Code)
+	}
+}
+
+// login signs in as login and returns the access token
+func login(t *testing.T, h *AuthHandler, login string) string {
+	t.Helper()
+	w := postJSON(h.HandleLogin, `{"login":"`+login+`","password":"secret"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("login %s: %d %s", login, w.Code, w.Body)
+	}
+	var tokens sessionTokens
+	json.NewDecoder(w.Body).Decode(&tokens)
+	return tokens.Token
+}
+
+func TestRequireWrite(t *testing.T) {
+	h, store := newTestAuthHandler(t, clock.NewFake(time.Now()))
+	store.users["viewer@starcrown.partners"] = storage.User{
+		Email:        "viewer@starcrown.partners",
+		Nickname:     "viewer",
+		Role:         RoleViewer,
+		PasswordHash: hashPassword("secret"),
+	}
+	protected := h.RequireWrite(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	call := func(token string) int {
+		r := httptest.NewRequest(http.MethodPost, "/api/alerts/bulk", nil)
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		protected(w, r)
+		return w.Code
+	}
+
+	if code := call(""); code != http.StatusUnauthorized {
+		t.Errorf("anonymous: %d, want 401", code)
+	}
+	if code := call(login(t, h, "viewer")); code != http.StatusForbidden {
+		t.Errorf("viewer: %d, want 403", code)
+	}
+	if code := call(login(t, h, "ops")); code != http.StatusNoContent {
+		t.Errorf("admin: %d, want 204", code)
+	}
+}
@@ -0,0 +1,277 @@
+package handler
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/mcbile/product-pulse/internal/collector"
+	"github.com/mcbile/product-pulse/internal/enrich"
+	"github.com/mcbile/product-pulse/internal/middleware"
+	"github.com/mcbile/product-pulse/internal/model"
+	"github.com/mcbile/product-pulse/internal/quality"
+	"github.com/mcbile/product-pulse/internal/sdk"
+	"github.com/mcbile/product-pulse/internal/trace"
+)
+
+// ============================================
+// BATCH COLLECT HANDLER (all metric types)
+// ============================================
+
+// BatchCollectHandler accepts frontend events and API, PSP, game and WS
+// metrics in a single envelope, so clients need one round trip per flush
+type BatchCollectHandler struct {
+	collector      *collector.BatchCollector
+	backend        *collector.Backend
+	limits         *quality.Limits
+	pipeline       *enrich.Pipeline
+	backfill       bool // Skip the maximum event age, no frontend events
+	allowedOrigins map[string]bool
+	allowAll       bool
+}
+
+func NewBatchCollectHandler(c *collector.BatchCollector, backend *collector.Backend, limits *quality.Limits, pipeline *enrich.Pipeline, origins []string) *BatchCollectHandler {
+	h := &BatchCollectHandler{
+		collector:      c,
+		backend:        backend,
+		limits:         limits,
+		pipeline:       pipeline,
+		allowedOrigins: make(map[string]bool),
+	}
+	for _, o := range origins {
+		if o == "*" {
+			h.allowAll = true
+			break
+		}
+		h.allowedOrigins[o] = true
+	}
+	return h
+}
+
+// NewBackfillCollectHandler creates a batch handler for importing historical
+// backend metrics: events older than the site's maximum event age are
+// accepted. Frontend events are clamped to the current time at ingest and
+// cannot be backfilled.
+func NewBackfillCollectHandler(c *collector.BatchCollector, backend *collector.Backend, limits *quality.Limits, origins []string) *BatchCollectHandler {
+	h := NewBatchCollectHandler(c, backend, limits, nil, origins)
+	h.backfill = true
+	return h
+}
+
+// Handle handles POST /collect/batch and POST /collect/backfill. Sections are queued independently; if
+// a section could not be queued at all the response is 503 and lists the
+// failed sections, which are the only ones a client should resend. If a
+// targeted queue is above its high watermark nothing is queued and the
+// response is 429 with Retry-After.
+func (h *BatchCollectHandler) Handle(w http.ResponseWriter, r *http.Request) {
+	h.setCORS(w, r)
+	batchID := startBatch(w, r)
+
+	var env model.BatchEnvelope
+	malformed, err := decodeBatch(r, &env)
+	if err != nil {
+		slog.Debug("invalid request body", "error", err)
+		writeDecodeError(w, err)
+		return
+	}
+	reportEnvelopeEvents(r, &env, malformed)
+	if h.backfill && len(env.Events) > 0 {
+		http.Error(w, "frontend events cannot be backfilled", http.StatusBadRequest)
+		return
+	}
+
+	// Service accounts may only send the sections their scopes allow
+	var forbidden []string
+	for _, sec := range []struct {
+		scope string
+		count int
+	}{
+		{"frontend", len(env.Events)},
+		{"api", len(env.API)},
+		{"psp", len(env.PSP)},
+		{"game", len(env.Game)},
+		{"ws", len(env.WS)},
+	} {
+		if sec.count > 0 && !middleware.ScopeAllowed(r, sec.scope) {
+			forbidden = append(forbidden, sec.scope)
+		}
+	}
+	if len(forbidden) > 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":    "error",
+			"forbidden": forbidden,
+		})
+		return
+	}
+
+	// Refuse the whole envelope if any collector it targets is saturated,
+	// so clients never have to split it up to retry
+	var targets []admitter
+	if len(env.Events) > 0 {
+		targets = append(targets, h.collector)
+	}
+	if len(env.API) > 0 {
+		targets = append(targets, h.backend.API)
+	}
+	if len(env.PSP) > 0 {
+		targets = append(targets, h.backend.PSP)
+	}
+	if len(env.Game) > 0 {
+		targets = append(targets, h.backend.Game)
+	}
+	if len(env.WS) > 0 {
+		targets = append(targets, h.backend.WS)
+	}
+	if !admit(w, targets...) {
+		return
+	}
+
+	site := r.Header.Get("X-Site-Id")
+	now := time.Now().UTC()
+	rejected := len(malformed)
+	var failed []string
+
+	for _, m := range malformed {
+		h.limits.CountMalformed(site, envelopeMetricTypes[m.Section], 1)
+	}
+
+	if len(env.Events) > 0 {
+		rejected += queueFrontendEvents(h.collector, h.limits, h.pipeline, r, batchID, env.Events)
+		middleware.ReportMetricTypes(r, "frontend")
+	}
+
+	api := filterMetrics(env.API, func(m *model.APIMetric) bool {
+		m.SiteID = site
+		return h.prepare(site, "api", now, &m.Time, &m.Metadata, &m.ErrorMessage)
+	})
+	psp := filterMetrics(env.PSP, func(m *model.PSPMetric) bool {
+		m.SiteID = site
+		return m.ValidState() && h.prepare(site, "psp", now, &m.Time, &m.Metadata, &m.ErrorMessage)
+	})
+	game := filterMetrics(env.Game, func(m *model.GameMetric) bool {
+		m.SiteID = site
+		return h.prepare(site, "game", now, &m.Time, &m.Metadata, &m.ErrorMessage)
+	})
+	ws := filterMetrics(env.WS, func(m *model.WebSocketMetric) bool {
+		m.SiteID = site
+		return h.prepare(site, "ws", now, &m.Time, &m.Metadata, nil)
+	})
+	rejected += len(env.API) - len(api) + len(env.PSP) - len(psp) +
+		len(env.Game) - len(game) + len(env.WS) - len(ws)
+
+	sections := []struct {
+		name  string
+		count int
+		push  func() int
+	}{
+		{"api", len(api), func() int { return h.backend.API.PushBatch(batchID, api) }},
+		{"psp", len(psp), func() int { return h.backend.PSP.PushBatch(batchID, psp) }},
+		{"game", len(game), func() int { return h.backend.Game.PushBatch(batchID, game) }},
+		{"ws", len(ws), func() int { return h.backend.WS.PushBatch(batchID, ws) }},
+	}
+	for _, sec := range sections {
+		if sec.count == 0 {
+			continue
+		}
+		middleware.ReportMetricTypes(r, sec.name)
+		dropped := sec.push()
+		if dropped == sec.count {
+			failed = append(failed, sec.name)
+			continue
+		}
+		rejected += dropped
+	}
+
+	if len(failed) > 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		if len(malformed) > maxReportedRejections {
+			malformed = malformed[:maxReportedRejections]
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":    "error",
+			"failed":    failed,
+			"rejected":  rejected,
+			"malformed": malformed,
+		})
+		return
+	}
+
+	writeAccepted(w, rejected, malformed)
+}
+
+// reportEnvelopeEvents tells the usage meter how many events of each type
+// an envelope carried, malformed ones included
+func reportEnvelopeEvents(r *http.Request, env *model.BatchEnvelope, malformed []model.Rejection) {
+	counts := map[string]int{
+		"frontend": len(env.Events),
+		"api":      len(env.API),
+		"psp":      len(env.PSP),
+		"game":     len(env.Game),
+		"ws":       len(env.WS),
+	}
+	for _, m := range malformed {
+		counts[envelopeMetricTypes[m.Section]]++
+	}
+	for _, t := range []string{"frontend", "api", "psp", "game", "ws"} {
+		middleware.ReportEvents(r, t, counts[t])
+	}
+}
+
+// envelopeMetricTypes maps BatchEnvelope sections to metric types
+var envelopeMetricTypes = map[string]string{
+	"events": "frontend",
+	"api":    "api",
+	"psp":    "psp",
+	"game":   "game",
+	"ws":     "ws",
+}
+
+// prepare checks the maximum event age unless backfilling, applies field
+// size policies and stamps missing times
+func (h *BatchCollectHandler) prepare(site, metricType string, now time.Time, t *time.Time, metadata *json.RawMessage, errorMessage **string) bool {
+	if h.backfill {
+		if !h.limits.Apply(site, metadata, errorMessage) {
+			return false
+		}
+		if t.IsZero() {
+			*t = now
+		}
+		return true
+	}
+	return h.limits.Admit(site, metricType, now, t, metadata, errorMessage)
+}
+
+func (h *BatchCollectHandler) HandleCORS(w http.ResponseWriter, r *http.Request) {
+	h.setCORS(w, r)
+	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Encoding, X-Site-Id, "+sdk.Header+", "+trace.Header+", "+middleware.APIKeyHeader)
+	w.Header().Set("Access-Control-Max-Age", "86400")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *BatchCollectHandler) setCORS(w http.ResponseWriter, r *http.Request) {
+	origin := r.Header.Get("Origin")
+	if h.allowAll {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	} else if h.allowedOrigins[origin] {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+	}
+	w.Header().Set("Access-Control-Expose-Headers", sdk.DeprecationHeader+", "+trace.Header+", Retry-After")
+}
+
+// filterMetrics keeps the metrics for which keep returns true, reusing the
+// backing array. keep may modify the metric.
+func filterMetrics[T any](metrics []T, keep func(*T) bool) []T {
+	out := metrics[:0]
+	for i := range metrics {
+		m := metrics[i]
+		if keep(&m) {
+			out = append(out, m)
+		}
+	}
+	return out
+}
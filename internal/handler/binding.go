@@ -0,0 +1,131 @@
+package handler
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mcbile/product-pulse/internal/storage"
+)
+
+// ============================================
+// SESSION BINDING
+// ============================================
+
+// Session binding modes (SESSION_BINDING). Every session records the IP
+// prefix and User-Agent of the client that logged in; with warn a request
+// from another client is logged to the audit log and the session follows
+// it, with enforce the session is ended.
+const (
+	SessionBindingOff     = "off"
+	SessionBindingWarn    = "warn"
+	SessionBindingEnforce = "enforce"
+)
+
+// AuditSessionBindingMismatch is the audit event of a session used by
+// another client than the one it is bound to
+const AuditSessionBindingMismatch = "session_binding_mismatch"
+
+// ValidSessionBinding reports whether mode is a session binding mode
+func ValidSessionBinding(mode string) bool {
+	switch mode {
+	case SessionBindingOff, SessionBindingWarn, SessionBindingEnforce:
+		return true
+	}
+	return false
+}
+
+// SetSessionBinding sets how sessions are bound to their client: mode is
+// one of the SessionBinding modes, and clients match when their IPs share
+// the first ipv4Bits or ipv6Bits bits
+func (h *AuthHandler) SetSessionBinding(mode string, ipv4Bits, ipv6Bits int) {
+	h.bindingMode = mode
+	h.bindingIPv4Bits = ipv4Bits
+	h.bindingIPv6Bits = ipv6Bits
+}
+
+// clientBinding is the binding of the client sending r
+func (h *AuthHandler) clientBinding(r *http.Request) storage.SessionBinding {
+	var b storage.SessionBinding
+	if ip := net.ParseIP(getClientIP(r)); ip != nil {
+		mask := net.CIDRMask(h.bindingIPv6Bits, 128)
+		if v4 := ip.To4(); v4 != nil {
+			ip, mask = v4, net.CIDRMask(h.bindingIPv4Bits, 32)
+		}
+		b.ClientNet = (&net.IPNet{IP: ip.Mask(mask), Mask: mask}).String()
+	}
+	if ua := r.UserAgent(); ua != "" {
+		b.UserAgentHash = hashToken(ua)
+	}
+	return b
+}
+
+// bindingMismatch lists what differs between a session's binding and the
+// client's: "ip" and "user_agent". Unrecorded fields match.
+func bindingMismatch(session, client storage.SessionBinding) []string {
+	var mismatch []string
+	if session.ClientNet != "" && session.ClientNet != client.ClientNet {
+		mismatch = append(mismatch, "ip")
+	}
+	if session.UserAgentHash != "" && session.UserAgentHash != client.UserAgentHash {
+		mismatch = append(mismatch, "user_agent")
+	}
+	return mismatch
+}
+
+// checkBinding compares the session with tokenHash, bound to session, with
+// the client of r. It returns false when the session was ended because of
+// a mismatch; with warn the session is moved to the client instead.
+func (h *AuthHandler) checkBinding(r *http.Request, tokenHash, email string, session storage.SessionBinding) bool {
+	if h.bindingMode != SessionBindingWarn && h.bindingMode != SessionBindingEnforce {
+		return true
+	}
+	client := h.clientBinding(r)
+	mismatch := bindingMismatch(session, client)
+	if len(mismatch) == 0 {
+		return true
+	}
+
+	action := "rebound"
+	if h.bindingMode == SessionBindingEnforce {
+		action = "revoked"
+		if err := h.sessions.DeleteSession(r.Context(), tokenHash); err != nil {
+			slog.Error("failed to delete session", "error", err)
+		}
+	} else if err := h.sessions.RebindSession(r.Context(), tokenHash, client); err != nil {
+		slog.Error("failed to rebind session", "email", email, "error", err)
+	}
+
+	slog.Warn("session used by another client", "email", email, "mismatch", mismatch,
+		"action", action, "ip", getClientIP(r), "path", r.URL.Path)
+	h.audit(r, storage.AuditEvent{
+		Event: AuditSessionBindingMismatch,
+		Email: email,
+		Detail: map[string]string{
+			"mismatch":     strings.Join(mismatch, ","),
+			"action":       action,
+			"session_net":  session.ClientNet,
+			"client_net":   client.ClientNet,
+			"path":         r.URL.Path,
+			"binding_mode": h.bindingMode,
+		},
+	})
+	return action != "revoked"
+}
+
+// audit writes an event of the request r to the audit log. The write
+// outlives the request, so events of aborted requests are kept too.
+func (h *AuthHandler) audit(r *http.Request, e storage.AuditEvent) {
+	e.Time = h.clock.Now().UTC()
+	e.IP = getClientIP(r)
+	e.UserAgent = r.UserAgent()
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), 5*time.Second)
+	defer cancel()
+	if err := h.storage.InsertAuditEvent(ctx, e); err != nil {
+		slog.Error("failed to write audit event", "event", e.Event, "email", e.Email, "error", err)
+	}
+}
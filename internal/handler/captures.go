@@ -0,0 +1,245 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/mcbile/product-pulse/internal/middleware"
+	"github.com/mcbile/product-pulse/internal/storage"
+)
+
+// ============================================
+// DIAGNOSTIC CAPTURES HANDLER (admin)
+// ============================================
+
+const (
+	defaultCaptureDuration = 15 * time.Minute
+	maxCaptureDuration     = time.Hour
+	defaultCaptureRecords  = 1000
+	maxCaptureRecords      = 10000
+	maxCaptureRecordPage   = 500
+)
+
+// CaptureStorage is the subset of storage used for diagnostic captures
+type CaptureStorage interface {
+	CreateCapture(ctx context.Context, c storage.Capture) (storage.Capture, error)
+	GetCaptures(ctx context.Context) ([]storage.Capture, error)
+	StopCapture(ctx context.Context, id int64) (bool, error)
+	GetCaptureRecords(ctx context.Context, captureID int64, limit, offset int) ([]storage.CaptureRecord, error)
+}
+
+// CaptureHandler lets admins record the requests of a site or client IP for
+// a limited time, to debug missing data without packet captures
+type CaptureHandler struct {
+	storage        CaptureStorage
+	recorder       *middleware.Recorder
+	allowedOrigins map[string]bool
+	allowAll       bool
+}
+
+func NewCaptureHandler(store CaptureStorage, recorder *middleware.Recorder, origins []string) *CaptureHandler {
+	h := &CaptureHandler{
+		storage:        store,
+		recorder:       recorder,
+		allowedOrigins: make(map[string]bool),
+	}
+	for _, o := range origins {
+		if o == "*" {
+			h.allowAll = true
+			break
+		}
+		h.allowedOrigins[o] = true
+	}
+	return h
+}
+
+type captureRequest struct {
+	SiteID     string `json:"site_id"`
+	ClientIP   string `json:"client_ip"` // Address or CIDR range
+	Duration   string `json:"duration"`  // Default 15m, at most 1h
+	MaxRecords int    `json:"max_records"`
+	Note       string `json:"note"`
+}
+
+// HandleCreate starts recording the requests of a site and/or client IP
+// POST /api/admin/captures
+func (h *CaptureHandler) HandleCreate(w http.ResponseWriter, r *http.Request) {
+	h.setCORS(w, r)
+
+	var req captureRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if req.SiteID == "" && req.ClientIP == "" {
+		http.Error(w, "site_id or client_ip is required", http.StatusBadRequest)
+		return
+	}
+	if req.ClientIP != "" {
+		network, err := middleware.ParseClientNetwork(req.ClientIP)
+		if err != nil {
+			http.Error(w, "invalid client_ip", http.StatusBadRequest)
+			return
+		}
+		req.ClientIP = network.String()
+	}
+
+	duration := defaultCaptureDuration
+	if req.Duration != "" {
+		d, err := time.ParseDuration(req.Duration)
+		if err != nil || d <= 0 || d > maxCaptureDuration {
+			http.Error(w, "duration must be between 0 and "+maxCaptureDuration.String(), http.StatusBadRequest)
+			return
+		}
+		duration = d
+	}
+	if req.MaxRecords == 0 {
+		req.MaxRecords = defaultCaptureRecords
+	}
+	if req.MaxRecords < 0 || req.MaxRecords > maxCaptureRecords {
+		http.Error(w, "max_records must be between 1 and "+strconv.Itoa(maxCaptureRecords), http.StatusBadRequest)
+		return
+	}
+
+	user, _ := UserFromContext(r.Context())
+	now := time.Now().UTC()
+	capture, err := h.storage.CreateCapture(r.Context(), storage.Capture{
+		SiteID:     req.SiteID,
+		ClientIP:   req.ClientIP,
+		Note:       req.Note,
+		CreatedBy:  user.Email,
+		StartedAt:  now,
+		ExpiresAt:  now.Add(duration),
+		MaxRecords: req.MaxRecords,
+	})
+	if err != nil {
+		slog.Error("failed to create capture", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	h.reload(r.Context())
+
+	slog.Info("diagnostic capture started",
+		"capture", capture.ID, "site_id", capture.SiteID, "client_ip", capture.ClientIP,
+		"expires_at", capture.ExpiresAt, "by", capture.CreatedBy)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(capture)
+}
+
+// HandleList returns all captures with their record counts
+// GET /api/admin/captures
+func (h *CaptureHandler) HandleList(w http.ResponseWriter, r *http.Request) {
+	h.setCORS(w, r)
+
+	captures, err := h.storage.GetCaptures(r.Context())
+	if err != nil {
+		slog.Error("failed to list captures", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"captures":        captures,
+		"dropped_records": h.recorder.Dropped(),
+	})
+}
+
+// HandleRecords returns the recorded requests of a capture, oldest first
+// GET /api/admin/captures/{id}/records?limit=100&offset=0
+func (h *CaptureHandler) HandleRecords(w http.ResponseWriter, r *http.Request) {
+	h.setCORS(w, r)
+
+	id, ok := parseCaptureID(w, r)
+	if !ok {
+		return
+	}
+	q := r.URL.Query()
+	limit, offset := 100, 0
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxCaptureRecordPage {
+			http.Error(w, "limit must be between 1 and "+strconv.Itoa(maxCaptureRecordPage), http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	if v := q.Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			http.Error(w, "invalid offset", http.StatusBadRequest)
+			return
+		}
+		offset = n
+	}
+
+	records, err := h.storage.GetCaptureRecords(r.Context(), id, limit, offset)
+	if err != nil {
+		slog.Error("failed to get capture records", "capture", id, "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"records": records,
+	})
+}
+
+// HandleStop ends a capture before it expires
+// DELETE /api/admin/captures/{id}
+func (h *CaptureHandler) HandleStop(w http.ResponseWriter, r *http.Request) {
+	h.setCORS(w, r)
+
+	id, ok := parseCaptureID(w, r)
+	if !ok {
+		return
+	}
+
+	stopped, err := h.storage.StopCapture(r.Context(), id)
+	if err != nil {
+		slog.Error("failed to stop capture", "capture", id, "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if !stopped {
+		http.Error(w, "capture not found or already ended", http.StatusNotFound)
+		return
+	}
+	h.reload(r.Context())
+
+	slog.Info("diagnostic capture stopped", "capture", id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// reload applies a change on this collector immediately; others pick it up
+// with their next periodic reload
+func (h *CaptureHandler) reload(ctx context.Context) {
+	if err := h.recorder.Reload(ctx); err != nil {
+		slog.Error("failed to reload captures", "error", err)
+	}
+}
+
+func parseCaptureID(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid capture id", http.StatusBadRequest)
+		return 0, false
+	}
+	return id, true
+}
+
+func (h *CaptureHandler) setCORS(w http.ResponseWriter, r *http.Request) {
+	origin := r.Header.Get("Origin")
+	if h.allowAll {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	} else if h.allowedOrigins[origin] {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+	}
+}
@@ -0,0 +1,245 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/mcbile/product-pulse/internal/middleware"
+	"github.com/mcbile/product-pulse/internal/storage"
+)
+
+// ============================================
+// SITE CREDENTIALS HANDLER (admin)
+// ============================================
+
+// CredentialStorage is the subset of storage used for credential management
+type CredentialStorage interface {
+	RotateCredential(ctx context.Context, cred storage.SiteCredential, graceEnd time.Time) (storage.SiteCredential, error)
+	GetCredentials(ctx context.Context, siteID string) ([]storage.SiteCredential, error)
+	GetCredential(ctx context.Context, siteID string, id int64) (storage.SiteCredential, error)
+	RevokeCredential(ctx context.Context, siteID string, id int64) (bool, error)
+}
+
+// CredentialHandler issues, lists, rotates and revokes site API keys and
+// signing secrets. Issuing a credential rotates the site's existing ones of
+// the same kind and scopes: they keep working for a grace period so
+// producers can be redeployed one at a time.
+type CredentialHandler struct {
+	storage        CredentialStorage
+	auth           *middleware.SiteAuth
+	gracePeriod    time.Duration
+	allowedOrigins map[string]bool
+	allowAll       bool
+}
+
+func NewCredentialHandler(store CredentialStorage, auth *middleware.SiteAuth, gracePeriod time.Duration, origins []string) *CredentialHandler {
+	h := &CredentialHandler{
+		storage:        store,
+		auth:           auth,
+		gracePeriod:    gracePeriod,
+		allowedOrigins: make(map[string]bool),
+	}
+	for _, o := range origins {
+		if o == "*" {
+			h.allowAll = true
+			break
+		}
+		h.allowedOrigins[o] = true
+	}
+	return h
+}
+
+type createCredentialRequest struct {
+	Kind        string   `json:"kind"`         // api_key or hmac_secret
+	Scopes      []string `json:"scopes"`       // Metric types the credential may send; empty allows all
+	GracePeriod string   `json:"grace_period"` // Go duration; defaults to CREDENTIAL_GRACE_PERIOD
+}
+
+type rotateCredentialRequest struct {
+	GracePeriod string `json:"grace_period"` // Go duration; defaults to CREDENTIAL_GRACE_PERIOD
+}
+
+// HandleCreate issues a new credential and starts the grace period of the
+// ones it replaces. The secret is only returned in this response.
+// POST /api/sites/{site}/credentials
+func (h *CredentialHandler) HandleCreate(w http.ResponseWriter, r *http.Request) {
+	h.setCORS(w, r)
+
+	var req createCredentialRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	grace, ok := h.parseGracePeriod(w, req.GracePeriod)
+	if !ok {
+		return
+	}
+	for _, s := range req.Scopes {
+		if !middleware.ValidScope(s) {
+			http.Error(w, "unknown scope "+strconv.Quote(s), http.StatusBadRequest)
+			return
+		}
+	}
+	// Sorted so that rotation finds the credentials with the same scopes
+	scopes := slices.Clone(req.Scopes)
+	slices.Sort(scopes)
+	scopes = slices.Compact(scopes)
+
+	h.issue(w, r, req.Kind, scopes, grace)
+}
+
+// HandleRotate replaces a credential with a new one of the same kind and
+// scopes. The old one keeps working for the grace period.
+// POST /api/sites/{site}/credentials/{id}/rotate
+func (h *CredentialHandler) HandleRotate(w http.ResponseWriter, r *http.Request) {
+	h.setCORS(w, r)
+
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid credential id", http.StatusBadRequest)
+		return
+	}
+
+	var req rotateCredentialRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid JSON", http.StatusBadRequest)
+			return
+		}
+	}
+	grace, ok := h.parseGracePeriod(w, req.GracePeriod)
+	if !ok {
+		return
+	}
+
+	old, err := h.storage.GetCredential(r.Context(), r.PathValue("site"), id)
+	if errors.Is(err, storage.ErrCredentialNotFound) {
+		http.Error(w, "credential not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		slog.Error("failed to get credential", "id", id, "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if old.RevokedAt != nil || (old.ExpiresAt != nil && old.ExpiresAt.Before(time.Now())) {
+		http.Error(w, "credential is no longer active", http.StatusConflict)
+		return
+	}
+
+	h.issue(w, r, old.Kind, old.Scopes, grace)
+}
+
+func (h *CredentialHandler) parseGracePeriod(w http.ResponseWriter, value string) (time.Duration, bool) {
+	if value == "" {
+		return h.gracePeriod, true
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		http.Error(w, "invalid grace_period", http.StatusBadRequest)
+		return 0, false
+	}
+	return d, true
+}
+
+// issue generates and stores a credential, rotating the site's existing
+// ones of the same kind and scopes, and writes it with its secret
+func (h *CredentialHandler) issue(w http.ResponseWriter, r *http.Request, kind string, scopes []string, grace time.Duration) {
+	cred, secret, err := middleware.NewCredential(r.PathValue("site"), kind, scopes)
+	if errors.Is(err, middleware.ErrUnknownCredentialKind) {
+		http.Error(w, "kind must be api_key or hmac_secret", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		slog.Error("failed to generate credential", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	cred, err = h.storage.RotateCredential(r.Context(), cred, time.Now().Add(grace))
+	if err != nil {
+		slog.Error("failed to store credential", "site_id", cred.SiteID, "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	h.reload(r.Context())
+
+	slog.Info("site credential issued", "site_id", cred.SiteID, "kind", cred.Kind, "scopes", cred.Scopes, "prefix", cred.Prefix, "grace_period", grace.String())
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"credential": cred,
+		"secret":     secret,
+	})
+}
+
+// HandleList returns a site's credentials with their usage
+// GET /api/sites/{site}/credentials
+func (h *CredentialHandler) HandleList(w http.ResponseWriter, r *http.Request) {
+	h.setCORS(w, r)
+
+	creds, err := h.storage.GetCredentials(r.Context(), r.PathValue("site"))
+	if err != nil {
+		slog.Error("failed to list credentials", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"credentials": creds,
+	})
+}
+
+// HandleRevoke revokes a credential immediately
+// DELETE /api/sites/{site}/credentials/{id}
+func (h *CredentialHandler) HandleRevoke(w http.ResponseWriter, r *http.Request) {
+	h.setCORS(w, r)
+
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid credential id", http.StatusBadRequest)
+		return
+	}
+
+	revoked, err := h.storage.RevokeCredential(r.Context(), r.PathValue("site"), id)
+	if err != nil {
+		slog.Error("failed to revoke credential", "id", id, "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if !revoked {
+		http.Error(w, "credential not found", http.StatusNotFound)
+		return
+	}
+	h.reload(r.Context())
+
+	slog.Info("site credential revoked", "site_id", r.PathValue("site"), "id", id)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{"status":"revoked"}`))
+}
+
+// reload applies a change right away instead of on the next refresh
+func (h *CredentialHandler) reload(ctx context.Context) {
+	if err := h.auth.Reload(ctx); err != nil {
+		slog.Error("failed to reload site credentials", "error", err)
+	}
+}
+
+func (h *CredentialHandler) setCORS(w http.ResponseWriter, r *http.Request) {
+	origin := r.Header.Get("Origin")
+	if h.allowAll {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	} else if h.allowedOrigins[origin] {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+	}
+}
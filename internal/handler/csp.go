@@ -0,0 +1,192 @@
+package handler
+
+import (
+	"encoding/json"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mcbile/product-pulse/internal/model"
+	"github.com/mcbile/product-pulse/internal/storage"
+)
+
+// ============================================
+// CSP REPORT HANDLER
+// ============================================
+
+// CSPCollectHandler accepts Content-Security-Policy violation reports sent by
+// browsers via report-uri (application/csp-report) or report-to
+// (application/reports+json)
+type CSPCollectHandler struct {
+	db             *storage.Postgres
+	allowedOrigins map[string]bool
+	allowAll       bool
+}
+
+func NewCSPCollectHandler(db *storage.Postgres, origins []string) *CSPCollectHandler {
+	h := &CSPCollectHandler{
+		db:             db,
+		allowedOrigins: make(map[string]bool),
+	}
+	for _, o := range origins {
+		if o == "*" {
+			h.allowAll = true
+			break
+		}
+		h.allowedOrigins[o] = true
+	}
+	return h
+}
+
+// legacyCSPReport is the report-uri body: {"csp-report": {...}}
+type legacyCSPReport struct {
+	Report struct {
+		DocumentURI        string `json:"document-uri"`
+		Referrer           string `json:"referrer"`
+		ViolatedDirective  string `json:"violated-directive"`
+		EffectiveDirective string `json:"effective-directive"`
+		OriginalPolicy     string `json:"original-policy"`
+		Disposition        string `json:"disposition"`
+		BlockedURI         string `json:"blocked-uri"`
+		StatusCode         int    `json:"status-code"`
+		SourceFile         string `json:"source-file"`
+		LineNumber         int    `json:"line-number"`
+		ColumnNumber       int    `json:"column-number"`
+		ScriptSample       string `json:"script-sample"`
+	} `json:"csp-report"`
+}
+
+// reportingAPIReport is a single entry of a report-to body
+type reportingAPIReport struct {
+	Type      string `json:"type"`
+	Age       int64  `json:"age"` // milliseconds between violation and delivery
+	URL       string `json:"url"`
+	UserAgent string `json:"user_agent"`
+	Body      struct {
+		DocumentURL        string `json:"documentURL"`
+		Referrer           string `json:"referrer"`
+		BlockedURL         string `json:"blockedURL"`
+		EffectiveDirective string `json:"effectiveDirective"`
+		OriginalPolicy     string `json:"originalPolicy"`
+		SourceFile         string `json:"sourceFile"`
+		Sample             string `json:"sample"`
+		Disposition        string `json:"disposition"`
+		StatusCode         int    `json:"statusCode"`
+		LineNumber         int    `json:"lineNumber"`
+		ColumnNumber       int    `json:"columnNumber"`
+	} `json:"body"`
+}
+
+func (h *CSPCollectHandler) Handle(w http.ResponseWriter, r *http.Request) {
+	h.setCORS(w, r)
+
+	now := time.Now().UTC()
+	userAgent := r.UserAgent()
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	var reports []model.CSPReport
+	if mediaType == "application/reports+json" {
+		var batch []reportingAPIReport
+		if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+			slog.Debug("invalid csp report body", "error", err)
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+
+		for _, rep := range batch {
+			if rep.Type != "csp-violation" {
+				continue
+			}
+			ua := rep.UserAgent
+			if ua == "" {
+				ua = userAgent
+			}
+			reports = append(reports, model.CSPReport{
+				Time:               now.Add(-time.Duration(rep.Age) * time.Millisecond),
+				DocumentURI:        rep.Body.DocumentURL,
+				Referrer:           rep.Body.Referrer,
+				ViolatedDirective:  rep.Body.EffectiveDirective,
+				EffectiveDirective: rep.Body.EffectiveDirective,
+				OriginalPolicy:     rep.Body.OriginalPolicy,
+				Disposition:        rep.Body.Disposition,
+				BlockedURI:         rep.Body.BlockedURL,
+				StatusCode:         rep.Body.StatusCode,
+				SourceFile:         rep.Body.SourceFile,
+				LineNumber:         rep.Body.LineNumber,
+				ColumnNumber:       rep.Body.ColumnNumber,
+				ScriptSample:       rep.Body.Sample,
+				UserAgent:          ua,
+			})
+		}
+	} else {
+		var legacy legacyCSPReport
+		if err := json.NewDecoder(r.Body).Decode(&legacy); err != nil {
+			slog.Debug("invalid csp report body", "error", err)
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+
+		rep := legacy.Report
+		effective := rep.EffectiveDirective
+		if effective == "" {
+			// Older browsers only send violated-directive, possibly with a value
+			effective, _, _ = strings.Cut(rep.ViolatedDirective, " ")
+		}
+		reports = append(reports, model.CSPReport{
+			Time:               now,
+			DocumentURI:        rep.DocumentURI,
+			Referrer:           rep.Referrer,
+			ViolatedDirective:  rep.ViolatedDirective,
+			EffectiveDirective: effective,
+			OriginalPolicy:     rep.OriginalPolicy,
+			Disposition:        rep.Disposition,
+			BlockedURI:         rep.BlockedURI,
+			StatusCode:         rep.StatusCode,
+			SourceFile:         rep.SourceFile,
+			LineNumber:         rep.LineNumber,
+			ColumnNumber:       rep.ColumnNumber,
+			ScriptSample:       rep.ScriptSample,
+			UserAgent:          userAgent,
+		})
+	}
+
+	// Drop reports without a directive, they cannot be aggregated
+	valid := reports[:0]
+	for _, rep := range reports {
+		if rep.EffectiveDirective != "" {
+			valid = append(valid, rep)
+		}
+	}
+
+	if len(valid) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if err := h.db.InsertCSPReports(r.Context(), valid); err != nil {
+		slog.Error("failed to insert CSP reports", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *CSPCollectHandler) HandleCORS(w http.ResponseWriter, r *http.Request) {
+	h.setCORS(w, r)
+	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	w.Header().Set("Access-Control-Max-Age", "86400")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *CSPCollectHandler) setCORS(w http.ResponseWriter, r *http.Request) {
+	origin := r.Header.Get("Origin")
+	if h.allowAll {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	} else if h.allowedOrigins[origin] {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+	}
+}
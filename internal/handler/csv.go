@@ -0,0 +1,147 @@
+package handler
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ============================================
+// CSV EXPORT
+// ============================================
+
+// csvFlushRows is how many rows are buffered before they are flushed to the
+// client
+const csvFlushRows = 500
+
+// wantsCSV reports whether a dashboard request asks for CSV, with
+// ?format=csv or Accept: text/csv
+func wantsCSV(r *http.Request) bool {
+	if r.URL.Query().Get("format") == "csv" {
+		return true
+	}
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		if mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(accept)); err == nil && mediaType == "text/csv" {
+			return true
+		}
+	}
+	return false
+}
+
+// csvColumn is a struct field exported as a CSV column
+type csvColumn struct {
+	name  string
+	index []int
+}
+
+// writeCSV streams v, a slice of structs or a single struct, as CSV with a
+// header row of the JSON field names. Times are RFC 3339, missing values
+// empty and nested values (e.g. percentiles) JSON encoded.
+func writeCSV(w http.ResponseWriter, r *http.Request, v interface{}) {
+	rows := reflect.ValueOf(v)
+	for rows.Kind() == reflect.Pointer && !rows.IsNil() {
+		rows = rows.Elem()
+	}
+	if rows.Kind() != reflect.Slice {
+		rows = reflect.Append(reflect.MakeSlice(reflect.SliceOf(rows.Type()), 0, 1), rows)
+	}
+	elem := rows.Type().Elem()
+	for elem.Kind() == reflect.Pointer {
+		elem = elem.Elem()
+	}
+	if elem.Kind() != reflect.Struct {
+		http.Error(w, "csv is not supported here", http.StatusNotAcceptable)
+		return
+	}
+	columns := csvColumns(elem)
+
+	name := strings.ReplaceAll(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/"), "/"), "/", "-")
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+`.csv"`)
+	w.Header().Set("Cache-Control", "no-store")
+
+	cw := csv.NewWriter(w)
+	header := make([]string, len(columns))
+	for i, c := range columns {
+		header[i] = c.name
+	}
+	cw.Write(header)
+
+	rc := http.NewResponseController(w)
+	record := make([]string, len(columns))
+	for i := 0; i < rows.Len(); i++ {
+		row := indirect(rows.Index(i))
+		for j, c := range columns {
+			record[j] = ""
+			if row.IsValid() {
+				record[j] = csvValue(row.FieldByIndex(c.index))
+			}
+		}
+		if err := cw.Write(record); err != nil {
+			slog.Debug("csv export aborted", "path", r.URL.Path, "error", err)
+			return
+		}
+		if (i+1)%csvFlushRows == 0 {
+			cw.Flush()
+			rc.Flush()
+		}
+	}
+	cw.Flush()
+}
+
+// csvColumns lists the JSON fields of a struct in declaration order
+func csvColumns(t reflect.Type) []csvColumn {
+	var columns []csvColumn
+	for _, f := range reflect.VisibleFields(t) {
+		if !f.IsExported() || (f.Anonymous && f.Type.Kind() == reflect.Struct) {
+			continue
+		}
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		columns = append(columns, csvColumn{name: name, index: f.Index})
+	}
+	return columns
+}
+
+// csvValue formats one field
+func csvValue(v reflect.Value) string {
+	v = indirect(v)
+	if !v.IsValid() {
+		return ""
+	}
+	if t, ok := v.Interface().(time.Time); ok {
+		return t.UTC().Format(time.RFC3339Nano)
+	}
+	switch v.Kind() {
+	case reflect.String:
+		return v.String()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(v.Uint(), 10)
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'f', -1, 64)
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool())
+	case reflect.Map, reflect.Slice:
+		if v.IsNil() {
+			return ""
+		}
+	}
+	if b, err := json.Marshal(v.Interface()); err == nil {
+		return string(b)
+	}
+	return fmt.Sprint(v.Interface())
+}
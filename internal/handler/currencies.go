@@ -0,0 +1,125 @@
+package handler
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// ============================================
+// CURRENCY METADATA
+// ============================================
+
+// currencyRange is how far back /api/meta/currencies looks for currencies
+// by default
+const currencyRange = 30 * 24 * time.Hour
+
+// currencyFormat tells widgets how to render amounts of a currency
+type currencyFormat struct {
+	Name     string
+	Symbol   string
+	Decimals int    // ISO 4217 minor units
+	Locale   string // BCP 47 locale whose conventions fit the currency best
+}
+
+// currencyFormats covers the currencies casinos usually accept. Decimals
+// follow ISO 4217: JPY and KRW have none, KWD and BHD have three.
+var currencyFormats = map[string]currencyFormat{
+	"ARS": {"Argentine Peso", "$", 2, "es-AR"},
+	"AUD": {"Australian Dollar", "A$", 2, "en-AU"},
+	"BHD": {"Bahraini Dinar", "BD", 3, "ar-BH"},
+	"BRL": {"Brazilian Real", "R$", 2, "pt-BR"},
+	"CAD": {"Canadian Dollar", "CA$", 2, "en-CA"},
+	"CHF": {"Swiss Franc", "CHF", 2, "de-CH"},
+	"CLP": {"Chilean Peso", "$", 0, "es-CL"},
+	"CNY": {"Chinese Yuan", "¥", 2, "zh-CN"},
+	"COP": {"Colombian Peso", "$", 2, "es-CO"},
+	"CZK": {"Czech Koruna", "Kč", 2, "cs-CZ"},
+	"DKK": {"Danish Krone", "kr.", 2, "da-DK"},
+	"EUR": {"Euro", "€", 2, "de-DE"},
+	"GBP": {"British Pound", "£", 2, "en-GB"},
+	"HUF": {"Hungarian Forint", "Ft", 2, "hu-HU"},
+	"IDR": {"Indonesian Rupiah", "Rp", 2, "id-ID"},
+	"INR": {"Indian Rupee", "₹", 2, "en-IN"},
+	"ISK": {"Icelandic Króna", "kr", 0, "is-IS"},
+	"JPY": {"Japanese Yen", "¥", 0, "ja-JP"},
+	"KES": {"Kenyan Shilling", "KSh", 2, "en-KE"},
+	"KRW": {"South Korean Won", "₩", 0, "ko-KR"},
+	"KWD": {"Kuwaiti Dinar", "KD", 3, "ar-KW"},
+	"KZT": {"Kazakhstani Tenge", "₸", 2, "kk-KZ"},
+	"MXN": {"Mexican Peso", "MX$", 2, "es-MX"},
+	"NGN": {"Nigerian Naira", "₦", 2, "en-NG"},
+	"NOK": {"Norwegian Krone", "kr", 2, "nb-NO"},
+	"NZD": {"New Zealand Dollar", "NZ$", 2, "en-NZ"},
+	"PEN": {"Peruvian Sol", "S/", 2, "es-PE"},
+	"PHP": {"Philippine Peso", "₱", 2, "en-PH"},
+	"PLN": {"Polish Zloty", "zł", 2, "pl-PL"},
+	"RON": {"Romanian Leu", "lei", 2, "ro-RO"},
+	"SEK": {"Swedish Krona", "kr", 2, "sv-SE"},
+	"THB": {"Thai Baht", "฿", 2, "th-TH"},
+	"TRY": {"Turkish Lira", "₺", 2, "tr-TR"},
+	"UAH": {"Ukrainian Hryvnia", "₴", 2, "uk-UA"},
+	"USD": {"US Dollar", "$", 2, "en-US"},
+	"VND": {"Vietnamese Dong", "₫", 0, "vi-VN"},
+	"ZAR": {"South African Rand", "R", 2, "en-ZA"},
+}
+
+// CurrencyInfo is a currency seen in PSP transactions with formatting
+// hints for widgets
+type CurrencyInfo struct {
+	Code         string    `json:"code"`
+	Name         string    `json:"name,omitempty"`
+	Symbol       string    `json:"symbol"`
+	Decimals     int       `json:"decimals"`
+	Locale       string    `json:"locale,omitempty"`
+	Source       string    `json:"source"` // iso4217, or observed when derived from amounts
+	Transactions int64     `json:"transactions"`
+	LastSeen     time.Time `json:"last_seen"`
+}
+
+// HandleCurrencies returns the currencies of PSP transactions with the
+// decimal places, symbol and locale to format their amounts. Currencies
+// missing from the built-in table get two decimals if any amount had a
+// fractional part and none otherwise, and their code as symbol.
+// GET /api/meta/currencies?start=2024-01-01T00:00:00Z
+func (h *DashboardHandler) HandleCurrencies(w http.ResponseWriter, r *http.Request) {
+	h.setCORS(w, r)
+	lq, ok := parseListQuery(w, r)
+	if !ok {
+		return
+	}
+
+	start := time.Now().Add(-currencyRange)
+	if r.URL.Query().Get("start") != "" {
+		start = h.parseStartTime(r)
+	}
+
+	usage, err := h.db.GetCurrencyUsage(r.Context(), start, siteScope(r))
+	if err != nil {
+		slog.Error("failed to get currency usage", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	currencies := make([]CurrencyInfo, 0, len(usage))
+	for _, u := range usage {
+		info := CurrencyInfo{
+			Code:         u.Currency,
+			Transactions: u.Transactions,
+			LastSeen:     u.LastSeen,
+		}
+		if f, ok := currencyFormats[u.Currency]; ok {
+			info.Name, info.Symbol, info.Decimals, info.Locale = f.Name, f.Symbol, f.Decimals, f.Locale
+			info.Source = "iso4217"
+		} else {
+			info.Symbol = u.Currency
+			if u.Fractional {
+				info.Decimals = 2
+			}
+			info.Source = "observed"
+		}
+		currencies = append(currencies, info)
+	}
+
+	writeList(w, r, currencies, lq)
+}
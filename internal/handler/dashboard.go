@@ -1,26 +1,40 @@
 package handler
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"fmt"
 	"log/slog"
 	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/mcbile/product-pulse/internal/storage"
 )
 
+// DegradedHeader names the continuous aggregate a response was answered
+// from because its query on the raw hypertables timed out
+const DegradedHeader = "X-Pulse-Degraded"
+
 // DashboardHandler handles dashboard API endpoints
 type DashboardHandler struct {
-	db             *storage.Postgres
-	allowedOrigins map[string]bool
-	allowAll       bool
+	db              *storage.Postgres
+	rawQueryTimeout time.Duration // Bounds raw queries that can fall back to aggregates, 0 for no bound
+	allowedOrigins  map[string]bool
+	allowAll        bool
 }
 
 // NewDashboardHandler creates a new dashboard handler
-func NewDashboardHandler(db *storage.Postgres, origins []string) *DashboardHandler {
+func NewDashboardHandler(db *storage.Postgres, rawQueryTimeout time.Duration, origins []string) *DashboardHandler {
 	h := &DashboardHandler{
-		db:             db,
-		allowedOrigins: make(map[string]bool),
+		db:              db,
+		rawQueryTimeout: rawQueryTimeout,
+		allowedOrigins:  make(map[string]bool),
 	}
 	for _, o := range origins {
 		if o == "*" {
@@ -39,6 +53,7 @@ func (h *DashboardHandler) setCORS(w http.ResponseWriter, r *http.Request) {
 	} else if h.allowedOrigins[origin] {
 		w.Header().Set("Access-Control-Allow-Origin", origin)
 	}
+	w.Header().Set("Access-Control-Expose-Headers", "ETag, Content-Disposition, "+TotalCountHeader+", "+DegradedHeader)
 	w.Header().Set("Content-Type", "application/json")
 }
 
@@ -53,6 +68,136 @@ func (h *DashboardHandler) parseStartTime(r *http.Request) time.Time {
 	return time.Now().Add(-time.Hour)
 }
 
+// timeSeries returns a series from start until now. With
+// ?compare=previous_period|previous_week each point also gets the baseline
+// value of the bucket one period (rounded up to whole buckets) or one week
+// earlier, so "vs last week" needs no second request. On errors it answers
+// and returns ok=false.
+func (h *DashboardHandler) timeSeries(w http.ResponseWriter, r *http.Request, bucket time.Duration,
+	get func(start, end time.Time) ([]storage.TimeSeriesPoint, error)) ([]storage.TimeSeriesPoint, bool) {
+	start := h.parseStartTime(r)
+	end := time.Now()
+
+	var offset time.Duration
+	switch compare := r.URL.Query().Get("compare"); compare {
+	case "":
+	case "previous_period":
+		offset = (end.Sub(start) + bucket - 1) / bucket * bucket
+	case "previous_week":
+		offset = 7 * 24 * time.Hour
+	default:
+		http.Error(w, "compare must be previous_period or previous_week", http.StatusBadRequest)
+		return nil, false
+	}
+
+	series, err := get(start, end)
+	if err != nil {
+		slog.Error("failed to get timeseries", "path", r.URL.Path, "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return nil, false
+	}
+	if offset <= 0 {
+		return series, true
+	}
+
+	baseline, err := get(start.Add(-offset), end.Add(-offset))
+	if err != nil {
+		slog.Error("failed to get baseline timeseries", "path", r.URL.Path, "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return nil, false
+	}
+	values := make(map[int64]float64, len(baseline))
+	for _, p := range baseline {
+		values[p.Time.Add(offset).Unix()] = p.Value
+	}
+	for i := range series {
+		if v, ok := values[series[i].Time.Unix()]; ok {
+			series[i].Baseline = &v
+		}
+	}
+	return series, true
+}
+
+// withRollupFallback runs raw, a query on the raw hypertables, bounded by
+// the raw query timeout. When it times out (vacuum, chunk maintenance or
+// plain load), rollup answers the request from the continuous aggregate
+// view instead, at the aggregate's coarser resolution and without the
+// breakdowns only raw rows have, and the response is marked with
+// DegradedHeader. The aggregates are not split by site, so users
+// restricted to sites get the error.
+func withRollupFallback[T any](h *DashboardHandler, w http.ResponseWriter, r *http.Request, view string,
+	raw func(ctx context.Context) (T, error), rollup func(ctx context.Context) (T, error)) (T, error) {
+	ctx := r.Context()
+	if h.rawQueryTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.rawQueryTimeout)
+		defer cancel()
+	}
+	result, err := raw(ctx)
+	if err == nil || !storage.IsTimeout(err) || r.Context().Err() != nil || siteScope(r) != nil {
+		return result, err
+	}
+
+	slog.Warn("raw query timed out, answering from rollup", "path", r.URL.Path, "rollup", view, "error", err)
+	result, err = rollup(r.Context())
+	if err == nil {
+		w.Header().Set(DegradedHeader, view)
+	}
+	return result, err
+}
+
+// writeQueryResult writes v as JSON with a weak ETag computed from the
+// encoded result. Polling widgets send it back in If-None-Match and get a
+// 304 without a body while the result is unchanged. Requests for CSV get
+// the rows streamed as CSV instead, without an ETag.
+func writeQueryResult(w http.ResponseWriter, r *http.Request, v interface{}) {
+	setQueryRows(r, v)
+	if wantsCSV(r) {
+		writeCSV(w, r, v)
+		return
+	}
+	if wantsNDJSON(r) {
+		writeNDJSON(w, r, v)
+		return
+	}
+
+	body, err := json.Marshal(v)
+	if err != nil {
+		slog.Error("failed to encode query result", "path", r.URL.Path, "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	body = append(body, '\n')
+
+	sum := sha256.Sum256(body)
+	etag := `W/"` + hex.EncodeToString(sum[:16]) + `"`
+	w.Header().Set("ETag", etag)
+	// Per-user and per-site data: browsers may cache it but must revalidate,
+	// shared caches must not store it
+	w.Header().Set("Cache-Control", "private, no-cache")
+
+	if etagMatch(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Write(body)
+}
+
+// etagMatch reports whether an If-None-Match header lists etag, using weak
+// comparison (RFC 9110 section 13.1.2)
+func etagMatch(header, etag string) bool {
+	if header == "" {
+		return false
+	}
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
 // HandleOverview returns aggregated overview metrics
 // GET /api/metrics/overview?start=2024-01-15T10:00:00Z
 func (h *DashboardHandler) HandleOverview(w http.ResponseWriter, r *http.Request) {
@@ -61,38 +206,99 @@ func (h *DashboardHandler) HandleOverview(w http.ResponseWriter, r *http.Request
 	start := h.parseStartTime(r)
 	ctx := r.Context()
 
-	metrics, err := h.db.GetOverviewMetrics(ctx, start)
+	metrics, err := h.db.GetOverviewMetrics(ctx, start, siteScope(r))
 	if err != nil {
 		slog.Error("failed to get overview metrics", "error", err)
 		http.Error(w, "internal error", http.StatusInternalServerError)
 		return
 	}
 
-	json.NewEncoder(w).Encode(metrics)
+	writeQueryResult(w, r, metrics)
 }
 
-// HandleAPIPerformance returns API performance metrics
-// GET /api/metrics/api?start=2024-01-15T10:00:00Z
+// maxPercentileRange bounds queries with ?percentiles=, which read raw
+// metrics instead of continuous aggregates
+const maxPercentileRange = 24 * time.Hour
+
+// maxPercentiles bounds the number of percentiles per query
+const maxPercentiles = 10
+
+// defaultPercentiles are computed where percentiles are always returned
+var defaultPercentiles = []float64{50, 95, 99}
+
+// parsePercentiles parses ?percentiles=50,95,99.9. It returns nil without
+// the parameter. Invalid values, and percentile queries starting before
+// maxPercentileRange, answer 400 and return ok=false.
+func parsePercentiles(w http.ResponseWriter, r *http.Request, start time.Time) (percentiles []float64, ok bool) {
+	param := r.URL.Query().Get("percentiles")
+	if param == "" {
+		return nil, true
+	}
+
+	for _, s := range strings.Split(param, ",") {
+		p, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+		if err != nil || p < 0 || p > 100 {
+			http.Error(w, "percentiles must be numbers between 0 and 100", http.StatusBadRequest)
+			return nil, false
+		}
+		percentiles = append(percentiles, p)
+	}
+	if len(percentiles) > maxPercentiles {
+		http.Error(w, "at most "+strconv.Itoa(maxPercentiles)+" percentiles", http.StatusBadRequest)
+		return nil, false
+	}
+	if time.Since(start) > maxPercentileRange {
+		http.Error(w, "percentiles are limited to the last "+maxPercentileRange.String(), http.StatusBadRequest)
+		return nil, false
+	}
+	return percentiles, true
+}
+
+// HandleAPIPerformance returns API performance metrics, with the requested
+// duration percentiles per row if percentiles is set
+// GET /api/metrics/api?start=2024-01-15T10:00:00Z&percentiles=50,95,99
 func (h *DashboardHandler) HandleAPIPerformance(w http.ResponseWriter, r *http.Request) {
 	h.setCORS(w, r)
+	lq, ok := parseListQuery(w, r)
+	if !ok {
+		return
+	}
 
 	start := h.parseStartTime(r)
+	percentiles, ok := parsePercentiles(w, r, start)
+	if !ok {
+		return
+	}
 	ctx := r.Context()
 
-	metrics, err := h.db.GetAPIPerformance(ctx, start)
+	var metrics []storage.APIPerformanceRow
+	var err error
+	if percentiles != nil {
+		metrics, err = withRollupFallback(h, w, r, "api_performance_1m", func(ctx context.Context) ([]storage.APIPerformanceRow, error) {
+			return h.db.GetAPIPercentiles(ctx, start, percentiles, siteScope(r))
+		}, func(ctx context.Context) ([]storage.APIPerformanceRow, error) {
+			return h.db.GetAPIPerformance(ctx, start, nil)
+		})
+	} else {
+		metrics, err = h.db.GetAPIPerformance(ctx, start, siteScope(r))
+	}
 	if err != nil {
 		slog.Error("failed to get API performance", "error", err)
 		http.Error(w, "internal error", http.StatusInternalServerError)
 		return
 	}
 
-	json.NewEncoder(w).Encode(metrics)
+	writeList(w, r, metrics, lq)
 }
 
 // HandleAPITimeSeries returns API latency time series for a service
-// GET /api/metrics/api/timeseries?service=auth&start=2024-01-15T10:00:00Z
+// GET /api/metrics/api/timeseries?service=auth&start=2024-01-15T10:00:00Z&compare=previous_week
 func (h *DashboardHandler) HandleAPITimeSeries(w http.ResponseWriter, r *http.Request) {
 	h.setCORS(w, r)
+	lq, ok := parseListQuery(w, r, "service")
+	if !ok {
+		return
+	}
 
 	service := r.URL.Query().Get("service")
 	if service == "" {
@@ -100,41 +306,95 @@ func (h *DashboardHandler) HandleAPITimeSeries(w http.ResponseWriter, r *http.Re
 		return
 	}
 
-	start := h.parseStartTime(r)
-	ctx := r.Context()
-
-	series, err := h.db.GetAPITimeSeries(ctx, service, start)
-	if err != nil {
-		slog.Error("failed to get API timeseries", "error", err)
-		http.Error(w, "internal error", http.StatusInternalServerError)
+	series, ok := h.timeSeries(w, r, time.Minute, func(start, end time.Time) ([]storage.TimeSeriesPoint, error) {
+		return h.db.GetAPITimeSeries(r.Context(), service, start, end, siteScope(r))
+	})
+	if !ok {
 		return
 	}
 
-	json.NewEncoder(w).Encode(series)
+	writeList(w, r, series, lq)
+}
+
+// maxCampaignRange bounds campaign breakdowns, which read raw metrics
+// instead of continuous aggregates
+const maxCampaignRange = 7 * 24 * time.Hour
+
+// parseCampaignBreakdown reports whether by=campaign was requested. Other
+// values of by, and campaign breakdowns starting before maxCampaignRange,
+// answer 400 and return ok=false.
+func parseCampaignBreakdown(w http.ResponseWriter, r *http.Request, start time.Time) (byCampaign, ok bool) {
+	switch r.URL.Query().Get("by") {
+	case "":
+		return false, true
+	case "campaign":
+		if time.Since(start) > maxCampaignRange {
+			http.Error(w, "campaign breakdown is limited to the last "+maxCampaignRange.String(), http.StatusBadRequest)
+			return false, false
+		}
+		return true, true
+	default:
+		http.Error(w, "by must be campaign", http.StatusBadRequest)
+		return false, false
+	}
 }
 
-// HandlePSPHealth returns PSP health metrics
-// GET /api/metrics/psp?start=2024-01-15T10:00:00Z
+// HandlePSPHealth returns PSP health metrics, per campaign with by=campaign
+// or with the requested duration percentiles per row if percentiles is set
+// GET /api/metrics/psp?start=2024-01-15T10:00:00Z&by=campaign
 func (h *DashboardHandler) HandlePSPHealth(w http.ResponseWriter, r *http.Request) {
 	h.setCORS(w, r)
+	lq, ok := parseListQuery(w, r)
+	if !ok {
+		return
+	}
 
 	start := h.parseStartTime(r)
+	byCampaign, ok := parseCampaignBreakdown(w, r, start)
+	if !ok {
+		return
+	}
+	percentiles, ok := parsePercentiles(w, r, start)
+	if !ok {
+		return
+	}
+	if byCampaign && percentiles != nil {
+		http.Error(w, "percentiles cannot be combined with by=campaign", http.StatusBadRequest)
+		return
+	}
 	ctx := r.Context()
 
-	metrics, err := h.db.GetPSPHealth(ctx, start)
+	var metrics []storage.PSPHealthRow
+	var err error
+	if percentiles != nil || byCampaign {
+		metrics, err = withRollupFallback(h, w, r, "psp_success_5m", func(ctx context.Context) ([]storage.PSPHealthRow, error) {
+			if byCampaign {
+				return h.db.GetPSPHealthByCampaign(ctx, start, siteScope(r))
+			}
+			return h.db.GetPSPPercentiles(ctx, start, percentiles, siteScope(r))
+		}, func(ctx context.Context) ([]storage.PSPHealthRow, error) {
+			return h.db.GetPSPHealth(ctx, start, nil)
+		})
+	} else {
+		metrics, err = h.db.GetPSPHealth(ctx, start, siteScope(r))
+	}
 	if err != nil {
 		slog.Error("failed to get PSP health", "error", err)
 		http.Error(w, "internal error", http.StatusInternalServerError)
 		return
 	}
 
-	json.NewEncoder(w).Encode(metrics)
+	writeList(w, r, metrics, lq)
 }
 
 // HandlePSPTimeSeries returns PSP success rate time series
-// GET /api/metrics/psp/timeseries?psp=PIX&start=2024-01-15T10:00:00Z
+// GET /api/metrics/psp/timeseries?psp=PIX&start=2024-01-15T10:00:00Z&compare=previous_week
 func (h *DashboardHandler) HandlePSPTimeSeries(w http.ResponseWriter, r *http.Request) {
 	h.setCORS(w, r)
+	lq, ok := parseListQuery(w, r)
+	if !ok {
+		return
+	}
 
 	psp := r.URL.Query().Get("psp")
 	if psp == "" {
@@ -142,82 +402,203 @@ func (h *DashboardHandler) HandlePSPTimeSeries(w http.ResponseWriter, r *http.Re
 		return
 	}
 
+	series, ok := h.timeSeries(w, r, 5*time.Minute, func(start, end time.Time) ([]storage.TimeSeriesPoint, error) {
+		return h.db.GetPSPTimeSeries(r.Context(), psp, start, end, siteScope(r))
+	})
+	if !ok {
+		return
+	}
+
+	writeList(w, r, series, lq)
+}
+
+// HandleWithdrawals returns the withdrawal funnel and time between states
+// per PSP (and campaign with by=campaign), for withdrawals requested since
+// start
+// GET /api/metrics/withdrawals?psp=PIX&start=2024-01-15T00:00:00Z&by=campaign
+func (h *DashboardHandler) HandleWithdrawals(w http.ResponseWriter, r *http.Request) {
+	h.setCORS(w, r)
+	lq, ok := parseListQuery(w, r)
+	if !ok {
+		return
+	}
+
+	start := h.parseStartTime(r)
+	byCampaign, ok := parseCampaignBreakdown(w, r, start)
+	if !ok {
+		return
+	}
+	psp := r.URL.Query().Get("psp")
+	ctx := r.Context()
+
+	flow, err := h.db.GetWithdrawalFlow(ctx, start, psp, byCampaign, siteScope(r))
+	if err != nil {
+		slog.Error("failed to get withdrawal flow", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	writeList(w, r, flow, lq)
+}
+
+// HandlePendingWithdrawals returns open withdrawals older than older_than
+// (default 1h), to spot payouts breaching their SLA
+// GET /api/metrics/withdrawals/pending?psp=PIX&older_than=24h&start=2024-01-15T00:00:00Z
+func (h *DashboardHandler) HandlePendingWithdrawals(w http.ResponseWriter, r *http.Request) {
+	h.setCORS(w, r)
+	lq, ok := parseListQuery(w, r)
+	if !ok {
+		return
+	}
+
+	olderThan := time.Hour
+	if s := r.URL.Query().Get("older_than"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil || d < 0 {
+			http.Error(w, "invalid older_than", http.StatusBadRequest)
+			return
+		}
+		olderThan = d
+	}
+
+	// Without start, look back far enough to include slow settlements
+	start := time.Now().Add(-7 * 24 * time.Hour)
+	if r.URL.Query().Get("start") != "" {
+		start = h.parseStartTime(r)
+	}
+	psp := r.URL.Query().Get("psp")
+	ctx := r.Context()
+
+	pending, err := h.db.GetPendingWithdrawals(ctx, start, psp, olderThan, siteScope(r))
+	if err != nil {
+		slog.Error("failed to get pending withdrawals", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	writeList(w, r, pending, lq)
+}
+
+// HandleCampaigns returns frontend and payment activity per campaign in 5
+// minute buckets, to correlate promotion launches with load and cashier
+// health
+// GET /api/metrics/campaigns?campaign=welcome-bonus&start=2024-01-15T00:00:00Z
+func (h *DashboardHandler) HandleCampaigns(w http.ResponseWriter, r *http.Request) {
+	h.setCORS(w, r)
+	lq, ok := parseListQuery(w, r)
+	if !ok {
+		return
+	}
+
 	start := h.parseStartTime(r)
+	if time.Since(start) > maxCampaignRange {
+		http.Error(w, "start is limited to the last "+maxCampaignRange.String(), http.StatusBadRequest)
+		return
+	}
+	campaign := r.URL.Query().Get("campaign")
 	ctx := r.Context()
 
-	series, err := h.db.GetPSPTimeSeries(ctx, psp, start)
+	activity, err := h.db.GetCampaignActivity(ctx, start, campaign, siteScope(r))
 	if err != nil {
-		slog.Error("failed to get PSP timeseries", "error", err)
+		slog.Error("failed to get campaign activity", "error", err)
 		http.Error(w, "internal error", http.StatusInternalServerError)
 		return
 	}
 
-	json.NewEncoder(w).Encode(series)
+	writeList(w, r, activity, lq)
 }
 
 // HandleWebVitals returns Web Vitals metrics
 // GET /api/metrics/vitals?start=2024-01-15T10:00:00Z
 func (h *DashboardHandler) HandleWebVitals(w http.ResponseWriter, r *http.Request) {
 	h.setCORS(w, r)
+	lq, ok := parseListQuery(w, r)
+	if !ok {
+		return
+	}
 
 	start := h.parseStartTime(r)
 	ctx := r.Context()
 
-	metrics, err := h.db.GetWebVitals(ctx, start)
+	metrics, err := h.db.GetWebVitals(ctx, start, siteScope(r))
 	if err != nil {
 		slog.Error("failed to get Web Vitals", "error", err)
 		http.Error(w, "internal error", http.StatusInternalServerError)
 		return
 	}
 
-	json.NewEncoder(w).Encode(metrics)
+	writeList(w, r, metrics, lq)
 }
 
 // HandleWebVitalsTimeSeries returns Web Vitals time series for a metric
-// GET /api/metrics/vitals/timeseries?metric=lcp&start=2024-01-15T10:00:00Z
+// GET /api/metrics/vitals/timeseries?metric=lcp&start=2024-01-15T10:00:00Z&compare=previous_week
 func (h *DashboardHandler) HandleWebVitalsTimeSeries(w http.ResponseWriter, r *http.Request) {
 	h.setCORS(w, r)
+	lq, ok := parseListQuery(w, r)
+	if !ok {
+		return
+	}
 
 	metric := r.URL.Query().Get("metric")
 	if metric == "" {
 		metric = "lcp"
 	}
 
-	start := h.parseStartTime(r)
-	ctx := r.Context()
-
-	series, err := h.db.GetWebVitalsTimeSeries(ctx, metric, start)
-	if err != nil {
-		slog.Error("failed to get Vitals timeseries", "error", err)
-		http.Error(w, "internal error", http.StatusInternalServerError)
+	series, ok := h.timeSeries(w, r, time.Hour, func(start, end time.Time) ([]storage.TimeSeriesPoint, error) {
+		return h.db.GetWebVitalsTimeSeries(r.Context(), metric, start, end, siteScope(r))
+	})
+	if !ok {
 		return
 	}
 
-	json.NewEncoder(w).Encode(series)
+	writeList(w, r, series, lq)
 }
 
-// HandleGameHealth returns game provider health metrics
-// GET /api/metrics/games?start=2024-01-15T10:00:00Z
+// HandleGameHealth returns game provider health metrics, with the
+// requested load time percentiles per row if percentiles is set
+// GET /api/metrics/games?start=2024-01-15T10:00:00Z&percentiles=50,95,99
 func (h *DashboardHandler) HandleGameHealth(w http.ResponseWriter, r *http.Request) {
 	h.setCORS(w, r)
+	lq, ok := parseListQuery(w, r)
+	if !ok {
+		return
+	}
 
 	start := h.parseStartTime(r)
+	percentiles, ok := parsePercentiles(w, r, start)
+	if !ok {
+		return
+	}
 	ctx := r.Context()
 
-	metrics, err := h.db.GetGameHealth(ctx, start)
+	var metrics []storage.GameHealthRow
+	var err error
+	if percentiles != nil {
+		metrics, err = withRollupFallback(h, w, r, "game_health_5m", func(ctx context.Context) ([]storage.GameHealthRow, error) {
+			return h.db.GetGamePercentiles(ctx, start, percentiles, siteScope(r))
+		}, func(ctx context.Context) ([]storage.GameHealthRow, error) {
+			return h.db.GetGameHealth(ctx, start, nil)
+		})
+	} else {
+		metrics, err = h.db.GetGameHealth(ctx, start, siteScope(r))
+	}
 	if err != nil {
 		slog.Error("failed to get game health", "error", err)
 		http.Error(w, "internal error", http.StatusInternalServerError)
 		return
 	}
 
-	json.NewEncoder(w).Encode(metrics)
+	writeList(w, r, metrics, lq)
 }
 
 // HandleGameTimeSeries returns game provider success rate time series
-// GET /api/metrics/games/timeseries?provider=Pragmatic&start=2024-01-15T10:00:00Z
+// GET /api/metrics/games/timeseries?provider=Pragmatic&start=2024-01-15T10:00:00Z&compare=previous_week
 func (h *DashboardHandler) HandleGameTimeSeries(w http.ResponseWriter, r *http.Request) {
 	h.setCORS(w, r)
+	lq, ok := parseListQuery(w, r, "provider")
+	if !ok {
+		return
+	}
 
 	provider := r.URL.Query().Get("provider")
 	if provider == "" {
@@ -225,46 +606,572 @@ func (h *DashboardHandler) HandleGameTimeSeries(w http.ResponseWriter, r *http.R
 		return
 	}
 
+	series, ok := h.timeSeries(w, r, 5*time.Minute, func(start, end time.Time) ([]storage.TimeSeriesPoint, error) {
+		return h.db.GetGameTimeSeries(r.Context(), provider, start, end, siteScope(r))
+	})
+	if !ok {
+		return
+	}
+
+	writeList(w, r, series, lq)
+}
+
+// HandleWebSocketLatency returns WebSocket event counts and latency
+// percentiles (default p50, p95, p99) per endpoint and device type
+// GET /api/metrics/ws?start=2024-01-15T10:00:00Z&percentiles=50,99
+func (h *DashboardHandler) HandleWebSocketLatency(w http.ResponseWriter, r *http.Request) {
+	h.setCORS(w, r)
+	lq, ok := parseListQuery(w, r)
+	if !ok {
+		return
+	}
+
+	start := h.parseStartTime(r)
+	if time.Since(start) > maxPercentileRange {
+		http.Error(w, "start is limited to the last "+maxPercentileRange.String(), http.StatusBadRequest)
+		return
+	}
+	percentiles, ok := parsePercentiles(w, r, start)
+	if !ok {
+		return
+	}
+	if percentiles == nil {
+		percentiles = defaultPercentiles
+	}
+	ctx := r.Context()
+
+	metrics, err := h.db.GetWebSocketLatency(ctx, start, percentiles, siteScope(r))
+	if err != nil {
+		slog.Error("failed to get WebSocket latency", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	writeList(w, r, metrics, lq)
+}
+
+// HandleCSPViolations returns CSP reports aggregated per directive and blocked URI
+// GET /api/metrics/csp?directive=script-src-elem&start=2024-01-15T10:00:00Z
+func (h *DashboardHandler) HandleCSPViolations(w http.ResponseWriter, r *http.Request) {
+	h.setCORS(w, r)
+	if !requireAllSites(w, r) {
+		return
+	}
+	lq, ok := parseListQuery(w, r)
+	if !ok {
+		return
+	}
+
+	directive := r.URL.Query().Get("directive")
 	start := h.parseStartTime(r)
 	ctx := r.Context()
 
-	series, err := h.db.GetGameTimeSeries(ctx, provider, start)
+	violations, err := h.db.GetCSPViolations(ctx, start, directive)
 	if err != nil {
-		slog.Error("failed to get game timeseries", "error", err)
+		slog.Error("failed to get CSP violations", "error", err)
 		http.Error(w, "internal error", http.StatusInternalServerError)
 		return
 	}
 
-	json.NewEncoder(w).Encode(series)
+	writeList(w, r, violations, lq)
+}
+
+// maxErrorRange bounds the error explorer, which reads raw metrics
+const maxErrorRange = 7 * 24 * time.Hour
+
+// errorSources are the values of ?source= on /api/errors
+var errorSources = []string{"api", "psp", "game"}
+
+// maxErrorSamples bounds ?limit= of the error drill-down
+const maxErrorSamples = 100
+
+// parseErrorRange parses start for the error explorer; ranges beyond
+// maxErrorRange answer 400 and return ok=false
+func (h *DashboardHandler) parseErrorRange(w http.ResponseWriter, r *http.Request) (time.Time, bool) {
+	start := h.parseStartTime(r)
+	if time.Since(start) > maxErrorRange {
+		http.Error(w, "start is limited to the last "+maxErrorRange.String(), http.StatusBadRequest)
+		return time.Time{}, false
+	}
+	return start, true
 }
 
-// HandleAlerts returns alert events
-// GET /api/alerts?resolved=false
+// HandleErrors groups failed API requests, payments and game launches by
+// fingerprint (source, component, error type and message with IDs and
+// numbers masked), most frequent first, with first/last seen and the
+// number of affected players
+// GET /api/errors?source=psp&component=Trustly&start=2024-01-15T10:00:00Z
+func (h *DashboardHandler) HandleErrors(w http.ResponseWriter, r *http.Request) {
+	h.setCORS(w, r)
+	lq, ok := parseListQuery(w, r)
+	if !ok {
+		return
+	}
+
+	start, ok := h.parseErrorRange(w, r)
+	if !ok {
+		return
+	}
+	source := r.URL.Query().Get("source")
+	if source != "" && !slices.Contains(errorSources, source) {
+		http.Error(w, "source must be one of "+strings.Join(errorSources, ", "), http.StatusBadRequest)
+		return
+	}
+	component := r.URL.Query().Get("component")
+	ctx := r.Context()
+
+	groups, err := h.db.GetErrorGroups(ctx, start, source, component, siteScope(r))
+	if err != nil {
+		slog.Error("failed to get error groups", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	writeList(w, r, groups, lq)
+}
+
+// HandleErrorSamples returns the latest occurrences of one error
+// fingerprint (default 20)
+// GET /api/errors/{fingerprint}/samples?limit=50&start=2024-01-15T10:00:00Z
+func (h *DashboardHandler) HandleErrorSamples(w http.ResponseWriter, r *http.Request) {
+	h.setCORS(w, r)
+
+	fingerprint := r.PathValue("fingerprint")
+	if fingerprint == "" {
+		http.Error(w, "fingerprint required", http.StatusBadRequest)
+		return
+	}
+	start, ok := h.parseErrorRange(w, r)
+	if !ok {
+		return
+	}
+	limit := 20
+	if s := r.URL.Query().Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > maxErrorSamples {
+			http.Error(w, fmt.Sprintf("limit must be between 1 and %d", maxErrorSamples), http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	ctx := r.Context()
+
+	samples, err := h.db.GetErrorSamples(ctx, start, fingerprint, limit, siteScope(r))
+	if err != nil {
+		slog.Error("failed to get error samples", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if samples == nil {
+		samples = []storage.ErrorSampleRow{}
+	}
+
+	writeQueryResult(w, r, samples)
+}
+
+// maxTimelineRange and maxTimelineEntries bound player timelines
+const (
+	maxTimelineRange   = 31 * 24 * time.Hour
+	maxTimelineEntries = 5000
+)
+
+// playerIDPattern matches the UUIDs player IDs are stored as
+var playerIDPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// HandlePlayerTimeline returns everything recorded for one player, from
+// page loads and API calls to payments, game launches and WebSocket
+// events, oldest first, for support to investigate complaints about
+// deposits or game loading. from defaults to 24 hours before to, to to
+// now.
+// GET /api/players/{player_id}/timeline?from=2024-01-15T00:00:00Z&to=2024-01-16T00:00:00Z
+func (h *DashboardHandler) HandlePlayerTimeline(w http.ResponseWriter, r *http.Request) {
+	h.setCORS(w, r)
+
+	playerID := r.PathValue("player_id")
+	if !playerIDPattern.MatchString(playerID) {
+		http.Error(w, "player_id must be a UUID", http.StatusBadRequest)
+		return
+	}
+
+	to := time.Now().UTC()
+	if s := r.URL.Query().Get("to"); s != "" {
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			http.Error(w, "invalid to", http.StatusBadRequest)
+			return
+		}
+		to = t
+	}
+	from := to.Add(-24 * time.Hour)
+	if s := r.URL.Query().Get("from"); s != "" {
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			http.Error(w, "invalid from", http.StatusBadRequest)
+			return
+		}
+		from = t
+	}
+	if !from.Before(to) || to.Sub(from) > maxTimelineRange {
+		http.Error(w, "from must be before to and at most "+maxTimelineRange.String()+" earlier", http.StatusBadRequest)
+		return
+	}
+	ctx := r.Context()
+
+	// One more than the limit tells whether the timeline was cut
+	entries, err := h.db.GetPlayerTimeline(ctx, playerID, from, to, maxTimelineEntries+1, siteScope(r))
+	if err != nil {
+		slog.Error("failed to get player timeline", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	truncated := len(entries) > maxTimelineEntries
+	if truncated {
+		entries = entries[:maxTimelineEntries]
+	}
+	if entries == nil {
+		entries = []storage.TimelineEntry{}
+	}
+	if wantsCSV(r) {
+		writeCSV(w, r, entries)
+		return
+	}
+	if wantsNDJSON(r) {
+		writeNDJSON(w, r, entries)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"player_id": playerID,
+		"from":      from,
+		"to":        to,
+		"entries":   entries,
+		"truncated": truncated,
+	})
+}
+
+// maxExportRange bounds raw event exports
+const maxExportRange = 7 * 24 * time.Hour
+
+// parseExportRange reads the source and the [start, end) range of an
+// export; start defaults to an hour before end, end to now. On errors it
+// answers and returns ok=false.
+func parseExportRange(w http.ResponseWriter, r *http.Request) (source string, start, end time.Time, ok bool) {
+	source = r.PathValue("source")
+	if _, ok := storage.ExportTables[source]; !ok {
+		sources := make([]string, 0, len(storage.ExportTables))
+		for s := range storage.ExportTables {
+			sources = append(sources, s)
+		}
+		slices.Sort(sources)
+		http.Error(w, "source must be one of "+strings.Join(sources, ", "), http.StatusBadRequest)
+		return "", time.Time{}, time.Time{}, false
+	}
+
+	end = time.Now().UTC()
+	if s := r.URL.Query().Get("end"); s != "" {
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			http.Error(w, "invalid end", http.StatusBadRequest)
+			return "", time.Time{}, time.Time{}, false
+		}
+		end = t
+	}
+	start = end.Add(-time.Hour)
+	if s := r.URL.Query().Get("start"); s != "" {
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			http.Error(w, "invalid start", http.StatusBadRequest)
+			return "", time.Time{}, time.Time{}, false
+		}
+		start = t
+	}
+	if !start.Before(end) || end.Sub(start) > maxExportRange {
+		http.Error(w, "start must be before end and at most "+maxExportRange.String()+" earlier", http.StatusBadRequest)
+		return "", time.Time{}, time.Time{}, false
+	}
+	return source, start, end, true
+}
+
+// HandleExport streams the raw events of a metric table in [start, end),
+// oldest first, as NDJSON with all columns, gzipped for clients that accept
+// it. start defaults to an hour before end, end to now. Rows go out as the
+// database returns them, so exports of any size use constant memory.
+// GET /api/export/{source}?start=2024-01-15T00:00:00Z&end=2024-01-16T00:00:00Z
+func (h *DashboardHandler) HandleExport(w http.ResponseWriter, r *http.Request) {
+	h.setCORS(w, r)
+	source, start, end, ok := parseExportRange(w, r)
+	if !ok {
+		return
+	}
+
+	var stream *ndjsonStream
+	err := h.db.StreamEvents(r.Context(), source, start, end, siteScope(r), func(event json.RawMessage) error {
+		if stream == nil {
+			stream = newNDJSONStream(w, r)
+		}
+		return stream.Write(event)
+	})
+	switch {
+	case err != nil && stream == nil:
+		slog.Error("failed to export events", "source", source, "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+	case err != nil:
+		// The status is sent; the client sees the stream end early
+		slog.Warn("event export aborted", "source", source, "rows", stream.rows, "error", err)
+	case stream == nil:
+		newNDJSONStream(w, r).Close()
+	default:
+		stream.Close()
+	}
+}
+
+// HandleStability returns crash-free sessions and users per release and
+// platform. device_type and country filter the events counted, since rows
+// are not split by them.
+// GET /api/metrics/stability?release=2.4.0&country=BR&start=2024-01-15T10:00:00Z
+func (h *DashboardHandler) HandleStability(w http.ResponseWriter, r *http.Request) {
+	h.setCORS(w, r)
+	lq, ok := parseListQuery(w, r, "device_type", "country")
+	if !ok {
+		return
+	}
+
+	release := r.URL.Query().Get("release")
+	start := h.parseStartTime(r)
+	ctx := r.Context()
+
+	query := r.URL.Query()
+	stability, err := h.db.GetStability(ctx, start, release, query.Get("device_type"), query.Get("country"), siteScope(r))
+	if err != nil {
+		slog.Error("failed to get stability", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	writeList(w, r, stability, lq)
+}
+
+// defaultAlertHistory is the range of alert statistics without start
+const defaultAlertHistory = 30 * 24 * time.Hour
+
+// parseAlertFilter reads an alert filter from the query: alert_type,
+// severity, source_table, metric_name, target, site_id, and start and end
+// in RFC 3339. On a bad value it answers 400 and returns ok=false.
+func parseAlertFilter(w http.ResponseWriter, r *http.Request) (storage.AlertFilter, bool) {
+	query := r.URL.Query()
+	f := storage.AlertFilter{
+		AlertType:   query.Get("alert_type"),
+		Severity:    query.Get("severity"),
+		SourceTable: query.Get("source_table"),
+		MetricName:  query.Get("metric_name"),
+		Target:      query.Get("target"),
+		SiteID:      query.Get("site_id"),
+	}
+	if s := query.Get("start"); s != "" {
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			http.Error(w, "invalid start", http.StatusBadRequest)
+			return f, false
+		}
+		f.Start = &t
+	}
+	if s := query.Get("end"); s != "" {
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			http.Error(w, "invalid end", http.StatusBadRequest)
+			return f, false
+		}
+		f.End = &t
+	}
+	return f, true
+}
+
+// HandleAlerts returns the latest alert events matching the alert filter,
+// newest first. state is open, unacknowledged, acknowledged or resolved;
+// resolved=true and resolved=false are kept for resolved and open.
+// GET /api/alerts?state=open&severity=critical&target=trustly&start=2024-01-01T00:00:00Z&limit=100
 func (h *DashboardHandler) HandleAlerts(w http.ResponseWriter, r *http.Request) {
 	h.setCORS(w, r)
+	if !requireAllSites(w, r) {
+		return
+	}
 
-	var resolved *bool
-	if resolvedStr := r.URL.Query().Get("resolved"); resolvedStr != "" {
-		b := resolvedStr == "true"
-		resolved = &b
+	f, ok := parseAlertFilter(w, r)
+	if !ok {
+		return
+	}
+	query := r.URL.Query()
+	state := query.Get("state")
+	switch query.Get("resolved") {
+	case "true":
+		state = storage.AlertStateResolved
+	case "false":
+		state = storage.AlertStateOpen
+	}
+	if !storage.ValidAlertState(state) {
+		http.Error(w, "state must be open, unacknowledged, acknowledged or resolved", http.StatusBadRequest)
+		return
+	}
+	limit := 100
+	if s := query.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > maxListLimit {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = n
 	}
 
 	ctx := r.Context()
 
-	alerts, err := h.db.GetAlerts(ctx, resolved)
+	alerts, err := h.db.GetAlerts(ctx, f, state, limit)
 	if err != nil {
 		slog.Error("failed to get alerts", "error", err)
 		http.Error(w, "internal error", http.StatusInternalServerError)
 		return
 	}
 
-	json.NewEncoder(w).Encode(alerts)
+	writeQueryResult(w, r, alerts)
+}
+
+// HandleAlertDailyStats counts the alerts matching the alert filter per day
+// (UTC) by severity, with how many were acknowledged and resolved. start
+// defaults to 30 days ago.
+// GET /api/alerts/stats/daily?alert_type=anomaly&start=2024-01-01T00:00:00Z
+func (h *DashboardHandler) HandleAlertDailyStats(w http.ResponseWriter, r *http.Request) {
+	h.setCORS(w, r)
+	if !requireAllSites(w, r) {
+		return
+	}
+
+	f, ok := parseAlertFilter(w, r)
+	if !ok {
+		return
+	}
+	if f.Start == nil {
+		start := time.Now().Add(-defaultAlertHistory)
+		f.Start = &start
+	}
+
+	days, err := h.db.GetAlertDailyStats(r.Context(), f)
+	if err != nil {
+		slog.Error("failed to get alert daily stats", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	writeQueryResult(w, r, days)
+}
+
+// HandleAlertResponseStats returns the mean time to acknowledge (MTTA) and
+// to resolve (MTTR) of the alerts matching the alert filter, overall or per
+// alert_type, severity, source_table, metric_name, target or site_id (by).
+// start defaults to 30 days ago.
+// GET /api/alerts/stats/response?by=target&severity=critical
+func (h *DashboardHandler) HandleAlertResponseStats(w http.ResponseWriter, r *http.Request) {
+	h.setCORS(w, r)
+	if !requireAllSites(w, r) {
+		return
+	}
+
+	f, ok := parseAlertFilter(w, r)
+	if !ok {
+		return
+	}
+	if f.Start == nil {
+		start := time.Now().Add(-defaultAlertHistory)
+		f.Start = &start
+	}
+	by := r.URL.Query().Get("by")
+	if _, ok := storage.AlertResponseGroups[by]; by != "" && !ok {
+		http.Error(w, "by must be alert_type, severity, source_table, metric_name, target or site_id", http.StatusBadRequest)
+		return
+	}
+
+	stats, err := h.db.GetAlertResponseStats(r.Context(), f, by)
+	if err != nil {
+		slog.Error("failed to get alert response stats", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	writeQueryResult(w, r, stats)
+}
+
+// Alert grouping defaults and bounds
+const (
+	defaultAlertGroupWindow = 10 * time.Minute
+	maxAlertGroupWindow     = 24 * time.Hour
+	maxAlertGroupSamples    = 20
+)
+
+// HandleAlertGroups returns alerts grouped into incidents: alerts of the
+// same type, source table and site raised within window (default 10m) of
+// each other make one group with counts, targets and the latest samples
+// (default 3). start defaults to 24 hours ago.
+// GET /api/alerts/groups?resolved=false&window=15m&samples=5
+func (h *DashboardHandler) HandleAlertGroups(w http.ResponseWriter, r *http.Request) {
+	h.setCORS(w, r)
+	if !requireAllSites(w, r) {
+		return
+	}
+
+	query := r.URL.Query()
+	var resolved *bool
+	if s := query.Get("resolved"); s != "" {
+		b := s == "true"
+		resolved = &b
+	}
+	start := time.Now().Add(-24 * time.Hour)
+	if s := query.Get("start"); s != "" {
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			http.Error(w, "invalid start", http.StatusBadRequest)
+			return
+		}
+		start = t
+	}
+	window := defaultAlertGroupWindow
+	if s := query.Get("window"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil || d < time.Minute || d > maxAlertGroupWindow {
+			http.Error(w, "window must be between 1m and "+maxAlertGroupWindow.String(), http.StatusBadRequest)
+			return
+		}
+		window = d
+	}
+	samples := 3
+	if s := query.Get("samples"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > maxAlertGroupSamples {
+			http.Error(w, fmt.Sprintf("samples must be between 1 and %d", maxAlertGroupSamples), http.StatusBadRequest)
+			return
+		}
+		samples = n
+	}
+	ctx := r.Context()
+
+	groups, err := h.db.GetAlertGroups(ctx, start, window, samples, resolved)
+	if err != nil {
+		slog.Error("failed to get alert groups", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if groups == nil {
+		groups = []storage.AlertGroup{}
+	}
+
+	writeQueryResult(w, r, groups)
 }
 
 // HandleAcknowledgeAlert marks an alert as acknowledged
 // POST /api/alerts/{time}/acknowledge
 func (h *DashboardHandler) HandleAcknowledgeAlert(w http.ResponseWriter, r *http.Request) {
 	h.setCORS(w, r)
+	if !requireAllSites(w, r) {
+		return
+	}
 
 	// Parse alert time from path
 	// Path pattern: /api/alerts/{alertTime}/acknowledge
@@ -292,6 +1199,89 @@ func (h *DashboardHandler) HandleAcknowledgeAlert(w http.ResponseWriter, r *http
 	w.Write([]byte(`{"status":"ok"}`))
 }
 
+// AuditAlertsBulkUpdated is the audit log event of a bulk alert change
+const AuditAlertsBulkUpdated = "alerts_bulk_updated"
+
+// bulkAlertRequest acknowledges or resolves every alert matching a filter;
+// older_than narrows it to alerts raised longer ago, e.g. "1h". Matching
+// every alert takes an explicit all.
+type bulkAlertRequest struct {
+	Action    string              `json:"action"` // acknowledge or resolve
+	Filter    storage.AlertFilter `json:"filter"`
+	OlderThan string              `json:"older_than"`
+	All       bool                `json:"all"`
+	DryRun    bool                `json:"dry_run"` // Only count the alerts
+}
+
+// HandleBulkAlerts acknowledges or resolves all alerts matching a filter,
+// e.g. every alert of a PSP older than an hour during an alert storm. With
+// dry_run it only returns how many alerts would change. Changes are written
+// to the audit log.
+// POST /api/alerts/bulk
+func (h *DashboardHandler) HandleBulkAlerts(w http.ResponseWriter, r *http.Request) {
+	h.setCORS(w, r)
+	if !requireAllSites(w, r) {
+		return
+	}
+
+	var req bulkAlertRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if !storage.ValidAlertAction(req.Action) {
+		http.Error(w, "action must be acknowledge or resolve", http.StatusBadRequest)
+		return
+	}
+	if req.Filter == (storage.AlertFilter{}) && req.OlderThan == "" && !req.All {
+		http.Error(w, "filter or older_than required, or all: true to change every alert", http.StatusBadRequest)
+		return
+	}
+	if req.OlderThan != "" {
+		d, err := time.ParseDuration(req.OlderThan)
+		if err != nil || d <= 0 {
+			http.Error(w, "invalid older_than", http.StatusBadRequest)
+			return
+		}
+		if cutoff := time.Now().Add(-d); req.Filter.End == nil || cutoff.Before(*req.Filter.End) {
+			req.Filter.End = &cutoff
+		}
+	}
+
+	ctx := r.Context()
+	var count int64
+	var err error
+	if req.DryRun {
+		count, err = h.db.CountBulkAlerts(ctx, req.Action, req.Filter)
+	} else {
+		count, err = h.db.BulkUpdateAlerts(ctx, req.Action, req.Filter)
+	}
+	if err != nil {
+		slog.Error("failed to update alerts in bulk", "action", req.Action, "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if !req.DryRun {
+		user, _ := UserFromContext(ctx)
+		slog.Info("alerts updated in bulk", "action", req.Action, "count", count,
+			"user", user.Email, "filter", req.Filter)
+		filter, _ := json.Marshal(req.Filter)
+		auditChange(r, h.db, AuditAlertsBulkUpdated, map[string]string{
+			"action": req.Action,
+			"filter": string(filter),
+			"all":    strconv.FormatBool(req.All),
+			"count":  strconv.FormatInt(count, 10),
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"action":  req.Action,
+		"dry_run": req.DryRun,
+		"count":   count,
+	})
+}
+
 // HandleCORS handles OPTIONS preflight requests for dashboard endpoints
 func (h *DashboardHandler) HandleCORS(w http.ResponseWriter, r *http.Request) {
 	origin := r.Header.Get("Origin")
@@ -302,8 +1292,8 @@ func (h *DashboardHandler) HandleCORS(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", origin)
 	}
 
-	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, If-None-Match")
 	w.Header().Set("Access-Control-Max-Age", "86400")
 	w.WriteHeader(http.StatusNoContent)
 }
@@ -0,0 +1,269 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/mcbile/product-pulse/internal/storage"
+)
+
+// ============================================
+// SAVED DASHBOARDS HANDLER
+// ============================================
+
+// maxDashboardName bounds the name of saved dashboards
+const maxDashboardName = 200
+
+// SavedDashboardStorage is the subset of storage used for saved dashboards
+type SavedDashboardStorage interface {
+	CreateDashboard(ctx context.Context, d storage.Dashboard) (storage.Dashboard, error)
+	GetDashboard(ctx context.Context, id int64) (storage.Dashboard, error)
+	GetDashboards(ctx context.Context, owner string, sites []string) ([]storage.Dashboard, error)
+	UpdateDashboard(ctx context.Context, d storage.Dashboard) (storage.Dashboard, error)
+	DeleteDashboard(ctx context.Context, id int64) (bool, error)
+}
+
+// SavedDashboardHandler stores named dashboard configurations per user.
+// Owners share a dashboard with everyone who can see its site; only the
+// owner and admins change or delete it.
+type SavedDashboardHandler struct {
+	storage        SavedDashboardStorage
+	allowedOrigins map[string]bool
+	allowAll       bool
+}
+
+func NewSavedDashboardHandler(store SavedDashboardStorage, origins []string) *SavedDashboardHandler {
+	h := &SavedDashboardHandler{
+		storage:        store,
+		allowedOrigins: make(map[string]bool),
+	}
+	for _, o := range origins {
+		if o == "*" {
+			h.allowAll = true
+			break
+		}
+		h.allowedOrigins[o] = true
+	}
+	return h
+}
+
+type dashboardRequest struct {
+	Name   string          `json:"name"`
+	SiteID string          `json:"site_id"`
+	Shared bool            `json:"shared"`
+	Config json.RawMessage `json:"config"`
+}
+
+// dashboardConfig is the part of a config the API checks; other fields
+// are stored as they are
+type dashboardConfig struct {
+	Panels    []json.RawMessage          `json:"panels"`
+	Filters   map[string]json.RawMessage `json:"filters"`
+	TimeRange json.RawMessage            `json:"time_range"`
+}
+
+// validate checks the request against the user's sites; it answers 400 or
+// 403 and returns false for invalid requests
+func (req *dashboardRequest) validate(w http.ResponseWriter, r *http.Request) bool {
+	if req.Name == "" || len(req.Name) > maxDashboardName {
+		http.Error(w, "name is required and at most 200 characters", http.StatusBadRequest)
+		return false
+	}
+	if len(req.Config) == 0 || string(req.Config) == "null" {
+		req.Config = json.RawMessage("{}")
+	}
+	var config dashboardConfig
+	if err := json.Unmarshal(req.Config, &config); err != nil {
+		http.Error(w, "config must be an object with panels (array), filters (object) and time_range", http.StatusBadRequest)
+		return false
+	}
+
+	// Users restricted to sites only save dashboards of their sites
+	if sites := siteScope(r); sites != nil && !slices.Contains(sites, req.SiteID) {
+		http.Error(w, "site_id must be one of your sites", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// HandleList returns the user's own dashboards and those shared with them
+// GET /api/dashboards
+func (h *SavedDashboardHandler) HandleList(w http.ResponseWriter, r *http.Request) {
+	h.setCORS(w, r)
+
+	user, _ := UserFromContext(r.Context())
+	dashboards, err := h.storage.GetDashboards(r.Context(), user.Email, siteScope(r))
+	if err != nil {
+		slog.Error("failed to list dashboards", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if dashboards == nil {
+		dashboards = []storage.Dashboard{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"dashboards": dashboards,
+	})
+}
+
+// HandleGet returns one dashboard the user may see
+// GET /api/dashboards/{id}
+func (h *SavedDashboardHandler) HandleGet(w http.ResponseWriter, r *http.Request) {
+	h.setCORS(w, r)
+
+	d, ok := h.load(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(d)
+}
+
+// HandleCreate saves a new dashboard owned by the user
+// POST /api/dashboards
+func (h *SavedDashboardHandler) HandleCreate(w http.ResponseWriter, r *http.Request) {
+	h.setCORS(w, r)
+
+	var req dashboardRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if !req.validate(w, r) {
+		return
+	}
+
+	user, _ := UserFromContext(r.Context())
+	d, err := h.storage.CreateDashboard(r.Context(), storage.Dashboard{
+		Name:   req.Name,
+		Owner:  user.Email,
+		SiteID: req.SiteID,
+		Shared: req.Shared,
+		Config: req.Config,
+	})
+	if err != nil {
+		slog.Error("failed to create dashboard", "name", req.Name, "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(d)
+}
+
+// HandleUpdate replaces name, site, sharing and config of a dashboard
+// PUT /api/dashboards/{id}
+func (h *SavedDashboardHandler) HandleUpdate(w http.ResponseWriter, r *http.Request) {
+	h.setCORS(w, r)
+
+	d, ok := h.load(w, r)
+	if !ok || !canEditDashboard(w, r, d) {
+		return
+	}
+
+	var req dashboardRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if !req.validate(w, r) {
+		return
+	}
+
+	d.Name, d.SiteID, d.Shared, d.Config = req.Name, req.SiteID, req.Shared, req.Config
+	updated, err := h.storage.UpdateDashboard(r.Context(), d)
+	if errors.Is(err, storage.ErrDashboardNotFound) {
+		http.Error(w, "dashboard not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		slog.Error("failed to update dashboard", "id", d.ID, "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(updated)
+}
+
+// HandleDelete removes a dashboard
+// DELETE /api/dashboards/{id}
+func (h *SavedDashboardHandler) HandleDelete(w http.ResponseWriter, r *http.Request) {
+	h.setCORS(w, r)
+
+	d, ok := h.load(w, r)
+	if !ok || !canEditDashboard(w, r, d) {
+		return
+	}
+
+	deleted, err := h.storage.DeleteDashboard(r.Context(), d.ID)
+	if err != nil {
+		slog.Error("failed to delete dashboard", "id", d.ID, "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if !deleted {
+		http.Error(w, "dashboard not found", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// load returns the dashboard of the request path if the user may see it.
+// Dashboards the user may not see answer 404, like unknown ones.
+func (h *SavedDashboardHandler) load(w http.ResponseWriter, r *http.Request) (storage.Dashboard, bool) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid dashboard id", http.StatusBadRequest)
+		return storage.Dashboard{}, false
+	}
+
+	d, err := h.storage.GetDashboard(r.Context(), id)
+	if errors.Is(err, storage.ErrDashboardNotFound) {
+		http.Error(w, "dashboard not found", http.StatusNotFound)
+		return d, false
+	}
+	if err != nil {
+		slog.Error("failed to get dashboard", "id", id, "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return d, false
+	}
+
+	user, _ := UserFromContext(r.Context())
+	sites := siteScope(r)
+	visible := d.Owner == user.Email ||
+		(d.Shared && (d.SiteID == "" || sites == nil || slices.Contains(sites, d.SiteID)))
+	if !visible {
+		http.Error(w, "dashboard not found", http.StatusNotFound)
+		return d, false
+	}
+	return d, true
+}
+
+// canEditDashboard answers 403 unless the user owns d or is an admin
+func canEditDashboard(w http.ResponseWriter, r *http.Request, d storage.Dashboard) bool {
+	user, _ := UserFromContext(r.Context())
+	if d.Owner != user.Email && !roles[user.Role].admin {
+		http.Error(w, "only the owner can change this dashboard", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+func (h *SavedDashboardHandler) setCORS(w http.ResponseWriter, r *http.Request) {
+	origin := r.Header.Get("Origin")
+	if h.allowAll {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	} else if h.allowedOrigins[origin] {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+	}
+}
@@ -0,0 +1,79 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/mcbile/product-pulse/internal/health"
+)
+
+// ============================================
+// HEALTH DECISION HANDLER
+// ============================================
+
+// HealthDecisionHandler answers healthy/degraded/down for one component, for
+// automated consumers such as cashier routing and game lobby fallback
+type HealthDecisionHandler struct {
+	decider        *health.Decider
+	allowedOrigins map[string]bool
+	allowAll       bool
+}
+
+func NewHealthDecisionHandler(decider *health.Decider, origins []string) *HealthDecisionHandler {
+	h := &HealthDecisionHandler{
+		decider:        decider,
+		allowedOrigins: make(map[string]bool),
+	}
+	for _, o := range origins {
+		if o == "*" {
+			h.allowAll = true
+			break
+		}
+		h.allowedOrigins[o] = true
+	}
+	return h
+}
+
+// Handle returns the verdict for a component. A verdict that cannot be
+// evaluated is returned as unknown with 503, so consumers can always parse
+// the body.
+// GET /api/health/decision?component=psp:Trustly
+func (h *HealthDecisionHandler) Handle(w http.ResponseWriter, r *http.Request) {
+	h.setCORS(w, r)
+	w.Header().Set("Content-Type", "application/json")
+
+	component := r.URL.Query().Get("component")
+	decision, err := h.decider.Decide(r.Context(), component)
+	if errors.Is(err, health.ErrInvalidComponent) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		slog.Error("failed to evaluate component health", "component", component, "error", err)
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(health.Decision{
+			Component:   component,
+			Status:      health.StatusUnknown,
+			Reason:      "evaluation failed",
+			EvaluatedAt: time.Now().UTC(),
+		})
+		return
+	}
+
+	w.Header().Set("Cache-Control", "max-age="+strconv.Itoa(int(h.decider.CacheTTL().Seconds())))
+	json.NewEncoder(w).Encode(decision)
+}
+
+func (h *HealthDecisionHandler) setCORS(w http.ResponseWriter, r *http.Request) {
+	origin := r.Header.Get("Origin")
+	if h.allowAll {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	} else if h.allowedOrigins[origin] {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+	}
+}
@@ -0,0 +1,152 @@
+package handler
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/mcbile/product-pulse/internal/middleware"
+	"github.com/mcbile/product-pulse/internal/model"
+)
+
+// maxDecompressedBodySize caps the inflated size of compressed request
+// bodies. MAX_BODY_SIZE only limits bytes on the wire, so without this a
+// small gzip payload could expand to gigabytes.
+const maxDecompressedBodySize = 32 << 20
+
+var (
+	errUnsupportedEncoding = errors.New("unsupported content encoding")
+	errInflatedTooLarge    = errors.New("decompressed body too large")
+)
+
+// decodeBody decodes a request body into v, transparently inflating gzip and
+// deflate payloads according to Content-Encoding. JSON, MessagePack and
+// protobuf are selected by Content-Type; see model.Decode.
+func decodeBody(r *http.Request, v interface{}) error {
+	body, err := requestBody(r)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	return model.Decode(body, r.Header.Get("Content-Type"), v)
+}
+
+// decodeBatch decodes a collect batch like decodeBody, but leaves malformed
+// JSON events out instead of failing the request, and reports field drift
+// to the schema drift tracker; see model.DecodeBatch
+func decodeBatch(r *http.Request, v interface{}) ([]model.Rejection, error) {
+	body, err := requestBody(r)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	return model.DecodeBatch(body, r.Header.Get("Content-Type"), v, func(section string, unknown, missing []string) {
+		middleware.ReportSchemaDrift(r, section, unknown, missing)
+	})
+}
+
+// requestBody returns the request body, decompressed if necessary
+func requestBody(r *http.Request) (io.ReadCloser, error) {
+	encoding := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding")))
+
+	switch encoding {
+	case "", "identity":
+		return r.Body, nil
+
+	case "gzip", "x-gzip":
+		zr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			return nil, fmt.Errorf("gzip: %w", err)
+		}
+		return &limitedReadCloser{
+			r:     zr,
+			n:     maxDecompressedBodySize,
+			close: zr.Close,
+			done:  func(n int64) { middleware.ReportDecodedBytes(r, n) },
+		}, nil
+
+	case "deflate":
+		// RFC 9110 "deflate" is zlib-wrapped, but many clients send raw
+		// DEFLATE; sniff the zlib header to accept both
+		br := bufio.NewReader(r.Body)
+		var zr io.ReadCloser
+		if hdr, err := br.Peek(2); err == nil && isZlibHeader(hdr) {
+			zr, err = zlib.NewReader(br)
+			if err != nil {
+				return nil, fmt.Errorf("zlib: %w", err)
+			}
+		} else {
+			zr = flate.NewReader(br)
+		}
+		return &limitedReadCloser{
+			r:     zr,
+			n:     maxDecompressedBodySize,
+			close: zr.Close,
+			done:  func(n int64) { middleware.ReportDecodedBytes(r, n) },
+		}, nil
+
+	default:
+		return nil, fmt.Errorf("%w: %s", errUnsupportedEncoding, encoding)
+	}
+}
+
+func isZlibHeader(b []byte) bool {
+	return b[0]&0x0f == 8 && (uint16(b[0])<<8|uint16(b[1]))%31 == 0
+}
+
+// limitedReadCloser reads at most n bytes of an inflated body and fails
+// with errInflatedTooLarge beyond, rather than cutting the body short into
+// a confusing decode error. done is called on Close with the bytes read.
+type limitedReadCloser struct {
+	r     io.Reader
+	n     int64
+	read  int64
+	close func() error
+	done  func(read int64)
+}
+
+func (l *limitedReadCloser) Read(p []byte) (int, error) {
+	if l.n <= 0 {
+		var b [1]byte
+		if n, _ := io.ReadFull(l.r, b[:]); n > 0 {
+			return 0, errInflatedTooLarge
+		}
+		return 0, io.EOF
+	}
+	if int64(len(p)) > l.n {
+		p = p[:l.n]
+	}
+	n, err := l.r.Read(p)
+	l.n -= int64(n)
+	l.read += int64(n)
+	return n, err
+}
+
+func (l *limitedReadCloser) Close() error {
+	if l.done != nil {
+		l.done(l.read)
+	}
+	return l.close()
+}
+
+// writeDecodeError maps a decodeBody error to an HTTP response
+func writeDecodeError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errUnsupportedEncoding):
+		http.Error(w, "unsupported content encoding", http.StatusUnsupportedMediaType)
+	case errors.Is(err, model.ErrUnsupportedContentType):
+		http.Error(w, "unsupported content type", http.StatusUnsupportedMediaType)
+	case errors.Is(err, errInflatedTooLarge):
+		http.Error(w, "decompressed body too large", http.StatusRequestEntityTooLarge)
+	default:
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,59 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/mcbile/product-pulse/internal/enrich"
+)
+
+// ============================================
+// ENRICHMENT PIPELINE HANDLER
+// ============================================
+
+// EnrichmentHandler shows the enrichment pipeline of frontend events and
+// how much time each stage takes, to tune the chain (admin)
+type EnrichmentHandler struct {
+	pipeline       *enrich.Pipeline
+	allowedOrigins map[string]bool
+	allowAll       bool
+}
+
+func NewEnrichmentHandler(pipeline *enrich.Pipeline, origins []string) *EnrichmentHandler {
+	h := &EnrichmentHandler{
+		pipeline:       pipeline,
+		allowedOrigins: make(map[string]bool),
+	}
+	for _, o := range origins {
+		if o == "*" {
+			h.allowAll = true
+			break
+		}
+		h.allowedOrigins[o] = true
+	}
+	return h
+}
+
+// Handle returns the stages in order with their options, the sites with
+// overrides, and per site and stage the batches, events and events
+// dropped since startup with the time spent
+// GET /api/admin/enrichment
+func (h *EnrichmentHandler) Handle(w http.ResponseWriter, r *http.Request) {
+	h.setCORS(w, r)
+	w.Header().Set("Content-Type", "application/json")
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"stages": h.pipeline.Stages(),
+		"sites":  h.pipeline.SiteStages(),
+		"stats":  h.pipeline.Stats(),
+	})
+}
+
+func (h *EnrichmentHandler) setCORS(w http.ResponseWriter, r *http.Request) {
+	origin := r.Header.Get("Origin")
+	if h.allowAll {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	} else if h.allowedOrigins[origin] {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+	}
+}
@@ -0,0 +1,161 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/mcbile/product-pulse/internal/export"
+)
+
+// ============================================
+// SERVER-SIDE EXPORTS HANDLER
+// ============================================
+
+// AuditExportCreated is the audit event of a server-side export of raw
+// events
+const AuditExportCreated = "export_created"
+
+// exportStorage is the subset of storage used by server-side exports
+type exportStorage interface {
+	auditWriter
+	StreamEvents(ctx context.Context, source string, start, end time.Time, sites []string, fn func(event json.RawMessage) error) error
+}
+
+// ExportHandler writes raw events to encrypted export artifacts and serves
+// them through signed download URLs
+type ExportHandler struct {
+	storage        exportStorage
+	exports        *export.Exports
+	allowedOrigins map[string]bool
+	allowAll       bool
+}
+
+func NewExportHandler(store exportStorage, exports *export.Exports, origins []string) *ExportHandler {
+	h := &ExportHandler{
+		storage:        store,
+		exports:        exports,
+		allowedOrigins: make(map[string]bool),
+	}
+	for _, o := range origins {
+		if o == "*" {
+			h.allowAll = true
+			break
+		}
+		h.allowedOrigins[o] = true
+	}
+	return h
+}
+
+// ExportCreated is the answer of HandleCreate
+type ExportCreated struct {
+	export.Artifact
+	Source       string    `json:"source"`
+	Start        time.Time `json:"start"`
+	End          time.Time `json:"end"`
+	Rows         int       `json:"rows"`
+	URL          string    `json:"url"` // Path and query of the signed download URL
+	URLExpiresAt time.Time `json:"url_expires_at"`
+	ExpiresAt    time.Time `json:"expires_at"` // When the artifact is deleted
+}
+
+// HandleCreate writes the raw events of a metric table in [start, end) of
+// the user's sites to an encrypted artifact and returns a signed URL to
+// download it, for exports too large or slow to stream in one request.
+// start and end work as with GET /api/export/{source}.
+// POST /api/exports/{source}?start=2024-01-15T00:00:00Z&end=2024-01-16T00:00:00Z
+func (h *ExportHandler) HandleCreate(w http.ResponseWriter, r *http.Request) {
+	h.setCORS(w, r)
+	source, start, end, ok := parseExportRange(w, r)
+	if !ok {
+		return
+	}
+
+	rows := 0
+	artifact, err := h.exports.Write(r.Context(), source, func(w io.Writer) error {
+		return h.storage.StreamEvents(r.Context(), source, start, end, siteScope(r), func(event json.RawMessage) error {
+			rows++
+			if _, err := w.Write(event); err != nil {
+				return err
+			}
+			_, err := w.Write([]byte{'\n'})
+			return err
+		})
+	})
+	if err != nil {
+		slog.Error("failed to create export", "source", source, "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	auditChange(r, h.storage, AuditExportCreated, map[string]string{
+		"name":  artifact.Name,
+		"start": start.Format(time.RFC3339),
+		"end":   end.Format(time.RFC3339),
+		"rows":  strconv.Itoa(rows),
+	})
+
+	url, urlExpires := h.exports.SignedURL(artifact.Name)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(ExportCreated{
+		Artifact:     artifact,
+		Source:       source,
+		Start:        start,
+		End:          end,
+		Rows:         rows,
+		URL:          url,
+		URLExpiresAt: urlExpires,
+		ExpiresAt:    artifact.Created.Add(h.exports.TTL()),
+	})
+}
+
+// HandleDownload serves an export artifact as gzipped NDJSON to holders of
+// a valid signed URL; it needs no login, the signature is the credential
+// GET /exports/{name}?expires=1705363200&sig=...
+func (h *ExportHandler) HandleDownload(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	q := r.URL.Query()
+	if err := h.exports.Verify(name, q.Get("expires"), q.Get("sig")); err != nil {
+		status := http.StatusForbidden
+		if errors.Is(err, export.ErrURLExpired) {
+			status = http.StatusGone
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	f, err := h.exports.Open(r.Context(), name)
+	if errors.Is(err, export.ErrNotFound) {
+		http.Error(w, "export expired", http.StatusGone)
+		return
+	}
+	if err != nil {
+		slog.Error("failed to open export", "name", name, "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+	w.Header().Set("Cache-Control", "private, no-store")
+	if _, err := io.Copy(w, f); err != nil {
+		// Headers are sent; the client sees the download end early
+		slog.Warn("export download aborted", "name", name, "error", err)
+	}
+}
+
+func (h *ExportHandler) setCORS(w http.ResponseWriter, r *http.Request) {
+	origin := r.Header.Get("Origin")
+	if h.allowAll {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	} else if h.allowedOrigins[origin] {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+	}
+}
@@ -3,14 +3,20 @@ package handler
 import (
 	"encoding/json"
 	"log/slog"
-	"net"
+	"math"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/mcbile/product-pulse/internal/collector"
+	"github.com/mcbile/product-pulse/internal/enrich"
+	"github.com/mcbile/product-pulse/internal/middleware"
 	"github.com/mcbile/product-pulse/internal/model"
+	"github.com/mcbile/product-pulse/internal/quality"
+	"github.com/mcbile/product-pulse/internal/sdk"
 	"github.com/mcbile/product-pulse/internal/storage"
+	"github.com/mcbile/product-pulse/internal/trace"
 )
 
 // ============================================
@@ -19,13 +25,17 @@ import (
 
 type CollectHandler struct {
 	collector      *collector.BatchCollector
+	limits         *quality.Limits
+	pipeline       *enrich.Pipeline
 	allowedOrigins map[string]bool
 	allowAll       bool
 }
 
-func NewCollectHandler(c *collector.BatchCollector, origins []string) *CollectHandler {
+func NewCollectHandler(c *collector.BatchCollector, limits *quality.Limits, pipeline *enrich.Pipeline, origins []string) *CollectHandler {
 	h := &CollectHandler{
 		collector:      c,
+		limits:         limits,
+		pipeline:       pipeline,
 		allowedOrigins: make(map[string]bool),
 	}
 
@@ -48,55 +58,32 @@ func (h *CollectHandler) Handle(w http.ResponseWriter, r *http.Request) {
 	} else if h.allowedOrigins[origin] {
 		w.Header().Set("Access-Control-Allow-Origin", origin)
 	}
+	w.Header().Set("Access-Control-Expose-Headers", sdk.DeprecationHeader+", "+trace.Header+", Retry-After")
+	batchID := startBatch(w, r)
+
+	if !admit(w, h.collector) {
+		return
+	}
 
 	// Parse body
 	var batch model.EventBatch
-	if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+	malformed, err := decodeBatch(r, &batch)
+	if err != nil {
 		slog.Debug("invalid request body", "error", err)
-		http.Error(w, "invalid json", http.StatusBadRequest)
+		writeDecodeError(w, err)
 		return
 	}
+	h.limits.CountMalformed(r.Header.Get("X-Site-Id"), "frontend", len(malformed))
+	middleware.ReportEvents(r, "frontend", len(batch.Events)+len(malformed))
 
 	if len(batch.Events) == 0 {
-		w.WriteHeader(http.StatusAccepted)
+		writeAccepted(w, len(malformed), malformed)
 		return
 	}
 
-	// Get client info
-	clientIP := getClientIP(r)
-	userAgent := r.UserAgent()
-	country := resolveCountry(clientIP)
+	rejected := queueFrontendEvents(h.collector, h.limits, h.pipeline, r, batchID, batch.Events)
 
-	// Enrich and queue events
-	for _, event := range batch.Events {
-		enriched := model.EnrichedEvent{
-			FrontendEvent: event,
-			Country:       country,
-			UserAgent:     userAgent,
-			IP:            clientIP,
-		}
-
-		// Override country if not set
-		if event.Country == nil || *event.Country == "" {
-			enriched.FrontendEvent.Country = &country
-		}
-
-		// Validate timestamp (not too far in past/future)
-		if event.Time.IsZero() {
-			enriched.FrontendEvent.Time = time.Now().UTC()
-		} else {
-			// Allow up to 1 hour drift
-			diff := time.Since(event.Time)
-			if diff < -time.Hour || diff > time.Hour {
-				enriched.FrontendEvent.Time = time.Now().UTC()
-			}
-		}
-
-		h.collector.Push(enriched)
-	}
-
-	w.WriteHeader(http.StatusAccepted)
-	w.Write([]byte(`{"status":"ok"}`))
+	writeAccepted(w, rejected+len(malformed), malformed)
 }
 
 func (h *CollectHandler) HandleCORS(w http.ResponseWriter, r *http.Request) {
@@ -109,35 +96,108 @@ func (h *CollectHandler) HandleCORS(w http.ResponseWriter, r *http.Request) {
 	}
 
 	w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, X-Site-Id")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Encoding, X-Site-Id, "+sdk.Header+", "+trace.Header+", "+middleware.APIKeyHeader)
 	w.Header().Set("Access-Control-Max-Age", "86400")
 	w.WriteHeader(http.StatusNoContent)
 }
 
-func getClientIP(r *http.Request) string {
-	// Check common proxy headers
-	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
-		parts := strings.Split(xff, ",")
-		return strings.TrimSpace(parts[0])
+// queueFrontendEvents enriches frontend events with client info, runs them
+// through the enrichment pipeline and queues them for batch insert. It
+// returns the number of events rejected by field size policies or dropped
+// by the pipeline.
+func queueFrontendEvents(c *collector.BatchCollector, limits *quality.Limits, pipeline *enrich.Pipeline, r *http.Request, batchID string, events []model.FrontendEvent) int {
+	// Get client info
+	clientIP := getClientIP(r)
+	userAgent := r.UserAgent()
+	site := r.Header.Get("X-Site-Id")
+	rejected := 0
+	enrichedEvents := make([]model.EnrichedEvent, 0, len(events))
+
+	// Enrich and queue events
+	now := time.Now().UTC()
+	for _, event := range events {
+		event.SiteID = site
+		if !limits.AdmitFrontend(site, &event, now) {
+			rejected++
+			continue
+		}
+
+		enrichedEvents = append(enrichedEvents, model.EnrichedEvent{
+			FrontendEvent: event,
+			UserAgent:     userAgent,
+			IP:            clientIP,
+		})
 	}
+	queued := len(enrichedEvents)
+	enrichedEvents = pipeline.Run(site, enrich.Request{IP: clientIP, UserAgent: userAgent, Header: r.Header}, enrichedEvents)
+	rejected += queued - len(enrichedEvents)
+	c.PushBatch(batchID, enrichedEvents)
+
+	return rejected
+}
 
-	if xri := r.Header.Get("X-Real-IP"); xri != "" {
-		return xri
+// startBatch assigns the request its batch ID, which follows its events
+// through queueing, flush and COPY in debug logs, and echoes it to the client
+func startBatch(w http.ResponseWriter, r *http.Request) string {
+	id := trace.FromRequest(r)
+	w.Header().Set(trace.Header, id)
+	slog.Debug("request batch received",
+		"batch_id", id,
+		"path", r.URL.Path,
+		"bytes", r.ContentLength,
+	)
+	return id
+}
+
+// admitter is a collector that can refuse requests under backpressure
+type admitter interface {
+	Admit() (time.Duration, bool)
+}
+
+// admit checks the collectors a request will be queued to and answers 429
+// with Retry-After if any of them is above its high watermark
+func admit(w http.ResponseWriter, collectors ...admitter) bool {
+	for _, c := range collectors {
+		retryAfter, ok := c.Admit()
+		if ok {
+			continue
+		}
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		http.Error(w, "collector overloaded, retry later", http.StatusTooManyRequests)
+		return false
 	}
+	return true
+}
 
-	// Fall back to RemoteAddr
-	ip, _, err := net.SplitHostPort(r.RemoteAddr)
-	if err != nil {
-		return r.RemoteAddr
+// maxReportedRejections caps the malformed events listed in a response
+const maxReportedRejections = 100
+
+// writeAccepted acknowledges a collect request, reporting the number of
+// events rejected (by field size policies, full queues or as malformed) and
+// the position and reason of the malformed ones
+func writeAccepted(w http.ResponseWriter, rejected int, malformed []model.Rejection) {
+	w.WriteHeader(http.StatusAccepted)
+	if rejected == 0 {
+		w.Write([]byte(`{"status":"ok"}`))
+		return
+	}
+	resp := map[string]interface{}{
+		"status":   "ok",
+		"rejected": rejected,
 	}
-	return ip
+	if len(malformed) > 0 {
+		if len(malformed) > maxReportedRejections {
+			malformed = malformed[:maxReportedRejections]
+		}
+		resp["malformed"] = malformed
+	}
+	json.NewEncoder(w).Encode(resp)
 }
 
-// resolveCountry from IP - placeholder for GeoIP integration
-func resolveCountry(ip string) string {
-	// TODO: Integrate MaxMind GeoIP2 or ip-api.com
-	// For now, return empty and let DB handle it
-	return ""
+// getClientIP returns the client address of r; proxy headers only count
+// when sent by a trusted proxy, see middleware.TrustedProxies
+func getClientIP(r *http.Request) string {
+	return middleware.ClientIP(r)
 }
 
 // ============================================
@@ -145,11 +205,12 @@ func resolveCountry(ip string) string {
 // ============================================
 
 type HealthHandler struct {
-	db *storage.Postgres
+	db        *storage.Postgres
+	readiness *Readiness // nil: /ready only checks the database
 }
 
-func NewHealthHandler(db *storage.Postgres) *HealthHandler {
-	return &HealthHandler{db: db}
+func NewHealthHandler(db *storage.Postgres, readiness *Readiness) *HealthHandler {
+	return &HealthHandler{db: db, readiness: readiness}
 }
 
 func (h *HealthHandler) Handle(w http.ResponseWriter, r *http.Request) {
@@ -168,6 +229,13 @@ func (h *HealthHandler) HandleReady(w http.ResponseWriter, r *http.Request) {
 	}
 
 	w.Header().Set("Content-Type", "application/json")
+	if h.readiness != nil {
+		if reason := h.readiness.Degraded(); reason != "" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			json.NewEncoder(w).Encode(map[string]string{"status": "degraded", "message": reason})
+			return
+		}
+	}
 	w.Write([]byte(`{"status":"ok"}`))
 }
 
@@ -177,14 +245,31 @@ func (h *HealthHandler) HandleReady(w http.ResponseWriter, r *http.Request) {
 
 type MetricsHandler struct {
 	collector *collector.BatchCollector
+	backend   *collector.Backend
+	usage     *middleware.UsageMeter
 }
 
-func NewMetricsHandler(c *collector.BatchCollector) *MetricsHandler {
-	return &MetricsHandler{collector: c}
+func NewMetricsHandler(c *collector.BatchCollector, backend *collector.Backend, usage *middleware.UsageMeter) *MetricsHandler {
+	return &MetricsHandler{collector: c, backend: backend, usage: usage}
 }
 
+// Handle returns the collector stats as JSON, or with ?format=prometheus
+// (or an Accept of text/plain) in the Prometheus text format together with
+// the ingest usage per site and metric type
+// GET /metrics
 func (h *MetricsHandler) Handle(w http.ResponseWriter, r *http.Request) {
-	stats := h.collector.GetStats()
+	if r.URL.Query().Get("format") == "prometheus" || strings.Contains(r.Header.Get("Accept"), "text/plain") {
+		h.handlePrometheus(w)
+		return
+	}
+
+	stats := struct {
+		model.CollectorStats
+		Backend map[string]model.CollectorStats `json:"backend"`
+	}{
+		CollectorStats: h.collector.GetStats(),
+		Backend:        h.backend.GetStats(),
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(stats)
@@ -195,14 +280,16 @@ func (h *MetricsHandler) Handle(w http.ResponseWriter, r *http.Request) {
 // ============================================
 
 type APICollectHandler struct {
-	db             *storage.Postgres
+	collector      *collector.Collector[model.APIMetric]
+	limits         *quality.Limits
 	allowedOrigins map[string]bool
 	allowAll       bool
 }
 
-func NewAPICollectHandler(db *storage.Postgres, origins []string) *APICollectHandler {
+func NewAPICollectHandler(c *collector.Collector[model.APIMetric], limits *quality.Limits, origins []string) *APICollectHandler {
 	h := &APICollectHandler{
-		db:             db,
+		collector:      c,
+		limits:         limits,
 		allowedOrigins: make(map[string]bool),
 	}
 	for _, o := range origins {
@@ -217,38 +304,52 @@ func NewAPICollectHandler(db *storage.Postgres, origins []string) *APICollectHan
 
 func (h *APICollectHandler) Handle(w http.ResponseWriter, r *http.Request) {
 	h.setCORS(w, r)
+	batchID := startBatch(w, r)
 
-	var batch struct {
-		Metrics []model.APIMetric `json:"metrics"`
+	if !admit(w, h.collector) {
+		return
 	}
-	if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+
+	var batch model.APIMetricBatch
+	malformed, err := decodeBatch(r, &batch)
+	if err != nil {
 		slog.Debug("invalid request body", "error", err)
-		http.Error(w, "invalid json", http.StatusBadRequest)
+		writeDecodeError(w, err)
 		return
 	}
+	site := r.Header.Get("X-Site-Id")
+	h.limits.CountMalformed(site, "api", len(malformed))
+	middleware.ReportEvents(r, "api", len(batch.Metrics)+len(malformed))
 
 	if len(batch.Metrics) == 0 {
-		w.WriteHeader(http.StatusAccepted)
+		writeAccepted(w, len(malformed), malformed)
 		return
 	}
 
-	// Validate timestamps
+	// Validate timestamps and their age, enforce field size policies
 	now := time.Now().UTC()
-	for i := range batch.Metrics {
-		if batch.Metrics[i].Time.IsZero() {
-			batch.Metrics[i].Time = now
+	metrics := batch.Metrics[:0]
+	for _, m := range batch.Metrics {
+		m.SiteID = site
+		if !h.limits.Admit(site, "api", now, &m.Time, &m.Metadata, &m.ErrorMessage) {
+			continue
 		}
+		metrics = append(metrics, m)
+	}
+	rejected := len(batch.Metrics) - len(metrics) + len(malformed)
+	if len(metrics) == 0 {
+		writeAccepted(w, rejected, malformed)
+		return
 	}
 
-	ctx := r.Context()
-	if err := h.db.InsertAPIMetrics(ctx, batch.Metrics); err != nil {
-		slog.Error("failed to insert API metrics", "error", err)
-		http.Error(w, "internal error", http.StatusInternalServerError)
+	// Queue for batched COPY; fail the request only if nothing fit
+	dropped := h.collector.PushBatch(batchID, metrics)
+	if dropped == len(metrics) {
+		http.Error(w, "queue full", http.StatusServiceUnavailable)
 		return
 	}
 
-	w.WriteHeader(http.StatusAccepted)
-	w.Write([]byte(`{"status":"ok"}`))
+	writeAccepted(w, rejected+dropped, malformed)
 }
 
 func (h *APICollectHandler) setCORS(w http.ResponseWriter, r *http.Request) {
@@ -265,14 +366,16 @@ func (h *APICollectHandler) setCORS(w http.ResponseWriter, r *http.Request) {
 // ============================================
 
 type PSPCollectHandler struct {
-	db             *storage.Postgres
+	collector      *collector.Collector[model.PSPMetric]
+	limits         *quality.Limits
 	allowedOrigins map[string]bool
 	allowAll       bool
 }
 
-func NewPSPCollectHandler(db *storage.Postgres, origins []string) *PSPCollectHandler {
+func NewPSPCollectHandler(c *collector.Collector[model.PSPMetric], limits *quality.Limits, origins []string) *PSPCollectHandler {
 	h := &PSPCollectHandler{
-		db:             db,
+		collector:      c,
+		limits:         limits,
 		allowedOrigins: make(map[string]bool),
 	}
 	for _, o := range origins {
@@ -287,38 +390,52 @@ func NewPSPCollectHandler(db *storage.Postgres, origins []string) *PSPCollectHan
 
 func (h *PSPCollectHandler) Handle(w http.ResponseWriter, r *http.Request) {
 	h.setCORS(w, r)
+	batchID := startBatch(w, r)
 
-	var batch struct {
-		Metrics []model.PSPMetric `json:"metrics"`
+	if !admit(w, h.collector) {
+		return
 	}
-	if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+
+	var batch model.PSPMetricBatch
+	malformed, err := decodeBatch(r, &batch)
+	if err != nil {
 		slog.Debug("invalid request body", "error", err)
-		http.Error(w, "invalid json", http.StatusBadRequest)
+		writeDecodeError(w, err)
 		return
 	}
+	site := r.Header.Get("X-Site-Id")
+	h.limits.CountMalformed(site, "psp", len(malformed))
+	middleware.ReportEvents(r, "psp", len(batch.Metrics)+len(malformed))
 
 	if len(batch.Metrics) == 0 {
-		w.WriteHeader(http.StatusAccepted)
+		writeAccepted(w, len(malformed), malformed)
 		return
 	}
 
-	// Validate timestamps
+	// Validate timestamps, their age and withdrawal states, enforce field size policies
 	now := time.Now().UTC()
-	for i := range batch.Metrics {
-		if batch.Metrics[i].Time.IsZero() {
-			batch.Metrics[i].Time = now
+	metrics := batch.Metrics[:0]
+	for _, m := range batch.Metrics {
+		m.SiteID = site
+		if !m.ValidState() || !h.limits.Admit(site, "psp", now, &m.Time, &m.Metadata, &m.ErrorMessage) {
+			continue
 		}
+		metrics = append(metrics, m)
+	}
+	rejected := len(batch.Metrics) - len(metrics) + len(malformed)
+	if len(metrics) == 0 {
+		writeAccepted(w, rejected, malformed)
+		return
 	}
 
-	ctx := r.Context()
-	if err := h.db.InsertPSPMetrics(ctx, batch.Metrics); err != nil {
-		slog.Error("failed to insert PSP metrics", "error", err)
-		http.Error(w, "internal error", http.StatusInternalServerError)
+	// Queue for batched COPY; fail the request only if nothing fit
+	dropped := h.collector.PushBatch(batchID, metrics)
+	if dropped == len(metrics) {
+		http.Error(w, "queue full", http.StatusServiceUnavailable)
 		return
 	}
 
-	w.WriteHeader(http.StatusAccepted)
-	w.Write([]byte(`{"status":"ok"}`))
+	writeAccepted(w, rejected+dropped, malformed)
 }
 
 func (h *PSPCollectHandler) setCORS(w http.ResponseWriter, r *http.Request) {
@@ -335,14 +452,16 @@ func (h *PSPCollectHandler) setCORS(w http.ResponseWriter, r *http.Request) {
 // ============================================
 
 type GameCollectHandler struct {
-	db             *storage.Postgres
+	collector      *collector.Collector[model.GameMetric]
+	limits         *quality.Limits
 	allowedOrigins map[string]bool
 	allowAll       bool
 }
 
-func NewGameCollectHandler(db *storage.Postgres, origins []string) *GameCollectHandler {
+func NewGameCollectHandler(c *collector.Collector[model.GameMetric], limits *quality.Limits, origins []string) *GameCollectHandler {
 	h := &GameCollectHandler{
-		db:             db,
+		collector:      c,
+		limits:         limits,
 		allowedOrigins: make(map[string]bool),
 	}
 	for _, o := range origins {
@@ -357,38 +476,52 @@ func NewGameCollectHandler(db *storage.Postgres, origins []string) *GameCollectH
 
 func (h *GameCollectHandler) Handle(w http.ResponseWriter, r *http.Request) {
 	h.setCORS(w, r)
+	batchID := startBatch(w, r)
 
-	var batch struct {
-		Metrics []model.GameMetric `json:"metrics"`
+	if !admit(w, h.collector) {
+		return
 	}
-	if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+
+	var batch model.GameMetricBatch
+	malformed, err := decodeBatch(r, &batch)
+	if err != nil {
 		slog.Debug("invalid request body", "error", err)
-		http.Error(w, "invalid json", http.StatusBadRequest)
+		writeDecodeError(w, err)
 		return
 	}
+	site := r.Header.Get("X-Site-Id")
+	h.limits.CountMalformed(site, "game", len(malformed))
+	middleware.ReportEvents(r, "game", len(batch.Metrics)+len(malformed))
 
 	if len(batch.Metrics) == 0 {
-		w.WriteHeader(http.StatusAccepted)
+		writeAccepted(w, len(malformed), malformed)
 		return
 	}
 
-	// Validate timestamps
+	// Validate timestamps and their age, enforce field size policies
 	now := time.Now().UTC()
-	for i := range batch.Metrics {
-		if batch.Metrics[i].Time.IsZero() {
-			batch.Metrics[i].Time = now
+	metrics := batch.Metrics[:0]
+	for _, m := range batch.Metrics {
+		m.SiteID = site
+		if !h.limits.Admit(site, "game", now, &m.Time, &m.Metadata, &m.ErrorMessage) {
+			continue
 		}
+		metrics = append(metrics, m)
+	}
+	rejected := len(batch.Metrics) - len(metrics) + len(malformed)
+	if len(metrics) == 0 {
+		writeAccepted(w, rejected, malformed)
+		return
 	}
 
-	ctx := r.Context()
-	if err := h.db.InsertGameMetrics(ctx, batch.Metrics); err != nil {
-		slog.Error("failed to insert game metrics", "error", err)
-		http.Error(w, "internal error", http.StatusInternalServerError)
+	// Queue for batched COPY; fail the request only if nothing fit
+	dropped := h.collector.PushBatch(batchID, metrics)
+	if dropped == len(metrics) {
+		http.Error(w, "queue full", http.StatusServiceUnavailable)
 		return
 	}
 
-	w.WriteHeader(http.StatusAccepted)
-	w.Write([]byte(`{"status":"ok"}`))
+	writeAccepted(w, rejected+dropped, malformed)
 }
 
 func (h *GameCollectHandler) setCORS(w http.ResponseWriter, r *http.Request) {
@@ -405,14 +538,16 @@ func (h *GameCollectHandler) setCORS(w http.ResponseWriter, r *http.Request) {
 // ============================================
 
 type WSCollectHandler struct {
-	db             *storage.Postgres
+	collector      *collector.Collector[model.WebSocketMetric]
+	limits         *quality.Limits
 	allowedOrigins map[string]bool
 	allowAll       bool
 }
 
-func NewWSCollectHandler(db *storage.Postgres, origins []string) *WSCollectHandler {
+func NewWSCollectHandler(c *collector.Collector[model.WebSocketMetric], limits *quality.Limits, origins []string) *WSCollectHandler {
 	h := &WSCollectHandler{
-		db:             db,
+		collector:      c,
+		limits:         limits,
 		allowedOrigins: make(map[string]bool),
 	}
 	for _, o := range origins {
@@ -427,38 +562,52 @@ func NewWSCollectHandler(db *storage.Postgres, origins []string) *WSCollectHandl
 
 func (h *WSCollectHandler) Handle(w http.ResponseWriter, r *http.Request) {
 	h.setCORS(w, r)
+	batchID := startBatch(w, r)
 
-	var batch struct {
-		Metrics []model.WebSocketMetric `json:"metrics"`
+	if !admit(w, h.collector) {
+		return
 	}
-	if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+
+	var batch model.WebSocketMetricBatch
+	malformed, err := decodeBatch(r, &batch)
+	if err != nil {
 		slog.Debug("invalid request body", "error", err)
-		http.Error(w, "invalid json", http.StatusBadRequest)
+		writeDecodeError(w, err)
 		return
 	}
+	site := r.Header.Get("X-Site-Id")
+	h.limits.CountMalformed(site, "ws", len(malformed))
+	middleware.ReportEvents(r, "ws", len(batch.Metrics)+len(malformed))
 
 	if len(batch.Metrics) == 0 {
-		w.WriteHeader(http.StatusAccepted)
+		writeAccepted(w, len(malformed), malformed)
 		return
 	}
 
-	// Validate timestamps
+	// Validate timestamps and their age, enforce field size policies
 	now := time.Now().UTC()
-	for i := range batch.Metrics {
-		if batch.Metrics[i].Time.IsZero() {
-			batch.Metrics[i].Time = now
+	metrics := batch.Metrics[:0]
+	for _, m := range batch.Metrics {
+		m.SiteID = site
+		if !h.limits.Admit(site, "ws", now, &m.Time, &m.Metadata, nil) {
+			continue
 		}
+		metrics = append(metrics, m)
+	}
+	rejected := len(batch.Metrics) - len(metrics) + len(malformed)
+	if len(metrics) == 0 {
+		writeAccepted(w, rejected, malformed)
+		return
 	}
 
-	ctx := r.Context()
-	if err := h.db.InsertWebSocketMetrics(ctx, batch.Metrics); err != nil {
-		slog.Error("failed to insert WebSocket metrics", "error", err)
-		http.Error(w, "internal error", http.StatusInternalServerError)
+	// Queue for batched COPY; fail the request only if nothing fit
+	dropped := h.collector.PushBatch(batchID, metrics)
+	if dropped == len(metrics) {
+		http.Error(w, "queue full", http.StatusServiceUnavailable)
 		return
 	}
 
-	w.WriteHeader(http.StatusAccepted)
-	w.Write([]byte(`{"status":"ok"}`))
+	writeAccepted(w, rejected+dropped, malformed)
 }
 
 func (h *WSCollectHandler) setCORS(w http.ResponseWriter, r *http.Request) {
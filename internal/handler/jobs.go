@@ -0,0 +1,119 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/mcbile/product-pulse/internal/jobs"
+)
+
+// ============================================
+// JOBS HANDLER (admin)
+// ============================================
+
+// JobsHandler lists scheduled jobs and lets admins run, pause and resume them
+type JobsHandler struct {
+	scheduler      *jobs.Scheduler
+	allowedOrigins map[string]bool
+	allowAll       bool
+}
+
+func NewJobsHandler(scheduler *jobs.Scheduler, origins []string) *JobsHandler {
+	h := &JobsHandler{
+		scheduler:      scheduler,
+		allowedOrigins: make(map[string]bool),
+	}
+	for _, o := range origins {
+		if o == "*" {
+			h.allowAll = true
+			break
+		}
+		h.allowedOrigins[o] = true
+	}
+	return h
+}
+
+// HandleList returns all jobs with schedule, last run and next run
+// GET /api/jobs
+func (h *JobsHandler) HandleList(w http.ResponseWriter, r *http.Request) {
+	h.setCORS(w, r)
+
+	list, err := h.scheduler.List(r.Context())
+	if err != nil {
+		slog.Error("failed to list jobs", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"jobs": list,
+	})
+}
+
+// HandleRun starts a job immediately
+// POST /api/jobs/{name}/run
+func (h *JobsHandler) HandleRun(w http.ResponseWriter, r *http.Request) {
+	h.setCORS(w, r)
+
+	started, err := h.scheduler.RunNow(r.PathValue("name"))
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+	if !started {
+		http.Error(w, "job already running", http.StatusConflict)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	w.Write([]byte(`{"status":"started"}`))
+}
+
+// HandlePause stops a job from running on its schedule
+// POST /api/jobs/{name}/pause
+func (h *JobsHandler) HandlePause(w http.ResponseWriter, r *http.Request) {
+	h.setPaused(w, r, true)
+}
+
+// HandleResume puts a paused job back on its schedule
+// POST /api/jobs/{name}/resume
+func (h *JobsHandler) HandleResume(w http.ResponseWriter, r *http.Request) {
+	h.setPaused(w, r, false)
+}
+
+func (h *JobsHandler) setPaused(w http.ResponseWriter, r *http.Request, paused bool) {
+	h.setCORS(w, r)
+
+	if err := h.scheduler.SetPaused(r.Context(), r.PathValue("name"), paused); err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": "ok",
+		"paused": paused,
+	})
+}
+
+func (h *JobsHandler) writeError(w http.ResponseWriter, err error) {
+	if errors.Is(err, jobs.ErrUnknownJob) {
+		http.Error(w, "job not found", http.StatusNotFound)
+		return
+	}
+	slog.Error("job operation failed", "error", err)
+	http.Error(w, "internal error", http.StatusInternalServerError)
+}
+
+func (h *JobsHandler) setCORS(w http.ResponseWriter, r *http.Request) {
+	origin := r.Header.Get("Origin")
+	if h.allowAll {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	} else if h.allowedOrigins[origin] {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+	}
+}
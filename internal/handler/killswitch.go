@@ -0,0 +1,192 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/mcbile/product-pulse/internal/middleware"
+	"github.com/mcbile/product-pulse/internal/storage"
+)
+
+// ============================================
+// KILL SWITCH HANDLER (admin)
+// ============================================
+
+// Audit events of kill switches turned on (or changed) and off
+const (
+	AuditKillSwitchOn  = "kill_switch_on"
+	AuditKillSwitchOff = "kill_switch_off"
+)
+
+// KillSwitchStorage is the subset of storage used for kill switches
+type KillSwitchStorage interface {
+	GetKillSwitches(ctx context.Context) ([]storage.KillSwitch, error)
+	SetKillSwitch(ctx context.Context, k storage.KillSwitch) (storage.KillSwitch, error)
+	DeleteKillSwitch(ctx context.Context, siteID, metricType string) (bool, error)
+	InsertAuditEvent(ctx context.Context, e storage.AuditEvent) error
+}
+
+// KillSwitchHandler turns kill switches on and off. Every change needs a
+// reason, which is logged and written to the audit log.
+type KillSwitchHandler struct {
+	storage        KillSwitchStorage
+	switches       *middleware.KillSwitches
+	allowedOrigins map[string]bool
+	allowAll       bool
+}
+
+func NewKillSwitchHandler(store KillSwitchStorage, switches *middleware.KillSwitches, origins []string) *KillSwitchHandler {
+	h := &KillSwitchHandler{
+		storage:        store,
+		switches:       switches,
+		allowedOrigins: make(map[string]bool),
+	}
+	for _, o := range origins {
+		if o == "*" {
+			h.allowAll = true
+			break
+		}
+		h.allowedOrigins[o] = true
+	}
+	return h
+}
+
+// killSwitch is a kill switch with what it stopped since startup
+type killSwitch struct {
+	storage.KillSwitch
+	Stats middleware.KillSwitchStats `json:"stats"`
+}
+
+// HandleList returns the kill switches that are on
+// GET /api/kill-switches
+func (h *KillSwitchHandler) HandleList(w http.ResponseWriter, r *http.Request) {
+	h.setCORS(w, r)
+
+	switches, err := h.storage.GetKillSwitches(r.Context())
+	if err != nil {
+		slog.Error("failed to query kill switches", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	stats := h.switches.Stats()
+	result := make([]killSwitch, 0, len(switches))
+	for _, k := range switches {
+		result = append(result, killSwitch{KillSwitch: k, Stats: stats[k.SiteID+"/"+k.MetricType]})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"kill_switches": result,
+	})
+}
+
+// HandlePut turns a kill switch on, or changes its mode and reason. It
+// takes effect on this collector at once and on others within their
+// reload interval.
+// PUT /api/sites/{site}/kill-switches/{type} {"mode": "discard", "reason": "release 4.2 sends garbage"}
+func (h *KillSwitchHandler) HandlePut(w http.ResponseWriter, r *http.Request) {
+	h.setCORS(w, r)
+
+	site, metricType := r.PathValue("site"), r.PathValue("type")
+	if site == "" || len(site) > 100 {
+		http.Error(w, "site is required and at most 100 characters", http.StatusBadRequest)
+		return
+	}
+	if !middleware.ValidKillType(metricType) {
+		http.Error(w, "type must be all, frontend, a frontend event type, api, psp, game or ws", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		Mode   string `json:"mode"`
+		Reason string `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if req.Mode != middleware.KillReject && req.Mode != middleware.KillDiscard {
+		http.Error(w, "mode must be reject or discard", http.StatusBadRequest)
+		return
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	if req.Reason == "" || len(req.Reason) > 500 {
+		http.Error(w, "reason is required and at most 500 characters", http.StatusBadRequest)
+		return
+	}
+
+	user, _ := UserFromContext(r.Context())
+	k, err := h.storage.SetKillSwitch(r.Context(), storage.KillSwitch{
+		SiteID:     site,
+		MetricType: metricType,
+		Mode:       req.Mode,
+		Reason:     req.Reason,
+		CreatedBy:  user.Email,
+	})
+	if err != nil {
+		slog.Error("failed to save kill switch", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	h.changed(r, AuditKillSwitchOn, k)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(killSwitch{KillSwitch: k, Stats: h.switches.Stats()[site+"/"+metricType]})
+}
+
+// HandleDelete turns a kill switch off, optionally with a reason
+// DELETE /api/sites/{site}/kill-switches/{type}?reason=
+func (h *KillSwitchHandler) HandleDelete(w http.ResponseWriter, r *http.Request) {
+	h.setCORS(w, r)
+
+	site, metricType := r.PathValue("site"), r.PathValue("type")
+	deleted, err := h.storage.DeleteKillSwitch(r.Context(), site, metricType)
+	if err != nil {
+		slog.Error("failed to delete kill switch", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if !deleted {
+		http.Error(w, "kill switch not on", http.StatusNotFound)
+		return
+	}
+	h.changed(r, AuditKillSwitchOff, storage.KillSwitch{
+		SiteID:     site,
+		MetricType: metricType,
+		Reason:     r.URL.Query().Get("reason"),
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{"status":"deleted"}`))
+}
+
+// changed applies a kill switch change right away, logs it with its reason
+// and records it in the audit log
+func (h *KillSwitchHandler) changed(r *http.Request, event string, k storage.KillSwitch) {
+	if err := h.switches.Reload(r.Context()); err != nil {
+		slog.Error("failed to reload kill switches", "error", err)
+	}
+
+	user, _ := UserFromContext(r.Context())
+	slog.Warn("kill switch changed", "event", event, "site_id", k.SiteID, "metric_type", k.MetricType,
+		"mode", k.Mode, "reason", k.Reason, "by", user.Email)
+	auditChange(r, h.storage, event, map[string]string{
+		"site_id":     k.SiteID,
+		"metric_type": k.MetricType,
+		"mode":        k.Mode,
+		"reason":      k.Reason,
+	})
+}
+
+func (h *KillSwitchHandler) setCORS(w http.ResponseWriter, r *http.Request) {
+	origin := r.Header.Get("Origin")
+	if h.allowAll {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	} else if h.allowedOrigins[origin] {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+	}
+}
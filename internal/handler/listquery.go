@@ -0,0 +1,224 @@
+package handler
+
+import (
+	"cmp"
+	"fmt"
+	"net/http"
+	"reflect"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ============================================
+// LIST QUERIES (pagination, sorting, filters)
+// ============================================
+
+// maxListLimit bounds ?limit= of list endpoints
+const maxListLimit = 1000
+
+// TotalCountHeader carries the number of rows matching a list query before
+// limit and offset
+const TotalCountHeader = "X-Total-Count"
+
+// listFilters maps filter parameters to the JSON fields they match
+var listFilters = map[string]string{
+	"service":     "service_name",
+	"psp_name":    "psp_name",
+	"provider":    "provider",
+	"device_type": "device_type",
+	"country":     "country",
+}
+
+// ListQuery is the pagination, sorting and filtering of a list endpoint,
+// from ?limit=&offset=&sort=[-]field and the listFilters parameters
+type ListQuery struct {
+	Limit   int // 0 returns all rows
+	Offset  int
+	Sort    string // JSON field, "" keeps the endpoint's order
+	Desc    bool
+	Filters map[string]string // JSON field = value
+}
+
+// parseListQuery parses the list parameters of r. Filter parameters the
+// endpoint consumes itself (e.g. service on /api/metrics/api/timeseries)
+// are passed as own and not treated as filters. Invalid values answer 400
+// and return ok=false.
+func parseListQuery(w http.ResponseWriter, r *http.Request, own ...string) (ListQuery, bool) {
+	q := r.URL.Query()
+	lq := ListQuery{Filters: make(map[string]string)}
+
+	if s := q.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > maxListLimit {
+			http.Error(w, fmt.Sprintf("limit must be between 1 and %d", maxListLimit), http.StatusBadRequest)
+			return ListQuery{}, false
+		}
+		lq.Limit = n
+	}
+	if s := q.Get("offset"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			http.Error(w, "invalid offset", http.StatusBadRequest)
+			return ListQuery{}, false
+		}
+		lq.Offset = n
+	}
+	lq.Sort, lq.Desc = strings.TrimPrefix(q.Get("sort"), "-"), strings.HasPrefix(q.Get("sort"), "-")
+
+	for param, field := range listFilters {
+		if v := q.Get(param); v != "" && !slices.Contains(own, param) {
+			lq.Filters[field] = v
+		}
+	}
+	return lq, true
+}
+
+// writeList applies q to rows and writes the page like writeQueryResult,
+// with the number of matching rows in TotalCountHeader. Filters or a sort
+// on fields the rows do not have answer 400.
+func writeList[T any](w http.ResponseWriter, r *http.Request, rows []T, q ListQuery) {
+	page, total, err := applyListQuery(rows, q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set(TotalCountHeader, strconv.Itoa(total))
+	if page == nil {
+		page = []T{}
+	}
+	writeQueryResult(w, r, page)
+}
+
+// applyListQuery filters, sorts and pages rows by their JSON fields. It
+// returns the page and the number of rows matching the filters.
+func applyListQuery[T any](rows []T, q ListQuery) ([]T, int, error) {
+	fields := jsonFields(reflect.TypeOf((*T)(nil)).Elem())
+
+	for field := range q.Filters {
+		if _, ok := fields[field]; !ok {
+			return nil, 0, fmt.Errorf("filter on %s is not supported here", field)
+		}
+	}
+	sortIndex, sorted := fields[q.Sort]
+	if q.Sort != "" && !sorted {
+		return nil, 0, fmt.Errorf("sort by %s is not supported here", q.Sort)
+	}
+
+	matched := rows
+	if len(q.Filters) > 0 {
+		matched = make([]T, 0, len(rows))
+		for _, row := range rows {
+			v := reflect.ValueOf(row)
+			keep := true
+			for field, want := range q.Filters {
+				if f := indirect(v.FieldByIndex(fields[field])); !f.IsValid() || fmt.Sprint(f.Interface()) != want {
+					keep = false
+					break
+				}
+			}
+			if keep {
+				matched = append(matched, row)
+			}
+		}
+	}
+
+	if sorted {
+		if len(q.Filters) == 0 {
+			// Do not reorder the caller's slice
+			matched = append([]T(nil), matched...)
+		}
+		sort.SliceStable(matched, func(i, j int) bool {
+			c := compareValues(
+				indirect(reflect.ValueOf(matched[i]).FieldByIndex(sortIndex)),
+				indirect(reflect.ValueOf(matched[j]).FieldByIndex(sortIndex)),
+			)
+			if q.Desc {
+				return c > 0
+			}
+			return c < 0
+		})
+	}
+
+	total := len(matched)
+	if q.Offset >= total {
+		return nil, total, nil
+	}
+	matched = matched[q.Offset:]
+	if q.Limit > 0 && q.Limit < len(matched) {
+		matched = matched[:q.Limit]
+	}
+	return matched, total, nil
+}
+
+// jsonFieldCache holds jsonFields per row type
+var jsonFieldCache sync.Map // reflect.Type -> map[string][]int
+
+// jsonFields maps the JSON names of a struct's fields to their index
+func jsonFields(t reflect.Type) map[string][]int {
+	if cached, ok := jsonFieldCache.Load(t); ok {
+		return cached.(map[string][]int)
+	}
+	fields := make(map[string][]int)
+	if t.Kind() == reflect.Struct {
+		for _, f := range reflect.VisibleFields(t) {
+			name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+			if f.IsExported() && name != "" && name != "-" {
+				fields[name] = f.Index
+			}
+		}
+	}
+	jsonFieldCache.Store(t, fields)
+	return fields
+}
+
+// indirect dereferences pointers; nil pointers give an invalid value
+func indirect(v reflect.Value) reflect.Value {
+	for v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return reflect.Value{}
+		}
+		v = v.Elem()
+	}
+	return v
+}
+
+// compareValues orders two values of the same field; missing values (nil
+// pointers) sort first
+func compareValues(a, b reflect.Value) int {
+	switch {
+	case !a.IsValid() && !b.IsValid():
+		return 0
+	case !a.IsValid():
+		return -1
+	case !b.IsValid():
+		return 1
+	}
+
+	if ta, ok := a.Interface().(time.Time); ok {
+		return ta.Compare(b.Interface().(time.Time))
+	}
+	switch a.Kind() {
+	case reflect.String:
+		return strings.Compare(a.String(), b.String())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return cmp.Compare(a.Int(), b.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return cmp.Compare(a.Uint(), b.Uint())
+	case reflect.Float32, reflect.Float64:
+		return cmp.Compare(a.Float(), b.Float())
+	case reflect.Bool:
+		return cmp.Compare(boolInt(a.Bool()), boolInt(b.Bool()))
+	}
+	return 0
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,141 @@
+package handler
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"mime"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// ============================================
+// NDJSON STREAMING
+// ============================================
+
+const (
+	// ndjsonBatchRows is how many rows are written before they are flushed
+	// to the client
+	ndjsonBatchRows = 500
+
+	// ndjsonWriteTimeout is how long the client may take to read a batch.
+	// Rows are read from the database only as fast as the client takes
+	// them, so a client that stops reading is cut off rather than holding
+	// a connection.
+	ndjsonWriteTimeout = 30 * time.Second
+)
+
+// wantsNDJSON reports whether a dashboard request asks for NDJSON, with
+// ?format=ndjson or Accept: application/x-ndjson
+func wantsNDJSON(r *http.Request) bool {
+	if r.URL.Query().Get("format") == "ndjson" {
+		return true
+	}
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		if mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(accept)); err == nil &&
+			(mediaType == "application/x-ndjson" || mediaType == "application/jsonl") {
+			return true
+		}
+	}
+	return false
+}
+
+// acceptsGzip reports whether the client takes gzip responses
+func acceptsGzip(r *http.Request) bool {
+	for _, enc := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(enc), ";")
+		if strings.EqualFold(strings.TrimSpace(name), "gzip") && strings.ReplaceAll(params, " ", "") != "q=0" {
+			return true
+		}
+	}
+	return false
+}
+
+// ndjsonStream writes rows as newline-delimited JSON, one object per line,
+// gzipped when the client accepts it. Rows are flushed in batches, and the
+// write deadline is extended per batch, so a stream may run longer than
+// the server's write timeout as long as the client keeps reading.
+type ndjsonStream struct {
+	r     *http.Request
+	rc    *http.ResponseController
+	gz    *gzip.Writer
+	enc   *json.Encoder
+	rows  int
+	batch int
+}
+
+// newNDJSONStream starts an NDJSON response
+func newNDJSONStream(w http.ResponseWriter, r *http.Request) *ndjsonStream {
+	s := &ndjsonStream{r: r, rc: http.NewResponseController(w)}
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Add("Vary", "Accept-Encoding")
+	if acceptsGzip(r) {
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Header().Del("Content-Length")
+		s.gz, _ = gzip.NewWriterLevel(w, gzip.BestSpeed)
+		s.enc = json.NewEncoder(s.gz)
+	} else {
+		s.enc = json.NewEncoder(w)
+	}
+	s.rc.SetWriteDeadline(time.Now().Add(ndjsonWriteTimeout))
+	return s
+}
+
+// Write writes one row; json.RawMessage rows are written as they are. An
+// error means the client is gone and the stream should stop.
+func (s *ndjsonStream) Write(v interface{}) error {
+	if err := s.enc.Encode(v); err != nil {
+		return err
+	}
+	s.rows++
+	if s.batch++; s.batch < ndjsonBatchRows {
+		return nil
+	}
+	s.batch = 0
+	return s.flush()
+}
+
+func (s *ndjsonStream) flush() error {
+	if s.gz != nil {
+		if err := s.gz.Flush(); err != nil {
+			return err
+		}
+	}
+	if err := s.rc.Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		return err
+	}
+	// Unsupported by some writers, then the server's timeout applies
+	s.rc.SetWriteDeadline(time.Now().Add(ndjsonWriteTimeout))
+	return nil
+}
+
+// Close ends the stream and records the rows written in the query log
+func (s *ndjsonStream) Close() error {
+	setQueryRowCount(s.r, s.rows)
+	if s.gz != nil {
+		return s.gz.Close()
+	}
+	return nil
+}
+
+// writeNDJSON streams v, a slice or a single value, as NDJSON
+func writeNDJSON(w http.ResponseWriter, r *http.Request, v interface{}) {
+	s := newNDJSONStream(w, r)
+	rows := reflect.ValueOf(v)
+	if rows.Kind() != reflect.Slice {
+		s.Write(v)
+		s.Close()
+		return
+	}
+	for i := 0; i < rows.Len(); i++ {
+		if err := s.Write(rows.Index(i).Interface()); err != nil {
+			slog.Debug("ndjson response aborted", "path", r.URL.Path, "error", err)
+			return
+		}
+	}
+	s.Close()
+}
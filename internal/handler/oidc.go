@@ -0,0 +1,193 @@
+package handler
+
+import (
+	"crypto/subtle"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mcbile/product-pulse/internal/idtoken"
+	"github.com/mcbile/product-pulse/internal/storage"
+)
+
+// ============================================
+// OIDC LOGIN
+// ============================================
+
+// oidcLoginTTL bounds the time between starting a login and the callback
+const oidcLoginTTL = 10 * time.Minute
+
+// oidcStateCookie binds a login to the browser that started it: it holds
+// the hash of the state, and the callback only accepts a state matching it.
+// Without it an attacker could send a victim the callback URL of the
+// attacker's own login and sign the victim in as the attacker.
+const oidcStateCookie = "pulse_oidc_state"
+
+// EnableOIDC turns on login with a generic OIDC provider. The browser is
+// redirected to dashboardURL after the callback, with the session tokens in
+// the URL fragment. With requireVerified, ID tokens must carry
+// email_verified=true; providers that never send the claim (Azure AD) need
+// it off.
+func (h *AuthHandler) EnableOIDC(provider *idtoken.Provider, dashboardURL string, requireVerified bool) {
+	h.oidc = provider
+	h.dashboardURL = dashboardURL
+	h.oidcRequireVerified = requireVerified
+}
+
+// HandleOIDCLogin handles GET /api/auth/oidc/login - starts the
+// authorization code flow. State, nonce and the PKCE code verifier are
+// kept in the database, so the callback may reach any replica; the
+// browser keeps the hash of the state in a cookie.
+func (h *AuthHandler) HandleOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	if h.oidc == nil {
+		http.Error(w, "OIDC login is not configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	state := generateToken()
+	login := storage.OIDCLogin{
+		StateHash:    hashToken(state),
+		CodeVerifier: idtoken.NewCodeVerifier(),
+		Nonce:        generateToken(),
+		ExpiresAt:    h.clock.Now().Add(oidcLoginTTL),
+	}
+
+	authURL, err := h.oidc.AuthCodeURL(r.Context(), state, login.Nonce, login.CodeVerifier)
+	if err != nil {
+		slog.Error("OIDC provider unavailable", "issuer", h.oidc.Issuer(), "error", err)
+		h.redirectOIDCError(w, r, "provider_unavailable")
+		return
+	}
+	if err := h.storage.CreateOIDCLogin(r.Context(), login); err != nil {
+		slog.Error("failed to store OIDC login", "error", err)
+		h.redirectOIDCError(w, r, "internal_error")
+		return
+	}
+
+	http.SetCookie(w, h.stateCookie(login.StateHash, int(oidcLoginTTL.Seconds())))
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// stateCookie returns the state cookie, scoped to the callback; a
+// negative maxAge deletes it. SameSite=Lax still sends it on the
+// provider's top-level redirect back to the callback.
+func (h *AuthHandler) stateCookie(value string, maxAge int) *http.Cookie {
+	path := "/"
+	if u, err := url.Parse(h.oidc.RedirectURL()); err == nil && u.Path != "" {
+		path = u.Path
+	}
+	return &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    value,
+		Path:     path,
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(h.oidc.RedirectURL(), "https://"),
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// HandleOIDCCallback handles GET /api/auth/oidc/callback - redeems the
+// authorization code, creates a session and returns to the dashboard
+func (h *AuthHandler) HandleOIDCCallback(w http.ResponseWriter, r *http.Request) {
+	if h.oidc == nil {
+		http.Error(w, "OIDC login is not configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	q := r.URL.Query()
+	if e := q.Get("error"); e != "" {
+		slog.Warn("OIDC login failed at provider", "error", e, "description", q.Get("error_description"))
+		h.redirectOIDCError(w, r, "access_denied")
+		return
+	}
+
+	// The state must belong to a login started by this browser
+	stateHash := hashToken(q.Get("state"))
+	cookie, err := r.Cookie(oidcStateCookie)
+	http.SetCookie(w, h.stateCookie("", -1))
+	if err != nil || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(stateHash)) != 1 {
+		slog.Warn("OIDC callback without matching state cookie")
+		h.redirectOIDCError(w, r, "login_expired")
+		return
+	}
+
+	login, err := h.storage.TakeOIDCLogin(r.Context(), stateHash)
+	if errors.Is(err, storage.ErrOIDCLoginNotFound) {
+		slog.Warn("OIDC callback with unknown or expired state")
+		h.redirectOIDCError(w, r, "login_expired")
+		return
+	}
+	if err != nil {
+		slog.Error("failed to load OIDC login", "error", err)
+		h.redirectOIDCError(w, r, "internal_error")
+		return
+	}
+
+	claims, err := h.oidc.Exchange(r.Context(), q.Get("code"), login.CodeVerifier, login.Nonce)
+	if errors.Is(err, idtoken.ErrInvalidToken) {
+		slog.Warn("invalid OIDC login", "error", err)
+		h.redirectOIDCError(w, r, "invalid_token")
+		return
+	}
+	if err != nil {
+		slog.Error("OIDC code exchange failed", "issuer", h.oidc.Issuer(), "error", err)
+		h.redirectOIDCError(w, r, "provider_unavailable")
+		return
+	}
+
+	// Unverified emails could take over existing users, including seeded
+	// admins, so they are rejected unless the provider is trusted not to
+	// issue them
+	email := strings.ToLower(claims.Email)
+	if email == "" || (h.oidcRequireVerified && !claims.EmailVerified) {
+		slog.Warn("OIDC login denied - no verified email", "subject", claims.Subject)
+		h.redirectOIDCError(w, r, "email_required")
+		return
+	}
+
+	if !h.isAllowedDomain(email) {
+		slog.Warn("OIDC login denied - domain not allowed", "email", email)
+		h.redirectOIDCError(w, r, "domain_not_allowed")
+		return
+	}
+
+	// New users join as clients; known users keep their role
+	stored, err := h.storage.RecordSignIn(r.Context(), storage.User{
+		Email:    email,
+		Name:     claims.Name,
+		Nickname: claims.Name,
+		Role:     RoleClient,
+		Picture:  claims.Picture,
+	})
+	if err != nil {
+		slog.Error("failed to record OIDC sign-in", "email", email, "error", err)
+		h.redirectOIDCError(w, r, "internal_error")
+		return
+	}
+
+	tokens, err := h.createSession(r, userFromStorage(stored), LoginOIDC)
+	if err != nil {
+		slog.Error("failed to create session", "email", stored.Email, "error", err)
+		h.redirectOIDCError(w, r, "internal_error")
+		return
+	}
+
+	slog.Info("OIDC login successful", "email", email, "role", stored.Role)
+	// The fragment is not sent to servers or in Referer headers
+	fragment := url.Values{
+		"token":         {tokens.Token},
+		"refresh_token": {tokens.RefreshToken},
+		"expires_in":    {strconv.Itoa(tokens.ExpiresIn)},
+	}
+	http.Redirect(w, r, h.dashboardURL+"#"+fragment.Encode(), http.StatusFound)
+}
+
+// redirectOIDCError returns the browser to the dashboard with an error code
+func (h *AuthHandler) redirectOIDCError(w http.ResponseWriter, r *http.Request, code string) {
+	http.Redirect(w, r, h.dashboardURL+"#"+url.Values{"oidc_error": {code}}.Encode(), http.StatusFound)
+}
@@ -0,0 +1,234 @@
+package handler
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mcbile/product-pulse/internal/idtoken"
+	"github.com/mcbile/product-pulse/internal/storage"
+	"github.com/mcbile/product-pulse/pkg/clock"
+)
+
+// fakeProvider serves OIDC discovery and a token endpoint rejecting every
+// code, so callbacks that get as far as the code exchange fail with
+// invalid_token
+func fakeProvider(t *testing.T) *httptest.Server {
+	t.Helper()
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			json.NewEncoder(w).Encode(map[string]string{
+				"issuer":                 srv.URL,
+				"authorization_endpoint": srv.URL + "/authorize",
+				"token_endpoint":         srv.URL + "/token",
+				"jwks_uri":               srv.URL + "/jwks",
+			})
+		case "/token":
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func newOIDCHandler(t *testing.T) (*AuthHandler, *memoryAuth) {
+	t.Helper()
+	provider := fakeProvider(t)
+	h, store := newTestAuthHandler(t, clock.NewFake(time.Now()))
+	h.EnableOIDC(idtoken.NewProvider(idtoken.ProviderConfig{
+		IssuerURL:   provider.URL,
+		ClientID:    "pulse",
+		RedirectURL: "https://pulse.example.com/api/auth/oidc/callback",
+	}), "https://dashboard.example.com/", true)
+	return h, store
+}
+
+// startOIDCLogin returns the state sent to the provider and the state
+// cookie set for the browser
+func startOIDCLogin(t *testing.T, h *AuthHandler) (string, *http.Cookie) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	h.HandleOIDCLogin(w, httptest.NewRequest(http.MethodGet, "/api/auth/oidc/login", nil))
+	if w.Code != http.StatusFound {
+		t.Fatalf("login: %d %s", w.Code, w.Body)
+	}
+	loc, _ := url.Parse(w.Header().Get("Location"))
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("login set %d cookies", len(cookies))
+	}
+	return loc.Query().Get("state"), cookies[0]
+}
+
+func oidcCallback(h *AuthHandler, state string, cookie *http.Cookie) string {
+	r := httptest.NewRequest(http.MethodGet, "/api/auth/oidc/callback?"+url.Values{"state": {state}, "code": {"abc"}}.Encode(), nil)
+	if cookie != nil {
+		r.AddCookie(cookie)
+	}
+	w := httptest.NewRecorder()
+	h.HandleOIDCCallback(w, r)
+	loc, _ := url.Parse(w.Header().Get("Location"))
+	fragment, _ := url.ParseQuery(loc.Fragment)
+	return fragment.Get("oidc_error")
+}
+
+func TestOIDCLoginSetsStateCookie(t *testing.T) {
+	h, _ := newOIDCHandler(t)
+	state, cookie := startOIDCLogin(t, h)
+
+	if cookie.Name != oidcStateCookie || cookie.Value != hashToken(state) {
+		t.Errorf("cookie %s=%s, want the hash of the state", cookie.Name, cookie.Value)
+	}
+	if !cookie.HttpOnly || !cookie.Secure || cookie.SameSite != http.SameSiteLaxMode {
+		t.Errorf("cookie not HttpOnly, Secure and SameSite=Lax: %+v", cookie)
+	}
+	if cookie.Path != "/api/auth/oidc/callback" || cookie.MaxAge != int(oidcLoginTTL.Seconds()) {
+		t.Errorf("cookie path %q max age %d", cookie.Path, cookie.MaxAge)
+	}
+	if strings.Contains(cookie.String(), state) {
+		t.Error("cookie holds the state itself")
+	}
+}
+
+func TestOIDCCallbackRequiresStateCookie(t *testing.T) {
+	h, store := newOIDCHandler(t)
+	state, cookie := startOIDCLogin(t, h)
+	_, otherCookie := startOIDCLogin(t, h)
+
+	// The callback URL of someone else's login, opened in another browser
+	if code := oidcCallback(h, state, nil); code != "login_expired" {
+		t.Errorf("without cookie: %q, want login_expired", code)
+	}
+	if code := oidcCallback(h, state, otherCookie); code != "login_expired" {
+		t.Errorf("with the cookie of another login: %q, want login_expired", code)
+	}
+	if _, ok := store.logins[hashToken(state)]; !ok {
+		t.Fatal("rejected callbacks consumed the login")
+	}
+
+	// The browser that started the login gets as far as the code exchange
+	if code := oidcCallback(h, state, cookie); code != "invalid_token" {
+		t.Errorf("with matching cookie: %q, want invalid_token from the exchange", code)
+	}
+}
+
+// signingProvider serves OIDC discovery, its JWKS and a token endpoint
+// issuing an ID token for email with the nonce *nonce
+func signingProvider(t *testing.T, email string, nonce *string) *httptest.Server {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			json.NewEncoder(w).Encode(map[string]string{
+				"issuer":                 srv.URL,
+				"authorization_endpoint": srv.URL + "/authorize",
+				"token_endpoint":         srv.URL + "/token",
+				"jwks_uri":               srv.URL + "/jwks",
+			})
+		case "/jwks":
+			json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+				"kid": "test",
+				"kty": "RSA",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}}})
+		case "/token":
+			json.NewEncoder(w).Encode(map[string]string{"id_token": signIDToken(t, key, map[string]interface{}{
+				"iss":            srv.URL,
+				"sub":            "user-1",
+				"aud":            "pulse",
+				"exp":            time.Now().Add(time.Hour).Unix(),
+				"iat":            time.Now().Unix(),
+				"email":          email,
+				"email_verified": true,
+				"nonce":          *nonce,
+			})})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func signIDToken(t *testing.T, key *rsa.PrivateKey, claims map[string]interface{}) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "test", "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestOIDCCallbackChecksDomainAndAuditsLogin(t *testing.T) {
+	for _, tc := range []struct {
+		email, wantError string
+	}{
+		{"mallory@evil.example", "domain_not_allowed"},
+		{"ana@starcrown.partners", ""},
+	} {
+		h, store := newTestAuthHandler(t, clock.NewFake(time.Now()))
+		var nonce string
+		provider := signingProvider(t, tc.email, &nonce)
+		h.EnableOIDC(idtoken.NewProvider(idtoken.ProviderConfig{
+			IssuerURL:   provider.URL,
+			ClientID:    "pulse",
+			RedirectURL: "https://pulse.example.com/api/auth/oidc/callback",
+		}), "https://dashboard.example.com/", true)
+
+		state, cookie := startOIDCLogin(t, h)
+		nonce = store.logins[hashToken(state)].Nonce
+		if code := oidcCallback(h, state, cookie); code != tc.wantError {
+			t.Errorf("%s: oidc_error %q, want %q", tc.email, code, tc.wantError)
+		}
+
+		var logins []storage.AuditEvent
+		for _, e := range store.audit {
+			if e.Event == AuditLogin {
+				logins = append(logins, e)
+			}
+		}
+		switch {
+		case tc.wantError != "" && (len(logins) != 0 || len(store.sessions) != 0):
+			t.Errorf("%s: denied login created a session or audit event", tc.email)
+		case tc.wantError == "" && (len(logins) != 1 || logins[0].Email != tc.email || logins[0].Detail["method"] != LoginOIDC):
+			t.Errorf("%s: audit events %+v, want one oidc login", tc.email, store.audit)
+		}
+	}
+}
+
+func TestSetAllowedDomains(t *testing.T) {
+	h, _ := newTestAuthHandler(t, clock.NewFake(time.Now()))
+	h.SetAllowedDomains([]string{" @Example.com", ""})
+	if !h.isAllowedDomain("ana@example.com") || h.isAllowedDomain("ana@starcrown.partners") {
+		t.Errorf("domains %v", h.allowedDomains)
+	}
+	h.SetAllowedDomains([]string{"*"})
+	if !h.isAllowedDomain("ana@anywhere.example") || h.isAllowedDomain("not-an-email") {
+		t.Error("* does not allow any domain")
+	}
+}
@@ -0,0 +1,101 @@
+package handler
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mcbile/product-pulse/internal/model"
+	"github.com/mcbile/product-pulse/internal/storage"
+)
+
+// ============================================
+// PRODUCER REGISTRY HANDLER
+// ============================================
+
+// ProducerHandler handles producer registration and listing
+type ProducerHandler struct {
+	db             *storage.Postgres
+	allowedOrigins map[string]bool
+	allowAll       bool
+}
+
+func NewProducerHandler(db *storage.Postgres, origins []string) *ProducerHandler {
+	h := &ProducerHandler{
+		db:             db,
+		allowedOrigins: make(map[string]bool),
+	}
+	for _, o := range origins {
+		if o == "*" {
+			h.allowAll = true
+			break
+		}
+		h.allowedOrigins[o] = true
+	}
+	return h
+}
+
+// HandleRegister handles POST /collect/register - called by producers on startup
+func (h *ProducerHandler) HandleRegister(w http.ResponseWriter, r *http.Request) {
+	h.setCORS(w, r)
+
+	var req model.Producer
+	if err := decodeBody(r, &req); err != nil {
+		slog.Debug("invalid request body", "error", err)
+		writeDecodeError(w, err)
+		return
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		http.Error(w, "name required", http.StatusBadRequest)
+		return
+	}
+	if req.SiteID == "" {
+		req.SiteID = r.Header.Get("X-Site-Id")
+	}
+	req.RegisteredAt = time.Now().UTC()
+
+	if err := h.db.UpsertProducer(r.Context(), req); err != nil {
+		slog.Error("failed to register producer", "producer", req.Name, "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	slog.Info("producer registered",
+		"producer", req.Name,
+		"owner_team", req.OwnerTeam,
+		"sdk_version", req.SDKVersion,
+	)
+
+	w.WriteHeader(http.StatusAccepted)
+	w.Write([]byte(`{"status":"ok"}`))
+}
+
+// HandleList returns the known producers of the user's sites with last
+// activity
+// GET /api/producers
+func (h *ProducerHandler) HandleList(w http.ResponseWriter, r *http.Request) {
+	h.setCORS(w, r)
+	w.Header().Set("Content-Type", "application/json")
+
+	producers, err := h.db.GetProducers(r.Context(), siteScope(r))
+	if err != nil {
+		slog.Error("failed to get producers", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(producers)
+}
+
+func (h *ProducerHandler) setCORS(w http.ResponseWriter, r *http.Request) {
+	origin := r.Header.Get("Origin")
+	if h.allowAll {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	} else if h.allowedOrigins[origin] {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+	}
+}
@@ -0,0 +1,48 @@
+package handler
+
+import (
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/mcbile/product-pulse/internal/model"
+)
+
+// prometheusContentType is the Prometheus text exposition format
+const prometheusContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// handlePrometheus writes the collector stats and ingest usage in the
+// Prometheus text format
+func (h *MetricsHandler) handlePrometheus(w http.ResponseWriter) {
+	stats := h.backend.GetStats()
+	stats["frontend"] = h.collector.GetStats()
+	names := make([]string, 0, len(stats))
+	for name := range stats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	w.Header().Set("Content-Type", prometheusContentType)
+	for _, m := range []struct {
+		name, help, typ string
+		value           func(s model.CollectorStats) float64
+	}{
+		{"pulse_collector_events_received_total", "Events received per collector.", "counter", func(s model.CollectorStats) float64 { return float64(s.EventsReceived) }},
+		{"pulse_collector_events_processed_total", "Events written per collector.", "counter", func(s model.CollectorStats) float64 { return float64(s.EventsProcessed) }},
+		{"pulse_collector_events_failed_total", "Events that could not be written per collector.", "counter", func(s model.CollectorStats) float64 { return float64(s.EventsFailed) }},
+		{"pulse_collector_events_discarded_total", "Events dropped by kill switches per collector.", "counter", func(s model.CollectorStats) float64 { return float64(s.Discarded) }},
+		{"pulse_collector_requests_throttled_total", "Collect requests answered with 429 per collector.", "counter", func(s model.CollectorStats) float64 { return float64(s.Throttled) }},
+		{"pulse_collector_queue_size", "Events queued per collector.", "gauge", func(s model.CollectorStats) float64 { return float64(s.QueueSize) }},
+		{"pulse_collector_ingest_lag_ms", "Queue wait of the oldest event in the last flush.", "gauge", func(s model.CollectorStats) float64 { return s.IngestLagMS }},
+	} {
+		io.WriteString(w, "# HELP "+m.name+" "+m.help+"\n# TYPE "+m.name+" "+m.typ+"\n")
+		for _, name := range names {
+			io.WriteString(w, m.name+`{collector="`+name+`"} `+strconv.FormatFloat(m.value(stats[name]), 'g', -1, 64)+"\n")
+		}
+	}
+
+	if h.usage != nil {
+		h.usage.WritePrometheus(w)
+	}
+}
@@ -0,0 +1,219 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/mcbile/product-pulse/internal/storage"
+)
+
+// ============================================
+// PROVIDER CATALOG HANDLER
+// ============================================
+
+// ProviderStorage is the subset of storage used for the provider catalog
+type ProviderStorage interface {
+	UpsertProvider(ctx context.Context, p storage.Provider) (storage.Provider, error)
+	GetProvider(ctx context.Context, kind, name string) (storage.Provider, error)
+	GetProviders(ctx context.Context, kind string) ([]storage.Provider, error)
+	DeleteProvider(ctx context.Context, kind, name string) (bool, error)
+}
+
+// ProviderHandler serves the catalog of PSPs and game providers: display
+// names and logos for dashboards, regions, contacts and the escalation
+// path quoted in alert notifications. Every user reads it, admins maintain
+// it.
+type ProviderHandler struct {
+	storage        ProviderStorage
+	allowedOrigins map[string]bool
+	allowAll       bool
+}
+
+func NewProviderHandler(store ProviderStorage, origins []string) *ProviderHandler {
+	h := &ProviderHandler{
+		storage:        store,
+		allowedOrigins: make(map[string]bool),
+	}
+	for _, o := range origins {
+		if o == "*" {
+			h.allowAll = true
+			break
+		}
+		h.allowedOrigins[o] = true
+	}
+	return h
+}
+
+type providerRequest struct {
+	DisplayName string                    `json:"display_name"`
+	LogoURL     string                    `json:"logo_url"`
+	Regions     []string                  `json:"regions"`
+	Contacts    []storage.ProviderContact `json:"contacts"`
+	Escalation  string                    `json:"escalation"`
+}
+
+// validProviderKind answers 400 and returns false for unknown kinds
+func validProviderKind(w http.ResponseWriter, kind string) bool {
+	if kind != storage.ProviderPSP && kind != storage.ProviderGame {
+		http.Error(w, "kind must be psp or game", http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// HandleList returns the catalog, optionally of one kind
+// GET /api/providers?kind=psp
+func (h *ProviderHandler) HandleList(w http.ResponseWriter, r *http.Request) {
+	h.setCORS(w, r)
+
+	kind := r.URL.Query().Get("kind")
+	if kind != "" && !validProviderKind(w, kind) {
+		return
+	}
+
+	providers, err := h.storage.GetProviders(r.Context(), kind)
+	if err != nil {
+		slog.Error("failed to list providers", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if providers == nil {
+		providers = []storage.Provider{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"providers": providers,
+	})
+}
+
+// HandleGet returns one catalog entry
+// GET /api/providers/{kind}/{name}
+func (h *ProviderHandler) HandleGet(w http.ResponseWriter, r *http.Request) {
+	h.setCORS(w, r)
+
+	kind := r.PathValue("kind")
+	if !validProviderKind(w, kind) {
+		return
+	}
+	p, err := h.storage.GetProvider(r.Context(), kind, r.PathValue("name"))
+	if errors.Is(err, storage.ErrProviderNotFound) {
+		http.Error(w, "provider not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		slog.Error("failed to get provider", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(p)
+}
+
+// HandlePut creates or replaces a catalog entry (admin). The name is the
+// psp_name or game provider as the metrics report it.
+// PUT /api/providers/{kind}/{name}
+func (h *ProviderHandler) HandlePut(w http.ResponseWriter, r *http.Request) {
+	h.setCORS(w, r)
+
+	kind, name := r.PathValue("kind"), r.PathValue("name")
+	if !validProviderKind(w, kind) {
+		return
+	}
+	if name == "" || len(name) > 100 {
+		http.Error(w, "name is required and at most 100 characters", http.StatusBadRequest)
+		return
+	}
+
+	var req providerRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if req.DisplayName == "" {
+		req.DisplayName = name
+	}
+	if len(req.DisplayName) > 200 {
+		http.Error(w, "display_name must be at most 200 characters", http.StatusBadRequest)
+		return
+	}
+	if req.LogoURL != "" {
+		u, err := url.Parse(req.LogoURL)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			http.Error(w, "logo_url must be an http(s) URL", http.StatusBadRequest)
+			return
+		}
+	}
+	regions := make([]string, 0, len(req.Regions))
+	for _, region := range req.Regions {
+		if region = strings.ToUpper(strings.TrimSpace(region)); region != "" {
+			regions = append(regions, region)
+		}
+	}
+	if req.Contacts == nil {
+		req.Contacts = []storage.ProviderContact{}
+	}
+	for _, c := range req.Contacts {
+		if c.Name == "" || (c.Email == "" && c.Phone == "") {
+			http.Error(w, "contacts need a name and an email or phone", http.StatusBadRequest)
+			return
+		}
+	}
+
+	user, _ := UserFromContext(r.Context())
+	p, err := h.storage.UpsertProvider(r.Context(), storage.Provider{
+		Kind:        kind,
+		Name:        name,
+		DisplayName: req.DisplayName,
+		LogoURL:     req.LogoURL,
+		Regions:     regions,
+		Contacts:    req.Contacts,
+		Escalation:  strings.TrimSpace(req.Escalation),
+		UpdatedBy:   user.Email,
+	})
+	if err != nil {
+		slog.Error("failed to save provider", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(p)
+}
+
+// HandleDelete removes a catalog entry (admin)
+// DELETE /api/providers/{kind}/{name}
+func (h *ProviderHandler) HandleDelete(w http.ResponseWriter, r *http.Request) {
+	h.setCORS(w, r)
+
+	kind := r.PathValue("kind")
+	if !validProviderKind(w, kind) {
+		return
+	}
+	deleted, err := h.storage.DeleteProvider(r.Context(), kind, r.PathValue("name"))
+	if err != nil {
+		slog.Error("failed to delete provider", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if !deleted {
+		http.Error(w, "provider not found", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *ProviderHandler) setCORS(w http.ResponseWriter, r *http.Request) {
+	origin := r.Header.Get("Origin")
+	if h.allowAll {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	} else if h.allowedOrigins[origin] {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+	}
+}
@@ -0,0 +1,173 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mcbile/product-pulse/internal/health"
+)
+
+// ============================================
+// PUBLIC HANDLER (status pages, embeds)
+// ============================================
+
+// Overall states of the public status page
+const (
+	PublicOperational = "operational"
+	PublicDegraded    = "degraded"
+	PublicOutage      = "outage"
+	PublicUnknown     = "unknown"
+)
+
+// PublicComponent is one component shown on the public status page. Label
+// is shown instead of the component so status pages need not reveal
+// provider names.
+type PublicComponent struct {
+	Label     string `json:"name"`
+	Component string `json:"-"` // kind:name, see health.ParseComponent
+	Status    string `json:"status"`
+}
+
+// PublicStatus is the body of GET /public/status
+type PublicStatus struct {
+	Status     string            `json:"status"`
+	Components []PublicComponent `json:"components"`
+	UpdatedAt  time.Time         `json:"updated_at"`
+}
+
+// ParsePublicComponents parses entries in the form [label=]kind:name, e.g.
+// "Payments=psp:Trustly" or "api:wallet". Without a label the component
+// itself is shown.
+func ParsePublicComponents(entries []string) ([]PublicComponent, error) {
+	var components []PublicComponent
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		label, component, ok := strings.Cut(entry, "=")
+		if !ok {
+			label, component = entry, entry
+		}
+		label, component = strings.TrimSpace(label), strings.TrimSpace(component)
+		if _, _, err := health.ParseComponent(component); err != nil || label == "" {
+			return nil, fmt.Errorf("invalid public status component %q, expected [label=]kind:name", entry)
+		}
+		components = append(components, PublicComponent{Label: label, Component: component})
+	}
+	return components, nil
+}
+
+// PublicHandler serves the unauthenticated routes under /public/ for status
+// pages and embeddable widgets. It has its own CORS origins, sends no
+// credentials headers and only exposes the configured components, so
+// embedding a status page never widens what /api/* allows.
+type PublicHandler struct {
+	decider    *health.Decider
+	components []PublicComponent
+	cacheTTL   time.Duration
+
+	allowedOrigins map[string]bool
+	allowAll       bool
+}
+
+func NewPublicHandler(decider *health.Decider, components []PublicComponent, cacheTTL time.Duration, origins []string) *PublicHandler {
+	h := &PublicHandler{
+		decider:        decider,
+		components:     components,
+		cacheTTL:       cacheTTL,
+		allowedOrigins: make(map[string]bool),
+	}
+	for _, o := range origins {
+		if o == "*" {
+			h.allowAll = true
+			break
+		}
+		h.allowedOrigins[o] = true
+	}
+	return h
+}
+
+// HandleStatus returns the health verdicts of the public components and an
+// overall status. Unknown components (too little traffic) do not change
+// the overall status unless every component is unknown.
+// GET /public/status
+func (h *PublicHandler) HandleStatus(w http.ResponseWriter, r *http.Request) {
+	h.setCORS(w, r)
+
+	if len(h.components) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+
+	components := make([]PublicComponent, len(h.components))
+	var wg sync.WaitGroup
+	for i, c := range h.components {
+		wg.Add(1)
+		go func(i int, c PublicComponent) {
+			defer wg.Done()
+			c.Status = h.decide(r.Context(), c.Component)
+			components[i] = c
+		}(i, c)
+	}
+	wg.Wait()
+
+	status := PublicUnknown
+	for _, c := range components {
+		switch {
+		case c.Status == health.StatusDown:
+			status = PublicOutage
+		case c.Status == health.StatusDegraded && status != PublicOutage:
+			status = PublicDegraded
+		case c.Status == health.StatusHealthy && status == PublicUnknown:
+			status = PublicOperational
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age="+strconv.Itoa(int(h.cacheTTL.Seconds())))
+	json.NewEncoder(w).Encode(PublicStatus{
+		Status:     status,
+		Components: components,
+		UpdatedAt:  time.Now().UTC(),
+	})
+}
+
+// decide returns the verdict of a component, or unknown if it cannot be
+// evaluated; the reason is logged, not shown publicly
+func (h *PublicHandler) decide(ctx context.Context, component string) string {
+	decision, err := h.decider.Decide(ctx, component)
+	if err != nil {
+		slog.Warn("failed to evaluate public component", "component", component, "error", err)
+		return health.StatusUnknown
+	}
+	return decision.Status
+}
+
+func (h *PublicHandler) HandleCORS(w http.ResponseWriter, r *http.Request) {
+	h.setCORS(w, r)
+	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	w.Header().Set("Access-Control-Max-Age", "86400")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// setCORS allows the public origins for reading only; public routes never
+// take cookies or Authorization headers
+func (h *PublicHandler) setCORS(w http.ResponseWriter, r *http.Request) {
+	origin := r.Header.Get("Origin")
+	if h.allowAll {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	} else if h.allowedOrigins[origin] {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Add("Vary", "Origin")
+	}
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+}
@@ -0,0 +1,60 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/mcbile/product-pulse/internal/quality"
+)
+
+// ============================================
+// DATA QUALITY HANDLER
+// ============================================
+
+// DataQualityHandler reports how often incoming events were truncated,
+// stripped or rejected by field size policies, how many were skipped as
+// malformed and how many were rejected as older than the maximum event age
+type DataQualityHandler struct {
+	limits         *quality.Limits
+	allowedOrigins map[string]bool
+	allowAll       bool
+}
+
+func NewDataQualityHandler(limits *quality.Limits, origins []string) *DataQualityHandler {
+	h := &DataQualityHandler{
+		limits:         limits,
+		allowedOrigins: make(map[string]bool),
+	}
+	for _, o := range origins {
+		if o == "*" {
+			h.allowAll = true
+			break
+		}
+		h.allowedOrigins[o] = true
+	}
+	return h
+}
+
+// Handle returns field size policy action, malformed and stale event counts
+// since startup, of the user's sites
+// GET /api/data-quality
+func (h *DataQualityHandler) Handle(w http.ResponseWriter, r *http.Request) {
+	h.setCORS(w, r)
+	w.Header().Set("Content-Type", "application/json")
+
+	scope := siteScope(r)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"field_size": inSiteScope(scope, h.limits.Stats(), func(s quality.Stat) string { return s.SiteID }),
+		"malformed":  inSiteScope(scope, h.limits.MalformedStats(), func(s quality.MalformedStat) string { return s.SiteID }),
+		"too_old":    inSiteScope(scope, h.limits.StaleStats(), func(s quality.StaleStat) string { return s.SiteID }),
+	})
+}
+
+func (h *DataQualityHandler) setCORS(w http.ResponseWriter, r *http.Request) {
+	origin := r.Header.Get("Origin")
+	if h.allowAll {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	} else if h.allowedOrigins[origin] {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+	}
+}
@@ -0,0 +1,248 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/mcbile/product-pulse/internal/storage"
+)
+
+// ============================================
+// DASHBOARD QUERY LOG
+// ============================================
+
+// queryLogQueueSize bounds entries waiting to be written; beyond it
+// entries are dropped rather than slowing down dashboards
+const queryLogQueueSize = 1000
+
+// DashboardOpenRoute is the route whose queries count as opening a saved
+// dashboard in the query stats
+const DashboardOpenRoute = "GET /api/dashboards/{id}"
+
+// QueryLogStorage stores logged queries and reads their statistics
+type QueryLogStorage interface {
+	InsertQueryLog(ctx context.Context, entries []storage.QueryLogEntry) error
+	GetQueryEndpointStats(ctx context.Context, start time.Time) ([]storage.QueryEndpointStats, error)
+	GetDashboardUsage(ctx context.Context, endpoint string, start time.Time) ([]storage.DashboardUsage, error)
+}
+
+type queryEntryKey struct{}
+
+// QueryLog records every dashboard API query (user, route, parameters,
+// status, duration and rows returned) into query_log, to find expensive
+// widgets and unused saved views. Entries are written in the background;
+// if storage falls behind they are dropped.
+type QueryLog struct {
+	storage QueryLogStorage
+	entries chan storage.QueryLogEntry
+	dropped atomic.Int64
+}
+
+// NewQueryLog creates a query log
+func NewQueryLog(store QueryLogStorage) *QueryLog {
+	return &QueryLog{
+		storage: store,
+		entries: make(chan storage.QueryLogEntry, queryLogQueueSize),
+	}
+}
+
+// Start writes queued entries until ctx is cancelled
+func (ql *QueryLog) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+
+		var batch []storage.QueryLogEntry
+		flush := func() {
+			if len(batch) == 0 {
+				return
+			}
+			wctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			if err := ql.storage.InsertQueryLog(wctx, batch); err != nil {
+				slog.Error("failed to store query log", "entries", len(batch), "error", err)
+			}
+			batch = batch[:0]
+		}
+
+		for {
+			select {
+			case e := <-ql.entries:
+				batch = append(batch, e)
+				if len(batch) >= 100 {
+					flush()
+				}
+			case <-ticker.C:
+				flush()
+			case <-ctx.Done():
+				flush()
+				return
+			}
+		}
+	}()
+}
+
+// Wrap logs the queries of the route pattern served by next. It must run
+// inside the auth wrapper so the user is known. A nil QueryLog returns next.
+func (ql *QueryLog) Wrap(pattern string, next http.HandlerFunc) http.HandlerFunc {
+	if ql == nil {
+		return next
+	}
+	var pathParams []string
+	for _, segment := range strings.Split(pattern, "/") {
+		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+			pathParams = append(pathParams, strings.TrimSuffix(strings.Trim(segment, "{}"), "..."))
+		}
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		entry := &storage.QueryLogEntry{Time: start.UTC(), Endpoint: pattern, Params: make(map[string]string)}
+		if user, ok := UserFromContext(r.Context()); ok {
+			entry.User = user.Email
+		}
+		for name, values := range r.URL.Query() {
+			entry.Params[name] = strings.Join(values, ",")
+		}
+		for _, name := range pathParams {
+			entry.Params[name] = r.PathValue(name)
+		}
+
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		next(sw, r.WithContext(context.WithValue(r.Context(), queryEntryKey{}, entry)))
+
+		entry.Status = sw.status
+		entry.DurationMS = float64(time.Since(start).Microseconds()) / 1000
+		select {
+		case ql.entries <- *entry:
+		default:
+			if ql.dropped.Add(1) == 1 {
+				slog.Warn("query log queue full, dropping entries")
+			}
+		}
+	}
+}
+
+// setQueryRows reports how many rows a logged query returned: the length
+// of v if it is a slice, otherwise 1
+func setQueryRows(r *http.Request, v interface{}) {
+	n := 1
+	if rv := reflect.ValueOf(v); rv.Kind() == reflect.Slice {
+		n = rv.Len()
+	}
+	setQueryRowCount(r, n)
+}
+
+// setQueryRowCount reports how many rows a logged query returned, for
+// responses that are streamed
+func setQueryRowCount(r *http.Request, n int) {
+	if entry, ok := r.Context().Value(queryEntryKey{}).(*storage.QueryLogEntry); ok {
+		entry.Rows = &n
+	}
+}
+
+// statusWriter keeps the status of a response
+type statusWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (sw *statusWriter) WriteHeader(code int) {
+	if !sw.wroteHeader {
+		sw.status = code
+		sw.wroteHeader = true
+	}
+	sw.ResponseWriter.WriteHeader(code)
+}
+
+func (sw *statusWriter) Write(p []byte) (int, error) {
+	sw.wroteHeader = true
+	return sw.ResponseWriter.Write(p)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (sw *statusWriter) Unwrap() http.ResponseWriter {
+	return sw.ResponseWriter
+}
+
+// QueryStatsHandler serves usage statistics of the query log (admin)
+type QueryStatsHandler struct {
+	storage        QueryLogStorage
+	allowedOrigins map[string]bool
+	allowAll       bool
+}
+
+func NewQueryStatsHandler(store QueryLogStorage, origins []string) *QueryStatsHandler {
+	h := &QueryStatsHandler{
+		storage:        store,
+		allowedOrigins: make(map[string]bool),
+	}
+	for _, o := range origins {
+		if o == "*" {
+			h.allowAll = true
+			break
+		}
+		h.allowedOrigins[o] = true
+	}
+	return h
+}
+
+// Handle returns per endpoint usage and latency, most expensive first, and
+// the saved dashboards by how often they were opened, least used first
+// GET /api/admin/query-stats?start=2024-01-08T00:00:00Z
+func (h *QueryStatsHandler) Handle(w http.ResponseWriter, r *http.Request) {
+	h.setCORS(w, r)
+
+	start := time.Now().Add(-7 * 24 * time.Hour)
+	if v := r.URL.Query().Get("start"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			http.Error(w, "invalid start", http.StatusBadRequest)
+			return
+		}
+		start = t
+	}
+
+	ctx := r.Context()
+	endpoints, err := h.storage.GetQueryEndpointStats(ctx, start)
+	if err != nil {
+		slog.Error("failed to get query endpoint stats", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	dashboards, err := h.storage.GetDashboardUsage(ctx, DashboardOpenRoute, start)
+	if err != nil {
+		slog.Error("failed to get dashboard usage", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if endpoints == nil {
+		endpoints = []storage.QueryEndpointStats{}
+	}
+	if dashboards == nil {
+		dashboards = []storage.DashboardUsage{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"start":      start.UTC(),
+		"endpoints":  endpoints,
+		"dashboards": dashboards,
+	})
+}
+
+func (h *QueryStatsHandler) setCORS(w http.ResponseWriter, r *http.Request) {
+	origin := r.Header.Get("Origin")
+	if h.allowAll {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	} else if h.allowedOrigins[origin] {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+	}
+}
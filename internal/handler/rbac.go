@@ -0,0 +1,90 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"slices"
+)
+
+// ============================================
+// ROLES AND SITE SCOPES
+// ============================================
+
+// Roles of dashboard users
+const (
+	RoleSuperAdmin = "super_admin" // Everything, including managing admins
+	RoleAdmin      = "admin"       // All sites, manages users, keys and jobs
+	RoleViewer     = "viewer"      // Read-only, all sites
+	RoleClient     = "client"      // Read-only, granted sites only
+)
+
+type rolePermissions struct {
+	admin    bool // May use admin endpoints
+	write    bool // May change dashboard state, e.g. acknowledge alerts
+	allSites bool // Sees all sites without grants
+}
+
+var roles = map[string]rolePermissions{
+	RoleSuperAdmin: {admin: true, write: true, allSites: true},
+	RoleAdmin:      {admin: true, write: true, allSites: true},
+	RoleViewer:     {allSites: true},
+	RoleClient:     {},
+}
+
+// ValidRole reports whether role is a known role
+func ValidRole(role string) bool {
+	_, ok := roles[role]
+	return ok
+}
+
+// HasAllSites reports whether role sees all sites without grants
+func HasAllSites(role string) bool {
+	return roles[role].allSites
+}
+
+type userKey struct{}
+
+// UserFromContext returns the user authenticated by RequireAuth
+func UserFromContext(ctx context.Context) (User, bool) {
+	u, ok := ctx.Value(userKey{}).(User)
+	return u, ok
+}
+
+// siteScope returns the sites the user of r may see: nil for all sites,
+// otherwise the user's granted sites (empty when nothing is granted).
+// Requests to routes without RequireAuth are not restricted.
+func siteScope(r *http.Request) []string {
+	user, ok := UserFromContext(r.Context())
+	if !ok || roles[user.Role].allSites {
+		return nil
+	}
+	if user.Sites == nil {
+		return []string{}
+	}
+	return user.Sites
+}
+
+// requireAllSites answers 403 for users restricted to some sites, on
+// endpoints whose data does not belong to a site
+func requireAllSites(w http.ResponseWriter, r *http.Request) bool {
+	if siteScope(r) != nil {
+		http.Error(w, "not available for site-scoped users", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// inSiteScope keeps the items of the sites in scope, for data filtered in
+// memory rather than by storage; a nil scope keeps everything
+func inSiteScope[T any](scope []string, items []T, site func(T) string) []T {
+	if scope == nil {
+		return items
+	}
+	kept := make([]T, 0, len(items))
+	for _, item := range items {
+		if slices.Contains(scope, site(item)) {
+			kept = append(kept, item)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,121 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/mcbile/product-pulse/internal/collector"
+	"github.com/mcbile/product-pulse/internal/model"
+)
+
+// ============================================
+// READINESS
+// ============================================
+
+// ReadinessConfig makes /ready fail while the collector accepts events it
+// cannot persist, so orchestrators route traffic to other instances
+type ReadinessConfig struct {
+	QueueSaturation float64       // Fraction of queue capacity, 0 disables the check
+	QueueFor        time.Duration // How long a queue must stay above it
+	SpillGrowth     time.Duration // Spill growing over this window fails readiness, 0 disables the check
+}
+
+type spillSample struct {
+	at    time.Time
+	bytes int64
+}
+
+// Readiness samples the collector queues and the spill (Pulse's dead
+// letter queue) every second and reports sustained degradation. Short
+// bursts are absorbed by the queues and do not flip readiness.
+type Readiness struct {
+	config    ReadinessConfig
+	collector *collector.BatchCollector
+	backend   *collector.Backend
+
+	mu             sync.Mutex
+	saturatedSince time.Time     // Zero while queues are below the threshold
+	spill          []spillSample // Oldest first, covering SpillGrowth
+	reason         string        // Empty while ready
+}
+
+// NewReadiness creates a readiness monitor; nil if config enables no check
+func NewReadiness(config ReadinessConfig, c *collector.BatchCollector, backend *collector.Backend) *Readiness {
+	if config.QueueSaturation <= 0 && config.SpillGrowth <= 0 {
+		return nil
+	}
+	return &Readiness{config: config, collector: c, backend: backend}
+}
+
+// Start samples the collectors until ctx is cancelled
+func (rd *Readiness) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case now := <-ticker.C:
+				rd.sample(now)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+func (rd *Readiness) sample(now time.Time) {
+	stats := append([]model.CollectorStats{rd.collector.GetStats()}, backendStats(rd.backend)...)
+	var saturation float64
+	var spilled int64
+	for _, s := range stats {
+		saturation = max(saturation, s.QueueSaturation)
+		spilled += s.SpillBytes
+	}
+
+	rd.mu.Lock()
+	defer rd.mu.Unlock()
+
+	var reason string
+	if rd.config.QueueSaturation > 0 {
+		switch {
+		case saturation < rd.config.QueueSaturation*100:
+			rd.saturatedSince = time.Time{}
+		case rd.saturatedSince.IsZero():
+			rd.saturatedSince = now
+		}
+		if !rd.saturatedSince.IsZero() && now.Sub(rd.saturatedSince) >= rd.config.QueueFor {
+			reason = fmt.Sprintf("queues %.0f%% full for %s", saturation, now.Sub(rd.saturatedSince).Round(time.Second))
+		}
+	}
+
+	if rd.config.SpillGrowth > 0 {
+		rd.spill = append(rd.spill, spillSample{at: now, bytes: spilled})
+		// Keep the newest sample at least SpillGrowth old as the baseline
+		for len(rd.spill) > 1 && now.Sub(rd.spill[1].at) >= rd.config.SpillGrowth {
+			rd.spill = rd.spill[1:]
+		}
+		oldest := rd.spill[0]
+		if reason == "" && now.Sub(oldest.at) >= rd.config.SpillGrowth && spilled > oldest.bytes {
+			reason = fmt.Sprintf("spill grew by %d bytes in %s", spilled-oldest.bytes, rd.config.SpillGrowth)
+		}
+	}
+
+	switch {
+	case reason != "" && rd.reason == "":
+		slog.Warn("collector not ready", "reason", reason)
+	case reason == "" && rd.reason != "":
+		slog.Info("collector ready again")
+	}
+	rd.reason = reason
+}
+
+// Degraded returns why the collector should not receive traffic, or ""
+func (rd *Readiness) Degraded() string {
+	rd.mu.Lock()
+	defer rd.mu.Unlock()
+	return rd.reason
+}
@@ -0,0 +1,80 @@
+package handler
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"slices"
+
+	"github.com/mcbile/product-pulse/internal/recommend"
+)
+
+// ============================================
+// RECOMMENDATIONS HANDLER
+// ============================================
+
+// RecommendationHandler serves sampling and aggregation recommendations
+// from recent ingest volumes
+type RecommendationHandler struct {
+	analyzer       *recommend.Analyzer
+	allowedOrigins map[string]bool
+	allowAll       bool
+}
+
+func NewRecommendationHandler(analyzer *recommend.Analyzer, origins []string) *RecommendationHandler {
+	h := &RecommendationHandler{
+		analyzer:       analyzer,
+		allowedOrigins: make(map[string]bool),
+	}
+	for _, o := range origins {
+		if o == "*" {
+			h.allowAll = true
+			break
+		}
+		h.allowedOrigins[o] = true
+	}
+	return h
+}
+
+// Handle returns the recommendations for the sites the user may see,
+// largest savings first
+// GET /api/recommendations?site=casino-prod
+func (h *RecommendationHandler) Handle(w http.ResponseWriter, r *http.Request) {
+	h.setCORS(w, r)
+
+	recs, generatedAt, err := h.analyzer.Recommend(r.Context())
+	if err != nil {
+		slog.Error("failed to analyze ingest", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	sites := siteScope(r)
+	site := r.URL.Query().Get("site")
+	visible := make([]recommend.Recommendation, 0, len(recs))
+	for _, rec := range recs {
+		if (sites == nil || slices.Contains(sites, rec.SiteID)) && (site == "" || rec.SiteID == site) {
+			visible = append(visible, rec)
+		}
+	}
+	if wantsCSV(r) {
+		writeCSV(w, r, visible)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"window":          h.analyzer.Window().String(),
+		"generated_at":    generatedAt.UTC(),
+		"recommendations": visible,
+	})
+}
+
+func (h *RecommendationHandler) setCORS(w http.ResponseWriter, r *http.Request) {
+	origin := r.Header.Get("Origin")
+	if h.allowAll {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	} else if h.allowedOrigins[origin] {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+	}
+}
@@ -0,0 +1,216 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/mcbile/product-pulse/internal/middleware"
+	"github.com/mcbile/product-pulse/internal/storage"
+)
+
+// ============================================
+// SITE DATA RESIDENCY HANDLER (admin)
+// ============================================
+
+// AuditSiteResidencyChanged is the audit event of a site residency set or
+// removed
+const AuditSiteResidencyChanged = "site_residency_changed"
+
+// Residency actions of this collector for a site's collect requests
+const (
+	ResidencyStore   = "store"
+	ResidencyForward = "forward"
+	ResidencyReject  = "reject"
+)
+
+// ResidencyStorage is the subset of storage used for site residencies
+type ResidencyStorage interface {
+	GetSiteResidencies(ctx context.Context) ([]storage.SiteResidency, error)
+	GetSiteResidency(ctx context.Context, siteID string) (storage.SiteResidency, error)
+	SetSiteResidency(ctx context.Context, r storage.SiteResidency) (storage.SiteResidency, error)
+	DeleteSiteResidency(ctx context.Context, siteID string) (bool, error)
+	InsertAuditEvent(ctx context.Context, e storage.AuditEvent) error
+}
+
+// ResidencyHandler manages which region may store the data of a site.
+// Changes are written to the audit log.
+type ResidencyHandler struct {
+	storage        ResidencyStorage
+	residency      *middleware.Residency
+	allowedOrigins map[string]bool
+	allowAll       bool
+}
+
+func NewResidencyHandler(store ResidencyStorage, residency *middleware.Residency, origins []string) *ResidencyHandler {
+	h := &ResidencyHandler{
+		storage:        store,
+		residency:      residency,
+		allowedOrigins: make(map[string]bool),
+	}
+	for _, o := range origins {
+		if o == "*" {
+			h.allowAll = true
+			break
+		}
+		h.allowedOrigins[o] = true
+	}
+	return h
+}
+
+// siteResidency is a residency with what this collector does with the
+// site's collect requests
+type siteResidency struct {
+	storage.SiteResidency
+	Action string                    `json:"action"` // store, forward or reject
+	Stats  middleware.ResidencyStats `json:"stats"`  // Requests not stored here since startup
+}
+
+func (h *ResidencyHandler) view(r storage.SiteResidency, stats map[string]middleware.ResidencyStats) siteResidency {
+	v := siteResidency{SiteResidency: r, Action: ResidencyReject, Stats: stats[r.SiteID]}
+	switch {
+	case r.Region == h.residency.Region():
+		v.Action = ResidencyStore
+	case h.residency.Forwards(r.Region):
+		v.Action = ResidencyForward
+	}
+	return v
+}
+
+// HandleList returns this collector's region and every site with a
+// residency
+// GET /api/sites/residency
+func (h *ResidencyHandler) HandleList(w http.ResponseWriter, r *http.Request) {
+	h.setCORS(w, r)
+
+	residencies, err := h.storage.GetSiteResidencies(r.Context())
+	if err != nil {
+		slog.Error("failed to query site residencies", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	stats := h.residency.Stats()
+	sites := make([]siteResidency, 0, len(residencies))
+	for _, res := range residencies {
+		sites = append(sites, h.view(res, stats))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"region": h.residency.Region(),
+		"sites":  sites,
+	})
+}
+
+// HandleGet returns the residency of a site
+// GET /api/sites/{site}/residency
+func (h *ResidencyHandler) HandleGet(w http.ResponseWriter, r *http.Request) {
+	h.setCORS(w, r)
+
+	res, err := h.storage.GetSiteResidency(r.Context(), r.PathValue("site"))
+	if errors.Is(err, storage.ErrResidencyNotFound) {
+		http.Error(w, "site has no residency", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		slog.Error("failed to query site residency", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.view(res, h.residency.Stats()))
+}
+
+// HandlePut pins a site's data to a region. It takes effect on this
+// collector at once and on others within their reload interval.
+// PUT /api/sites/{site}/residency {"region": "eu"}
+func (h *ResidencyHandler) HandlePut(w http.ResponseWriter, r *http.Request) {
+	h.setCORS(w, r)
+
+	site := r.PathValue("site")
+	if site == "" || len(site) > 100 {
+		http.Error(w, "site is required and at most 100 characters", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		Region string `json:"region"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+	req.Region = strings.ToLower(strings.TrimSpace(req.Region))
+	if !middleware.ValidRegion(req.Region) {
+		http.Error(w, "region must be lowercase letters, digits and dashes, at most 32 characters", http.StatusBadRequest)
+		return
+	}
+
+	user, _ := UserFromContext(r.Context())
+	res, err := h.storage.SetSiteResidency(r.Context(), storage.SiteResidency{
+		SiteID:    site,
+		Region:    req.Region,
+		UpdatedBy: user.Email,
+	})
+	if err != nil {
+		slog.Error("failed to save site residency", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	h.changed(r, site, req.Region)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.view(res, h.residency.Stats()))
+}
+
+// HandleDelete removes a site's residency, so any region may store its
+// data again
+// DELETE /api/sites/{site}/residency
+func (h *ResidencyHandler) HandleDelete(w http.ResponseWriter, r *http.Request) {
+	h.setCORS(w, r)
+
+	site := r.PathValue("site")
+	deleted, err := h.storage.DeleteSiteResidency(r.Context(), site)
+	if err != nil {
+		slog.Error("failed to delete site residency", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if !deleted {
+		http.Error(w, "site has no residency", http.StatusNotFound)
+		return
+	}
+	h.changed(r, site, "")
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{"status":"deleted"}`))
+}
+
+// changed applies a residency change right away and records it in the
+// audit log; region is empty when the residency was removed
+func (h *ResidencyHandler) changed(r *http.Request, site, region string) {
+	if err := h.residency.Reload(r.Context()); err != nil {
+		slog.Error("failed to reload site residencies", "error", err)
+	}
+
+	user, _ := UserFromContext(r.Context())
+	slog.Info("site residency changed", "site_id", site, "region", region, "by", user.Email)
+	auditChange(r, h.storage, AuditSiteResidencyChanged, map[string]string{
+		"site_id": site,
+		"region":  region,
+	})
+}
+
+func (h *ResidencyHandler) setCORS(w http.ResponseWriter, r *http.Request) {
+	origin := r.Header.Get("Origin")
+	if h.allowAll {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	} else if h.allowedOrigins[origin] {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+	}
+}
@@ -0,0 +1,133 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mcbile/product-pulse/internal/rollup"
+)
+
+// ============================================
+// ROLLUP WATERMARK HANDLER
+// ============================================
+
+// RollupHandler reports rollup watermarks and how much data arrived late,
+// and recomputes rollups for explicit time ranges
+type RollupHandler struct {
+	tracker        *rollup.Tracker
+	recomputer     *rollup.Recomputer
+	allowedOrigins map[string]bool
+	allowAll       bool
+}
+
+func NewRollupHandler(tracker *rollup.Tracker, recomputer *rollup.Recomputer, origins []string) *RollupHandler {
+	h := &RollupHandler{
+		tracker:        tracker,
+		recomputer:     recomputer,
+		allowedOrigins: make(map[string]bool),
+	}
+	for _, o := range origins {
+		if o == "*" {
+			h.allowAll = true
+			break
+		}
+		h.allowedOrigins[o] = true
+	}
+	return h
+}
+
+// Handle returns watermarks and late-data counts per rollup since startup
+// GET /api/rollups
+func (h *RollupHandler) Handle(w http.ResponseWriter, r *http.Request) {
+	h.setCORS(w, r)
+	if !requireAllSites(w, r) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"rollups": h.tracker.Stats(),
+	})
+}
+
+// HandleRecompute starts re-deriving the rollups of a metric family for a
+// time range, e.g. after a backfill or a correction of historical rows.
+// It answers 202 with the recomputation; poll its progress by ID.
+// POST /api/admin/rollups/recompute {"family": "psp", "start": "2024-01-01T00:00:00Z", "end": "2024-01-08T00:00:00Z"}
+func (h *RollupHandler) HandleRecompute(w http.ResponseWriter, r *http.Request) {
+	h.setCORS(w, r)
+
+	var req struct {
+		Family string    `json:"family"`
+		Start  time.Time `json:"start"`
+		End    time.Time `json:"end"` // Optional, defaults to now
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Family == "" {
+		req.Family = rollup.FamilyAll
+	}
+
+	rec, err := h.recomputer.Start(req.Family, req.Start, req.End)
+	switch {
+	case errors.Is(err, rollup.ErrUnknownFamily):
+		http.Error(w, "family must be one of "+strings.Join(rollup.Families(), ", "), http.StatusBadRequest)
+		return
+	case errors.Is(err, rollup.ErrInvalidRange):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, rollup.ErrRecomputeRunning):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		slog.Error("failed to start rollup recomputation", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/api/admin/rollups/recompute/"+rec.ID)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(rec)
+}
+
+// HandleRecomputeList returns the running and recent recomputations
+// GET /api/admin/rollups/recompute
+func (h *RollupHandler) HandleRecomputeList(w http.ResponseWriter, r *http.Request) {
+	h.setCORS(w, r)
+	w.Header().Set("Content-Type", "application/json")
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"recomputations": h.recomputer.List(),
+	})
+}
+
+// HandleRecomputeStatus returns the progress of one recomputation
+// GET /api/admin/rollups/recompute/{id}
+func (h *RollupHandler) HandleRecomputeStatus(w http.ResponseWriter, r *http.Request) {
+	h.setCORS(w, r)
+
+	rec, ok := h.recomputer.Get(r.PathValue("id"))
+	if !ok {
+		http.Error(w, "recomputation not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rec)
+}
+
+func (h *RollupHandler) setCORS(w http.ResponseWriter, r *http.Request) {
+	origin := r.Header.Get("Origin")
+	if h.allowAll {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	} else if h.allowedOrigins[origin] {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+	}
+}
@@ -0,0 +1,120 @@
+package handler
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/mcbile/product-pulse/internal/sdk"
+	"github.com/mcbile/product-pulse/internal/storage"
+)
+
+// ============================================
+// SDK VERSION HANDLER
+// ============================================
+
+// SDKHandler serves the SDK version distribution
+type SDKHandler struct {
+	db             *storage.Postgres
+	policy         sdk.Policy
+	allowedOrigins map[string]bool
+	allowAll       bool
+}
+
+func NewSDKHandler(db *storage.Postgres, policy sdk.Policy, origins []string) *SDKHandler {
+	h := &SDKHandler{
+		db:             db,
+		policy:         policy,
+		allowedOrigins: make(map[string]bool),
+	}
+	for _, o := range origins {
+		if o == "*" {
+			h.allowAll = true
+			break
+		}
+		h.allowedOrigins[o] = true
+	}
+	return h
+}
+
+// HandleVersions returns request counts per SDK version on the user's
+// sites, flagging versions below the configured minimum
+// GET /api/sdk/versions?start=2024-01-15T00:00:00Z (default: last 7 days)
+func (h *SDKHandler) HandleVersions(w http.ResponseWriter, r *http.Request) {
+	h.setCORS(w, r)
+	w.Header().Set("Content-Type", "application/json")
+
+	start := time.Now().AddDate(0, 0, -7)
+	if s := r.URL.Query().Get("start"); s != "" {
+		if t, err := time.Parse(time.RFC3339, s); err == nil {
+			start = t
+		}
+	}
+
+	versions, err := h.db.GetSDKVersions(r.Context(), start, siteScope(r))
+	if err != nil {
+		slog.Error("failed to get sdk versions", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	for i := range versions {
+		_, versions[i].Deprecated = h.policy.Deprecated(versions[i].SDK, versions[i].Version)
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"versions":         versions,
+		"minimum_versions": h.policy,
+	})
+}
+
+// HandleDrift returns the fields that senders sent but the collector does
+// not know, or that the collector expects but senders left out, per SDK
+// version, producer, site and metric type, of the user's sites or of one of
+// them
+// GET /api/sdk/drift?start=2024-01-15T00:00:00Z&site= (default: last 24 hours)
+func (h *SDKHandler) HandleDrift(w http.ResponseWriter, r *http.Request) {
+	h.setCORS(w, r)
+	w.Header().Set("Content-Type", "application/json")
+
+	start := time.Now().Add(-24 * time.Hour)
+	if s := r.URL.Query().Get("start"); s != "" {
+		if t, err := time.Parse(time.RFC3339, s); err == nil {
+			start = t
+		}
+	}
+
+	sites := siteScope(r)
+	if site := r.URL.Query().Get("site"); site != "" {
+		if sites != nil && !slices.Contains(sites, site) {
+			http.Error(w, "site must be one of your sites", http.StatusForbidden)
+			return
+		}
+		sites = []string{site}
+	}
+
+	drift, err := h.db.GetSchemaDrift(r.Context(), start, sites)
+	if err != nil {
+		slog.Error("failed to get schema drift", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if drift == nil {
+		drift = []storage.SchemaDriftRow{}
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"drift": drift,
+	})
+}
+
+func (h *SDKHandler) setCORS(w http.ResponseWriter, r *http.Request) {
+	origin := r.Header.Get("Origin")
+	if h.allowAll {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	} else if h.allowedOrigins[origin] {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+	}
+}
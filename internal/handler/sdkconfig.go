@@ -0,0 +1,356 @@
+package handler
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mcbile/product-pulse/internal/middleware"
+	"github.com/mcbile/product-pulse/internal/storage"
+)
+
+// ============================================
+// SDK REMOTE CONFIG HANDLER
+// ============================================
+
+// AuditSDKConfigChanged is the audit event of a site's SDK config set or
+// removed
+const AuditSDKConfigChanged = "sdk_config_changed"
+
+// SDKConfigTypes are the types a site's SDK config may disable: frontend
+// event types of the browser SDK and metric types of the Go client
+var SDKConfigTypes = append(slices.Clone(middleware.FrontendEventTypes), "api", "psp", "game", "ws")
+
+// SDKConfigStorage is the subset of storage used for SDK configs
+type SDKConfigStorage interface {
+	GetSDKConfigs(ctx context.Context) ([]storage.SDKConfig, error)
+	GetSDKConfig(ctx context.Context, siteID string) (storage.SDKConfig, error)
+	SetSDKConfig(ctx context.Context, c storage.SDKConfig) (storage.SDKConfig, error)
+	DeleteSDKConfig(ctx context.Context, siteID string) (bool, error)
+	InsertAuditEvent(ctx context.Context, e storage.AuditEvent) error
+}
+
+// SDKConfigHandler serves per-site runtime directives to SDKs and Go
+// clients, which poll GET /collect/config, and lets admins change them.
+// Kill switches are added to the directives, so clients stop sending what
+// the collector drops anyway. The whole fleet polls, so configs are served
+// from a cache reloaded periodically. Changes are written to the audit log.
+type SDKConfigHandler struct {
+	storage        SDKConfigStorage
+	kills          *middleware.KillSwitches
+	pollInterval   time.Duration
+	allowedOrigins map[string]bool
+	allowAll       bool
+
+	mu     sync.RWMutex
+	bySite map[string]storage.SDKConfig
+}
+
+func NewSDKConfigHandler(store SDKConfigStorage, kills *middleware.KillSwitches, pollInterval time.Duration, origins []string) *SDKConfigHandler {
+	h := &SDKConfigHandler{
+		storage:        store,
+		kills:          kills,
+		pollInterval:   pollInterval,
+		allowedOrigins: make(map[string]bool),
+		bySite:         make(map[string]storage.SDKConfig),
+	}
+	for _, o := range origins {
+		if o == "*" {
+			h.allowAll = true
+			break
+		}
+		h.allowedOrigins[o] = true
+	}
+	return h
+}
+
+// Start loads the configs, then reloads them every interval until ctx is
+// cancelled
+func (h *SDKConfigHandler) Start(ctx context.Context, interval time.Duration) error {
+	if err := h.Reload(ctx); err != nil {
+		return err
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if err := h.Reload(ctx); err != nil {
+					slog.Error("failed to reload sdk configs", "error", err)
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// Reload replaces the cached configs with those in storage
+func (h *SDKConfigHandler) Reload(ctx context.Context) error {
+	configs, err := h.storage.GetSDKConfigs(ctx)
+	if err != nil {
+		return err
+	}
+
+	bySite := make(map[string]storage.SDKConfig, len(configs))
+	for _, c := range configs {
+		bySite[c.SiteID] = c
+	}
+
+	h.mu.Lock()
+	h.bySite = bySite
+	h.mu.Unlock()
+	return nil
+}
+
+// sdkDirectives is what clients fetch from /collect/config
+type sdkDirectives struct {
+	SampleRate    float64  `json:"sample_rate"`
+	DisabledTypes []string `json:"disabled_types"`
+	Endpoint      string   `json:"endpoint,omitempty"`
+	Disabled      bool     `json:"disabled"`
+	PollInterval  int      `json:"poll_interval"` // Seconds until the next poll
+}
+
+// HandleCollect returns the runtime directives of the site in X-Site-Id,
+// or ?site= for browsers, which avoids a CORS preflight. Sites without a
+// config get the defaults: everything sent, nothing changed. Kill switches
+// disable their types, or the site for one covering all. The response
+// has an ETag, so unchanged polls get a 304.
+// GET /collect/config
+func (h *SDKConfigHandler) HandleCollect(w http.ResponseWriter, r *http.Request) {
+	h.setCORS(w, r)
+
+	site := r.Header.Get("X-Site-Id")
+	if site == "" {
+		site = r.URL.Query().Get("site")
+	}
+
+	d := sdkDirectives{
+		SampleRate:    1,
+		DisabledTypes: []string{},
+		PollInterval:  int(h.pollInterval.Seconds()),
+	}
+	h.mu.RLock()
+	c, ok := h.bySite[site]
+	h.mu.RUnlock()
+	if ok {
+		d.SampleRate, d.DisabledTypes, d.Endpoint, d.Disabled = c.SampleRate, slices.Clone(c.DisabledTypes), c.Endpoint, c.Disabled
+	}
+	for _, t := range h.kills.Types(site) {
+		switch t {
+		case middleware.KillAll:
+			d.Disabled = true
+		case "frontend":
+			d.DisabledTypes = appendMissing(d.DisabledTypes, middleware.FrontendEventTypes...)
+		default:
+			d.DisabledTypes = appendMissing(d.DisabledTypes, t)
+		}
+	}
+
+	body, err := json.Marshal(d)
+	if err != nil {
+		slog.Error("failed to encode sdk config", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	body = append(body, '\n')
+
+	sum := sha256.Sum256(body)
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "no-cache")
+	if etagMatch(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Write(body)
+}
+
+// HandleList returns the SDK configs of every site (admin)
+// GET /api/sites/sdk-config
+func (h *SDKConfigHandler) HandleList(w http.ResponseWriter, r *http.Request) {
+	h.setCORS(w, r)
+
+	configs, err := h.storage.GetSDKConfigs(r.Context())
+	if err != nil {
+		slog.Error("failed to query sdk configs", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if configs == nil {
+		configs = []storage.SDKConfig{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"poll_interval": int(h.pollInterval.Seconds()),
+		"sites":         configs,
+	})
+}
+
+// HandleGet returns the SDK config of a site (admin)
+// GET /api/sites/{site}/sdk-config
+func (h *SDKConfigHandler) HandleGet(w http.ResponseWriter, r *http.Request) {
+	h.setCORS(w, r)
+
+	c, err := h.storage.GetSDKConfig(r.Context(), r.PathValue("site"))
+	if errors.Is(err, storage.ErrSDKConfigNotFound) {
+		http.Error(w, "site has no sdk config", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		slog.Error("failed to query sdk config", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(c)
+}
+
+type sdkConfigRequest struct {
+	SampleRate    *float64 `json:"sample_rate"` // Default 1
+	DisabledTypes []string `json:"disabled_types"`
+	Endpoint      string   `json:"endpoint"`
+	Disabled      bool     `json:"disabled"`
+}
+
+// HandlePut replaces the SDK config of a site (admin). Clients pick it up
+// on their next poll.
+// PUT /api/sites/{site}/sdk-config {"sample_rate": 0.25, "disabled_types": ["interaction"], "disabled": false}
+func (h *SDKConfigHandler) HandlePut(w http.ResponseWriter, r *http.Request) {
+	h.setCORS(w, r)
+
+	site := r.PathValue("site")
+	if site == "" || len(site) > 100 {
+		http.Error(w, "site is required and at most 100 characters", http.StatusBadRequest)
+		return
+	}
+
+	var req sdkConfigRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+	sampleRate := 1.0
+	if req.SampleRate != nil {
+		sampleRate = *req.SampleRate
+	}
+	if sampleRate < 0 || sampleRate > 1 {
+		http.Error(w, "sample_rate must be between 0 and 1", http.StatusBadRequest)
+		return
+	}
+	types := make([]string, 0, len(req.DisabledTypes))
+	for _, t := range req.DisabledTypes {
+		if !slices.Contains(SDKConfigTypes, t) {
+			http.Error(w, "disabled_types must be among "+strings.Join(SDKConfigTypes, ", "), http.StatusBadRequest)
+			return
+		}
+		if !slices.Contains(types, t) {
+			types = append(types, t)
+		}
+	}
+	if req.Endpoint != "" {
+		u, err := url.Parse(req.Endpoint)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" || u.RawQuery != "" {
+			http.Error(w, "endpoint must be the http(s) base URL of a collector", http.StatusBadRequest)
+			return
+		}
+		req.Endpoint = strings.TrimSuffix(req.Endpoint, "/")
+	}
+
+	user, _ := UserFromContext(r.Context())
+	c, err := h.storage.SetSDKConfig(r.Context(), storage.SDKConfig{
+		SiteID:        site,
+		SampleRate:    sampleRate,
+		DisabledTypes: types,
+		Endpoint:      req.Endpoint,
+		Disabled:      req.Disabled,
+		UpdatedBy:     user.Email,
+	})
+	if err != nil {
+		slog.Error("failed to save sdk config", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	h.changed(r, site, map[string]string{
+		"site_id":        site,
+		"sample_rate":    strconv.FormatFloat(sampleRate, 'f', -1, 64),
+		"disabled_types": strings.Join(types, ","),
+		"endpoint":       req.Endpoint,
+		"disabled":       strconv.FormatBool(req.Disabled),
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(c)
+}
+
+// HandleDelete removes the SDK config of a site (admin), so its clients
+// fall back to their own settings
+// DELETE /api/sites/{site}/sdk-config
+func (h *SDKConfigHandler) HandleDelete(w http.ResponseWriter, r *http.Request) {
+	h.setCORS(w, r)
+
+	site := r.PathValue("site")
+	deleted, err := h.storage.DeleteSDKConfig(r.Context(), site)
+	if err != nil {
+		slog.Error("failed to delete sdk config", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if !deleted {
+		http.Error(w, "site has no sdk config", http.StatusNotFound)
+		return
+	}
+	h.changed(r, site, map[string]string{"site_id": site, "deleted": "true"})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{"status":"deleted"}`))
+}
+
+// changed serves a config change right away and records it in the audit
+// log
+func (h *SDKConfigHandler) changed(r *http.Request, site string, detail map[string]string) {
+	if err := h.Reload(r.Context()); err != nil {
+		slog.Error("failed to reload sdk configs", "error", err)
+	}
+
+	user, _ := UserFromContext(r.Context())
+	slog.Info("sdk config changed", "site_id", site, "by", user.Email)
+	auditChange(r, h.storage, AuditSDKConfigChanged, detail)
+}
+
+// appendMissing appends the values not yet in s
+func appendMissing(s []string, values ...string) []string {
+	for _, v := range values {
+		if !slices.Contains(s, v) {
+			s = append(s, v)
+		}
+	}
+	return s
+}
+
+func (h *SDKConfigHandler) setCORS(w http.ResponseWriter, r *http.Request) {
+	origin := r.Header.Get("Origin")
+	if h.allowAll {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	} else if h.allowedOrigins[origin] {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+	}
+}
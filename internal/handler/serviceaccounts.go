@@ -0,0 +1,233 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/mcbile/product-pulse/internal/middleware"
+	"github.com/mcbile/product-pulse/internal/storage"
+)
+
+// ============================================
+// SERVICE ACCOUNTS HANDLER (admin)
+// ============================================
+
+// ServiceAccountStorage is the subset of storage used for service accounts
+type ServiceAccountStorage interface {
+	CreateServiceAccount(ctx context.Context, account storage.ServiceAccount) (storage.ServiceAccount, error)
+	GetServiceAccounts(ctx context.Context) ([]storage.ServiceAccount, error)
+	SetServiceAccountScopes(ctx context.Context, id int64, scopes []string) (bool, error)
+	RevokeServiceAccount(ctx context.Context, id int64) (bool, error)
+}
+
+// ServiceAccountHandler manages service accounts: tokens for internal
+// services that may only use the collect endpoints in their scopes
+type ServiceAccountHandler struct {
+	storage        ServiceAccountStorage
+	auth           *middleware.SiteAuth
+	allowedOrigins map[string]bool
+	allowAll       bool
+}
+
+func NewServiceAccountHandler(store ServiceAccountStorage, auth *middleware.SiteAuth, origins []string) *ServiceAccountHandler {
+	h := &ServiceAccountHandler{
+		storage:        store,
+		auth:           auth,
+		allowedOrigins: make(map[string]bool),
+	}
+	for _, o := range origins {
+		if o == "*" {
+			h.allowAll = true
+			break
+		}
+		h.allowedOrigins[o] = true
+	}
+	return h
+}
+
+type serviceAccountRequest struct {
+	Name   string   `json:"name"`
+	SiteID string   `json:"site_id"` // Optional, binds the account to one site
+	Scopes []string `json:"scopes"`
+}
+
+// HandleCreate creates a service account. The token is only returned in
+// this response.
+// POST /api/service-accounts
+func (h *ServiceAccountHandler) HandleCreate(w http.ResponseWriter, r *http.Request) {
+	h.setCORS(w, r)
+
+	var req serviceAccountRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if req.Name == "" {
+		http.Error(w, "name is required", http.StatusBadRequest)
+		return
+	}
+	if !validScopes(w, req.Scopes) {
+		return
+	}
+
+	token, prefix, hash, err := middleware.NewServiceAccountToken()
+	if err != nil {
+		slog.Error("failed to generate service account token", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	account, err := h.storage.CreateServiceAccount(r.Context(), storage.ServiceAccount{
+		Name:      req.Name,
+		SiteID:    req.SiteID,
+		Scopes:    req.Scopes,
+		Prefix:    prefix,
+		TokenHash: hash,
+	})
+	if errors.Is(err, storage.ErrServiceAccountExists) {
+		http.Error(w, "service account already exists", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		slog.Error("failed to create service account", "name", req.Name, "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	h.reload(r.Context())
+
+	slog.Info("service account created", "name", account.Name, "scopes", account.Scopes)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"service_account": account,
+		"token":           token,
+	})
+}
+
+// HandleList returns all service accounts with their usage
+// GET /api/service-accounts
+func (h *ServiceAccountHandler) HandleList(w http.ResponseWriter, r *http.Request) {
+	h.setCORS(w, r)
+
+	accounts, err := h.storage.GetServiceAccounts(r.Context())
+	if err != nil {
+		slog.Error("failed to list service accounts", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"service_accounts": accounts,
+	})
+}
+
+// HandleUpdateScopes replaces the scopes of a service account
+// PUT /api/service-accounts/{id}/scopes
+func (h *ServiceAccountHandler) HandleUpdateScopes(w http.ResponseWriter, r *http.Request) {
+	h.setCORS(w, r)
+
+	id, ok := parseAccountID(w, r)
+	if !ok {
+		return
+	}
+
+	var req serviceAccountRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if !validScopes(w, req.Scopes) {
+		return
+	}
+
+	updated, err := h.storage.SetServiceAccountScopes(r.Context(), id, req.Scopes)
+	if err != nil {
+		slog.Error("failed to update service account", "id", id, "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if !updated {
+		http.Error(w, "service account not found", http.StatusNotFound)
+		return
+	}
+	h.reload(r.Context())
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": "ok",
+		"scopes": req.Scopes,
+	})
+}
+
+// HandleRevoke revokes a service account immediately
+// DELETE /api/service-accounts/{id}
+func (h *ServiceAccountHandler) HandleRevoke(w http.ResponseWriter, r *http.Request) {
+	h.setCORS(w, r)
+
+	id, ok := parseAccountID(w, r)
+	if !ok {
+		return
+	}
+
+	revoked, err := h.storage.RevokeServiceAccount(r.Context(), id)
+	if err != nil {
+		slog.Error("failed to revoke service account", "id", id, "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if !revoked {
+		http.Error(w, "service account not found", http.StatusNotFound)
+		return
+	}
+	h.reload(r.Context())
+
+	slog.Info("service account revoked", "id", id)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{"status":"revoked"}`))
+}
+
+func parseAccountID(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid service account id", http.StatusBadRequest)
+		return 0, false
+	}
+	return id, true
+}
+
+func validScopes(w http.ResponseWriter, scopes []string) bool {
+	if len(scopes) == 0 {
+		http.Error(w, "at least one scope is required", http.StatusBadRequest)
+		return false
+	}
+	for _, s := range scopes {
+		if !middleware.ValidServiceAccountScope(s) {
+			http.Error(w, "unknown scope "+strconv.Quote(s), http.StatusBadRequest)
+			return false
+		}
+	}
+	return true
+}
+
+// reload applies a change right away instead of on the next refresh
+func (h *ServiceAccountHandler) reload(ctx context.Context) {
+	if err := h.auth.Reload(ctx); err != nil {
+		slog.Error("failed to reload service accounts", "error", err)
+	}
+}
+
+func (h *ServiceAccountHandler) setCORS(w http.ResponseWriter, r *http.Request) {
+	origin := r.Header.Get("Origin")
+	if h.allowAll {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	} else if h.allowedOrigins[origin] {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+	}
+}
@@ -0,0 +1,56 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/mcbile/product-pulse/internal/shadow"
+)
+
+// ============================================
+// SHADOW STORAGE HANDLER (admin)
+// ============================================
+
+// ShadowHandler reports how a candidate storage backend compares with the
+// primary while shadow writes are enabled
+type ShadowHandler struct {
+	writer         *shadow.Writer
+	allowedOrigins map[string]bool
+	allowAll       bool
+}
+
+func NewShadowHandler(writer *shadow.Writer, origins []string) *ShadowHandler {
+	h := &ShadowHandler{
+		writer:         writer,
+		allowedOrigins: make(map[string]bool),
+	}
+	for _, o := range origins {
+		if o == "*" {
+			h.allowAll = true
+			break
+		}
+		h.allowedOrigins[o] = true
+	}
+	return h
+}
+
+// Handle returns write latencies, errors and the last row count comparison
+// per table
+// GET /api/shadow
+func (h *ShadowHandler) Handle(w http.ResponseWriter, r *http.Request) {
+	h.setCORS(w, r)
+	w.Header().Set("Content-Type", "application/json")
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"tables": h.writer.Stats(),
+	})
+}
+
+func (h *ShadowHandler) setCORS(w http.ResponseWriter, r *http.Request) {
+	origin := r.Header.Get("Origin")
+	if h.allowAll {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	} else if h.allowedOrigins[origin] {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+	}
+}
@@ -0,0 +1,233 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/mcbile/product-pulse/internal/slo"
+	"github.com/mcbile/product-pulse/internal/storage"
+)
+
+// ============================================
+// SLO HANDLER
+// ============================================
+
+const (
+	defaultSLOWindowDays = 30
+	maxSLOWindowDays     = 90
+)
+
+// SLOStorage is the subset of storage used for service level objectives
+type SLOStorage interface {
+	GetSLOs(ctx context.Context) ([]storage.SLO, error)
+	CreateSLO(ctx context.Context, s storage.SLO) (storage.SLO, error)
+	UpdateSLO(ctx context.Context, s storage.SLO) (storage.SLO, error)
+	DeleteSLO(ctx context.Context, name string) (bool, error)
+}
+
+// SLOHandler serves service level objectives with their error budgets, and
+// lets admins define them
+type SLOHandler struct {
+	storage        SLOStorage
+	allowedOrigins map[string]bool
+	allowAll       bool
+}
+
+func NewSLOHandler(store SLOStorage, origins []string) *SLOHandler {
+	h := &SLOHandler{
+		storage:        store,
+		allowedOrigins: make(map[string]bool),
+	}
+	for _, o := range origins {
+		if o == "*" {
+			h.allowAll = true
+			break
+		}
+		h.allowedOrigins[o] = true
+	}
+	return h
+}
+
+// HandleList returns all objectives with attainment, remaining error
+// budget and burn rate as of their last evaluation
+// GET /api/slo
+func (h *SLOHandler) HandleList(w http.ResponseWriter, r *http.Request) {
+	h.setCORS(w, r)
+	// Objectives cover all sites
+	if !requireAllSites(w, r) {
+		return
+	}
+
+	slos, err := h.storage.GetSLOs(r.Context())
+	if err != nil {
+		slog.Error("failed to list slos", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	statuses := make([]slo.Status, 0, len(slos))
+	for _, s := range slos {
+		statuses = append(statuses, slo.Summarize(s))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"slos": statuses,
+	})
+}
+
+type sloRequest struct {
+	Name        string  `json:"name"`
+	SLI         string  `json:"sli"`
+	Target      string  `json:"target"`
+	Objective   float64 `json:"objective"`   // Good events (%), e.g. 99.5
+	WindowDays  int     `json:"window_days"` // Default 30
+	Description string  `json:"description"`
+}
+
+// validate checks the request and fills in defaults; it answers 400 and
+// returns false for invalid requests
+func (req *sloRequest) validate(w http.ResponseWriter) bool {
+	if _, ok := storage.SLIs[req.SLI]; !ok {
+		http.Error(w, "sli must be one of "+strings.Join(sliNames(), ", "), http.StatusBadRequest)
+		return false
+	}
+	if req.Objective <= 0 || req.Objective >= 100 {
+		http.Error(w, "objective must be a percentage between 0 and 100", http.StatusBadRequest)
+		return false
+	}
+	if req.WindowDays == 0 {
+		req.WindowDays = defaultSLOWindowDays
+	}
+	if req.WindowDays < 1 || req.WindowDays > maxSLOWindowDays {
+		http.Error(w, "window_days must be between 1 and 90", http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+func sliNames() []string {
+	names := make([]string, 0, len(storage.SLIs))
+	for name := range storage.SLIs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// HandleCreate defines a new objective
+// POST /api/slo
+func (h *SLOHandler) HandleCreate(w http.ResponseWriter, r *http.Request) {
+	h.setCORS(w, r)
+
+	var req sloRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if req.Name == "" {
+		http.Error(w, "name is required", http.StatusBadRequest)
+		return
+	}
+	if !req.validate(w) {
+		return
+	}
+
+	user, _ := UserFromContext(r.Context())
+	created, err := h.storage.CreateSLO(r.Context(), storage.SLO{
+		Name:        req.Name,
+		SLI:         req.SLI,
+		Target:      req.Target,
+		Objective:   req.Objective,
+		WindowDays:  req.WindowDays,
+		Description: req.Description,
+		CreatedBy:   user.Email,
+	})
+	if errors.Is(err, storage.ErrSLOExists) {
+		http.Error(w, "slo already exists", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		slog.Error("failed to create slo", "name", req.Name, "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	slog.Info("slo created", "name", created.Name, "sli", created.SLI, "objective", created.Objective)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(slo.Summarize(created))
+}
+
+// HandleUpdate replaces the definition of an objective
+// PUT /api/slo/{name}
+func (h *SLOHandler) HandleUpdate(w http.ResponseWriter, r *http.Request) {
+	h.setCORS(w, r)
+
+	var req sloRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if !req.validate(w) {
+		return
+	}
+
+	name := r.PathValue("name")
+	updated, err := h.storage.UpdateSLO(r.Context(), storage.SLO{
+		Name:        name,
+		SLI:         req.SLI,
+		Target:      req.Target,
+		Objective:   req.Objective,
+		WindowDays:  req.WindowDays,
+		Description: req.Description,
+	})
+	if errors.Is(err, storage.ErrSLONotFound) {
+		http.Error(w, "slo not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		slog.Error("failed to update slo", "name", name, "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(slo.Summarize(updated))
+}
+
+// HandleDelete removes an objective
+// DELETE /api/slo/{name}
+func (h *SLOHandler) HandleDelete(w http.ResponseWriter, r *http.Request) {
+	h.setCORS(w, r)
+
+	name := r.PathValue("name")
+	deleted, err := h.storage.DeleteSLO(r.Context(), name)
+	if err != nil {
+		slog.Error("failed to delete slo", "name", name, "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if !deleted {
+		http.Error(w, "slo not found", http.StatusNotFound)
+		return
+	}
+
+	slog.Info("slo deleted", "name", name)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *SLOHandler) setCORS(w http.ResponseWriter, r *http.Request) {
+	origin := r.Header.Get("Origin")
+	if h.allowAll {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	} else if h.allowedOrigins[origin] {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+	}
+}
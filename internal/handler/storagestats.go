@@ -0,0 +1,99 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/mcbile/product-pulse/internal/storage"
+)
+
+// ============================================
+// STORAGE STATS HANDLER (admin)
+// ============================================
+
+// maxStatsRange bounds the exact row counts of a stats request
+const maxStatsRange = 31 * 24 * time.Hour
+
+// StorageStatsStore is the subset of storage used for capacity statistics
+type StorageStatsStore interface {
+	GetStorageStats(ctx context.Context, start, end time.Time) (storage.StorageStats, error)
+}
+
+// StorageStatsHandler reports table sizes, row counts, chunk health and
+// rollup freshness, so capacity planning does not need database access
+type StorageStatsHandler struct {
+	store          StorageStatsStore
+	allowedOrigins map[string]bool
+	allowAll       bool
+}
+
+func NewStorageStatsHandler(store StorageStatsStore, origins []string) *StorageStatsHandler {
+	h := &StorageStatsHandler{
+		store:          store,
+		allowedOrigins: make(map[string]bool),
+	}
+	for _, o := range origins {
+		if o == "*" {
+			h.allowAll = true
+			break
+		}
+		h.allowedOrigins[o] = true
+	}
+	return h
+}
+
+// Handle returns storage statistics. start and end (RFC3339, default the
+// last 24 hours, at most 31 days apart) bound the exact row counts.
+// GET /api/admin/storage/stats
+func (h *StorageStatsHandler) Handle(w http.ResponseWriter, r *http.Request) {
+	h.setCORS(w, r)
+	w.Header().Set("Content-Type", "application/json")
+
+	end := time.Now()
+	if s := r.URL.Query().Get("end"); s != "" {
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			http.Error(w, "invalid end", http.StatusBadRequest)
+			return
+		}
+		end = t
+	}
+	start := end.Add(-24 * time.Hour)
+	if s := r.URL.Query().Get("start"); s != "" {
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			http.Error(w, "invalid start", http.StatusBadRequest)
+			return
+		}
+		start = t
+	}
+	if !start.Before(end) {
+		http.Error(w, "start must be before end", http.StatusBadRequest)
+		return
+	}
+	if end.Sub(start) > maxStatsRange {
+		http.Error(w, "range must not exceed 31 days", http.StatusBadRequest)
+		return
+	}
+
+	stats, err := h.store.GetStorageStats(r.Context(), start, end)
+	if err != nil {
+		slog.Error("failed to get storage stats", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(stats)
+}
+
+func (h *StorageStatsHandler) setCORS(w http.ResponseWriter, r *http.Request) {
+	origin := r.Header.Get("Origin")
+	if h.allowAll {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	} else if h.allowedOrigins[origin] {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+	}
+}
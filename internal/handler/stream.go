@@ -0,0 +1,195 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mcbile/product-pulse/internal/storage"
+)
+
+// ============================================
+// LIVE STREAM HANDLER
+// ============================================
+
+const (
+	// streamSessionWindow is how recent a frontend event must be for its
+	// session to count as active
+	streamSessionWindow = 5 * time.Minute
+
+	// maxStreamDuration ends streams so clients reconnect with a fresh
+	// access token; EventSource reconnects on its own
+	maxStreamDuration = 30 * time.Minute
+)
+
+// LiveStatsReader is the storage used by the live stream
+type LiveStatsReader interface {
+	GetLiveStats(ctx context.Context, now time.Time, window, sessionWindow time.Duration, sites []string) (storage.LiveStats, error)
+}
+
+type cachedLiveStats struct {
+	stats storage.LiveStats
+	at    time.Time
+}
+
+// StreamHandler pushes rolling aggregates to wall dashboards over
+// Server-Sent Events. Streams of the same site scope share one query per
+// interval, so many open dashboards cost no more than one.
+type StreamHandler struct {
+	db             LiveStatsReader
+	interval       time.Duration
+	window         time.Duration
+	allowedOrigins map[string]bool
+	allowAll       bool
+
+	mu    sync.Mutex
+	cache map[string]cachedLiveStats // By site scope
+
+	closing   chan struct{}
+	closeOnce sync.Once
+}
+
+func NewStreamHandler(db LiveStatsReader, interval, window time.Duration, origins []string) *StreamHandler {
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	if window <= 0 {
+		window = time.Minute
+	}
+	h := &StreamHandler{
+		db:             db,
+		interval:       interval,
+		window:         window,
+		allowedOrigins: make(map[string]bool),
+		cache:          make(map[string]cachedLiveStats),
+		closing:        make(chan struct{}),
+	}
+	for _, o := range origins {
+		if o == "*" {
+			h.allowAll = true
+			break
+		}
+		h.allowedOrigins[o] = true
+	}
+	return h
+}
+
+// Handle streams requests/sec, API error rate, PSP success rate and active
+// sessions as "stats" events every interval
+// GET /api/stream
+func (h *StreamHandler) Handle(w http.ResponseWriter, r *http.Request) {
+	h.setCORS(w, r)
+
+	rc := http.NewResponseController(w)
+	// The server's write timeout is meant for ordinary requests
+	if err := rc.SetWriteDeadline(time.Now().Add(maxStreamDuration + time.Minute)); err != nil {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: %d\n\n", h.interval.Milliseconds())
+
+	ctx, cancel := context.WithTimeout(r.Context(), maxStreamDuration)
+	defer cancel()
+
+	sites := siteScope(r)
+	ticker := time.NewTicker(h.interval)
+	defer ticker.Stop()
+
+	for {
+		if err := h.send(ctx, w, sites); err != nil {
+			slog.Debug("live stream closed", "error", err)
+			return
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		case <-h.closing:
+			return
+		}
+	}
+}
+
+// Close ends open streams; clients reconnect to another collector. Register
+// it with http.Server.RegisterOnShutdown, as Shutdown does not cancel
+// request contexts and would wait for streams until its deadline.
+func (h *StreamHandler) Close() {
+	h.closeOnce.Do(func() { close(h.closing) })
+}
+
+// send writes one event; it fails only when the client is gone
+func (h *StreamHandler) send(ctx context.Context, w http.ResponseWriter, sites []string) error {
+	stats, err := h.stats(ctx, sites)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		slog.Error("failed to get live stats", "error", err)
+		_, err = fmt.Fprint(w, "event: error\ndata: {\"error\":\"query failed\"}\n\n")
+		return err
+	}
+
+	data, err := json.Marshal(stats)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: stats\nid: %d\ndata: %s\n\n", stats.Time.UnixMilli(), data)
+	return err
+}
+
+// stats returns the live stats of a site scope, reusing a result younger
+// than half the interval: fresh for every tick of one stream, shared by
+// streams ticking at about the same time
+func (h *StreamHandler) stats(ctx context.Context, sites []string) (storage.LiveStats, error) {
+	key := "*"
+	if sites != nil {
+		key = strings.Join(sites, ",")
+	}
+
+	now := time.Now()
+	maxAge := h.interval / 2
+	h.mu.Lock()
+	cached, ok := h.cache[key]
+	h.mu.Unlock()
+	if ok && now.Sub(cached.at) < maxAge {
+		return cached.stats, nil
+	}
+
+	stats, err := h.db.GetLiveStats(ctx, now.UTC(), h.window, streamSessionWindow, sites)
+	if err != nil {
+		return storage.LiveStats{}, err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for k, c := range h.cache {
+		if now.Sub(c.at) >= maxAge {
+			delete(h.cache, k)
+		}
+	}
+	h.cache[key] = cachedLiveStats{stats: stats, at: now}
+	return stats, nil
+}
+
+func (h *StreamHandler) setCORS(w http.ResponseWriter, r *http.Request) {
+	origin := r.Header.Get("Origin")
+	if h.allowAll {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	} else if h.allowedOrigins[origin] {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+	}
+}
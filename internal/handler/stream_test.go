@@ -0,0 +1,94 @@
+package handler
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mcbile/product-pulse/internal/storage"
+)
+
+type staticLiveStats struct{}
+
+func (staticLiveStats) GetLiveStats(ctx context.Context, now time.Time, window, sessionWindow time.Duration, sites []string) (storage.LiveStats, error) {
+	return storage.LiveStats{Time: now}, nil
+}
+
+func TestStreamEndsOnClose(t *testing.T) {
+	h := NewStreamHandler(staticLiveStats{}, time.Hour, time.Minute, nil)
+	srv := httptest.NewServer(http.HandlerFunc(h.Handle))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	// Wait for the first event, so the handler is in its loop
+	body := bufio.NewReader(resp.Body)
+	for {
+		line, err := body.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(line) > 5 && line[:5] == "data:" {
+			break
+		}
+	}
+
+	h.Close()
+	done := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(io.Discard, body)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("stream ended with %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("stream still open after Close")
+	}
+}
+
+type emptyAlertFeed struct{}
+
+func (emptyAlertFeed) GetAlertsAfter(ctx context.Context, cursor int64, limit int) ([]storage.AlertRow, error) {
+	return nil, nil
+}
+
+func (emptyAlertFeed) GetLatestAlertID(ctx context.Context) (int64, error) { return 42, nil }
+
+func TestAlertPollAnswersOnClose(t *testing.T) {
+	h := NewAlertStreamHandler(emptyAlertFeed{}, nil)
+	srv := httptest.NewServer(http.HandlerFunc(h.Handle))
+	defer srv.Close()
+
+	time.AfterFunc(100*time.Millisecond, h.Close)
+	start := time.Now()
+	resp, err := http.Get(srv.URL + "?cursor=42&wait=1m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if waited := time.Since(start); waited > 10*time.Second {
+		t.Fatalf("poll answered after %s", waited)
+	}
+
+	var got struct {
+		Alerts []storage.AlertRow `json:"alerts"`
+		Cursor int64              `json:"cursor"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || len(got.Alerts) != 0 || got.Cursor != 42 {
+		t.Errorf("got %d %+v, want no alerts and the same cursor", resp.StatusCode, got)
+	}
+}
@@ -0,0 +1,132 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/mcbile/product-pulse/internal/storage"
+)
+
+// ============================================
+// EMAIL SUBSCRIPTION HANDLER
+// ============================================
+
+// defaultEmailSeverity is the minimum severity of new subscriptions
+const defaultEmailSeverity = "warning"
+
+// SubscriptionStorage is the subset of storage used for email subscriptions
+type SubscriptionStorage interface {
+	GetEmailSubscription(ctx context.Context, email string) (storage.EmailSubscription, error)
+	SetEmailSubscription(ctx context.Context, s storage.EmailSubscription) (storage.EmailSubscription, error)
+}
+
+// SubscriptionHandler lets each user choose the alert emails they get:
+// on or off, the minimum severity and the sites. Users restricted to some
+// sites only get alerts of their granted sites, whatever they choose.
+type SubscriptionHandler struct {
+	storage        SubscriptionStorage
+	allowedOrigins map[string]bool
+	allowAll       bool
+}
+
+func NewSubscriptionHandler(store SubscriptionStorage, origins []string) *SubscriptionHandler {
+	h := &SubscriptionHandler{
+		storage:        store,
+		allowedOrigins: make(map[string]bool),
+	}
+	for _, o := range origins {
+		if o == "*" {
+			h.allowAll = true
+			break
+		}
+		h.allowedOrigins[o] = true
+	}
+	return h
+}
+
+type subscriptionRequest struct {
+	Enabled     bool     `json:"enabled"`
+	MinSeverity string   `json:"min_severity"`
+	Sites       []string `json:"sites"`
+}
+
+// HandleGet returns the subscription of the current user; users who never
+// subscribed get the defaults, disabled
+// GET /api/notifications/email
+func (h *SubscriptionHandler) HandleGet(w http.ResponseWriter, r *http.Request) {
+	h.setCORS(w, r)
+
+	user, _ := UserFromContext(r.Context())
+	s, err := h.storage.GetEmailSubscription(r.Context(), user.Email)
+	if errors.Is(err, storage.ErrEmailSubscriptionNotFound) {
+		s = storage.EmailSubscription{Email: user.Email, MinSeverity: defaultEmailSeverity, Sites: []string{}}
+	} else if err != nil {
+		slog.Error("failed to get email subscription", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s)
+}
+
+// HandlePut replaces the subscription of the current user
+// PUT /api/notifications/email
+func (h *SubscriptionHandler) HandlePut(w http.ResponseWriter, r *http.Request) {
+	h.setCORS(w, r)
+
+	var req subscriptionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if req.MinSeverity == "" {
+		req.MinSeverity = defaultEmailSeverity
+	}
+	if req.MinSeverity != "info" && req.MinSeverity != "warning" && req.MinSeverity != "critical" {
+		http.Error(w, "min_severity must be info, warning or critical", http.StatusBadRequest)
+		return
+	}
+	scope := siteScope(r)
+	sites := make([]string, 0, len(req.Sites))
+	for _, site := range req.Sites {
+		if site = strings.TrimSpace(site); site == "" || slices.Contains(sites, site) {
+			continue
+		}
+		if scope != nil && !slices.Contains(scope, site) {
+			http.Error(w, "site not granted: "+site, http.StatusForbidden)
+			return
+		}
+		sites = append(sites, site)
+	}
+
+	user, _ := UserFromContext(r.Context())
+	s, err := h.storage.SetEmailSubscription(r.Context(), storage.EmailSubscription{
+		Email:       user.Email,
+		Enabled:     req.Enabled,
+		MinSeverity: req.MinSeverity,
+		Sites:       sites,
+	})
+	if err != nil {
+		slog.Error("failed to save email subscription", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s)
+}
+
+func (h *SubscriptionHandler) setCORS(w http.ResponseWriter, r *http.Request) {
+	origin := r.Header.Get("Origin")
+	if h.allowAll {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	} else if h.allowedOrigins[origin] {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+	}
+}
@@ -0,0 +1,67 @@
+package handler
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/mcbile/product-pulse/internal/support"
+)
+
+// ============================================
+// SUPPORT BUNDLE HANDLER (admin)
+// ============================================
+
+// AuditSupportBundle is the audit event of a downloaded support bundle,
+// which carries the collector's configuration and recent logs
+const AuditSupportBundle = "support_bundle_downloaded"
+
+// SupportHandler serves support bundles of this collector
+type SupportHandler struct {
+	storage        auditWriter
+	sources        support.Sources
+	allowedOrigins map[string]bool
+	allowAll       bool
+}
+
+func NewSupportHandler(store auditWriter, sources support.Sources, origins []string) *SupportHandler {
+	h := &SupportHandler{
+		storage:        store,
+		sources:        sources,
+		allowedOrigins: make(map[string]bool),
+	}
+	for _, o := range origins {
+		if o == "*" {
+			h.allowAll = true
+			break
+		}
+		h.allowedOrigins[o] = true
+	}
+	return h
+}
+
+// Handle returns a zip archive of the redacted config, recent logs, stats,
+// schema, job statuses and connection pool of this collector
+// GET /api/admin/support-bundle
+func (h *SupportHandler) Handle(w http.ResponseWriter, r *http.Request) {
+	h.setCORS(w, r)
+
+	filename := support.Filename(time.Now())
+	auditChange(r, h.storage, AuditSupportBundle, map[string]string{"file": filename})
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+	w.Header().Set("Cache-Control", "no-store")
+	if err := support.Write(r.Context(), w, h.sources); err != nil {
+		slog.Error("failed to write support bundle", "error", err)
+	}
+}
+
+func (h *SupportHandler) setCORS(w http.ResponseWriter, r *http.Request) {
+	origin := r.Header.Get("Origin")
+	if h.allowAll {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	} else if h.allowedOrigins[origin] {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+	}
+}
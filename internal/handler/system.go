@@ -0,0 +1,319 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mcbile/product-pulse/internal/collector"
+	"github.com/mcbile/product-pulse/internal/jobs"
+	"github.com/mcbile/product-pulse/internal/model"
+)
+
+// ============================================
+// SYSTEM HEALTH HANDLER
+// ============================================
+
+// Traffic light states of Pulse itself
+const (
+	SystemGreen  = "green"
+	SystemYellow = "yellow"
+	SystemRed    = "red"
+)
+
+// Thresholds of the system health factors (yellow, red)
+const (
+	ingestLagYellow = 30 * time.Second
+	ingestLagRed    = 5 * time.Minute
+	dropRateYellow  = 0.01
+	dropRateRed     = 0.05
+	dbLatencyYellow = 250 * time.Millisecond
+	dbLatencyRed    = time.Second
+	saturationRed   = 90.0 // Queue saturation, % of capacity
+
+	dropRateWindow = 5 * time.Minute
+	dbPingTimeout  = 2 * time.Second
+	schedulerStall = 5 * time.Minute // Overdue jobs beyond this mean the scheduler stopped
+)
+
+// SystemFactor is one input of the system health status
+type SystemFactor struct {
+	Name    string  `json:"name"`
+	Status  string  `json:"status"`
+	Value   float64 `json:"value"`
+	Message string  `json:"message,omitempty"` // Set unless green
+}
+
+// SystemHealth is the health of the collector itself
+type SystemHealth struct {
+	Status    string         `json:"status"` // Worst factor status
+	Factors   []SystemFactor `json:"factors"`
+	CheckedAt time.Time      `json:"checked_at"`
+}
+
+// Pinger checks the database connection
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// eventCounts is a snapshot of the received and failed counters of all
+// collectors, for drop rates over a window
+type eventCounts struct {
+	at       time.Time
+	received int64
+	failed   int64
+}
+
+// SystemHealthHandler summarizes ingest lag, drop rate, database latency,
+// spilled events and the job scheduler into one traffic light, so the
+// dashboard can tell users when missing data is Pulse's fault rather than
+// a product outage
+type SystemHealthHandler struct {
+	db               Pinger
+	collector        *collector.BatchCollector
+	backend          *collector.Backend
+	scheduler        *jobs.Scheduler
+	failureThreshold int // Consecutive job failures before a job_failure alert
+
+	mu        sync.Mutex
+	snapshots []eventCounts // One per minute, oldest first, within dropRateWindow
+
+	allowedOrigins map[string]bool
+	allowAll       bool
+}
+
+func NewSystemHealthHandler(db Pinger, c *collector.BatchCollector, backend *collector.Backend, scheduler *jobs.Scheduler, failureThreshold int, origins []string) *SystemHealthHandler {
+	h := &SystemHealthHandler{
+		db:               db,
+		collector:        c,
+		backend:          backend,
+		scheduler:        scheduler,
+		failureThreshold: failureThreshold,
+		allowedOrigins:   make(map[string]bool),
+	}
+	for _, o := range origins {
+		if o == "*" {
+			h.allowAll = true
+			break
+		}
+		h.allowedOrigins[o] = true
+	}
+	return h
+}
+
+// Handle returns the system health with its contributing factors
+// GET /api/system/health
+func (h *SystemHealthHandler) Handle(w http.ResponseWriter, r *http.Request) {
+	h.setCORS(w, r)
+
+	health := h.evaluate(r.Context())
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(health)
+}
+
+func (h *SystemHealthHandler) evaluate(ctx context.Context) SystemHealth {
+	now := time.Now()
+	stats := append([]model.CollectorStats{h.collector.GetStats()}, backendStats(h.backend)...)
+
+	factors := []SystemFactor{
+		ingestLagFactor(stats),
+		h.dropRateFactor(stats, now),
+		h.dbLatencyFactor(ctx),
+		spillFactor(stats),
+		h.schedulerFactor(ctx, now),
+	}
+
+	status := SystemGreen
+	for _, f := range factors {
+		if severity(f.Status) > severity(status) {
+			status = f.Status
+		}
+	}
+	return SystemHealth{Status: status, Factors: factors, CheckedAt: now.UTC()}
+}
+
+func backendStats(b *collector.Backend) []model.CollectorStats {
+	var stats []model.CollectorStats
+	for _, s := range b.GetStats() {
+		stats = append(stats, s)
+	}
+	return stats
+}
+
+func severity(status string) int {
+	switch status {
+	case SystemRed:
+		return 2
+	case SystemYellow:
+		return 1
+	}
+	return 0
+}
+
+// grade returns the status of a value against its thresholds
+func grade(value, yellow, red float64) string {
+	switch {
+	case value >= red:
+		return SystemRed
+	case value >= yellow:
+		return SystemYellow
+	}
+	return SystemGreen
+}
+
+// ingestLagFactor is the longest time events waited in a queue before
+// their last flush
+func ingestLagFactor(stats []model.CollectorStats) SystemFactor {
+	var lag float64
+	for _, s := range stats {
+		lag = max(lag, s.IngestLagMS)
+	}
+	f := SystemFactor{
+		Name:   "ingest_lag",
+		Status: grade(lag, float64(ingestLagYellow.Milliseconds()), float64(ingestLagRed.Milliseconds())),
+		Value:  lag,
+	}
+	if f.Status != SystemGreen {
+		f.Message = fmt.Sprintf("events wait %s before they are written, recent data may be missing", time.Duration(lag*1e6).Round(time.Second))
+	}
+	return f
+}
+
+// dropRateFactor is the share of events received within dropRateWindow
+// that could not be written
+func (h *SystemHealthHandler) dropRateFactor(stats []model.CollectorStats, now time.Time) SystemFactor {
+	current := eventCounts{at: now}
+	for _, s := range stats {
+		current.received += s.EventsReceived
+		current.failed += s.EventsFailed
+	}
+
+	h.mu.Lock()
+	for len(h.snapshots) > 1 && now.Sub(h.snapshots[1].at) >= dropRateWindow {
+		h.snapshots = h.snapshots[1:]
+	}
+	base := eventCounts{}
+	if len(h.snapshots) > 0 {
+		base = h.snapshots[0]
+	}
+	if len(h.snapshots) == 0 || now.Sub(h.snapshots[len(h.snapshots)-1].at) >= time.Minute {
+		h.snapshots = append(h.snapshots, current)
+	}
+	h.mu.Unlock()
+
+	var rate float64
+	if received := current.received - base.received; received > 0 {
+		rate = float64(current.failed-base.failed) / float64(received)
+	}
+	f := SystemFactor{
+		Name:   "drop_rate",
+		Status: grade(rate, dropRateYellow, dropRateRed),
+		Value:  rate,
+	}
+	if f.Status != SystemGreen {
+		f.Message = fmt.Sprintf("%.1f%% of recent events were dropped", rate*100)
+	}
+	return f
+}
+
+// dbLatencyFactor is the round trip time of a database ping
+func (h *SystemHealthHandler) dbLatencyFactor(ctx context.Context) SystemFactor {
+	ctx, cancel := context.WithTimeout(ctx, dbPingTimeout)
+	defer cancel()
+
+	start := time.Now()
+	err := h.db.Ping(ctx)
+	latency := time.Since(start)
+
+	f := SystemFactor{
+		Name:   "db_latency",
+		Status: grade(float64(latency), float64(dbLatencyYellow), float64(dbLatencyRed)),
+		Value:  float64(latency.Microseconds()) / 1000,
+	}
+	switch {
+	case err != nil:
+		slog.Warn("system health database ping failed", "error", err)
+		f.Status = SystemRed
+		f.Message = "database unavailable"
+	case f.Status != SystemGreen:
+		f.Message = fmt.Sprintf("database responds in %s", latency.Round(time.Millisecond))
+	}
+	return f
+}
+
+// spillFactor covers events waiting outside the in-memory queues: events
+// spilled to disk are Pulse's dead letter queue, written once the
+// database catches up
+func spillFactor(stats []model.CollectorStats) SystemFactor {
+	var spilled int64
+	var saturation float64
+	for _, s := range stats {
+		spilled += s.SpillBytes
+		saturation = max(saturation, s.QueueSaturation)
+	}
+
+	f := SystemFactor{Name: "spill", Status: SystemGreen, Value: float64(spilled)}
+	switch {
+	case saturation >= saturationRed:
+		f.Status = SystemRed
+		f.Message = fmt.Sprintf("queues are %.0f%% full, new events are throttled or dropped", saturation)
+	case spilled > 0:
+		f.Status = SystemYellow
+		f.Message = fmt.Sprintf("%d bytes of events spilled to disk wait to be written", spilled)
+	}
+	return f
+}
+
+// schedulerFactor covers the jobs that raise alerts: failing jobs mean
+// alerts may be missing, overdue jobs that the scheduler stopped
+func (h *SystemHealthHandler) schedulerFactor(ctx context.Context, now time.Time) SystemFactor {
+	f := SystemFactor{Name: "alerting", Status: SystemGreen}
+
+	list, err := h.scheduler.List(ctx)
+	if err != nil {
+		slog.Warn("system health job list failed", "error", err)
+		f.Status = SystemYellow
+		f.Message = "job status unavailable"
+		return f
+	}
+
+	var failing, stalled []string
+	for _, job := range list {
+		if !job.Registered {
+			continue
+		}
+		if job.ConsecutiveFailures >= h.failureThreshold {
+			failing = append(failing, job.Name)
+		}
+		if !job.Running && job.NextRunAt != nil && now.Sub(*job.NextRunAt) > schedulerStall {
+			stalled = append(stalled, job.Name)
+		}
+	}
+	f.Value = float64(len(failing) + len(stalled))
+
+	switch {
+	case len(stalled) > 0:
+		f.Status = SystemRed
+		f.Message = fmt.Sprintf("scheduled jobs are not running: %s", strings.Join(stalled, ", "))
+	case len(failing) > 0:
+		f.Status = SystemYellow
+		f.Message = fmt.Sprintf("scheduled jobs are failing: %s", strings.Join(failing, ", "))
+	}
+	return f
+}
+
+func (h *SystemHealthHandler) setCORS(w http.ResponseWriter, r *http.Request) {
+	origin := r.Header.Get("Origin")
+	if h.allowAll {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	} else if h.allowedOrigins[origin] {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+	}
+}
@@ -0,0 +1,244 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/mcbile/product-pulse/internal/middleware"
+	"github.com/mcbile/product-pulse/internal/storage"
+)
+
+// ============================================
+// USAGE AND QUOTA HANDLER (admin)
+// ============================================
+
+// AuditSiteQuotaChanged is the audit event of a quota set or removed
+const AuditSiteQuotaChanged = "site_quota_changed"
+
+// maxUsageDays bounds the range of a usage query
+const maxUsageDays = 366
+
+// UsageStorage is the subset of storage used for usage and quotas
+type UsageStorage interface {
+	GetSiteUsage(ctx context.Context, start, end time.Time, siteID string) ([]storage.SiteUsage, error)
+	GetSiteQuotas(ctx context.Context) ([]storage.SiteQuota, error)
+	SetSiteQuota(ctx context.Context, q storage.SiteQuota) (storage.SiteQuota, error)
+	DeleteSiteQuota(ctx context.Context, siteID string) (bool, error)
+	InsertAuditEvent(ctx context.Context, e storage.AuditEvent) error
+}
+
+// UsageHandler serves ingest usage per site and metric type, and manages
+// the daily quotas enforced by the usage meter
+type UsageHandler struct {
+	storage        UsageStorage
+	meter          *middleware.UsageMeter
+	allowedOrigins map[string]bool
+	allowAll       bool
+}
+
+func NewUsageHandler(store UsageStorage, meter *middleware.UsageMeter, origins []string) *UsageHandler {
+	h := &UsageHandler{
+		storage:        store,
+		meter:          meter,
+		allowedOrigins: make(map[string]bool),
+	}
+	for _, o := range origins {
+		if o == "*" {
+			h.allowAll = true
+			break
+		}
+		h.allowedOrigins[o] = true
+	}
+	return h
+}
+
+// Handle returns daily usage from start to end (YYYY-MM-DD, inclusive,
+// default the last 30 days) with totals per site, of all sites or one.
+// Usage is stored every 30 seconds, so today's lags behind by up to that.
+// GET /api/usage?start=2026-01-01&end=2026-01-31&site=
+func (h *UsageHandler) Handle(w http.ResponseWriter, r *http.Request) {
+	h.setCORS(w, r)
+
+	q := r.URL.Query()
+	end := time.Now().UTC().Truncate(24 * time.Hour)
+	if s := q.Get("end"); s != "" {
+		t, err := time.Parse(time.DateOnly, s)
+		if err != nil {
+			http.Error(w, "invalid end, expected YYYY-MM-DD", http.StatusBadRequest)
+			return
+		}
+		end = t
+	}
+	start := end.AddDate(0, 0, -29)
+	if s := q.Get("start"); s != "" {
+		t, err := time.Parse(time.DateOnly, s)
+		if err != nil {
+			http.Error(w, "invalid start, expected YYYY-MM-DD", http.StatusBadRequest)
+			return
+		}
+		start = t
+	}
+	if start.After(end) {
+		http.Error(w, "start must not be after end", http.StatusBadRequest)
+		return
+	}
+	if end.Sub(start) >= maxUsageDays*24*time.Hour {
+		http.Error(w, "range must not exceed 366 days", http.StatusBadRequest)
+		return
+	}
+
+	usage, err := h.storage.GetSiteUsage(r.Context(), start, end, q.Get("site"))
+	if err != nil {
+		slog.Error("failed to query site usage", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if usage == nil {
+		usage = []storage.SiteUsage{}
+	}
+
+	totals := make(map[string]*middleware.UsageCounts)
+	for _, u := range usage {
+		t, ok := totals[u.SiteID]
+		if !ok {
+			t = &middleware.UsageCounts{}
+			totals[u.SiteID] = t
+		}
+		t.Requests += u.Requests
+		t.Events += u.Events
+		t.WireBytes += u.WireBytes
+		t.RawBytes += u.RawBytes
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"start":  start.Format(time.DateOnly),
+		"end":    end.Format(time.DateOnly),
+		"usage":  usage,
+		"totals": totals,
+	})
+}
+
+// siteQuota is a quota with the site's usage today
+type siteQuota struct {
+	storage.SiteQuota
+	Today middleware.UsageCounts `json:"today"`
+}
+
+// HandleQuotas lists the site quotas with each site's usage today
+// GET /api/quotas
+func (h *UsageHandler) HandleQuotas(w http.ResponseWriter, r *http.Request) {
+	h.setCORS(w, r)
+
+	quotas, err := h.storage.GetSiteQuotas(r.Context())
+	if err != nil {
+		slog.Error("failed to query site quotas", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	result := make([]siteQuota, 0, len(quotas))
+	for _, q := range quotas {
+		result = append(result, siteQuota{SiteQuota: q, Today: h.meter.Today(q.SiteID)})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"quotas": result,
+	})
+}
+
+// HandlePutQuota sets a site's daily limits of events and raw (decoded)
+// body bytes; 0 leaves one unlimited
+// PUT /api/sites/{site}/quota {"max_events": 50000000, "max_bytes": 20000000000}
+func (h *UsageHandler) HandlePutQuota(w http.ResponseWriter, r *http.Request) {
+	h.setCORS(w, r)
+
+	site := r.PathValue("site")
+	if site == "" || len(site) > 100 {
+		http.Error(w, "site is required and at most 100 characters", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		MaxEvents int64 `json:"max_events"`
+		MaxBytes  int64 `json:"max_bytes"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if req.MaxEvents < 0 || req.MaxBytes < 0 {
+		http.Error(w, "max_events and max_bytes must not be negative", http.StatusBadRequest)
+		return
+	}
+	if req.MaxEvents == 0 && req.MaxBytes == 0 {
+		http.Error(w, "max_events or max_bytes is required, delete the quota to lift it", http.StatusBadRequest)
+		return
+	}
+
+	user, _ := UserFromContext(r.Context())
+	q, err := h.storage.SetSiteQuota(r.Context(), storage.SiteQuota{
+		SiteID:    site,
+		MaxEvents: req.MaxEvents,
+		MaxBytes:  req.MaxBytes,
+		UpdatedBy: user.Email,
+	})
+	if err != nil {
+		slog.Error("failed to save site quota", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	h.changed(r, q)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(siteQuota{SiteQuota: q, Today: h.meter.Today(site)})
+}
+
+// HandleDeleteQuota lifts a site's quota
+// DELETE /api/sites/{site}/quota
+func (h *UsageHandler) HandleDeleteQuota(w http.ResponseWriter, r *http.Request) {
+	h.setCORS(w, r)
+
+	site := r.PathValue("site")
+	deleted, err := h.storage.DeleteSiteQuota(r.Context(), site)
+	if err != nil {
+		slog.Error("failed to delete site quota", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if !deleted {
+		http.Error(w, "site has no quota", http.StatusNotFound)
+		return
+	}
+	h.changed(r, storage.SiteQuota{SiteID: site})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{"status":"deleted"}`))
+}
+
+// changed applies a quota change right away and records it in the audit
+// log; zero limits mean the quota was removed
+func (h *UsageHandler) changed(r *http.Request, q storage.SiteQuota) {
+	if err := h.meter.Reload(r.Context()); err != nil {
+		slog.Error("failed to reload site quotas", "error", err)
+	}
+	auditChange(r, h.storage, AuditSiteQuotaChanged, map[string]string{
+		"site_id":    q.SiteID,
+		"max_events": strconv.FormatInt(q.MaxEvents, 10),
+		"max_bytes":  strconv.FormatInt(q.MaxBytes, 10),
+	})
+}
+
+func (h *UsageHandler) setCORS(w http.ResponseWriter, r *http.Request) {
+	origin := r.Header.Get("Origin")
+	if h.allowAll {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	} else if h.allowedOrigins[origin] {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+	}
+}
@@ -0,0 +1,195 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/mcbile/product-pulse/internal/storage"
+)
+
+// ============================================
+// USERS HANDLER (admin)
+// ============================================
+
+// UserStorage is the subset of storage used to manage dashboard users
+type UserStorage interface {
+	ListUsers(ctx context.Context) ([]storage.User, error)
+	GetUser(ctx context.Context, email string) (storage.User, error)
+	SetUserRole(ctx context.Context, email, role string) error
+	SetUserSites(ctx context.Context, email string, sites []string, grantedBy string) error
+}
+
+// UserHandler manages the roles and site grants of dashboard users. Only
+// super admins may grant admin roles or change users holding them.
+type UserHandler struct {
+	storage        UserStorage
+	allowedOrigins map[string]bool
+	allowAll       bool
+}
+
+func NewUserHandler(store UserStorage, origins []string) *UserHandler {
+	h := &UserHandler{
+		storage:        store,
+		allowedOrigins: make(map[string]bool),
+	}
+	for _, o := range origins {
+		if o == "*" {
+			h.allowAll = true
+			break
+		}
+		h.allowedOrigins[o] = true
+	}
+	return h
+}
+
+// HandleList returns all users with their roles and site grants
+// GET /api/users
+func (h *UserHandler) HandleList(w http.ResponseWriter, r *http.Request) {
+	h.setCORS(w, r)
+
+	users, err := h.storage.ListUsers(r.Context())
+	if err != nil {
+		slog.Error("failed to list users", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	result := make([]User, 0, len(users))
+	for _, u := range users {
+		result = append(result, userFromStorage(u))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"users": result,
+	})
+}
+
+// HandleSetRole changes the role of a user
+// PUT /api/users/{email}/role
+func (h *UserHandler) HandleSetRole(w http.ResponseWriter, r *http.Request) {
+	h.setCORS(w, r)
+
+	var req struct {
+		Role string `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if !ValidRole(req.Role) {
+		http.Error(w, "unknown role", http.StatusBadRequest)
+		return
+	}
+
+	target, actor, ok := h.loadTarget(w, r)
+	if !ok {
+		return
+	}
+	if target.Email == actor.Email {
+		http.Error(w, "cannot change your own role", http.StatusBadRequest)
+		return
+	}
+	if roles[req.Role].admin && actor.Role != RoleSuperAdmin {
+		http.Error(w, "only super admins may grant admin roles", http.StatusForbidden)
+		return
+	}
+
+	if err := h.storage.SetUserRole(r.Context(), target.Email, req.Role); err != nil {
+		h.writeStorageError(w, target.Email, err)
+		return
+	}
+
+	slog.Info("user role changed", "email", target.Email, "role", req.Role, "from", target.Role, "by", actor.Email)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"status": "ok",
+		"role":   req.Role,
+	})
+}
+
+// HandleSetSites replaces the sites a user may see. Grants only restrict
+// roles without access to all sites.
+// PUT /api/users/{email}/sites
+func (h *UserHandler) HandleSetSites(w http.ResponseWriter, r *http.Request) {
+	h.setCORS(w, r)
+
+	var req struct {
+		Sites []string `json:"sites"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+	sites := make([]string, 0, len(req.Sites))
+	for _, s := range req.Sites {
+		if s = strings.TrimSpace(s); s == "" || len(s) > 100 {
+			http.Error(w, "invalid site id", http.StatusBadRequest)
+			return
+		}
+		sites = append(sites, s)
+	}
+	slices.Sort(sites)
+	sites = slices.Compact(sites)
+
+	target, actor, ok := h.loadTarget(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.storage.SetUserSites(r.Context(), target.Email, sites, actor.Email); err != nil {
+		h.writeStorageError(w, target.Email, err)
+		return
+	}
+
+	slog.Info("user sites changed", "email", target.Email, "sites", sites, "by", actor.Email)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": "ok",
+		"sites":  sites,
+	})
+}
+
+// loadTarget returns the user named in the path and the user making the
+// request, answering 404 for unknown users and 403 when an admin tries to
+// change a user holding an admin role
+func (h *UserHandler) loadTarget(w http.ResponseWriter, r *http.Request) (target storage.User, actor User, ok bool) {
+	actor, _ = UserFromContext(r.Context())
+
+	email := strings.ToLower(r.PathValue("email"))
+	target, err := h.storage.GetUser(r.Context(), email)
+	if err != nil {
+		h.writeStorageError(w, email, err)
+		return target, actor, false
+	}
+	if roles[target.Role].admin && actor.Role != RoleSuperAdmin {
+		http.Error(w, "only super admins may change admins", http.StatusForbidden)
+		return target, actor, false
+	}
+	return target, actor, true
+}
+
+func (h *UserHandler) writeStorageError(w http.ResponseWriter, email string, err error) {
+	if errors.Is(err, storage.ErrUserNotFound) {
+		http.Error(w, "user not found", http.StatusNotFound)
+		return
+	}
+	slog.Error("failed to update user", "email", email, "error", err)
+	http.Error(w, "internal error", http.StatusInternalServerError)
+}
+
+func (h *UserHandler) setCORS(w http.ResponseWriter, r *http.Request) {
+	origin := r.Header.Get("Origin")
+	if h.allowAll {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	} else if h.allowedOrigins[origin] {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+	}
+}
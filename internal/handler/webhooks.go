@@ -0,0 +1,220 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/mcbile/product-pulse/internal/notify"
+	"github.com/mcbile/product-pulse/internal/storage"
+)
+
+// ============================================
+// WEBHOOKS HANDLER (admin)
+// ============================================
+
+// WebhookStorage is the subset of storage used for webhooks
+type WebhookStorage interface {
+	UpsertWebhook(ctx context.Context, w storage.Webhook) (storage.Webhook, error)
+	GetWebhook(ctx context.Context, name string) (storage.Webhook, error)
+	GetWebhooks(ctx context.Context) ([]storage.Webhook, error)
+	DeleteWebhook(ctx context.Context, name string) (bool, error)
+	GetWebhookDeliveries(ctx context.Context, webhook string, failed bool, limit int) ([]storage.WebhookDelivery, error)
+}
+
+// WebhooksHandler manages the outbound webhooks of the webhook channel and
+// serves their delivery log. Secrets are write-only.
+type WebhooksHandler struct {
+	storage        WebhookStorage
+	allowedOrigins map[string]bool
+	allowAll       bool
+}
+
+func NewWebhooksHandler(store WebhookStorage, origins []string) *WebhooksHandler {
+	h := &WebhooksHandler{
+		storage:        store,
+		allowedOrigins: make(map[string]bool),
+	}
+	for _, o := range origins {
+		if o == "*" {
+			h.allowAll = true
+			break
+		}
+		h.allowedOrigins[o] = true
+	}
+	return h
+}
+
+// webhookRequest creates or replaces a webhook. An omitted secret keeps
+// the current one; an empty secret turns signing off.
+type webhookRequest struct {
+	URL       string  `json:"url"`
+	Secret    *string `json:"secret"`
+	Template  string  `json:"template"`
+	AllAlerts bool    `json:"all_alerts"`
+	Enabled   *bool   `json:"enabled"`
+}
+
+// HandleList returns all webhooks
+// GET /api/webhooks
+func (h *WebhooksHandler) HandleList(w http.ResponseWriter, r *http.Request) {
+	h.setCORS(w, r)
+
+	webhooks, err := h.storage.GetWebhooks(r.Context())
+	if err != nil {
+		slog.Error("failed to list webhooks", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if webhooks == nil {
+		webhooks = []storage.Webhook{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"webhooks": webhooks,
+	})
+}
+
+// HandleGet returns one webhook
+// GET /api/webhooks/{name}
+func (h *WebhooksHandler) HandleGet(w http.ResponseWriter, r *http.Request) {
+	h.setCORS(w, r)
+
+	webhook, err := h.storage.GetWebhook(r.Context(), r.PathValue("name"))
+	if errors.Is(err, storage.ErrWebhookNotFound) {
+		http.Error(w, "webhook not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		slog.Error("failed to get webhook", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(webhook)
+}
+
+// HandlePut creates or replaces a webhook. The template must render valid
+// JSON.
+// PUT /api/webhooks/{name}
+func (h *WebhooksHandler) HandlePut(w http.ResponseWriter, r *http.Request) {
+	h.setCORS(w, r)
+
+	name := r.PathValue("name")
+	if name == "" || len(name) > 100 {
+		http.Error(w, "name is required and at most 100 characters", http.StatusBadRequest)
+		return
+	}
+
+	var req webhookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON", http.StatusBadRequest)
+		return
+	}
+	u, err := url.Parse(req.URL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		http.Error(w, "url must be an http(s) URL", http.StatusBadRequest)
+		return
+	}
+	if err := notify.ValidateWebhookTemplate(req.Template); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	webhook := storage.Webhook{
+		Name:      name,
+		URL:       req.URL,
+		Template:  req.Template,
+		AllAlerts: req.AllAlerts,
+		Enabled:   req.Enabled == nil || *req.Enabled,
+	}
+	if req.Secret != nil {
+		webhook.Secret = *req.Secret
+	} else {
+		current, err := h.storage.GetWebhook(r.Context(), name)
+		if err != nil && !errors.Is(err, storage.ErrWebhookNotFound) {
+			slog.Error("failed to get webhook", "error", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		webhook.Secret = current.Secret
+	}
+	user, _ := UserFromContext(r.Context())
+	webhook.UpdatedBy = user.Email
+
+	saved, err := h.storage.UpsertWebhook(r.Context(), webhook)
+	if err != nil {
+		slog.Error("failed to save webhook", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(saved)
+}
+
+// HandleDelete removes a webhook
+// DELETE /api/webhooks/{name}
+func (h *WebhooksHandler) HandleDelete(w http.ResponseWriter, r *http.Request) {
+	h.setCORS(w, r)
+
+	deleted, err := h.storage.DeleteWebhook(r.Context(), r.PathValue("name"))
+	if err != nil {
+		slog.Error("failed to delete webhook", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if !deleted {
+		http.Error(w, "webhook not found", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleDeliveries returns the latest delivery attempts of a webhook,
+// newest first; failed=true returns only failed attempts
+// GET /api/webhooks/{name}/deliveries?failed=true&limit=100
+func (h *WebhooksHandler) HandleDeliveries(w http.ResponseWriter, r *http.Request) {
+	h.setCORS(w, r)
+
+	limit := 100
+	if s := r.URL.Query().Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > maxListLimit {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	failed := r.URL.Query().Get("failed") == "true"
+
+	deliveries, err := h.storage.GetWebhookDeliveries(r.Context(), r.PathValue("name"), failed, limit)
+	if err != nil {
+		slog.Error("failed to query webhook deliveries", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if deliveries == nil {
+		deliveries = []storage.WebhookDelivery{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"deliveries": deliveries,
+	})
+}
+
+func (h *WebhooksHandler) setCORS(w http.ResponseWriter, r *http.Request) {
+	origin := r.Header.Get("Origin")
+	if h.allowAll {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	} else if h.allowedOrigins[origin] {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+	}
+}
@@ -0,0 +1,251 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mcbile/product-pulse/internal/storage"
+)
+
+// Verdicts returned in Decision.Status
+const (
+	StatusHealthy  = "healthy"
+	StatusDegraded = "degraded"
+	StatusDown     = "down"
+	StatusUnknown  = "unknown" // Not enough recent traffic to decide
+)
+
+// ErrInvalidComponent is returned for components not in the form kind:name
+var ErrInvalidComponent = errors.New("invalid component, expected psp:<name>, game:<provider> or api:<service>")
+
+// latencyLimitsMS is the p95 latency above which a component of each kind is
+// degraded even if its requests succeed
+var latencyLimitsMS = map[string]float64{
+	storage.ComponentPSP:  10000,
+	storage.ComponentGame: 8000,
+	storage.ComponentAPI:  2000,
+}
+
+// maxCachedDecisions bounds the cache; component names come from clients
+const maxCachedDecisions = 1000
+
+// Storage is the subset of storage used by the decider
+type Storage interface {
+	GetComponentHealth(ctx context.Context, kind, name string, start time.Time) (storage.ComponentHealthRow, error)
+}
+
+// Config for health decisions
+type Config struct {
+	Window        time.Duration // Lookback for success rate and latency
+	CacheTTL      time.Duration // How long a decision is reused
+	Timeout       time.Duration // Budget for one evaluation
+	MinSamples    int64         // Fewer samples give an unknown verdict
+	DegradedBelow float64       // Success rate (0-1) below which a component is degraded
+	DownBelow     float64       // Success rate (0-1) below which a component is down
+}
+
+// Decision is the verdict for one component
+type Decision struct {
+	Component    string    `json:"component"`
+	Status       string    `json:"status"`
+	Confidence   float64   `json:"confidence"` // 0-1, grows with the number of samples
+	Reason       string    `json:"reason"`
+	Samples      int64     `json:"samples"`
+	SuccessRate  *float64  `json:"success_rate,omitempty"`
+	P95LatencyMS *float64  `json:"p95_latency_ms,omitempty"`
+	Window       string    `json:"window"`
+	EvaluatedAt  time.Time `json:"evaluated_at"`
+	Stale        bool      `json:"stale,omitempty"` // Last known decision, evaluation failed
+}
+
+// Decider turns recent PSP, game provider and API metrics into
+// healthy/degraded/down verdicts for automated consumers such as cashier
+// routing. Decisions are cached for CacheTTL and concurrent requests for the
+// same component share one evaluation, so the database sees at most one
+// query per component and TTL however often consumers poll.
+type Decider struct {
+	config  Config
+	storage Storage
+
+	mu       sync.Mutex
+	cache    map[string]cachedDecision
+	inflight map[string]*evaluation
+}
+
+type cachedDecision struct {
+	decision Decision
+	expires  time.Time
+}
+
+type evaluation struct {
+	done     chan struct{}
+	decision Decision
+	err      error
+}
+
+// NewDecider creates a decider
+func NewDecider(config Config, storage Storage) *Decider {
+	if config.Window <= 0 {
+		config.Window = 5 * time.Minute
+	}
+	if config.CacheTTL <= 0 {
+		config.CacheTTL = 10 * time.Second
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = 250 * time.Millisecond
+	}
+	return &Decider{
+		config:   config,
+		storage:  storage,
+		cache:    make(map[string]cachedDecision),
+		inflight: make(map[string]*evaluation),
+	}
+}
+
+// CacheTTL returns how long decisions are reused
+func (d *Decider) CacheTTL() time.Duration {
+	return d.config.CacheTTL
+}
+
+// ParseComponent splits a component in the form kind:name
+func ParseComponent(component string) (kind, name string, err error) {
+	kind, name, ok := strings.Cut(component, ":")
+	if !ok || name == "" {
+		return "", "", ErrInvalidComponent
+	}
+	if _, known := latencyLimitsMS[kind]; !known {
+		return "", "", ErrInvalidComponent
+	}
+	return kind, name, nil
+}
+
+// Decide returns the verdict for a component. If an evaluation fails, the
+// last decision is returned with Stale set; without one the error is
+// returned.
+func (d *Decider) Decide(ctx context.Context, component string) (Decision, error) {
+	kind, name, err := ParseComponent(component)
+	if err != nil {
+		return Decision{}, err
+	}
+
+	d.mu.Lock()
+	if cached, ok := d.cache[component]; ok && time.Now().Before(cached.expires) {
+		d.mu.Unlock()
+		return cached.decision, nil
+	}
+	ev, ok := d.inflight[component]
+	if !ok {
+		ev = &evaluation{done: make(chan struct{})}
+		d.inflight[component] = ev
+		go d.evaluate(component, kind, name, ev)
+	}
+	d.mu.Unlock()
+
+	select {
+	case <-ev.done:
+		return ev.decision, ev.err
+	case <-ctx.Done():
+		return Decision{}, ctx.Err()
+	}
+}
+
+// evaluate runs detached from the request that started it, since other
+// requests may be waiting for the result
+func (d *Decider) evaluate(component, kind, name string, ev *evaluation) {
+	ctx, cancel := context.WithTimeout(context.Background(), d.config.Timeout)
+	defer cancel()
+
+	now := time.Now()
+	row, err := d.storage.GetComponentHealth(ctx, kind, name, now.Add(-d.config.Window))
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.inflight, component)
+	defer close(ev.done)
+
+	if err != nil {
+		cached, ok := d.cache[component]
+		if !ok || now.Sub(cached.decision.EvaluatedAt) > d.config.Window {
+			ev.err = fmt.Errorf("evaluate %s: %w", component, err)
+			return
+		}
+		ev.decision = cached.decision
+		ev.decision.Stale = true
+		ev.decision.Confidence = round2(ev.decision.Confidence / 2)
+		return
+	}
+
+	ev.decision = d.decide(component, kind, row, now)
+	if len(d.cache) >= maxCachedDecisions {
+		d.prune(now)
+	}
+	if _, ok := d.cache[component]; ok || len(d.cache) < maxCachedDecisions {
+		d.cache[component] = cachedDecision{decision: ev.decision, expires: now.Add(d.config.CacheTTL)}
+	}
+}
+
+func (d *Decider) decide(component, kind string, row storage.ComponentHealthRow, now time.Time) Decision {
+	decision := Decision{
+		Component:   component,
+		Samples:     row.Total,
+		Window:      d.config.Window.String(),
+		EvaluatedAt: now.UTC(),
+	}
+
+	if row.Total == 0 || row.Total < d.config.MinSamples {
+		decision.Status = StatusUnknown
+		decision.Reason = fmt.Sprintf("%d samples in the last %s, need %d", row.Total, d.config.Window, d.config.MinSamples)
+		return decision
+	}
+
+	rate := float64(row.Success) / float64(row.Total)
+	p95 := row.P95LatencyMS
+	decision.SuccessRate = &rate
+	decision.P95LatencyMS = &p95
+	decision.Confidence = d.confidence(row.Total)
+
+	limit := latencyLimitsMS[kind]
+	switch {
+	case rate < d.config.DownBelow:
+		decision.Status = StatusDown
+		decision.Reason = fmt.Sprintf("success rate %.1f%% below %.1f%%", rate*100, d.config.DownBelow*100)
+	case rate < d.config.DegradedBelow:
+		decision.Status = StatusDegraded
+		decision.Reason = fmt.Sprintf("success rate %.1f%% below %.1f%%", rate*100, d.config.DegradedBelow*100)
+	case p95 > limit:
+		decision.Status = StatusDegraded
+		decision.Reason = fmt.Sprintf("p95 latency %.0fms above %.0fms", p95, limit)
+	default:
+		decision.Status = StatusHealthy
+		decision.Reason = fmt.Sprintf("success rate %.1f%%, p95 latency %.0fms", rate*100, p95)
+	}
+	return decision
+}
+
+// confidence grows with the sample count, reaching 0.96 at ten times
+// MinSamples
+func (d *Decider) confidence(samples int64) float64 {
+	scale := float64(d.config.MinSamples)
+	if scale < 1 {
+		scale = 1
+	}
+	return round2(1 - math.Exp(-float64(samples)/(scale*3)))
+}
+
+// prune drops decisions too old to be served as stale; d.mu must be held
+func (d *Decider) prune(now time.Time) {
+	for component, cached := range d.cache {
+		if now.Sub(cached.decision.EvaluatedAt) > d.config.Window {
+			delete(d.cache, component)
+		}
+	}
+}
+
+func round2(v float64) float64 {
+	return math.Round(v*100) / 100
+}
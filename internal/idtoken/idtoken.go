@@ -0,0 +1,293 @@
+package idtoken
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// GoogleJWKSURL serves the keys Google signs ID tokens with
+const GoogleJWKSURL = "https://www.googleapis.com/oauth2/v3/certs"
+
+// GoogleIssuers are the iss values of Google ID tokens
+var GoogleIssuers = []string{"accounts.google.com", "https://accounts.google.com"}
+
+// ErrInvalidToken is returned for tokens that fail verification
+var ErrInvalidToken = errors.New("invalid id token")
+
+// Claims of an OpenID Connect ID token
+type Claims struct {
+	Issuer        string   `json:"iss"`
+	Subject       string   `json:"sub"`
+	Audience      audience `json:"aud"`
+	ExpiresAt     int64    `json:"exp"`
+	IssuedAt      int64    `json:"iat"`
+	Email         string   `json:"email"`
+	EmailVerified bool     `json:"email_verified"`
+	Name          string   `json:"name"`
+	Picture       string   `json:"picture"`
+	Nonce         string   `json:"nonce"`
+}
+
+// audience accepts both forms of the aud claim: a string or an array
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+	var multi []string
+	if err := json.Unmarshal(data, &multi); err != nil {
+		return err
+	}
+	*a = multi
+	return nil
+}
+
+func (a audience) contains(s string) bool {
+	for _, v := range a {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Config for a verifier
+type Config struct {
+	JWKSURL         string
+	Issuers         []string      // Accepted iss values
+	Audience        string        // Expected aud, the OAuth client ID
+	RefreshInterval time.Duration // Keys are refetched after this, or after the max-age the JWKS endpoint sends
+	Leeway          time.Duration // Clock skew tolerated for exp and iat
+}
+
+// Verifier checks RS256-signed ID tokens against the keys of a JWKS
+// endpoint. Keys are cached until their max-age (or RefreshInterval) runs
+// out and then refetched on the next verification; a token signed with an
+// unknown key triggers an early refresh, at most once per minute.
+type Verifier struct {
+	config Config
+	client *http.Client
+
+	mu          sync.Mutex
+	keys        map[string]*rsa.PublicKey
+	expires     time.Time
+	lastRefresh time.Time
+}
+
+// NewVerifier creates a verifier
+func NewVerifier(config Config) *Verifier {
+	if config.RefreshInterval <= 0 {
+		config.RefreshInterval = time.Hour
+	}
+	if config.Leeway <= 0 {
+		config.Leeway = time.Minute
+	}
+	return &Verifier{
+		config: config,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// NewGoogleVerifier creates a verifier for Google ID tokens issued to
+// clientID
+func NewGoogleVerifier(clientID string) *Verifier {
+	return NewVerifier(Config{
+		JWKSURL:  GoogleJWKSURL,
+		Issuers:  GoogleIssuers,
+		Audience: clientID,
+	})
+}
+
+// Verify checks the signature, issuer, audience and expiry of token and
+// returns its claims. Tokens failing any check give an error wrapping
+// ErrInvalidToken; other errors mean the keys could not be fetched.
+func (v *Verifier) Verify(ctx context.Context, token string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: malformed token", ErrInvalidToken)
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("%w: header: %v", ErrInvalidToken, err)
+	}
+	if header.Alg != "RS256" {
+		return nil, fmt.Errorf("%w: unsupported algorithm %q", ErrInvalidToken, header.Alg)
+	}
+
+	key, err := v.key(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: signature encoding", ErrInvalidToken)
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig); err != nil {
+		return nil, fmt.Errorf("%w: bad signature", ErrInvalidToken)
+	}
+
+	var claims Claims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("%w: claims: %v", ErrInvalidToken, err)
+	}
+	if err := v.checkClaims(&claims, time.Now()); err != nil {
+		return nil, err
+	}
+	return &claims, nil
+}
+
+func (v *Verifier) checkClaims(c *Claims, now time.Time) error {
+	issuerOK := false
+	for _, iss := range v.config.Issuers {
+		if c.Issuer == iss {
+			issuerOK = true
+			break
+		}
+	}
+	if !issuerOK {
+		return fmt.Errorf("%w: unexpected issuer %q", ErrInvalidToken, c.Issuer)
+	}
+	if !c.Audience.contains(v.config.Audience) {
+		return fmt.Errorf("%w: unexpected audience", ErrInvalidToken)
+	}
+	if c.ExpiresAt == 0 || now.Add(-v.config.Leeway).Unix() >= c.ExpiresAt {
+		return fmt.Errorf("%w: expired", ErrInvalidToken)
+	}
+	if c.IssuedAt > now.Add(v.config.Leeway).Unix() {
+		return fmt.Errorf("%w: issued in the future", ErrInvalidToken)
+	}
+	return nil
+}
+
+// key returns the public key with the given ID, refreshing the cached keys
+// when they expired or the ID is unknown
+func (v *Verifier) key(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	now := time.Now()
+	key, ok := v.keys[kid]
+	stale := now.After(v.expires)
+	if ok && !stale {
+		return key, nil
+	}
+	if !stale && now.Sub(v.lastRefresh) < time.Minute {
+		return nil, fmt.Errorf("%w: unknown key %q", ErrInvalidToken, kid)
+	}
+
+	if err := v.refresh(ctx, now); err != nil {
+		if ok {
+			// Keep using the cached key while the endpoint is unavailable
+			slog.Warn("failed to refresh id token keys, using cached keys", "url", v.config.JWKSURL, "error", err)
+			return key, nil
+		}
+		return nil, err
+	}
+
+	if key, ok = v.keys[kid]; !ok {
+		return nil, fmt.Errorf("%w: unknown key %q", ErrInvalidToken, kid)
+	}
+	return key, nil
+}
+
+// refresh fetches the JWKS; v.mu must be held
+func (v *Verifier) refresh(ctx context.Context, now time.Time) error {
+	v.lastRefresh = now
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, v.config.JWKSURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("fetch jwks: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("fetch jwks: status %d", resp.StatusCode)
+	}
+
+	var set struct {
+		Keys []struct {
+			Kid string `json:"kid"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&set); err != nil {
+		return fmt.Errorf("decode jwks: %w", err)
+	}
+
+	keys := make(map[string]*rsa.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			continue
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			continue
+		}
+		keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	if len(keys) == 0 {
+		return fmt.Errorf("jwks contains no RSA keys")
+	}
+
+	v.keys = keys
+	v.expires = now.Add(maxAge(resp.Header.Get("Cache-Control"), v.config.RefreshInterval))
+	slog.Debug("id token keys refreshed", "url", v.config.JWKSURL, "keys", len(keys))
+	return nil
+}
+
+// maxAge returns the max-age of a Cache-Control header, or fallback
+func maxAge(cacheControl string, fallback time.Duration) time.Duration {
+	for _, directive := range strings.Split(cacheControl, ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(directive), "=")
+		if !ok || !strings.EqualFold(name, "max-age") {
+			continue
+		}
+		if d, err := time.ParseDuration(value + "s"); err == nil && d > 0 {
+			return d
+		}
+	}
+	return fallback
+}
+
+func decodeSegment(seg string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(seg, "="))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
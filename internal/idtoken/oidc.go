@@ -0,0 +1,209 @@
+package idtoken
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ProviderConfig configures a generic OpenID Connect provider (Okta, Azure
+// AD, Keycloak, ...)
+type ProviderConfig struct {
+	IssuerURL    string   // Discovery is read from IssuerURL/.well-known/openid-configuration
+	ClientID     string   // Expected aud of ID tokens
+	ClientSecret string   // Empty for public clients, which rely on PKCE alone
+	RedirectURL  string   // Callback registered with the provider
+	Scopes       []string // Defaults to openid email profile
+}
+
+// Provider runs the authorization code flow with PKCE against an OIDC
+// provider. Endpoints are discovered on first use and rediscovered after a
+// failure, so a provider outage at startup does not disable logins.
+type Provider struct {
+	config ProviderConfig
+	client *http.Client
+
+	mu       sync.Mutex
+	authURL  string
+	tokenURL string
+	verifier *Verifier
+}
+
+// NewProvider creates a provider
+func NewProvider(config ProviderConfig) *Provider {
+	if len(config.Scopes) == 0 {
+		config.Scopes = []string{"openid", "email", "profile"}
+	}
+	config.IssuerURL = strings.TrimSuffix(config.IssuerURL, "/")
+	return &Provider{
+		config: config,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Issuer returns the configured issuer URL
+func (p *Provider) Issuer() string {
+	return p.config.IssuerURL
+}
+
+// RedirectURL returns the configured callback URL
+func (p *Provider) RedirectURL() string {
+	return p.config.RedirectURL
+}
+
+// discover fetches the provider's endpoints unless already known
+func (p *Provider) discover(ctx context.Context) (authURL, tokenURL string, verifier *Verifier, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.verifier != nil {
+		return p.authURL, p.tokenURL, p.verifier, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.config.IssuerURL+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return "", "", nil, err
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", "", nil, fmt.Errorf("fetch oidc discovery: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", "", nil, fmt.Errorf("fetch oidc discovery: status %d", resp.StatusCode)
+	}
+
+	var doc struct {
+		Issuer                string `json:"issuer"`
+		AuthorizationEndpoint string `json:"authorization_endpoint"`
+		TokenEndpoint         string `json:"token_endpoint"`
+		JWKSURI               string `json:"jwks_uri"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&doc); err != nil {
+		return "", "", nil, fmt.Errorf("decode oidc discovery: %w", err)
+	}
+	if doc.Issuer != p.config.IssuerURL {
+		return "", "", nil, fmt.Errorf("oidc discovery: issuer %q does not match %q", doc.Issuer, p.config.IssuerURL)
+	}
+	if doc.AuthorizationEndpoint == "" || doc.TokenEndpoint == "" || doc.JWKSURI == "" {
+		return "", "", nil, fmt.Errorf("oidc discovery: missing endpoints")
+	}
+
+	p.authURL = doc.AuthorizationEndpoint
+	p.tokenURL = doc.TokenEndpoint
+	p.verifier = NewVerifier(Config{
+		JWKSURL:  doc.JWKSURI,
+		Issuers:  []string{doc.Issuer},
+		Audience: p.config.ClientID,
+	})
+	return p.authURL, p.tokenURL, p.verifier, nil
+}
+
+// NewCodeVerifier returns a random PKCE code verifier
+func NewCodeVerifier() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// codeChallenge is the S256 challenge of a code verifier
+func codeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// AuthCodeURL returns the provider URL to send the browser to
+func (p *Provider) AuthCodeURL(ctx context.Context, state, nonce, codeVerifier string) (string, error) {
+	authURL, _, _, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.config.ClientID},
+		"redirect_uri":          {p.config.RedirectURL},
+		"scope":                 {strings.Join(p.config.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {codeChallenge(codeVerifier)},
+		"code_challenge_method": {"S256"},
+	}
+	sep := "?"
+	if strings.Contains(authURL, "?") {
+		sep = "&"
+	}
+	return authURL + sep + q.Encode(), nil
+}
+
+// Exchange redeems an authorization code and returns the verified claims
+// of the ID token. Tokens failing verification, or carrying another
+// nonce, give an error wrapping ErrInvalidToken.
+func (p *Provider) Exchange(ctx context.Context, code, codeVerifier, nonce string) (*Claims, error) {
+	_, tokenURL, verifier, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.config.RedirectURL},
+		"client_id":     {p.config.ClientID},
+		"code_verifier": {codeVerifier},
+	}
+	if p.config.ClientSecret != "" {
+		form.Set("client_secret", p.config.ClientSecret)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("oidc token request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&body); err != nil {
+		return nil, fmt.Errorf("decode oidc token response: %w", err)
+	}
+	if body.Error != "" {
+		// Bad, expired or reused codes are the client's fault
+		return nil, fmt.Errorf("%w: token endpoint: %s %s", ErrInvalidToken, body.Error, body.ErrorDescription)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("oidc token request: status %d", resp.StatusCode)
+	}
+	if body.IDToken == "" {
+		return nil, errors.New("oidc token response without id_token")
+	}
+
+	claims, err := verifier.Verify(ctx, body.IDToken)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Nonce != nonce {
+		return nil, fmt.Errorf("%w: nonce mismatch", ErrInvalidToken)
+	}
+	return claims, nil
+}
@@ -0,0 +1,490 @@
+package ingest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+
+	"github.com/mcbile/product-pulse/internal/collector"
+	"github.com/mcbile/product-pulse/internal/enrich"
+	"github.com/mcbile/product-pulse/internal/model"
+	"github.com/mcbile/product-pulse/internal/quality"
+	"github.com/mcbile/product-pulse/internal/trace"
+)
+
+// NATSConfig for the JetStream ingest source
+type NATSConfig struct {
+	URL           string
+	Stream        string
+	SubjectPrefix string
+	Durable       string
+	AckWait       time.Duration
+}
+
+// NATSSource consumes metric batches from JetStream subjects, one subject per
+// metric type (<prefix>.frontend, <prefix>.api, <prefix>.psp, <prefix>.game,
+// <prefix>.ws). Message payloads use the same JSON bodies as the HTTP collect
+// endpoints and is validated, normalised and enriched the same way. A
+// message is acked only after every event in it was persisted, otherwise it
+// is nacked and redelivered by the server.
+type NATSSource struct {
+	config    NATSConfig
+	collector *collector.BatchCollector
+	backend   *collector.Backend
+	limits    *quality.Limits
+	pipeline  *enrich.Pipeline
+
+	residency Residency
+	kills     KillSwitches
+	usage     Usage
+
+	conn     *nats.Conn
+	closed   chan struct{} // Closed once conn is closed
+	consumes []jetstream.ConsumeContext
+}
+
+// Residency decides whether events of a site may be stored by this
+// collector, see middleware.Residency
+type Residency interface {
+	Allowed(site string) (region string, ok bool)
+	Drop(site string, events int)
+}
+
+// SetResidency drops events of sites resident in another region. Messages
+// cannot be forwarded, so publishers of those sites should use the
+// stream of their region.
+func (s *NATSSource) SetResidency(r Residency) {
+	s.residency = r
+}
+
+// KillSwitches drops events of killed sites and metric types, see
+// middleware.KillSwitches
+type KillSwitches interface {
+	Discard(site, metricType string) bool
+}
+
+// Usage accounts ingested events per site and refuses sites over their
+// daily quota, see middleware.UsageMeter
+type Usage interface {
+	Admit(site, metricType string, events int, bytes int64) bool
+}
+
+// SetKillSwitches drops events of killed sites and metric types before they
+// are validated. Messages cannot be refused, so reject mode drops them too.
+func (s *NATSSource) SetKillSwitches(ks KillSwitches) {
+	s.kills = ks
+}
+
+// SetUsage accounts messages in the usage of their sites and drops the
+// events of sites over their daily quota. Publishers cannot be told to
+// retry later as HTTP clients are, and redelivering the messages until the
+// quota resets would stall the consumer.
+func (s *NATSSource) SetUsage(u Usage) {
+	s.usage = u
+}
+
+// NewNATSSource creates a new JetStream ingest source
+func NewNATSSource(config NATSConfig, c *collector.BatchCollector, backend *collector.Backend, limits *quality.Limits, pipeline *enrich.Pipeline) *NATSSource {
+	if config.Stream == "" {
+		config.Stream = "PULSE"
+	}
+	if config.SubjectPrefix == "" {
+		config.SubjectPrefix = "pulse"
+	}
+	if config.Durable == "" {
+		config.Durable = "pulse-collector"
+	}
+	if config.AckWait <= 0 {
+		config.AckWait = 30 * time.Second
+	}
+
+	return &NATSSource{
+		config:    config,
+		collector: c,
+		backend:   backend,
+		limits:    limits,
+		pipeline:  pipeline,
+	}
+}
+
+// Start connects to NATS and begins consuming all metric subjects
+func (s *NATSSource) Start(ctx context.Context) error {
+	closed := make(chan struct{})
+	nc, err := nats.Connect(s.config.URL, nats.Name("pulse-collector"),
+		nats.ClosedHandler(func(*nats.Conn) { close(closed) }))
+	if err != nil {
+		return fmt.Errorf("nats connect: %w", err)
+	}
+	s.conn = nc
+	s.closed = closed
+
+	js, err := jetstream.New(nc)
+	if err != nil {
+		nc.Close()
+		return fmt.Errorf("jetstream: %w", err)
+	}
+
+	if _, err := js.Stream(ctx, s.config.Stream); errors.Is(err, jetstream.ErrStreamNotFound) {
+		_, err = js.CreateStream(ctx, jetstream.StreamConfig{
+			Name:     s.config.Stream,
+			Subjects: []string{s.config.SubjectPrefix + ".>"},
+		})
+		if err != nil {
+			nc.Close()
+			return fmt.Errorf("create stream: %w", err)
+		}
+	} else if err != nil {
+		nc.Close()
+		return fmt.Errorf("lookup stream: %w", err)
+	}
+
+	handlers := map[string]func(jetstream.Msg){
+		"frontend": s.handleFrontend,
+		"api":      handleMetrics(s, s.backend.API, "api"),
+		"psp":      handleMetrics(s, s.backend.PSP, "psp"),
+		"game":     handleMetrics(s, s.backend.Game, "game"),
+		"ws":       handleMetrics(s, s.backend.WS, "ws"),
+	}
+
+	for kind, handle := range handlers {
+		cons, err := js.CreateOrUpdateConsumer(ctx, s.config.Stream, jetstream.ConsumerConfig{
+			Durable:       s.config.Durable + "-" + kind,
+			FilterSubject: s.config.SubjectPrefix + "." + kind,
+			AckPolicy:     jetstream.AckExplicitPolicy,
+			AckWait:       s.config.AckWait,
+		})
+		if err != nil {
+			s.Stop()
+			return fmt.Errorf("create consumer %s: %w", kind, err)
+		}
+
+		cc, err := cons.Consume(handle)
+		if err != nil {
+			s.Stop()
+			return fmt.Errorf("consume %s: %w", kind, err)
+		}
+		s.consumes = append(s.consumes, cc)
+	}
+
+	slog.Info("nats ingest started",
+		"stream", s.config.Stream,
+		"subject_prefix", s.config.SubjectPrefix,
+	)
+	return nil
+}
+
+// Stop stops consuming and closes the NATS connection right away; on
+// shutdown use StopConsuming and Close around draining the collectors
+func (s *NATSSource) Stop() {
+	s.StopConsuming(context.Background())
+	if s.conn != nil {
+		s.conn.Close()
+	}
+}
+
+// StopConsuming stops pulling messages and waits until the handlers of
+// messages already received have queued them on the collectors. Those are
+// acked or nacked as the collectors flush, which needs the connection: call
+// Close once the collectors are drained.
+func (s *NATSSource) StopConsuming(ctx context.Context) {
+	for _, cc := range s.consumes {
+		cc.Stop()
+	}
+	for _, cc := range s.consumes {
+		select {
+		case <-cc.Closed():
+		case <-ctx.Done():
+			return
+		}
+	}
+	s.consumes = nil
+}
+
+// Close drains the NATS connection, so the acks of flushed messages reach
+// the server, and waits for it to close until ctx is done
+func (s *NATSSource) Close(ctx context.Context) {
+	if s.conn == nil {
+		return
+	}
+	if err := s.conn.Drain(); err != nil {
+		s.conn.Close()
+		return
+	}
+	select {
+	case <-s.closed:
+	case <-ctx.Done():
+		s.conn.Close()
+	}
+}
+
+func (s *NATSSource) handleFrontend(msg jetstream.Msg) {
+	var batch model.EventBatch
+	if err := json.Unmarshal(msg.Data(), &batch); err != nil {
+		slog.Warn("invalid nats frontend payload", "subject", msg.Subject(), "error", err)
+		msg.Term()
+		return
+	}
+
+	kept := residentEvents(s.residency, batch.Events)
+	kept = keep(kept, func(e *model.FrontendEvent) bool { return s.kills == nil || !s.kills.Discard(e.SiteID, e.EventType) })
+	over := overQuota(s.usage, len(msg.Data()), "frontend", kept, func(e *model.FrontendEvent) string { return e.SiteID })
+	kept = keep(kept, func(e *model.FrontendEvent) bool { return !over[e.SiteID] })
+
+	events := s.prepareEvents(kept)
+	if len(events) == 0 {
+		msg.Ack()
+		return
+	}
+
+	ack := newMsgAck(msg, len(events))
+	batchID := msgBatchID(msg)
+	for _, event := range events {
+		s.collector.PushWithAck(batchID, event, ack.done)
+	}
+}
+
+// prepareEvents applies the field size policies and time clamp of the HTTP
+// frontend endpoint and runs the enrichment pipeline of each site. Messages
+// carry no client address or headers, so stages depending on them leave
+// events as they are.
+func (s *NATSSource) prepareEvents(events []model.FrontendEvent) []model.EnrichedEvent {
+	now := time.Now().UTC()
+	bySite := make(map[string][]model.EnrichedEvent)
+	var sites []string
+	for _, event := range events {
+		if !s.limits.AdmitFrontend(event.SiteID, &event, now) {
+			continue
+		}
+		if _, ok := bySite[event.SiteID]; !ok {
+			sites = append(sites, event.SiteID)
+		}
+		bySite[event.SiteID] = append(bySite[event.SiteID], model.EnrichedEvent{FrontendEvent: event})
+	}
+
+	var enriched []model.EnrichedEvent
+	for _, site := range sites {
+		enriched = append(enriched, s.pipeline.Run(site, enrich.Request{}, bySite[site])...)
+	}
+	return enriched
+}
+
+// handleMetrics queues a backend metrics message on its collector, acking it
+// once every metric has been flushed
+func handleMetrics[T any](s *NATSSource, c *collector.Collector[T], metricType string) func(jetstream.Msg) {
+	return func(msg jetstream.Msg) {
+		var batch struct {
+			Metrics []T `json:"metrics"`
+		}
+		if err := json.Unmarshal(msg.Data(), &batch); err != nil {
+			slog.Warn("invalid nats metrics payload", "subject", msg.Subject(), "error", err)
+			msg.Term()
+			return
+		}
+
+		if len(batch.Metrics) == 0 {
+			msg.Ack()
+			return
+		}
+
+		kept := residentMetrics(s.residency, validMetrics(batch.Metrics))
+		kept = keep(kept, func(m *T) bool { return s.kills == nil || !s.kills.Discard(metricSite(m), metricType) })
+		over := overQuota(s.usage, len(msg.Data()), metricType, kept, func(m *T) string { return metricSite(m) })
+		kept = keep(kept, func(m *T) bool { return !over[metricSite(m)] })
+
+		batch.Metrics = admitMetrics(s.limits, metricType, kept)
+		if len(batch.Metrics) == 0 {
+			msg.Ack()
+			return
+		}
+
+		ack := newMsgAck(msg, len(batch.Metrics))
+		batchID := msgBatchID(msg)
+		for _, m := range batch.Metrics {
+			c.PushWithAck(batchID, m, ack.done)
+		}
+	}
+}
+
+// msgBatchID traces a message by its stream sequence, which stays the same
+// across redeliveries
+func msgBatchID(msg jetstream.Msg) string {
+	md, err := msg.Metadata()
+	if err != nil {
+		return trace.NewID()
+	}
+	return fmt.Sprintf("nats-%d", md.Sequence.Stream)
+}
+
+// validMetrics drops metrics the HTTP collect handlers would reject, so a
+// single bad metric cannot fail the flush of its batch
+func validMetrics[T any](metrics []T) []T {
+	valid := metrics[:0]
+	for i := range metrics {
+		if m, ok := any(&metrics[i]).(*model.PSPMetric); ok && !m.ValidState() {
+			slog.Warn("dropping psp metric with unknown state", "state", *m.State)
+			continue
+		}
+		valid = append(valid, metrics[i])
+	}
+	return valid
+}
+
+// keep returns the items f keeps, reusing the backing array of items
+func keep[T any](items []T, f func(*T) bool) []T {
+	kept := items[:0]
+	for i := range items {
+		if f(&items[i]) {
+			kept = append(kept, items[i])
+		}
+	}
+	return kept
+}
+
+// overQuota accounts the events of a message of size bytes in the usage of
+// their sites, splitting the bytes in proportion to their events, and
+// returns the sites over their daily quota
+func overQuota[T any](usage Usage, bytes int, metricType string, events []T, site func(*T) string) map[string]bool {
+	if usage == nil || len(events) == 0 {
+		return nil
+	}
+	counts := make(map[string]int)
+	var sites []string
+	for i := range events {
+		s := site(&events[i])
+		if counts[s] == 0 {
+			sites = append(sites, s)
+		}
+		counts[s]++
+	}
+
+	var over map[string]bool
+	for _, s := range sites {
+		share := int64(bytes) * int64(counts[s]) / int64(len(events))
+		if usage.Admit(s, metricType, counts[s], share) {
+			continue
+		}
+		if over == nil {
+			over = make(map[string]bool)
+		}
+		over[s] = true
+		slog.Debug("dropping nats events of site over its daily quota", "site_id", s, "metric_type", metricType, "events", counts[s])
+	}
+	return over
+}
+
+// residentEvents drops events of sites resident in another region
+func residentEvents(residency Residency, events []model.FrontendEvent) []model.FrontendEvent {
+	if residency == nil {
+		return events
+	}
+	kept := events[:0]
+	for _, e := range events {
+		if region, ok := residency.Allowed(e.SiteID); !ok {
+			residency.Drop(e.SiteID, 1)
+			slog.Debug("dropping nats event of site resident elsewhere", "site_id", e.SiteID, "region", region)
+			continue
+		}
+		kept = append(kept, e)
+	}
+	return kept
+}
+
+// residentMetrics drops metrics of sites resident in another region
+func residentMetrics[T any](residency Residency, metrics []T) []T {
+	if residency == nil {
+		return metrics
+	}
+	kept := metrics[:0]
+	for i := range metrics {
+		site := metricSite(&metrics[i])
+		if region, ok := residency.Allowed(site); !ok {
+			residency.Drop(site, 1)
+			slog.Debug("dropping nats metric of site resident elsewhere", "site_id", site, "region", region)
+			continue
+		}
+		kept = append(kept, metrics[i])
+	}
+	return kept
+}
+
+func metricSite(m any) string {
+	switch m := m.(type) {
+	case *model.APIMetric:
+		return m.SiteID
+	case *model.PSPMetric:
+		return m.SiteID
+	case *model.GameMetric:
+		return m.SiteID
+	case *model.WebSocketMetric:
+		return m.SiteID
+	}
+	return ""
+}
+
+// admitMetrics drops metrics older than the site's maximum event age or
+// breaking its field size policies, and stamps missing times, matching the
+// HTTP collect handlers
+func admitMetrics[T any](limits *quality.Limits, metricType string, metrics []T) []T {
+	now := time.Now().UTC()
+	kept := metrics[:0]
+	for i := range metrics {
+		var ok bool
+		switch m := any(&metrics[i]).(type) {
+		case *model.APIMetric:
+			ok = limits.Admit(m.SiteID, metricType, now, &m.Time, &m.Metadata, &m.ErrorMessage)
+		case *model.PSPMetric:
+			ok = limits.Admit(m.SiteID, metricType, now, &m.Time, &m.Metadata, &m.ErrorMessage)
+		case *model.GameMetric:
+			ok = limits.Admit(m.SiteID, metricType, now, &m.Time, &m.Metadata, &m.ErrorMessage)
+		case *model.WebSocketMetric:
+			ok = limits.Admit(m.SiteID, metricType, now, &m.Time, &m.Metadata, nil)
+		}
+		if ok {
+			kept = append(kept, metrics[i])
+		}
+	}
+	return kept
+}
+
+// msgAck acks a message once all of its events have been flushed, or nacks
+// it as soon as any of them fails (terminates it if the failure is
+// permanent, see collector.Permanent)
+type msgAck struct {
+	msg       jetstream.Msg
+	remaining atomic.Int64
+	failOnce  sync.Once
+	failed    atomic.Bool
+}
+
+func newMsgAck(msg jetstream.Msg, events int) *msgAck {
+	a := &msgAck{msg: msg}
+	a.remaining.Store(int64(events))
+	return a
+}
+
+func (a *msgAck) done(err error) {
+	if err != nil {
+		a.failOnce.Do(func() {
+			a.failed.Store(true)
+			// Redelivering rows the schema rejected would fail again
+			if collector.Permanent(err) {
+				slog.Warn("terminating nats message with rejected events", "subject", a.msg.Subject(), "error", err)
+				a.msg.Term()
+				return
+			}
+			a.msg.Nak()
+		})
+	}
+
+	if a.remaining.Add(-1) == 0 && !a.failed.Load() {
+		a.msg.Ack()
+	}
+}
@@ -0,0 +1,94 @@
+package ingest
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mcbile/product-pulse/internal/enrich"
+	"github.com/mcbile/product-pulse/internal/model"
+	"github.com/mcbile/product-pulse/internal/quality"
+)
+
+func TestPrepareEventsMatchesHTTP(t *testing.T) {
+	limits, err := quality.ParsePolicies([]string{"casino-b/metadata=reject:16"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pipeline, err := enrich.Parse([]string{"path_template", "casino-b/path_template=off"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewNATSSource(NATSConfig{}, nil, nil, limits, pipeline)
+
+	skewed := time.Now().Add(-3 * time.Hour)
+	events := s.prepareEvents([]model.FrontendEvent{
+		{SiteID: "casino-a", PagePath: "/game/12345", Time: skewed},
+		{SiteID: "casino-b", PagePath: "/game/12345", Metadata: json.RawMessage(`{"note":"` + strings.Repeat("x", 32) + `"}`)},
+		{SiteID: "casino-b", PagePath: "/game/12345"},
+	})
+
+	if len(events) != 2 {
+		t.Fatalf("got %d events, want the oversized one rejected", len(events))
+	}
+	a, b := events[0].FrontendEvent, events[1].FrontendEvent
+	if a.PagePath != "/game/:id" || b.PagePath != "/game/12345" {
+		t.Errorf("paths %q and %q, want the pipeline of each site", a.PagePath, b.PagePath)
+	}
+	if a.Time.Equal(skewed) || b.Time.IsZero() {
+		t.Errorf("times %s and %s not clamped", a.Time, b.Time)
+	}
+	if a.Country == nil || b.Country == nil {
+		t.Error("missing country not marked for the geoip stage")
+	}
+}
+
+func TestAdmitMetricsMatchesHTTP(t *testing.T) {
+	limits, err := quality.ParsePolicies(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := limits.SetMaxEventAges([]string{"1h"}); err != nil {
+		t.Fatal(err)
+	}
+
+	metrics := admitMetrics(limits, "api", []model.APIMetric{
+		{SiteID: "casino-a", Endpoint: "/old", Time: time.Now().Add(-2 * time.Hour)},
+		{SiteID: "casino-a", Endpoint: "/new"},
+	})
+	if len(metrics) != 1 || metrics[0].Endpoint != "/new" || metrics[0].Time.IsZero() {
+		t.Errorf("got %+v, want only the fresh metric, stamped", metrics)
+	}
+}
+
+type memoryUsage struct {
+	over   map[string]bool
+	events map[string]int
+	bytes  map[string]int64
+}
+
+func (u *memoryUsage) Admit(site, metricType string, events int, bytes int64) bool {
+	if u.over[site] {
+		return false
+	}
+	u.events[site] += events
+	u.bytes[site] += bytes
+	return true
+}
+
+func TestOverQuotaSplitsMessagesBySite(t *testing.T) {
+	usage := &memoryUsage{over: map[string]bool{"casino-b": true}, events: map[string]int{}, bytes: map[string]int64{}}
+	metrics := []model.APIMetric{{SiteID: "casino-a"}, {SiteID: "casino-b"}, {SiteID: "casino-a"}, {SiteID: "casino-c"}}
+
+	over := overQuota(usage, 400, "api", metrics, func(m *model.APIMetric) string { return m.SiteID })
+	if len(over) != 1 || !over["casino-b"] {
+		t.Errorf("over quota %v, want casino-b", over)
+	}
+	if usage.events["casino-a"] != 2 || usage.bytes["casino-a"] != 200 || usage.bytes["casino-c"] != 100 {
+		t.Errorf("accounted %v events, %v bytes", usage.events, usage.bytes)
+	}
+	if usage.events["casino-b"] != 0 {
+		t.Error("events over quota accounted")
+	}
+}
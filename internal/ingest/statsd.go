@@ -0,0 +1,204 @@
+package ingest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mcbile/product-pulse/internal/collector"
+	"github.com/mcbile/product-pulse/internal/model"
+	"github.com/mcbile/product-pulse/internal/trace"
+)
+
+// StatsDConfig for the StatsD UDP listener
+type StatsDConfig struct {
+	Addr string // UDP listen address, e.g. ":8125"
+}
+
+// StatsDListener accepts statsd/DogStatsD timer lines over UDP and stores
+// them as API metrics, for services that cannot adopt the Go client:
+//
+//	api.login.duration:123|ms|#service:wallet,method:POST,status:200
+//
+// The metric name maps to the endpoint ("api." and ".duration" are
+// stripped, remaining dots become slashes: /login). Known tags fill the
+// APIMetric fields, the rest go to metadata. Only timers (ms, h, d) are
+// accepted; other metric types are counted as dropped. Parsed metrics are
+// queued on the API collector; delivery is fire-and-forget, so metrics that
+// cannot be queued or persisted are only counted as dropped.
+type StatsDListener struct {
+	config    StatsDConfig
+	collector *collector.Collector[model.APIMetric]
+
+	conn net.PacketConn
+	wg   sync.WaitGroup
+
+	received atomic.Int64
+	dropped  atomic.Int64
+}
+
+// NewStatsDListener creates a new StatsD UDP listener
+func NewStatsDListener(config StatsDConfig, c *collector.Collector[model.APIMetric]) *StatsDListener {
+	return &StatsDListener{
+		config:    config,
+		collector: c,
+	}
+}
+
+// Start binds the UDP socket and begins reading packets
+func (l *StatsDListener) Start(ctx context.Context) error {
+	conn, err := net.ListenPacket("udp", l.config.Addr)
+	if err != nil {
+		return fmt.Errorf("statsd listen: %w", err)
+	}
+	l.conn = conn
+
+	l.wg.Add(1)
+	go l.readLoop()
+
+	slog.Info("statsd listener started", "addr", conn.LocalAddr().String())
+	return nil
+}
+
+// Stop closes the socket. Queued metrics are flushed by the API collector.
+func (l *StatsDListener) Stop() {
+	if l.conn == nil {
+		return
+	}
+	l.conn.Close()
+	l.wg.Wait()
+
+	slog.Info("statsd listener stopped",
+		"received", l.received.Load(),
+		"dropped", l.dropped.Load(),
+	)
+}
+
+func (l *StatsDListener) readLoop() {
+	defer l.wg.Done()
+
+	buf := make([]byte, 65535)
+	for {
+		n, _, err := l.conn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			slog.Warn("statsd read failed", "error", err)
+			continue
+		}
+
+		now := time.Now().UTC()
+		batchID := trace.NewID()
+		for _, line := range strings.Split(string(buf[:n]), "\n") {
+			line = strings.TrimSpace(line)
+			if line == "" {
+				continue
+			}
+			metric, err := parseStatsDLine(line, now)
+			if err != nil {
+				l.dropped.Add(1)
+				slog.Debug("invalid statsd line", "line", line, "error", err)
+				continue
+			}
+			l.received.Add(1)
+			l.collector.PushWithAck(batchID, metric, l.ack)
+		}
+	}
+}
+
+// ack counts metrics that could not be queued or persisted
+func (l *StatsDListener) ack(err error) {
+	if err != nil {
+		l.dropped.Add(1)
+	}
+}
+
+// parseStatsDLine parses name:value|type[|@rate][|#tag:value,...]
+func parseStatsDLine(line string, now time.Time) (model.APIMetric, error) {
+	var m model.APIMetric
+
+	name, rest, ok := strings.Cut(line, ":")
+	if !ok || name == "" {
+		return m, errors.New("missing metric name")
+	}
+
+	fields := strings.Split(rest, "|")
+	if len(fields) < 2 {
+		return m, errors.New("missing metric type")
+	}
+
+	switch fields[1] {
+	case "ms", "h", "d":
+	default:
+		return m, fmt.Errorf("unsupported metric type %q", fields[1])
+	}
+
+	value, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil || value < 0 {
+		return m, fmt.Errorf("invalid value %q", fields[0])
+	}
+
+	m.Time = now
+	m.DurationMS = value
+	m.Method = "GET"
+	m.StatusCode = 200
+	m.Endpoint = statsDEndpoint(name)
+
+	metadata := map[string]string{"source": "statsd", "metric": name}
+	for _, f := range fields[2:] {
+		if !strings.HasPrefix(f, "#") {
+			continue // sample rate and extensions
+		}
+		for _, tag := range strings.Split(f[1:], ",") {
+			k, v, _ := strings.Cut(tag, ":")
+			if k == "" {
+				continue
+			}
+			switch k {
+			case "service":
+				m.ServiceName = v
+			case "endpoint":
+				m.Endpoint = v
+			case "method":
+				m.Method = strings.ToUpper(v)
+			case "status":
+				code, err := strconv.Atoi(v)
+				if err != nil {
+					return m, fmt.Errorf("invalid status tag %q", v)
+				}
+				m.StatusCode = code
+			case "player_id":
+				m.PlayerID = &v
+			case "request_id":
+				m.RequestID = &v
+			case "error_type":
+				m.ErrorType = &v
+			default:
+				metadata[k] = v
+			}
+		}
+	}
+
+	if m.ServiceName == "" {
+		return m, errors.New("missing service tag")
+	}
+
+	m.Metadata, _ = json.Marshal(metadata)
+	return m, nil
+}
+
+// statsDEndpoint maps api.auth.login.duration to /auth/login
+func statsDEndpoint(name string) string {
+	name = strings.TrimPrefix(name, "api.")
+	name = strings.TrimSuffix(name, ".duration")
+	return "/" + strings.ReplaceAll(name, ".", "/")
+}
@@ -0,0 +1,128 @@
+package jobs
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule computes when a job runs next
+type Schedule interface {
+	Next(after time.Time) time.Time
+}
+
+// Every returns the schedule spec for a fixed interval
+func Every(d time.Duration) string {
+	return "@every " + d.String()
+}
+
+// ParseSchedule parses "@every <duration>", "@hourly", "@daily" or a
+// five-field cron expression (minute hour day-of-month month day-of-week)
+// evaluated in UTC, e.g. "*/15 * * * *" or "0 3 * * 1-5". Unlike classic
+// cron, a time must match both day-of-month and day-of-week.
+func ParseSchedule(spec string) (Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	switch {
+	case strings.HasPrefix(spec, "@every "):
+		d, err := time.ParseDuration(strings.TrimSpace(strings.TrimPrefix(spec, "@every ")))
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid interval in schedule %q", spec)
+		}
+		return interval(d), nil
+	case spec == "@hourly":
+		spec = "0 * * * *"
+	case spec == "@daily":
+		spec = "0 0 * * *"
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid schedule %q, expected @every <duration> or 5 cron fields", spec)
+	}
+
+	bounds := [5][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 6}}
+	var c cron
+	sets := []*uint64{&c.minute, &c.hour, &c.dom, &c.month, &c.dow}
+	for i, f := range fields {
+		set, err := parseField(f, bounds[i][0], bounds[i][1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid schedule %q: %w", spec, err)
+		}
+		*sets[i] = set
+	}
+	return c, nil
+}
+
+type interval time.Duration
+
+func (d interval) Next(after time.Time) time.Time {
+	return after.Add(time.Duration(d))
+}
+
+// cron holds one bit per allowed value of each field
+type cron struct {
+	minute, hour, dom, month, dow uint64
+}
+
+func (c cron) Next(after time.Time) time.Time {
+	t := after.UTC().Truncate(time.Minute).Add(time.Minute)
+	// Every combination repeats within four years (leap days)
+	limit := t.AddDate(4, 0, 0)
+	for t.Before(limit) {
+		switch {
+		case c.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+		case c.dom&(1<<uint(t.Day())) == 0 || c.dow&(1<<uint(t.Weekday())) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.UTC)
+		case c.hour&(1<<uint(t.Hour())) == 0:
+			t = t.Truncate(time.Hour).Add(time.Hour)
+		case c.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// parseField parses a comma separated list of *, n, a-b, with optional /step
+func parseField(field string, min, max int) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(field, ",") {
+		rng, stepStr, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepStr)
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("invalid step %q", part)
+			}
+			step = n
+		}
+
+		lo, hi := min, max
+		if rng != "*" {
+			a, b, isRange := strings.Cut(rng, "-")
+			var err error
+			if lo, err = strconv.Atoi(a); err != nil {
+				return 0, fmt.Errorf("invalid value %q", part)
+			}
+			hi = lo
+			if isRange {
+				if hi, err = strconv.Atoi(b); err != nil {
+					return 0, fmt.Errorf("invalid value %q", part)
+				}
+			} else if hasStep {
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("value %q out of range %d-%d", part, min, max)
+		}
+
+		for v := lo; v <= hi; v += step {
+			set |= 1 << uint(v)
+		}
+	}
+	return set, nil
+}
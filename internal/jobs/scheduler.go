@@ -0,0 +1,321 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/mcbile/product-pulse/internal/storage"
+	"github.com/mcbile/product-pulse/pkg/clock"
+)
+
+// AlertType used for job failure alerts in alert_events
+const AlertType = "job_failure"
+
+// ErrUnknownJob is returned for operations on jobs that are not registered
+var ErrUnknownJob = errors.New("unknown job")
+
+// Storage is the subset of storage used by the scheduler
+type Storage interface {
+	EnsureJob(ctx context.Context, name, schedule string) (storage.JobRow, error)
+	RecordJobRun(ctx context.Context, run storage.JobRun) (storage.JobRow, error)
+	SetJobPaused(ctx context.Context, name string, paused bool) error
+	GetJobs(ctx context.Context) ([]storage.JobRow, error)
+	InsertAlert(ctx context.Context, alert storage.AlertRow) error
+	HasOpenAlert(ctx context.Context, alertType, metricName string) (bool, error)
+	ResolveAlerts(ctx context.Context, alertType, metricName string) error
+}
+
+// Job is a unit of periodic work
+type Job struct {
+	Name     string
+	Schedule string        // See ParseSchedule
+	Timeout  time.Duration // Per run; defaults to Config.Timeout
+	Run      func(ctx context.Context) error
+}
+
+// Config for the scheduler
+type Config struct {
+	Tick             time.Duration // How often due jobs are checked
+	Timeout          time.Duration // Default per-run timeout
+	FailureThreshold int           // Consecutive failures before an alert fires
+	Clock            clock.Clock   // Time source for schedules and runs; nil uses the system clock
+}
+
+// Status is a job definition with its live scheduling state
+type Status struct {
+	storage.JobRow
+	Registered bool       `json:"registered"` // False for jobs no longer registered by this build
+	Running    bool       `json:"running"`
+	NextRunAt  *time.Time `json:"next_run_at,omitempty"`
+}
+
+type entry struct {
+	job      Job
+	schedule Schedule
+	paused   bool
+	next     time.Time
+	running  bool
+}
+
+// Scheduler runs registered jobs on their schedules. Definitions, the
+// paused flag and the last run of every job are persisted in
+// scheduled_jobs, so they survive restarts and can be inspected and
+// controlled through /api/jobs. A job never overlaps with itself.
+type Scheduler struct {
+	config  Config
+	storage Storage
+
+	mu   sync.Mutex
+	jobs map[string]*entry
+	ctx  context.Context
+	wg   sync.WaitGroup
+}
+
+// NewScheduler creates a new job scheduler
+func NewScheduler(config Config, storage Storage) *Scheduler {
+	if config.Tick <= 0 {
+		config.Tick = time.Second
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = 5 * time.Minute
+	}
+	if config.FailureThreshold <= 0 {
+		config.FailureThreshold = 3
+	}
+	config.Clock = clock.OrReal(config.Clock)
+	return &Scheduler{
+		config:  config,
+		storage: storage,
+		jobs:    make(map[string]*entry),
+	}
+}
+
+// Register adds a job and persists its definition. It must be called
+// before Start.
+func (s *Scheduler) Register(ctx context.Context, job Job) error {
+	schedule, err := ParseSchedule(job.Schedule)
+	if err != nil {
+		return fmt.Errorf("job %s: %w", job.Name, err)
+	}
+	if job.Timeout <= 0 {
+		job.Timeout = s.config.Timeout
+	}
+
+	row, err := s.storage.EnsureJob(ctx, job.Name, job.Schedule)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.jobs[job.Name] = &entry{
+		job:      job,
+		schedule: schedule,
+		paused:   row.Paused,
+		next:     schedule.Next(s.config.Clock.Now()),
+	}
+	s.mu.Unlock()
+	return nil
+}
+
+// Start runs due jobs until ctx is cancelled
+func (s *Scheduler) Start(ctx context.Context) {
+	s.mu.Lock()
+	s.ctx = ctx
+	names := make([]string, 0, len(s.jobs))
+	for name := range s.jobs {
+		names = append(names, name)
+	}
+	s.mu.Unlock()
+
+	go func() {
+		ticker := s.config.Clock.NewTicker(s.config.Tick)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case now := <-ticker.C():
+				s.runDue(now)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	slog.Info("job scheduler started", "jobs", names)
+}
+
+// Wait blocks until running jobs have finished
+func (s *Scheduler) Wait() {
+	s.wg.Wait()
+}
+
+func (s *Scheduler) runDue(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, e := range s.jobs {
+		if e.paused || e.running || now.Before(e.next) {
+			continue
+		}
+		e.next = e.schedule.Next(now)
+		s.launch(e)
+	}
+}
+
+// launch starts a run; s.mu must be held
+func (s *Scheduler) launch(e *entry) {
+	e.running = true
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.run(e)
+
+		s.mu.Lock()
+		e.running = false
+		s.mu.Unlock()
+	}()
+}
+
+func (s *Scheduler) run(e *entry) {
+	ctx, cancel := context.WithTimeout(s.ctx, e.job.Timeout)
+	defer cancel()
+
+	start := s.config.Clock.Now().UTC()
+	err := runJob(ctx, e.job)
+	duration := s.config.Clock.Since(start)
+
+	if err != nil {
+		slog.Error("job failed", "job", e.job.Name, "duration_ms", duration.Milliseconds(), "error", err)
+	} else {
+		slog.Debug("job finished", "job", e.job.Name, "duration_ms", duration.Milliseconds())
+	}
+
+	// Bookkeeping must not be cut short by the job's own timeout
+	bctx, bcancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer bcancel()
+
+	row, rerr := s.storage.RecordJobRun(bctx, storage.JobRun{
+		Name:     e.job.Name,
+		Start:    start,
+		Duration: duration,
+		Err:      err,
+	})
+	if rerr != nil {
+		slog.Error("failed to record job run", "job", e.job.Name, "error", rerr)
+		return
+	}
+	if aerr := s.updateAlert(bctx, row); aerr != nil {
+		slog.Error("failed to update job alert", "job", e.job.Name, "error", aerr)
+	}
+}
+
+// runJob converts a panic in a job into an error
+func runJob(ctx context.Context, job Job) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return job.Run(ctx)
+}
+
+// updateAlert fires an alert once a job has failed FailureThreshold times
+// in a row and resolves it after the next successful run
+func (s *Scheduler) updateAlert(ctx context.Context, row storage.JobRow) error {
+	open, err := s.storage.HasOpenAlert(ctx, AlertType, row.Name)
+	if err != nil {
+		return err
+	}
+
+	failing := row.ConsecutiveFailures >= s.config.FailureThreshold
+	switch {
+	case failing && !open:
+		msg := fmt.Sprintf("Job %s failed %d times in a row", row.Name, row.ConsecutiveFailures)
+		if row.LastError != nil {
+			msg += ": " + *row.LastError
+		}
+		return s.storage.InsertAlert(ctx, storage.AlertRow{
+			Time:           s.config.Clock.Now().UTC(),
+			AlertType:      AlertType,
+			Severity:       "warning",
+			SourceTable:    "scheduled_jobs",
+			MetricName:     row.Name,
+			ThresholdValue: float64(s.config.FailureThreshold),
+			ActualValue:    float64(row.ConsecutiveFailures),
+			Message:        msg,
+		})
+	case row.ConsecutiveFailures == 0 && open:
+		return s.storage.ResolveAlerts(ctx, AlertType, row.Name)
+	}
+	return nil
+}
+
+// RunNow starts a job immediately, even if it is paused. It returns false
+// if the job is already running.
+func (s *Scheduler) RunNow(name string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.jobs[name]
+	if !ok {
+		return false, ErrUnknownJob
+	}
+	if e.running || s.ctx == nil {
+		return false, nil
+	}
+	s.launch(e)
+	return true, nil
+}
+
+// SetPaused pauses or resumes a job
+func (s *Scheduler) SetPaused(ctx context.Context, name string, paused bool) error {
+	s.mu.Lock()
+	e, ok := s.jobs[name]
+	s.mu.Unlock()
+	if !ok {
+		return ErrUnknownJob
+	}
+
+	if err := s.storage.SetJobPaused(ctx, name, paused); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	e.paused = paused
+	if !paused {
+		e.next = e.schedule.Next(s.config.Clock.Now())
+	}
+	s.mu.Unlock()
+	return nil
+}
+
+// List returns all persisted jobs with their live state, including jobs
+// persisted by an earlier deployment that are no longer registered
+func (s *Scheduler) List(ctx context.Context) ([]Status, error) {
+	rows, err := s.storage.GetJobs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result := make([]Status, 0, len(rows))
+	for _, row := range rows {
+		st := Status{JobRow: row}
+		if e, ok := s.jobs[row.Name]; ok {
+			st.Registered = true
+			st.Running = e.running
+			if !e.paused {
+				next := e.next
+				st.NextRunAt = &next
+			}
+		}
+		result = append(result, st)
+	}
+	return result, nil
+}
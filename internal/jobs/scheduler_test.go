@@ -0,0 +1,147 @@
+package jobs
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mcbile/product-pulse/internal/storage"
+	"github.com/mcbile/product-pulse/pkg/clock"
+)
+
+// memoryStorage keeps job state in memory
+type memoryStorage struct {
+	mu   sync.Mutex
+	runs []storage.JobRun
+}
+
+func (m *memoryStorage) EnsureJob(ctx context.Context, name, schedule string) (storage.JobRow, error) {
+	return storage.JobRow{Name: name, Schedule: schedule}, nil
+}
+
+func (m *memoryStorage) RecordJobRun(ctx context.Context, run storage.JobRun) (storage.JobRow, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.runs = append(m.runs, run)
+	return storage.JobRow{Name: run.Name}, nil
+}
+
+func (m *memoryStorage) SetJobPaused(ctx context.Context, name string, paused bool) error {
+	return nil
+}
+
+func (m *memoryStorage) GetJobs(ctx context.Context) ([]storage.JobRow, error) {
+	return nil, nil
+}
+
+func (m *memoryStorage) InsertAlert(ctx context.Context, alert storage.AlertRow) error {
+	return nil
+}
+
+func (m *memoryStorage) HasOpenAlert(ctx context.Context, alertType, metricName string) (bool, error) {
+	return false, nil
+}
+
+func (m *memoryStorage) ResolveAlerts(ctx context.Context, alertType, metricName string) error {
+	return nil
+}
+
+func TestSchedulerRunsJobsWhenDue(t *testing.T) {
+	start := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	clk := clock.NewFake(start)
+	store := &memoryStorage{}
+	// Ticking once a minute, every tick is picked up before the next
+	s := NewScheduler(Config{Tick: time.Minute, Clock: clk}, store)
+
+	ran := make(chan time.Time, 4)
+	err := s.Register(context.Background(), Job{
+		Name:     "cleanup",
+		Schedule: Every(time.Minute),
+		Run: func(ctx context.Context) error {
+			ran <- clk.Now()
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s.Start(ctx)
+	clk.BlockUntil(1)
+
+	clk.Advance(59 * time.Second)
+	select {
+	case at := <-ran:
+		t.Fatalf("ran at %v, before it was due", at)
+	default:
+	}
+
+	clk.Advance(time.Second)
+	select {
+	case at := <-ran:
+		if !at.Equal(start.Add(time.Minute)) {
+			t.Errorf("ran at %v, want %v", at, start.Add(time.Minute))
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("job did not run when due")
+	}
+	s.Wait()
+
+	// The next run is a minute after the previous one
+	clk.Advance(59 * time.Second)
+	select {
+	case at := <-ran:
+		t.Fatalf("ran again at %v", at)
+	default:
+	}
+	clk.Advance(time.Second)
+	select {
+	case <-ran:
+	case <-time.After(5 * time.Second):
+		t.Fatal("job did not run again")
+	}
+	s.Wait()
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if len(store.runs) != 2 || !store.runs[0].Start.Equal(start.Add(time.Minute)) {
+		t.Fatalf("recorded runs %+v", store.runs)
+	}
+}
+
+func TestSchedulerSkipsPausedJobs(t *testing.T) {
+	clk := clock.NewFake(time.Now())
+	s := NewScheduler(Config{Tick: time.Second, Clock: clk}, &memoryStorage{})
+
+	ran := make(chan struct{}, 1)
+	err := s.Register(context.Background(), Job{
+		Name:     "rollup",
+		Schedule: Every(time.Minute),
+		Run: func(ctx context.Context) error {
+			ran <- struct{}{}
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetPaused(context.Background(), "rollup", true); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s.Start(ctx)
+	clk.BlockUntil(1)
+
+	clk.Advance(10 * time.Minute)
+	s.Wait()
+	select {
+	case <-ran:
+		t.Fatal("paused job ran")
+	default:
+	}
+}
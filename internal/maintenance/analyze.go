@@ -0,0 +1,245 @@
+package maintenance
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/mcbile/product-pulse/internal/trace"
+)
+
+// Analysis states
+const (
+	AnalysisRunning = "running"
+	AnalysisDone    = "done"
+	AnalysisFailed  = "failed"
+)
+
+// Analysis triggers
+const (
+	TriggerSchedule = "schedule"
+	TriggerManual   = "manual"
+)
+
+// Errors returned by Analyzer.Start
+var (
+	ErrUnknownTable    = errors.New("unknown table")
+	ErrAnalysisRunning = errors.New("an analysis is already running")
+)
+
+// Storage is the subset of storage used by the analyzer
+type Storage interface {
+	AnalyzeTable(ctx context.Context, table string, columns []string, target int) error
+}
+
+// Table is a table with the columns whose statistics target is raised
+type Table struct {
+	Name    string
+	Columns []string
+}
+
+// HotTables are the metric tables with the columns dashboards filter and
+// group by. Their value distributions are skewed (a few PSPs or services
+// carry most rows), and the default statistics target samples them too
+// coarsely once a backfill shifts them, so the planner misestimates
+// selectivity until autovacuum analyzes the table again.
+var HotTables = []Table{
+	{Name: "api_metrics", Columns: []string{"service_name", "endpoint", "status_code", "site_id"}},
+	{Name: "psp_metrics", Columns: []string{"psp_name", "operation", "site_id"}},
+	{Name: "game_metrics", Columns: []string{"provider", "game_id", "site_id"}},
+	{Name: "frontend_metrics", Columns: []string{"event_type", "page_path", "release", "site_id"}},
+	{Name: "websocket_metrics", Columns: []string{"event_type", "site_id"}},
+	{Name: "alert_events", Columns: []string{"alert_type", "metric_name", "target", "site_id"}},
+}
+
+// Tables returns the names of HotTables
+func Tables() []string {
+	names := make([]string, len(HotTables))
+	for i, t := range HotTables {
+		names[i] = t.Name
+	}
+	return names
+}
+
+// Config for the analyzer
+type Config struct {
+	StatisticsTarget int           // Statistics target of hot columns
+	Timeout          time.Duration // Per manual analysis; scheduled runs use the job's timeout
+}
+
+// TableResult is the outcome of analyzing one table
+type TableResult struct {
+	Table      string   `json:"table"`
+	Columns    []string `json:"columns"`
+	DurationMS float64  `json:"duration_ms"`
+	Error      string   `json:"error,omitempty"`
+}
+
+// Analysis is the progress of one analysis
+type Analysis struct {
+	ID               string        `json:"id"`
+	Trigger          string        `json:"trigger"`
+	Status           string        `json:"status"`
+	StatisticsTarget int           `json:"statistics_target"`
+	Tables           []TableResult `json:"tables"` // Analyzed so far
+	Pending          []string      `json:"pending"`
+	StartedAt        time.Time     `json:"started_at"`
+	FinishedAt       *time.Time    `json:"finished_at,omitempty"`
+}
+
+// Analyzer raises the statistics target of hot columns and runs ANALYZE on
+// their tables, on a schedule and on demand after large backfills, so query
+// plans do not wait for autovacuum to notice the new rows. One analysis
+// runs at a time and the last one is kept in memory.
+type Analyzer struct {
+	ctx     context.Context
+	cfg     Config
+	storage Storage
+
+	mu     sync.Mutex
+	last   *Analysis
+	active bool
+}
+
+// NewAnalyzer creates an analyzer; manual analyses stop when ctx is
+// cancelled
+func NewAnalyzer(ctx context.Context, cfg Config, storage Storage) *Analyzer {
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = time.Hour
+	}
+	return &Analyzer{ctx: ctx, cfg: cfg, storage: storage}
+}
+
+// Run analyzes every hot table; it is the scheduled job. A manual analysis
+// still running is left to finish.
+func (a *Analyzer) Run(ctx context.Context) error {
+	an, err := a.begin(TriggerSchedule, HotTables)
+	if errors.Is(err, ErrAnalysisRunning) {
+		slog.Info("table analysis skipped, another one is running")
+		return nil
+	}
+	return a.run(ctx, an, HotTables)
+}
+
+// Start begins analyzing the named hot tables, all of them when none are
+// named, in the background and returns its initial progress
+func (a *Analyzer) Start(names []string) (Analysis, error) {
+	tables := HotTables
+	if len(names) > 0 {
+		tables = nil
+		for _, name := range names {
+			t, ok := hotTable(name)
+			if !ok {
+				return Analysis{}, fmt.Errorf("%w: %s", ErrUnknownTable, name)
+			}
+			tables = append(tables, t)
+		}
+	}
+
+	an, err := a.begin(TriggerManual, tables)
+	if err != nil {
+		return Analysis{}, err
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(a.ctx, a.cfg.Timeout)
+		defer cancel()
+		a.run(ctx, an, tables)
+	}()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.snapshot(an), nil
+}
+
+// Last returns the running or last finished analysis
+func (a *Analyzer) Last() (Analysis, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.last == nil {
+		return Analysis{}, false
+	}
+	return a.snapshot(a.last), true
+}
+
+func hotTable(name string) (Table, bool) {
+	for _, t := range HotTables {
+		if t.Name == name {
+			return t, true
+		}
+	}
+	return Table{}, false
+}
+
+// begin records a new running analysis unless one is running
+func (a *Analyzer) begin(trigger string, tables []Table) (*Analysis, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.active {
+		return nil, ErrAnalysisRunning
+	}
+	an := &Analysis{
+		ID:               trace.NewID(),
+		Trigger:          trigger,
+		Status:           AnalysisRunning,
+		StatisticsTarget: a.cfg.StatisticsTarget,
+		Tables:           []TableResult{},
+		StartedAt:        time.Now().UTC(),
+	}
+	for _, t := range tables {
+		an.Pending = append(an.Pending, t.Name)
+	}
+	a.active = true
+	a.last = an
+	return an, nil
+}
+
+// run analyzes the tables one by one. A failed table does not stop the
+// others; the errors are returned together.
+func (a *Analyzer) run(ctx context.Context, an *Analysis, tables []Table) error {
+	slog.Info("table analysis started", "id", an.ID, "trigger", an.Trigger, "tables", len(tables))
+
+	var errs []error
+	for _, t := range tables {
+		start := time.Now()
+		err := a.storage.AnalyzeTable(ctx, t.Name, t.Columns, a.cfg.StatisticsTarget)
+		res := TableResult{
+			Table:      t.Name,
+			Columns:    t.Columns,
+			DurationMS: float64(time.Since(start).Microseconds()) / 1000,
+		}
+		if err != nil {
+			res.Error = err.Error()
+			errs = append(errs, err)
+			slog.Error("failed to analyze table", "table", t.Name, "error", err)
+		}
+
+		a.mu.Lock()
+		an.Tables = append(an.Tables, res)
+		an.Pending = an.Pending[1:]
+		a.mu.Unlock()
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	finished := time.Now().UTC()
+	an.FinishedAt = &finished
+	a.active = false
+	if len(errs) > 0 {
+		an.Status = AnalysisFailed
+		return errors.Join(errs...)
+	}
+	an.Status = AnalysisDone
+	slog.Info("table analysis finished", "id", an.ID, "duration", finished.Sub(an.StartedAt))
+	return nil
+}
+
+// snapshot copies an with its progress; a.mu must be held
+func (a *Analyzer) snapshot(an *Analysis) Analysis {
+	s := *an
+	s.Tables = append([]TableResult{}, an.Tables...)
+	s.Pending = append([]string{}, an.Pending...)
+	return s
+}
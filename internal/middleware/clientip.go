@@ -0,0 +1,116 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// TrustedProxies are the networks of proxies allowed to report the client
+// address of a request. Headers from other peers are ignored, so clients
+// cannot pick the address they are rate limited, audited or bound by.
+type TrustedProxies []*net.IPNet
+
+type clientIPKey struct{}
+
+// ParseTrustedProxies parses CIDR ranges and single addresses
+func ParseTrustedProxies(entries []string) (TrustedProxies, error) {
+	var proxies TrustedProxies
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q, expected an address or CIDR range", entry)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				bits = 32
+			}
+			proxies = append(proxies, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", entry, err)
+		}
+		proxies = append(proxies, network)
+	}
+	return proxies, nil
+}
+
+func (tp TrustedProxies) trusts(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, network := range tp {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Middleware resolves the client address of every request once, for
+// ClientIP
+func (tp TrustedProxies) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), clientIPKey{}, tp.resolve(r))))
+	})
+}
+
+// resolve returns the peer address of r, or, when the peer is a trusted
+// proxy, the first address in X-Forwarded-For that is not, read from the
+// right since clients can prepend anything
+func (tp TrustedProxies) resolve(r *http.Request) string {
+	addr := peerIP(r)
+	if !tp.trusts(addr) {
+		return addr
+	}
+
+	var hops []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(header, ",")...)
+	}
+	if len(hops) == 0 {
+		if xri := strings.TrimSpace(r.Header.Get("X-Real-IP")); net.ParseIP(xri) != nil {
+			return xri
+		}
+		return addr
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if net.ParseIP(hop) == nil {
+			break // A malformed hop ends the chain the proxies vouch for
+		}
+		addr = hop
+		if !tp.trusts(hop) {
+			break
+		}
+	}
+	return addr
+}
+
+// ClientIP returns the client address of r as resolved by
+// TrustedProxies.Middleware, or the peer address of requests it did not
+// see
+func ClientIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(clientIPKey{}).(string); ok {
+		return ip
+	}
+	return peerIP(r)
+}
+
+func peerIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return ip
+}
@@ -0,0 +1,50 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIPTrustsOnlyConfiguredProxies(t *testing.T) {
+	proxies, err := ParseTrustedProxies([]string{"10.0.0.0/8", "192.0.2.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name, remote, xff, realIP, want string
+	}{
+		{"direct client", "203.0.113.7:5000", "", "", "203.0.113.7"},
+		{"direct client forging the header", "203.0.113.7:5000", "198.51.100.1", "198.51.100.2", "203.0.113.7"},
+		{"via proxy", "10.1.2.3:5000", "203.0.113.7", "", "203.0.113.7"},
+		{"via proxy, prepended by the client", "10.1.2.3:5000", "198.51.100.1, 203.0.113.7", "", "203.0.113.7"},
+		{"via two proxies", "10.1.2.3:5000", "203.0.113.7, 192.0.2.1", "", "203.0.113.7"},
+		{"via proxy setting X-Real-IP", "10.1.2.3:5000", "", "203.0.113.7", "203.0.113.7"},
+		{"malformed hop", "10.1.2.3:5000", "203.0.113.7, junk", "", "10.1.2.3"},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = tc.remote
+		if tc.xff != "" {
+			r.Header.Set("X-Forwarded-For", tc.xff)
+		}
+		if tc.realIP != "" {
+			r.Header.Set("X-Real-IP", tc.realIP)
+		}
+		var got string
+		proxies.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got = ClientIP(r)
+		})).ServeHTTP(httptest.NewRecorder(), r)
+		if got != tc.want {
+			t.Errorf("%s: %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestParseTrustedProxiesRejectsInvalidEntries(t *testing.T) {
+	for _, entry := range []string{"proxy.internal", "10.0.0.0/33"} {
+		if _, err := ParseTrustedProxies([]string{entry}); err == nil {
+			t.Errorf("%q accepted", entry)
+		}
+	}
+}
@@ -0,0 +1,495 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mcbile/product-pulse/internal/storage"
+)
+
+// Credential headers on collect requests. Service accounts send their
+// token as "Authorization: Bearer <token>".
+const (
+	APIKeyHeader    = "X-Pulse-Key"
+	APIKeyAltHeader = "X-Api-Key"         // Accepted as well, for backend clients configured with the common name
+	SignatureHeader = "X-Pulse-Signature" // t=<unix seconds>,v1=<hex HMAC-SHA256 of "<t>.<body>">
+)
+
+// Service account and API key scopes, one per collect endpoint.
+// /collect/batch and /collect/backfill check the scope of every section
+// they carry.
+var Scopes = []string{"frontend", "api", "psp", "game", "ws", "register", "backfill"}
+
+// HealthScope lets a service account read /api/health/decision. Only
+// service accounts can hold it; site credentials never authenticate
+// dashboard API routes.
+const HealthScope = "health"
+
+// signatureTolerance bounds clock skew and replay of signed requests
+const signatureTolerance = 5 * time.Minute
+
+// ErrUnknownCredentialKind is returned by NewCredential for unsupported kinds
+var ErrUnknownCredentialKind = errors.New("unknown credential kind")
+
+// CredentialStorage loads credentials and service accounts and persists
+// their usage
+type CredentialStorage interface {
+	GetActiveCredentials(ctx context.Context) ([]storage.SiteCredential, error)
+	RecordCredentialUsage(ctx context.Context, usage []storage.CredentialUsage) error
+	GetActiveServiceAccounts(ctx context.Context) ([]storage.ServiceAccount, error)
+	RecordServiceAccountUsage(ctx context.Context, usage []storage.CredentialUsage) error
+}
+
+// SiteAuth checks credentials on collect endpoints. A request carrying a
+// service account token may only use the endpoints in the account's scopes
+// and needs no site credential. Otherwise API keys and request signatures
+// are checked for sites that have at least one credential, so sites can
+// adopt credentials one at a time. With requireKey, every backend collect
+// endpoint needs a credential or service account token, also for sites
+// without credentials. /collect/backfill, which bypasses the maximum event
+// age, always needs one. Credentials are cached and reloaded periodically;
+// usage is aggregated in memory and flushed with the reload.
+type SiteAuth struct {
+	storage    CredentialStorage
+	interval   time.Duration
+	requireKey bool
+
+	mu       sync.RWMutex
+	bySite   map[string][]storage.SiteCredential
+	accounts map[string]storage.ServiceAccount // By token hash
+
+	usageMu      sync.Mutex
+	usage        map[int64]*storage.CredentialUsage
+	accountUsage map[int64]*storage.CredentialUsage
+}
+
+type scopesKey struct{}
+
+type verifiedClientKey struct{}
+
+// verifiedClient is who SiteAuth authenticated a collect request as, for
+// rate limit buckets that must not follow unchecked headers
+type verifiedClient struct {
+	site       string
+	credential string // "cred/<id>" or "sa/<id>"
+}
+
+func withVerifiedClient(r *http.Request, site, credential string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), verifiedClientKey{}, verifiedClient{site: site, credential: credential}))
+}
+
+// NewSiteAuth creates a new collect request authenticator
+func NewSiteAuth(store CredentialStorage, interval time.Duration, requireKey bool) *SiteAuth {
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+	return &SiteAuth{
+		storage:    store,
+		interval:   interval,
+		requireKey: requireKey,
+		bySite:     make(map[string][]storage.SiteCredential),
+		accounts:   make(map[string]storage.ServiceAccount),
+
+		usage:        make(map[int64]*storage.CredentialUsage),
+		accountUsage: make(map[int64]*storage.CredentialUsage),
+	}
+}
+
+// Start loads credentials, then reloads them and flushes usage until ctx
+// is cancelled
+func (sa *SiteAuth) Start(ctx context.Context) error {
+	if err := sa.Reload(ctx); err != nil {
+		return err
+	}
+
+	go func() {
+		ticker := time.NewTicker(sa.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				sa.Flush(ctx)
+				if err := sa.Reload(ctx); err != nil {
+					slog.Error("failed to reload site credentials", "error", err)
+				}
+			case <-ctx.Done():
+				sa.Flush(context.Background())
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// Reload replaces the cached credentials and service accounts with the
+// active ones in storage
+func (sa *SiteAuth) Reload(ctx context.Context) error {
+	creds, err := sa.storage.GetActiveCredentials(ctx)
+	if err != nil {
+		return err
+	}
+	accounts, err := sa.storage.GetActiveServiceAccounts(ctx)
+	if err != nil {
+		return err
+	}
+
+	bySite := make(map[string][]storage.SiteCredential)
+	for _, c := range creds {
+		bySite[c.SiteID] = append(bySite[c.SiteID], c)
+	}
+	byHash := make(map[string]storage.ServiceAccount, len(accounts))
+	for _, a := range accounts {
+		byHash[a.TokenHash] = a
+	}
+
+	sa.mu.Lock()
+	sa.bySite = bySite
+	sa.accounts = byHash
+	sa.mu.Unlock()
+	return nil
+}
+
+// Flush writes pending usage to storage
+func (sa *SiteAuth) Flush(ctx context.Context) {
+	sa.usageMu.Lock()
+	usage := drainUsage(sa.usage)
+	accountUsage := drainUsage(sa.accountUsage)
+	sa.usage = make(map[int64]*storage.CredentialUsage)
+	sa.accountUsage = make(map[int64]*storage.CredentialUsage)
+	sa.usageMu.Unlock()
+
+	if err := sa.storage.RecordCredentialUsage(ctx, usage); err != nil {
+		slog.Error("failed to record credential usage", "credentials", len(usage), "error", err)
+	}
+	if err := sa.storage.RecordServiceAccountUsage(ctx, accountUsage); err != nil {
+		slog.Error("failed to record service account usage", "accounts", len(accountUsage), "error", err)
+	}
+}
+
+func drainUsage(pending map[int64]*storage.CredentialUsage) []storage.CredentialUsage {
+	usage := make([]storage.CredentialUsage, 0, len(pending))
+	for _, u := range pending {
+		usage = append(usage, *u)
+	}
+	return usage
+}
+
+// touch counts a use of a site credential, or of a service account if
+// account is set
+func (sa *SiteAuth) touch(id int64, account bool) {
+	sa.usageMu.Lock()
+	defer sa.usageMu.Unlock()
+
+	pending := sa.usage
+	if account {
+		pending = sa.accountUsage
+	}
+	u, ok := pending[id]
+	if !ok {
+		u = &storage.CredentialUsage{ID: id}
+		pending[id] = u
+	}
+	u.Count++
+	u.LastUsedAt = time.Now().UTC()
+}
+
+// Middleware returns HTTP middleware that rejects collect requests with an
+// unknown or out-of-scope service account token or API key, and requests of
+// sites with credentials unless they carry a valid API key or signature.
+// CSP reports are sent by browsers without custom headers and are exempt;
+// so are frontend events when keys are required, as browsers cannot keep
+// a key secret.
+func (sa *SiteAuth) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || !strings.HasPrefix(r.URL.Path, "/collect") || r.URL.Path == "/collect/csp" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		siteID := r.Header.Get("X-Site-Id")
+		if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+			sa.serveServiceAccount(w, r, next, siteID, strings.TrimSpace(token))
+			return
+		}
+
+		sa.mu.RLock()
+		creds := sa.bySite[siteID]
+		sa.mu.RUnlock()
+		if len(creds) == 0 {
+			if (sa.requireKey && r.URL.Path != "/collect") || r.URL.Path == "/collect/backfill" {
+				http.Error(w, "api key required", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cred, ok, err := verifyCredential(r, creds, time.Now())
+		if err != nil {
+			http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		if !ok {
+			http.Error(w, "invalid credentials", http.StatusUnauthorized)
+			return
+		}
+		sa.touch(cred.ID, false)
+		r = withVerifiedClient(r, siteID, "cred/"+strconv.FormatInt(cred.ID, 10))
+
+		// Scoped credentials are checked like service accounts
+		if len(cred.Scopes) > 0 {
+			if scope := collectScope(r.URL.Path); scope != "" && !hasScope(cred.Scopes, scope) {
+				http.Error(w, "api key scope does not allow "+r.URL.Path, http.StatusForbidden)
+				return
+			}
+			r = r.WithContext(context.WithValue(r.Context(), scopesKey{}, cred.Scopes))
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// serveServiceAccount authenticates a request by service account token and
+// checks the endpoint against the account's scopes. For /collect/batch the
+// scopes are passed on in the context, see ScopeAllowed.
+func (sa *SiteAuth) serveServiceAccount(w http.ResponseWriter, r *http.Request, next http.Handler, siteID, token string) {
+	sa.mu.RLock()
+	account, ok := sa.accounts[HashAPIKey(token)]
+	sa.mu.RUnlock()
+	if !ok {
+		http.Error(w, "invalid credentials", http.StatusUnauthorized)
+		return
+	}
+	if account.SiteID != "" && account.SiteID != siteID {
+		http.Error(w, "service account not allowed for site", http.StatusForbidden)
+		return
+	}
+	if scope := collectScope(r.URL.Path); scope != "" && !hasScope(account.Scopes, scope) {
+		http.Error(w, "service account scope does not allow "+r.URL.Path, http.StatusForbidden)
+		return
+	}
+	sa.touch(account.ID, true)
+
+	r = withVerifiedClient(r, siteID, "sa/"+strconv.FormatInt(account.ID, 10))
+	next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), scopesKey{}, account.Scopes)))
+}
+
+// ScopeAllowed reports whether the request may send metrics of the given
+// scope. Requests not made with a service account or scoped credential are
+// always allowed.
+func ScopeAllowed(r *http.Request, scope string) bool {
+	scopes, ok := r.Context().Value(scopesKey{}).([]string)
+	return !ok || hasScope(scopes, scope)
+}
+
+// ValidScope reports whether scope is a known service account or API key
+// scope
+func ValidScope(scope string) bool {
+	return hasScope(Scopes, scope)
+}
+
+// ValidServiceAccountScope reports whether scope is a known service account
+// scope: a collect scope or HealthScope
+func ValidServiceAccountScope(scope string) bool {
+	return scope == HealthScope || ValidScope(scope)
+}
+
+// RequireScope returns a handler that serves only requests with the token
+// of a service account holding scope. The routes it guards report on all
+// sites, so accounts bound to a site are refused.
+func (sa *SiteAuth) RequireScope(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok {
+			http.Error(w, "service account token required", http.StatusUnauthorized)
+			return
+		}
+		sa.mu.RLock()
+		account, ok := sa.accounts[HashAPIKey(strings.TrimSpace(token))]
+		sa.mu.RUnlock()
+		if !ok {
+			http.Error(w, "invalid credentials", http.StatusUnauthorized)
+			return
+		}
+		if account.SiteID != "" {
+			http.Error(w, "service account is bound to a site", http.StatusForbidden)
+			return
+		}
+		if !hasScope(account.Scopes, scope) {
+			http.Error(w, "service account scope does not allow "+r.URL.Path, http.StatusForbidden)
+			return
+		}
+		sa.touch(account.ID, true)
+		next(w, r)
+	}
+}
+
+func hasScope(scopes []string, scope string) bool {
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// collectScope maps a collect path to its scope. It returns "" for
+// /collect/batch, whose sections are checked by the handler.
+func collectScope(path string) string {
+	switch t := strings.Trim(strings.TrimPrefix(path, "/collect"), "/"); t {
+	case "":
+		return "frontend"
+	case "batch":
+		return ""
+	default:
+		return t
+	}
+}
+
+// verifyCredential returns the credential that authenticates r. The body
+// is read to check a signature and replaced for the next handler.
+func verifyCredential(r *http.Request, creds []storage.SiteCredential, now time.Time) (storage.SiteCredential, bool, error) {
+	key := r.Header.Get(APIKeyHeader)
+	if key == "" {
+		key = r.Header.Get(APIKeyAltHeader)
+	}
+	if key != "" {
+		hash := HashAPIKey(key)
+		for _, c := range creds {
+			if c.Kind == storage.CredentialAPIKey && usable(c, now) &&
+				subtle.ConstantTimeCompare([]byte(c.KeyHash), []byte(hash)) == 1 {
+				return c, true, nil
+			}
+		}
+		return storage.SiteCredential{}, false, nil
+	}
+
+	sig := r.Header.Get(SignatureHeader)
+	if sig == "" {
+		return storage.SiteCredential{}, false, nil
+	}
+	ts, mac, ok := parseSignature(sig)
+	if !ok {
+		return storage.SiteCredential{}, false, nil
+	}
+	if skew := now.Sub(time.Unix(ts, 0)); skew > signatureTolerance || skew < -signatureTolerance {
+		return storage.SiteCredential{}, false, nil
+	}
+
+	body, err := io.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		return storage.SiteCredential{}, false, err
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	for _, c := range creds {
+		if c.Kind == storage.CredentialHMACSecret && usable(c, now) &&
+			hmac.Equal(mac, signBody(c.Secret, ts, body)) {
+			return c, true, nil
+		}
+	}
+	return storage.SiteCredential{}, false, nil
+}
+
+// usable reports whether a cached credential is still valid; rotated
+// credentials may have expired since the last reload
+func usable(c storage.SiteCredential, now time.Time) bool {
+	return c.ExpiresAt == nil || now.Before(*c.ExpiresAt)
+}
+
+func parseSignature(header string) (int64, []byte, bool) {
+	var ts int64
+	var mac []byte
+	for _, part := range strings.Split(header, ",") {
+		k, v, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch k {
+		case "t":
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return 0, nil, false
+			}
+			ts = n
+		case "v1":
+			b, err := hex.DecodeString(v)
+			if err != nil {
+				return 0, nil, false
+			}
+			mac = b
+		}
+	}
+	return ts, mac, ts != 0 && mac != nil
+}
+
+func signBody(secret string, ts int64, body []byte) []byte {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write([]byte(strconv.FormatInt(ts, 10)))
+	h.Write([]byte{'.'})
+	h.Write(body)
+	return h.Sum(nil)
+}
+
+// HashAPIKey returns the stored form of an API key
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// NewServiceAccountToken generates a service account token and returns it
+// with its prefix and hash
+func NewServiceAccountToken() (token, prefix, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", "", err
+	}
+	token = "sa_" + hex.EncodeToString(b)
+	return token, token[:11], HashAPIKey(token), nil
+}
+
+// NewCredential generates a credential of the given kind and scopes for a
+// site. The returned secret is shown to the caller once; only its hash is
+// kept for API keys.
+func NewCredential(siteID, kind string, scopes []string) (storage.SiteCredential, string, error) {
+	var prefix string
+	switch kind {
+	case storage.CredentialAPIKey:
+		prefix = "pk_"
+	case storage.CredentialHMACSecret:
+		prefix = "whsec_"
+	default:
+		return storage.SiteCredential{}, "", ErrUnknownCredentialKind
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return storage.SiteCredential{}, "", err
+	}
+	secret := prefix + hex.EncodeToString(b)
+
+	cred := storage.SiteCredential{
+		SiteID: siteID,
+		Kind:   kind,
+		Scopes: scopes,
+		Prefix: secret[:len(prefix)+8],
+	}
+	if kind == storage.CredentialAPIKey {
+		cred.KeyHash = HashAPIKey(secret)
+	} else {
+		cred.Secret = secret
+	}
+	return cred, secret, nil
+}
@@ -0,0 +1,75 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mcbile/product-pulse/internal/storage"
+)
+
+type memoryCredentials struct {
+	accounts []storage.ServiceAccount
+}
+
+func (m *memoryCredentials) GetActiveCredentials(ctx context.Context) ([]storage.SiteCredential, error) {
+	return nil, nil
+}
+
+func (m *memoryCredentials) RecordCredentialUsage(ctx context.Context, usage []storage.CredentialUsage) error {
+	return nil
+}
+
+func (m *memoryCredentials) GetActiveServiceAccounts(ctx context.Context) ([]storage.ServiceAccount, error) {
+	return m.accounts, nil
+}
+
+func (m *memoryCredentials) RecordServiceAccountUsage(ctx context.Context, usage []storage.CredentialUsage) error {
+	return nil
+}
+
+func TestRequireScope(t *testing.T) {
+	store := &memoryCredentials{accounts: []storage.ServiceAccount{
+		{ID: 1, Scopes: []string{HealthScope}, TokenHash: HashAPIKey("sa_health")},
+		{ID: 2, Scopes: []string{"api", "psp"}, TokenHash: HashAPIKey("sa_collect")},
+		{ID: 3, SiteID: "casino-a", Scopes: []string{HealthScope}, TokenHash: HashAPIKey("sa_site")},
+	}}
+	sa := NewSiteAuth(store, 0, false)
+	if err := sa.Reload(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	h := sa.RequireScope(HealthScope, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	for _, tc := range []struct {
+		auth string
+		want int
+	}{
+		{"Bearer sa_health", http.StatusOK},
+		{"", http.StatusUnauthorized},
+		{"Bearer sa_unknown", http.StatusUnauthorized},
+		{"Bearer sa_collect", http.StatusForbidden},
+		{"Bearer sa_site", http.StatusForbidden},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/api/health/decision?component=psp:Trustly", nil)
+		if tc.auth != "" {
+			r.Header.Set("Authorization", tc.auth)
+		}
+		w := httptest.NewRecorder()
+		h(w, r)
+		if w.Code != tc.want {
+			t.Errorf("%q: %d, want %d", tc.auth, w.Code, tc.want)
+		}
+	}
+}
+
+func TestHealthScopeIsNotACollectScope(t *testing.T) {
+	if ValidScope(HealthScope) {
+		t.Error("health accepted as a site credential scope")
+	}
+	if !ValidServiceAccountScope(HealthScope) || !ValidServiceAccountScope("backfill") || ValidServiceAccountScope("admin") {
+		t.Error("unexpected service account scopes")
+	}
+}
@@ -0,0 +1,218 @@
+package middleware
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/mcbile/product-pulse/internal/sdk"
+	"github.com/mcbile/product-pulse/internal/storage"
+)
+
+// Bounds of schema drift tracking: rows held between flushes, so producers
+// sending random field names cannot grow memory without limit, and the
+// length of the header values recorded with them
+const (
+	maxPendingDrift = 10000
+	maxDriftLabel   = 100
+)
+
+// SchemaDriftStorage persists schema drift
+type SchemaDriftStorage interface {
+	RecordSchemaDrift(ctx context.Context, drift []storage.SchemaDrift) error
+}
+
+type schemaDriftKey struct {
+	bucket     time.Time
+	siteID     string
+	producer   string
+	sdk        string
+	version    string
+	metricType string
+	field      string
+	kind       string
+}
+
+// SchemaDriftTracker counts events with fields the collector does not know
+// or without fields it expects, per minute, producer, SDK version, metric
+// type and field. Like the SDK tracker it aggregates in memory and writes
+// to storage periodically.
+type SchemaDriftTracker struct {
+	storage  SchemaDriftStorage
+	interval time.Duration
+
+	mu      sync.Mutex
+	pending map[schemaDriftKey]*storage.SchemaDrift
+	dropped int64
+}
+
+// NewSchemaDriftTracker creates a new schema drift tracker
+func NewSchemaDriftTracker(store SchemaDriftStorage, interval time.Duration) *SchemaDriftTracker {
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+	return &SchemaDriftTracker{
+		storage:  store,
+		interval: interval,
+		pending:  make(map[schemaDriftKey]*storage.SchemaDrift),
+	}
+}
+
+// Start flushes recorded drift until ctx is cancelled
+func (dt *SchemaDriftTracker) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(dt.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				dt.Flush(ctx)
+			case <-ctx.Done():
+				dt.Flush(context.Background())
+				return
+			}
+		}
+	}()
+}
+
+// driftSource is the sender of a collect request
+type driftSource struct {
+	siteID   string
+	producer string
+	sdk      string
+	version  string
+}
+
+// Record counts one event of metricType with unknown and missing fields
+func (dt *SchemaDriftTracker) Record(src driftSource, metricType string, unknown, missing []string) {
+	now := time.Now().UTC()
+	key := schemaDriftKey{
+		bucket:     now.Truncate(time.Minute),
+		siteID:     src.siteID,
+		producer:   src.producer,
+		sdk:        src.sdk,
+		version:    src.version,
+		metricType: metricType,
+	}
+
+	dt.mu.Lock()
+	defer dt.mu.Unlock()
+
+	count := func(fields []string, kind string) {
+		for _, field := range fields {
+			key.field, key.kind = field, kind
+			d, ok := dt.pending[key]
+			if !ok {
+				if len(dt.pending) >= maxPendingDrift {
+					dt.dropped++
+					continue
+				}
+				d = &storage.SchemaDrift{
+					Bucket:     key.bucket,
+					SiteID:     src.siteID,
+					Producer:   src.producer,
+					SDK:        src.sdk,
+					SDKVersion: src.version,
+					MetricType: metricType,
+					Field:      field,
+					Kind:       kind,
+				}
+				dt.pending[key] = d
+			}
+			d.Events++
+		}
+	}
+	count(unknown, storage.SchemaDriftUnknown)
+	count(missing, storage.SchemaDriftMissing)
+}
+
+// Flush writes pending drift to storage
+func (dt *SchemaDriftTracker) Flush(ctx context.Context) {
+	dt.mu.Lock()
+	if dt.dropped > 0 {
+		slog.Warn("schema drift rows dropped, too many distinct fields", "dropped", dt.dropped)
+		dt.dropped = 0
+	}
+	if len(dt.pending) == 0 {
+		dt.mu.Unlock()
+		return
+	}
+	drift := make([]storage.SchemaDrift, 0, len(dt.pending))
+	for _, d := range dt.pending {
+		drift = append(drift, *d)
+	}
+	dt.pending = make(map[schemaDriftKey]*storage.SchemaDrift)
+	dt.mu.Unlock()
+
+	if err := dt.storage.RecordSchemaDrift(ctx, drift); err != nil {
+		slog.Error("failed to record schema drift", "rows", len(drift), "error", err)
+	}
+}
+
+// Middleware returns HTTP middleware that lets collect handlers report
+// field drift of the events they decode through ReportSchemaDrift
+func (dt *SchemaDriftTracker) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || !strings.HasPrefix(r.URL.Path, "/collect") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		name, version := sdk.Parse(r.Header.Get(sdk.Header))
+		req := &driftRequest{
+			tracker: dt,
+			path:    r.URL.Path,
+			source: driftSource{
+				siteID:   driftLabel(r.Header.Get("X-Site-Id")),
+				producer: driftLabel(r.Header.Get(ProducerHeader)),
+				sdk:      driftLabel(name),
+				version:  driftLabel(version),
+			},
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), driftKey{}, req)))
+	})
+}
+
+type driftKey struct{}
+
+type driftRequest struct {
+	tracker *SchemaDriftTracker
+	path    string
+	source  driftSource
+}
+
+// ReportSchemaDrift lets collect handlers report an event of a batch
+// section ("events", "metrics" or a /collect/batch section) with unknown
+// and missing fields. It is a no-op outside the tracker middleware.
+func ReportSchemaDrift(r *http.Request, section string, unknown, missing []string) {
+	req, ok := r.Context().Value(driftKey{}).(*driftRequest)
+	if !ok {
+		return
+	}
+
+	metricType := section
+	switch section {
+	case "events":
+		metricType = "frontend"
+	case "metrics":
+		metricType = collectMetricType(req.path)
+	}
+	req.tracker.Record(req.source, metricType, unknown, missing)
+}
+
+// driftLabel cuts a header value to maxDriftLabel bytes on a rune boundary
+func driftLabel(s string) string {
+	if len(s) <= maxDriftLabel {
+		return s
+	}
+	s = s[:maxDriftLabel]
+	for !utf8.ValidString(s) {
+		s = s[:len(s)-1]
+	}
+	return s
+}
@@ -0,0 +1,222 @@
+package middleware
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mcbile/product-pulse/internal/storage"
+)
+
+// Kill switch modes
+const (
+	KillReject  = "reject"  // Collect requests answer 403, events arriving otherwise are dropped
+	KillDiscard = "discard" // Events are accepted and dropped
+)
+
+// KillAll is the metric type of a kill switch covering every type of a site
+const KillAll = "all"
+
+// FrontendEventTypes are the event types of the browser SDK, each of which
+// can be killed alone; "frontend" kills them all
+var FrontendEventTypes = []string{"page_load", "web_vital", "interaction", "error", "crash", "custom"}
+
+// backendMetricTypes are the metric types of backend services
+var backendMetricTypes = []string{"api", "psp", "game", "ws"}
+
+// ValidKillType reports whether t is a metric type a kill switch can cover
+func ValidKillType(t string) bool {
+	return t == KillAll || t == "frontend" || slices.Contains(FrontendEventTypes, t) || slices.Contains(backendMetricTypes, t)
+}
+
+// KillSwitchStorage loads kill switches
+type KillSwitchStorage interface {
+	GetKillSwitches(ctx context.Context) ([]storage.KillSwitch, error)
+}
+
+// KillSwitchStats counts what a kill switch stopped since startup
+type KillSwitchStats struct {
+	Rejected  int64 `json:"rejected"`  // Requests answered 403
+	Discarded int64 `json:"discarded"` // Events dropped
+}
+
+type killKey struct {
+	site, metricType string
+}
+
+// KillSwitches stops the collector from storing a site's events of one
+// type, or all of them, in an emergency. In reject mode collect requests
+// carrying only killed events are refused with 403; events the middleware
+// cannot tell apart (of /collect/batch, frontend event types) are dropped
+// by the collectors, as in discard mode. The NATS source drops killed
+// events before validating them. Kill switches are cached
+// and reloaded periodically.
+type KillSwitches struct {
+	storage  KillSwitchStorage
+	interval time.Duration
+
+	mu     sync.RWMutex
+	byKey  map[killKey]storage.KillSwitch
+	bySite map[string][]string // Killed metric types
+
+	statsMu sync.Mutex
+	stats   map[killKey]*KillSwitchStats
+}
+
+// NewKillSwitches creates the kill switch check, reloading every interval
+func NewKillSwitches(store KillSwitchStorage, interval time.Duration) *KillSwitches {
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+	return &KillSwitches{
+		storage:  store,
+		interval: interval,
+		byKey:    make(map[killKey]storage.KillSwitch),
+		bySite:   make(map[string][]string),
+		stats:    make(map[killKey]*KillSwitchStats),
+	}
+}
+
+// Start loads kill switches, then reloads them until ctx is cancelled
+func (ks *KillSwitches) Start(ctx context.Context) error {
+	if err := ks.Reload(ctx); err != nil {
+		return err
+	}
+
+	go func() {
+		ticker := time.NewTicker(ks.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if err := ks.Reload(ctx); err != nil {
+					slog.Error("failed to reload kill switches", "error", err)
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// Reload replaces the cached kill switches with those in storage
+func (ks *KillSwitches) Reload(ctx context.Context) error {
+	switches, err := ks.storage.GetKillSwitches(ctx)
+	if err != nil {
+		return err
+	}
+
+	byKey := make(map[killKey]storage.KillSwitch, len(switches))
+	bySite := make(map[string][]string)
+	for _, k := range switches {
+		byKey[killKey{k.SiteID, k.MetricType}] = k
+		bySite[k.SiteID] = append(bySite[k.SiteID], k.MetricType)
+	}
+
+	ks.mu.Lock()
+	ks.byKey = byKey
+	ks.bySite = bySite
+	ks.mu.Unlock()
+	return nil
+}
+
+// Types returns the metric types killed for site
+func (ks *KillSwitches) Types(site string) []string {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	return slices.Clone(ks.bySite[site])
+}
+
+// find returns the kill switch covering events of metricType of site: the
+// type's own, the frontend one for frontend event types, or the site's.
+// Every type but the backend ones is a frontend event type.
+func (ks *KillSwitches) find(site, metricType string) (storage.KillSwitch, bool) {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	if len(ks.bySite[site]) == 0 {
+		return storage.KillSwitch{}, false
+	}
+	if k, ok := ks.byKey[killKey{site, metricType}]; ok {
+		return k, true
+	}
+	if metricType != KillAll && !slices.Contains(backendMetricTypes, metricType) {
+		if k, ok := ks.byKey[killKey{site, "frontend"}]; ok {
+			return k, true
+		}
+	}
+	k, ok := ks.byKey[killKey{site, KillAll}]
+	return k, ok
+}
+
+// Discard reports whether an event of metricType of site is dropped by a
+// kill switch, and counts it if so
+func (ks *KillSwitches) Discard(site, metricType string) bool {
+	k, ok := ks.find(site, metricType)
+	if ok {
+		ks.count(k, func(s *KillSwitchStats) { s.Discarded++ })
+	}
+	return ok
+}
+
+// Stats returns the counts per kill switch since startup, keyed by
+// site/metric_type
+func (ks *KillSwitches) Stats() map[string]KillSwitchStats {
+	ks.statsMu.Lock()
+	defer ks.statsMu.Unlock()
+	stats := make(map[string]KillSwitchStats, len(ks.stats))
+	for key, s := range ks.stats {
+		stats[key.site+"/"+key.metricType] = *s
+	}
+	return stats
+}
+
+func (ks *KillSwitches) count(k storage.KillSwitch, f func(*KillSwitchStats)) {
+	ks.statsMu.Lock()
+	defer ks.statsMu.Unlock()
+	key := killKey{k.SiteID, k.MetricType}
+	s, ok := ks.stats[key]
+	if !ok {
+		s = &KillSwitchStats{}
+		ks.stats[key] = s
+	}
+	f(s)
+}
+
+// Middleware returns HTTP middleware that refuses collect requests killed
+// in reject mode: any of a site killed as a whole, and those of a single
+// metric type endpoint (/collect, /collect/api, ...) killed for its type.
+// Registrations are let through.
+func (ks *KillSwitches) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || !strings.HasPrefix(r.URL.Path, "/collect") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		metricType := collectMetricType(r.URL.Path)
+		if metricType == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if metricType != "frontend" && !slices.Contains(backendMetricTypes, metricType) {
+			metricType = KillAll // Mixed or other endpoints only stop as a whole
+		}
+
+		site := r.Header.Get("X-Site-Id")
+		k, ok := ks.find(site, metricType)
+		if !ok || k.Mode != KillReject {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ks.count(k, func(s *KillSwitchStats) { s.Rejected++ })
+		slog.Debug("collect request rejected by kill switch", "site_id", site, "metric_type", k.MetricType, "reason", k.Reason, "path", r.URL.Path)
+		http.Error(w, "collection stopped for this site", http.StatusForbidden)
+	})
+}
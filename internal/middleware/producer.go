@@ -0,0 +1,160 @@
+package middleware
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mcbile/product-pulse/internal/sdk"
+	"github.com/mcbile/product-pulse/internal/storage"
+)
+
+// ProducerHeader identifies the sending service on collect requests
+const ProducerHeader = "X-Pulse-Producer"
+
+// ProducerStorage persists producer activity
+type ProducerStorage interface {
+	TouchProducers(ctx context.Context, activity []storage.ProducerActivity) error
+}
+
+// ProducerTracker records which producers send which metric types. Activity
+// is aggregated in memory and written to storage periodically, so collect
+// requests never wait on the registry.
+type ProducerTracker struct {
+	storage  ProducerStorage
+	interval time.Duration
+
+	mu      sync.Mutex
+	pending map[string]*storage.ProducerActivity
+}
+
+// NewProducerTracker creates a new producer tracker
+func NewProducerTracker(store ProducerStorage, interval time.Duration) *ProducerTracker {
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+	return &ProducerTracker{
+		storage:  store,
+		interval: interval,
+		pending:  make(map[string]*storage.ProducerActivity),
+	}
+}
+
+// Start flushes recorded activity until ctx is cancelled
+func (pt *ProducerTracker) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(pt.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				pt.Flush(ctx)
+			case <-ctx.Done():
+				pt.Flush(context.Background())
+				return
+			}
+		}
+	}()
+}
+
+// Touch records activity for a producer. sdkVersion is the X-Pulse-SDK
+// header value and may be empty.
+func (pt *ProducerTracker) Touch(name, siteID, sdkVersion, metricType string) {
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+
+	a, ok := pt.pending[name]
+	if !ok {
+		a = &storage.ProducerActivity{Name: name, SiteID: siteID}
+		pt.pending[name] = a
+	}
+	a.LastSeenAt = time.Now().UTC()
+	if sdkVersion != "" {
+		a.SDKVersion = sdkVersion
+	}
+
+	for _, t := range a.MetricTypes {
+		if t == metricType {
+			return
+		}
+	}
+	a.MetricTypes = append(a.MetricTypes, metricType)
+}
+
+// Flush writes pending activity to storage
+func (pt *ProducerTracker) Flush(ctx context.Context) {
+	pt.mu.Lock()
+	if len(pt.pending) == 0 {
+		pt.mu.Unlock()
+		return
+	}
+	activity := make([]storage.ProducerActivity, 0, len(pt.pending))
+	for _, a := range pt.pending {
+		activity = append(activity, *a)
+	}
+	pt.pending = make(map[string]*storage.ProducerActivity)
+	pt.mu.Unlock()
+
+	if err := pt.storage.TouchProducers(ctx, activity); err != nil {
+		slog.Error("failed to record producer activity", "producers", len(activity), "error", err)
+	}
+}
+
+// Middleware returns HTTP middleware that records producer activity on
+// collect endpoints
+func (pt *ProducerTracker) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := r.Header.Get(ProducerHeader)
+		if r.Method != http.MethodPost || name == "" || !strings.HasPrefix(r.URL.Path, "/collect") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		siteID := r.Header.Get("X-Site-Id")
+		sdkVersion := strings.TrimSpace(r.Header.Get(sdk.Header))
+
+		// Multi-type requests report what they carried via ReportMetricTypes
+		if r.URL.Path == "/collect/batch" {
+			var types []string
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), metricTypesKey{}, &types)))
+			for _, t := range types {
+				pt.Touch(name, siteID, sdkVersion, t)
+			}
+			return
+		}
+
+		if metricType := collectMetricType(r.URL.Path); metricType != "" {
+			pt.Touch(name, siteID, sdkVersion, metricType)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+type metricTypesKey struct{}
+
+// ReportMetricTypes lets handlers of multi-type endpoints tell the producer
+// tracker which metric types a request carried. It is a no-op outside the
+// tracker middleware.
+func ReportMetricTypes(r *http.Request, types ...string) {
+	if p, ok := r.Context().Value(metricTypesKey{}).(*[]string); ok {
+		*p = append(*p, types...)
+	}
+}
+
+// collectMetricType maps a collect path to its metric type:
+// /collect -> frontend, /collect/api -> api, ... Registration requests carry
+// no metrics and map to "".
+func collectMetricType(path string) string {
+	t := strings.Trim(strings.TrimPrefix(path, "/collect"), "/")
+	switch t {
+	case "":
+		return "frontend"
+	case "register":
+		return ""
+	}
+	return t
+}
@@ -0,0 +1,194 @@
+package middleware
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Route groups a rate limit key policy can configure separately
+const (
+	RouteCollect   = "collect"   // /collect and /collect/*
+	RouteDashboard = "dashboard" // /api/*
+	RoutePublic    = "public"    // /public/*
+	RouteDefault   = "*"         // Everything else, and groups without an entry
+)
+
+// PlayerHeader identifies the player of a request for player keyed limits
+const PlayerHeader = "X-Player-Id"
+
+// KeyRule says how requests of a route group are grouped into rate limit
+// buckets, and optionally overrides the limiter's rate for those buckets
+type KeyRule struct {
+	Strategy string // ip, site, key or player
+	V4Prefix int    // ip: IPv4 prefix length of a bucket, 32 for single addresses
+	V6Prefix int    // ip: IPv6 prefix length of a bucket
+	RPS      float64
+	Burst    int // 0 keeps the limiter's rate and burst
+}
+
+// KeyPolicy selects the KeyRule of a request by route group
+type KeyPolicy map[string]KeyRule
+
+// defaultKeyRule is one bucket per client IP, the limiter's behaviour
+// without a policy
+var defaultKeyRule = KeyRule{Strategy: "ip", V4Prefix: 32, V6Prefix: 128}
+
+// ParseKeyPolicy parses entries in the form group=strategy[@rps/burst] with
+// strategy one of ip[/v4_prefix[/v6_prefix]], site, key or player, e.g.
+// "collect=site@2000/4000" or "*=ip/24/56" to aggregate carrier-grade NAT
+// ranges. site, key and player buckets are only known once SiteAuth has
+// verified the request's credential, so they are limited to the collect
+// group; see RateLimiter.VerifiedMiddleware.
+func ParseKeyPolicy(entries []string) (KeyPolicy, error) {
+	policy := make(KeyPolicy)
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		group, spec, ok := strings.Cut(entry, "=")
+		group = strings.TrimSpace(group)
+		if !ok || spec == "" {
+			return nil, fmt.Errorf("invalid rate limit key %q, expected group=strategy", entry)
+		}
+		switch group {
+		case RouteCollect, RouteDashboard, RoutePublic, RouteDefault:
+		default:
+			return nil, fmt.Errorf("unknown route group %q in rate limit key %q", group, entry)
+		}
+
+		rule := defaultKeyRule
+		spec, limit, hasLimit := strings.Cut(strings.TrimSpace(spec), "@")
+		if hasLimit {
+			rps, burst, ok := strings.Cut(limit, "/")
+			r, err := strconv.ParseFloat(rps, 64)
+			b, berr := strconv.Atoi(burst)
+			if !ok || err != nil || berr != nil || r <= 0 || b <= 0 {
+				return nil, fmt.Errorf("invalid rate in rate limit key %q, expected @rps/burst", entry)
+			}
+			rule.RPS, rule.Burst = r, b
+		}
+
+		parts := strings.Split(spec, "/")
+		rule.Strategy = parts[0]
+		switch {
+		case rule.Strategy == "ip" && len(parts) <= 3:
+			bits := []*int{&rule.V4Prefix, &rule.V6Prefix}
+			for i, p := range parts[1:] {
+				n, err := strconv.Atoi(p)
+				if err != nil || n < 1 || n > 32+96*i {
+					return nil, fmt.Errorf("invalid prefix length in rate limit key %q", entry)
+				}
+				*bits[i] = n
+			}
+		case (rule.Strategy == "site" || rule.Strategy == "key" || rule.Strategy == "player") && len(parts) == 1:
+			if group != RouteCollect {
+				return nil, fmt.Errorf("strategy %s in rate limit key %q needs verified credentials, only available for the collect group", rule.Strategy, entry)
+			}
+		default:
+			return nil, fmt.Errorf("invalid strategy in rate limit key %q, expected ip[/v4[/v6]], site, key or player", entry)
+		}
+		policy[group] = rule
+	}
+	return policy, nil
+}
+
+// routeGroup returns the route group of a request path
+func routeGroup(path string) string {
+	switch {
+	case path == "/collect" || strings.HasPrefix(path, "/collect/"):
+		return RouteCollect
+	case strings.HasPrefix(path, "/api/"):
+		return RouteDashboard
+	case strings.HasPrefix(path, "/public/"):
+		return RoutePublic
+	}
+	return RouteDefault
+}
+
+// rule returns the KeyRule of a request
+func (p KeyPolicy) rule(r *http.Request) KeyRule {
+	if rule, ok := p[routeGroup(r.URL.Path)]; ok {
+		return rule
+	}
+	if rule, ok := p[RouteDefault]; ok {
+		return rule
+	}
+	return defaultKeyRule
+}
+
+// verified reports whether the rule buckets requests by a credential that
+// SiteAuth verifies rather than by client address
+func (rule KeyRule) verified() bool {
+	return rule.Strategy != "ip"
+}
+
+// ipKey returns the per-IP bucket of a request. Rules bucketing by verified
+// credentials get one at their own rate, so a single client cannot use
+// more than one site, key or player could.
+func (rule KeyRule) ipKey(r *http.Request) string {
+	v4, v6 := rule.V4Prefix, rule.V6Prefix
+	if rule.verified() {
+		v4, v6 = defaultKeyRule.V4Prefix, defaultKeyRule.V6Prefix
+	}
+	return rule.withRate("ip:" + clientPrefix(ClientIP(r), v4, v6))
+}
+
+// verifiedKey returns the site, key or player bucket of a request that
+// SiteAuth authenticated, and false for other requests. Buckets of
+// different strategies never collide; groups using the same strategy share
+// buckets unless they set their own rate.
+func (rule KeyRule) verifiedKey(r *http.Request) (string, bool) {
+	client, ok := r.Context().Value(verifiedClientKey{}).(verifiedClient)
+	if !ok {
+		return "", false
+	}
+	var key string
+	switch rule.Strategy {
+	case "site":
+		key = "site:" + client.site
+	case "key":
+		key = "key:" + client.credential
+	case "player":
+		player := r.Header.Get(PlayerHeader)
+		if player == "" {
+			return "", false
+		}
+		// Per site, so one tenant's player IDs never touch another's
+		key = "player:" + client.site + "/" + player
+	default:
+		return "", false
+	}
+	return rule.withRate(key), true
+}
+
+func (rule KeyRule) withRate(key string) string {
+	if rule.Burst > 0 {
+		key = strconv.FormatFloat(rule.RPS, 'f', -1, 64) + "/" + strconv.Itoa(rule.Burst) + ":" + key
+	}
+	return key
+}
+
+// clientPrefix masks ip to the network of the given prefix length, so
+// clients behind one carrier NAT range share a bucket. Unparsable addresses
+// are returned as they are.
+func clientPrefix(ip string, v4, v6 int) string {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return ip
+	}
+	if v4ip := parsed.To4(); v4ip != nil {
+		if v4 == 0 || v4 >= 32 {
+			return v4ip.String()
+		}
+		return (&net.IPNet{IP: v4ip.Mask(net.CIDRMask(v4, 32)), Mask: net.CIDRMask(v4, 32)}).String()
+	}
+	if v6 == 0 || v6 >= 128 {
+		return parsed.String()
+	}
+	return (&net.IPNet{IP: parsed.Mask(net.CIDRMask(v6, 128)), Mask: net.CIDRMask(v6, 128)}).String()
+}
@@ -0,0 +1,70 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mcbile/product-pulse/internal/storage"
+)
+
+func TestParseKeyPolicyLimitsVerifiedStrategiesToCollect(t *testing.T) {
+	if _, err := ParseKeyPolicy([]string{"collect=site@2000/4000", "collect=key", "*=ip/24/56"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range []string{"dashboard=key", "*=player", "public=site"} {
+		if _, err := ParseKeyPolicy([]string{entry}); err == nil {
+			t.Errorf("%q accepted", entry)
+		}
+	}
+}
+
+func TestRateLimitKeysOnlyVerifiedCredentials(t *testing.T) {
+	store := &memoryCredentials{accounts: []storage.ServiceAccount{
+		{ID: 1, SiteID: "casino-a", Scopes: []string{"api"}, TokenHash: HashAPIKey("sa_a")},
+	}}
+	sa := NewSiteAuth(store, 0, false)
+	if err := sa.Reload(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	policy, err := ParseKeyPolicy([]string{"collect=player@1/1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rl := NewRateLimiter(1000, 1000, true)
+	rl.SetKeyPolicy(policy)
+	h := rl.Middleware(sa.Middleware(rl.VerifiedMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	}))))
+
+	send := func(remote, token, player string) int {
+		r := httptest.NewRequest(http.MethodPost, "/collect/api", nil)
+		r.RemoteAddr = remote
+		r.Header.Set("X-Site-Id", "casino-a")
+		r.Header.Set(PlayerHeader, player)
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	// Unverified requests are limited per IP at the group's rate, whatever
+	// player they claim to be
+	if code := send("203.0.113.1:1000", "", "p1"); code != http.StatusAccepted {
+		t.Fatalf("first request: %d", code)
+	}
+	if code := send("203.0.113.1:1000", "", "p2"); code != http.StatusTooManyRequests {
+		t.Errorf("new player ID bypassed the per-IP limit: %d", code)
+	}
+
+	// Verified requests get player buckets
+	if code := send("203.0.113.2:1000", "sa_a", "p1"); code != http.StatusAccepted {
+		t.Fatalf("verified request: %d", code)
+	}
+	if code := send("203.0.113.3:1000", "sa_a", "p1"); code != http.StatusTooManyRequests {
+		t.Errorf("player bucket not shared across IPs: %d", code)
+	}
+}
@@ -1,26 +1,30 @@
 package middleware
 
 import (
+	"context"
 	"log/slog"
-	"net"
 	"net/http"
-	"strings"
 	"sync"
 	"time"
 
+	"github.com/redis/go-redis/v9"
 	"golang.org/x/time/rate"
 )
 
-// RateLimiter implements per-IP rate limiting
+// RateLimiter implements per-client rate limiting, by default one bucket
+// per IP; see SetKeyPolicy
 type RateLimiter struct {
 	mu       sync.RWMutex
-	limiters map[string]*ipLimiter
+	limiters map[string]*keyLimiter
 	rps      rate.Limit
 	burst    int
 	enabled  bool
+	redis    *redis.Client // Shared buckets across collectors when set
+	group    string        // Separates the Redis buckets of route groups, "" for the main limiter
+	policy   KeyPolicy
 }
 
-type ipLimiter struct {
+type keyLimiter struct {
 	limiter  *rate.Limiter
 	lastSeen time.Time
 }
@@ -28,7 +32,7 @@ type ipLimiter struct {
 // NewRateLimiter creates a new rate limiter
 func NewRateLimiter(rps float64, burst int, enabled bool) *RateLimiter {
 	rl := &RateLimiter{
-		limiters: make(map[string]*ipLimiter),
+		limiters: make(map[string]*keyLimiter),
 		rps:      rate.Limit(rps),
 		burst:    burst,
 		enabled:  enabled,
@@ -40,30 +44,94 @@ func NewRateLimiter(rps float64, burst int, enabled bool) *RateLimiter {
 	return rl
 }
 
+// NewRedisRateLimiter creates a rate limiter keeping its buckets in Redis,
+// so the limit applies to a client across all collectors behind a load
+// balancer. Requests are let through while Redis is unavailable. Limiters
+// of different groups keep separate buckets.
+func NewRedisRateLimiter(client *redis.Client, group string, rps float64, burst int, enabled bool) *RateLimiter {
+	return &RateLimiter{
+		rps:     rate.Limit(rps),
+		burst:   burst,
+		enabled: enabled,
+		redis:   client,
+		group:   group,
+	}
+}
+
+// SetKeyPolicy changes how requests are grouped into buckets per route
+// group, e.g. per site for collect endpoints or per /24 for carrier NAT. It
+// must be called before the limiter serves requests.
+func (rl *RateLimiter) SetKeyPolicy(policy KeyPolicy) {
+	rl.policy = policy
+}
+
+// tokenBucket refills KEYS[1] at ARGV[1] tokens per second up to ARGV[2]
+// and takes one token if available. Redis' clock is used so collectors
+// with skewed clocks agree.
+var tokenBucket = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local t = redis.call('TIME')
+local now = tonumber(t[1]) + tonumber(t[2]) / 1000000
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(state[1]) or burst
+local ts = tonumber(state[2]) or now
+tokens = math.min(burst, tokens + math.max(0, now - ts) * rate)
+local allowed = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+end
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'ts', tostring(now))
+redis.call('PEXPIRE', KEYS[1], ARGV[3])
+return allowed
+`)
+
+// allowRedis takes a token from a shared bucket
+func (rl *RateLimiter) allowRedis(ctx context.Context, bucket string, rps rate.Limit, burst int) bool {
+	// Idle buckets are full again after burst/rps and can be dropped
+	ttl := 3 * time.Minute
+	if rps > 0 {
+		ttl = time.Duration(float64(burst)/float64(rps)*float64(time.Second)) + time.Second
+	}
+
+	key := "pulse:ratelimit:" + bucket
+	if rl.group != "" {
+		key = "pulse:ratelimit:" + rl.group + ":" + bucket
+	}
+	allowed, err := tokenBucket.Run(ctx, rl.redis, []string{key},
+		float64(rps), burst, ttl.Milliseconds()).Int()
+	if err != nil {
+		slog.Warn("redis rate limit failed, allowing request", "bucket", bucket, "error", err)
+		return true
+	}
+	return allowed == 1
+}
+
 func (rl *RateLimiter) cleanup() {
 	ticker := time.NewTicker(time.Minute)
 	for range ticker.C {
 		rl.mu.Lock()
-		for ip, il := range rl.limiters {
-			if time.Since(il.lastSeen) > 3*time.Minute {
-				delete(rl.limiters, ip)
+		for key, kl := range rl.limiters {
+			if time.Since(kl.lastSeen) > 3*time.Minute {
+				delete(rl.limiters, key)
 			}
 		}
 		rl.mu.Unlock()
 	}
 }
 
-func (rl *RateLimiter) getLimiter(ip string) *rate.Limiter {
+func (rl *RateLimiter) getLimiter(key string, rps rate.Limit, burst int) *rate.Limiter {
 	rl.mu.Lock()
 	defer rl.mu.Unlock()
 
-	if il, exists := rl.limiters[ip]; exists {
-		il.lastSeen = time.Now()
-		return il.limiter
+	if kl, exists := rl.limiters[key]; exists {
+		kl.lastSeen = time.Now()
+		return kl.limiter
 	}
 
-	limiter := rate.NewLimiter(rl.rps, rl.burst)
-	rl.limiters[ip] = &ipLimiter{
+	limiter := rate.NewLimiter(rps, burst)
+	rl.limiters[key] = &keyLimiter{
 		limiter:  limiter,
 		lastSeen: time.Now(),
 	}
@@ -71,43 +139,52 @@ func (rl *RateLimiter) getLimiter(ip string) *rate.Limiter {
 	return limiter
 }
 
-// Middleware returns HTTP middleware that applies rate limiting
+// Middleware returns HTTP middleware that applies the per-IP rate limit.
+// It runs before authentication, so every request is limited by an address
+// the client cannot choose.
 func (rl *RateLimiter) Middleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if !rl.enabled {
-			next.ServeHTTP(w, r)
-			return
-		}
-
-		ip := getClientIP(r)
-		limiter := rl.getLimiter(ip)
-
-		if !limiter.Allow() {
-			slog.Debug("rate limit exceeded", "ip", ip, "path", r.URL.Path)
+		rule := rl.policy.rule(r)
+		if rl.enabled && !rl.allow(r, rule, rule.ipKey(r)) {
 			http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
 			return
 		}
+		next.ServeHTTP(w, r)
+	})
+}
 
+// VerifiedMiddleware returns HTTP middleware that applies the site, key and
+// player buckets of the key policy. It must run after SiteAuth, which
+// verifies the credentials they are keyed by; requests SiteAuth did not
+// authenticate only have the per-IP limit of Middleware.
+func (rl *RateLimiter) VerifiedMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rule := rl.policy.rule(r)
+		if rl.enabled && rule.verified() {
+			if key, ok := rule.verifiedKey(r); ok && !rl.allow(r, rule, key) {
+				http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+				return
+			}
+		}
 		next.ServeHTTP(w, r)
 	})
 }
 
-func getClientIP(r *http.Request) string {
-	// Check X-Forwarded-For header (from proxy)
-	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
-		parts := strings.Split(xff, ",")
-		return strings.TrimSpace(parts[0])
+// allow takes a token from the bucket key at the rule's rate
+func (rl *RateLimiter) allow(r *http.Request, rule KeyRule, key string) bool {
+	rps, burst := rl.rps, rl.burst
+	if rule.Burst > 0 {
+		rps, burst = rate.Limit(rule.RPS), rule.Burst
 	}
 
-	// Check X-Real-IP header
-	if xri := r.Header.Get("X-Real-IP"); xri != "" {
-		return xri
+	var allowed bool
+	if rl.redis != nil {
+		allowed = rl.allowRedis(r.Context(), key, rps, burst)
+	} else {
+		allowed = rl.getLimiter(key, rps, burst).Allow()
 	}
-
-	// Fall back to RemoteAddr
-	ip, _, err := net.SplitHostPort(r.RemoteAddr)
-	if err != nil {
-		return r.RemoteAddr
+	if !allowed {
+		slog.Debug("rate limit exceeded", "bucket", key, "ip", ClientIP(r), "path", r.URL.Path)
 	}
-	return ip
+	return allowed
 }
@@ -0,0 +1,451 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"github.com/mcbile/product-pulse/internal/storage"
+)
+
+const (
+	// maxCapturedBody bounds the stored request and response body
+	maxCapturedBody = 64 << 10
+
+	// recordQueueSize bounds records waiting to be written; beyond it
+	// records are dropped rather than slowing down requests
+	recordQueueSize = 1000
+
+	redacted = "[REDACTED]"
+)
+
+// redactedHeaders never leave the request in clear text
+var redactedHeaders = map[string]bool{
+	"Authorization":       true,
+	"Cookie":              true,
+	"Set-Cookie":          true,
+	APIKeyHeader:          true,
+	APIKeyAltHeader:       true,
+	SignatureHeader:       true,
+	"Proxy-Authorization": true,
+}
+
+// redactedFieldNames are secrets in JSON bodies, at any depth, and in query
+// strings (compared case-insensitively)
+var redactedFieldNames = []string{
+	"password",
+	"password_hash",
+	"token",
+	"access_token",
+	"refresh_token",
+	"id_token",
+	"credential",
+	"secret",
+	"client_secret",
+	"api_key",
+	"key",
+	"code",
+	"code_verifier",
+}
+
+// redactedParams are secret only as query parameters: the signature of
+// export download links and the OAuth state of the OIDC callback. Bodies
+// use these names for other things, such as PSP states.
+var redactedParams = []string{"sig", "state"}
+
+var (
+	redactedFields = nameSet(redactedFieldNames)
+	redactedQuery  = nameSet(slices.Concat(redactedFieldNames, redactedParams))
+
+	// redactedText catches secret JSON fields in bodies that do not parse,
+	// e.g. truncated ones
+	redactedText = regexp.MustCompile(`(?i)("(?:` + strings.Join(redactedFieldNames, "|") + `)"\s*:\s*)"(?:[^"\\]|\\.)*"?`)
+)
+
+func nameSet(names []string) map[string]bool {
+	set := make(map[string]bool, len(names))
+	for _, name := range names {
+		set[name] = true
+	}
+	return set
+}
+
+// RecorderStorage loads captures and stores their records
+type RecorderStorage interface {
+	GetActiveCaptures(ctx context.Context) ([]storage.Capture, error)
+	InsertCaptureRecords(ctx context.Context, records []storage.CaptureRecord) error
+}
+
+type activeCapture struct {
+	storage.Capture
+	network *net.IPNet // nil: any client
+}
+
+// Recorder records full request/response pairs of the sites and client IPs
+// of active captures, so "our metrics aren't showing up" tickets can be
+// debugged from what the collector actually received and answered.
+// Credentials are redacted from headers and JSON bodies before anything is
+// stored. Captures are loaded from storage periodically, so a capture
+// started on one collector records on all of them. Records are written in
+// the background; if storage falls behind they are dropped.
+type Recorder struct {
+	storage  RecorderStorage
+	interval time.Duration
+
+	mu       sync.RWMutex
+	captures []activeCapture
+
+	records chan storage.CaptureRecord
+	dropped atomic.Int64
+}
+
+// NewRecorder creates a request recorder
+func NewRecorder(store RecorderStorage, interval time.Duration) *Recorder {
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+	return &Recorder{
+		storage:  store,
+		interval: interval,
+		records:  make(chan storage.CaptureRecord, recordQueueSize),
+	}
+}
+
+// Start loads active captures, then reloads them and writes records until
+// ctx is cancelled
+func (rec *Recorder) Start(ctx context.Context) error {
+	if err := rec.Reload(ctx); err != nil {
+		return err
+	}
+
+	go func() {
+		ticker := time.NewTicker(rec.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if err := rec.Reload(ctx); err != nil {
+					slog.Error("failed to reload captures", "error", err)
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	go rec.write(ctx)
+	return nil
+}
+
+// Reload replaces the cached active captures with the stored ones
+func (rec *Recorder) Reload(ctx context.Context) error {
+	captures, err := rec.storage.GetActiveCaptures(ctx)
+	if err != nil {
+		return err
+	}
+
+	active := make([]activeCapture, 0, len(captures))
+	for _, c := range captures {
+		ac := activeCapture{Capture: c}
+		if c.ClientIP != "" {
+			network, err := ParseClientNetwork(c.ClientIP)
+			if err != nil {
+				slog.Warn("ignoring capture with invalid client ip", "capture", c.ID, "client_ip", c.ClientIP)
+				continue
+			}
+			ac.network = network
+		}
+		active = append(active, ac)
+	}
+
+	rec.mu.Lock()
+	rec.captures = active
+	rec.mu.Unlock()
+	return nil
+}
+
+// ParseClientNetwork parses an address or CIDR range
+func ParseClientNetwork(s string) (*net.IPNet, error) {
+	if !strings.Contains(s, "/") {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, &net.ParseError{Type: "IP address", Text: s}
+		}
+		bits := 128
+		if ip.To4() != nil {
+			ip, bits = ip.To4(), 32
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+	_, network, err := net.ParseCIDR(s)
+	return network, err
+}
+
+// match returns the active capture covering a request
+func (rec *Recorder) match(siteID, clientIP string, now time.Time) (int64, bool) {
+	rec.mu.RLock()
+	defer rec.mu.RUnlock()
+
+	if len(rec.captures) == 0 {
+		return 0, false
+	}
+	ip := net.ParseIP(clientIP)
+	for _, c := range rec.captures {
+		if !now.Before(c.ExpiresAt) {
+			continue
+		}
+		if c.SiteID != "" && c.SiteID != siteID {
+			continue
+		}
+		if c.network != nil && (ip == nil || !c.network.Contains(ip)) {
+			continue
+		}
+		return c.ID, true
+	}
+	return 0, false
+}
+
+// Middleware records requests matching an active capture. It should run
+// first, so rejected requests (rate limits, oversized bodies, missing
+// credentials) are recorded too.
+func (rec *Recorder) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		siteID := r.Header.Get("X-Site-Id")
+		clientIP := ClientIP(r)
+		captureID, ok := rec.match(siteID, clientIP, start)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// The start of the body is read upfront, so it is recorded even if
+		// the request is rejected before a handler reads it
+		var reqBody []byte
+		var reqTruncated bool
+		if r.Body != nil && r.Body != http.NoBody {
+			var err error
+			reqBody, err = io.ReadAll(io.LimitReader(r.Body, maxCapturedBody+1))
+			if len(reqBody) > maxCapturedBody {
+				reqTruncated = true
+			}
+			rest := io.Reader(r.Body)
+			if err != nil {
+				rest = errReader{err}
+			}
+			r.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.MultiReader(bytes.NewReader(reqBody), rest), r.Body}
+			reqBody = reqBody[:min(len(reqBody), maxCapturedBody)]
+		}
+		// Snapshot before handlers strip or add headers
+		reqHeaders := redactHeaders(r.Header)
+
+		rw := &recordingWriter{ResponseWriter: w, status: http.StatusOK, body: limitedBuffer{limit: maxCapturedBody}}
+		next.ServeHTTP(rw, r)
+
+		record := storage.CaptureRecord{
+			CaptureID:         captureID,
+			Time:              start.UTC(),
+			Method:            r.Method,
+			Path:              redactURI(r.URL),
+			ClientIP:          clientIP,
+			SiteID:            siteID,
+			RequestHeaders:    reqHeaders,
+			RequestBody:       redactBody(reqBody),
+			RequestTruncated:  reqTruncated,
+			Status:            rw.status,
+			ResponseHeaders:   redactHeaders(w.Header()),
+			ResponseBody:      redactBody(rw.body.Bytes()),
+			ResponseTruncated: rw.body.truncated,
+			DurationMS:        float64(time.Since(start).Microseconds()) / 1000,
+		}
+		select {
+		case rec.records <- record:
+		default:
+			if rec.dropped.Add(1) == 1 {
+				slog.Warn("capture record queue full, dropping records", "capture", captureID)
+			}
+		}
+	})
+}
+
+// write stores queued records in batches until ctx is cancelled
+func (rec *Recorder) write(ctx context.Context) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	var batch []storage.CaptureRecord
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		wctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := rec.storage.InsertCaptureRecords(wctx, batch); err != nil {
+			slog.Error("failed to store capture records", "records", len(batch), "error", err)
+		}
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case r := <-rec.records:
+			batch = append(batch, r)
+			if len(batch) >= 100 {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-ctx.Done():
+			flush()
+			return
+		}
+	}
+}
+
+// Dropped returns the number of records dropped because storage fell
+// behind
+func (rec *Recorder) Dropped() int64 {
+	return rec.dropped.Load()
+}
+
+// limitedBuffer keeps the first limit bytes written to it
+type limitedBuffer struct {
+	bytes.Buffer
+	limit     int
+	truncated bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.limit - b.Len(); room < len(p) {
+		b.truncated = true
+		if room > 0 {
+			b.Buffer.Write(p[:room])
+		}
+		return len(p), nil
+	}
+	return b.Buffer.Write(p)
+}
+
+// errReader replays a read error after the recorded part of a body
+type errReader struct{ err error }
+
+func (e errReader) Read([]byte) (int, error) { return 0, e.err }
+
+// recordingWriter keeps the status and the start of the body of a response
+type recordingWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        limitedBuffer
+}
+
+func (rw *recordingWriter) WriteHeader(code int) {
+	if !rw.wroteHeader {
+		rw.status = code
+		rw.wroteHeader = true
+	}
+	rw.ResponseWriter.WriteHeader(code)
+}
+
+func (rw *recordingWriter) Write(p []byte) (int, error) {
+	rw.wroteHeader = true
+	rw.body.Write(p)
+	return rw.ResponseWriter.Write(p)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (rw *recordingWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+// redactURI returns the path and query of u with the values of secret
+// parameters replaced, keeping the rest of the query as sent
+func redactURI(u *url.URL) string {
+	if u.RawQuery == "" {
+		return u.RequestURI()
+	}
+	params := strings.Split(u.RawQuery, "&")
+	for i, param := range params {
+		raw, _, _ := strings.Cut(param, "=")
+		name, err := url.QueryUnescape(raw)
+		if err != nil {
+			name = raw
+		}
+		if redactedQuery[strings.ToLower(name)] {
+			params[i] = raw + "=" + redacted
+		}
+	}
+	c := *u
+	c.RawQuery = strings.Join(params, "&")
+	return c.RequestURI()
+}
+
+// redactHeaders encodes headers as JSON with credentials replaced
+func redactHeaders(h http.Header) json.RawMessage {
+	out := make(map[string][]string, len(h))
+	for name, values := range h {
+		if redactedHeaders[http.CanonicalHeaderKey(name)] {
+			out[name] = []string{redacted}
+			continue
+		}
+		out[name] = values
+	}
+	b, err := json.Marshal(out)
+	if err != nil {
+		return json.RawMessage("{}")
+	}
+	return b
+}
+
+// redactBody returns a body with secret JSON fields replaced and binary
+// bodies (MessagePack, protobuf, gzip) as a size note
+func redactBody(body []byte) string {
+	if len(body) == 0 {
+		return ""
+	}
+	var v interface{}
+	if err := json.Unmarshal(body, &v); err == nil {
+		if b, err := json.Marshal(redactJSON(v)); err == nil {
+			return string(b)
+		}
+	}
+	if !utf8.Valid(body) {
+		return "[binary body, " + strconv.Itoa(len(body)) + " bytes]"
+	}
+	return redactedText.ReplaceAllString(string(body), `${1}"`+redacted+`"`)
+}
+
+func redactJSON(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, inner := range t {
+			if redactedFields[strings.ToLower(k)] {
+				t[k] = redacted
+				continue
+			}
+			t[k] = redactJSON(inner)
+		}
+	case []interface{}:
+		for i, inner := range t {
+			t[i] = redactJSON(inner)
+		}
+	}
+	return v
+}
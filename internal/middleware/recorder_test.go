@@ -0,0 +1,41 @@
+package middleware
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestRedactURI(t *testing.T) {
+	for _, tc := range []struct {
+		uri, want string
+	}{
+		{"/collect", "/collect"},
+		{"/exports/usage.csv?expires=1767225600&sig=3f9a1c", "/exports/usage.csv?expires=1767225600&sig=[REDACTED]"},
+		{"/auth/oidc/callback?code=4%2F0Ab&state=xyz&scope=openid", "/auth/oidc/callback?code=[REDACTED]&state=[REDACTED]&scope=openid"},
+		{"/api/metrics?Api_Key=pk_live&site=casino-a", "/api/metrics?Api_Key=[REDACTED]&site=casino-a"},
+		{"/api/alerts?%73ig=abc&x=%zz", "/api/alerts?%73ig=[REDACTED]&x=%zz"},
+	} {
+		u, err := url.Parse(tc.uri)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := redactURI(u); got != tc.want {
+			t.Errorf("%s: %s, want %s", tc.uri, got, tc.want)
+		}
+	}
+}
+
+func TestRedactBodyCoversTheSameFieldsParsedOrNot(t *testing.T) {
+	for _, name := range redactedFieldNames {
+		body := `{"` + name + `":"s3cret","state":"approved"}`
+		for _, got := range []string{redactBody([]byte(body)), redactBody([]byte(body[:len(body)-1]))} {
+			if strings.Contains(got, "s3cret") {
+				t.Errorf("%s not redacted in %s", name, got)
+			}
+			if !strings.Contains(got, "approved") {
+				t.Errorf("state redacted in body %s", got)
+			}
+		}
+	}
+}
@@ -0,0 +1,254 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mcbile/product-pulse/internal/storage"
+)
+
+// Data residency headers. Collect requests forwarded to another region
+// carry the region of the collector that forwarded them; rejected ones get
+// the region that must receive them.
+const (
+	ForwardedRegionHeader = "X-Pulse-Forwarded-From"
+	RegionHeader          = "X-Pulse-Region"
+)
+
+// regionPattern matches region names such as eu, us-east or apac2
+var regionPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,31}$`)
+
+// ValidRegion reports whether s is a valid region name
+func ValidRegion(s string) bool {
+	return regionPattern.MatchString(s)
+}
+
+// ParseResidencyForwards parses region=url entries, the collectors that
+// requests of sites resident in another region are forwarded to, e.g.
+// "eu=https://pulse-eu.example.com"
+func ParseResidencyForwards(entries []string) (map[string]*url.URL, error) {
+	forwards := make(map[string]*url.URL)
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		region, target, ok := strings.Cut(entry, "=")
+		region = strings.TrimSpace(region)
+		if !ok || !ValidRegion(region) {
+			return nil, fmt.Errorf("invalid residency forward %q, expected region=url", entry)
+		}
+		u, err := url.Parse(strings.TrimSpace(target))
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return nil, fmt.Errorf("invalid residency forward url for %s", region)
+		}
+		forwards[region] = u
+	}
+	return forwards, nil
+}
+
+// ResidencyStorage loads site residencies
+type ResidencyStorage interface {
+	GetSiteResidencies(ctx context.Context) ([]storage.SiteResidency, error)
+}
+
+// ResidencyStats counts the collect requests of a site that this collector
+// did not store since startup
+type ResidencyStats struct {
+	Forwarded int64 `json:"forwarded"`
+	Rejected  int64 `json:"rejected"`
+	Dropped   int64 `json:"dropped"` // Events of queue ingest (NATS), which cannot be forwarded
+}
+
+// Residency keeps the data of sites pinned to a region (site_residency) in
+// that region. Collect requests of a site resident in another region than
+// this collector's are forwarded to that region's collector when one is
+// configured, and rejected with 421 and the region otherwise. A collector
+// without a region stores no resident site's data. Sites without a
+// residency are stored anywhere. Residencies are cached and reloaded
+// periodically.
+type Residency struct {
+	storage  ResidencyStorage
+	interval time.Duration
+	region   string
+	proxies  map[string]*httputil.ReverseProxy
+
+	mu     sync.RWMutex
+	bySite map[string]string
+
+	statsMu sync.Mutex
+	stats   map[string]*ResidencyStats
+}
+
+// NewResidency creates the residency check of a collector in region, with
+// the collectors of other regions to forward to
+func NewResidency(store ResidencyStorage, region string, forwards map[string]*url.URL, interval time.Duration) *Residency {
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+	rs := &Residency{
+		storage:  store,
+		interval: interval,
+		region:   region,
+		proxies:  make(map[string]*httputil.ReverseProxy),
+		bySite:   make(map[string]string),
+		stats:    make(map[string]*ResidencyStats),
+	}
+	for target, u := range forwards {
+		if target != region {
+			rs.proxies[target] = rs.newProxy(target, u)
+		}
+	}
+	return rs
+}
+
+// Start loads residencies, then reloads them until ctx is cancelled
+func (rs *Residency) Start(ctx context.Context) error {
+	if err := rs.Reload(ctx); err != nil {
+		return err
+	}
+
+	go func() {
+		ticker := time.NewTicker(rs.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if err := rs.Reload(ctx); err != nil {
+					slog.Error("failed to reload site residencies", "error", err)
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// Reload replaces the cached residencies with those in storage
+func (rs *Residency) Reload(ctx context.Context) error {
+	residencies, err := rs.storage.GetSiteResidencies(ctx)
+	if err != nil {
+		return err
+	}
+
+	bySite := make(map[string]string, len(residencies))
+	for _, r := range residencies {
+		bySite[r.SiteID] = r.Region
+	}
+
+	rs.mu.Lock()
+	rs.bySite = bySite
+	rs.mu.Unlock()
+	return nil
+}
+
+// Region returns the region of this collector, empty if unset
+func (rs *Residency) Region() string {
+	return rs.region
+}
+
+// Forwards reports whether requests of sites resident in region are
+// forwarded rather than rejected
+func (rs *Residency) Forwards(region string) bool {
+	_, ok := rs.proxies[region]
+	return ok
+}
+
+// Allowed reports whether this collector may store data of site, and
+// otherwise the region that must
+func (rs *Residency) Allowed(site string) (string, bool) {
+	rs.mu.RLock()
+	region, ok := rs.bySite[site]
+	rs.mu.RUnlock()
+	return region, !ok || region == rs.region
+}
+
+// Drop counts events of site dropped by queue ingest because they belong
+// to another region
+func (rs *Residency) Drop(site string, events int) {
+	rs.count(site, func(s *ResidencyStats) { s.Dropped += int64(events) })
+}
+
+// Stats returns the counts per site since startup
+func (rs *Residency) Stats() map[string]ResidencyStats {
+	rs.statsMu.Lock()
+	defer rs.statsMu.Unlock()
+	stats := make(map[string]ResidencyStats, len(rs.stats))
+	for site, s := range rs.stats {
+		stats[site] = *s
+	}
+	return stats
+}
+
+func (rs *Residency) count(site string, f func(*ResidencyStats)) {
+	rs.statsMu.Lock()
+	defer rs.statsMu.Unlock()
+	s, ok := rs.stats[site]
+	if !ok {
+		s = &ResidencyStats{}
+		rs.stats[site] = s
+	}
+	f(s)
+}
+
+// Middleware returns HTTP middleware that forwards or rejects collect
+// requests of sites resident in another region. A request that was already
+// forwarded once is rejected rather than forwarded again, so misconfigured
+// collectors cannot loop.
+func (rs *Residency) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || !strings.HasPrefix(r.URL.Path, "/collect") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		site := r.Header.Get("X-Site-Id")
+		region, ok := rs.Allowed(site)
+		if ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if proxy, ok := rs.proxies[region]; ok && r.Header.Get(ForwardedRegionHeader) == "" {
+			rs.count(site, func(s *ResidencyStats) { s.Forwarded++ })
+			proxy.ServeHTTP(w, r)
+			return
+		}
+
+		rs.count(site, func(s *ResidencyStats) { s.Rejected++ })
+		slog.Debug("collect request rejected by data residency", "site_id", site, "region", region, "path", r.URL.Path)
+		w.Header().Set(RegionHeader, region)
+		http.Error(w, "site data must be sent to region "+region, http.StatusMisdirectedRequest)
+	})
+}
+
+// newProxy forwards requests to the collector of region at u, keeping the
+// client's address and the path
+func (rs *Residency) newProxy(region string, u *url.URL) *httputil.ReverseProxy {
+	return &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.SetURL(u)
+			pr.Out.Header["X-Forwarded-For"] = pr.In.Header["X-Forwarded-For"]
+			pr.SetXForwarded()
+			from := rs.region
+			if from == "" {
+				from = "none"
+			}
+			pr.Out.Header.Set(ForwardedRegionHeader, from)
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			slog.Error("failed to forward collect request", "region", region, "site_id", r.Header.Get("X-Site-Id"), "error", err)
+			http.Error(w, "region "+region+" unavailable", http.StatusBadGateway)
+		},
+	}
+}
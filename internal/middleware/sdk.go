@@ -0,0 +1,135 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mcbile/product-pulse/internal/sdk"
+	"github.com/mcbile/product-pulse/internal/storage"
+)
+
+// SDKStorage persists SDK version usage
+type SDKStorage interface {
+	RecordSDKUsage(ctx context.Context, usage []storage.SDKUsage) error
+}
+
+type sdkUsageKey struct {
+	day      time.Time
+	sdk      string
+	version  string
+	siteID   string
+	producer string
+}
+
+// SDKTracker counts collect requests per SDK version and flags SDKs below the
+// configured minimum version with a deprecation response header
+type SDKTracker struct {
+	storage  SDKStorage
+	policy   sdk.Policy
+	interval time.Duration
+
+	mu      sync.Mutex
+	pending map[sdkUsageKey]*storage.SDKUsage
+}
+
+// NewSDKTracker creates a new SDK version tracker
+func NewSDKTracker(store SDKStorage, policy sdk.Policy, interval time.Duration) *SDKTracker {
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+	return &SDKTracker{
+		storage:  store,
+		policy:   policy,
+		interval: interval,
+		pending:  make(map[sdkUsageKey]*storage.SDKUsage),
+	}
+}
+
+// Start flushes recorded usage until ctx is cancelled
+func (st *SDKTracker) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(st.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				st.Flush(ctx)
+			case <-ctx.Done():
+				st.Flush(context.Background())
+				return
+			}
+		}
+	}()
+}
+
+// Record counts one request from an SDK version
+func (st *SDKTracker) Record(name, version, siteID, producer string) {
+	now := time.Now().UTC()
+	key := sdkUsageKey{
+		day:      now.Truncate(24 * time.Hour),
+		sdk:      name,
+		version:  version,
+		siteID:   siteID,
+		producer: producer,
+	}
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	u, ok := st.pending[key]
+	if !ok {
+		u = &storage.SDKUsage{
+			Day:      key.day,
+			SDK:      name,
+			Version:  version,
+			SiteID:   siteID,
+			Producer: producer,
+		}
+		st.pending[key] = u
+	}
+	u.Requests++
+	u.LastSeenAt = now
+}
+
+// Flush writes pending usage to storage
+func (st *SDKTracker) Flush(ctx context.Context) {
+	st.mu.Lock()
+	if len(st.pending) == 0 {
+		st.mu.Unlock()
+		return
+	}
+	usage := make([]storage.SDKUsage, 0, len(st.pending))
+	for _, u := range st.pending {
+		usage = append(usage, *u)
+	}
+	st.pending = make(map[sdkUsageKey]*storage.SDKUsage)
+	st.mu.Unlock()
+
+	if err := st.storage.RecordSDKUsage(ctx, usage); err != nil {
+		slog.Error("failed to record sdk usage", "rows", len(usage), "error", err)
+	}
+}
+
+// Middleware returns HTTP middleware that records the X-Pulse-SDK header on
+// collect endpoints and sets the deprecation header for outdated SDKs
+func (st *SDKTracker) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost && strings.HasPrefix(r.URL.Path, "/collect") {
+			if name, version := sdk.Parse(r.Header.Get(sdk.Header)); name != "" && version != "" {
+				st.Record(name, version, r.Header.Get("X-Site-Id"), r.Header.Get(ProducerHeader))
+
+				if min, deprecated := st.policy.Deprecated(name, version); deprecated {
+					w.Header().Set(sdk.DeprecationHeader,
+						fmt.Sprintf("%s/%s is deprecated, please upgrade to %s or later", name, version, min))
+				}
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
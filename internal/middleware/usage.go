@@ -0,0 +1,420 @@
+package middleware
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mcbile/product-pulse/internal/storage"
+)
+
+// UsageStorage persists ingest usage and loads quotas
+type UsageStorage interface {
+	RecordSiteUsage(ctx context.Context, usage []storage.SiteUsage) error
+	GetSiteUsage(ctx context.Context, start, end time.Time, siteID string) ([]storage.SiteUsage, error)
+	GetSiteQuotas(ctx context.Context) ([]storage.SiteQuota, error)
+}
+
+// UsageCounts is what was ingested for a site and metric type
+type UsageCounts struct {
+	Requests  int64 `json:"requests"`
+	Events    int64 `json:"events"`
+	WireBytes int64 `json:"wire_bytes"` // Body bytes as sent
+	RawBytes  int64 `json:"raw_bytes"`  // Body bytes decoded
+}
+
+func (u *UsageCounts) add(o UsageCounts) {
+	u.Requests += o.Requests
+	u.Events += o.Events
+	u.WireBytes += o.WireBytes
+	u.RawBytes += o.RawBytes
+}
+
+type usageKey struct {
+	day              time.Time // Zero in totals since startup
+	site, metricType string
+}
+
+// UsageMeter accounts the requests, events and body bytes of collect
+// requests per site and metric type, before and after decompression, and
+// refuses requests of sites over their daily quota with 429. NATS messages
+// are accounted as requests through Admit. Usage is
+// aggregated in memory and added to storage periodically; quotas are
+// checked against the stored totals of the day plus this collector's
+// unflushed usage, so with several collectors a site may overshoot its
+// quota by what they accept within one interval.
+type UsageMeter struct {
+	storage  UsageStorage
+	interval time.Duration
+
+	mu       sync.Mutex
+	pending  map[usageKey]*UsageCounts // Not yet in storage, by day
+	totals   map[usageKey]*UsageCounts // Since startup, for /metrics
+	unstored map[string]*UsageCounts   // Pending usage of today per site
+	stored   map[string]UsageCounts    // Stored usage of storedOn per site
+	storedOn time.Time
+	quotas   map[string]storage.SiteQuota
+	rejected map[string]int64 // Requests refused over quota per site
+}
+
+// NewUsageMeter creates the usage meter, flushing usage and reloading
+// quotas every interval
+func NewUsageMeter(store UsageStorage, interval time.Duration) *UsageMeter {
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+	return &UsageMeter{
+		storage:  store,
+		interval: interval,
+		pending:  make(map[usageKey]*UsageCounts),
+		totals:   make(map[usageKey]*UsageCounts),
+		unstored: make(map[string]*UsageCounts),
+		stored:   make(map[string]UsageCounts),
+		quotas:   make(map[string]storage.SiteQuota),
+		rejected: make(map[string]int64),
+	}
+}
+
+// Start loads quotas and today's usage, then flushes usage and reloads
+// them until ctx is cancelled
+func (um *UsageMeter) Start(ctx context.Context) error {
+	if err := um.Reload(ctx); err != nil {
+		return err
+	}
+
+	go func() {
+		ticker := time.NewTicker(um.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				um.Flush(ctx)
+				if err := um.Reload(ctx); err != nil {
+					slog.Error("failed to reload site quotas", "error", err)
+				}
+			case <-ctx.Done():
+				um.Flush(context.Background())
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// Reload replaces the cached quotas and stored usage of today
+func (um *UsageMeter) Reload(ctx context.Context) error {
+	quotas, err := um.storage.GetSiteQuotas(ctx)
+	if err != nil {
+		return err
+	}
+	today := usageDay(time.Now())
+	usage, err := um.storage.GetSiteUsage(ctx, today, today, "")
+	if err != nil {
+		return err
+	}
+
+	byQuota := make(map[string]storage.SiteQuota, len(quotas))
+	for _, q := range quotas {
+		byQuota[q.SiteID] = q
+	}
+	stored := make(map[string]UsageCounts)
+	for _, u := range usage {
+		s := stored[u.SiteID]
+		s.add(UsageCounts{Requests: u.Requests, Events: u.Events, WireBytes: u.WireBytes, RawBytes: u.RawBytes})
+		stored[u.SiteID] = s
+	}
+
+	um.mu.Lock()
+	um.quotas = byQuota
+	um.stored = stored
+	um.storedOn = today
+	um.mu.Unlock()
+	return nil
+}
+
+// Flush adds pending usage to storage
+func (um *UsageMeter) Flush(ctx context.Context) {
+	um.mu.Lock()
+	if len(um.pending) == 0 {
+		um.mu.Unlock()
+		return
+	}
+	usage := make([]storage.SiteUsage, 0, len(um.pending))
+	for key, u := range um.pending {
+		usage = append(usage, storage.SiteUsage{
+			Day:        key.day,
+			SiteID:     key.site,
+			MetricType: key.metricType,
+			Requests:   u.Requests,
+			Events:     u.Events,
+			WireBytes:  u.WireBytes,
+			RawBytes:   u.RawBytes,
+		})
+	}
+	um.pending = make(map[usageKey]*UsageCounts)
+	um.unstored = make(map[string]*UsageCounts)
+	um.mu.Unlock()
+
+	if err := um.storage.RecordSiteUsage(ctx, usage); err != nil {
+		slog.Error("failed to record site usage", "entries", len(usage), "error", err)
+	}
+}
+
+// Record accounts usage of site and metricType
+func (um *UsageMeter) Record(site, metricType string, u UsageCounts) {
+	day := usageDay(time.Now())
+
+	um.mu.Lock()
+	defer um.mu.Unlock()
+	usageCounts(um.pending, usageKey{day: day, site: site, metricType: metricType}).add(u)
+	usageCounts(um.totals, usageKey{site: site, metricType: metricType}).add(u)
+
+	s, ok := um.unstored[site]
+	if !ok {
+		s = &UsageCounts{}
+		um.unstored[site] = s
+	}
+	s.add(u)
+}
+
+func usageCounts(m map[usageKey]*UsageCounts, key usageKey) *UsageCounts {
+	u, ok := m[key]
+	if !ok {
+		u = &UsageCounts{}
+		m[key] = u
+	}
+	return u
+}
+
+// Admit accounts events of site and metricType received other than by a
+// collect request, such as a NATS message of size bytes, and reports
+// whether the site is under its daily quota. Events of sites over it are
+// counted as rejected instead.
+func (um *UsageMeter) Admit(site, metricType string, events int, bytes int64) bool {
+	if um.overQuota(site) {
+		return false
+	}
+	um.Record(site, metricType, UsageCounts{Requests: 1, Events: int64(events), WireBytes: bytes, RawBytes: bytes})
+	return true
+}
+
+// Today returns the usage of site today across collectors, as of the last
+// reload, plus this collector's since
+func (um *UsageMeter) Today(site string) UsageCounts {
+	um.mu.Lock()
+	defer um.mu.Unlock()
+	return um.today(site)
+}
+
+func (um *UsageMeter) today(site string) UsageCounts {
+	var u UsageCounts
+	if um.storedOn.Equal(usageDay(time.Now())) {
+		u = um.stored[site]
+	}
+	if s, ok := um.unstored[site]; ok {
+		u.add(*s)
+	}
+	return u
+}
+
+// overQuota reports whether site has reached a limit of its quota today
+func (um *UsageMeter) overQuota(site string) bool {
+	um.mu.Lock()
+	defer um.mu.Unlock()
+	q, ok := um.quotas[site]
+	if !ok {
+		return false
+	}
+	u := um.today(site)
+	if (q.MaxEvents > 0 && u.Events >= q.MaxEvents) || (q.MaxBytes > 0 && u.RawBytes >= q.MaxBytes) {
+		um.rejected[site]++
+		return true
+	}
+	return false
+}
+
+// WritePrometheus writes the usage since startup in the Prometheus text
+// exposition format
+func (um *UsageMeter) WritePrometheus(w io.Writer) {
+	um.mu.Lock()
+	keys := make([]usageKey, 0, len(um.totals))
+	totals := make(map[usageKey]UsageCounts, len(um.totals))
+	for key, u := range um.totals {
+		keys = append(keys, key)
+		totals[key] = *u
+	}
+	rejected := make(map[string]int64, len(um.rejected))
+	for site, n := range um.rejected {
+		rejected[site] = n
+	}
+	um.mu.Unlock()
+
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].site != keys[j].site {
+			return keys[i].site < keys[j].site
+		}
+		return keys[i].metricType < keys[j].metricType
+	})
+
+	metrics := []struct {
+		name, help string
+		value      func(u UsageCounts) int64
+	}{
+		{"pulse_ingest_requests_total", "Collect requests per site and metric type.", func(u UsageCounts) int64 { return u.Requests }},
+		{"pulse_ingest_events_total", "Events sent per site and metric type.", func(u UsageCounts) int64 { return u.Events }},
+		{"pulse_ingest_wire_bytes_total", "Request body bytes as sent, possibly compressed.", func(u UsageCounts) int64 { return u.WireBytes }},
+		{"pulse_ingest_raw_bytes_total", "Request body bytes after decompression.", func(u UsageCounts) int64 { return u.RawBytes }},
+	}
+	for _, m := range metrics {
+		writeHelp(w, m.name, m.help, "counter")
+		for _, key := range keys {
+			io.WriteString(w, m.name+`{site_id="`+escapeLabel(key.site)+`",metric_type="`+escapeLabel(key.metricType)+`"} `+
+				strconv.FormatInt(m.value(totals[key]), 10)+"\n")
+		}
+	}
+
+	sites := make([]string, 0, len(rejected))
+	for site := range rejected {
+		sites = append(sites, site)
+	}
+	sort.Strings(sites)
+	writeHelp(w, "pulse_quota_rejected_total", "Collect requests and NATS messages refused because the site is over its daily quota.", "counter")
+	for _, site := range sites {
+		io.WriteString(w, `pulse_quota_rejected_total{site_id="`+escapeLabel(site)+`"} `+strconv.FormatInt(rejected[site], 10)+"\n")
+	}
+}
+
+func writeHelp(w io.Writer, name, help, typ string) {
+	io.WriteString(w, "# HELP "+name+" "+help+"\n# TYPE "+name+" "+typ+"\n")
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+// Middleware returns HTTP middleware that accounts collect requests and
+// refuses those of sites over their daily quota
+func (um *UsageMeter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || !strings.HasPrefix(r.URL.Path, "/collect") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		endpointType := collectMetricType(r.URL.Path)
+		if endpointType == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		site := r.Header.Get("X-Site-Id")
+		if um.overQuota(site) {
+			now := time.Now().UTC()
+			retryAfter := usageDay(now).Add(24 * time.Hour).Sub(now)
+			w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())+1))
+			http.Error(w, "daily ingest quota exceeded", http.StatusTooManyRequests)
+			return
+		}
+
+		body := &countingReadCloser{ReadCloser: r.Body}
+		r.Body = body
+		ru := &requestUsage{raw: -1}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestUsageKey{}, ru)))
+		wire := body.n
+		if r.ContentLength > wire {
+			wire = r.ContentLength // Handlers may stop reading early
+		}
+		um.record(site, endpointType, wire, ru)
+	})
+}
+
+// record accounts a request: its bytes are split across the metric types
+// it carried in proportion to their events, or go to the endpoint's type
+// if it carried none
+func (um *UsageMeter) record(site, endpointType string, wire int64, ru *requestUsage) {
+	raw := ru.raw
+	if raw < 0 {
+		raw = wire
+	}
+
+	var total int64
+	for _, e := range ru.events {
+		total += e.n
+	}
+	if total == 0 {
+		um.Record(site, endpointType, UsageCounts{Requests: 1, WireBytes: wire, RawBytes: raw})
+		return
+	}
+
+	wireLeft, rawLeft := wire, raw
+	for i, e := range ru.events {
+		u := UsageCounts{Requests: 1, Events: e.n}
+		if i == len(ru.events)-1 {
+			u.WireBytes, u.RawBytes = wireLeft, rawLeft
+		} else {
+			u.WireBytes, u.RawBytes = wire*e.n/total, raw*e.n/total
+		}
+		wireLeft -= u.WireBytes
+		rawLeft -= u.RawBytes
+		um.Record(site, e.metricType, u)
+	}
+}
+
+// usageDay truncates t to its UTC day
+func usageDay(t time.Time) time.Time {
+	y, m, d := t.UTC().Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+type countingReadCloser struct {
+	io.ReadCloser
+	n int64
+}
+
+func (c *countingReadCloser) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+type requestUsageKey struct{}
+
+// requestUsage is what handlers report about a request to the usage meter
+type requestUsage struct {
+	raw    int64 // Decoded body bytes, -1 if not reported
+	events []typedCount
+}
+
+type typedCount struct {
+	metricType string
+	n          int64
+}
+
+// ReportEvents lets collect handlers tell the usage meter how many events
+// of metricType a request carried. It is a no-op outside the meter
+// middleware.
+func ReportEvents(r *http.Request, metricType string, n int) {
+	if n <= 0 {
+		return
+	}
+	if ru, ok := r.Context().Value(requestUsageKey{}).(*requestUsage); ok {
+		ru.events = append(ru.events, typedCount{metricType, int64(n)})
+	}
+}
+
+// ReportDecodedBytes lets collect handlers tell the usage meter the size of
+// a compressed request body after decompression. It is a no-op outside the
+// meter middleware.
+func ReportDecodedBytes(r *http.Request, n int64) {
+	if ru, ok := r.Context().Value(requestUsageKey{}).(*requestUsage); ok {
+		ru.raw = n
+	}
+}
@@ -0,0 +1,349 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Supported request body content types
+const (
+	ContentTypeJSON     = "application/json"
+	ContentTypeMsgPack  = "application/msgpack"
+	ContentTypeProtobuf = "application/x-protobuf"
+)
+
+// ErrUnsupportedContentType is returned by Decode for bodies it cannot parse
+var ErrUnsupportedContentType = errors.New("unsupported content type")
+
+// ProtoUnmarshaler is implemented by the batch types that have a pulse.proto
+// message
+type ProtoUnmarshaler interface {
+	UnmarshalProto([]byte) error
+}
+
+// MediaType normalizes a Content-Type header to one of the ContentType
+// constants. Empty and unknown text types fall back to JSON so existing
+// clients that send text/plain (e.g. navigator.sendBeacon) keep working.
+func MediaType(contentType string) string {
+	if contentType == "" {
+		return ContentTypeJSON
+	}
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ContentTypeJSON
+	}
+	switch mt {
+	case "application/msgpack", "application/x-msgpack", "application/vnd.msgpack":
+		return ContentTypeMsgPack
+	case "application/x-protobuf", "application/protobuf", "application/vnd.google.protobuf":
+		return ContentTypeProtobuf
+	}
+	return ContentTypeJSON
+}
+
+// Decode reads a request body encoded as contentType into v. Protobuf bodies
+// require v to implement ProtoUnmarshaler.
+func Decode(r io.Reader, contentType string, v interface{}) error {
+	switch MediaType(contentType) {
+	case ContentTypeMsgPack:
+		dec := msgpack.NewDecoder(r)
+		dec.SetCustomStructTag("json")
+		return dec.Decode(v)
+
+	case ContentTypeProtobuf:
+		pm, ok := v.(ProtoUnmarshaler)
+		if !ok {
+			return fmt.Errorf("%w: %s", ErrUnsupportedContentType, ContentTypeProtobuf)
+		}
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		return pm.UnmarshalProto(data)
+
+	default:
+		return json.NewDecoder(r).Decode(v)
+	}
+}
+
+// Rejection is an event left out of a batch by DecodeBatch
+type Rejection struct {
+	Section string `json:"section"` // Batch field holding the event, e.g. "events" or "api"
+	Index   int    `json:"index"`   // Position of the event in the section
+	Reason  string `json:"reason"`
+}
+
+// DecodeBatch decodes a batch like Decode, but decodes JSON batches one
+// event at a time: events that fail to decode (wrong field types, bad
+// timestamps) are left out and returned as rejections instead of failing
+// the batch. Bodies that are not valid JSON, and other content types, still
+// fail as a whole. v must point to a struct of event slices, such as
+// EventBatch or BatchEnvelope. drift, if not nil, is called for the first
+// events of each JSON section whose fields differ from the model; see
+// FieldDrift.
+func DecodeBatch(r io.Reader, contentType string, v interface{}, drift FieldDrift) ([]Rejection, error) {
+	if MediaType(contentType) != ContentTypeJSON {
+		return nil, Decode(r, contentType, v)
+	}
+
+	var sections map[string]json.RawMessage
+	if err := json.NewDecoder(r).Decode(&sections); err != nil {
+		return nil, err
+	}
+
+	var rejections []Rejection
+	rv := reflect.ValueOf(v).Elem()
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		field := rt.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		raw, ok := batchSection(sections, name)
+		if !ok || field.Type.Kind() != reflect.Slice {
+			continue
+		}
+
+		var items []json.RawMessage
+		if err := json.Unmarshal(raw, &items); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		events := reflect.MakeSlice(field.Type, 0, len(items))
+		for j, item := range items {
+			event := reflect.New(field.Type.Elem())
+			if err := json.Unmarshal(item, event.Interface()); err != nil {
+				rejections = append(rejections, Rejection{Section: name, Index: j, Reason: rejectionReason(err)})
+				continue
+			}
+			events = reflect.Append(events, event.Elem())
+
+			if drift != nil && j < driftChecks {
+				if unknown, missing := fieldDrift(item, fieldsOf(field.Type.Elem())); len(unknown) > 0 || len(missing) > 0 {
+					drift(name, unknown, missing)
+				}
+			}
+		}
+		rv.Field(i).Set(events)
+	}
+
+	return rejections, nil
+}
+
+// batchSection looks up a batch field the way encoding/json matches struct
+// fields: exactly, or else case-insensitively
+func batchSection(sections map[string]json.RawMessage, name string) (json.RawMessage, bool) {
+	if raw, ok := sections[name]; ok {
+		return raw, true
+	}
+	for k, raw := range sections {
+		if strings.EqualFold(k, name) {
+			return raw, true
+		}
+	}
+	return nil, false
+}
+
+// rejectionReason describes a decode error without Go type names
+func rejectionReason(err error) string {
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) && typeErr.Field != "" {
+		return fmt.Sprintf("%s: unexpected %s", typeErr.Field, typeErr.Value)
+	}
+	return err.Error()
+}
+
+// ============================================
+// FIELD DRIFT
+// ============================================
+
+// FieldDrift receives the top-level fields of an event that its model does
+// not know (unknown) and the fields the model expects that it lacks or
+// sends as null (missing). Drift between what SDKs send and what the
+// collector reads shows incompatible SDK releases: renamed or misspelled
+// fields are otherwise dropped silently.
+type FieldDrift func(section string, unknown, missing []string)
+
+// Bounds of the drift check. A batch comes from one producer, so its first
+// events are representative; the field bounds keep garbage keys from
+// flooding the drift report.
+const (
+	driftChecks     = 20
+	maxDriftFields  = 20
+	maxDriftNameLen = 100
+)
+
+// eventFields are the JSON fields of a model type. Expected fields are
+// those that are neither pointers, nor omitempty, nor raw JSON: leaving
+// them out makes the collector read a zero value.
+type eventFields struct {
+	known    map[string]bool // Lowercased, encoding/json matches case-insensitively
+	expected []string
+}
+
+var eventFieldsCache sync.Map // reflect.Type -> *eventFields
+
+func fieldsOf(t reflect.Type) *eventFields {
+	if f, ok := eventFieldsCache.Load(t); ok {
+		return f.(*eventFields)
+	}
+
+	f := &eventFields{known: make(map[string]bool)}
+	rawJSON := reflect.TypeOf(json.RawMessage{})
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if !field.IsExported() || tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if name == "" {
+			name = field.Name
+		}
+		f.known[strings.ToLower(name)] = true
+		if field.Type.Kind() != reflect.Pointer && field.Type != rawJSON && !strings.Contains(opts, "omitempty") {
+			f.expected = append(f.expected, name)
+		}
+	}
+	eventFieldsCache.Store(t, f)
+	return f
+}
+
+// fieldDrift compares the top-level fields of a JSON event to its model
+func fieldDrift(item json.RawMessage, f *eventFields) (unknown, missing []string) {
+	var fields map[string]json.RawMessage
+	if json.Unmarshal(item, &fields) != nil {
+		return nil, nil
+	}
+
+	present := make(map[string]bool, len(fields))
+	for k, v := range fields {
+		lower := strings.ToLower(k)
+		if string(v) != "null" {
+			present[lower] = true
+		}
+		if !f.known[lower] && len(unknown) < maxDriftFields {
+			if len(k) > maxDriftNameLen {
+				k = k[:maxDriftNameLen]
+				for !utf8.ValidString(k) {
+					k = k[:len(k)-1]
+				}
+			}
+			unknown = append(unknown, k)
+		}
+	}
+	sort.Strings(unknown)
+
+	for _, name := range f.expected {
+		if !present[strings.ToLower(name)] {
+			missing = append(missing, name)
+		}
+	}
+	return unknown, missing
+}
+
+// ============================================
+// MSGPACK TYPE MAPPING
+// ============================================
+
+// The model types are JSON-first. Metadata is json.RawMessage, which msgpack
+// would otherwise treat as a binary blob, and SDKs in other languages rarely
+// agree on a timestamp encoding. These decoders make msgpack bodies land in
+// the same shape as their JSON equivalents.
+func init() {
+	msgpack.Register(json.RawMessage{}, nil, decodeMsgpackRawJSON)
+	msgpack.Register(time.Time{}, nil, decodeMsgpackTime)
+}
+
+// decodeMsgpackRawJSON decodes any msgpack value and re-encodes it as JSON
+func decodeMsgpackRawJSON(d *msgpack.Decoder, v reflect.Value) error {
+	val, err := d.DecodeInterfaceLoose()
+	if err != nil {
+		return err
+	}
+	if val == nil {
+		v.SetBytes(nil)
+		return nil
+	}
+	b, err := json.Marshal(normalizeMsgpack(val))
+	if err != nil {
+		return fmt.Errorf("metadata: %w", err)
+	}
+	v.SetBytes(b)
+	return nil
+}
+
+// normalizeMsgpack converts map[interface{}]interface{} (produced for maps
+// with non-string keys) into something encoding/json accepts
+func normalizeMsgpack(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, e := range t {
+			t[k] = normalizeMsgpack(e)
+		}
+		return t
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(t))
+		for k, e := range t {
+			m[fmt.Sprint(k)] = normalizeMsgpack(e)
+		}
+		return m
+	case []interface{}:
+		for i, e := range t {
+			t[i] = normalizeMsgpack(e)
+		}
+		return t
+	case []byte:
+		return string(t)
+	}
+	return v
+}
+
+// decodeMsgpackTime accepts the msgpack timestamp extension, an RFC 3339
+// string or Unix milliseconds
+func decodeMsgpackTime(d *msgpack.Decoder, v reflect.Value) error {
+	val, err := d.DecodeInterfaceLoose()
+	if err != nil {
+		return err
+	}
+
+	var t time.Time
+	switch x := val.(type) {
+	case nil:
+	case time.Time:
+		t = x
+	case string:
+		if strings.TrimSpace(x) != "" {
+			t, err = time.Parse(time.RFC3339Nano, x)
+			if err != nil {
+				return fmt.Errorf("time: %w", err)
+			}
+		}
+	case int64:
+		t = unixMilli(x)
+	case uint64:
+		t = unixMilli(int64(x))
+	case float64:
+		t = unixMilli(int64(x))
+	default:
+		return fmt.Errorf("time: unsupported msgpack type %T", val)
+	}
+	v.Set(reflect.ValueOf(t))
+	return nil
+}
+
+// unixMilli maps 0 to the zero time so handlers stamp the server time
+func unixMilli(ms int64) time.Time {
+	if ms == 0 {
+		return time.Time{}
+	}
+	return time.UnixMilli(ms).UTC()
+}
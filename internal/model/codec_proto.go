@@ -0,0 +1,490 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Protobuf decoding for the messages in pulse.proto. Decoding works directly
+// on the wire format into the model types, so there is no intermediate
+// message struct to allocate and copy on the hot path.
+
+var errProtoWireType = errors.New("protobuf: unexpected wire type")
+
+// protoReader consumes fields from a protobuf message. The first error is
+// sticky; callers check err once after the loop.
+type protoReader struct {
+	b   []byte
+	err error
+}
+
+func (r *protoReader) next() (protowire.Number, protowire.Type, bool) {
+	if r.err != nil || len(r.b) == 0 {
+		return 0, 0, false
+	}
+	num, typ, n := protowire.ConsumeTag(r.b)
+	if n < 0 {
+		r.err = protowire.ParseError(n)
+		return 0, 0, false
+	}
+	r.b = r.b[n:]
+	return num, typ, true
+}
+
+func (r *protoReader) consumed(n int) {
+	if n < 0 {
+		r.err = protowire.ParseError(n)
+		return
+	}
+	r.b = r.b[n:]
+}
+
+func (r *protoReader) expect(got, want protowire.Type) bool {
+	if got != want {
+		r.err = errProtoWireType
+		return false
+	}
+	return true
+}
+
+func (r *protoReader) bytes(typ protowire.Type) []byte {
+	if !r.expect(typ, protowire.BytesType) {
+		return nil
+	}
+	v, n := protowire.ConsumeBytes(r.b)
+	r.consumed(n)
+	return v
+}
+
+func (r *protoReader) string(typ protowire.Type) string {
+	return string(r.bytes(typ))
+}
+
+func (r *protoReader) stringPtr(typ protowire.Type) *string {
+	s := r.string(typ)
+	return &s
+}
+
+func (r *protoReader) varint(typ protowire.Type) uint64 {
+	if !r.expect(typ, protowire.VarintType) {
+		return 0
+	}
+	v, n := protowire.ConsumeVarint(r.b)
+	r.consumed(n)
+	return v
+}
+
+func (r *protoReader) int(typ protowire.Type) int {
+	return int(int32(r.varint(typ)))
+}
+
+func (r *protoReader) intPtr(typ protowire.Type) *int {
+	v := r.int(typ)
+	return &v
+}
+
+func (r *protoReader) bool(typ protowire.Type) bool {
+	return r.varint(typ) != 0
+}
+
+func (r *protoReader) time(typ protowire.Type) time.Time {
+	return unixMilli(int64(r.varint(typ)))
+}
+
+func (r *protoReader) double(typ protowire.Type) float64 {
+	if !r.expect(typ, protowire.Fixed64Type) {
+		return 0
+	}
+	v, n := protowire.ConsumeFixed64(r.b)
+	r.consumed(n)
+	return math.Float64frombits(v)
+}
+
+func (r *protoReader) doublePtr(typ protowire.Type) *float64 {
+	v := r.double(typ)
+	return &v
+}
+
+// metadata validates the metadata_json string so a malformed value is
+// rejected here rather than by the database
+func (r *protoReader) metadata(typ protowire.Type) json.RawMessage {
+	b := r.bytes(typ)
+	if len(b) == 0 {
+		return nil
+	}
+	if !json.Valid(b) {
+		r.err = errors.New("protobuf: metadata_json is not valid JSON")
+		return nil
+	}
+	return json.RawMessage(append([]byte(nil), b...))
+}
+
+func (r *protoReader) skip(num protowire.Number, typ protowire.Type) {
+	r.consumed(protowire.ConsumeFieldValue(num, typ, r.b))
+}
+
+// decodeRepeated decodes field 1 of a batch message with fn
+func decodeRepeated(b []byte, fn func([]byte) error) error {
+	r := &protoReader{b: b}
+	for {
+		num, typ, ok := r.next()
+		if !ok {
+			break
+		}
+		if num != 1 {
+			r.skip(num, typ)
+			continue
+		}
+		msg := r.bytes(typ)
+		if r.err != nil {
+			break
+		}
+		if err := fn(msg); err != nil {
+			return err
+		}
+	}
+	return r.err
+}
+
+// UnmarshalProto decodes a pulse.v1.BatchEnvelope
+func (b *BatchEnvelope) UnmarshalProto(data []byte) error {
+	r := &protoReader{b: data}
+	for {
+		num, typ, ok := r.next()
+		if !ok {
+			break
+		}
+		if num < 1 || num > 5 {
+			r.skip(num, typ)
+			continue
+		}
+		msg := r.bytes(typ)
+		if r.err != nil {
+			break
+		}
+
+		var err error
+		switch num {
+		case 1:
+			var e FrontendEvent
+			if e, err = decodeFrontendEvent(msg); err == nil {
+				b.Events = append(b.Events, e)
+			}
+		case 2:
+			var m APIMetric
+			if m, err = decodeAPIMetric(msg); err == nil {
+				b.API = append(b.API, m)
+			}
+		case 3:
+			var m PSPMetric
+			if m, err = decodePSPMetric(msg); err == nil {
+				b.PSP = append(b.PSP, m)
+			}
+		case 4:
+			var m GameMetric
+			if m, err = decodeGameMetric(msg); err == nil {
+				b.Game = append(b.Game, m)
+			}
+		case 5:
+			var m WebSocketMetric
+			if m, err = decodeWebSocketMetric(msg); err == nil {
+				b.WS = append(b.WS, m)
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("field %d: %w", num, err)
+		}
+	}
+	return r.err
+}
+
+// UnmarshalProto decodes a pulse.v1.EventBatch
+func (b *EventBatch) UnmarshalProto(data []byte) error {
+	return decodeRepeated(data, func(msg []byte) error {
+		e, err := decodeFrontendEvent(msg)
+		if err != nil {
+			return fmt.Errorf("event %d: %w", len(b.Events), err)
+		}
+		b.Events = append(b.Events, e)
+		return nil
+	})
+}
+
+func decodeFrontendEvent(msg []byte) (FrontendEvent, error) {
+	var e FrontendEvent
+	r := &protoReader{b: msg}
+	for {
+		num, typ, ok := r.next()
+		if !ok {
+			break
+		}
+		switch num {
+		case 1:
+			e.Time = r.time(typ)
+		case 2:
+			e.SessionID = r.string(typ)
+		case 3:
+			e.PlayerID = r.stringPtr(typ)
+		case 4:
+			e.DeviceType = r.string(typ)
+		case 5:
+			e.Browser = r.string(typ)
+		case 6:
+			e.Country = r.stringPtr(typ)
+		case 7:
+			e.EventType = r.string(typ)
+		case 8:
+			e.PagePath = r.string(typ)
+		case 9:
+			e.Release = r.stringPtr(typ)
+		case 10:
+			e.Platform = r.stringPtr(typ)
+		case 11:
+			e.LCP = r.doublePtr(typ)
+		case 12:
+			e.FID = r.doublePtr(typ)
+		case 13:
+			e.CLS = r.doublePtr(typ)
+		case 14:
+			e.TTFB = r.doublePtr(typ)
+		case 15:
+			e.FCP = r.doublePtr(typ)
+		case 16:
+			e.INP = r.doublePtr(typ)
+		case 17:
+			e.MetricName = r.stringPtr(typ)
+		case 18:
+			e.MetricValue = r.doublePtr(typ)
+		case 19:
+			e.Metadata = r.metadata(typ)
+		case 20:
+			e.EventID = r.stringPtr(typ)
+		case 21:
+			e.Campaign = r.stringPtr(typ)
+		default:
+			r.skip(num, typ)
+		}
+	}
+	return e, r.err
+}
+
+// UnmarshalProto decodes a pulse.v1.APIMetricBatch
+func (b *APIMetricBatch) UnmarshalProto(data []byte) error {
+	return decodeRepeated(data, func(msg []byte) error {
+		m, err := decodeAPIMetric(msg)
+		if err != nil {
+			return fmt.Errorf("metric %d: %w", len(b.Metrics), err)
+		}
+		b.Metrics = append(b.Metrics, m)
+		return nil
+	})
+}
+
+func decodeAPIMetric(msg []byte) (APIMetric, error) {
+	var m APIMetric
+	r := &protoReader{b: msg}
+	for {
+		num, typ, ok := r.next()
+		if !ok {
+			break
+		}
+		switch num {
+		case 1:
+			m.Time = r.time(typ)
+		case 2:
+			m.ServiceName = r.string(typ)
+		case 3:
+			m.Endpoint = r.string(typ)
+		case 4:
+			m.Method = r.string(typ)
+		case 5:
+			m.DurationMS = r.double(typ)
+		case 6:
+			m.StatusCode = r.int(typ)
+		case 7:
+			m.PlayerID = r.stringPtr(typ)
+		case 8:
+			m.RequestID = r.stringPtr(typ)
+		case 9:
+			m.ErrorType = r.stringPtr(typ)
+		case 10:
+			m.ErrorMessage = r.stringPtr(typ)
+		case 11:
+			m.RequestSize = r.intPtr(typ)
+		case 12:
+			m.ResponseSize = r.intPtr(typ)
+		case 13:
+			m.Metadata = r.metadata(typ)
+		default:
+			r.skip(num, typ)
+		}
+	}
+	return m, r.err
+}
+
+// UnmarshalProto decodes a pulse.v1.PSPMetricBatch
+func (b *PSPMetricBatch) UnmarshalProto(data []byte) error {
+	return decodeRepeated(data, func(msg []byte) error {
+		m, err := decodePSPMetric(msg)
+		if err != nil {
+			return fmt.Errorf("metric %d: %w", len(b.Metrics), err)
+		}
+		b.Metrics = append(b.Metrics, m)
+		return nil
+	})
+}
+
+func decodePSPMetric(msg []byte) (PSPMetric, error) {
+	var m PSPMetric
+	r := &protoReader{b: msg}
+	for {
+		num, typ, ok := r.next()
+		if !ok {
+			break
+		}
+		switch num {
+		case 1:
+			m.Time = r.time(typ)
+		case 2:
+			m.PSPName = r.string(typ)
+		case 3:
+			m.Operation = r.string(typ)
+		case 4:
+			m.DurationMS = r.double(typ)
+		case 5:
+			m.Success = r.bool(typ)
+		case 6:
+			m.PlayerID = r.stringPtr(typ)
+		case 7:
+			m.TransactionID = r.stringPtr(typ)
+		case 8:
+			m.Amount = r.doublePtr(typ)
+		case 9:
+			m.Currency = r.stringPtr(typ)
+		case 10:
+			m.ErrorCode = r.stringPtr(typ)
+		case 11:
+			m.ErrorMessage = r.stringPtr(typ)
+		case 12:
+			m.PSPResponseCode = r.stringPtr(typ)
+		case 13:
+			m.Metadata = r.metadata(typ)
+		case 14:
+			m.State = r.stringPtr(typ)
+		case 15:
+			m.Campaign = r.stringPtr(typ)
+		default:
+			r.skip(num, typ)
+		}
+	}
+	return m, r.err
+}
+
+// UnmarshalProto decodes a pulse.v1.GameMetricBatch
+func (b *GameMetricBatch) UnmarshalProto(data []byte) error {
+	return decodeRepeated(data, func(msg []byte) error {
+		m, err := decodeGameMetric(msg)
+		if err != nil {
+			return fmt.Errorf("metric %d: %w", len(b.Metrics), err)
+		}
+		b.Metrics = append(b.Metrics, m)
+		return nil
+	})
+}
+
+func decodeGameMetric(msg []byte) (GameMetric, error) {
+	var m GameMetric
+	r := &protoReader{b: msg}
+	for {
+		num, typ, ok := r.next()
+		if !ok {
+			break
+		}
+		switch num {
+		case 1:
+			m.Time = r.time(typ)
+		case 2:
+			m.Provider = r.string(typ)
+		case 3:
+			m.GameID = r.stringPtr(typ)
+		case 4:
+			m.GameType = r.stringPtr(typ)
+		case 5:
+			m.LoadTimeMS = r.doublePtr(typ)
+		case 6:
+			m.LaunchSuccess = r.bool(typ)
+		case 7:
+			m.PlayerID = r.stringPtr(typ)
+		case 8:
+			m.SessionID = r.stringPtr(typ)
+		case 9:
+			m.DeviceType = r.stringPtr(typ)
+		case 10:
+			m.ErrorType = r.stringPtr(typ)
+		case 11:
+			m.ErrorMessage = r.stringPtr(typ)
+		case 12:
+			m.Metadata = r.metadata(typ)
+		default:
+			r.skip(num, typ)
+		}
+	}
+	return m, r.err
+}
+
+// UnmarshalProto decodes a pulse.v1.WebSocketMetricBatch
+func (b *WebSocketMetricBatch) UnmarshalProto(data []byte) error {
+	return decodeRepeated(data, func(msg []byte) error {
+		m, err := decodeWebSocketMetric(msg)
+		if err != nil {
+			return fmt.Errorf("metric %d: %w", len(b.Metrics), err)
+		}
+		b.Metrics = append(b.Metrics, m)
+		return nil
+	})
+}
+
+func decodeWebSocketMetric(msg []byte) (WebSocketMetric, error) {
+	var m WebSocketMetric
+	r := &protoReader{b: msg}
+	for {
+		num, typ, ok := r.next()
+		if !ok {
+			break
+		}
+		switch num {
+		case 1:
+			m.Time = r.time(typ)
+		case 2:
+			m.ConnectionID = r.string(typ)
+		case 3:
+			m.PlayerID = r.stringPtr(typ)
+		case 4:
+			m.EventType = r.string(typ)
+		case 5:
+			m.LatencyMS = r.doublePtr(typ)
+		case 6:
+			m.MessagesSent = r.intPtr(typ)
+		case 7:
+			m.MessagesReceived = r.intPtr(typ)
+		case 8:
+			m.CloseCode = r.intPtr(typ)
+		case 9:
+			m.CloseReason = r.stringPtr(typ)
+		case 10:
+			m.Endpoint = r.stringPtr(typ)
+		case 11:
+			m.DeviceType = r.stringPtr(typ)
+		case 12:
+			m.Metadata = r.metadata(typ)
+		default:
+			r.skip(num, typ)
+		}
+	}
+	return m, r.err
+}
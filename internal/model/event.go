@@ -2,7 +2,9 @@ package model
 
 import (
 	"encoding/json"
+	"strings"
 	"time"
+	"unicode/utf8"
 )
 
 // EventBatch from frontend SDK
@@ -20,6 +22,8 @@ type FrontendEvent struct {
 	Country    *string   `json:"country"`
 	EventType  string    `json:"event_type"`
 	PagePath   string    `json:"page_path"`
+	Release    *string   `json:"release"`  // app/site release version
+	Platform   *string   `json:"platform"` // web, ios, android, webview
 
 	// Web Vitals
 	LCP  *float64 `json:"lcp_ms"`
@@ -35,8 +39,24 @@ type FrontendEvent struct {
 
 	// Context
 	Metadata json.RawMessage `json:"metadata"`
+
+	// Client-generated ID; retries of an already accepted event are dropped
+	EventID *string `json:"event_id"`
+
+	// Bonus/promotion campaign the player came from, see NormalizeCampaign
+	Campaign *string `json:"campaign"`
+
+	// Site that sent the event, stamped from X-Site-Id by the collect
+	// handlers
+	SiteID string `json:"site_id,omitempty"`
 }
 
+// Event types counted as crashes for stability scoring
+const (
+	EventTypeCrash      = "crash"
+	EventTypeFatalError = "fatal_error"
+)
+
 // EnrichedEvent with server-side additions
 type EnrichedEvent struct {
 	FrontendEvent
@@ -60,6 +80,7 @@ type APIMetric struct {
 	RequestSize  *int            `json:"request_size"`
 	ResponseSize *int            `json:"response_size"`
 	Metadata     json.RawMessage `json:"metadata"`
+	SiteID       string          `json:"site_id,omitempty"` // Stamped from X-Site-Id
 }
 
 // PSPMetric for payment tracking
@@ -76,7 +97,66 @@ type PSPMetric struct {
 	ErrorCode       *string         `json:"error_code"`
 	ErrorMessage    *string         `json:"error_message"`
 	PSPResponseCode *string         `json:"psp_response_code"`
+	State           *string         `json:"state"`    // Withdrawal lifecycle state, see WithdrawalStates
+	Campaign        *string         `json:"campaign"` // Bonus/promotion campaign, see NormalizeCampaign
 	Metadata        json.RawMessage `json:"metadata"`
+	SiteID          string          `json:"site_id,omitempty"` // Stamped from X-Site-Id
+}
+
+// OperationWithdrawal is the PSP operation of payouts. A withdrawal is
+// reported once per state it reaches, with the same transaction_id.
+const OperationWithdrawal = "withdrawal"
+
+// Withdrawal lifecycle states. Unlike deposits, withdrawals wait for manual
+// review and settle with a delay, so each state is tracked separately.
+const (
+	WithdrawalRequested = "requested"
+	WithdrawalApproved  = "approved"
+	WithdrawalSent      = "sent"
+	WithdrawalSettled   = "settled"
+	WithdrawalFailed    = "failed"
+)
+
+// WithdrawalStates lists the states in lifecycle order
+var WithdrawalStates = []string{
+	WithdrawalRequested, WithdrawalApproved, WithdrawalSent, WithdrawalSettled, WithdrawalFailed,
+}
+
+// ValidState reports whether the state of m is unset or a known
+// withdrawal state
+func (m *PSPMetric) ValidState() bool {
+	if m.State == nil {
+		return true
+	}
+	for _, s := range WithdrawalStates {
+		if *m.State == s {
+			return true
+		}
+	}
+	return false
+}
+
+// MaxCampaignLength is the longest campaign tag stored; longer tags are cut
+const MaxCampaignLength = 100
+
+// NormalizeCampaign trims a campaign tag and cuts it to MaxCampaignLength
+// bytes (on a rune boundary). Blank tags become nil, so untagged traffic
+// is always NULL.
+func NormalizeCampaign(campaign *string) *string {
+	if campaign == nil {
+		return nil
+	}
+	c := strings.TrimSpace(*campaign)
+	if c == "" {
+		return nil
+	}
+	if len(c) > MaxCampaignLength {
+		c = c[:MaxCampaignLength]
+		for !utf8.ValidString(c) {
+			c = c[:len(c)-1]
+		}
+	}
+	return &c
 }
 
 // GameMetric for provider tracking
@@ -93,6 +173,7 @@ type GameMetric struct {
 	ErrorType     *string         `json:"error_type"`
 	ErrorMessage  *string         `json:"error_message"`
 	Metadata      json.RawMessage `json:"metadata"`
+	SiteID        string          `json:"site_id,omitempty"` // Stamped from X-Site-Id
 }
 
 // WebSocketMetric for real-time connection tracking
@@ -109,6 +190,34 @@ type WebSocketMetric struct {
 	Endpoint         *string         `json:"endpoint"`
 	DeviceType       *string         `json:"device_type"`
 	Metadata         json.RawMessage `json:"metadata"`
+	SiteID           string          `json:"site_id,omitempty"` // Stamped from X-Site-Id
+}
+
+// Metric batches as posted to /collect/api, /collect/psp, /collect/game and
+// /collect/ws
+type APIMetricBatch struct {
+	Metrics []APIMetric `json:"metrics"`
+}
+
+type PSPMetricBatch struct {
+	Metrics []PSPMetric `json:"metrics"`
+}
+
+type GameMetricBatch struct {
+	Metrics []GameMetric `json:"metrics"`
+}
+
+type WebSocketMetricBatch struct {
+	Metrics []WebSocketMetric `json:"metrics"`
+}
+
+// BatchEnvelope carries every metric type in one request to /collect/batch
+type BatchEnvelope struct {
+	Events []FrontendEvent   `json:"events"`
+	API    []APIMetric       `json:"api"`
+	PSP    []PSPMetric       `json:"psp"`
+	Game   []GameMetric      `json:"game"`
+	WS     []WebSocketMetric `json:"ws"`
 }
 
 // CollectorStats for monitoring
@@ -120,4 +229,46 @@ type CollectorStats struct {
 	QueueSize        int     `json:"queue_size"`
 	AvgBatchSize     float64 `json:"avg_batch_size"`
 	AvgFlushTimeMS   float64 `json:"avg_flush_time_ms"`
+	EventsSpilled    int64   `json:"events_spilled"`
+	SpillBytes       int64   `json:"spill_bytes"`
+	FlushRetries     int64   `json:"flush_retries"`
+	QueueSaturation  float64 `json:"queue_saturation_pct"` // Queue depth as % of capacity
+	Throttled        int64   `json:"requests_throttled"`   // Requests answered with 429
+	WALReplayed      int64   `json:"wal_replayed"`         // Events replayed from the WAL at startup
+	WALBytes         int64   `json:"wal_bytes"`            // Unflushed events in the WAL on disk
+	WALSkipped       int64   `json:"wal_skipped"`          // Events queued without logging, WAL at WAL_MAX_BYTES
+	Deduplicated     int64   `json:"events_deduplicated"`  // Retries dropped by event ID
+	Discarded        int64   `json:"events_discarded"`     // Dropped by kill switches
+	IngestLagMS      float64 `json:"ingest_lag_ms"`        // Queue wait of the oldest event in the last flush
+}
+
+// CSPReport is a Content-Security-Policy violation report, normalized from
+// either the legacy report-uri format or the Reporting API (report-to)
+type CSPReport struct {
+	Time               time.Time `json:"time"`
+	DocumentURI        string    `json:"document_uri"`
+	Referrer           string    `json:"referrer"`
+	ViolatedDirective  string    `json:"violated_directive"`
+	EffectiveDirective string    `json:"effective_directive"`
+	OriginalPolicy     string    `json:"original_policy"`
+	Disposition        string    `json:"disposition"` // enforce, report
+	BlockedURI         string    `json:"blocked_uri"`
+	StatusCode         int       `json:"status_code"`
+	SourceFile         string    `json:"source_file"`
+	LineNumber         int       `json:"line_number"`
+	ColumnNumber       int       `json:"column_number"`
+	ScriptSample       string    `json:"script_sample"`
+	UserAgent          string    `json:"user_agent"`
+}
+
+// Producer is a service registered as a source of metrics
+type Producer struct {
+	Name          string    `json:"name"`
+	OwnerTeam     string    `json:"owner_team"`
+	SDKVersion    string    `json:"sdk_version"`
+	SiteID        string    `json:"site_id"`
+	MetricTypes   []string  `json:"metric_types"`   // declared at registration
+	ObservedTypes []string  `json:"observed_types"` // seen on collect endpoints
+	RegisteredAt  time.Time `json:"registered_at"`
+	LastSeenAt    time.Time `json:"last_seen_at"`
 }
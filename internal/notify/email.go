@@ -0,0 +1,230 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"html/template"
+	"mime"
+	"net"
+	"net/smtp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mcbile/product-pulse/internal/storage"
+)
+
+// emailTemplate renders the HTML body of alert emails
+var emailTemplate = template.Must(template.New("email").Parse(`<!DOCTYPE html>
+<html>
+<body style="font-family: sans-serif; color: #1f2328;">
+{{if .Digest}}<p>Alerts held back by quiet hours or rate limits ({{len .Alerts}}):</p>{{end}}
+{{range .Alerts}}<table style="border-left: 4px solid {{.Color}}; margin: 0 0 16px; padding: 4px 12px;">
+<tr><td><strong>{{if .Resolved}}RESOLVED{{else}}{{.Severity}}{{end}}</strong> {{.AlertType}} <code>{{.MetricName}}</code></td></tr>
+<tr><td>{{.Message}}</td></tr>
+<tr><td style="color: #656d76; font-size: 12px;">{{if .SiteID}}Site {{.SiteID}} · {{end}}{{.Time.UTC.Format "2006-01-02 15:04 MST"}}{{if .Resolved}} · resolved {{.ResolvedAt.UTC.Format "15:04 MST"}}{{end}}</td></tr>
+{{if .Link}}<tr><td><a href="{{.Link}}">Open in dashboard</a></td></tr>{{end}}
+</table>
+{{end}}<p style="color: #656d76; font-size: 12px;">You receive these emails because you subscribed to alerts in Product Pulse.</p>
+</body>
+</html>
+`))
+
+// emailColor marks alerts by severity
+var emailColor = map[string]string{
+	"critical": "#cf222e",
+	"warning":  "#bf8700",
+	"info":     "#0969da",
+}
+
+type emailAlert struct {
+	storage.AlertRow
+	Resolved bool
+	Color    string
+	Link     string
+}
+
+// SubscriberStorage returns the users subscribed to alert emails
+type SubscriberStorage interface {
+	GetEmailSubscribers(ctx context.Context) ([]storage.EmailSubscriber, error)
+}
+
+// EmailConfig for the email channel
+type EmailConfig struct {
+	Host         string
+	Port         int
+	Username     string // Empty for servers without authentication
+	Password     string
+	From         string
+	TLS          string                 // starttls, tls (implicit, usually port 465) or none
+	DashboardURL string                 // Base URL of the dashboard for links, may be empty
+	AllSites     func(role string) bool // Whether a role sees all sites without grants
+}
+
+// EmailChannel mails alerts over SMTP to the users subscribed to them,
+// one HTML email per user with the alerts matching their minimum severity
+// and sites. Users restricted to some sites only get alerts of their
+// granted sites; alerts across sites go to users who see all sites.
+type EmailChannel struct {
+	config  EmailConfig
+	storage SubscriberStorage
+}
+
+// NewEmailChannel creates an email channel
+func NewEmailChannel(config EmailConfig, store SubscriberStorage) (*EmailChannel, error) {
+	if config.Host == "" || config.From == "" {
+		return nil, errors.New("email channel needs an SMTP host and from address")
+	}
+	switch config.TLS {
+	case "":
+		config.TLS = "starttls"
+	case "starttls", "tls", "none":
+	default:
+		return nil, fmt.Errorf("invalid smtp tls mode %q", config.TLS)
+	}
+	if config.Port == 0 {
+		config.Port = 587
+	}
+	if config.AllSites == nil {
+		config.AllSites = func(string) bool { return true }
+	}
+	return &EmailChannel{config: config, storage: store}, nil
+}
+
+func (e *EmailChannel) Name() string { return "email" }
+
+// Send mails every subscriber the alerts meant for them
+func (e *EmailChannel) Send(ctx context.Context, msg Message) error {
+	subscribers, err := e.storage.GetEmailSubscribers(ctx)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, s := range subscribers {
+		var alerts []storage.AlertRow
+		for _, a := range msg.Alerts {
+			if e.wants(s, a) {
+				alerts = append(alerts, a)
+			}
+		}
+		if len(alerts) == 0 {
+			continue
+		}
+		body, err := e.render(alerts, msg.Digest)
+		if err != nil {
+			return err
+		}
+		if err := e.send(ctx, s.Email, emailSubject(alerts, msg.Digest), body); err != nil {
+			errs = append(errs, fmt.Errorf("mail %s: %w", s.Email, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// wants reports whether an alert matches the subscription and the sites
+// its user may see
+func (e *EmailChannel) wants(s storage.EmailSubscriber, a storage.AlertRow) bool {
+	if severityRank(a.Severity) < severityRank(s.MinSeverity) {
+		return false
+	}
+	if !e.config.AllSites(s.Role) && !slices.Contains(s.GrantedSites, a.SiteID) {
+		return false
+	}
+	return len(s.Sites) == 0 || slices.Contains(s.Sites, a.SiteID)
+}
+
+func (e *EmailChannel) render(alerts []storage.AlertRow, digest bool) ([]byte, error) {
+	data := struct {
+		Digest bool
+		Alerts []emailAlert
+	}{Digest: digest}
+	for _, a := range alerts {
+		data.Alerts = append(data.Alerts, emailAlert{
+			AlertRow: a,
+			Resolved: a.ResolvedAt != nil,
+			Color:    emailColor[a.Severity],
+			Link:     dashboardLink(e.config.DashboardURL, a),
+		})
+	}
+	var body bytes.Buffer
+	if err := emailTemplate.Execute(&body, data); err != nil {
+		return nil, fmt.Errorf("render email: %w", err)
+	}
+	return body.Bytes(), nil
+}
+
+// emailSubject is e.g. "[CRITICAL] threshold psp_success_rate" for one
+// alert and counts the alerts of several
+func emailSubject(alerts []storage.AlertRow, digest bool) string {
+	var subject string
+	switch {
+	case digest:
+		subject = fmt.Sprintf("[Pulse] Digest of %d alerts", len(alerts))
+	case len(alerts) > 1:
+		subject = fmt.Sprintf("[Pulse] %d alerts", len(alerts))
+	case alerts[0].ResolvedAt != nil:
+		subject = fmt.Sprintf("[RESOLVED] %s %s", alerts[0].AlertType, alerts[0].MetricName)
+	default:
+		subject = fmt.Sprintf("[%s] %s %s", strings.ToUpper(alerts[0].Severity), alerts[0].AlertType, alerts[0].MetricName)
+	}
+	return mime.QEncoding.Encode("utf-8", strings.NewReplacer("\r", " ", "\n", " ").Replace(subject))
+}
+
+// send delivers one email, bounded by ctx
+func (e *EmailChannel) send(ctx context.Context, to, subject string, body []byte) error {
+	addr := net.JoinHostPort(e.config.Host, strconv.Itoa(e.config.Port))
+	dialer := net.Dialer{Timeout: 10 * time.Second}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return fmt.Errorf("smtp connect: %w", err)
+	}
+	defer conn.Close()
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(30 * time.Second)
+	}
+	conn.SetDeadline(deadline)
+
+	tlsConfig := &tls.Config{ServerName: e.config.Host}
+	if e.config.TLS == "tls" {
+		conn = tls.Client(conn, tlsConfig)
+	}
+	client, err := smtp.NewClient(conn, e.config.Host)
+	if err != nil {
+		return fmt.Errorf("smtp handshake: %w", err)
+	}
+	defer client.Close()
+
+	if e.config.TLS == "starttls" {
+		if err := client.StartTLS(tlsConfig); err != nil {
+			return fmt.Errorf("smtp starttls: %w", err)
+		}
+	}
+	if e.config.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", e.config.Username, e.config.Password, e.config.Host)); err != nil {
+			return fmt.Errorf("smtp auth: %w", err)
+		}
+	}
+	if err := client.Mail(e.config.From); err != nil {
+		return fmt.Errorf("smtp mail from: %w", err)
+	}
+	if err := client.Rcpt(to); err != nil {
+		return fmt.Errorf("smtp rcpt to: %w", err)
+	}
+	w, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("smtp data: %w", err)
+	}
+	fmt.Fprintf(w, "From: %s\r\nTo: %s\r\nSubject: %s\r\nDate: %s\r\nMIME-Version: 1.0\r\nContent-Type: text/html; charset=utf-8\r\nContent-Transfer-Encoding: 8bit\r\n\r\n",
+		e.config.From, to, subject, time.Now().Format(time.RFC1123Z))
+	w.Write(body)
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("smtp data: %w", err)
+	}
+	return client.Quit()
+}
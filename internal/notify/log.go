@@ -0,0 +1,30 @@
+package notify
+
+import (
+	"context"
+	"log/slog"
+)
+
+// LogChannel writes notifications to the application log. It is useful to
+// try out policies before a real channel is configured.
+type LogChannel struct{}
+
+func (LogChannel) Name() string { return "log" }
+
+func (LogChannel) Send(ctx context.Context, msg Message) error {
+	if msg.Digest {
+		slog.Info("notification digest", "alerts", len(msg.Alerts))
+	}
+	for _, a := range msg.Alerts {
+		slog.Info("notification",
+			"alert_type", a.AlertType,
+			"severity", a.Severity,
+			"metric", a.MetricName,
+			"time", a.Time,
+			"message", a.Message,
+			"resolved", a.ResolvedAt != nil,
+			"digest", msg.Digest,
+		)
+	}
+	return nil
+}
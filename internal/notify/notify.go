@@ -0,0 +1,247 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/mcbile/product-pulse/internal/storage"
+)
+
+// Message is what a channel delivers: a single alert, or a digest of alerts
+// that were held back by quiet hours or rate limits. Alerts with ResolvedAt
+// set announce that the alert resolved.
+type Message struct {
+	Alerts []storage.AlertRow
+	Digest bool
+}
+
+// Channel delivers notifications, e.g. to chat or a pager
+type Channel interface {
+	Name() string
+	Send(ctx context.Context, msg Message) error
+}
+
+// Storage is the subset of storage used for the digest queue
+type Storage interface {
+	QueueNotification(ctx context.Context, n storage.QueuedNotification) error
+	ClaimNotifications(ctx context.Context, channel string, limit int) ([]storage.QueuedNotification, error)
+}
+
+// Config for the notifier
+type Config struct {
+	QuietHours  map[string]QuietHours // Per channel; "*" applies to the others
+	RateLimits  map[string]*RateLimit // Per channel; "*" applies to the others
+	SendTimeout time.Duration
+	DigestSize  int // Alerts per digest message
+}
+
+type route struct {
+	channel    Channel
+	quietHours *QuietHours
+	policies   []Policy
+}
+
+// Notifier sends alerts to every channel, subject to the policies of the
+// channel. Held back alerts are queued in the database and sent as a digest
+// by FlushDigests once the channel is outside its quiet hours, so nothing
+// is lost across restarts and replicas never send the same digest twice.
+// Rate limits are kept per replica.
+type Notifier struct {
+	config  Config
+	storage Storage
+	routes  []*route
+}
+
+// New creates a notifier for channels
+func New(config Config, storage Storage, channels ...Channel) *Notifier {
+	if config.SendTimeout <= 0 {
+		config.SendTimeout = 10 * time.Second
+	}
+	if config.DigestSize <= 0 {
+		config.DigestSize = 100
+	}
+
+	n := &Notifier{config: config, storage: storage}
+	for _, ch := range channels {
+		r := &route{channel: ch}
+		if q, ok := lookup(config.QuietHours, ch.Name()); ok {
+			r.quietHours = &q
+			r.policies = append(r.policies, q)
+		}
+		if l, ok := lookup(config.RateLimits, ch.Name()); ok {
+			// Every channel gets its own limiter, also when sharing "*"
+			r.policies = append(r.policies, &RateLimit{Count: l.Count, Period: l.Period})
+		}
+		n.routes = append(n.routes, r)
+		slog.Info("notification channel enabled", "channel", ch.Name(), "quiet_hours", r.quietHours != nil)
+	}
+	return n
+}
+
+// dashboardLink returns the URL of an alert in the dashboard at base, empty
+// without base
+func dashboardLink(base string, alert storage.AlertRow) string {
+	if base == "" {
+		return ""
+	}
+	return strings.TrimSuffix(base, "/") + "/alerts?" + url.Values{"metric": {alert.MetricName}}.Encode()
+}
+
+func lookup[T any](m map[string]T, channel string) (T, bool) {
+	if v, ok := m[channel]; ok {
+		return v, true
+	}
+	v, ok := m["*"]
+	return v, ok
+}
+
+// Notify sends an alert, raised or resolved, to every channel, or queues it for the digest of
+// channels whose policies hold it back. Delivery failures are logged and
+// do not affect the caller.
+func (n *Notifier) Notify(ctx context.Context, alert storage.AlertRow) {
+	now := time.Now()
+	for _, r := range n.routes {
+		reason := ""
+		for _, p := range r.policies {
+			if reason = p.Hold(alert, now); reason != "" {
+				break
+			}
+		}
+
+		if reason != "" {
+			err := n.storage.QueueNotification(ctx, storage.QueuedNotification{
+				Channel: r.channel.Name(),
+				Reason:  reason,
+				Alert:   alert,
+			})
+			if err != nil {
+				slog.Error("failed to queue notification", "channel", r.channel.Name(), "error", err)
+			}
+			continue
+		}
+
+		if err := n.send(ctx, r.channel, Message{Alerts: []storage.AlertRow{alert}}); err != nil {
+			slog.Error("failed to send notification",
+				"channel", r.channel.Name(),
+				"alert_type", alert.AlertType,
+				"error", err,
+			)
+		}
+	}
+}
+
+// FlushDigests sends the queued alerts of every channel outside its quiet
+// hours as digest messages. Alerts of a digest that fails are queued again.
+// It is run as a scheduled job.
+func (n *Notifier) FlushDigests(ctx context.Context) error {
+	now := time.Now()
+	var errs []error
+	for _, r := range n.routes {
+		if r.quietHours != nil && r.quietHours.Active(now) {
+			continue
+		}
+		if err := n.flush(ctx, r.channel); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", r.channel.Name(), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (n *Notifier) flush(ctx context.Context, ch Channel) error {
+	for {
+		queued, err := n.storage.ClaimNotifications(ctx, ch.Name(), n.config.DigestSize)
+		if err != nil {
+			return err
+		}
+		if len(queued) == 0 {
+			return nil
+		}
+
+		msg := Message{Digest: true, Alerts: make([]storage.AlertRow, len(queued))}
+		for i, q := range queued {
+			msg.Alerts[i] = q.Alert
+		}
+
+		if err := n.send(ctx, ch, msg); err != nil {
+			for _, q := range queued {
+				if qerr := n.storage.QueueNotification(ctx, q); qerr != nil {
+					slog.Error("failed to requeue notification", "channel", ch.Name(), "error", qerr)
+				}
+			}
+			return err
+		}
+		slog.Info("notification digest sent", "channel", ch.Name(), "alerts", len(queued))
+
+		if len(queued) < n.config.DigestSize {
+			return nil
+		}
+	}
+}
+
+func (n *Notifier) send(ctx context.Context, ch Channel, msg Message) error {
+	ctx, cancel := context.WithTimeout(ctx, n.config.SendTimeout)
+	defer cancel()
+	return ch.Send(ctx, msg)
+}
+
+// AlertStorage wraps the database so that every alert it records or
+// resolves is also sent through the notifier. It can be passed wherever
+// *storage.Postgres is used to raise alerts. Notifications of alerts about
+// a PSP or game provider in the catalog quote its escalation path.
+type AlertStorage struct {
+	*storage.Postgres
+	notifier *Notifier
+}
+
+// Wrap returns db with InsertAlert and ResolveAlerts notifying about the
+// alerts
+func (n *Notifier) Wrap(db *storage.Postgres) *AlertStorage {
+	return &AlertStorage{Postgres: db, notifier: n}
+}
+
+// InsertAlert records the alert and notifies about it
+func (a *AlertStorage) InsertAlert(ctx context.Context, alert storage.AlertRow) error {
+	if err := a.Postgres.InsertAlert(ctx, alert); err != nil {
+		return err
+	}
+	a.notifier.Notify(ctx, a.escalate(ctx, alert))
+	return nil
+}
+
+// escalate appends the escalation path of the alert's provider to its
+// message, if the catalog has one
+func (a *AlertStorage) escalate(ctx context.Context, alert storage.AlertRow) storage.AlertRow {
+	kind := storage.ProviderKind(alert.SourceTable)
+	if kind == "" || alert.Target == "" {
+		return alert
+	}
+	p, err := a.Postgres.GetProvider(ctx, kind, alert.Target)
+	if errors.Is(err, storage.ErrProviderNotFound) {
+		return alert
+	}
+	if err != nil {
+		slog.Warn("failed to look up provider for notification", "provider", alert.Target, "error", err)
+		return alert
+	}
+	if p.Escalation != "" {
+		alert.Message += fmt.Sprintf("\nEscalate to %s's NOC: %s", p.DisplayName, p.Escalation)
+	}
+	return alert
+}
+
+// ResolveAlerts resolves the open alerts and notifies about each of them
+func (a *AlertStorage) ResolveAlerts(ctx context.Context, alertType, metricName string) error {
+	resolved, err := a.Postgres.ResolveOpenAlerts(ctx, alertType, metricName)
+	if err != nil {
+		return err
+	}
+	for _, alert := range resolved {
+		a.notifier.Notify(ctx, alert)
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mcbile/product-pulse/internal/storage"
+)
+
+// PagerDutyEventsURL is the Events API v2 endpoint
+const PagerDutyEventsURL = "https://events.pagerduty.com/v2/enqueue"
+
+// pagerDutySeverity maps alert severities to PagerDuty's
+var pagerDutySeverity = map[string]string{
+	"critical": "critical",
+	"warning":  "warning",
+	"info":     "info",
+}
+
+// PagerDutyConfig for the PagerDuty channel
+type PagerDutyConfig struct {
+	RoutingKey   string // Integration key of the Events API v2 integration
+	MinSeverity  string // Alerts below are not sent, empty sends all
+	EventsURL    string // Empty for PagerDutyEventsURL, e.g. the EU endpoint
+	DashboardURL string // Base URL of the dashboard for links, may be empty
+}
+
+// PagerDutyChannel sends alerts to the PagerDuty Events API v2. The dedup
+// key is the alert's type and metric, so a raised alert triggers an
+// incident and its resolution resolves the same incident, and an alert
+// raised again while the incident is open does not open another one.
+type PagerDutyChannel struct {
+	config PagerDutyConfig
+	client *http.Client
+}
+
+// NewPagerDutyChannel creates a PagerDuty channel
+func NewPagerDutyChannel(config PagerDutyConfig) (*PagerDutyChannel, error) {
+	if config.RoutingKey == "" {
+		return nil, errors.New("pagerduty channel needs a routing key")
+	}
+	if config.MinSeverity != "" && severityRank(config.MinSeverity) == 0 {
+		return nil, fmt.Errorf("invalid pagerduty min severity %q", config.MinSeverity)
+	}
+	if config.EventsURL == "" {
+		config.EventsURL = PagerDutyEventsURL
+	}
+	return &PagerDutyChannel{
+		config: config,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+func (p *PagerDutyChannel) Name() string { return "pagerduty" }
+
+// Send enqueues one event per alert, in order, so a digest holding both
+// the trigger and the resolution of an alert leaves the incident resolved
+func (p *PagerDutyChannel) Send(ctx context.Context, msg Message) error {
+	var errs []error
+	for _, a := range msg.Alerts {
+		if severityRank(a.Severity) < severityRank(p.config.MinSeverity) {
+			continue
+		}
+		if err := p.enqueue(ctx, p.event(a)); err != nil {
+			errs = append(errs, fmt.Errorf("%s %s: %w", a.AlertType, a.MetricName, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+type pagerDutyEvent struct {
+	RoutingKey  string            `json:"routing_key"`
+	EventAction string            `json:"event_action"` // trigger or resolve
+	DedupKey    string            `json:"dedup_key"`
+	Payload     *pagerDutyPayload `json:"payload,omitempty"`
+	Links       []pagerDutyLink   `json:"links,omitempty"`
+}
+
+type pagerDutyPayload struct {
+	Summary       string                 `json:"summary"`
+	Source        string                 `json:"source"`
+	Severity      string                 `json:"severity"`
+	Timestamp     time.Time              `json:"timestamp"`
+	Component     string                 `json:"component,omitempty"`
+	Class         string                 `json:"class"`
+	CustomDetails map[string]interface{} `json:"custom_details"`
+}
+
+type pagerDutyLink struct {
+	Href string `json:"href"`
+	Text string `json:"text"`
+}
+
+// event builds the trigger or resolve event of an alert
+func (p *PagerDutyChannel) event(a storage.AlertRow) pagerDutyEvent {
+	e := pagerDutyEvent{
+		RoutingKey: p.config.RoutingKey,
+		DedupKey:   "product-pulse:" + a.AlertType + ":" + a.MetricName,
+	}
+	if len(e.DedupKey) > 255 {
+		e.DedupKey = e.DedupKey[:255]
+	}
+	if a.ResolvedAt != nil {
+		e.EventAction = "resolve"
+		return e
+	}
+
+	e.EventAction = "trigger"
+	severity, ok := pagerDutySeverity[a.Severity]
+	if !ok {
+		severity = "error"
+	}
+	summary := a.Message
+	if summary == "" {
+		summary = a.AlertType + " " + a.MetricName
+	}
+	if len(summary) > 1024 {
+		summary = summary[:1024]
+	}
+	e.Payload = &pagerDutyPayload{
+		Summary:   summary,
+		Source:    "product-pulse",
+		Severity:  severity,
+		Timestamp: a.Time,
+		Component: a.SourceTable,
+		Class:     a.AlertType,
+		CustomDetails: map[string]interface{}{
+			"metric":    a.MetricName,
+			"threshold": a.ThresholdValue,
+			"actual":    a.ActualValue,
+		},
+	}
+	if link := dashboardLink(p.config.DashboardURL, a); link != "" {
+		e.Links = []pagerDutyLink{{Href: link, Text: "Open in dashboard"}}
+	}
+	return e
+}
+
+func (p *PagerDutyChannel) enqueue(ctx context.Context, e pagerDutyEvent) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.config.EventsURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("pagerduty request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("pagerduty returned %d: %s", resp.StatusCode, strings.TrimSpace(string(data)))
+	}
+	return nil
+}
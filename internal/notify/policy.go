@@ -0,0 +1,172 @@
+package notify
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"github.com/mcbile/product-pulse/internal/storage"
+)
+
+// Reasons a notification is held back for the digest
+const (
+	ReasonQuietHours  = "quiet_hours"
+	ReasonRateLimited = "rate_limited"
+)
+
+// Policy decides whether an alert may be sent to a channel right now. A
+// policy that returns a non-empty reason holds the alert back; it is sent
+// with the next digest instead.
+type Policy interface {
+	Hold(alert storage.AlertRow, now time.Time) (reason string)
+}
+
+// severityRank orders alert severities; unknown severities rank lowest
+func severityRank(severity string) int {
+	switch severity {
+	case "critical":
+		return 3
+	case "warning":
+		return 2
+	case "info":
+		return 1
+	}
+	return 0
+}
+
+// QuietHours holds back alerts below MinSeverity between Start and End
+// (minutes after midnight in Location). A window with Start > End spans
+// midnight.
+type QuietHours struct {
+	Start       int
+	End         int
+	MinSeverity string // Alerts at or above are sent anyway
+	Location    *time.Location
+}
+
+// Active reports whether now falls within the quiet hours
+func (q QuietHours) Active(now time.Time) bool {
+	local := now.In(q.Location)
+	minute := local.Hour()*60 + local.Minute()
+	if q.Start <= q.End {
+		return minute >= q.Start && minute < q.End
+	}
+	return minute >= q.Start || minute < q.End
+}
+
+func (q QuietHours) Hold(alert storage.AlertRow, now time.Time) string {
+	if q.Active(now) && severityRank(alert.Severity) < severityRank(q.MinSeverity) {
+		return ReasonQuietHours
+	}
+	return ""
+}
+
+// RateLimit allows at most Count notifications per Period to a channel
+type RateLimit struct {
+	Count  int
+	Period time.Duration
+
+	once    sync.Once
+	limiter *rate.Limiter
+}
+
+func (l *RateLimit) Hold(alert storage.AlertRow, now time.Time) string {
+	l.once.Do(func() {
+		l.limiter = rate.NewLimiter(rate.Limit(float64(l.Count)/l.Period.Seconds()), l.Count)
+	})
+	if l.limiter.AllowN(now, 1) {
+		return ""
+	}
+	return ReasonRateLimited
+}
+
+// ParseRateLimits parses channel=count/period entries, e.g. log=20/1h. The
+// channel * applies to channels without an entry of their own.
+func ParseRateLimits(entries []string) (map[string]*RateLimit, error) {
+	limits := make(map[string]*RateLimit)
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		channel, spec, ok := strings.Cut(entry, "=")
+		countStr, periodStr, ok2 := strings.Cut(spec, "/")
+		if !ok || !ok2 || strings.TrimSpace(channel) == "" {
+			return nil, fmt.Errorf("invalid notification rate limit %q, expected channel=count/period", entry)
+		}
+
+		count, err := strconv.Atoi(strings.TrimSpace(countStr))
+		if err != nil || count <= 0 {
+			return nil, fmt.Errorf("invalid count in notification rate limit %q", entry)
+		}
+		period, err := time.ParseDuration(strings.TrimSpace(periodStr))
+		if err != nil || period <= 0 {
+			return nil, fmt.Errorf("invalid period in notification rate limit %q", entry)
+		}
+
+		limits[strings.TrimSpace(channel)] = &RateLimit{Count: count, Period: period}
+	}
+	return limits, nil
+}
+
+// ParseQuietHours parses channel=HH:MM-HH:MM[@min_severity] entries, e.g.
+// *=00:00-07:00@critical. Without a severity only critical alerts are sent
+// during quiet hours.
+func ParseQuietHours(entries []string, loc *time.Location) (map[string]QuietHours, error) {
+	policies := make(map[string]QuietHours)
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		channel, spec, ok := strings.Cut(entry, "=")
+		if !ok || strings.TrimSpace(channel) == "" {
+			return nil, fmt.Errorf("invalid quiet hours %q, expected channel=HH:MM-HH:MM[@severity]", entry)
+		}
+
+		window, severity, _ := strings.Cut(spec, "@")
+		severity = strings.TrimSpace(severity)
+		if severity == "" {
+			severity = "critical"
+		}
+		if severityRank(severity) == 0 {
+			return nil, fmt.Errorf("invalid severity in quiet hours %q", entry)
+		}
+
+		startStr, endStr, ok := strings.Cut(window, "-")
+		if !ok {
+			return nil, fmt.Errorf("invalid quiet hours %q, expected channel=HH:MM-HH:MM[@severity]", entry)
+		}
+		start, err := parseClock(startStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid start in quiet hours %q", entry)
+		}
+		end, err := parseClock(endStr)
+		if err != nil || end == start {
+			return nil, fmt.Errorf("invalid end in quiet hours %q", entry)
+		}
+
+		policies[strings.TrimSpace(channel)] = QuietHours{
+			Start:       start,
+			End:         end,
+			MinSeverity: severity,
+			Location:    loc,
+		}
+	}
+	return policies, nil
+}
+
+// parseClock parses HH:MM into minutes after midnight
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, err
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
@@ -0,0 +1,186 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// DefaultSlackTemplate renders an alert in Slack mrkdwn
+const DefaultSlackTemplate = `{{if .Resolved}}:white_check_mark: *RESOLVED*{{else}}{{.Emoji}} *{{upper .Severity}}*{{end}} {{.AlertType}} ` + "`{{.MetricName}}`" + `
+{{.Message}}{{if .Link}}
+<{{.Link}}|Open in dashboard>{{end}}`
+
+// slackEmoji marks raised alerts by severity
+var slackEmoji = map[string]string{
+	"critical": ":red_circle:",
+	"warning":  ":large_orange_circle:",
+	"info":     ":large_blue_circle:",
+}
+
+// SlackAlert is what the message template renders
+type SlackAlert struct {
+	AlertType    string
+	Severity     string
+	SourceTable  string
+	MetricName   string
+	Threshold    float64
+	Actual       float64
+	Message      string
+	Time         time.Time
+	Resolved     bool
+	ResolvedAt   *time.Time
+	Emoji        string // By severity
+	Link         string // The alert in the dashboard, empty without DashboardURL
+	DashboardURL string
+}
+
+// SlackConfig for the Slack channel
+type SlackConfig struct {
+	Webhooks     map[string]string // Incoming webhook URL per severity; "*" applies to the others
+	Template     string            // text/template over SlackAlert, empty for DefaultSlackTemplate
+	DashboardURL string            // Base URL of the dashboard for links, may be empty
+}
+
+// SlackChannel posts alerts to Slack incoming webhooks, routed by
+// severity, e.g. critical alerts to the on-call channel and the rest to a
+// team channel. Alerts of a severity without webhook (and no "*") are not
+// sent.
+type SlackChannel struct {
+	config   SlackConfig
+	template *template.Template
+	client   *http.Client
+}
+
+// NewSlackChannel creates a Slack channel
+func NewSlackChannel(config SlackConfig) (*SlackChannel, error) {
+	if len(config.Webhooks) == 0 {
+		return nil, errors.New("slack channel needs at least one webhook")
+	}
+	if config.Template == "" {
+		config.Template = DefaultSlackTemplate
+	}
+	tmpl, err := template.New("slack").Funcs(template.FuncMap{"upper": strings.ToUpper}).Parse(config.Template)
+	if err != nil {
+		return nil, fmt.Errorf("invalid slack template: %w", err)
+	}
+	return &SlackChannel{
+		config:   config,
+		template: tmpl,
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+func (s *SlackChannel) Name() string { return "slack" }
+
+// Send posts one message per webhook the alerts are routed to. A digest
+// lists its alerts under a header.
+func (s *SlackChannel) Send(ctx context.Context, msg Message) error {
+	var webhooks []string
+	texts := make(map[string][]string)
+	for _, a := range msg.Alerts {
+		webhook, ok := lookup(s.config.Webhooks, a.Severity)
+		if !ok {
+			continue
+		}
+		alert := SlackAlert{
+			AlertType:    a.AlertType,
+			Severity:     a.Severity,
+			SourceTable:  a.SourceTable,
+			MetricName:   a.MetricName,
+			Threshold:    a.ThresholdValue,
+			Actual:       a.ActualValue,
+			Message:      a.Message,
+			Time:         a.Time,
+			Resolved:     a.ResolvedAt != nil,
+			ResolvedAt:   a.ResolvedAt,
+			Emoji:        slackEmoji[a.Severity],
+			DashboardURL: s.config.DashboardURL,
+		}
+		alert.Link = dashboardLink(s.config.DashboardURL, a)
+
+		var text strings.Builder
+		if err := s.template.Execute(&text, alert); err != nil {
+			return fmt.Errorf("render slack message: %w", err)
+		}
+		if _, ok := texts[webhook]; !ok {
+			webhooks = append(webhooks, webhook)
+		}
+		texts[webhook] = append(texts[webhook], text.String())
+	}
+
+	var errs []error
+	for _, webhook := range webhooks {
+		text := strings.Join(texts[webhook], "\n\n")
+		if msg.Digest {
+			text = fmt.Sprintf("*Digest of alerts held back by quiet hours or rate limits (%d)*\n\n%s", len(texts[webhook]), text)
+		}
+		if err := s.post(ctx, webhook, text); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (s *SlackChannel) post(ctx context.Context, webhook, text string) error {
+	body, err := json.Marshal(map[string]string{"text": text})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		// The URL holds the webhook's secret, keep it out of logs
+		var uerr *url.Error
+		if errors.As(err, &uerr) {
+			err = uerr.Err
+		}
+		return fmt.Errorf("slack request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("slack returned %d: %s", resp.StatusCode, strings.TrimSpace(string(data)))
+	}
+	return nil
+}
+
+// ParseSlackWebhooks parses severity=url entries, with * for all other
+// severities; an entry without severity applies to all, e.g.
+// "critical=https://hooks.slack.com/services/T0/B1/x,*=https://hooks.slack.com/services/T0/B2/y"
+func ParseSlackWebhooks(entries []string) (map[string]string, error) {
+	webhooks := make(map[string]string)
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		severity, webhook := "*", entry
+		if s, w, ok := strings.Cut(entry, "="); ok && !strings.Contains(s, "/") {
+			severity, webhook = strings.TrimSpace(s), strings.TrimSpace(w)
+		}
+		if severity != "*" && severityRank(severity) == 0 {
+			return nil, fmt.Errorf("invalid severity %q in slack webhook", severity)
+		}
+		u, err := url.Parse(webhook)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return nil, fmt.Errorf("invalid slack webhook url for %s", severity)
+		}
+		webhooks[severity] = webhook
+	}
+	return webhooks, nil
+}
@@ -0,0 +1,278 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/mcbile/product-pulse/internal/storage"
+)
+
+// DefaultWebhookTemplate renders the whole WebhookAlert as JSON
+const DefaultWebhookTemplate = `{{json .}}`
+
+// WebhookSignatureHeader carries the HMAC-SHA256 signature of signed
+// webhooks: t=<unix time>,v1=<hex HMAC of "<t>.<body>">, the scheme the
+// collector verifies on signed ingestion
+const WebhookSignatureHeader = "X-Pulse-Signature"
+
+// WebhookAlert is what the payload template renders
+type WebhookAlert struct {
+	Webhook     string     `json:"webhook"`
+	Event       string     `json:"event"` // raised or resolved
+	Digest      bool       `json:"digest"`
+	ID          int64      `json:"id,omitempty"`
+	AlertType   string     `json:"alert_type"`
+	Severity    string     `json:"severity"`
+	SourceTable string     `json:"source_table,omitempty"`
+	MetricName  string     `json:"metric_name"`
+	Target      string     `json:"target,omitempty"`
+	SiteID      string     `json:"site_id,omitempty"`
+	Threshold   float64    `json:"threshold"`
+	Actual      float64    `json:"actual"`
+	Message     string     `json:"message"`
+	Time        time.Time  `json:"time"`
+	ResolvedAt  *time.Time `json:"resolved_at,omitempty"`
+	Link        string     `json:"link,omitempty"` // The alert in the dashboard, empty without DashboardURL
+}
+
+// WebhookStorage is the subset of storage used by the webhook channel
+type WebhookStorage interface {
+	GetAlertWebhooks(ctx context.Context, rule string) ([]storage.Webhook, error)
+	InsertWebhookDelivery(ctx context.Context, d storage.WebhookDelivery) error
+}
+
+// WebhookConfig for the webhook channel
+type WebhookConfig struct {
+	MaxAttempts   int           // Attempts per alert and webhook, default 3
+	Backoff       time.Duration // Wait before the first retry, doubled for every further one; default 1s
+	RuleAlertType string        // Alert type of rule alerts, whose metric name is the rule
+	DashboardURL  string        // Base URL of the dashboard for links, may be empty
+}
+
+// WebhookChannel posts alerts as JSON to the webhooks stored in the
+// database: those the alert's rule names and those receiving all alerts.
+// The payload is rendered from the webhook's template, signed with its
+// secret, and retried with exponential backoff on network errors, 429 and
+// 5xx answers until MaxAttempts or the send timeout. Every attempt is
+// logged to webhook_deliveries.
+type WebhookChannel struct {
+	config  WebhookConfig
+	storage WebhookStorage
+	client  *http.Client
+}
+
+// NewWebhookChannel creates a webhook channel
+func NewWebhookChannel(config WebhookConfig, store WebhookStorage) *WebhookChannel {
+	if config.MaxAttempts <= 0 {
+		config.MaxAttempts = 3
+	}
+	if config.Backoff <= 0 {
+		config.Backoff = time.Second
+	}
+	return &WebhookChannel{
+		config:  config,
+		storage: store,
+		client:  &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (c *WebhookChannel) Name() string { return "webhook" }
+
+// Send posts every alert to each of its webhooks, one request per alert
+func (c *WebhookChannel) Send(ctx context.Context, msg Message) error {
+	var errs []error
+	for _, a := range msg.Alerts {
+		rule := ""
+		if a.AlertType == c.config.RuleAlertType {
+			rule = a.MetricName
+		}
+		webhooks, err := c.storage.GetAlertWebhooks(ctx, rule)
+		if err != nil {
+			return err
+		}
+		for _, w := range webhooks {
+			if err := c.deliver(ctx, w, a, msg.Digest); err != nil {
+				errs = append(errs, fmt.Errorf("webhook %s: %w", w.Name, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// deliver posts one alert to a webhook, retrying until it is accepted,
+// rejected for good or out of attempts
+func (c *WebhookChannel) deliver(ctx context.Context, w storage.Webhook, a storage.AlertRow, digest bool) error {
+	alert := c.alert(w.Name, a, digest)
+	body, err := renderWebhook(w.Template, alert)
+	if err != nil {
+		return err
+	}
+
+	backoff := c.config.Backoff
+	for attempt := 1; ; attempt++ {
+		start := time.Now()
+		status, err := c.post(ctx, w, body)
+		c.log(ctx, storage.WebhookDelivery{
+			Time:       start.UTC(),
+			Webhook:    w.Name,
+			AlertID:    a.ID,
+			AlertType:  a.AlertType,
+			MetricName: a.MetricName,
+			Event:      alert.Event,
+			Attempt:    attempt,
+			StatusCode: status,
+			Error:      errorText(err),
+			DurationMS: float64(time.Since(start).Microseconds()) / 1000,
+		})
+		if err == nil {
+			return nil
+		}
+		retryable := status == 0 || status == http.StatusTooManyRequests || status >= 500
+		if !retryable || attempt >= c.config.MaxAttempts {
+			return err
+		}
+
+		select {
+		case <-time.After(backoff):
+			backoff *= 2
+		case <-ctx.Done():
+			return fmt.Errorf("%w (gave up after %d attempts)", err, attempt)
+		}
+	}
+}
+
+func (c *WebhookChannel) alert(webhook string, a storage.AlertRow, digest bool) WebhookAlert {
+	alert := WebhookAlert{
+		Webhook:     webhook,
+		Event:       "raised",
+		Digest:      digest,
+		ID:          a.ID,
+		AlertType:   a.AlertType,
+		Severity:    a.Severity,
+		SourceTable: a.SourceTable,
+		MetricName:  a.MetricName,
+		Target:      a.Target,
+		SiteID:      a.SiteID,
+		Threshold:   a.ThresholdValue,
+		Actual:      a.ActualValue,
+		Message:     a.Message,
+		Time:        a.Time,
+		ResolvedAt:  a.ResolvedAt,
+		Link:        dashboardLink(c.config.DashboardURL, a),
+	}
+	if a.ResolvedAt != nil {
+		alert.Event = "resolved"
+	}
+	return alert
+}
+
+// post sends one attempt and returns the status code, 0 without response
+func (c *WebhookChannel) post(ctx context.Context, w storage.Webhook, body []byte) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "product-pulse-webhook")
+	if w.Secret != "" {
+		req.Header.Set(WebhookSignatureHeader, SignWebhook(w.Secret, time.Now().Unix(), body))
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("webhook request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return resp.StatusCode, fmt.Errorf("webhook returned %d: %s", resp.StatusCode, strings.TrimSpace(string(data)))
+	}
+	return resp.StatusCode, nil
+}
+
+// log records a delivery attempt; the log outlives the send timeout so
+// the attempt that hit it is recorded too
+func (c *WebhookChannel) log(ctx context.Context, d storage.WebhookDelivery) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+	defer cancel()
+	if err := c.storage.InsertWebhookDelivery(ctx, d); err != nil {
+		slog.Error("failed to log webhook delivery", "webhook", d.Webhook, "error", err)
+	}
+}
+
+func errorText(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+// SignWebhook returns the X-Pulse-Signature value of body sent at ts
+func SignWebhook(secret string, ts int64, body []byte) string {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write([]byte(strconv.FormatInt(ts, 10)))
+	h.Write([]byte{'.'})
+	h.Write(body)
+	return "t=" + strconv.FormatInt(ts, 10) + ",v1=" + hex.EncodeToString(h.Sum(nil))
+}
+
+// webhookFuncs are available to payload templates; json encodes a value,
+// so strings are quoted and escaped
+var webhookFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+	"upper": strings.ToUpper,
+}
+
+// renderWebhook renders a payload template, empty for
+// DefaultWebhookTemplate, and checks the result is JSON
+func renderWebhook(text string, alert WebhookAlert) ([]byte, error) {
+	if text == "" {
+		text = DefaultWebhookTemplate
+	}
+	tmpl, err := template.New("webhook").Funcs(webhookFuncs).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid webhook template: %w", err)
+	}
+	var body bytes.Buffer
+	if err := tmpl.Execute(&body, alert); err != nil {
+		return nil, fmt.Errorf("render webhook payload: %w", err)
+	}
+	if !json.Valid(body.Bytes()) {
+		return nil, errors.New("webhook template did not render valid JSON")
+	}
+	return body.Bytes(), nil
+}
+
+// ValidateWebhookTemplate checks that a payload template parses and
+// renders valid JSON for a sample alert
+func ValidateWebhookTemplate(text string) error {
+	resolved := time.Now()
+	for _, alert := range []WebhookAlert{
+		{Webhook: "sample", Event: "raised", AlertType: "threshold", Severity: "critical",
+			MetricName: "psp_success_rate", Message: `PSP "Trustly" success rate below 95%`, Time: time.Now()},
+		{Webhook: "sample", Event: "resolved", AlertType: "threshold", Severity: "critical",
+			MetricName: "psp_success_rate", Time: time.Now(), ResolvedAt: &resolved},
+	} {
+		if _, err := renderWebhook(text, alert); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,402 @@
+package quality
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/mcbile/product-pulse/internal/model"
+)
+
+// Action taken when a field exceeds its size limit
+type Action string
+
+const (
+	ActionTruncate Action = "truncate" // Shorten the field and mark it truncated
+	ActionDrop     Action = "drop"     // Remove the field, keep the event
+	ActionReject   Action = "reject"   // Drop the whole event, keep the batch
+)
+
+// Fields with size policies
+const (
+	FieldMetadata     = "metadata"
+	FieldErrorMessage = "error_message"
+)
+
+// TruncatedMarker is appended to truncated string fields
+const TruncatedMarker = "…[truncated]"
+
+// Policy limits the size of one field
+type Policy struct {
+	Action   Action
+	MaxBytes int
+}
+
+// DefaultPolicies apply when no policy is configured for a field
+var DefaultPolicies = map[string]Policy{
+	FieldMetadata:     {Action: ActionTruncate, MaxBytes: 16 << 10},
+	FieldErrorMessage: {Action: ActionTruncate, MaxBytes: 4 << 10},
+}
+
+// Stat counts policy actions for a site and field
+type Stat struct {
+	SiteID string `json:"site_id"`
+	Field  string `json:"field"`
+	Action Action `json:"action"`
+	Count  int64  `json:"count"`
+}
+
+type statKey struct {
+	site   string
+	field  string
+	action Action
+}
+
+// MalformedStat counts events of a site and metric type that were left out
+// of their batch because they could not be decoded
+type MalformedStat struct {
+	SiteID     string `json:"site_id"`
+	MetricType string `json:"metric_type"`
+	Count      int64  `json:"count"`
+}
+
+type malformedKey struct {
+	site       string
+	metricType string
+}
+
+// DefaultMaxEventAge is the oldest event time accepted at ingest when no
+// maximum age is configured
+const DefaultMaxEventAge = 7 * 24 * time.Hour
+
+// StaleStat counts events of a site and metric type that were rejected
+// because they were older than the site's maximum event age
+type StaleStat struct {
+	SiteID     string `json:"site_id"`
+	MetricType string `json:"metric_type"`
+	Count      int64  `json:"count"`
+}
+
+// Limits applies per-site field size policies to incoming events and counts
+// every action taken, so oversized payloads degrade single events instead of
+// failing whole batches. It also counts malformed events skipped by lenient
+// batch decoding and events rejected for being older than the site's
+// maximum event age.
+type Limits struct {
+	defaults map[string]Policy
+	sites    map[string]map[string]Policy
+
+	maxAge      time.Duration            // 0 accepts any event time
+	siteMaxAges map[string]time.Duration // Per-site overrides of maxAge
+
+	mu        sync.Mutex
+	counts    map[statKey]int64
+	malformed map[malformedKey]int64
+	stale     map[malformedKey]int64
+}
+
+// ParsePolicies parses entries in the form [site/]field=action:max_bytes,
+// e.g. "metadata=truncate:8192" or "casino-prod/metadata=reject:4096".
+// Entries without a site override DefaultPolicies for all sites.
+func ParsePolicies(entries []string) (*Limits, error) {
+	l := &Limits{
+		defaults:    make(map[string]Policy, len(DefaultPolicies)),
+		sites:       make(map[string]map[string]Policy),
+		maxAge:      DefaultMaxEventAge,
+		siteMaxAges: make(map[string]time.Duration),
+		counts:      make(map[statKey]int64),
+		malformed:   make(map[malformedKey]int64),
+		stale:       make(map[malformedKey]int64),
+	}
+	for field, p := range DefaultPolicies {
+		l.defaults[field] = p
+	}
+
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		target, spec, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid field size policy %q, expected [site/]field=action:max_bytes", entry)
+		}
+		site, field, hasSite := strings.Cut(strings.TrimSpace(target), "/")
+		if !hasSite {
+			site, field = "", site
+		}
+		if _, known := DefaultPolicies[field]; !known {
+			return nil, fmt.Errorf("unknown field %q in size policy %q", field, entry)
+		}
+
+		action, size, _ := strings.Cut(strings.TrimSpace(spec), ":")
+		p := Policy{Action: Action(action), MaxBytes: DefaultPolicies[field].MaxBytes}
+		switch p.Action {
+		case ActionTruncate, ActionDrop, ActionReject:
+		default:
+			return nil, fmt.Errorf("unknown action %q in size policy %q", action, entry)
+		}
+		if size != "" {
+			n, err := strconv.Atoi(size)
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("invalid max_bytes in size policy %q", entry)
+			}
+			p.MaxBytes = n
+		}
+
+		if site == "" {
+			l.defaults[field] = p
+			continue
+		}
+		if l.sites[site] == nil {
+			l.sites[site] = make(map[string]Policy)
+		}
+		l.sites[site][field] = p
+	}
+
+	return l, nil
+}
+
+// SetMaxEventAges parses entries in the form [site=]duration, e.g. "168h" or
+// "casino-staging=720h". Entries without a site override DefaultMaxEventAge
+// for all sites; a duration of 0 accepts events of any age. It must be
+// called before events are checked.
+func (l *Limits) SetMaxEventAges(entries []string) error {
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		site, spec, hasSite := strings.Cut(entry, "=")
+		if !hasSite {
+			site, spec = "", site
+		}
+		age, err := time.ParseDuration(strings.TrimSpace(spec))
+		if err != nil || age < 0 {
+			return fmt.Errorf("invalid max event age %q, expected [site=]duration", entry)
+		}
+
+		if site = strings.TrimSpace(site); site == "" {
+			l.maxAge = age
+			continue
+		}
+		l.siteMaxAges[site] = age
+	}
+	return nil
+}
+
+// Fresh reports whether an event of metricType with time t is within the
+// site's maximum event age, and counts it as stale otherwise. Events
+// without a time are stamped at ingest and always fresh.
+func (l *Limits) Fresh(site, metricType string, t, now time.Time) bool {
+	maxAge, ok := l.siteMaxAges[site]
+	if !ok {
+		maxAge = l.maxAge
+	}
+	if maxAge == 0 || t.IsZero() || now.Sub(t) <= maxAge {
+		return true
+	}
+
+	l.mu.Lock()
+	l.stale[malformedKey{site, metricType}]++
+	l.mu.Unlock()
+	return false
+}
+
+// Admit checks a metric of metricType against the site's maximum event age
+// and field size policies, and stamps a missing time with now. It returns
+// false if the metric must be rejected. errorMessage may be nil.
+func (l *Limits) Admit(site, metricType string, now time.Time, t *time.Time, metadata *json.RawMessage, errorMessage **string) bool {
+	if !l.Fresh(site, metricType, *t, now) || !l.Apply(site, metadata, errorMessage) {
+		return false
+	}
+	if t.IsZero() {
+		*t = now
+	}
+	return true
+}
+
+// MaxClockDrift bounds how far the time of a frontend event may be from
+// the collector's clock; browsers' clocks are not trusted beyond it
+const MaxClockDrift = time.Hour
+
+// AdmitFrontend applies the field size policies to a frontend event and
+// replaces a missing time, or one further than MaxClockDrift from now, with
+// now. It returns false if the event must be rejected.
+func (l *Limits) AdmitFrontend(site string, e *model.FrontendEvent, now time.Time) bool {
+	if !l.Apply(site, &e.Metadata, nil) {
+		return false
+	}
+	// Countries not sent are resolved by the geoip stage
+	if e.Country == nil {
+		e.Country = new(string)
+	}
+	if diff := now.Sub(e.Time); e.Time.IsZero() || diff < -MaxClockDrift || diff > MaxClockDrift {
+		e.Time = now
+	}
+	return true
+}
+
+func (l *Limits) policy(site, field string) Policy {
+	if p, ok := l.sites[site][field]; ok {
+		return p
+	}
+	return l.defaults[field]
+}
+
+func (l *Limits) count(site, field string, action Action) {
+	l.mu.Lock()
+	l.counts[statKey{site, field, action}]++
+	l.mu.Unlock()
+}
+
+// Apply enforces size policies on an event's metadata and error message.
+// Either pointer may be nil if the event type has no such field. It returns
+// false if the event must be rejected.
+func (l *Limits) Apply(site string, metadata *json.RawMessage, errorMessage **string) bool {
+	if metadata != nil && len(*metadata) > 0 {
+		p := l.policy(site, FieldMetadata)
+		if len(*metadata) > p.MaxBytes {
+			l.count(site, FieldMetadata, p.Action)
+			switch p.Action {
+			case ActionReject:
+				return false
+			case ActionDrop:
+				*metadata = nil
+			default:
+				*metadata = truncateJSON(*metadata, p.MaxBytes)
+			}
+		}
+	}
+
+	if errorMessage != nil && *errorMessage != nil {
+		p := l.policy(site, FieldErrorMessage)
+		if len(**errorMessage) > p.MaxBytes {
+			l.count(site, FieldErrorMessage, p.Action)
+			switch p.Action {
+			case ActionReject:
+				return false
+			case ActionDrop:
+				*errorMessage = nil
+			default:
+				s := truncateString(**errorMessage, p.MaxBytes)
+				*errorMessage = &s
+			}
+		}
+	}
+
+	return true
+}
+
+// Stats returns action counts since startup, ordered by site and field
+func (l *Limits) Stats() []Stat {
+	l.mu.Lock()
+	stats := make([]Stat, 0, len(l.counts))
+	for k, n := range l.counts {
+		stats = append(stats, Stat{SiteID: k.site, Field: k.field, Action: k.action, Count: n})
+	}
+	l.mu.Unlock()
+
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].SiteID != stats[j].SiteID {
+			return stats[i].SiteID < stats[j].SiteID
+		}
+		if stats[i].Field != stats[j].Field {
+			return stats[i].Field < stats[j].Field
+		}
+		return stats[i].Action < stats[j].Action
+	})
+	return stats
+}
+
+// CountMalformed records n events of metricType that were rejected because
+// they could not be decoded
+func (l *Limits) CountMalformed(site, metricType string, n int) {
+	if n == 0 {
+		return
+	}
+	l.mu.Lock()
+	l.malformed[malformedKey{site, metricType}] += int64(n)
+	l.mu.Unlock()
+}
+
+// MalformedStats returns malformed event counts since startup, ordered by
+// site and metric type
+func (l *Limits) MalformedStats() []MalformedStat {
+	l.mu.Lock()
+	stats := make([]MalformedStat, 0, len(l.malformed))
+	for k, n := range l.malformed {
+		stats = append(stats, MalformedStat{SiteID: k.site, MetricType: k.metricType, Count: n})
+	}
+	l.mu.Unlock()
+
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].SiteID != stats[j].SiteID {
+			return stats[i].SiteID < stats[j].SiteID
+		}
+		return stats[i].MetricType < stats[j].MetricType
+	})
+	return stats
+}
+
+// StaleStats returns counts of events rejected for their age since
+// startup, ordered by site and metric type
+func (l *Limits) StaleStats() []StaleStat {
+	l.mu.Lock()
+	stats := make([]StaleStat, 0, len(l.stale))
+	for k, n := range l.stale {
+		stats = append(stats, StaleStat{SiteID: k.site, MetricType: k.metricType, Count: n})
+	}
+	l.mu.Unlock()
+
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].SiteID != stats[j].SiteID {
+			return stats[i].SiteID < stats[j].SiteID
+		}
+		return stats[i].MetricType < stats[j].MetricType
+	})
+	return stats
+}
+
+// truncateString cuts s to at most maxBytes including the marker, on a rune
+// boundary
+func truncateString(s string, maxBytes int) string {
+	n := maxBytes - len(TruncatedMarker)
+	if n <= 0 {
+		return TruncatedMarker
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n] + TruncatedMarker
+}
+
+// truncateJSON replaces oversized metadata with a marker object that keeps a
+// preview of the original, since cutting JSON mid-document would not be
+// valid jsonb
+func truncateJSON(raw json.RawMessage, maxBytes int) json.RawMessage {
+	marker := map[string]interface{}{
+		"_truncated":      true,
+		"_original_bytes": len(raw),
+	}
+	overhead, _ := json.Marshal(marker)
+
+	// Leave room for the preview key and JSON escaping of the preview
+	if budget := (maxBytes - len(overhead) - len(`,"_preview":""`)) / 2; budget > 0 {
+		marker["_preview"] = truncateString(string(raw), budget)
+	}
+
+	b, _ := json.Marshal(marker)
+	if len(b) > maxBytes {
+		// Escaped preview still too large (e.g. many HTML characters)
+		b = overhead
+	}
+	return b
+}
@@ -0,0 +1,189 @@
+package recommend
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mcbile/product-pulse/internal/storage"
+)
+
+// Kinds of recommendations
+const (
+	KindSample    = "sample"    // Send only a share of the events
+	KindNormalize = "normalize" // Reduce the cardinality of a field before sending
+)
+
+// Thresholds above which a site gets a recommendation
+const (
+	wsSamplesPerConnectionHour = 60        // More than one latency ping per connection and minute
+	maxEventsPerHour           = 1_000_000 // Per site and metric type
+	maxKeys                    = 1000      // Distinct page paths or API endpoints
+	minEvents                  = 1000      // Fewer events in the window are never worth sampling
+)
+
+// sampleRates are the rates recommended, highest first
+var sampleRates = []float64{0.5, 0.25, 0.1, 0.05, 0.01, 0.005, 0.001}
+
+// Storage is the subset of storage used by the analyzer
+type Storage interface {
+	GetIngestProfile(ctx context.Context, start time.Time) ([]storage.IngestProfileRow, error)
+}
+
+// Recommendation is a sampling or aggregation setting for one metric type
+// of a site, with the volume it would save
+type Recommendation struct {
+	SiteID        string  `json:"site_id"`
+	MetricType    string  `json:"metric_type"`
+	Kind          string  `json:"kind"`
+	Target        string  `json:"target"`                // What to sample or normalize
+	SampleRate    float64 `json:"sample_rate,omitempty"` // Share of Target to keep, for KindSample
+	Reason        string  `json:"reason"`
+	EventsPerHour float64 `json:"events_per_hour"`
+	SavedPerHour  float64 `json:"saved_events_per_hour,omitempty"` // Estimate, for KindSample
+}
+
+// Config for the analyzer
+type Config struct {
+	Window   time.Duration // Ingest inspected per analysis
+	CacheTTL time.Duration // How long an analysis is reused
+}
+
+// Analyzer inspects recent ingest volumes and cardinalities per site and
+// recommends sampling and aggregation settings, since tenants often send
+// every WebSocket ping or raw URLs without realizing the cost. Analyses
+// scan the raw metrics of the window and are cached for CacheTTL.
+type Analyzer struct {
+	config  Config
+	storage Storage
+
+	mu       sync.Mutex
+	cached   []Recommendation
+	cachedAt time.Time
+}
+
+// NewAnalyzer creates an analyzer
+func NewAnalyzer(config Config, storage Storage) *Analyzer {
+	if config.Window <= 0 {
+		config.Window = time.Hour
+	}
+	if config.CacheTTL <= 0 {
+		config.CacheTTL = 15 * time.Minute
+	}
+	return &Analyzer{config: config, storage: storage}
+}
+
+// Window returns the ingest window inspected per analysis
+func (a *Analyzer) Window() time.Duration {
+	return a.config.Window
+}
+
+// Recommend returns the recommendations of the latest analysis, running a
+// new one if the cached one expired. Concurrent callers wait for the same
+// analysis.
+func (a *Analyzer) Recommend(ctx context.Context) ([]Recommendation, time.Time, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := time.Now()
+	if !a.cachedAt.IsZero() && now.Sub(a.cachedAt) < a.config.CacheTTL {
+		return a.cached, a.cachedAt, nil
+	}
+
+	profile, err := a.storage.GetIngestProfile(ctx, now.Add(-a.config.Window))
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("ingest profile: %w", err)
+	}
+
+	a.cached = analyze(profile, a.config.Window.Hours())
+	a.cachedAt = now
+	return a.cached, a.cachedAt, nil
+}
+
+// analyze turns an ingest profile into recommendations, largest savings
+// first
+func analyze(profile []storage.IngestProfileRow, hours float64) []Recommendation {
+	recs := []Recommendation{}
+	for _, row := range profile {
+		perHour := float64(row.Events) / hours
+
+		// WebSocket pings: one latency sample per connection and minute is
+		// plenty for percentiles
+		if row.MetricType == "ws" && row.Sources > 0 && row.Samples >= minEvents {
+			perConnection := float64(row.Samples) / float64(row.Sources) / hours
+			if rate, ok := sampleRate(wsSamplesPerConnectionHour / perConnection); ok {
+				samples := float64(row.Samples) / hours
+				recs = append(recs, Recommendation{
+					SiteID:        row.SiteID,
+					MetricType:    row.MetricType,
+					Kind:          KindSample,
+					Target:        "latency pings",
+					SampleRate:    rate,
+					Reason:        fmt.Sprintf("%.0f latency pings per connection and hour; %d per hour are enough for percentiles", perConnection, wsSamplesPerConnectionHour),
+					EventsPerHour: perHour,
+					SavedPerHour:  samples * (1 - rate),
+				})
+			}
+		}
+
+		// Raw volume of types whose events are interchangeable. PSP and
+		// game events are business records and never sampled.
+		if (row.MetricType == "frontend" || row.MetricType == "api") && row.Events >= minEvents {
+			if rate, ok := sampleRate(maxEventsPerHour / perHour); ok {
+				target := "events other than errors and crashes"
+				if row.MetricType == "api" {
+					target = "successful requests"
+				}
+				recs = append(recs, Recommendation{
+					SiteID:        row.SiteID,
+					MetricType:    row.MetricType,
+					Kind:          KindSample,
+					Target:        target,
+					SampleRate:    rate,
+					Reason:        fmt.Sprintf("%.0f events per hour, above %d", perHour, maxEventsPerHour),
+					EventsPerHour: perHour,
+					SavedPerHour:  perHour * (1 - rate),
+				})
+			}
+		}
+
+		// Unbounded keys bloat the rollups and make breakdowns useless
+		if row.Keys > maxKeys {
+			target := map[string]string{"frontend": "page_path", "api": "endpoint"}[row.MetricType]
+			if target != "" {
+				recs = append(recs, Recommendation{
+					SiteID:        row.SiteID,
+					MetricType:    row.MetricType,
+					Kind:          KindNormalize,
+					Target:        target,
+					Reason:        fmt.Sprintf("%d distinct values; send templates such as /users/{id} instead of raw paths", row.Keys),
+					EventsPerHour: perHour,
+				})
+			}
+		}
+	}
+
+	sort.SliceStable(recs, func(i, j int) bool {
+		if recs[i].SavedPerHour != recs[j].SavedPerHour {
+			return recs[i].SavedPerHour > recs[j].SavedPerHour
+		}
+		return recs[i].SiteID < recs[j].SiteID
+	})
+	return recs
+}
+
+// sampleRate returns the highest of sampleRates not above want, and false
+// if want does not call for sampling
+func sampleRate(want float64) (float64, bool) {
+	if want >= 1 {
+		return 0, false
+	}
+	for _, r := range sampleRates {
+		if r <= want {
+			return r, true
+		}
+	}
+	return sampleRates[len(sampleRates)-1], true
+}
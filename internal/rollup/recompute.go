@@ -0,0 +1,211 @@
+package rollup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/mcbile/product-pulse/internal/trace"
+)
+
+// Recomputation states
+const (
+	RecomputeRunning = "running"
+	RecomputeDone    = "done"
+	RecomputeFailed  = "failed"
+)
+
+// Errors returned by Recomputer.Start
+var (
+	ErrUnknownFamily    = errors.New("unknown metric family")
+	ErrInvalidRange     = errors.New("invalid time range")
+	ErrRecomputeRunning = errors.New("a recomputation is already running")
+)
+
+// FamilyAll selects every rollup
+const FamilyAll = "all"
+
+const (
+	// recomputeChunk is the span refreshed per call, a multiple of every
+	// bucket width; smaller calls keep transactions short and progress
+	// visible
+	recomputeChunk = 24 * time.Hour
+
+	// maxRecomputeRange bounds one recomputation
+	maxRecomputeRange = 366 * 24 * time.Hour
+
+	// keptRecomputations is how many finished recomputations are listed
+	keptRecomputations = 20
+)
+
+// Recomputation is the progress of one recomputation
+type Recomputation struct {
+	ID         string     `json:"id"`
+	Family     string     `json:"family"`
+	Views      []string   `json:"views"`
+	Start      time.Time  `json:"start"`
+	End        time.Time  `json:"end"`
+	Status     string     `json:"status"`
+	Chunks     int        `json:"chunks"`      // Refresh calls in total
+	ChunksDone int        `json:"chunks_done"` // Refresh calls finished
+	Progress   float64    `json:"progress"`    // 0-1
+	Error      string     `json:"error,omitempty"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// Recomputer re-derives rollups for an explicit time range, e.g. after a
+// backfill beyond the lateness window or after rows were corrected or
+// deleted, which the refresh policies and the watermark tracker never
+// revisit. Recomputations run in the background one at a time, one
+// recomputeChunk per refresh call, and their progress is kept in memory.
+type Recomputer struct {
+	ctx     context.Context
+	storage Storage
+
+	mu     sync.Mutex
+	recent []*Recomputation // Newest last
+	active bool
+}
+
+// NewRecomputer creates a recomputer; recomputations stop when ctx is
+// cancelled
+func NewRecomputer(ctx context.Context, storage Storage) *Recomputer {
+	return &Recomputer{ctx: ctx, storage: storage}
+}
+
+// Families returns the metric families accepted by Start
+func Families() []string {
+	families := []string{FamilyAll}
+	for _, r := range Rollups {
+		families = append(families, r.Family)
+	}
+	return families
+}
+
+// Start begins recomputing the rollups of family (see Families) for
+// [start, end), widened to whole buckets, and returns its initial progress
+func (rc *Recomputer) Start(family string, start, end time.Time) (Recomputation, error) {
+	var rollups []Rollup
+	for _, r := range Rollups {
+		if family == FamilyAll || r.Family == family {
+			rollups = append(rollups, r)
+		}
+	}
+	if len(rollups) == 0 {
+		return Recomputation{}, ErrUnknownFamily
+	}
+
+	now := time.Now().UTC()
+	if end.IsZero() || end.After(now) {
+		end = now
+	}
+	if !start.Before(end) {
+		return Recomputation{}, fmt.Errorf("%w: start must be before end", ErrInvalidRange)
+	}
+	if end.Sub(start) > maxRecomputeRange {
+		return Recomputation{}, fmt.Errorf("%w: at most %s", ErrInvalidRange, maxRecomputeRange)
+	}
+
+	start = start.UTC().Truncate(recomputeChunk)
+	if t := end.UTC().Truncate(recomputeChunk); !t.Equal(end) {
+		end = t.Add(recomputeChunk)
+	}
+	chunks := int(end.Sub(start) / recomputeChunk)
+
+	rec := &Recomputation{
+		ID:        trace.NewID(),
+		Family:    family,
+		Start:     start,
+		End:       end,
+		Status:    RecomputeRunning,
+		Chunks:    chunks * len(rollups),
+		StartedAt: now,
+	}
+	for _, r := range rollups {
+		rec.Views = append(rec.Views, r.View)
+	}
+
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if rc.active {
+		return Recomputation{}, ErrRecomputeRunning
+	}
+	rc.active = true
+	rc.recent = append(rc.recent, rec)
+	if len(rc.recent) > keptRecomputations {
+		rc.recent = rc.recent[len(rc.recent)-keptRecomputations:]
+	}
+
+	go rc.run(rec, rollups)
+	return rc.snapshot(rec), nil
+}
+
+// run refreshes the rollups chunk by chunk, oldest first
+func (rc *Recomputer) run(rec *Recomputation, rollups []Rollup) {
+	slog.Info("rollup recomputation started",
+		"id", rec.ID, "family", rec.Family, "start", rec.Start, "end", rec.End, "chunks", rec.Chunks)
+
+	var err error
+run:
+	for _, r := range rollups {
+		for from := rec.Start; from.Before(rec.End); from = from.Add(recomputeChunk) {
+			if err = rc.storage.RefreshRollup(rc.ctx, r.View, from, from.Add(recomputeChunk)); err != nil {
+				break run
+			}
+			rc.mu.Lock()
+			rec.ChunksDone++
+			rc.mu.Unlock()
+		}
+	}
+
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	finished := time.Now().UTC()
+	rec.FinishedAt = &finished
+	rc.active = false
+	if err != nil {
+		rec.Status = RecomputeFailed
+		rec.Error = err.Error()
+		slog.Error("rollup recomputation failed", "id", rec.ID, "chunks_done", rec.ChunksDone, "error", err)
+		return
+	}
+	rec.Status = RecomputeDone
+	slog.Info("rollup recomputation finished", "id", rec.ID, "duration", finished.Sub(rec.StartedAt))
+}
+
+// Get returns the progress of a recomputation
+func (rc *Recomputer) Get(id string) (Recomputation, bool) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	for _, rec := range rc.recent {
+		if rec.ID == id {
+			return rc.snapshot(rec), true
+		}
+	}
+	return Recomputation{}, false
+}
+
+// List returns the running and recent recomputations, newest first
+func (rc *Recomputer) List() []Recomputation {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	list := make([]Recomputation, 0, len(rc.recent))
+	for i := len(rc.recent) - 1; i >= 0; i-- {
+		list = append(list, rc.snapshot(rc.recent[i]))
+	}
+	return list
+}
+
+// snapshot copies rec with its progress; rc.mu must be held
+func (rc *Recomputer) snapshot(rec *Recomputation) Recomputation {
+	s := *rec
+	s.Views = append([]string(nil), rec.Views...)
+	if s.Chunks > 0 {
+		s.Progress = float64(s.ChunksDone) / float64(s.Chunks)
+	}
+	return s
+}
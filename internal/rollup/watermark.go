@@ -0,0 +1,265 @@
+package rollup
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mcbile/product-pulse/internal/collector"
+	"github.com/mcbile/product-pulse/internal/model"
+)
+
+// Rollup is a continuous aggregate and the refresh policy that maintains it
+// (see product_pulse_schema.sql)
+type Rollup struct {
+	View   string        // Continuous aggregate
+	Source string        // Hypertable it aggregates
+	Family string        // Metric family, see Recomputer
+	Bucket time.Duration // time_bucket width
+	Offset time.Duration // start_offset of the refresh policy
+}
+
+// Rollups lists the continuous aggregates defined by the schema
+var Rollups = []Rollup{
+	{View: "api_performance_1m", Source: "api_metrics", Family: "api", Bucket: time.Minute, Offset: 10 * time.Minute},
+	{View: "psp_success_5m", Source: "psp_metrics", Family: "psp", Bucket: 5 * time.Minute, Offset: 30 * time.Minute},
+	{View: "web_vitals_hourly", Source: "frontend_metrics", Family: "frontend", Bucket: time.Hour, Offset: 3 * time.Hour},
+	{View: "game_health_5m", Source: "game_metrics", Family: "game", Bucket: 5 * time.Minute, Offset: 30 * time.Minute},
+}
+
+// Storage is the subset of storage used to re-aggregate late buckets
+type Storage interface {
+	RefreshRollup(ctx context.Context, view string, start, end time.Time) error
+}
+
+// Config for watermark tracking
+type Config struct {
+	Lateness time.Duration // Late events older than this are not re-aggregated
+}
+
+// Stat describes the watermark and late data of one rollup
+type Stat struct {
+	View                string     `json:"view"`
+	Source              string     `json:"source"`
+	Watermark           *time.Time `json:"watermark,omitempty"`
+	Observed            int64      `json:"observed"`
+	Late                int64      `json:"late"`
+	Expired             int64      `json:"expired"`
+	MaxLatenessSeconds  float64    `json:"max_lateness_seconds"`
+	PendingBuckets      int        `json:"pending_buckets"`
+	ReaggregatedBuckets int64      `json:"reaggregated_buckets"`
+	RefreshErrors       int64      `json:"refresh_errors"`
+	LastRefresh         *time.Time `json:"last_refresh,omitempty"`
+}
+
+type state struct {
+	rollup Rollup
+
+	maxEventTime time.Time
+	observed     int64
+	late         int64
+	expired      int64
+	maxLateness  time.Duration
+	dirty        map[time.Time]struct{} // Bucket starts awaiting re-aggregation
+	reaggregated int64
+	errors       int64
+	lastRefresh  time.Time
+}
+
+// watermark is the end of the window the refresh policy still covers;
+// buckets before it are considered final
+func (s *state) watermark() time.Time {
+	return s.maxEventTime.Add(-s.rollup.Offset)
+}
+
+// Tracker keeps an event-time watermark per rollup. The refresh policies
+// only re-materialize the last start_offset of data, so events persisted
+// behind the watermark would never reach their rollup; the tracker marks
+// their buckets and re-aggregates them, as long as they arrive within the
+// lateness window.
+type Tracker struct {
+	config  Config
+	storage Storage
+
+	mu       sync.Mutex
+	rollups  []*state
+	bySource map[string][]*state
+}
+
+// NewTracker creates a watermark tracker for Rollups
+func NewTracker(config Config, storage Storage) *Tracker {
+	if config.Lateness <= 0 {
+		config.Lateness = 24 * time.Hour
+	}
+
+	t := &Tracker{
+		config:   config,
+		storage:  storage,
+		bySource: make(map[string][]*state),
+	}
+	for _, r := range Rollups {
+		s := &state{rollup: r, dirty: make(map[time.Time]struct{})}
+		t.rollups = append(t.rollups, s)
+		t.bySource[r.Source] = append(t.bySource[r.Source], s)
+	}
+	return t
+}
+
+// Attach observes every batch the collectors persist
+func (t *Tracker) Attach(frontend *collector.BatchCollector, backend *collector.Backend) {
+	frontend.OnFlush(observer(t, "frontend_metrics", func(e *model.EnrichedEvent) time.Time { return e.Time }))
+	backend.API.OnFlush(observer(t, "api_metrics", func(m *model.APIMetric) time.Time { return m.Time }))
+	backend.PSP.OnFlush(observer(t, "psp_metrics", func(m *model.PSPMetric) time.Time { return m.Time }))
+	backend.Game.OnFlush(observer(t, "game_metrics", func(m *model.GameMetric) time.Time { return m.Time }))
+}
+
+func observer[T any](t *Tracker, source string, eventTime func(*T) time.Time) func([]T) {
+	return func(items []T) {
+		times := make([]time.Time, len(items))
+		for i := range items {
+			times[i] = eventTime(&items[i])
+		}
+		t.Observe(source, times)
+	}
+}
+
+// Observe records event times persisted to a source table
+func (t *Tracker) Observe(source string, times []time.Time) {
+	rollups := t.bySource[source]
+	if len(rollups) == 0 || len(times) == 0 {
+		return
+	}
+
+	now := time.Now().UTC()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, s := range rollups {
+		for _, et := range times {
+			// Clients with skewed clocks must not push the watermark ahead
+			if et.After(now) {
+				et = now
+			}
+			s.observed++
+			if et.After(s.maxEventTime) {
+				s.maxEventTime = et
+			}
+
+			if !et.Before(s.watermark()) {
+				continue
+			}
+			s.late++
+			lateness := s.maxEventTime.Sub(et)
+			if lateness > s.maxLateness {
+				s.maxLateness = lateness
+			}
+			if lateness > t.config.Lateness {
+				s.expired++
+				continue
+			}
+			s.dirty[et.Truncate(s.rollup.Bucket)] = struct{}{}
+		}
+	}
+}
+
+// Reaggregate refreshes every rollup bucket that received late events.
+// Adjacent buckets are refreshed in one call; failed ranges are retried on
+// the next run.
+func (t *Tracker) Reaggregate(ctx context.Context) error {
+	var errs []error
+	for _, s := range t.rollups {
+		t.mu.Lock()
+		buckets := make([]time.Time, 0, len(s.dirty))
+		for b := range s.dirty {
+			buckets = append(buckets, b)
+		}
+		s.dirty = make(map[time.Time]struct{})
+		t.mu.Unlock()
+
+		if len(buckets) == 0 {
+			continue
+		}
+		sort.Slice(buckets, func(i, j int) bool { return buckets[i].Before(buckets[j]) })
+
+		for _, rng := range bucketRanges(buckets, s.rollup.Bucket) {
+			err := t.storage.RefreshRollup(ctx, s.rollup.View, rng.start, rng.end)
+
+			t.mu.Lock()
+			if err != nil {
+				s.errors++
+				for _, b := range rng.buckets {
+					s.dirty[b] = struct{}{}
+				}
+			} else {
+				s.reaggregated += int64(len(rng.buckets))
+				s.lastRefresh = time.Now().UTC()
+			}
+			t.mu.Unlock()
+
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			slog.Info("late rollup buckets re-aggregated",
+				"view", s.rollup.View,
+				"start", rng.start,
+				"end", rng.end,
+				"buckets", len(rng.buckets),
+			)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+type bucketRange struct {
+	start, end time.Time
+	buckets    []time.Time
+}
+
+// bucketRanges merges sorted bucket starts into contiguous ranges
+func bucketRanges(buckets []time.Time, width time.Duration) []bucketRange {
+	var ranges []bucketRange
+	for _, b := range buckets {
+		if n := len(ranges); n > 0 && ranges[n-1].end.Equal(b) {
+			ranges[n-1].end = b.Add(width)
+			ranges[n-1].buckets = append(ranges[n-1].buckets, b)
+			continue
+		}
+		ranges = append(ranges, bucketRange{start: b, end: b.Add(width), buckets: []time.Time{b}})
+	}
+	return ranges
+}
+
+// Stats returns watermarks and late-data counts since startup
+func (t *Tracker) Stats() []Stat {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	stats := make([]Stat, 0, len(t.rollups))
+	for _, s := range t.rollups {
+		st := Stat{
+			View:                s.rollup.View,
+			Source:              s.rollup.Source,
+			Observed:            s.observed,
+			Late:                s.late,
+			Expired:             s.expired,
+			MaxLatenessSeconds:  s.maxLateness.Seconds(),
+			PendingBuckets:      len(s.dirty),
+			ReaggregatedBuckets: s.reaggregated,
+			RefreshErrors:       s.errors,
+		}
+		if !s.maxEventTime.IsZero() {
+			wm := s.watermark()
+			st.Watermark = &wm
+		}
+		if !s.lastRefresh.IsZero() {
+			lr := s.lastRefresh
+			st.LastRefresh = &lr
+		}
+		stats = append(stats, st)
+	}
+	return stats
+}
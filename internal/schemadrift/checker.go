@@ -0,0 +1,201 @@
+// Package schemadrift raises alerts when a sender keeps sending events
+// whose fields differ from the collector's model, so an incompatible SDK
+// release is caught within minutes instead of at month-end analysis.
+package schemadrift
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mcbile/product-pulse/internal/storage"
+)
+
+// AlertType used for schema drift alerts in alert_events
+const AlertType = "schema_drift"
+
+// maxAlertFields bounds the fields listed in an alert message
+const maxAlertFields = 5
+
+// Storage is the subset of storage used by the drift checker
+type Storage interface {
+	GetSchemaDrift(ctx context.Context, start time.Time, sites []string) ([]storage.SchemaDriftRow, error)
+	GetAlerts(ctx context.Context, f storage.AlertFilter, state string, limit int) ([]storage.AlertRow, error)
+	InsertAlert(ctx context.Context, alert storage.AlertRow) error
+	ResolveAlerts(ctx context.Context, alertType, metricName string) error
+}
+
+// Config for the drift checker
+type Config struct {
+	Window    time.Duration // Lookback for drifting events
+	MinEvents int64         // Events with a drifting field within Window that raise an alert
+}
+
+// Checker raises an alert per sender and metric type with fields drifting
+// in at least MinEvents events, and resolves it once none do
+type Checker struct {
+	config  Config
+	storage Storage
+}
+
+// NewChecker creates a new drift checker
+func NewChecker(config Config, storage Storage) *Checker {
+	if config.Window <= 0 {
+		config.Window = 15 * time.Minute
+	}
+	if config.MinEvents <= 0 {
+		config.MinEvents = 100
+	}
+	return &Checker{config: config, storage: storage}
+}
+
+// Sender names who sent drifting events: the SDK version, or the producer
+// for senders without SDK header
+func Sender(d storage.SchemaDriftRow) string {
+	switch {
+	case d.SDK != "":
+		return d.SDK + "/" + d.SDKVersion
+	case d.Producer != "":
+		return d.Producer
+	}
+	return "unknown"
+}
+
+// MetricName is the alert metric name of drift of a sender and metric type
+func MetricName(sender, metricType string) string {
+	return "schema_drift:" + sender + ":" + metricType
+}
+
+// driftingFields are the drifting fields of one sender and metric type
+type driftingFields struct {
+	sender     string
+	metricType string
+	fields     []storage.SchemaDriftRow
+	producers  map[string]bool
+}
+
+// Evaluate checks the drift of the last window once, raising and resolving
+// alerts as needed
+func (c *Checker) Evaluate(ctx context.Context) error {
+	now := time.Now().UTC()
+	rows, err := c.storage.GetSchemaDrift(ctx, now.Add(-c.config.Window), nil)
+	if err != nil {
+		return err
+	}
+
+	// Rows of several sites and producers of the same field add up
+	totals := make(map[string]*storage.SchemaDriftRow)
+	groups := make(map[string]*driftingFields)
+	for _, row := range rows {
+		sender := Sender(row)
+		metricName := MetricName(sender, row.MetricType)
+		g, ok := groups[metricName]
+		if !ok {
+			g = &driftingFields{sender: sender, metricType: row.MetricType, producers: make(map[string]bool)}
+			groups[metricName] = g
+		}
+		if row.Producer != "" {
+			g.producers[row.Producer] = true
+		}
+
+		key := metricName + "\x00" + row.Kind + "\x00" + row.Field
+		if t, ok := totals[key]; ok {
+			t.Events += row.Events
+			continue
+		}
+		total := row
+		totals[key] = &total
+	}
+	for key, t := range totals {
+		metricName, _, _ := strings.Cut(key, "\x00")
+		if t.Events >= c.config.MinEvents {
+			groups[metricName].fields = append(groups[metricName].fields, *t)
+		}
+	}
+
+	open, err := c.storage.GetAlerts(ctx, storage.AlertFilter{AlertType: AlertType}, storage.AlertStateOpen, 1000)
+	if err != nil {
+		return err
+	}
+	isOpen := make(map[string]bool, len(open))
+	for _, a := range open {
+		isOpen[a.MetricName] = true
+	}
+
+	for metricName, g := range groups {
+		if len(g.fields) == 0 || isOpen[metricName] {
+			continue
+		}
+		sort.Slice(g.fields, func(i, j int) bool { return g.fields[i].Events > g.fields[j].Events })
+
+		slog.Warn("schema drift", "sender", g.sender, "metric_type", g.metricType, "fields", len(g.fields))
+		err := c.storage.InsertAlert(ctx, storage.AlertRow{
+			Time:           now,
+			AlertType:      AlertType,
+			Severity:       "warning",
+			SourceTable:    "schema_drift",
+			MetricName:     metricName,
+			Target:         g.sender,
+			ThresholdValue: float64(c.config.MinEvents),
+			ActualValue:    float64(g.fields[0].Events),
+			Message:        c.message(g),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	for metricName := range isOpen {
+		if g, ok := groups[metricName]; ok && len(g.fields) > 0 {
+			continue
+		}
+		if err := c.storage.ResolveAlerts(ctx, AlertType, metricName); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// message describes the drifting fields, the most frequent first, e.g.
+// "go/1.4.0 sends api events with unknown fields durationMs (1520) and
+// without duration_ms (1520) in the last 15m0s (producers: wallet)"
+func (c *Checker) message(g *driftingFields) string {
+	var unknown, missing []string
+	for i, f := range g.fields {
+		if i == maxAlertFields {
+			break
+		}
+		field := fmt.Sprintf("%s (%d)", f.Field, f.Events)
+		if f.Kind == storage.SchemaDriftMissing {
+			missing = append(missing, field)
+		} else {
+			unknown = append(unknown, field)
+		}
+	}
+
+	var parts []string
+	if len(unknown) > 0 {
+		parts = append(parts, "with unknown fields "+strings.Join(unknown, ", "))
+	}
+	if len(missing) > 0 {
+		parts = append(parts, "without "+strings.Join(missing, ", "))
+	}
+	msg := fmt.Sprintf("%s sends %s events %s in the last %s", g.sender, g.metricType, strings.Join(parts, " and "), c.config.Window)
+	if more := len(g.fields) - maxAlertFields; more > 0 {
+		msg += fmt.Sprintf(", %d more fields", more)
+	}
+
+	if len(g.producers) > 0 {
+		producers := make([]string, 0, len(g.producers))
+		for p := range g.producers {
+			producers = append(producers, p)
+		}
+		sort.Strings(producers)
+		msg += " (producers: " + strings.Join(producers, ", ") + ")"
+	}
+	return msg
+}
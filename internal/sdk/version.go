@@ -0,0 +1,97 @@
+package sdk
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Header carries the sending SDK as name/version, e.g. "go/1.4.0" or "js/1.4.0"
+const Header = "X-Pulse-SDK"
+
+// DeprecationHeader is set on responses to SDKs older than the configured
+// minimum version
+const DeprecationHeader = "X-Pulse-SDK-Deprecated"
+
+// Parse splits an SDK header value into name and version. Values without a
+// slash are treated as a bare name.
+func Parse(value string) (name, version string) {
+	name, version, _ = strings.Cut(strings.TrimSpace(value), "/")
+	return strings.ToLower(strings.TrimSpace(name)), strings.TrimSpace(version)
+}
+
+// Compare compares two dotted versions numerically, returning -1, 0 or 1.
+// A leading "v" and pre-release/build suffixes are ignored; missing
+// components count as zero.
+func Compare(a, b string) int {
+	pa, pb := versionParts(a), versionParts(b)
+	for i := 0; i < len(pa) || i < len(pb); i++ {
+		var x, y int
+		if i < len(pa) {
+			x = pa[i]
+		}
+		if i < len(pb) {
+			y = pb[i]
+		}
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+	}
+	return 0
+}
+
+func versionParts(v string) []int {
+	v = strings.TrimPrefix(strings.TrimSpace(v), "v")
+	if i := strings.IndexAny(v, "-+"); i >= 0 {
+		v = v[:i]
+	}
+	if v == "" {
+		return nil
+	}
+	fields := strings.Split(v, ".")
+	parts := make([]int, len(fields))
+	for i, f := range fields {
+		n, err := strconv.Atoi(f)
+		if err != nil {
+			return parts[:i]
+		}
+		parts[i] = n
+	}
+	return parts
+}
+
+// Policy maps SDK names to the minimum supported version
+type Policy map[string]string
+
+// ParsePolicy parses entries in the form sdk=min_version, e.g. "go=1.3.0"
+func ParsePolicy(entries []string) (Policy, error) {
+	policy := make(Policy, len(entries))
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, min, ok := strings.Cut(entry, "=")
+		name = strings.ToLower(strings.TrimSpace(name))
+		min = strings.TrimSpace(min)
+		if !ok || name == "" || len(versionParts(min)) == 0 {
+			return nil, fmt.Errorf("invalid sdk minimum version %q, expected sdk=version", entry)
+		}
+		policy[name] = min
+	}
+	return policy, nil
+}
+
+// Deprecated reports whether version is below the minimum for name, and
+// returns that minimum. Unknown SDKs and unparseable versions are never
+// deprecated.
+func (p Policy) Deprecated(name, version string) (string, bool) {
+	min, ok := p[name]
+	if !ok || len(versionParts(version)) == 0 {
+		return "", false
+	}
+	return min, Compare(version, min) < 0
+}
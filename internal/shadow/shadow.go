@@ -0,0 +1,329 @@
+package shadow
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mcbile/product-pulse/internal/model"
+)
+
+// Tables written by the collectors, compared by Compare
+var Tables = []string{"frontend_metrics", "api_metrics", "psp_metrics", "game_metrics", "websocket_metrics"}
+
+// Primary is the storage the collectors write to
+type Primary interface {
+	CopyFrontendMetrics(ctx context.Context, events []model.EnrichedEvent) error
+	InsertFrontendMetrics(ctx context.Context, events []model.EnrichedEvent) error
+	CopyAPIMetrics(ctx context.Context, metrics []model.APIMetric) error
+	InsertAPIMetrics(ctx context.Context, metrics []model.APIMetric) error
+	CopyPSPMetrics(ctx context.Context, metrics []model.PSPMetric) error
+	InsertPSPMetrics(ctx context.Context, metrics []model.PSPMetric) error
+	CopyGameMetrics(ctx context.Context, metrics []model.GameMetric) error
+	InsertGameMetrics(ctx context.Context, metrics []model.GameMetric) error
+	CopyWebSocketMetrics(ctx context.Context, metrics []model.WebSocketMetric) error
+	InsertWebSocketMetrics(ctx context.Context, metrics []model.WebSocketMetric) error
+	CountRows(ctx context.Context, table string, start, end time.Time) (int64, error)
+}
+
+// Candidate is the storage being evaluated
+type Candidate interface {
+	Insert(ctx context.Context, table string, rows []byte) error
+	Count(ctx context.Context, table string, start, end time.Time) (int64, error)
+}
+
+// Config for shadow writes
+type Config struct {
+	Workers    int           // Concurrent candidate writes
+	QueueSize  int           // Batches waiting for the candidate; more are dropped
+	Lag        time.Duration // Comparisons skip the most recent data, which may still be queued
+	Window     time.Duration // Time range compared per run
+	Divergence float64       // Row count difference (fraction) reported as divergent
+}
+
+// Writer sits between the collectors and the primary storage. Every batch
+// the primary accepts is also written to the candidate in the background,
+// and both write latencies are recorded. Compare periodically counts rows in
+// both backends and reports divergence. The candidate never slows down or
+// fails primary writes.
+//
+// Rows of a partially failed COPY that did reach the primary are not
+// mirrored, so they show up as divergence.
+type Writer struct {
+	primary   Primary
+	candidate Candidate
+	config    Config
+	queue     chan shadowBatch
+	stats     map[string]*tableStats
+
+	mu          sync.Mutex
+	comparisons map[string]Comparison
+}
+
+type shadowBatch struct {
+	table string
+	rows  []byte
+	count int
+}
+
+type tableStats struct {
+	batches         atomic.Int64
+	rows            atomic.Int64
+	primaryNs       atomic.Int64
+	primaryErrors   atomic.Int64
+	candidateWrites atomic.Int64
+	candidateNs     atomic.Int64
+	candidateErrors atomic.Int64
+	dropped         atomic.Int64
+}
+
+// TableStats compares primary and candidate writes of one table
+type TableStats struct {
+	Table           string      `json:"table"`
+	Batches         int64       `json:"batches"`
+	Rows            int64       `json:"rows"`
+	PrimaryAvgMS    float64     `json:"primary_avg_ms"`
+	PrimaryErrors   int64       `json:"primary_errors"`
+	CandidateAvgMS  float64     `json:"candidate_avg_ms"`
+	CandidateErrors int64       `json:"candidate_errors"`
+	DroppedBatches  int64       `json:"dropped_batches"` // Candidate too slow, batch not mirrored
+	LastComparison  *Comparison `json:"last_comparison,omitempty"`
+	Divergent       bool        `json:"divergent"` // Last comparison above Config.Divergence
+}
+
+// Comparison is the result of counting one table in both backends
+type Comparison struct {
+	Start            time.Time `json:"start"`
+	End              time.Time `json:"end"`
+	PrimaryRows      int64     `json:"primary_rows"`
+	CandidateRows    int64     `json:"candidate_rows"`
+	Divergence       float64   `json:"divergence"` // |primary - candidate| / primary
+	PrimaryQueryMS   float64   `json:"primary_query_ms"`
+	CandidateQueryMS float64   `json:"candidate_query_ms"`
+	Error            string    `json:"error,omitempty"`
+}
+
+// NewWriter creates a shadow writer. Start must be called before batches
+// are mirrored.
+func NewWriter(config Config, primary Primary, candidate Candidate) *Writer {
+	if config.Workers <= 0 {
+		config.Workers = 2
+	}
+	if config.QueueSize <= 0 {
+		config.QueueSize = 100
+	}
+	if config.Lag <= 0 {
+		config.Lag = 2 * time.Minute
+	}
+	if config.Window <= 0 {
+		config.Window = 10 * time.Minute
+	}
+
+	w := &Writer{
+		primary:     primary,
+		candidate:   candidate,
+		config:      config,
+		queue:       make(chan shadowBatch, config.QueueSize),
+		stats:       make(map[string]*tableStats, len(Tables)),
+		comparisons: make(map[string]Comparison),
+	}
+	for _, table := range Tables {
+		w.stats[table] = &tableStats{}
+	}
+	return w
+}
+
+// Start runs the candidate writers until ctx is done
+func (w *Writer) Start(ctx context.Context) {
+	for i := 0; i < w.config.Workers; i++ {
+		go w.worker(ctx)
+	}
+	slog.Info("shadow writes started", "workers", w.config.Workers, "queue_size", w.config.QueueSize)
+}
+
+func (w *Writer) worker(ctx context.Context) {
+	for {
+		select {
+		case b := <-w.queue:
+			stats := w.stats[b.table]
+			start := time.Now()
+			err := w.candidate.Insert(ctx, b.table, b.rows)
+			stats.candidateWrites.Add(1)
+			stats.candidateNs.Add(time.Since(start).Nanoseconds())
+			if err != nil {
+				stats.candidateErrors.Add(1)
+				slog.Warn("shadow write failed", "table", b.table, "rows", b.count, "error", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// mirror writes items to the primary and, if that succeeds, queues them for
+// the candidate
+func mirror[T any](ctx context.Context, w *Writer, table string, items []T, write func(context.Context, []T) error) error {
+	stats := w.stats[table]
+	start := time.Now()
+	err := write(ctx, items)
+	stats.primaryNs.Add(time.Since(start).Nanoseconds())
+	if err != nil {
+		stats.primaryErrors.Add(1)
+		return err
+	}
+	stats.batches.Add(1)
+	stats.rows.Add(int64(len(items)))
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, item := range items {
+		if err := enc.Encode(item); err != nil {
+			slog.Error("failed to encode shadow batch", "table", table, "error", err)
+			return nil
+		}
+	}
+
+	select {
+	case w.queue <- shadowBatch{table: table, rows: buf.Bytes(), count: len(items)}:
+	default:
+		stats.dropped.Add(1)
+	}
+	return nil
+}
+
+func (w *Writer) CopyFrontendMetrics(ctx context.Context, events []model.EnrichedEvent) error {
+	return mirror(ctx, w, "frontend_metrics", events, w.primary.CopyFrontendMetrics)
+}
+
+func (w *Writer) InsertFrontendMetrics(ctx context.Context, events []model.EnrichedEvent) error {
+	return mirror(ctx, w, "frontend_metrics", events, w.primary.InsertFrontendMetrics)
+}
+
+func (w *Writer) CopyAPIMetrics(ctx context.Context, metrics []model.APIMetric) error {
+	return mirror(ctx, w, "api_metrics", metrics, w.primary.CopyAPIMetrics)
+}
+
+func (w *Writer) InsertAPIMetrics(ctx context.Context, metrics []model.APIMetric) error {
+	return mirror(ctx, w, "api_metrics", metrics, w.primary.InsertAPIMetrics)
+}
+
+func (w *Writer) CopyPSPMetrics(ctx context.Context, metrics []model.PSPMetric) error {
+	return mirror(ctx, w, "psp_metrics", metrics, w.primary.CopyPSPMetrics)
+}
+
+func (w *Writer) InsertPSPMetrics(ctx context.Context, metrics []model.PSPMetric) error {
+	return mirror(ctx, w, "psp_metrics", metrics, w.primary.InsertPSPMetrics)
+}
+
+func (w *Writer) CopyGameMetrics(ctx context.Context, metrics []model.GameMetric) error {
+	return mirror(ctx, w, "game_metrics", metrics, w.primary.CopyGameMetrics)
+}
+
+func (w *Writer) InsertGameMetrics(ctx context.Context, metrics []model.GameMetric) error {
+	return mirror(ctx, w, "game_metrics", metrics, w.primary.InsertGameMetrics)
+}
+
+func (w *Writer) CopyWebSocketMetrics(ctx context.Context, metrics []model.WebSocketMetric) error {
+	return mirror(ctx, w, "websocket_metrics", metrics, w.primary.CopyWebSocketMetrics)
+}
+
+func (w *Writer) InsertWebSocketMetrics(ctx context.Context, metrics []model.WebSocketMetric) error {
+	return mirror(ctx, w, "websocket_metrics", metrics, w.primary.InsertWebSocketMetrics)
+}
+
+// Compare counts the rows of every table in both backends over the last
+// Window, ending Lag ago, and logs tables whose counts diverge. It is run
+// as a scheduled job.
+func (w *Writer) Compare(ctx context.Context) error {
+	end := time.Now().Add(-w.config.Lag).Truncate(time.Second)
+	start := end.Add(-w.config.Window)
+
+	failed := 0
+	for _, table := range Tables {
+		c := Comparison{Start: start, End: end}
+
+		queryStart := time.Now()
+		primaryRows, err := w.primary.CountRows(ctx, table, start, end)
+		c.PrimaryQueryMS = msSince(queryStart)
+		if err == nil {
+			queryStart = time.Now()
+			var candidateRows int64
+			candidateRows, err = w.candidate.Count(ctx, table, start, end)
+			c.CandidateQueryMS = msSince(queryStart)
+			c.PrimaryRows, c.CandidateRows = primaryRows, candidateRows
+		}
+
+		if err != nil {
+			failed++
+			c.Error = err.Error()
+			slog.Warn("shadow comparison failed", "table", table, "error", err)
+		} else {
+			c.Divergence = divergence(c.PrimaryRows, c.CandidateRows)
+			if c.Divergence > w.config.Divergence {
+				slog.Warn("shadow storage diverges",
+					"table", table,
+					"primary_rows", c.PrimaryRows,
+					"candidate_rows", c.CandidateRows,
+					"divergence", c.Divergence,
+				)
+			}
+		}
+
+		w.mu.Lock()
+		w.comparisons[table] = c
+		w.mu.Unlock()
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("shadow comparison failed for %d of %d tables", failed, len(Tables))
+	}
+	return nil
+}
+
+// Stats returns write and comparison statistics per table
+func (w *Writer) Stats() []TableStats {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	result := make([]TableStats, 0, len(Tables))
+	for _, table := range Tables {
+		s := w.stats[table]
+		ts := TableStats{
+			Table:           table,
+			Batches:         s.batches.Load(),
+			Rows:            s.rows.Load(),
+			PrimaryErrors:   s.primaryErrors.Load(),
+			CandidateErrors: s.candidateErrors.Load(),
+			DroppedBatches:  s.dropped.Load(),
+		}
+		if n := ts.Batches + ts.PrimaryErrors; n > 0 {
+			ts.PrimaryAvgMS = float64(s.primaryNs.Load()) / float64(n) / 1e6
+		}
+		if n := s.candidateWrites.Load(); n > 0 {
+			ts.CandidateAvgMS = float64(s.candidateNs.Load()) / float64(n) / 1e6
+		}
+		if c, ok := w.comparisons[table]; ok {
+			ts.LastComparison = &c
+			ts.Divergent = c.Error == "" && c.Divergence > w.config.Divergence
+		}
+		result = append(result, ts)
+	}
+	return result
+}
+
+func divergence(primary, candidate int64) float64 {
+	if primary == candidate {
+		return 0
+	}
+	return math.Abs(float64(primary-candidate)) / math.Max(float64(primary), 1)
+}
+
+func msSince(t time.Time) float64 {
+	return float64(time.Since(t).Microseconds()) / 1000
+}
@@ -0,0 +1,159 @@
+package slo
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/mcbile/product-pulse/internal/storage"
+)
+
+// AlertType used for SLO burn rate alerts in alert_events
+const AlertType = "slo_burn"
+
+// Storage is the subset of storage used by the SLO evaluator
+type Storage interface {
+	GetSLOs(ctx context.Context) ([]storage.SLO, error)
+	GetSLICounts(ctx context.Context, sli, target string, start, end time.Time) (storage.SLICounts, error)
+	UpdateSLOStatus(ctx context.Context, name string, at time.Time, window, burn storage.SLICounts) error
+	InsertAlert(ctx context.Context, alert storage.AlertRow) error
+	HasOpenAlert(ctx context.Context, alertType, metricName string) (bool, error)
+	ResolveAlerts(ctx context.Context, alertType, metricName string) error
+}
+
+// Config for the SLO evaluator
+type Config struct {
+	BurnWindow time.Duration // Recent period the burn rate is computed over
+	BurnAlert  float64       // Burn rate that raises an alert, 0 disables alerts
+	MinEvents  int64         // Events the burn window needs before alerting
+}
+
+// Status is an objective with its attainment, error budget and burn rate
+type Status struct {
+	storage.SLO
+	Attainment *float64 `json:"attainment"` // Good events over the window (%), nil without events
+
+	// ErrorBudgetRemaining is the share of the window's error budget left
+	// (%); negative once the objective is missed
+	ErrorBudgetRemaining *float64 `json:"error_budget_remaining"`
+
+	// BurnRate is how fast the budget is spent over the burn window: 1
+	// spends exactly the budget over the objective's window
+	BurnRate *float64 `json:"burn_rate"`
+}
+
+// Summarize derives attainment, remaining error budget and burn rate from
+// the counts of an objective's last evaluation
+func Summarize(s storage.SLO) Status {
+	st := Status{SLO: s}
+	budget := 1 - s.Objective/100
+	if s.Window.Total > 0 {
+		bad := float64(s.Window.Total-s.Window.Good) / float64(s.Window.Total)
+		attainment := 100 * (1 - bad)
+		remaining := 100 * (1 - bad/budget)
+		st.Attainment, st.ErrorBudgetRemaining = &attainment, &remaining
+	}
+	if s.Burn.Total > 0 {
+		bad := float64(s.Burn.Total-s.Burn.Good) / float64(s.Burn.Total)
+		burn := bad / budget
+		st.BurnRate = &burn
+	}
+	return st
+}
+
+// Evaluator updates the counts of all objectives and maintains burn rate
+// alerts
+type Evaluator struct {
+	config  Config
+	storage Storage
+}
+
+// NewEvaluator creates a new SLO evaluator
+func NewEvaluator(config Config, storage Storage) *Evaluator {
+	if config.BurnWindow <= 0 {
+		config.BurnWindow = time.Hour
+	}
+	return &Evaluator{config: config, storage: storage}
+}
+
+// Evaluate counts good and total events of every objective over its window
+// and over the burn window. Objectives that fail are logged and skipped, so
+// one bad definition does not stall the others.
+func (e *Evaluator) Evaluate(ctx context.Context) error {
+	slos, err := e.storage.GetSLOs(ctx)
+	if err != nil {
+		return err
+	}
+
+	var failed int
+	for _, s := range slos {
+		if err := e.evaluate(ctx, s); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			slog.Error("failed to evaluate slo", "slo", s.Name, "error", err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d slos failed", failed, len(slos))
+	}
+	return nil
+}
+
+func (e *Evaluator) evaluate(ctx context.Context, s storage.SLO) error {
+	now := time.Now().UTC()
+	window, err := e.storage.GetSLICounts(ctx, s.SLI, s.Target, now.AddDate(0, 0, -s.WindowDays), now)
+	if err != nil {
+		return err
+	}
+	burn, err := e.storage.GetSLICounts(ctx, s.SLI, s.Target, now.Add(-e.config.BurnWindow), now)
+	if err != nil {
+		return err
+	}
+	if err := e.storage.UpdateSLOStatus(ctx, s.Name, now, window, burn); err != nil {
+		return err
+	}
+
+	if e.config.BurnAlert <= 0 {
+		return nil
+	}
+	s.Window, s.Burn = window, burn
+	return e.alert(ctx, Summarize(s))
+}
+
+// alert fires while the burn rate is at or above the alert threshold and
+// resolves once it drops below
+func (e *Evaluator) alert(ctx context.Context, st Status) error {
+	metricName := "slo:" + st.Name
+	open, err := e.storage.HasOpenAlert(ctx, AlertType, metricName)
+	if err != nil {
+		return err
+	}
+
+	breached := st.BurnRate != nil && *st.BurnRate >= e.config.BurnAlert &&
+		st.Burn.Total >= e.config.MinEvents
+	switch {
+	case breached && !open:
+		slog.Warn("slo burning error budget",
+			"slo", st.Name,
+			"burn_rate", *st.BurnRate,
+			"threshold", e.config.BurnAlert,
+		)
+		return e.storage.InsertAlert(ctx, storage.AlertRow{
+			Time:           time.Now().UTC(),
+			AlertType:      AlertType,
+			Severity:       "critical",
+			SourceTable:    "slo_objectives",
+			MetricName:     metricName,
+			ThresholdValue: e.config.BurnAlert,
+			ActualValue:    *st.BurnRate,
+			Message: fmt.Sprintf("SLO %s burning its error budget %.1fx too fast over the last %s (%d of %d events bad)",
+				st.Name, *st.BurnRate, e.config.BurnWindow, st.Burn.Total-st.Burn.Good, st.Burn.Total),
+		})
+	case !breached && open:
+		return e.storage.ResolveAlerts(ctx, AlertType, metricName)
+	}
+	return nil
+}
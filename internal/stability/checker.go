@@ -0,0 +1,180 @@
+package stability
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mcbile/product-pulse/internal/storage"
+	"github.com/mcbile/product-pulse/pkg/clock"
+)
+
+// AlertType used for release-health alerts in alert_events
+const AlertType = "release_health"
+
+// Storage is the subset of storage used by the release-health checker
+type Storage interface {
+	GetStability(ctx context.Context, start time.Time, release, deviceType, country string, sites []string) ([]storage.StabilityRow, error)
+	InsertAlert(ctx context.Context, alert storage.AlertRow) error
+	GetAlerts(ctx context.Context, f storage.AlertFilter, state string, limit int) ([]storage.AlertRow, error)
+	ResolveAlerts(ctx context.Context, alertType, metricName string) error
+}
+
+// Rule fires when crash-free sessions for a release/platform drop below
+// MinCrashFreePct. Release "*" matches every release, an empty Platform
+// matches every platform.
+type Rule struct {
+	Release         string
+	Platform        string
+	MinCrashFreePct float64
+}
+
+// ParseRules parses entries in the form release[/platform]<min_pct,
+// e.g. "2.4.0/ios<99" or "*<98.5"
+func ParseRules(entries []string) ([]Rule, error) {
+	rules := make([]Rule, 0, len(entries))
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		target, threshold, ok := strings.Cut(entry, "<")
+		if !ok {
+			return nil, fmt.Errorf("invalid stability rule %q, expected release[/platform]<pct", entry)
+		}
+
+		pct, err := strconv.ParseFloat(strings.TrimSpace(threshold), 64)
+		if err != nil || pct <= 0 || pct > 100 {
+			return nil, fmt.Errorf("invalid threshold in stability rule %q", entry)
+		}
+
+		release, platform, _ := strings.Cut(strings.TrimSpace(target), "/")
+		if release == "" {
+			release = "*"
+		}
+		rules = append(rules, Rule{
+			Release:         release,
+			Platform:        platform,
+			MinCrashFreePct: pct,
+		})
+	}
+	return rules, nil
+}
+
+func (r Rule) matches(row storage.StabilityRow) bool {
+	if r.Release != "*" && r.Release != row.Release {
+		return false
+	}
+	return r.Platform == "" || r.Platform == row.Platform
+}
+
+// String returns the rule in the form ParseRules accepts
+func (r Rule) String() string {
+	target := r.Release
+	if r.Platform != "" {
+		target += "/" + r.Platform
+	}
+	return target + "<" + strconv.FormatFloat(r.MinCrashFreePct, 'f', -1, 64)
+}
+
+// MetricName is the alert metric name of a rule breached by a release and
+// platform. Rules matching the same release keep their own alerts.
+func MetricName(rule Rule, release, platform string) string {
+	return fmt.Sprintf("crash_free_sessions:%s:%s/%s", rule, release, platform)
+}
+
+// Config for the release-health checker
+type Config struct {
+	Rules       []Rule
+	Window      time.Duration // Lookback for crash-free rates
+	MinSessions int64         // Ignore releases with too little traffic
+	Clock       clock.Clock   // nil uses the system clock
+}
+
+// Checker evaluates release-health rules and maintains alert_events
+type Checker struct {
+	config  Config
+	storage Storage
+}
+
+// NewChecker creates a new release-health checker
+func NewChecker(config Config, storage Storage) *Checker {
+	if config.Window <= 0 {
+		config.Window = time.Hour
+	}
+	config.Clock = clock.OrReal(config.Clock)
+	return &Checker{config: config, storage: storage}
+}
+
+// Evaluate checks all rules once, firing alerts for breached ones and
+// resolving the others, including those of releases that no longer have
+// MinSessions sessions within the window
+func (c *Checker) Evaluate(ctx context.Context) error {
+	now := c.config.Clock.Now().UTC()
+	rows, err := c.storage.GetStability(ctx, now.Add(-c.config.Window), "", "", "", nil)
+	if err != nil {
+		return err
+	}
+
+	open, err := c.storage.GetAlerts(ctx, storage.AlertFilter{AlertType: AlertType}, storage.AlertStateOpen, 1000)
+	if err != nil {
+		return err
+	}
+	isOpen := make(map[string]bool, len(open))
+	for _, a := range open {
+		isOpen[a.MetricName] = true
+	}
+
+	breached := make(map[string]bool)
+	for _, rule := range c.config.Rules {
+		for _, row := range rows {
+			if !rule.matches(row) || row.Sessions < c.config.MinSessions || row.CrashFreeSessionsPct >= rule.MinCrashFreePct {
+				continue
+			}
+
+			metricName := MetricName(rule, row.Release, row.Platform)
+			breached[metricName] = true
+			if isOpen[metricName] {
+				continue
+			}
+
+			slog.Warn("release health degraded",
+				"release", row.Release,
+				"platform", row.Platform,
+				"rule", rule.String(),
+				"crash_free_sessions_pct", row.CrashFreeSessionsPct,
+				"threshold", rule.MinCrashFreePct,
+			)
+			err := c.storage.InsertAlert(ctx, storage.AlertRow{
+				Time:           now,
+				AlertType:      AlertType,
+				Severity:       "critical",
+				SourceTable:    "frontend_metrics",
+				MetricName:     metricName,
+				ThresholdValue: rule.MinCrashFreePct,
+				ActualValue:    row.CrashFreeSessionsPct,
+				Message: fmt.Sprintf("Crash-free sessions for %s (%s) at %.2f%%, below %.2f%% (%d of %d sessions crashed)",
+					row.Release, row.Platform, row.CrashFreeSessionsPct, rule.MinCrashFreePct,
+					row.CrashedSessions, row.Sessions),
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	for metricName := range isOpen {
+		if breached[metricName] {
+			continue
+		}
+		if err := c.storage.ResolveAlerts(ctx, AlertType, metricName); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
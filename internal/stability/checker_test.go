@@ -0,0 +1,119 @@
+package stability
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/mcbile/product-pulse/internal/storage"
+	"github.com/mcbile/product-pulse/pkg/clock"
+)
+
+// memoryStorage serves fixed stability rows and keeps alerts in memory
+type memoryStorage struct {
+	rows   []storage.StabilityRow
+	start  time.Time
+	alerts []storage.AlertRow
+}
+
+func (m *memoryStorage) GetStability(ctx context.Context, start time.Time, release, deviceType, country string, sites []string) ([]storage.StabilityRow, error) {
+	m.start = start
+	return m.rows, nil
+}
+
+func (m *memoryStorage) GetAlerts(ctx context.Context, f storage.AlertFilter, state string, limit int) ([]storage.AlertRow, error) {
+	var open []storage.AlertRow
+	for _, a := range m.alerts {
+		if a.AlertType == f.AlertType && a.ResolvedAt == nil {
+			open = append(open, a)
+		}
+	}
+	return open, nil
+}
+
+func (m *memoryStorage) InsertAlert(ctx context.Context, alert storage.AlertRow) error {
+	m.alerts = append(m.alerts, alert)
+	return nil
+}
+
+func (m *memoryStorage) ResolveAlerts(ctx context.Context, alertType, metricName string) error {
+	now := time.Now()
+	for i := range m.alerts {
+		if m.alerts[i].AlertType == alertType && m.alerts[i].MetricName == metricName && m.alerts[i].ResolvedAt == nil {
+			m.alerts[i].ResolvedAt = &now
+		}
+	}
+	return nil
+}
+
+func (m *memoryStorage) open() map[string]bool {
+	open := make(map[string]bool)
+	for _, a := range m.alerts {
+		if a.ResolvedAt == nil {
+			open[a.MetricName] = true
+		}
+	}
+	return open
+}
+
+func TestEvaluateKeepsAlertsPerRule(t *testing.T) {
+	rules, err := ParseRules([]string{"*<98", "2.4.0/ios<99.5"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := &memoryStorage{rows: []storage.StabilityRow{
+		{Release: "2.4.0", Platform: "ios", Sessions: 1000, CrashFreeSessionsPct: 99},
+	}}
+	fake := clock.NewFake(time.Date(2026, 3, 2, 12, 0, 0, 0, time.UTC))
+	c := NewChecker(Config{Rules: rules, Window: time.Hour, MinSessions: 100, Clock: fake}, store)
+
+	// Only the stricter rule is breached; evaluating again must not flap it
+	for i := 0; i < 2; i++ {
+		if err := c.Evaluate(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := MetricName(rules[1], "2.4.0", "ios")
+	if open := store.open(); len(open) != 1 || !open[want] || len(store.alerts) != 1 {
+		t.Fatalf("alerts %+v, want one open %s", store.alerts, want)
+	}
+	if !store.start.Equal(fake.Now().Add(-time.Hour)) || !store.alerts[0].Time.Equal(fake.Now()) {
+		t.Errorf("window from %s, alert at %s, want the injected clock", store.start, store.alerts[0].Time)
+	}
+
+	// Both rules are breached
+	store.rows[0].CrashFreeSessionsPct = 97
+	if err := c.Evaluate(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if open := store.open(); len(open) != 2 || len(store.alerts) != 2 {
+		t.Fatalf("alerts %+v, want one per rule", store.alerts)
+	}
+}
+
+func TestEvaluateResolvesAlertsWithoutTraffic(t *testing.T) {
+	rules, err := ParseRules([]string{"*<99"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := &memoryStorage{rows: []storage.StabilityRow{
+		{Release: "2.4.0", Platform: "ios", Sessions: 1000, CrashFreeSessionsPct: 95},
+		{Release: "2.5.0", Platform: "ios", Sessions: 1000, CrashFreeSessionsPct: 95},
+	}}
+	c := NewChecker(Config{Rules: rules, MinSessions: 100}, store)
+	if err := c.Evaluate(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(store.open()) != 2 {
+		t.Fatalf("alerts %+v, want two open", store.alerts)
+	}
+
+	// 2.4.0 drops out of the window, 2.5.0 below MinSessions
+	store.rows = []storage.StabilityRow{{Release: "2.5.0", Platform: "ios", Sessions: 10, CrashFreeSessionsPct: 90}}
+	if err := c.Evaluate(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if open := store.open(); len(open) != 0 {
+		t.Errorf("alerts %v still open", open)
+	}
+}
@@ -0,0 +1,95 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"net"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// Classes of database errors, matched with errors.Is. Write methods wrap
+// Postgres errors with their class so callers can decide whether a retry
+// may succeed without inspecting SQLSTATE codes or messages.
+var (
+	// ErrConflict is a unique or exclusion constraint violation
+	ErrConflict = errors.New("conflict")
+
+	// ErrValidation is data the schema rejects: other constraint
+	// violations, invalid values, out of range numbers. Retrying the same
+	// rows fails again.
+	ErrValidation = errors.New("invalid data")
+
+	// ErrRetryable is a transient failure: lost connections, failovers,
+	// deadlocks, serialization failures, timeouts, too many connections
+	ErrRetryable = errors.New("temporary database failure")
+)
+
+// classifiedError is a database error together with its class
+type classifiedError struct {
+	err   error
+	class error
+}
+
+func (e *classifiedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *classifiedError) Unwrap() []error {
+	return []error{e.err, e.class}
+}
+
+// classify wraps err with its class. Errors of no known class (and nil) are
+// returned as they are.
+func classify(err error) error {
+	if err == nil {
+		return nil
+	}
+	if class := errorClass(err); class != nil {
+		return &classifiedError{err: err, class: class}
+	}
+	return err
+}
+
+// errorClass returns the class of err, or nil
+func errorClass(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch {
+		case pgErr.Code == "23505" || pgErr.Code == "23P01":
+			return ErrConflict
+		case pgErr.Code == "40001" || pgErr.Code == "40P01":
+			return ErrRetryable
+		}
+		switch pgErr.Code[:2] {
+		case "22", "23":
+			// Data exception, integrity constraint violation
+			return ErrValidation
+		case "08", "53", "57":
+			// Connection exception, insufficient resources, operator
+			// intervention (admin shutdown, cannot connect now)
+			return ErrRetryable
+		}
+		return nil
+	}
+
+	var netErr net.Error
+	switch {
+	case errors.Is(err, context.Canceled):
+		return nil
+	case pgconn.SafeToRetry(err), pgconn.Timeout(err), errors.As(err, &netErr):
+		return ErrRetryable
+	}
+	return nil
+}
+
+// IsTimeout reports whether a query failed because it ran out of time: its
+// context deadline passed or Postgres cancelled it (statement_timeout,
+// lock_timeout, or a cancel request)
+func IsTimeout(err error) bool {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.Code == "57014" || pgErr.Code == "55P03"
+	}
+	return errors.Is(err, context.DeadlineExceeded) || pgconn.Timeout(err)
+}
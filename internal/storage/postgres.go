@@ -2,17 +2,33 @@ package storage
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"log/slog"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/mcbile/product-pulse/internal/model"
+	"github.com/mcbile/product-pulse/internal/trace"
 )
 
 type Postgres struct {
 	pool *pgxpool.Pool
+
+	// COPY partitioning: rows are split by copyPartition (the hypertable
+	// chunk interval) and each partition is copied on its own connection,
+	// at most copyParallelism at a time
+	copyPartition   time.Duration
+	copyParallelism int
 }
 
 func NewPostgres(databaseURL string) (*Postgres, error) {
@@ -41,7 +57,24 @@ func NewPostgres(databaseURL string) (*Postgres, error) {
 		return nil, fmt.Errorf("ping: %w", err)
 	}
 
-	return &Postgres{pool: pool}, nil
+	return &Postgres{
+		pool:            pool,
+		copyPartition:   24 * time.Hour,
+		copyParallelism: 4,
+	}, nil
+}
+
+// ConfigureCopy sets the partition interval and parallelism used by
+// CopyFrontendMetrics. The interval should match the hypertable
+// chunk_time_interval; parallelism <= 1 copies partitions sequentially.
+func (p *Postgres) ConfigureCopy(partition time.Duration, parallelism int) {
+	if partition > 0 {
+		p.copyPartition = partition
+	}
+	if parallelism < 1 {
+		parallelism = 1
+	}
+	p.copyParallelism = parallelism
 }
 
 func (p *Postgres) Close() {
@@ -61,8 +94,9 @@ func (p *Postgres) InsertFrontendMetrics(ctx context.Context, events []model.Enr
 	// Build batch insert
 	columns := []string{
 		"time", "session_id", "player_id", "device_type", "browser", "country",
-		"event_type", "page_path", "lcp_ms", "fid_ms", "cls", "ttfb_ms", "fcp_ms", "inp_ms",
-		"metric_name", "metric_value", "metadata",
+		"event_type", "page_path", "release", "platform",
+		"lcp_ms", "fid_ms", "cls", "ttfb_ms", "fcp_ms", "inp_ms",
+		"metric_name", "metric_value", "metadata", "event_id", "campaign", "site_id",
 	}
 
 	valueStrings := make([]string, 0, len(events))
@@ -78,8 +112,9 @@ func (p *Postgres) InsertFrontendMetrics(ctx context.Context, events []model.Enr
 
 		valueArgs = append(valueArgs,
 			e.Time, e.SessionID, e.PlayerID, e.DeviceType, e.Browser, e.Country,
-			e.EventType, e.PagePath, e.LCP, e.FID, e.CLS, e.TTFB, e.FCP, e.INP,
-			e.MetricName, e.MetricValue, e.Metadata,
+			e.EventType, e.PagePath, e.Release, e.Platform,
+			e.LCP, e.FID, e.CLS, e.TTFB, e.FCP, e.INP,
+			e.MetricName, e.MetricValue, e.Metadata, e.EventID, model.NormalizeCampaign(e.Campaign), nullableSite(e.SiteID),
 		)
 	}
 
@@ -90,7 +125,7 @@ func (p *Postgres) InsertFrontendMetrics(ctx context.Context, events []model.Enr
 	)
 
 	_, err := p.pool.Exec(ctx, query, valueArgs...)
-	return err
+	return classify(err)
 }
 
 // InsertAPIMetrics batch inserts API metrics
@@ -102,7 +137,7 @@ func (p *Postgres) InsertAPIMetrics(ctx context.Context, metrics []model.APIMetr
 	columns := []string{
 		"time", "service_name", "endpoint", "method", "duration_ms", "status_code",
 		"player_id", "request_id", "error_type", "error_message",
-		"request_size", "response_size", "metadata",
+		"request_size", "response_size", "metadata", "site_id",
 	}
 
 	valueStrings := make([]string, 0, len(metrics))
@@ -119,7 +154,7 @@ func (p *Postgres) InsertAPIMetrics(ctx context.Context, metrics []model.APIMetr
 		valueArgs = append(valueArgs,
 			m.Time, m.ServiceName, m.Endpoint, m.Method, m.DurationMS, m.StatusCode,
 			m.PlayerID, m.RequestID, m.ErrorType, m.ErrorMessage,
-			m.RequestSize, m.ResponseSize, m.Metadata,
+			m.RequestSize, m.ResponseSize, m.Metadata, nullableSite(m.SiteID),
 		)
 	}
 
@@ -130,7 +165,7 @@ func (p *Postgres) InsertAPIMetrics(ctx context.Context, metrics []model.APIMetr
 	)
 
 	_, err := p.pool.Exec(ctx, query, valueArgs...)
-	return err
+	return classify(err)
 }
 
 // InsertPSPMetrics batch inserts PSP metrics
@@ -142,7 +177,7 @@ func (p *Postgres) InsertPSPMetrics(ctx context.Context, metrics []model.PSPMetr
 	columns := []string{
 		"time", "psp_name", "operation", "duration_ms", "success",
 		"player_id", "transaction_id", "amount", "currency",
-		"error_code", "error_message", "psp_response_code", "metadata",
+		"error_code", "error_message", "psp_response_code", "state", "campaign", "metadata", "site_id",
 	}
 
 	valueStrings := make([]string, 0, len(metrics))
@@ -159,7 +194,7 @@ func (p *Postgres) InsertPSPMetrics(ctx context.Context, metrics []model.PSPMetr
 		valueArgs = append(valueArgs,
 			m.Time, m.PSPName, m.Operation, m.DurationMS, m.Success,
 			m.PlayerID, m.TransactionID, m.Amount, m.Currency,
-			m.ErrorCode, m.ErrorMessage, m.PSPResponseCode, m.Metadata,
+			m.ErrorCode, m.ErrorMessage, m.PSPResponseCode, m.State, model.NormalizeCampaign(m.Campaign), m.Metadata, nullableSite(m.SiteID),
 		)
 	}
 
@@ -170,7 +205,7 @@ func (p *Postgres) InsertPSPMetrics(ctx context.Context, metrics []model.PSPMetr
 	)
 
 	_, err := p.pool.Exec(ctx, query, valueArgs...)
-	return err
+	return classify(err)
 }
 
 // InsertGameMetrics batch inserts game provider metrics
@@ -181,7 +216,7 @@ func (p *Postgres) InsertGameMetrics(ctx context.Context, metrics []model.GameMe
 
 	columns := []string{
 		"time", "provider", "game_id", "game_type", "load_time_ms", "launch_success",
-		"player_id", "session_id", "device_type", "error_type", "error_message", "metadata",
+		"player_id", "session_id", "device_type", "error_type", "error_message", "metadata", "site_id",
 	}
 
 	valueStrings := make([]string, 0, len(metrics))
@@ -197,7 +232,7 @@ func (p *Postgres) InsertGameMetrics(ctx context.Context, metrics []model.GameMe
 
 		valueArgs = append(valueArgs,
 			m.Time, m.Provider, m.GameID, m.GameType, m.LoadTimeMS, m.LaunchSuccess,
-			m.PlayerID, m.SessionID, m.DeviceType, m.ErrorType, m.ErrorMessage, m.Metadata,
+			m.PlayerID, m.SessionID, m.DeviceType, m.ErrorType, m.ErrorMessage, m.Metadata, nullableSite(m.SiteID),
 		)
 	}
 
@@ -208,7 +243,7 @@ func (p *Postgres) InsertGameMetrics(ctx context.Context, metrics []model.GameMe
 	)
 
 	_, err := p.pool.Exec(ctx, query, valueArgs...)
-	return err
+	return classify(err)
 }
 
 // InsertWebSocketMetrics batch inserts WebSocket metrics
@@ -220,7 +255,7 @@ func (p *Postgres) InsertWebSocketMetrics(ctx context.Context, metrics []model.W
 	columns := []string{
 		"time", "connection_id", "player_id", "event_type", "latency_ms",
 		"messages_sent", "messages_received", "close_code", "close_reason",
-		"endpoint", "device_type", "metadata",
+		"endpoint", "device_type", "metadata", "site_id",
 	}
 
 	valueStrings := make([]string, 0, len(metrics))
@@ -237,7 +272,7 @@ func (p *Postgres) InsertWebSocketMetrics(ctx context.Context, metrics []model.W
 		valueArgs = append(valueArgs,
 			m.Time, m.ConnectionID, m.PlayerID, m.EventType, m.LatencyMS,
 			m.MessagesSent, m.MessagesReceived, m.CloseCode, m.CloseReason,
-			m.Endpoint, m.DeviceType, m.Metadata,
+			m.Endpoint, m.DeviceType, m.Metadata, nullableSite(m.SiteID),
 		)
 	}
 
@@ -248,44 +283,343 @@ func (p *Postgres) InsertWebSocketMetrics(ctx context.Context, metrics []model.W
 	)
 
 	_, err := p.pool.Exec(ctx, query, valueArgs...)
-	return err
+	return classify(err)
+}
+
+// InsertCSPReports batch inserts CSP violation reports
+func (p *Postgres) InsertCSPReports(ctx context.Context, reports []model.CSPReport) error {
+	if len(reports) == 0 {
+		return nil
+	}
+
+	columns := []string{
+		"time", "document_uri", "referrer", "violated_directive", "effective_directive",
+		"original_policy", "disposition", "blocked_uri", "status_code",
+		"source_file", "line_number", "column_number", "script_sample", "user_agent",
+	}
+
+	valueStrings := make([]string, 0, len(reports))
+	valueArgs := make([]interface{}, 0, len(reports)*len(columns))
+
+	for i, r := range reports {
+		base := i * len(columns)
+		placeholders := make([]string, len(columns))
+		for j := range columns {
+			placeholders[j] = fmt.Sprintf("$%d", base+j+1)
+		}
+		valueStrings = append(valueStrings, "("+strings.Join(placeholders, ", ")+")")
+
+		valueArgs = append(valueArgs,
+			r.Time, r.DocumentURI, r.Referrer, r.ViolatedDirective, r.EffectiveDirective,
+			r.OriginalPolicy, r.Disposition, r.BlockedURI, r.StatusCode,
+			r.SourceFile, r.LineNumber, r.ColumnNumber, r.ScriptSample, r.UserAgent,
+		)
+	}
+
+	query := fmt.Sprintf(
+		"INSERT INTO csp_reports (%s) VALUES %s",
+		strings.Join(columns, ", "),
+		strings.Join(valueStrings, ", "),
+	)
+
+	_, err := p.pool.Exec(ctx, query, valueArgs...)
+	return classify(err)
+}
+
+// PartialCopyError is returned by the Copy* methods when some partitions
+// were written and others failed. Failed holds only the rows that were not
+// written, so callers can retry them without duplicating the rest.
+type PartialCopyError[T any] struct {
+	Failed []T
+	Err    error
+}
+
+func (e *PartialCopyError[T]) Error() string {
+	return fmt.Sprintf("copy failed for %d rows: %v", len(e.Failed), e.Err)
 }
 
-// CopyFrontendMetrics uses COPY for maximum throughput
+func (e *PartialCopyError[T]) Unwrap() error {
+	return e.Err
+}
+
+// CopyFrontendMetrics uses COPY for maximum throughput. Rows spanning
+// several chunks are routed to one COPY per chunk, executed in parallel,
+// which is considerably faster than a single COPY crossing chunks.
 func (p *Postgres) CopyFrontendMetrics(ctx context.Context, events []model.EnrichedEvent) error {
-	if len(events) == 0 {
+	return copyPartitioned(ctx, p, events,
+		func(e model.EnrichedEvent) time.Time { return e.Time },
+		p.copyFrontendRows,
+	)
+}
+
+// copyPartitioned splits rows by hypertable chunk and copies each chunk with
+// copyRows, at most p.copyParallelism at a time
+func copyPartitioned[T any](ctx context.Context, p *Postgres, rows []T, timeOf func(T) time.Time, copyRows func(context.Context, []T) error) error {
+	if len(rows) == 0 {
+		return nil
+	}
+
+	partitions := partitionRows(rows, timeOf, p.copyPartition)
+	if len(partitions) == 1 {
+		return copyRows(ctx, rows)
+	}
+
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		failed []T
+		errs   []error
+	)
+	sem := make(chan struct{}, p.copyParallelism)
+
+	for _, part := range partitions {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(part []T) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			if err := copyRows(ctx, part); err != nil {
+				mu.Lock()
+				failed = append(failed, part...)
+				errs = append(errs, err)
+				mu.Unlock()
+			}
+		}(part)
+	}
+	wg.Wait()
+
+	switch {
+	case len(errs) == 0:
 		return nil
+	case len(failed) == len(rows):
+		return errors.Join(errs...)
+	default:
+		return &PartialCopyError[T]{Failed: failed, Err: errors.Join(errs...)}
 	}
+}
+
+// partitionRows groups rows by hypertable chunk, preserving order within
+// each chunk
+func partitionRows[T any](rows []T, timeOf func(T) time.Time, interval time.Duration) [][]T {
+	index := make(map[int64]int)
+	var partitions [][]T
+	for _, r := range rows {
+		key := timeOf(r).UnixNano() / int64(interval)
+		i, ok := index[key]
+		if !ok {
+			i = len(partitions)
+			index[key] = i
+			partitions = append(partitions, nil)
+		}
+		partitions[i] = append(partitions[i], r)
+	}
+	return partitions
+}
 
+func (p *Postgres) copyFrontendRows(ctx context.Context, events []model.EnrichedEvent) error {
 	columns := []string{
 		"time", "session_id", "player_id", "device_type", "browser", "country",
-		"event_type", "page_path", "lcp_ms", "fid_ms", "cls", "ttfb_ms", "fcp_ms", "inp_ms",
-		"metric_name", "metric_value", "metadata",
+		"event_type", "page_path", "release", "platform",
+		"lcp_ms", "fid_ms", "cls", "ttfb_ms", "fcp_ms", "inp_ms",
+		"metric_name", "metric_value", "metadata", "event_id", "campaign", "site_id",
 	}
 
 	rows := make([][]interface{}, len(events))
 	for i, e := range events {
 		rows[i] = []interface{}{
 			e.Time, e.SessionID, e.PlayerID, e.DeviceType, e.Browser, e.Country,
-			e.EventType, e.PagePath, e.LCP, e.FID, e.CLS, e.TTFB, e.FCP, e.INP,
-			e.MetricName, e.MetricValue, e.Metadata,
+			e.EventType, e.PagePath, e.Release, e.Platform,
+			e.LCP, e.FID, e.CLS, e.TTFB, e.FCP, e.INP,
+			e.MetricName, e.MetricValue, e.Metadata, e.EventID, model.NormalizeCampaign(e.Campaign), nullableSite(e.SiteID),
 		}
 	}
 
-	_, err := p.pool.CopyFrom(
-		ctx,
-		pgx.Identifier{"frontend_metrics"},
-		columns,
-		pgx.CopyFromRows(rows),
+	return p.copyRows(ctx, "frontend_metrics", columns, rows)
+}
+
+// CopyAPIMetrics writes API metrics with COPY, one statement per chunk
+func (p *Postgres) CopyAPIMetrics(ctx context.Context, metrics []model.APIMetric) error {
+	return copyPartitioned(ctx, p, metrics,
+		func(m model.APIMetric) time.Time { return m.Time },
+		func(ctx context.Context, metrics []model.APIMetric) error {
+			rows := make([][]interface{}, len(metrics))
+			for i, m := range metrics {
+				rows[i] = []interface{}{
+					m.Time, m.ServiceName, m.Endpoint, m.Method, m.DurationMS, m.StatusCode,
+					m.PlayerID, m.RequestID, m.ErrorType, m.ErrorMessage,
+					m.RequestSize, m.ResponseSize, m.Metadata, nullableSite(m.SiteID),
+				}
+			}
+			return p.copyRows(ctx, "api_metrics", []string{
+				"time", "service_name", "endpoint", "method", "duration_ms", "status_code",
+				"player_id", "request_id", "error_type", "error_message",
+				"request_size", "response_size", "metadata", "site_id",
+			}, rows)
+		},
+	)
+}
+
+// CopyPSPMetrics writes PSP metrics with COPY, one statement per chunk
+func (p *Postgres) CopyPSPMetrics(ctx context.Context, metrics []model.PSPMetric) error {
+	return copyPartitioned(ctx, p, metrics,
+		func(m model.PSPMetric) time.Time { return m.Time },
+		func(ctx context.Context, metrics []model.PSPMetric) error {
+			rows := make([][]interface{}, len(metrics))
+			for i, m := range metrics {
+				rows[i] = []interface{}{
+					m.Time, m.PSPName, m.Operation, m.DurationMS, m.Success,
+					m.PlayerID, m.TransactionID, m.Amount, m.Currency,
+					m.ErrorCode, m.ErrorMessage, m.PSPResponseCode, m.State, model.NormalizeCampaign(m.Campaign), m.Metadata, nullableSite(m.SiteID),
+				}
+			}
+			return p.copyRows(ctx, "psp_metrics", []string{
+				"time", "psp_name", "operation", "duration_ms", "success",
+				"player_id", "transaction_id", "amount", "currency",
+				"error_code", "error_message", "psp_response_code", "state", "campaign", "metadata", "site_id",
+			}, rows)
+		},
 	)
+}
 
-	return err
+// CopyGameMetrics writes game metrics with COPY, one statement per chunk
+func (p *Postgres) CopyGameMetrics(ctx context.Context, metrics []model.GameMetric) error {
+	return copyPartitioned(ctx, p, metrics,
+		func(m model.GameMetric) time.Time { return m.Time },
+		func(ctx context.Context, metrics []model.GameMetric) error {
+			rows := make([][]interface{}, len(metrics))
+			for i, m := range metrics {
+				rows[i] = []interface{}{
+					m.Time, m.Provider, m.GameID, m.GameType, m.LoadTimeMS, m.LaunchSuccess,
+					m.PlayerID, m.SessionID, m.DeviceType, m.ErrorType, m.ErrorMessage, m.Metadata, nullableSite(m.SiteID),
+				}
+			}
+			return p.copyRows(ctx, "game_metrics", []string{
+				"time", "provider", "game_id", "game_type", "load_time_ms", "launch_success",
+				"player_id", "session_id", "device_type", "error_type", "error_message", "metadata", "site_id",
+			}, rows)
+		},
+	)
+}
+
+// CopyWebSocketMetrics writes WebSocket metrics with COPY, one statement per
+// chunk
+func (p *Postgres) CopyWebSocketMetrics(ctx context.Context, metrics []model.WebSocketMetric) error {
+	return copyPartitioned(ctx, p, metrics,
+		func(m model.WebSocketMetric) time.Time { return m.Time },
+		func(ctx context.Context, metrics []model.WebSocketMetric) error {
+			rows := make([][]interface{}, len(metrics))
+			for i, m := range metrics {
+				rows[i] = []interface{}{
+					m.Time, m.ConnectionID, m.PlayerID, m.EventType, m.LatencyMS,
+					m.MessagesSent, m.MessagesReceived, m.CloseCode, m.CloseReason,
+					m.Endpoint, m.DeviceType, m.Metadata, nullableSite(m.SiteID),
+				}
+			}
+			return p.copyRows(ctx, "websocket_metrics", []string{
+				"time", "connection_id", "player_id", "event_type", "latency_ms",
+				"messages_sent", "messages_received", "close_code", "close_reason",
+				"endpoint", "device_type", "metadata", "site_id",
+			}, rows)
+		},
+	)
+}
+
+// nullableSite stores metrics sent without a site as NULL
+func nullableSite(siteID string) *string {
+	if siteID == "" {
+		return nil
+	}
+	return &siteID
+}
+
+func (p *Postgres) copyRows(ctx context.Context, table string, columns []string, rows [][]interface{}) error {
+	start := time.Now()
+	_, err := p.pool.CopyFrom(ctx, pgx.Identifier{table}, columns, pgx.CopyFromRows(rows))
+
+	if f, ok := trace.FlushFrom(ctx); ok {
+		slog.Debug("copy written",
+			"collector", f.Collector,
+			"flush_id", f.ID,
+			"batch_ids", f.BatchIDs,
+			"table", table,
+			"rows", len(rows),
+			"duration_ms", time.Since(start).Milliseconds(),
+			"error", err,
+		)
+	}
+	return classify(err)
 }
 
 // ============================================
 // DASHBOARD QUERY METHODS
 // ============================================
 
+// Dashboard queries take the sites the caller may see. nil means all sites;
+// an empty slice matches nothing.
+
+// aggregateDefinitions mirror the continuous aggregates of the schema, with
+// %[1]d as the start and %[2]d as the sites parameter
+var aggregateDefinitions = map[string]string{
+	"api_performance_1m": `
+		SELECT time_bucket('1 minute', time) AS bucket, service_name, endpoint,
+		       COUNT(*) AS request_count,
+		       AVG(duration_ms) AS avg_duration_ms,
+		       PERCENTILE_CONT(0.95) WITHIN GROUP (ORDER BY duration_ms) AS p95_duration_ms,
+		       PERCENTILE_CONT(0.99) WITHIN GROUP (ORDER BY duration_ms) AS p99_duration_ms,
+		       SUM(CASE WHEN status_code >= 400 THEN 1 ELSE 0 END) AS error_count,
+		       SUM(CASE WHEN status_code >= 500 THEN 1 ELSE 0 END) AS server_error_count
+		FROM api_metrics
+		WHERE time >= $%[1]d AND site_id = ANY($%[2]d)
+		GROUP BY 1, service_name, endpoint`,
+	"psp_success_5m": `
+		SELECT time_bucket('5 minutes', time) AS bucket, psp_name, operation,
+		       COUNT(*) AS total_count,
+		       SUM(CASE WHEN success THEN 1 ELSE 0 END) AS success_count,
+		       AVG(duration_ms) AS avg_duration_ms,
+		       PERCENTILE_CONT(0.95) WITHIN GROUP (ORDER BY duration_ms) AS p95_duration_ms,
+		       SUM(amount) FILTER (WHERE success) AS total_amount
+		FROM psp_metrics
+		WHERE time >= $%[1]d AND site_id = ANY($%[2]d)
+		GROUP BY 1, psp_name, operation`,
+	"web_vitals_hourly": `
+		SELECT time_bucket('1 hour', time) AS bucket, device_type, page_path,
+		       COUNT(*) AS sample_count,
+		       AVG(lcp_ms) AS avg_lcp_ms,
+		       PERCENTILE_CONT(0.75) WITHIN GROUP (ORDER BY lcp_ms) AS p75_lcp_ms,
+		       AVG(fid_ms) AS avg_fid_ms,
+		       PERCENTILE_CONT(0.75) WITHIN GROUP (ORDER BY fid_ms) AS p75_fid_ms,
+		       AVG(cls) AS avg_cls,
+		       PERCENTILE_CONT(0.75) WITHIN GROUP (ORDER BY cls) AS p75_cls,
+		       AVG(inp_ms) AS avg_inp_ms,
+		       PERCENTILE_CONT(0.75) WITHIN GROUP (ORDER BY inp_ms) AS p75_inp_ms
+		FROM frontend_metrics
+		WHERE event_type = 'web_vital' AND time >= $%[1]d AND site_id = ANY($%[2]d)
+		GROUP BY 1, device_type, page_path`,
+	"game_health_5m": `
+		SELECT time_bucket('5 minutes', time) AS bucket, provider, game_type,
+		       COUNT(*) AS launch_count,
+		       SUM(CASE WHEN launch_success THEN 1 ELSE 0 END) AS success_count,
+		       AVG(load_time_ms) AS avg_load_time_ms,
+		       PERCENTILE_CONT(0.95) WITHIN GROUP (ORDER BY load_time_ms) AS p95_load_time_ms
+		FROM game_metrics
+		WHERE time >= $%[1]d AND site_id = ANY($%[2]d)
+		GROUP BY 1, provider, game_type`,
+}
+
+// aggregateSource returns what a dashboard query reads from a continuous
+// aggregate, and its arguments. Aggregates are not split by site, so for
+// queries restricted to sites the aggregate is computed from the raw
+// hypertable instead: startArg is the parameter bounding the scan, and
+// sites is appended to args.
+func aggregateSource(view string, sites []string, startArg int, args []interface{}) (string, []interface{}) {
+	if sites == nil {
+		return view, args
+	}
+	args = append(args, sites)
+	return fmt.Sprintf("(%s) AS %s", fmt.Sprintf(aggregateDefinitions[view], startArg, len(args)), view), args
+}
+
 // APIPerformanceRow represents a row from api_performance_1m
 type APIPerformanceRow struct {
 	Bucket           time.Time `json:"bucket"`
@@ -297,20 +631,23 @@ type APIPerformanceRow struct {
 	P99DurationMS    float64   `json:"p99_duration_ms"`
 	ErrorCount       int64     `json:"error_count"`
 	ServerErrorCount int64     `json:"server_error_count"`
+
+	Percentiles map[string]*float64 `json:"percentiles,omitempty"` // Only with ?percentiles=, see GetAPIPercentiles
 }
 
 // GetAPIPerformance retrieves API performance metrics from continuous aggregate
-func (p *Postgres) GetAPIPerformance(ctx context.Context, start time.Time) ([]APIPerformanceRow, error) {
+func (p *Postgres) GetAPIPerformance(ctx context.Context, start time.Time, sites []string) ([]APIPerformanceRow, error) {
+	source, args := aggregateSource("api_performance_1m", sites, 1, []interface{}{start})
 	query := `
 		SELECT bucket, service_name, endpoint, request_count,
 		       avg_duration_ms, p95_duration_ms, p99_duration_ms,
 		       error_count, server_error_count
-		FROM api_performance_1m
+		FROM ` + source + `
 		WHERE bucket >= $1
 		ORDER BY bucket DESC, service_name, endpoint
 	`
 
-	rows, err := p.pool.Query(ctx, query, start)
+	rows, err := p.pool.Query(ctx, query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("query api_performance_1m: %w", err)
 	}
@@ -334,20 +671,22 @@ func (p *Postgres) GetAPIPerformance(ctx context.Context, start time.Time) ([]AP
 
 // TimeSeriesPoint represents a single point in time series
 type TimeSeriesPoint struct {
-	Time  time.Time `json:"time"`
-	Value float64   `json:"value"`
+	Time     time.Time `json:"time"`
+	Value    float64   `json:"value"`
+	Baseline *float64  `json:"baseline,omitempty"` // Only with ?compare=, the value one period or week earlier
 }
 
-// GetAPITimeSeries retrieves time series for a specific service
-func (p *Postgres) GetAPITimeSeries(ctx context.Context, serviceName string, start time.Time) ([]TimeSeriesPoint, error) {
+// GetAPITimeSeries retrieves time series for a specific service in [start, end)
+func (p *Postgres) GetAPITimeSeries(ctx context.Context, serviceName string, start, end time.Time, sites []string) ([]TimeSeriesPoint, error) {
+	source, args := aggregateSource("api_performance_1m", sites, 2, []interface{}{serviceName, start, end})
 	query := `
 		SELECT bucket, avg_duration_ms
-		FROM api_performance_1m
-		WHERE service_name = $1 AND bucket >= $2
+		FROM ` + source + `
+		WHERE service_name = $1 AND bucket >= $2 AND bucket < $3
 		ORDER BY bucket ASC
 	`
 
-	rows, err := p.pool.Query(ctx, query, serviceName, start)
+	rows, err := p.pool.Query(ctx, query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("query api timeseries: %w", err)
 	}
@@ -375,19 +714,23 @@ type PSPHealthRow struct {
 	AvgDurationMS float64   `json:"avg_duration_ms"`
 	P95DurationMS float64   `json:"p95_duration_ms"`
 	TotalAmount   float64   `json:"total_amount"`
+	Campaign      *string   `json:"campaign,omitempty"` // Only in the campaign breakdown; null for untagged payments
+
+	Percentiles map[string]*float64 `json:"percentiles,omitempty"` // Only with ?percentiles=, see GetPSPPercentiles
 }
 
 // GetPSPHealth retrieves PSP health metrics from continuous aggregate
-func (p *Postgres) GetPSPHealth(ctx context.Context, start time.Time) ([]PSPHealthRow, error) {
+func (p *Postgres) GetPSPHealth(ctx context.Context, start time.Time, sites []string) ([]PSPHealthRow, error) {
+	source, args := aggregateSource("psp_success_5m", sites, 1, []interface{}{start})
 	query := `
 		SELECT bucket, psp_name, operation, total_count, success_count,
 		       avg_duration_ms, p95_duration_ms, COALESCE(total_amount, 0)
-		FROM psp_success_5m
+		FROM ` + source + `
 		WHERE bucket >= $1
 		ORDER BY bucket DESC, psp_name, operation
 	`
 
-	rows, err := p.pool.Query(ctx, query, start)
+	rows, err := p.pool.Query(ctx, query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("query psp_success_5m: %w", err)
 	}
@@ -408,17 +751,18 @@ func (p *Postgres) GetPSPHealth(ctx context.Context, start time.Time) ([]PSPHeal
 	return result, rows.Err()
 }
 
-// GetPSPTimeSeries retrieves time series for a specific PSP
-func (p *Postgres) GetPSPTimeSeries(ctx context.Context, pspName string, start time.Time) ([]TimeSeriesPoint, error) {
+// GetPSPTimeSeries retrieves time series for a specific PSP in [start, end)
+func (p *Postgres) GetPSPTimeSeries(ctx context.Context, pspName string, start, end time.Time, sites []string) ([]TimeSeriesPoint, error) {
+	source, args := aggregateSource("psp_success_5m", sites, 2, []interface{}{pspName, start, end})
 	query := `
 		SELECT bucket,
 		       CASE WHEN total_count > 0 THEN success_count::float / total_count * 100 ELSE 100 END as success_rate
-		FROM psp_success_5m
-		WHERE psp_name = $1 AND bucket >= $2
+		FROM ` + source + `
+		WHERE psp_name = $1 AND bucket >= $2 AND bucket < $3
 		ORDER BY bucket ASC
 	`
 
-	rows, err := p.pool.Query(ctx, query, pspName, start)
+	rows, err := p.pool.Query(ctx, query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("query psp timeseries: %w", err)
 	}
@@ -453,19 +797,20 @@ type WebVitalsRow struct {
 }
 
 // GetWebVitals retrieves Web Vitals metrics from continuous aggregate
-func (p *Postgres) GetWebVitals(ctx context.Context, start time.Time) ([]WebVitalsRow, error) {
+func (p *Postgres) GetWebVitals(ctx context.Context, start time.Time, sites []string) ([]WebVitalsRow, error) {
+	source, args := aggregateSource("web_vitals_hourly", sites, 1, []interface{}{start})
 	query := `
 		SELECT bucket, COALESCE(device_type, 'unknown'), COALESCE(page_path, '/'),
 		       sample_count, COALESCE(avg_lcp_ms, 0), COALESCE(p75_lcp_ms, 0),
 		       COALESCE(avg_fid_ms, 0), COALESCE(p75_fid_ms, 0),
 		       COALESCE(avg_cls, 0), COALESCE(p75_cls, 0),
 		       COALESCE(avg_inp_ms, 0), COALESCE(p75_inp_ms, 0)
-		FROM web_vitals_hourly
+		FROM ` + source + `
 		WHERE bucket >= $1
 		ORDER BY bucket DESC, device_type, page_path
 	`
 
-	rows, err := p.pool.Query(ctx, query, start)
+	rows, err := p.pool.Query(ctx, query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("query web_vitals_hourly: %w", err)
 	}
@@ -487,8 +832,8 @@ func (p *Postgres) GetWebVitals(ctx context.Context, start time.Time) ([]WebVita
 	return result, rows.Err()
 }
 
-// GetWebVitalsTimeSeries retrieves time series for a specific metric
-func (p *Postgres) GetWebVitalsTimeSeries(ctx context.Context, metric string, start time.Time) ([]TimeSeriesPoint, error) {
+// GetWebVitalsTimeSeries retrieves time series for a specific metric in [start, end)
+func (p *Postgres) GetWebVitalsTimeSeries(ctx context.Context, metric string, start, end time.Time, sites []string) ([]TimeSeriesPoint, error) {
 	// Map metric name to column
 	column := "avg_lcp_ms"
 	switch metric {
@@ -502,15 +847,16 @@ func (p *Postgres) GetWebVitalsTimeSeries(ctx context.Context, metric string, st
 		column = "avg_inp_ms"
 	}
 
+	source, args := aggregateSource("web_vitals_hourly", sites, 1, []interface{}{start, end})
 	query := fmt.Sprintf(`
 		SELECT bucket, COALESCE(AVG(%s), 0)
-		FROM web_vitals_hourly
-		WHERE bucket >= $1
+		FROM %s
+		WHERE bucket >= $1 AND bucket < $2
 		GROUP BY bucket
 		ORDER BY bucket ASC
-	`, column)
+	`, column, source)
 
-	rows, err := p.pool.Query(ctx, query, start)
+	rows, err := p.pool.Query(ctx, query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("query vitals timeseries: %w", err)
 	}
@@ -537,20 +883,23 @@ type GameHealthRow struct {
 	SuccessCount  int64     `json:"success_count"`
 	AvgLoadTimeMS float64   `json:"avg_load_time_ms"`
 	P95LoadTimeMS float64   `json:"p95_load_time_ms"`
+
+	Percentiles map[string]*float64 `json:"percentiles,omitempty"` // Only with ?percentiles=, see GetGamePercentiles
 }
 
 // GetGameHealth retrieves game provider health metrics
-func (p *Postgres) GetGameHealth(ctx context.Context, start time.Time) ([]GameHealthRow, error) {
+func (p *Postgres) GetGameHealth(ctx context.Context, start time.Time, sites []string) ([]GameHealthRow, error) {
+	source, args := aggregateSource("game_health_5m", sites, 1, []interface{}{start})
 	query := `
 		SELECT bucket, provider, COALESCE(game_type, 'unknown'),
 		       launch_count, success_count,
 		       COALESCE(avg_load_time_ms, 0), COALESCE(p95_load_time_ms, 0)
-		FROM game_health_5m
+		FROM ` + source + `
 		WHERE bucket >= $1
 		ORDER BY bucket DESC, provider, game_type
 	`
 
-	rows, err := p.pool.Query(ctx, query, start)
+	rows, err := p.pool.Query(ctx, query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("query game_health_5m: %w", err)
 	}
@@ -572,17 +921,18 @@ func (p *Postgres) GetGameHealth(ctx context.Context, start time.Time) ([]GameHe
 	return result, rows.Err()
 }
 
-// GetGameTimeSeries retrieves time series for a specific provider
-func (p *Postgres) GetGameTimeSeries(ctx context.Context, provider string, start time.Time) ([]TimeSeriesPoint, error) {
+// GetGameTimeSeries retrieves time series for a specific provider in [start, end)
+func (p *Postgres) GetGameTimeSeries(ctx context.Context, provider string, start, end time.Time, sites []string) ([]TimeSeriesPoint, error) {
+	source, args := aggregateSource("game_health_5m", sites, 2, []interface{}{provider, start, end})
 	query := `
 		SELECT bucket,
 		       CASE WHEN launch_count > 0 THEN success_count::float / launch_count * 100 ELSE 100 END
-		FROM game_health_5m
-		WHERE provider = $1 AND bucket >= $2
+		FROM ` + source + `
+		WHERE provider = $1 AND bucket >= $2 AND bucket < $3
 		ORDER BY bucket ASC
 	`
 
-	rows, err := p.pool.Query(ctx, query, provider, start)
+	rows, err := p.pool.Query(ctx, query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("query game timeseries: %w", err)
 	}
@@ -600,6 +950,208 @@ func (p *Postgres) GetGameTimeSeries(ctx context.Context, provider string, start
 	return result, rows.Err()
 }
 
+// ============================================
+// LATENCY PERCENTILES
+// ============================================
+
+// Percentile queries read the raw hypertables, since the continuous
+// aggregates only keep fixed percentiles. percentiles are in percent (50
+// for the median) and come back keyed by PercentileKey; a key is null when
+// the group has no latency values.
+
+// PercentileKey names a percentile in a Percentiles map, e.g. 99.9 as p99.9
+func PercentileKey(p float64) string {
+	return "p" + strconv.FormatFloat(p, 'f', -1, 64)
+}
+
+// fractions converts percentiles to the fractions PERCENTILE_CONT takes
+func fractions(percentiles []float64) []float64 {
+	f := make([]float64, len(percentiles))
+	for i, p := range percentiles {
+		f[i] = p / 100
+	}
+	return f
+}
+
+func percentileMap(percentiles []float64, values []*float64) map[string]*float64 {
+	m := make(map[string]*float64, len(percentiles))
+	for i, p := range percentiles {
+		if i < len(values) {
+			m[PercentileKey(p)] = values[i]
+		}
+	}
+	return m
+}
+
+// GetAPIPercentiles returns API performance like GetAPIPerformance, with
+// the requested duration percentiles, from raw metrics
+func (p *Postgres) GetAPIPercentiles(ctx context.Context, start time.Time, percentiles []float64, sites []string) ([]APIPerformanceRow, error) {
+	query := `
+		SELECT time_bucket('1 minute', time) AS bucket, service_name, endpoint,
+		       COUNT(*), AVG(duration_ms)::float8,
+		       PERCENTILE_CONT(0.95) WITHIN GROUP (ORDER BY duration_ms)::float8,
+		       PERCENTILE_CONT(0.99) WITHIN GROUP (ORDER BY duration_ms)::float8,
+		       COUNT(*) FILTER (WHERE status_code >= 400),
+		       COUNT(*) FILTER (WHERE status_code >= 500),
+		       PERCENTILE_CONT($2::float8[]) WITHIN GROUP (ORDER BY duration_ms::float8)
+		FROM api_metrics
+		WHERE time >= $1 AND ($3::text[] IS NULL OR site_id = ANY($3))
+		GROUP BY bucket, service_name, endpoint
+		ORDER BY bucket DESC, service_name, endpoint
+	`
+
+	rows, err := p.pool.Query(ctx, query, start, fractions(percentiles), sites)
+	if err != nil {
+		return nil, fmt.Errorf("query api percentiles: %w", err)
+	}
+	defer rows.Close()
+
+	var result []APIPerformanceRow
+	for rows.Next() {
+		var r APIPerformanceRow
+		var values []*float64
+		if err := rows.Scan(
+			&r.Bucket, &r.ServiceName, &r.Endpoint, &r.RequestCount,
+			&r.AvgDurationMS, &r.P95DurationMS, &r.P99DurationMS,
+			&r.ErrorCount, &r.ServerErrorCount, &values,
+		); err != nil {
+			return nil, fmt.Errorf("scan row: %w", err)
+		}
+		r.Percentiles = percentileMap(percentiles, values)
+		result = append(result, r)
+	}
+
+	return result, rows.Err()
+}
+
+// GetPSPPercentiles returns PSP health like GetPSPHealth, with the
+// requested duration percentiles, from raw metrics
+func (p *Postgres) GetPSPPercentiles(ctx context.Context, start time.Time, percentiles []float64, sites []string) ([]PSPHealthRow, error) {
+	query := `
+		SELECT time_bucket('5 minutes', time) AS bucket, psp_name, operation,
+		       COUNT(*), COUNT(*) FILTER (WHERE success),
+		       AVG(duration_ms)::float8,
+		       PERCENTILE_CONT(0.95) WITHIN GROUP (ORDER BY duration_ms)::float8,
+		       COALESCE(SUM(amount) FILTER (WHERE success), 0)::float8,
+		       PERCENTILE_CONT($2::float8[]) WITHIN GROUP (ORDER BY duration_ms::float8)
+		FROM psp_metrics
+		WHERE time >= $1 AND ($3::text[] IS NULL OR site_id = ANY($3))
+		GROUP BY bucket, psp_name, operation
+		ORDER BY bucket DESC, psp_name, operation
+	`
+
+	rows, err := p.pool.Query(ctx, query, start, fractions(percentiles), sites)
+	if err != nil {
+		return nil, fmt.Errorf("query psp percentiles: %w", err)
+	}
+	defer rows.Close()
+
+	var result []PSPHealthRow
+	for rows.Next() {
+		var r PSPHealthRow
+		var values []*float64
+		if err := rows.Scan(
+			&r.Bucket, &r.PSPName, &r.Operation, &r.TotalCount, &r.SuccessCount,
+			&r.AvgDurationMS, &r.P95DurationMS, &r.TotalAmount, &values,
+		); err != nil {
+			return nil, fmt.Errorf("scan row: %w", err)
+		}
+		r.Percentiles = percentileMap(percentiles, values)
+		result = append(result, r)
+	}
+
+	return result, rows.Err()
+}
+
+// GetGamePercentiles returns game provider health like GetGameHealth, with
+// the requested load time percentiles, from raw metrics
+func (p *Postgres) GetGamePercentiles(ctx context.Context, start time.Time, percentiles []float64, sites []string) ([]GameHealthRow, error) {
+	query := `
+		SELECT time_bucket('5 minutes', time) AS bucket, provider, COALESCE(game_type, 'unknown') AS game_type,
+		       COUNT(*), COUNT(*) FILTER (WHERE launch_success),
+		       COALESCE(AVG(load_time_ms), 0)::float8,
+		       COALESCE(PERCENTILE_CONT(0.95) WITHIN GROUP (ORDER BY load_time_ms), 0)::float8,
+		       PERCENTILE_CONT($2::float8[]) WITHIN GROUP (ORDER BY load_time_ms::float8)
+		FROM game_metrics
+		WHERE time >= $1 AND ($3::text[] IS NULL OR site_id = ANY($3))
+		GROUP BY bucket, provider, 3
+		ORDER BY bucket DESC, provider, 3
+	`
+
+	rows, err := p.pool.Query(ctx, query, start, fractions(percentiles), sites)
+	if err != nil {
+		return nil, fmt.Errorf("query game percentiles: %w", err)
+	}
+	defer rows.Close()
+
+	var result []GameHealthRow
+	for rows.Next() {
+		var r GameHealthRow
+		var values []*float64
+		if err := rows.Scan(
+			&r.Bucket, &r.Provider, &r.GameType,
+			&r.LaunchCount, &r.SuccessCount,
+			&r.AvgLoadTimeMS, &r.P95LoadTimeMS, &values,
+		); err != nil {
+			return nil, fmt.Errorf("scan row: %w", err)
+		}
+		r.Percentiles = percentileMap(percentiles, values)
+		result = append(result, r)
+	}
+
+	return result, rows.Err()
+}
+
+// WebSocketLatencyRow is the connection quality of a WebSocket endpoint and
+// device type in a 5 minute bucket
+type WebSocketLatencyRow struct {
+	Bucket       time.Time           `json:"bucket"`
+	Endpoint     string              `json:"endpoint"`
+	DeviceType   string              `json:"device_type"`
+	EventCount   int64               `json:"event_count"`
+	ErrorCount   int64               `json:"error_count"`
+	AvgLatencyMS *float64            `json:"avg_latency_ms"`
+	Percentiles  map[string]*float64 `json:"percentiles"`
+}
+
+// GetWebSocketLatency returns WebSocket event counts and latency
+// percentiles from raw metrics; there is no continuous aggregate for them
+func (p *Postgres) GetWebSocketLatency(ctx context.Context, start time.Time, percentiles []float64, sites []string) ([]WebSocketLatencyRow, error) {
+	query := `
+		SELECT time_bucket('5 minutes', time) AS bucket,
+		       COALESCE(endpoint, 'unknown') AS endpoint, COALESCE(device_type, 'unknown') AS device_type,
+		       COUNT(*), COUNT(*) FILTER (WHERE event_type = 'error'),
+		       AVG(latency_ms)::float8,
+		       PERCENTILE_CONT($2::float8[]) WITHIN GROUP (ORDER BY latency_ms::float8)
+		FROM websocket_metrics
+		WHERE time >= $1 AND ($3::text[] IS NULL OR site_id = ANY($3))
+		GROUP BY 1, 2, 3
+		ORDER BY 1 DESC, 2, 3
+	`
+
+	rows, err := p.pool.Query(ctx, query, start, fractions(percentiles), sites)
+	if err != nil {
+		return nil, fmt.Errorf("query websocket latency: %w", err)
+	}
+	defer rows.Close()
+
+	var result []WebSocketLatencyRow
+	for rows.Next() {
+		var r WebSocketLatencyRow
+		var values []*float64
+		if err := rows.Scan(
+			&r.Bucket, &r.Endpoint, &r.DeviceType,
+			&r.EventCount, &r.ErrorCount, &r.AvgLatencyMS, &values,
+		); err != nil {
+			return nil, fmt.Errorf("scan row: %w", err)
+		}
+		r.Percentiles = percentileMap(percentiles, values)
+		result = append(result, r)
+	}
+
+	return result, rows.Err()
+}
+
 // OverviewMetrics represents aggregated overview data
 type OverviewMetrics struct {
 	ActiveSessions  int64   `json:"active_sessions"`
@@ -613,50 +1165,53 @@ type OverviewMetrics struct {
 }
 
 // GetOverviewMetrics retrieves aggregated overview metrics
-func (p *Postgres) GetOverviewMetrics(ctx context.Context, start time.Time) (*OverviewMetrics, error) {
+func (p *Postgres) GetOverviewMetrics(ctx context.Context, start time.Time, sites []string) (*OverviewMetrics, error) {
 	result := &OverviewMetrics{}
 
 	// Active sessions (distinct session_ids in last 15 min)
 	err := p.pool.QueryRow(ctx, `
 		SELECT COUNT(DISTINCT session_id)
 		FROM frontend_metrics
-		WHERE time >= $1
-	`, start).Scan(&result.ActiveSessions)
+		WHERE time >= $1 AND ($2::text[] IS NULL OR site_id = ANY($2))
+	`, start, sites).Scan(&result.ActiveSessions)
 	if err != nil {
 		return nil, fmt.Errorf("query active sessions: %w", err)
 	}
 
 	// API error rate and latency
+	source, args := aggregateSource("api_performance_1m", sites, 1, []interface{}{start})
 	err = p.pool.QueryRow(ctx, `
 		SELECT
 			COALESCE(AVG(CASE WHEN error_count > 0 THEN error_count::float / NULLIF(request_count, 0) * 100 ELSE 0 END), 0),
 			COALESCE(AVG(avg_duration_ms), 0)
-		FROM api_performance_1m
+		FROM `+source+`
 		WHERE bucket >= $1
-	`, start).Scan(&result.ErrorRate, &result.AvgLatencyMS)
+	`, args...).Scan(&result.ErrorRate, &result.AvgLatencyMS)
 	if err != nil {
 		return nil, fmt.Errorf("query api metrics: %w", err)
 	}
 
 	// PSP metrics
+	source, args = aggregateSource("psp_success_5m", sites, 1, []interface{}{start})
 	err = p.pool.QueryRow(ctx, `
 		SELECT
 			COALESCE(SUM(CASE WHEN operation = 'deposit' THEN total_count ELSE 0 END), 0),
 			COALESCE(SUM(CASE WHEN operation = 'deposit' THEN total_amount ELSE 0 END), 0),
 			COALESCE(AVG(CASE WHEN total_count > 0 THEN success_count::float / total_count * 100 ELSE 100 END), 100)
-		FROM psp_success_5m
+		FROM `+source+`
 		WHERE bucket >= $1
-	`, start).Scan(&result.DepositsCount, &result.DepositsVolume, &result.PSPSuccessRate)
+	`, args...).Scan(&result.DepositsCount, &result.DepositsVolume, &result.PSPSuccessRate)
 	if err != nil {
 		return nil, fmt.Errorf("query psp metrics: %w", err)
 	}
 
 	// Game success rate
+	source, args = aggregateSource("game_health_5m", sites, 1, []interface{}{start})
 	err = p.pool.QueryRow(ctx, `
 		SELECT COALESCE(AVG(CASE WHEN launch_count > 0 THEN success_count::float / launch_count * 100 ELSE 100 END), 100)
-		FROM game_health_5m
+		FROM `+source+`
 		WHERE bucket >= $1
-	`, start).Scan(&result.GameSuccessRate)
+	`, args...).Scan(&result.GameSuccessRate)
 	if err != nil {
 		return nil, fmt.Errorf("query game metrics: %w", err)
 	}
@@ -666,31 +1221,64 @@ func (p *Postgres) GetOverviewMetrics(ctx context.Context, start time.Time) (*Ov
 
 // AlertRow represents an alert event
 type AlertRow struct {
+	ID             int64      `json:"id"`
 	Time           time.Time  `json:"time"`
 	AlertType      string     `json:"alert_type"`
 	Severity       string     `json:"severity"`
 	SourceTable    string     `json:"source_table"`
 	MetricName     string     `json:"metric_name"`
+	Target         string     `json:"target,omitempty"`  // PSP, provider, service... the alert is about
+	SiteID         string     `json:"site_id,omitempty"` // Empty for alerts across sites
 	ThresholdValue float64    `json:"threshold_value"`
 	ActualValue    float64    `json:"actual_value"`
 	Acknowledged   bool       `json:"acknowledged"`
+	AcknowledgedAt *time.Time `json:"acknowledged_at"`
 	ResolvedAt     *time.Time `json:"resolved_at"`
 	Message        string     `json:"message"`
 }
 
-// GetAlerts retrieves alert events
-func (p *Postgres) GetAlerts(ctx context.Context, resolved *bool) ([]AlertRow, error) {
+// Alert states of GetAlerts
+const (
+	AlertStateOpen           = "open"           // Not resolved
+	AlertStateUnacknowledged = "unacknowledged" // Not resolved nor acknowledged
+	AlertStateAcknowledged   = "acknowledged"   // Acknowledged, not resolved
+	AlertStateResolved       = "resolved"
+)
+
+// alertStateConditions select the alerts of each state
+var alertStateConditions = map[string]string{
+	"":                       `TRUE`,
+	AlertStateOpen:           `resolved_at IS NULL`,
+	AlertStateUnacknowledged: `resolved_at IS NULL AND NOT acknowledged`,
+	AlertStateAcknowledged:   `resolved_at IS NULL AND acknowledged`,
+	AlertStateResolved:       `resolved_at IS NOT NULL`,
+}
+
+// ValidAlertState reports whether state is an alert state, empty for all
+func ValidAlertState(state string) bool {
+	_, ok := alertStateConditions[state]
+	return ok
+}
+
+// GetAlerts retrieves the latest alert events matching f in a state, empty
+// for all, newest first
+func (p *Postgres) GetAlerts(ctx context.Context, f AlertFilter, state string, limit int) ([]AlertRow, error) {
+	condition, ok := alertStateConditions[state]
+	if !ok {
+		return nil, fmt.Errorf("unknown alert state %q", state)
+	}
 	query := `
-		SELECT time, alert_type, severity, COALESCE(source_table, ''),
+		SELECT id, time, alert_type, severity, COALESCE(source_table, ''),
 		       COALESCE(metric_name, ''), COALESCE(threshold_value, 0),
-		       COALESCE(actual_value, 0), acknowledged, resolved_at, COALESCE(message, '')
+		       COALESCE(actual_value, 0), acknowledged, acknowledged_at, resolved_at, COALESCE(message, ''),
+		       COALESCE(target, ''), COALESCE(site_id, '')
 		FROM alert_events
-		WHERE ($1::boolean IS NULL OR (resolved_at IS NOT NULL) = $1)
+		WHERE ` + condition + ` AND ` + alertFilterSQL + `
 		ORDER BY time DESC
-		LIMIT 100
+		LIMIT $9
 	`
 
-	rows, err := p.pool.Query(ctx, query, resolved)
+	rows, err := p.pool.Query(ctx, query, append(f.args(), limit)...)
 	if err != nil {
 		return nil, fmt.Errorf("query alerts: %w", err)
 	}
@@ -700,9 +1288,9 @@ func (p *Postgres) GetAlerts(ctx context.Context, resolved *bool) ([]AlertRow, e
 	for rows.Next() {
 		var r AlertRow
 		if err := rows.Scan(
-			&r.Time, &r.AlertType, &r.Severity, &r.SourceTable,
+			&r.ID, &r.Time, &r.AlertType, &r.Severity, &r.SourceTable,
 			&r.MetricName, &r.ThresholdValue, &r.ActualValue,
-			&r.Acknowledged, &r.ResolvedAt, &r.Message,
+			&r.Acknowledged, &r.AcknowledgedAt, &r.ResolvedAt, &r.Message, &r.Target, &r.SiteID,
 		); err != nil {
 			return nil, fmt.Errorf("scan row: %w", err)
 		}
@@ -712,12 +1300,4687 @@ func (p *Postgres) GetAlerts(ctx context.Context, resolved *bool) ([]AlertRow, e
 	return result, rows.Err()
 }
 
-// AcknowledgeAlert marks an alert as acknowledged
-func (p *Postgres) AcknowledgeAlert(ctx context.Context, alertTime time.Time) error {
-	_, err := p.pool.Exec(ctx, `
-		UPDATE alert_events
-		SET acknowledged = true
-		WHERE time = $1
-	`, alertTime)
-	return err
+// GetAlertsAfter returns up to limit alerts with an id above cursor, oldest
+// first. Ids come from a sequence, so alerts raised after a cursor always
+// sort after it.
+func (p *Postgres) GetAlertsAfter(ctx context.Context, cursor int64, limit int) ([]AlertRow, error) {
+	rows, err := p.pool.Query(ctx, `
+		SELECT id, time, alert_type, severity, COALESCE(source_table, ''),
+		       COALESCE(metric_name, ''), COALESCE(threshold_value, 0),
+		       COALESCE(actual_value, 0), acknowledged, acknowledged_at, resolved_at, COALESCE(message, ''),
+		       COALESCE(target, ''), COALESCE(site_id, '')
+		FROM alert_events
+		WHERE id > $1
+		ORDER BY id
+		LIMIT $2
+	`, cursor, limit)
+	if err != nil {
+		return nil, fmt.Errorf("query alerts after %d: %w", cursor, err)
+	}
+	defer rows.Close()
+
+	var result []AlertRow
+	for rows.Next() {
+		var r AlertRow
+		if err := rows.Scan(
+			&r.ID, &r.Time, &r.AlertType, &r.Severity, &r.SourceTable,
+			&r.MetricName, &r.ThresholdValue, &r.ActualValue,
+			&r.Acknowledged, &r.AcknowledgedAt, &r.ResolvedAt, &r.Message, &r.Target, &r.SiteID,
+		); err != nil {
+			return nil, fmt.Errorf("scan row: %w", err)
+		}
+		result = append(result, r)
+	}
+
+	return result, rows.Err()
+}
+
+// GetLatestAlertID returns the id of the newest alert, 0 without alerts
+func (p *Postgres) GetLatestAlertID(ctx context.Context) (int64, error) {
+	var id int64
+	if err := p.pool.QueryRow(ctx, `SELECT COALESCE(MAX(id), 0) FROM alert_events`).Scan(&id); err != nil {
+		return 0, fmt.Errorf("query latest alert id: %w", err)
+	}
+	return id, nil
+}
+
+// AlertGroup is an incident: related alerts of one type, source table and
+// site raised at most the grouping window apart, e.g. the errors of every
+// endpoint of a failing service
+type AlertGroup struct {
+	ID           string     `json:"id"` // type:source_table:site:unix time of the first alert
+	AlertType    string     `json:"alert_type"`
+	SourceTable  string     `json:"source_table,omitempty"`
+	SiteID       string     `json:"site_id,omitempty"`
+	Severity     string     `json:"severity"`     // Highest of its alerts
+	Count        int64      `json:"count"`        // Alerts in the group
+	Distinct     int64      `json:"distinct"`     // Distinct metrics; repeats of one alert count once
+	Open         int64      `json:"open"`         // Alerts not resolved yet
+	Acknowledged bool       `json:"acknowledged"` // All alerts acknowledged
+	Targets      []string   `json:"targets"`      // Up to 20 PSPs, providers, services... the alerts are about
+	FirstAt      time.Time  `json:"first_at"`
+	LastAt       time.Time  `json:"last_at"`
+	ResolvedAt   *time.Time `json:"resolved_at"` // Once all alerts resolved
+	Samples      []AlertRow `json:"samples"`     // The latest alerts
+}
+
+// GetAlertGroups groups the alerts raised since start into incidents,
+// latest first: alerts of the same type, source table and site join the
+// group of the previous one if raised within window of it. Each group
+// carries up to samples of its latest alerts. resolved filters on whether
+// all alerts of a group are resolved.
+func (p *Postgres) GetAlertGroups(ctx context.Context, start time.Time, window time.Duration, samples int, resolved *bool) ([]AlertGroup, error) {
+	query := `
+		WITH marked AS (
+			SELECT *, COALESCE(source_table, '') AS src, COALESCE(site_id, '') AS site,
+			       CASE WHEN time - LAG(time) OVER w <= $2 THEN 0 ELSE 1 END AS starts_group
+			FROM alert_events
+			WHERE time >= $1
+			WINDOW w AS (PARTITION BY alert_type, COALESCE(source_table, ''), COALESCE(site_id, '') ORDER BY time)
+		), grouped AS (
+			SELECT *, SUM(starts_group) OVER (PARTITION BY alert_type, src, site ORDER BY time) AS incident
+			FROM marked
+		)
+		SELECT alert_type, src, site,
+		       (ARRAY['info', 'warning', 'critical'])[MAX(CASE severity WHEN 'critical' THEN 3 WHEN 'warning' THEN 2 ELSE 1 END)],
+		       COUNT(*), COUNT(DISTINCT metric_name), COUNT(*) FILTER (WHERE resolved_at IS NULL),
+		       bool_and(COALESCE(acknowledged, false)), MIN(time), MAX(time),
+		       CASE WHEN bool_and(resolved_at IS NOT NULL) THEN MAX(resolved_at) END,
+		       COALESCE((ARRAY_AGG(DISTINCT target) FILTER (WHERE target IS NOT NULL))[1:20], '{}'),
+		       to_jsonb((ARRAY_AGG(jsonb_build_object(
+		           'id', id, 'time', time, 'alert_type', alert_type, 'severity', severity,
+		           'source_table', src, 'metric_name', COALESCE(metric_name, ''),
+		           'target', COALESCE(target, ''), 'site_id', site,
+		           'threshold_value', COALESCE(threshold_value, 0), 'actual_value', COALESCE(actual_value, 0),
+		           'acknowledged', COALESCE(acknowledged, false), 'resolved_at', resolved_at,
+		           'message', COALESCE(message, '')
+		       ) ORDER BY time DESC))[1:$3])
+		FROM grouped
+		GROUP BY alert_type, src, site, incident
+		HAVING $4::boolean IS NULL OR bool_and(resolved_at IS NOT NULL) = $4
+		ORDER BY MAX(time) DESC
+		LIMIT 100
+	`
+
+	rows, err := p.pool.Query(ctx, query, start, window, samples, resolved)
+	if err != nil {
+		return nil, fmt.Errorf("query alert groups: %w", err)
+	}
+	defer rows.Close()
+
+	var result []AlertGroup
+	for rows.Next() {
+		var g AlertGroup
+		if err := rows.Scan(
+			&g.AlertType, &g.SourceTable, &g.SiteID, &g.Severity,
+			&g.Count, &g.Distinct, &g.Open, &g.Acknowledged, &g.FirstAt, &g.LastAt,
+			&g.ResolvedAt, &g.Targets, &g.Samples,
+		); err != nil {
+			return nil, fmt.Errorf("scan row: %w", err)
+		}
+		g.ID = fmt.Sprintf("%s:%s:%s:%d", g.AlertType, g.SourceTable, g.SiteID, g.FirstAt.Unix())
+		result = append(result, g)
+	}
+
+	return result, rows.Err()
+}
+
+// AcknowledgeAlert marks an alert as acknowledged
+func (p *Postgres) AcknowledgeAlert(ctx context.Context, alertTime time.Time) error {
+	_, err := p.pool.Exec(ctx, `
+		UPDATE alert_events
+		SET acknowledged = true, acknowledged_at = COALESCE(acknowledged_at, NOW())
+		WHERE time = $1
+	`, alertTime)
+	return err
+}
+
+// AlertFilter selects alert events; empty fields match every alert
+type AlertFilter struct {
+	AlertType   string     `json:"alert_type,omitempty"`
+	Severity    string     `json:"severity,omitempty"`
+	SourceTable string     `json:"source_table,omitempty"`
+	MetricName  string     `json:"metric_name,omitempty"`
+	Target      string     `json:"target,omitempty"` // e.g. a PSP
+	SiteID      string     `json:"site_id,omitempty"`
+	Start       *time.Time `json:"start,omitempty"` // Raised at or after
+	End         *time.Time `json:"end,omitempty"`   // Raised before
+}
+
+// alertFilterSQL is the condition of an AlertFilter on alert_events, with
+// the filter's args as $1-$8
+const alertFilterSQL = `($1 = '' OR alert_type = $1) AND ($2 = '' OR severity = $2)
+	AND ($3 = '' OR source_table = $3) AND ($4 = '' OR metric_name = $4)
+	AND ($5 = '' OR target = $5) AND ($6 = '' OR site_id = $6)
+	AND ($7::timestamptz IS NULL OR time >= $7) AND ($8::timestamptz IS NULL OR time < $8)`
+
+func (f AlertFilter) args() []interface{} {
+	return []interface{}{f.AlertType, f.Severity, f.SourceTable, f.MetricName, f.Target, f.SiteID, f.Start, f.End}
+}
+
+// Bulk alert actions
+const (
+	AlertActionAcknowledge = "acknowledge" // Acknowledge unacknowledged alerts
+	AlertActionResolve     = "resolve"     // Resolve open alerts
+)
+
+// bulkAlertConditions are the alerts each bulk action changes
+var bulkAlertConditions = map[string]string{
+	AlertActionAcknowledge: `NOT acknowledged`,
+	AlertActionResolve:     `resolved_at IS NULL`,
+}
+
+// ValidAlertAction reports whether action is a bulk alert action
+func ValidAlertAction(action string) bool {
+	_, ok := bulkAlertConditions[action]
+	return ok
+}
+
+// CountBulkAlerts returns how many alerts matching f a bulk action would
+// change, for a dry run
+func (p *Postgres) CountBulkAlerts(ctx context.Context, action string, f AlertFilter) (int64, error) {
+	condition, ok := bulkAlertConditions[action]
+	if !ok {
+		return 0, fmt.Errorf("unknown alert action %q", action)
+	}
+	var n int64
+	err := p.pool.QueryRow(ctx, `
+		SELECT COUNT(*) FROM alert_events
+		WHERE `+condition+` AND `+alertFilterSQL, f.args()...).Scan(&n)
+	if err != nil {
+		return 0, fmt.Errorf("count alerts to %s: %w", action, err)
+	}
+	return n, nil
+}
+
+// BulkUpdateAlerts acknowledges or resolves every alert matching f and
+// returns how many it changed. Resolving the alert of a firing threshold
+// rule also moves the rule to resolved, so the next evaluation that still
+// finds it breaching raises a new alert instead of keeping the incident
+// hidden until the rule recovers.
+func (p *Postgres) BulkUpdateAlerts(ctx context.Context, action string, f AlertFilter) (int64, error) {
+	condition, ok := bulkAlertConditions[action]
+	if !ok {
+		return 0, fmt.Errorf("unknown alert action %q", action)
+	}
+	if action == AlertActionAcknowledge {
+		tag, err := p.pool.Exec(ctx, `
+			UPDATE alert_events SET acknowledged = true, acknowledged_at = NOW()
+			WHERE `+condition+` AND `+alertFilterSQL, f.args()...)
+		if err != nil {
+			return 0, fmt.Errorf("%s alerts: %w", action, err)
+		}
+		return tag.RowsAffected(), nil
+	}
+
+	// Threshold rule alerts (alerting.AlertType) carry the rule name as
+	// metric_name
+	var n int64
+	err := p.pool.QueryRow(ctx, `
+		WITH changed AS (
+			UPDATE alert_events SET resolved_at = NOW()
+			WHERE `+condition+` AND `+alertFilterSQL+`
+			RETURNING alert_type, metric_name
+		), rules AS (
+			UPDATE alert_rules SET state = $9, state_changed_at = NOW()
+			WHERE state = $10
+			  AND name IN (SELECT metric_name FROM changed WHERE alert_type = 'threshold')
+		)
+		SELECT COUNT(*) FROM changed
+	`, append(f.args(), AlertRuleResolved, AlertRuleFiring)...).Scan(&n)
+	if err != nil {
+		return 0, fmt.Errorf("%s alerts: %w", action, err)
+	}
+	return n, nil
+}
+
+// AlertDayStats counts the alerts raised on a day (UTC)
+type AlertDayStats struct {
+	Day          time.Time `json:"day"`
+	Alerts       int64     `json:"alerts"`
+	Critical     int64     `json:"critical"`
+	Warning      int64     `json:"warning"`
+	Info         int64     `json:"info"`
+	Acknowledged int64     `json:"acknowledged"`
+	Resolved     int64     `json:"resolved"`
+}
+
+// GetAlertDailyStats counts the alerts matching f per day, oldest first
+func (p *Postgres) GetAlertDailyStats(ctx context.Context, f AlertFilter) ([]AlertDayStats, error) {
+	rows, err := p.pool.Query(ctx, `
+		SELECT time_bucket('1 day', time) AS day,
+		       COUNT(*),
+		       COUNT(*) FILTER (WHERE severity = 'critical'),
+		       COUNT(*) FILTER (WHERE severity = 'warning'),
+		       COUNT(*) FILTER (WHERE severity = 'info'),
+		       COUNT(*) FILTER (WHERE acknowledged),
+		       COUNT(resolved_at)
+		FROM alert_events
+		WHERE `+alertFilterSQL+`
+		GROUP BY day
+		ORDER BY day
+	`, f.args()...)
+	if err != nil {
+		return nil, fmt.Errorf("query alert daily stats: %w", err)
+	}
+	defer rows.Close()
+
+	var result []AlertDayStats
+	for rows.Next() {
+		var d AlertDayStats
+		if err := rows.Scan(&d.Day, &d.Alerts, &d.Critical, &d.Warning, &d.Info, &d.Acknowledged, &d.Resolved); err != nil {
+			return nil, fmt.Errorf("scan row: %w", err)
+		}
+		result = append(result, d)
+	}
+
+	return result, rows.Err()
+}
+
+// AlertResponseGroups are the columns alert response times can be grouped by
+var AlertResponseGroups = map[string]string{
+	"alert_type":   "alert_type",
+	"severity":     "severity",
+	"source_table": "source_table",
+	"metric_name":  "metric_name",
+	"target":       "target",
+	"site_id":      "site_id",
+}
+
+// AlertResponseStats are the response times of alerts, in seconds: mean
+// time to acknowledge (MTTA) and to resolve (MTTR). Times are nil without
+// acknowledged or resolved alerts; alerts acknowledged before
+// acknowledged_at was recorded count as acknowledged but not for MTTA.
+type AlertResponseStats struct {
+	Group         string   `json:"group,omitempty"` // Value of the grouping column
+	Alerts        int64    `json:"alerts"`
+	Acknowledged  int64    `json:"acknowledged"`
+	Resolved      int64    `json:"resolved"`
+	MTTASeconds   *float64 `json:"mtta_seconds"`
+	MTTRSeconds   *float64 `json:"mttr_seconds"`
+	P50TTRSeconds *float64 `json:"p50_ttr_seconds"`
+	P90TTRSeconds *float64 `json:"p90_ttr_seconds"`
+}
+
+// GetAlertResponseStats returns the MTTA and MTTR of the alerts matching f,
+// overall or per value of a column of AlertResponseGroups, most alerts first
+func (p *Postgres) GetAlertResponseStats(ctx context.Context, f AlertFilter, by string) ([]AlertResponseStats, error) {
+	group := `''`
+	if by != "" {
+		column, ok := AlertResponseGroups[by]
+		if !ok {
+			return nil, fmt.Errorf("unknown alert grouping %q", by)
+		}
+		group = `COALESCE(` + column + `, '')`
+	}
+	rows, err := p.pool.Query(ctx, `
+		SELECT `+group+` AS grp,
+		       COUNT(*),
+		       COUNT(*) FILTER (WHERE acknowledged),
+		       COUNT(resolved_at),
+		       AVG(EXTRACT(EPOCH FROM acknowledged_at - time))::float8,
+		       AVG(EXTRACT(EPOCH FROM resolved_at - time))::float8,
+		       percentile_cont(0.5) WITHIN GROUP (ORDER BY EXTRACT(EPOCH FROM resolved_at - time)),
+		       percentile_cont(0.9) WITHIN GROUP (ORDER BY EXTRACT(EPOCH FROM resolved_at - time))
+		FROM alert_events
+		WHERE `+alertFilterSQL+`
+		GROUP BY grp
+		ORDER BY COUNT(*) DESC
+		LIMIT 100
+	`, f.args()...)
+	if err != nil {
+		return nil, fmt.Errorf("query alert response stats: %w", err)
+	}
+	defer rows.Close()
+
+	var result []AlertResponseStats
+	for rows.Next() {
+		var s AlertResponseStats
+		if err := rows.Scan(&s.Group, &s.Alerts, &s.Acknowledged, &s.Resolved,
+			&s.MTTASeconds, &s.MTTRSeconds, &s.P50TTRSeconds, &s.P90TTRSeconds); err != nil {
+			return nil, fmt.Errorf("scan row: %w", err)
+		}
+		result = append(result, s)
+	}
+
+	return result, rows.Err()
+}
+
+// CSPViolationRow represents CSP reports aggregated per directive and blocked URI
+type CSPViolationRow struct {
+	EffectiveDirective string    `json:"effective_directive"`
+	BlockedURI         string    `json:"blocked_uri"`
+	Disposition        string    `json:"disposition"`
+	ReportCount        int64     `json:"report_count"`
+	DocumentCount      int64     `json:"document_count"`
+	FirstSeen          time.Time `json:"first_seen"`
+	LastSeen           time.Time `json:"last_seen"`
+	SampleDocumentURI  string    `json:"sample_document_uri"`
+}
+
+// GetCSPViolations retrieves CSP reports grouped by directive and blocked URI
+func (p *Postgres) GetCSPViolations(ctx context.Context, start time.Time, directive string) ([]CSPViolationRow, error) {
+	query := `
+		SELECT effective_directive, COALESCE(blocked_uri, ''), COALESCE(disposition, ''),
+		       COUNT(*), COUNT(DISTINCT document_uri),
+		       MIN(time), MAX(time), COALESCE(MAX(document_uri), '')
+		FROM csp_reports
+		WHERE time >= $1 AND ($2 = '' OR effective_directive = $2)
+		GROUP BY effective_directive, blocked_uri, disposition
+		ORDER BY COUNT(*) DESC
+		LIMIT 500
+	`
+
+	rows, err := p.pool.Query(ctx, query, start, directive)
+	if err != nil {
+		return nil, fmt.Errorf("query csp_reports: %w", err)
+	}
+	defer rows.Close()
+
+	var result []CSPViolationRow
+	for rows.Next() {
+		var r CSPViolationRow
+		if err := rows.Scan(
+			&r.EffectiveDirective, &r.BlockedURI, &r.Disposition,
+			&r.ReportCount, &r.DocumentCount,
+			&r.FirstSeen, &r.LastSeen, &r.SampleDocumentURI,
+		); err != nil {
+			return nil, fmt.Errorf("scan row: %w", err)
+		}
+		result = append(result, r)
+	}
+
+	return result, rows.Err()
+}
+
+// StabilityRow represents crash-free rates for a release and platform
+type StabilityRow struct {
+	Release              string  `json:"release"`
+	Platform             string  `json:"platform"`
+	Sessions             int64   `json:"sessions"`
+	CrashedSessions      int64   `json:"crashed_sessions"`
+	Users                int64   `json:"users"`
+	CrashedUsers         int64   `json:"crashed_users"`
+	CrashFreeSessionsPct float64 `json:"crash_free_sessions_pct"`
+	CrashFreeUsersPct    float64 `json:"crash_free_users_pct"`
+}
+
+// GetStability computes crash-free sessions and users per release and platform.
+// Events without an explicit platform fall back to device_type. deviceType
+// and country restrict the events counted unless empty.
+func (p *Postgres) GetStability(ctx context.Context, start time.Time, release, deviceType, country string, sites []string) ([]StabilityRow, error) {
+	query := `
+		SELECT COALESCE(release, 'unknown'),
+		       COALESCE(platform, device_type, 'unknown'),
+		       COUNT(DISTINCT session_id),
+		       COUNT(DISTINCT session_id) FILTER (WHERE event_type IN ($3, $4)),
+		       COUNT(DISTINCT player_id),
+		       COUNT(DISTINCT player_id) FILTER (WHERE event_type IN ($3, $4))
+		FROM frontend_metrics
+		WHERE time >= $1 AND ($2 = '' OR release = $2)
+		  AND ($5::text[] IS NULL OR site_id = ANY($5))
+		  AND ($6 = '' OR device_type = $6)
+		  AND ($7 = '' OR country = $7)
+		GROUP BY 1, 2
+		ORDER BY 1 DESC, 2
+	`
+
+	rows, err := p.pool.Query(ctx, query, start, release, model.EventTypeCrash, model.EventTypeFatalError, sites, deviceType, country)
+	if err != nil {
+		return nil, fmt.Errorf("query stability: %w", err)
+	}
+	defer rows.Close()
+
+	var result []StabilityRow
+	for rows.Next() {
+		var r StabilityRow
+		if err := rows.Scan(
+			&r.Release, &r.Platform,
+			&r.Sessions, &r.CrashedSessions,
+			&r.Users, &r.CrashedUsers,
+		); err != nil {
+			return nil, fmt.Errorf("scan row: %w", err)
+		}
+		r.CrashFreeSessionsPct = crashFreePct(r.Sessions, r.CrashedSessions)
+		r.CrashFreeUsersPct = crashFreePct(r.Users, r.CrashedUsers)
+		result = append(result, r)
+	}
+
+	return result, rows.Err()
+}
+
+func crashFreePct(total, crashed int64) float64 {
+	if total == 0 {
+		return 100
+	}
+	return float64(total-crashed) / float64(total) * 100
+}
+
+// InsertAlert records a new alert event
+func (p *Postgres) InsertAlert(ctx context.Context, alert AlertRow) error {
+	_, err := p.pool.Exec(ctx, `
+		INSERT INTO alert_events (
+			time, alert_type, severity, source_table, metric_name,
+			threshold_value, actual_value, message, target, site_id
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NULLIF($9, ''), NULLIF($10, ''))
+	`, alert.Time, alert.AlertType, alert.Severity, alert.SourceTable, alert.MetricName,
+		alert.ThresholdValue, alert.ActualValue, alert.Message, alert.Target, alert.SiteID)
+	return err
+}
+
+// HasOpenAlert reports whether an unresolved alert exists for the given type and metric
+func (p *Postgres) HasOpenAlert(ctx context.Context, alertType, metricName string) (bool, error) {
+	var exists bool
+	err := p.pool.QueryRow(ctx, `
+		SELECT EXISTS (
+			SELECT 1 FROM alert_events
+			WHERE alert_type = $1 AND metric_name = $2 AND resolved_at IS NULL
+		)
+	`, alertType, metricName).Scan(&exists)
+	return exists, err
+}
+
+// ResolveAlerts marks all open alerts for the given type and metric as resolved
+func (p *Postgres) ResolveAlerts(ctx context.Context, alertType, metricName string) error {
+	_, err := p.ResolveOpenAlerts(ctx, alertType, metricName)
+	return err
+}
+
+// ResolveOpenAlerts resolves like ResolveAlerts and returns the alerts it
+// resolved, so they can be announced
+func (p *Postgres) ResolveOpenAlerts(ctx context.Context, alertType, metricName string) ([]AlertRow, error) {
+	rows, err := p.pool.Query(ctx, `
+		UPDATE alert_events
+		SET resolved_at = NOW()
+		WHERE alert_type = $1 AND metric_name = $2 AND resolved_at IS NULL
+		RETURNING id, time, alert_type, severity, COALESCE(source_table, ''),
+		          COALESCE(metric_name, ''), COALESCE(threshold_value, 0),
+		          COALESCE(actual_value, 0), acknowledged, acknowledged_at, resolved_at, COALESCE(message, ''),
+		          COALESCE(target, ''), COALESCE(site_id, '')
+	`, alertType, metricName)
+	if err != nil {
+		return nil, fmt.Errorf("resolve alerts: %w", err)
+	}
+	defer rows.Close()
+
+	var result []AlertRow
+	for rows.Next() {
+		var r AlertRow
+		if err := rows.Scan(
+			&r.ID, &r.Time, &r.AlertType, &r.Severity, &r.SourceTable,
+			&r.MetricName, &r.ThresholdValue, &r.ActualValue,
+			&r.Acknowledged, &r.AcknowledgedAt, &r.ResolvedAt, &r.Message, &r.Target, &r.SiteID,
+		); err != nil {
+			return nil, fmt.Errorf("scan row: %w", err)
+		}
+		result = append(result, r)
+	}
+
+	return result, rows.Err()
+}
+
+// ============================================
+// PRODUCER REGISTRY
+// ============================================
+
+// UpsertProducer registers a producer or refreshes its registration
+func (p *Postgres) UpsertProducer(ctx context.Context, producer model.Producer) error {
+	_, err := p.pool.Exec(ctx, `
+		INSERT INTO producers (name, owner_team, sdk_version, site_id, metric_types, registered_at, last_seen_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $6)
+		ON CONFLICT (name) DO UPDATE SET
+			owner_team = EXCLUDED.owner_team,
+			sdk_version = EXCLUDED.sdk_version,
+			site_id = EXCLUDED.site_id,
+			metric_types = EXCLUDED.metric_types,
+			registered_at = EXCLUDED.registered_at,
+			last_seen_at = GREATEST(producers.last_seen_at, EXCLUDED.last_seen_at)
+	`, producer.Name, producer.OwnerTeam, producer.SDKVersion, producer.SiteID,
+		producer.MetricTypes, producer.RegisteredAt)
+	return err
+}
+
+// ProducerActivity is the last observed activity of a producer
+type ProducerActivity struct {
+	Name        string
+	SiteID      string
+	SDKVersion  string // From the X-Pulse-SDK header, empty if not sent
+	MetricTypes []string
+	LastSeenAt  time.Time
+}
+
+// TouchProducers records collect activity. Unregistered producers are
+// created on first sight so unowned senders show up in the registry.
+func (p *Postgres) TouchProducers(ctx context.Context, activity []ProducerActivity) error {
+	if len(activity) == 0 {
+		return nil
+	}
+
+	batch := &pgx.Batch{}
+	for _, a := range activity {
+		batch.Queue(`
+			INSERT INTO producers (name, site_id, sdk_version, observed_types, last_seen_at)
+			VALUES ($1, $2, NULLIF($3, ''), $4, $5)
+			ON CONFLICT (name) DO UPDATE SET
+				site_id = COALESCE(NULLIF(producers.site_id, ''), EXCLUDED.site_id),
+				sdk_version = COALESCE(EXCLUDED.sdk_version, producers.sdk_version),
+				observed_types = ARRAY(
+					SELECT DISTINCT unnest(producers.observed_types || EXCLUDED.observed_types)
+					ORDER BY 1
+				),
+				last_seen_at = GREATEST(producers.last_seen_at, EXCLUDED.last_seen_at)
+		`, a.Name, a.SiteID, a.SDKVersion, a.MetricTypes, a.LastSeenAt)
+	}
+
+	return p.pool.SendBatch(ctx, batch).Close()
+}
+
+// GetProducers lists registered and observed producers of sites (nil for
+// all sites)
+func (p *Postgres) GetProducers(ctx context.Context, sites []string) ([]model.Producer, error) {
+	rows, err := p.pool.Query(ctx, `
+		SELECT name, COALESCE(owner_team, ''), COALESCE(sdk_version, ''), COALESCE(site_id, ''),
+		       COALESCE(metric_types, '{}'), COALESCE(observed_types, '{}'),
+		       COALESCE(registered_at, 'epoch'), COALESCE(last_seen_at, 'epoch')
+		FROM producers
+		WHERE ($1::text[] IS NULL OR site_id = ANY($1))
+		ORDER BY last_seen_at DESC NULLS LAST, name
+	`, sites)
+	if err != nil {
+		return nil, fmt.Errorf("query producers: %w", err)
+	}
+	defer rows.Close()
+
+	var result []model.Producer
+	for rows.Next() {
+		var r model.Producer
+		if err := rows.Scan(
+			&r.Name, &r.OwnerTeam, &r.SDKVersion, &r.SiteID,
+			&r.MetricTypes, &r.ObservedTypes,
+			&r.RegisteredAt, &r.LastSeenAt,
+		); err != nil {
+			return nil, fmt.Errorf("scan row: %w", err)
+		}
+		result = append(result, r)
+	}
+
+	return result, rows.Err()
+}
+
+// ============================================
+// SDK VERSIONS
+// ============================================
+
+// SDKUsage counts collect requests per SDK version on one day
+type SDKUsage struct {
+	Day        time.Time
+	SDK        string
+	Version    string
+	SiteID     string
+	Producer   string
+	Requests   int64
+	LastSeenAt time.Time
+}
+
+// RecordSDKUsage adds request counts to the daily sdk_usage rollup
+func (p *Postgres) RecordSDKUsage(ctx context.Context, usage []SDKUsage) error {
+	if len(usage) == 0 {
+		return nil
+	}
+
+	batch := &pgx.Batch{}
+	for _, u := range usage {
+		batch.Queue(`
+			INSERT INTO sdk_usage (day, sdk, version, site_id, producer, requests, last_seen_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)
+			ON CONFLICT (day, sdk, version, site_id, producer) DO UPDATE SET
+				requests = sdk_usage.requests + EXCLUDED.requests,
+				last_seen_at = GREATEST(sdk_usage.last_seen_at, EXCLUDED.last_seen_at)
+		`, u.Day, u.SDK, u.Version, u.SiteID, u.Producer, u.Requests, u.LastSeenAt)
+	}
+
+	return p.pool.SendBatch(ctx, batch).Close()
+}
+
+// SDKVersionRow is one SDK version in the version distribution
+type SDKVersionRow struct {
+	SDK        string    `json:"sdk"`
+	Version    string    `json:"version"`
+	Requests   int64     `json:"requests"`
+	SharePct   float64   `json:"share_pct"` // Share of requests for this SDK
+	Producers  []string  `json:"producers"`
+	Sites      []string  `json:"sites"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	Deprecated bool      `json:"deprecated"`
+}
+
+// GetSDKVersions returns request counts per SDK version since start, of
+// sites (nil for all sites)
+func (p *Postgres) GetSDKVersions(ctx context.Context, start time.Time, sites []string) ([]SDKVersionRow, error) {
+	rows, err := p.pool.Query(ctx, `
+		SELECT
+			sdk,
+			version,
+			SUM(requests)::bigint AS requests,
+			COALESCE(ROUND((SUM(requests) * 100.0 / NULLIF(SUM(SUM(requests)) OVER (PARTITION BY sdk), 0))::numeric, 2), 0)::float8 AS share_pct,
+			COALESCE(array_agg(DISTINCT producer) FILTER (WHERE producer <> ''), '{}') AS producers,
+			COALESCE(array_agg(DISTINCT site_id) FILTER (WHERE site_id <> ''), '{}') AS sites,
+			MAX(last_seen_at) AS last_seen_at
+		FROM sdk_usage
+		WHERE day >= $1::date AND ($2::text[] IS NULL OR site_id = ANY($2))
+		GROUP BY sdk, version
+		ORDER BY sdk, requests DESC
+	`, start, sites)
+	if err != nil {
+		return nil, fmt.Errorf("query sdk versions: %w", err)
+	}
+	defer rows.Close()
+
+	var result []SDKVersionRow
+	for rows.Next() {
+		var r SDKVersionRow
+		if err := rows.Scan(&r.SDK, &r.Version, &r.Requests, &r.SharePct, &r.Producers, &r.Sites, &r.LastSeenAt); err != nil {
+			return nil, fmt.Errorf("scan row: %w", err)
+		}
+		result = append(result, r)
+	}
+
+	return result, rows.Err()
+}
+
+// ============================================
+// ROLLUPS
+// ============================================
+
+// RefreshRollup re-materializes a continuous aggregate for [start, end).
+// The bounds must be aligned to the aggregate's bucket width.
+func (p *Postgres) RefreshRollup(ctx context.Context, view string, start, end time.Time) error {
+	_, err := p.pool.Exec(ctx,
+		`CALL refresh_continuous_aggregate($1::regclass, $2::timestamptz, $3::timestamptz)`,
+		view, start, end)
+	if err != nil {
+		return fmt.Errorf("refresh %s: %w", view, err)
+	}
+	return nil
+}
+
+// ============================================
+// SCHEDULED JOBS
+// ============================================
+
+// JobRow is a persisted job definition with the outcome of its last run
+type JobRow struct {
+	Name                string     `json:"name"`
+	Schedule            string     `json:"schedule"`
+	Paused              bool       `json:"paused"`
+	LastRunAt           *time.Time `json:"last_run_at,omitempty"`
+	LastStatus          *string    `json:"last_status,omitempty"`
+	LastError           *string    `json:"last_error,omitempty"`
+	LastDurationMS      *int64     `json:"last_duration_ms,omitempty"`
+	RunCount            int64      `json:"run_count"`
+	FailureCount        int64      `json:"failure_count"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+}
+
+// JobRun is the outcome of one job run
+type JobRun struct {
+	Name     string
+	Start    time.Time
+	Duration time.Duration
+	Err      error
+}
+
+const jobColumns = `name, schedule, paused, last_run_at, last_status, last_error,
+	last_duration_ms, run_count, failure_count, consecutive_failures`
+
+func scanJob(row pgx.Row) (JobRow, error) {
+	var j JobRow
+	err := row.Scan(&j.Name, &j.Schedule, &j.Paused, &j.LastRunAt, &j.LastStatus, &j.LastError,
+		&j.LastDurationMS, &j.RunCount, &j.FailureCount, &j.ConsecutiveFailures)
+	return j, err
+}
+
+// EnsureJob creates or updates a job definition and returns it; the paused
+// flag and run history of an existing job are kept
+func (p *Postgres) EnsureJob(ctx context.Context, name, schedule string) (JobRow, error) {
+	j, err := scanJob(p.pool.QueryRow(ctx, `
+		INSERT INTO scheduled_jobs (name, schedule, updated_at)
+		VALUES ($1, $2, NOW())
+		ON CONFLICT (name) DO UPDATE SET
+			schedule = EXCLUDED.schedule,
+			updated_at = NOW()
+		RETURNING `+jobColumns,
+		name, schedule))
+	if err != nil {
+		return j, fmt.Errorf("ensure job %s: %w", name, err)
+	}
+	return j, nil
+}
+
+// RecordJobRun stores the outcome of a run and returns the updated job
+func (p *Postgres) RecordJobRun(ctx context.Context, run JobRun) (JobRow, error) {
+	status := "ok"
+	var errMsg *string
+	if run.Err != nil {
+		status = "failed"
+		msg := run.Err.Error()
+		errMsg = &msg
+	}
+
+	j, err := scanJob(p.pool.QueryRow(ctx, `
+		UPDATE scheduled_jobs SET
+			last_run_at = $2,
+			last_status = $3,
+			last_error = $4,
+			last_duration_ms = $5,
+			run_count = run_count + 1,
+			failure_count = failure_count + CASE WHEN $4::text IS NULL THEN 0 ELSE 1 END,
+			consecutive_failures = CASE WHEN $4::text IS NULL THEN 0 ELSE consecutive_failures + 1 END,
+			updated_at = NOW()
+		WHERE name = $1
+		RETURNING `+jobColumns,
+		run.Name, run.Start, status, errMsg, run.Duration.Milliseconds()))
+	if err != nil {
+		return j, fmt.Errorf("record job run %s: %w", run.Name, err)
+	}
+	return j, nil
+}
+
+// SetJobPaused pauses or resumes a job
+func (p *Postgres) SetJobPaused(ctx context.Context, name string, paused bool) error {
+	_, err := p.pool.Exec(ctx, `
+		UPDATE scheduled_jobs SET paused = $2, updated_at = NOW() WHERE name = $1
+	`, name, paused)
+	if err != nil {
+		return fmt.Errorf("update job %s: %w", name, err)
+	}
+	return nil
+}
+
+// GetJobs returns all job definitions ordered by name
+func (p *Postgres) GetJobs(ctx context.Context) ([]JobRow, error) {
+	rows, err := p.pool.Query(ctx, `SELECT `+jobColumns+` FROM scheduled_jobs ORDER BY name`)
+	if err != nil {
+		return nil, fmt.Errorf("query jobs: %w", err)
+	}
+	defer rows.Close()
+
+	var result []JobRow
+	for rows.Next() {
+		j, err := scanJob(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan row: %w", err)
+		}
+		result = append(result, j)
+	}
+
+	return result, rows.Err()
+}
+
+// ============================================
+// SITE CREDENTIALS
+// ============================================
+
+// Credential kinds
+const (
+	CredentialAPIKey     = "api_key"
+	CredentialHMACSecret = "hmac_secret"
+)
+
+// ErrCredentialNotFound is returned for unknown site credentials
+var ErrCredentialNotFound = errors.New("credential not found")
+
+// SiteCredential is an API key or HMAC signing secret of a site. API keys
+// are stored as SHA-256 hashes; signing secrets are needed to verify
+// signatures and are stored as issued. A credential with Scopes may only
+// send those metric types; without, it may send all.
+type SiteCredential struct {
+	ID         int64      `json:"id"`
+	SiteID     string     `json:"site_id"`
+	Kind       string     `json:"kind"`
+	Scopes     []string   `json:"scopes"`
+	Prefix     string     `json:"prefix"`
+	KeyHash    string     `json:"-"`
+	Secret     string     `json:"-"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	UseCount   int64      `json:"use_count"`
+}
+
+// CredentialUsage is the aggregated use of a credential since the last flush
+type CredentialUsage struct {
+	ID         int64
+	Count      int64
+	LastUsedAt time.Time
+}
+
+const credentialColumns = `id, site_id, kind, scopes, prefix, COALESCE(key_hash, ''), COALESCE(signing_secret, ''),
+	created_at, expires_at, revoked_at, last_used_at, use_count`
+
+func scanCredential(row pgx.Row) (SiteCredential, error) {
+	var c SiteCredential
+	err := row.Scan(&c.ID, &c.SiteID, &c.Kind, &c.Scopes, &c.Prefix, &c.KeyHash, &c.Secret,
+		&c.CreatedAt, &c.ExpiresAt, &c.RevokedAt, &c.LastUsedAt, &c.UseCount)
+	return c, err
+}
+
+// RotateCredential stores a new credential. Active credentials of the same
+// site, kind and scopes stay valid until graceEnd and expire after that.
+// Scopes must be sorted.
+func (p *Postgres) RotateCredential(ctx context.Context, cred SiteCredential, graceEnd time.Time) (SiteCredential, error) {
+	if cred.Scopes == nil {
+		cred.Scopes = []string{}
+	}
+	c, err := scanCredential(p.pool.QueryRow(ctx, `
+		WITH expired AS (
+			UPDATE site_credentials SET expires_at = LEAST(expires_at, $6)
+			WHERE site_id = $1 AND kind = $2 AND scopes = $7 AND revoked_at IS NULL
+			  AND (expires_at IS NULL OR expires_at > NOW())
+		)
+		INSERT INTO site_credentials (site_id, kind, scopes, prefix, key_hash, signing_secret, created_at)
+		VALUES ($1, $2, $7, $3, NULLIF($4, ''), NULLIF($5, ''), NOW())
+		RETURNING `+credentialColumns,
+		cred.SiteID, cred.Kind, cred.Prefix, cred.KeyHash, cred.Secret, graceEnd, cred.Scopes))
+	if err != nil {
+		return c, fmt.Errorf("rotate credential: %w", err)
+	}
+	return c, nil
+}
+
+// GetCredentials lists all credentials of a site, newest first
+func (p *Postgres) GetCredentials(ctx context.Context, siteID string) ([]SiteCredential, error) {
+	return p.queryCredentials(ctx, `
+		SELECT `+credentialColumns+` FROM site_credentials
+		WHERE site_id = $1
+		ORDER BY created_at DESC
+	`, siteID)
+}
+
+// GetCredential returns a credential of a site, or ErrCredentialNotFound
+func (p *Postgres) GetCredential(ctx context.Context, siteID string, id int64) (SiteCredential, error) {
+	c, err := scanCredential(p.pool.QueryRow(ctx, `
+		SELECT `+credentialColumns+` FROM site_credentials
+		WHERE id = $1 AND site_id = $2
+	`, id, siteID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return c, ErrCredentialNotFound
+	}
+	if err != nil {
+		return c, fmt.Errorf("query credential %d: %w", id, err)
+	}
+	return c, nil
+}
+
+// GetActiveCredentials returns credentials that are neither revoked nor
+// expired, for all sites
+func (p *Postgres) GetActiveCredentials(ctx context.Context) ([]SiteCredential, error) {
+	return p.queryCredentials(ctx, `
+		SELECT `+credentialColumns+` FROM site_credentials
+		WHERE revoked_at IS NULL AND (expires_at IS NULL OR expires_at > NOW())
+	`)
+}
+
+func (p *Postgres) queryCredentials(ctx context.Context, sql string, args ...any) ([]SiteCredential, error) {
+	rows, err := p.pool.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query credentials: %w", err)
+	}
+	defer rows.Close()
+
+	var result []SiteCredential
+	for rows.Next() {
+		c, err := scanCredential(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan row: %w", err)
+		}
+		result = append(result, c)
+	}
+
+	return result, rows.Err()
+}
+
+// RevokeCredential revokes a credential immediately. It returns false if
+// the site has no such credential or it was already revoked.
+func (p *Postgres) RevokeCredential(ctx context.Context, siteID string, id int64) (bool, error) {
+	tag, err := p.pool.Exec(ctx, `
+		UPDATE site_credentials SET revoked_at = NOW()
+		WHERE id = $1 AND site_id = $2 AND revoked_at IS NULL
+	`, id, siteID)
+	if err != nil {
+		return false, fmt.Errorf("revoke credential %d: %w", id, err)
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// RecordCredentialUsage adds aggregated usage counts
+func (p *Postgres) RecordCredentialUsage(ctx context.Context, usage []CredentialUsage) error {
+	if len(usage) == 0 {
+		return nil
+	}
+
+	batch := &pgx.Batch{}
+	for _, u := range usage {
+		batch.Queue(`
+			UPDATE site_credentials SET
+				use_count = use_count + $2,
+				last_used_at = GREATEST(last_used_at, $3)
+			WHERE id = $1
+		`, u.ID, u.Count, u.LastUsedAt)
+	}
+
+	return p.pool.SendBatch(ctx, batch).Close()
+}
+
+// ============================================
+// SERVICE ACCOUNTS
+// ============================================
+
+// ServiceAccount is a credential for an internal service, limited to the
+// collect endpoints in Scopes. Only the SHA-256 hash of its token is stored.
+type ServiceAccount struct {
+	ID         int64      `json:"id"`
+	Name       string     `json:"name"`
+	SiteID     string     `json:"site_id,omitempty"` // Empty if not bound to a site
+	Scopes     []string   `json:"scopes"`
+	Prefix     string     `json:"prefix"`
+	TokenHash  string     `json:"-"`
+	CreatedAt  time.Time  `json:"created_at"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	UseCount   int64      `json:"use_count"`
+}
+
+// ErrServiceAccountExists is returned when a service account name is taken.
+// It matches ErrConflict.
+var ErrServiceAccountExists = fmt.Errorf("service account already exists: %w", ErrConflict)
+
+const serviceAccountColumns = `id, name, COALESCE(site_id, ''), scopes, prefix, token_hash,
+	created_at, revoked_at, last_used_at, use_count`
+
+func scanServiceAccount(row pgx.Row) (ServiceAccount, error) {
+	var a ServiceAccount
+	err := row.Scan(&a.ID, &a.Name, &a.SiteID, &a.Scopes, &a.Prefix, &a.TokenHash,
+		&a.CreatedAt, &a.RevokedAt, &a.LastUsedAt, &a.UseCount)
+	return a, err
+}
+
+// CreateServiceAccount stores a new service account
+func (p *Postgres) CreateServiceAccount(ctx context.Context, account ServiceAccount) (ServiceAccount, error) {
+	a, err := scanServiceAccount(p.pool.QueryRow(ctx, `
+		INSERT INTO service_accounts (name, site_id, scopes, prefix, token_hash, created_at)
+		VALUES ($1, NULLIF($2, ''), $3, $4, $5, NOW())
+		RETURNING `+serviceAccountColumns,
+		account.Name, account.SiteID, account.Scopes, account.Prefix, account.TokenHash))
+	if errors.Is(classify(err), ErrConflict) {
+		return a, ErrServiceAccountExists
+	}
+	if err != nil {
+		return a, fmt.Errorf("create service account %s: %w", account.Name, err)
+	}
+	return a, nil
+}
+
+// GetServiceAccounts lists all service accounts, including revoked ones
+func (p *Postgres) GetServiceAccounts(ctx context.Context) ([]ServiceAccount, error) {
+	return p.queryServiceAccounts(ctx, `
+		SELECT `+serviceAccountColumns+` FROM service_accounts ORDER BY name
+	`)
+}
+
+// GetActiveServiceAccounts returns service accounts that are not revoked
+func (p *Postgres) GetActiveServiceAccounts(ctx context.Context) ([]ServiceAccount, error) {
+	return p.queryServiceAccounts(ctx, `
+		SELECT `+serviceAccountColumns+` FROM service_accounts WHERE revoked_at IS NULL
+	`)
+}
+
+func (p *Postgres) queryServiceAccounts(ctx context.Context, sql string, args ...any) ([]ServiceAccount, error) {
+	rows, err := p.pool.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query service accounts: %w", err)
+	}
+	defer rows.Close()
+
+	var result []ServiceAccount
+	for rows.Next() {
+		a, err := scanServiceAccount(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan row: %w", err)
+		}
+		result = append(result, a)
+	}
+
+	return result, rows.Err()
+}
+
+// SetServiceAccountScopes replaces the scopes of an active service account.
+// It returns false if there is no such account.
+func (p *Postgres) SetServiceAccountScopes(ctx context.Context, id int64, scopes []string) (bool, error) {
+	tag, err := p.pool.Exec(ctx, `
+		UPDATE service_accounts SET scopes = $2 WHERE id = $1 AND revoked_at IS NULL
+	`, id, scopes)
+	if err != nil {
+		return false, fmt.Errorf("update service account %d: %w", id, err)
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// RevokeServiceAccount revokes a service account. It returns false if there
+// is no such account or it was already revoked.
+func (p *Postgres) RevokeServiceAccount(ctx context.Context, id int64) (bool, error) {
+	tag, err := p.pool.Exec(ctx, `
+		UPDATE service_accounts SET revoked_at = NOW() WHERE id = $1 AND revoked_at IS NULL
+	`, id)
+	if err != nil {
+		return false, fmt.Errorf("revoke service account %d: %w", id, err)
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// RecordServiceAccountUsage adds aggregated usage counts
+func (p *Postgres) RecordServiceAccountUsage(ctx context.Context, usage []CredentialUsage) error {
+	if len(usage) == 0 {
+		return nil
+	}
+
+	batch := &pgx.Batch{}
+	for _, u := range usage {
+		batch.Queue(`
+			UPDATE service_accounts SET
+				use_count = use_count + $2,
+				last_used_at = GREATEST(last_used_at, $3)
+			WHERE id = $1
+		`, u.ID, u.Count, u.LastUsedAt)
+	}
+
+	return p.pool.SendBatch(ctx, batch).Close()
+}
+
+// ============================================
+// COMPONENT HEALTH
+// ============================================
+
+// Component kinds with a health query
+const (
+	ComponentPSP  = "psp"
+	ComponentGame = "game"
+	ComponentAPI  = "api"
+)
+
+// componentHealthQueries read raw metrics instead of the continuous
+// aggregates, which lag by their refresh end_offset
+var componentHealthQueries = map[string]string{
+	ComponentPSP: `
+		SELECT COUNT(*), COUNT(*) FILTER (WHERE success),
+		       COALESCE(PERCENTILE_CONT(0.95) WITHIN GROUP (ORDER BY duration_ms), 0),
+		       MAX(time)
+		FROM psp_metrics
+		WHERE psp_name = $1 AND time >= $2
+	`,
+	ComponentGame: `
+		SELECT COUNT(*), COUNT(*) FILTER (WHERE launch_success),
+		       COALESCE(PERCENTILE_CONT(0.95) WITHIN GROUP (ORDER BY load_time_ms), 0),
+		       MAX(time)
+		FROM game_metrics
+		WHERE provider = $1 AND time >= $2
+	`,
+	ComponentAPI: `
+		SELECT COUNT(*), COUNT(*) FILTER (WHERE status_code < 500),
+		       COALESCE(PERCENTILE_CONT(0.95) WITHIN GROUP (ORDER BY duration_ms), 0),
+		       MAX(time)
+		FROM api_metrics
+		WHERE service_name = $1 AND time >= $2
+	`,
+}
+
+// ComponentHealthRow summarizes recent traffic of one PSP, game provider or
+// API service
+type ComponentHealthRow struct {
+	Total        int64      `json:"total"`
+	Success      int64      `json:"success"`
+	P95LatencyMS float64    `json:"p95_latency_ms"`
+	LastSeen     *time.Time `json:"last_seen,omitempty"`
+}
+
+// GetComponentHealth returns success and latency of a component since start.
+// kind is one of the Component constants.
+func (p *Postgres) GetComponentHealth(ctx context.Context, kind, name string, start time.Time) (ComponentHealthRow, error) {
+	query, ok := componentHealthQueries[kind]
+	if !ok {
+		return ComponentHealthRow{}, fmt.Errorf("unknown component kind %q", kind)
+	}
+
+	var r ComponentHealthRow
+	err := p.pool.QueryRow(ctx, query, name, start).Scan(&r.Total, &r.Success, &r.P95LatencyMS, &r.LastSeen)
+	if err != nil {
+		return ComponentHealthRow{}, fmt.Errorf("query %s health: %w", kind, err)
+	}
+	return r, nil
+}
+
+// ============================================
+// ROW COUNTS
+// ============================================
+
+// countableTables are the metric tables CountRows accepts
+var countableTables = map[string]bool{
+	"frontend_metrics":  true,
+	"api_metrics":       true,
+	"psp_metrics":       true,
+	"game_metrics":      true,
+	"websocket_metrics": true,
+}
+
+// CountRows returns the number of rows of a metric table with
+// start <= time < end
+func (p *Postgres) CountRows(ctx context.Context, table string, start, end time.Time) (int64, error) {
+	if !countableTables[table] {
+		return 0, fmt.Errorf("unknown metric table %q", table)
+	}
+
+	var n int64
+	err := p.pool.QueryRow(ctx,
+		fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE time >= $1 AND time < $2", table),
+		start, end,
+	).Scan(&n)
+	if err != nil {
+		return 0, fmt.Errorf("count %s: %w", table, err)
+	}
+	return n, nil
+}
+
+// ============================================
+// USERS AND SESSIONS
+// ============================================
+
+// User is a dashboard user. PasswordHash is empty for users who can only
+// sign in with Google. Sites are the site IDs granted to the user.
+type User struct {
+	Email        string     `json:"email"`
+	Name         string     `json:"name"`
+	Nickname     string     `json:"nickname"`
+	Role         string     `json:"role"`
+	Sites        []string   `json:"sites"`
+	Picture      string     `json:"picture"`
+	PasswordHash string     `json:"-"`
+	CreatedAt    time.Time  `json:"created_at"`
+	LastLoginAt  *time.Time `json:"last_login_at,omitempty"`
+}
+
+// ErrUserNotFound is returned when no user matches a login
+var ErrUserNotFound = errors.New("user not found")
+
+// ErrSessionNotFound is returned for unknown or expired session tokens
+var ErrSessionNotFound = errors.New("session not found")
+
+const userColumns = `u.email, u.name, u.nickname, u.role,
+	ARRAY(SELECT g.site_id FROM user_sites g WHERE g.email = u.email ORDER BY g.site_id),
+	COALESCE(u.picture, ''), COALESCE(u.password_hash, ''), u.created_at, u.last_login_at`
+
+func scanUser(row pgx.Row) (User, error) {
+	var u User
+	err := row.Scan(&u.Email, &u.Name, &u.Nickname, &u.Role, &u.Sites, &u.Picture,
+		&u.PasswordHash, &u.CreatedAt, &u.LastLoginAt)
+	return u, err
+}
+
+// SeedUser creates or updates a user from configuration (ADMIN_USERS). Name,
+// nickname, role and password always follow the configuration.
+func (p *Postgres) SeedUser(ctx context.Context, user User) error {
+	_, err := p.pool.Exec(ctx, `
+		INSERT INTO users (email, name, nickname, role, password_hash, created_at)
+		VALUES ($1, $2, $3, $4, NULLIF($5, ''), NOW())
+		ON CONFLICT (email) DO UPDATE SET
+			name = EXCLUDED.name,
+			nickname = EXCLUDED.nickname,
+			role = EXCLUDED.role,
+			password_hash = EXCLUDED.password_hash
+	`, user.Email, user.Name, user.Nickname, user.Role, user.PasswordHash)
+	if err != nil {
+		return fmt.Errorf("seed user %s: %w", user.Email, err)
+	}
+	return nil
+}
+
+// RecordSignIn stores a sign-in through an identity provider. New users are
+// created with the given role and nickname; existing users keep theirs and
+// only get their name and picture refreshed.
+func (p *Postgres) RecordSignIn(ctx context.Context, user User) (User, error) {
+	u, err := scanUser(p.pool.QueryRow(ctx, `
+		INSERT INTO users AS u (email, name, nickname, role, picture, created_at, last_login_at)
+		VALUES ($1, $2, $3, $4, NULLIF($5, ''), NOW(), NOW())
+		ON CONFLICT (email) DO UPDATE SET
+			name = EXCLUDED.name,
+			picture = EXCLUDED.picture,
+			last_login_at = NOW()
+		RETURNING `+userColumns,
+		user.Email, user.Name, user.Nickname, user.Role, user.Picture))
+	if err != nil {
+		return u, fmt.Errorf("record sign-in of %s: %w", user.Email, err)
+	}
+	return u, nil
+}
+
+// GetUserByLogin finds a user by email or nickname, case-insensitively
+func (p *Postgres) GetUserByLogin(ctx context.Context, login string) (User, error) {
+	u, err := scanUser(p.pool.QueryRow(ctx, `
+		SELECT `+userColumns+` FROM users u
+		WHERE LOWER(u.email) = LOWER($1) OR LOWER(u.nickname) = LOWER($1)
+		ORDER BY LOWER(u.email) = LOWER($1) DESC
+		LIMIT 1
+	`, login))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return u, ErrUserNotFound
+	}
+	if err != nil {
+		return u, fmt.Errorf("get user %s: %w", login, err)
+	}
+	return u, nil
+}
+
+// TouchUserLogin sets the last login time of a user
+func (p *Postgres) TouchUserLogin(ctx context.Context, email string) error {
+	_, err := p.pool.Exec(ctx, `UPDATE users SET last_login_at = NOW() WHERE email = $1`, email)
+	if err != nil {
+		return fmt.Errorf("touch user %s: %w", email, err)
+	}
+	return nil
+}
+
+// Session is a dashboard login: a short-lived access token and a refresh
+// token that replaces both. Only the SHA-256 hashes of the tokens are kept.
+type Session struct {
+	TokenHash        string
+	RefreshHash      string
+	Email            string
+	ExpiresAt        time.Time // Access token expiry
+	RefreshExpiresAt time.Time
+	Binding          SessionBinding
+}
+
+// SessionBinding is the client a session belongs to. Empty fields, e.g. of
+// sessions created before binding was recorded, match any client.
+type SessionBinding struct {
+	ClientNet     string // IP prefix of the client, e.g. 203.0.113.0/24
+	UserAgentHash string // SHA-256 of the client's User-Agent
+}
+
+// scanSessionUser scans userColumns followed by the session's binding
+func scanSessionUser(row pgx.Row) (User, SessionBinding, error) {
+	var u User
+	var b SessionBinding
+	err := row.Scan(&u.Email, &u.Name, &u.Nickname, &u.Role, &u.Sites, &u.Picture,
+		&u.PasswordHash, &u.CreatedAt, &u.LastLoginAt, &b.ClientNet, &b.UserAgentHash)
+	return u, b, err
+}
+
+// CreateSession stores a session
+func (p *Postgres) CreateSession(ctx context.Context, s Session) error {
+	_, err := p.pool.Exec(ctx, `
+		INSERT INTO sessions (token_hash, refresh_hash, email, created_at, expires_at, refresh_expires_at,
+			client_net, user_agent_hash)
+		VALUES ($1, $2, $3, NOW(), $4, $5, NULLIF($6, ''), NULLIF($7, ''))
+	`, s.TokenHash, s.RefreshHash, s.Email, s.ExpiresAt, s.RefreshExpiresAt,
+		s.Binding.ClientNet, s.Binding.UserAgentHash)
+	if err != nil {
+		return fmt.Errorf("create session for %s: %w", s.Email, err)
+	}
+	return nil
+}
+
+// RefreshSession replaces the tokens and binding of the session with an
+// unexpired refresh token and extends it, returning its user and its
+// binding before the refresh. The old tokens stop working; unknown or
+// expired refresh tokens give ErrSessionNotFound.
+func (p *Postgres) RefreshSession(ctx context.Context, refreshHash string, next Session) (User, SessionBinding, error) {
+	u, b, err := scanSessionUser(p.pool.QueryRow(ctx, `
+		WITH old AS (
+			SELECT token_hash, client_net, user_agent_hash
+			FROM sessions
+			WHERE refresh_hash = $1 AND refresh_expires_at > NOW()
+			FOR UPDATE
+		), s AS (
+			UPDATE sessions
+			SET token_hash = $2, refresh_hash = $3, expires_at = $4, refresh_expires_at = $5,
+			    client_net = NULLIF($6, ''), user_agent_hash = NULLIF($7, '')
+			FROM old
+			WHERE sessions.token_hash = old.token_hash
+			RETURNING sessions.email, old.client_net, old.user_agent_hash
+		)
+		SELECT `+userColumns+`, COALESCE(s.client_net, ''), COALESCE(s.user_agent_hash, '')
+		FROM s JOIN users u ON u.email = s.email
+	`, refreshHash, next.TokenHash, next.RefreshHash, next.ExpiresAt, next.RefreshExpiresAt,
+		next.Binding.ClientNet, next.Binding.UserAgentHash))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return u, b, ErrSessionNotFound
+	}
+	if err != nil {
+		return u, b, fmt.Errorf("refresh session: %w", err)
+	}
+	return u, b, nil
+}
+
+// GetSession returns the user and binding of an unexpired session
+func (p *Postgres) GetSession(ctx context.Context, tokenHash string) (User, SessionBinding, error) {
+	u, b, err := scanSessionUser(p.pool.QueryRow(ctx, `
+		SELECT `+userColumns+`, COALESCE(s.client_net, ''), COALESCE(s.user_agent_hash, '')
+		FROM sessions s JOIN users u ON u.email = s.email
+		WHERE s.token_hash = $1 AND s.expires_at > NOW()
+	`, tokenHash))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return u, b, ErrSessionNotFound
+	}
+	if err != nil {
+		return u, b, fmt.Errorf("get session: %w", err)
+	}
+	return u, b, nil
+}
+
+// RebindSession moves a session to another client
+func (p *Postgres) RebindSession(ctx context.Context, tokenHash string, b SessionBinding) error {
+	_, err := p.pool.Exec(ctx, `
+		UPDATE sessions SET client_net = NULLIF($2, ''), user_agent_hash = NULLIF($3, '')
+		WHERE token_hash = $1
+	`, tokenHash, b.ClientNet, b.UserAgentHash)
+	if err != nil {
+		return fmt.Errorf("rebind session: %w", err)
+	}
+	return nil
+}
+
+// DeleteSession ends a session
+func (p *Postgres) DeleteSession(ctx context.Context, tokenHash string) error {
+	_, err := p.pool.Exec(ctx, `DELETE FROM sessions WHERE token_hash = $1`, tokenHash)
+	if err != nil {
+		return fmt.Errorf("delete session: %w", err)
+	}
+	return nil
+}
+
+// ListUsers returns all dashboard users with their site grants
+func (p *Postgres) ListUsers(ctx context.Context) ([]User, error) {
+	rows, err := p.pool.Query(ctx, `SELECT `+userColumns+` FROM users u ORDER BY u.email`)
+	if err != nil {
+		return nil, fmt.Errorf("query users: %w", err)
+	}
+	defer rows.Close()
+
+	var result []User
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan row: %w", err)
+		}
+		result = append(result, u)
+	}
+
+	return result, rows.Err()
+}
+
+// GetUser returns a user by email, or ErrUserNotFound
+func (p *Postgres) GetUser(ctx context.Context, email string) (User, error) {
+	u, err := scanUser(p.pool.QueryRow(ctx, `SELECT `+userColumns+` FROM users u WHERE u.email = $1`, email))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return u, ErrUserNotFound
+	}
+	if err != nil {
+		return u, fmt.Errorf("get user %s: %w", email, err)
+	}
+	return u, nil
+}
+
+// SetUserRole changes the role of a user. Users seeded from ADMIN_USERS get
+// their configured role back on the next start.
+func (p *Postgres) SetUserRole(ctx context.Context, email, role string) error {
+	tag, err := p.pool.Exec(ctx, `UPDATE users SET role = $2 WHERE email = $1`, email, role)
+	if err != nil {
+		return fmt.Errorf("set role of %s: %w", email, err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// SetUserSites replaces the site grants of a user
+func (p *Postgres) SetUserSites(ctx context.Context, email string, sites []string, grantedBy string) error {
+	if sites == nil {
+		sites = []string{}
+	}
+
+	tx, err := p.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var found int
+	err = tx.QueryRow(ctx, `SELECT 1 FROM users WHERE email = $1 FOR UPDATE`, email).Scan(&found)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrUserNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("lock user %s: %w", email, err)
+	}
+
+	if _, err := tx.Exec(ctx, `DELETE FROM user_sites WHERE email = $1 AND NOT (site_id = ANY($2))`, email, sites); err != nil {
+		return fmt.Errorf("revoke sites of %s: %w", email, err)
+	}
+	_, err = tx.Exec(ctx, `
+		INSERT INTO user_sites (email, site_id, granted_by, granted_at)
+		SELECT $1, s, NULLIF($3, ''), NOW() FROM UNNEST($2::text[]) AS s
+		ON CONFLICT (email, site_id) DO NOTHING
+	`, email, sites, grantedBy)
+	if err != nil {
+		return fmt.Errorf("grant sites to %s: %w", email, err)
+	}
+
+	return tx.Commit(ctx)
+}
+
+// DeleteExpiredSessions removes sessions whose refresh token expired and
+// returns how many there were
+func (p *Postgres) DeleteExpiredSessions(ctx context.Context) (int64, error) {
+	tag, err := p.pool.Exec(ctx, `DELETE FROM sessions WHERE refresh_expires_at <= NOW()`)
+	if err != nil {
+		return 0, fmt.Errorf("delete expired sessions: %w", err)
+	}
+	return tag.RowsAffected(), nil
+}
+
+// OIDCLogin is a login in progress with an OIDC provider, between the
+// redirect to the provider and its callback
+type OIDCLogin struct {
+	StateHash    string // SHA-256 of the state parameter
+	CodeVerifier string // PKCE code verifier, never sent to the browser
+	Nonce        string
+	ExpiresAt    time.Time
+}
+
+// ErrOIDCLoginNotFound is returned for unknown, used or expired login states
+var ErrOIDCLoginNotFound = errors.New("oidc login not found")
+
+// CreateOIDCLogin stores a pending OIDC login
+func (p *Postgres) CreateOIDCLogin(ctx context.Context, l OIDCLogin) error {
+	_, err := p.pool.Exec(ctx, `
+		INSERT INTO oidc_logins (state_hash, code_verifier, nonce, expires_at)
+		VALUES ($1, $2, $3, $4)
+	`, l.StateHash, l.CodeVerifier, l.Nonce, l.ExpiresAt)
+	if err != nil {
+		return fmt.Errorf("create oidc login: %w", err)
+	}
+	return nil
+}
+
+// TakeOIDCLogin removes and returns the pending login with the given state,
+// so each state can complete a login only once
+func (p *Postgres) TakeOIDCLogin(ctx context.Context, stateHash string) (OIDCLogin, error) {
+	l := OIDCLogin{StateHash: stateHash}
+	err := p.pool.QueryRow(ctx, `
+		DELETE FROM oidc_logins
+		WHERE state_hash = $1 AND expires_at > NOW()
+		RETURNING code_verifier, nonce, expires_at
+	`, stateHash).Scan(&l.CodeVerifier, &l.Nonce, &l.ExpiresAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return l, ErrOIDCLoginNotFound
+	}
+	if err != nil {
+		return l, fmt.Errorf("take oidc login: %w", err)
+	}
+	return l, nil
+}
+
+// DeleteExpiredOIDCLogins removes logins that were never completed
+func (p *Postgres) DeleteExpiredOIDCLogins(ctx context.Context) (int64, error) {
+	tag, err := p.pool.Exec(ctx, `DELETE FROM oidc_logins WHERE expires_at <= NOW()`)
+	if err != nil {
+		return 0, fmt.Errorf("delete expired oidc logins: %w", err)
+	}
+	return tag.RowsAffected(), nil
+}
+
+// ============================================
+// STORAGE STATS
+// ============================================
+
+// statsHypertables are the time-partitioned tables reported by
+// GetStorageStats, statsRegistryTables the plain ones
+var (
+	statsHypertables = []string{
+		"frontend_metrics", "api_metrics", "psp_metrics", "game_metrics",
+		"websocket_metrics", "business_metrics", "alert_events", "csp_reports",
+	}
+	statsRegistryTables = []string{
+		"producers", "sdk_usage", "scheduled_jobs", "site_credentials",
+		"service_accounts", "users", "user_sites", "sessions", "oidc_logins",
+	}
+)
+
+// TableStorageStats describes the size and contents of one table. Row
+// counts outside the requested range are estimates from the planner
+// statistics; RowsInRange is exact.
+type TableStorageStats struct {
+	Table       string       `json:"table"`
+	Hypertable  bool         `json:"hypertable"`
+	SizeBytes   int64        `json:"size_bytes"`
+	ApproxRows  int64        `json:"approx_rows"`
+	RowsInRange *int64       `json:"rows_in_range,omitempty"`
+	OldestRow   *time.Time   `json:"oldest_row,omitempty"`
+	NewestRow   *time.Time   `json:"newest_row,omitempty"`
+	Chunks      *ChunkHealth `json:"chunks,omitempty"`
+}
+
+// ChunkHealth summarizes the chunks of a hypertable. Overdue chunks mean the
+// compression or retention policy is not keeping up; future chunks mean
+// producers send timestamps ahead of the clock.
+type ChunkHealth struct {
+	Total              int64 `json:"total"`
+	Compressed         int64 `json:"compressed"`
+	Future             int64 `json:"future"`
+	CompressionOverdue int64 `json:"compression_overdue"`
+	RetentionOverdue   int64 `json:"retention_overdue"`
+}
+
+// RollupFreshness describes the refresh policy of a continuous aggregate
+type RollupFreshness struct {
+	View                    string     `json:"view"`
+	ScheduleIntervalSeconds float64    `json:"schedule_interval_seconds"`
+	LastSuccess             *time.Time `json:"last_success,omitempty"`
+	LastStatus              string     `json:"last_status,omitempty"`
+	NextStart               *time.Time `json:"next_start,omitempty"`
+	TotalFailures           int64      `json:"total_failures"`
+	NewestBucket            *time.Time `json:"newest_bucket,omitempty"`
+	LagSeconds              *float64   `json:"lag_seconds,omitempty"` // Since LastSuccess
+	Stale                   bool       `json:"stale"`                 // No success within two schedule intervals
+}
+
+// StorageStats is a capacity overview of the database
+type StorageStats struct {
+	Start   time.Time           `json:"start"`
+	End     time.Time           `json:"end"`
+	Tables  []TableStorageStats `json:"tables"`
+	Rollups []RollupFreshness   `json:"rollups"`
+}
+
+// GetStorageStats reports size, rows and partition health per table and
+// the freshness of every continuous aggregate. Exact row counts are limited
+// to start <= time < end so the query stays cheap on large hypertables.
+func (p *Postgres) GetStorageStats(ctx context.Context, start, end time.Time) (StorageStats, error) {
+	stats := StorageStats{Start: start, End: end}
+
+	for _, table := range statsHypertables {
+		t, err := p.hypertableStats(ctx, table, start, end)
+		if err != nil {
+			return StorageStats{}, err
+		}
+		stats.Tables = append(stats.Tables, t)
+	}
+
+	for _, table := range statsRegistryTables {
+		t := TableStorageStats{Table: table}
+		err := p.pool.QueryRow(ctx, `
+			SELECT pg_total_relation_size(c.oid), GREATEST(c.reltuples, 0)::bigint
+			FROM pg_class c
+			WHERE c.oid = $1::regclass
+		`, table).Scan(&t.SizeBytes, &t.ApproxRows)
+		if err != nil {
+			return StorageStats{}, fmt.Errorf("stats %s: %w", table, err)
+		}
+		stats.Tables = append(stats.Tables, t)
+	}
+
+	rollups, err := p.rollupFreshness(ctx)
+	if err != nil {
+		return StorageStats{}, err
+	}
+	stats.Rollups = rollups
+
+	return stats, nil
+}
+
+func (p *Postgres) hypertableStats(ctx context.Context, table string, start, end time.Time) (TableStorageStats, error) {
+	t := TableStorageStats{Table: table, Hypertable: true, Chunks: &ChunkHealth{}}
+
+	err := p.pool.QueryRow(ctx,
+		`SELECT hypertable_size($1::regclass), approximate_row_count($1::regclass)`,
+		table,
+	).Scan(&t.SizeBytes, &t.ApproxRows)
+	if err != nil {
+		return t, fmt.Errorf("stats %s: %w", table, err)
+	}
+
+	var rows int64
+	err = p.pool.QueryRow(ctx,
+		fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE time >= $1 AND time < $2", table),
+		start, end,
+	).Scan(&rows)
+	if err != nil {
+		return t, fmt.Errorf("count %s: %w", table, err)
+	}
+	t.RowsInRange = &rows
+
+	err = p.pool.QueryRow(ctx,
+		fmt.Sprintf("SELECT MIN(time), MAX(time) FROM %s", table),
+	).Scan(&t.OldestRow, &t.NewestRow)
+	if err != nil {
+		return t, fmt.Errorf("time range %s: %w", table, err)
+	}
+
+	err = p.pool.QueryRow(ctx, `
+		WITH policy AS (
+			SELECT
+				(SELECT (config->>'compress_after')::interval FROM timescaledb_information.jobs
+				 WHERE proc_name = 'policy_compression' AND hypertable_name = $1 LIMIT 1) AS compress_after,
+				(SELECT (config->>'drop_after')::interval FROM timescaledb_information.jobs
+				 WHERE proc_name = 'policy_retention' AND hypertable_name = $1 LIMIT 1) AS drop_after
+		)
+		SELECT
+			COUNT(c.chunk_name),
+			COUNT(c.chunk_name) FILTER (WHERE c.is_compressed),
+			COUNT(c.chunk_name) FILTER (WHERE c.range_start > NOW()),
+			COUNT(c.chunk_name) FILTER (WHERE NOT c.is_compressed AND c.range_end < NOW() - p.compress_after),
+			COUNT(c.chunk_name) FILTER (WHERE c.range_end < NOW() - p.drop_after)
+		FROM policy p
+		LEFT JOIN timescaledb_information.chunks c ON c.hypertable_name = $1
+	`, table).Scan(
+		&t.Chunks.Total, &t.Chunks.Compressed, &t.Chunks.Future,
+		&t.Chunks.CompressionOverdue, &t.Chunks.RetentionOverdue,
+	)
+	if err != nil {
+		return t, fmt.Errorf("chunks %s: %w", table, err)
+	}
+
+	return t, nil
+}
+
+func (p *Postgres) rollupFreshness(ctx context.Context) ([]RollupFreshness, error) {
+	rows, err := p.pool.Query(ctx, `
+		SELECT
+			ca.view_name,
+			COALESCE(EXTRACT(EPOCH FROM j.schedule_interval), 0)::float8,
+			s.last_successful_finish,
+			COALESCE(s.last_run_status, ''),
+			s.next_start,
+			COALESCE(s.total_failures, 0)
+		FROM timescaledb_information.continuous_aggregates ca
+		LEFT JOIN timescaledb_information.jobs j
+			ON j.proc_name = 'policy_refresh_continuous_aggregate'
+			AND j.hypertable_name = ca.materialization_hypertable_name
+		LEFT JOIN timescaledb_information.job_stats s ON s.job_id = j.job_id
+		ORDER BY ca.view_name
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("query rollup freshness: %w", err)
+	}
+
+	var result []RollupFreshness
+	for rows.Next() {
+		var r RollupFreshness
+		if err := rows.Scan(
+			&r.View, &r.ScheduleIntervalSeconds, &r.LastSuccess,
+			&r.LastStatus, &r.NextStart, &r.TotalFailures,
+		); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("scan rollup freshness: %w", err)
+		}
+		result = append(result, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("query rollup freshness: %w", err)
+	}
+
+	now := time.Now()
+	for i := range result {
+		r := &result[i]
+		err := p.pool.QueryRow(ctx,
+			fmt.Sprintf("SELECT MAX(bucket) FROM %s", pgx.Identifier{r.View}.Sanitize()),
+		).Scan(&r.NewestBucket)
+		if err != nil {
+			return nil, fmt.Errorf("newest bucket %s: %w", r.View, err)
+		}
+
+		if r.LastSuccess != nil {
+			lag := now.Sub(*r.LastSuccess).Seconds()
+			r.LagSeconds = &lag
+		}
+		r.Stale = r.LagSeconds == nil || *r.LagSeconds > 2*r.ScheduleIntervalSeconds
+	}
+	return result, nil
+}
+
+// ============================================
+// NOTIFICATION QUEUE
+// ============================================
+
+// QueuedNotification is an alert held back from a notification channel
+type QueuedNotification struct {
+	ID       int64     `json:"id"`
+	Channel  string    `json:"channel"`
+	Reason   string    `json:"reason"`
+	Alert    AlertRow  `json:"alert"`
+	QueuedAt time.Time `json:"queued_at"`
+}
+
+// QueueNotification holds an alert back for a later digest
+func (p *Postgres) QueueNotification(ctx context.Context, n QueuedNotification) error {
+	if n.QueuedAt.IsZero() {
+		n.QueuedAt = time.Now().UTC()
+	}
+	_, err := p.pool.Exec(ctx, `
+		INSERT INTO notification_queue (channel, reason, alert, queued_at)
+		VALUES ($1, $2, $3, $4)
+	`, n.Channel, n.Reason, n.Alert, n.QueuedAt)
+	if err != nil {
+		return fmt.Errorf("queue notification: %w", err)
+	}
+	return nil
+}
+
+// ClaimNotifications removes and returns up to limit queued notifications
+// of a channel, oldest first. Concurrent claims never return the same row.
+func (p *Postgres) ClaimNotifications(ctx context.Context, channel string, limit int) ([]QueuedNotification, error) {
+	rows, err := p.pool.Query(ctx, `
+		DELETE FROM notification_queue
+		WHERE id IN (
+			SELECT id FROM notification_queue
+			WHERE channel = $1
+			ORDER BY id
+			LIMIT $2
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, channel, reason, alert, queued_at
+	`, channel, limit)
+	if err != nil {
+		return nil, fmt.Errorf("claim notifications: %w", err)
+	}
+	defer rows.Close()
+
+	var result []QueuedNotification
+	for rows.Next() {
+		var n QueuedNotification
+		if err := rows.Scan(&n.ID, &n.Channel, &n.Reason, &n.Alert, &n.QueuedAt); err != nil {
+			return nil, fmt.Errorf("scan notification: %w", err)
+		}
+		result = append(result, n)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("claim notifications: %w", err)
+	}
+
+	// DELETE ... RETURNING does not keep the subquery order
+	sort.Slice(result, func(i, j int) bool { return result[i].ID < result[j].ID })
+	return result, nil
+}
+
+// ============================================
+// WITHDRAWALS
+// ============================================
+
+// WithdrawalFlowRow is the withdrawal funnel of one PSP and the time spent
+// between states. Durations are in seconds and nil without samples.
+type WithdrawalFlowRow struct {
+	PSPName       string   `json:"psp_name"`
+	Campaign      *string  `json:"campaign,omitempty"` // Only in the campaign breakdown; null for untagged withdrawals
+	Requested     int64    `json:"requested"`
+	Approved      int64    `json:"approved"`
+	Sent          int64    `json:"sent"`
+	Settled       int64    `json:"settled"`
+	Failed        int64    `json:"failed"`
+	Pending       int64    `json:"pending"` // Neither settled nor failed
+	SettledAmount float64  `json:"settled_amount"`
+	ReviewP50S    *float64 `json:"review_p50_s"` // requested -> approved
+	ReviewP95S    *float64 `json:"review_p95_s"`
+	SendP50S      *float64 `json:"send_p50_s"` // approved -> sent
+	SendP95S      *float64 `json:"send_p95_s"`
+	SettleP50S    *float64 `json:"settle_p50_s"` // sent -> settled
+	SettleP95S    *float64 `json:"settle_p95_s"`
+	TotalP50S     *float64 `json:"total_p50_s"` // requested -> settled
+	TotalP95S     *float64 `json:"total_p95_s"`
+}
+
+// withdrawalTransactions folds the state events of withdrawals requested
+// since $1 (of PSP $2 and sites $3) into one row per transaction with the
+// time each state was first reached
+const withdrawalTransactions = `
+	WITH tx AS (
+		SELECT
+			psp_name,
+			transaction_id,
+			MIN(time) FILTER (WHERE state = 'requested') AS requested_at,
+			MIN(time) FILTER (WHERE state = 'approved') AS approved_at,
+			MIN(time) FILTER (WHERE state = 'sent') AS sent_at,
+			MIN(time) FILTER (WHERE state = 'settled') AS settled_at,
+			MIN(time) FILTER (WHERE state = 'failed') AS failed_at,
+			MAX(time) AS updated_at,
+			MAX(amount) AS amount,
+			MAX(currency) AS currency,
+			MAX(campaign) AS campaign
+		FROM psp_metrics
+		WHERE operation = 'withdrawal'
+		  AND state IS NOT NULL
+		  AND transaction_id IS NOT NULL
+		  AND time >= $1
+		  AND ($2 = '' OR psp_name = $2)
+		  AND ($3::text[] IS NULL OR site_id = ANY($3))
+		GROUP BY psp_name, transaction_id
+	)
+`
+
+// GetWithdrawalFlow returns the withdrawal funnel and state durations per
+// PSP for withdrawals requested since start, and per campaign if
+// byCampaign is set
+func (p *Postgres) GetWithdrawalFlow(ctx context.Context, start time.Time, pspName string, byCampaign bool, sites []string) ([]WithdrawalFlowRow, error) {
+	query := withdrawalTransactions + `
+		SELECT
+			psp_name,
+			CASE WHEN $4 THEN campaign END AS campaign_key,
+			COUNT(requested_at),
+			COUNT(approved_at),
+			COUNT(sent_at),
+			COUNT(settled_at),
+			COUNT(failed_at),
+			COUNT(*) FILTER (WHERE settled_at IS NULL AND failed_at IS NULL),
+			COALESCE(SUM(amount) FILTER (WHERE settled_at IS NOT NULL), 0),
+			PERCENTILE_CONT(0.5) WITHIN GROUP (ORDER BY EXTRACT(EPOCH FROM approved_at - requested_at)),
+			PERCENTILE_CONT(0.95) WITHIN GROUP (ORDER BY EXTRACT(EPOCH FROM approved_at - requested_at)),
+			PERCENTILE_CONT(0.5) WITHIN GROUP (ORDER BY EXTRACT(EPOCH FROM sent_at - approved_at)),
+			PERCENTILE_CONT(0.95) WITHIN GROUP (ORDER BY EXTRACT(EPOCH FROM sent_at - approved_at)),
+			PERCENTILE_CONT(0.5) WITHIN GROUP (ORDER BY EXTRACT(EPOCH FROM settled_at - sent_at)),
+			PERCENTILE_CONT(0.95) WITHIN GROUP (ORDER BY EXTRACT(EPOCH FROM settled_at - sent_at)),
+			PERCENTILE_CONT(0.5) WITHIN GROUP (ORDER BY EXTRACT(EPOCH FROM settled_at - requested_at)),
+			PERCENTILE_CONT(0.95) WITHIN GROUP (ORDER BY EXTRACT(EPOCH FROM settled_at - requested_at))
+		FROM tx
+		WHERE requested_at IS NOT NULL
+		GROUP BY psp_name, campaign_key
+		ORDER BY psp_name, campaign_key NULLS FIRST
+	`
+
+	rows, err := p.pool.Query(ctx, query, start, pspName, sites, byCampaign)
+	if err != nil {
+		return nil, fmt.Errorf("query withdrawal flow: %w", err)
+	}
+	defer rows.Close()
+
+	var result []WithdrawalFlowRow
+	for rows.Next() {
+		var r WithdrawalFlowRow
+		if err := rows.Scan(
+			&r.PSPName, &r.Campaign, &r.Requested, &r.Approved, &r.Sent, &r.Settled, &r.Failed, &r.Pending,
+			&r.SettledAmount,
+			&r.ReviewP50S, &r.ReviewP95S, &r.SendP50S, &r.SendP95S,
+			&r.SettleP50S, &r.SettleP95S, &r.TotalP50S, &r.TotalP95S,
+		); err != nil {
+			return nil, fmt.Errorf("scan row: %w", err)
+		}
+		result = append(result, r)
+	}
+
+	return result, rows.Err()
+}
+
+// PendingWithdrawalRow is a withdrawal that has neither settled nor failed
+type PendingWithdrawalRow struct {
+	PSPName       string    `json:"psp_name"`
+	TransactionID string    `json:"transaction_id"`
+	State         string    `json:"state"` // Latest state reached
+	RequestedAt   time.Time `json:"requested_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+	AgeSeconds    float64   `json:"age_seconds"` // Since requested_at
+	Amount        *float64  `json:"amount"`
+	Currency      *string   `json:"currency"`
+}
+
+// GetPendingWithdrawals returns withdrawals requested since start that are
+// still open after olderThan, oldest first
+func (p *Postgres) GetPendingWithdrawals(ctx context.Context, start time.Time, pspName string, olderThan time.Duration, sites []string) ([]PendingWithdrawalRow, error) {
+	query := withdrawalTransactions + `
+		SELECT
+			psp_name,
+			transaction_id::text,
+			CASE
+				WHEN sent_at IS NOT NULL THEN 'sent'
+				WHEN approved_at IS NOT NULL THEN 'approved'
+				ELSE 'requested'
+			END,
+			requested_at,
+			updated_at,
+			EXTRACT(EPOCH FROM NOW() - requested_at)::float8,
+			amount,
+			currency
+		FROM tx
+		WHERE requested_at IS NOT NULL
+		  AND settled_at IS NULL
+		  AND failed_at IS NULL
+		  AND requested_at < NOW() - $4::float8 * INTERVAL '1 second'
+		ORDER BY requested_at
+		LIMIT 500
+	`
+
+	rows, err := p.pool.Query(ctx, query, start, pspName, sites, olderThan.Seconds())
+	if err != nil {
+		return nil, fmt.Errorf("query pending withdrawals: %w", err)
+	}
+	defer rows.Close()
+
+	var result []PendingWithdrawalRow
+	for rows.Next() {
+		var r PendingWithdrawalRow
+		if err := rows.Scan(
+			&r.PSPName, &r.TransactionID, &r.State, &r.RequestedAt, &r.UpdatedAt,
+			&r.AgeSeconds, &r.Amount, &r.Currency,
+		); err != nil {
+			return nil, fmt.Errorf("scan row: %w", err)
+		}
+		result = append(result, r)
+	}
+
+	return result, rows.Err()
+}
+
+// ============================================
+// CAMPAIGNS
+// ============================================
+
+// GetPSPHealthByCampaign returns PSP health in 5 minute buckets per
+// campaign. Campaigns are not part of psp_success_5m, so this reads the
+// raw metrics; the handler bounds the range.
+func (p *Postgres) GetPSPHealthByCampaign(ctx context.Context, start time.Time, sites []string) ([]PSPHealthRow, error) {
+	query := `
+		SELECT time_bucket('5 minutes', time) AS bucket, psp_name, operation, campaign,
+		       COUNT(*), COUNT(*) FILTER (WHERE success),
+		       AVG(duration_ms)::float8,
+		       PERCENTILE_CONT(0.95) WITHIN GROUP (ORDER BY duration_ms)::float8,
+		       COALESCE(SUM(amount), 0)::float8
+		FROM psp_metrics
+		WHERE time >= $1 AND ($2::text[] IS NULL OR site_id = ANY($2))
+		GROUP BY bucket, psp_name, operation, campaign
+		ORDER BY bucket DESC, psp_name, operation, campaign NULLS FIRST
+	`
+
+	rows, err := p.pool.Query(ctx, query, start, sites)
+	if err != nil {
+		return nil, fmt.Errorf("query psp health by campaign: %w", err)
+	}
+	defer rows.Close()
+
+	var result []PSPHealthRow
+	for rows.Next() {
+		var r PSPHealthRow
+		if err := rows.Scan(
+			&r.Bucket, &r.PSPName, &r.Operation, &r.Campaign, &r.TotalCount, &r.SuccessCount,
+			&r.AvgDurationMS, &r.P95DurationMS, &r.TotalAmount,
+		); err != nil {
+			return nil, fmt.Errorf("scan row: %w", err)
+		}
+		result = append(result, r)
+	}
+
+	return result, rows.Err()
+}
+
+// CampaignActivityRow is the frontend and payment traffic of one campaign
+// in a 5 minute bucket, to line up marketing launches with load spikes and
+// cashier degradation
+type CampaignActivityRow struct {
+	Bucket       time.Time `json:"bucket"`
+	Campaign     string    `json:"campaign"`
+	Events       int64     `json:"events"`
+	Sessions     int64     `json:"sessions"`
+	Errors       int64     `json:"errors"` // Frontend error and crash events
+	PSPTotal     int64     `json:"psp_total"`
+	PSPSuccess   int64     `json:"psp_success"`
+	PSPP95MS     *float64  `json:"psp_p95_ms"`
+	DepositTotal float64   `json:"deposit_total"` // Amount of successful deposits
+}
+
+// GetCampaignActivity returns the activity of tagged traffic since start,
+// optionally for one campaign
+func (p *Postgres) GetCampaignActivity(ctx context.Context, start time.Time, campaign string, sites []string) ([]CampaignActivityRow, error) {
+	query := `
+		WITH fe AS (
+			SELECT time_bucket('5 minutes', time) AS bucket, campaign,
+			       COUNT(*) AS events,
+			       COUNT(DISTINCT session_id) AS sessions,
+			       COUNT(*) FILTER (WHERE event_type IN ('error', 'crash', 'fatal_error')) AS errors
+			FROM frontend_metrics
+			WHERE time >= $1 AND campaign IS NOT NULL AND ($2 = '' OR campaign = $2)
+			  AND ($3::text[] IS NULL OR site_id = ANY($3))
+			GROUP BY bucket, campaign
+		), psp AS (
+			SELECT time_bucket('5 minutes', time) AS bucket, campaign,
+			       COUNT(*) AS total,
+			       COUNT(*) FILTER (WHERE success) AS success,
+			       PERCENTILE_CONT(0.95) WITHIN GROUP (ORDER BY duration_ms)::float8 AS p95_ms,
+			       COALESCE(SUM(amount) FILTER (WHERE success AND operation = 'deposit'), 0)::float8 AS deposits
+			FROM psp_metrics
+			WHERE time >= $1 AND campaign IS NOT NULL AND ($2 = '' OR campaign = $2)
+			  AND ($3::text[] IS NULL OR site_id = ANY($3))
+			GROUP BY bucket, campaign
+		)
+		SELECT COALESCE(fe.bucket, psp.bucket), COALESCE(fe.campaign, psp.campaign),
+		       COALESCE(fe.events, 0), COALESCE(fe.sessions, 0), COALESCE(fe.errors, 0),
+		       COALESCE(psp.total, 0), COALESCE(psp.success, 0), psp.p95_ms,
+		       COALESCE(psp.deposits, 0)
+		FROM fe
+		FULL OUTER JOIN psp ON psp.bucket = fe.bucket AND psp.campaign = fe.campaign
+		ORDER BY 1 DESC, 2
+	`
+
+	rows, err := p.pool.Query(ctx, query, start, campaign, sites)
+	if err != nil {
+		return nil, fmt.Errorf("query campaign activity: %w", err)
+	}
+	defer rows.Close()
+
+	var result []CampaignActivityRow
+	for rows.Next() {
+		var r CampaignActivityRow
+		if err := rows.Scan(
+			&r.Bucket, &r.Campaign, &r.Events, &r.Sessions, &r.Errors,
+			&r.PSPTotal, &r.PSPSuccess, &r.PSPP95MS, &r.DepositTotal,
+		); err != nil {
+			return nil, fmt.Errorf("scan row: %w", err)
+		}
+		result = append(result, r)
+	}
+
+	return result, rows.Err()
+}
+
+// ============================================
+// INGEST PROFILE
+// ============================================
+
+// IngestProfileRow is the volume and cardinality of one metric type of a
+// site since a point in time
+type IngestProfileRow struct {
+	SiteID     string
+	MetricType string
+	Events     int64
+	Sources    int64 // Distinct sessions, services, PSPs, providers or connections
+	Keys       int64 // Distinct page paths, API endpoints or game IDs; 0 for PSP and WS
+	Samples    int64 // Web vital events, or WS events with a latency (pings)
+}
+
+// GetIngestProfile returns ingest volumes and cardinalities per site and
+// metric type since start. It scans the raw metrics of the window, so
+// callers should cache it.
+func (p *Postgres) GetIngestProfile(ctx context.Context, start time.Time) ([]IngestProfileRow, error) {
+	query := `
+		SELECT COALESCE(site_id, ''), 'frontend', COUNT(*), COUNT(DISTINCT session_id),
+		       COUNT(DISTINCT page_path), COUNT(*) FILTER (WHERE event_type = 'web_vital')
+		FROM frontend_metrics WHERE time >= $1 GROUP BY 1
+		UNION ALL
+		SELECT COALESCE(site_id, ''), 'api', COUNT(*), COUNT(DISTINCT service_name),
+		       COUNT(DISTINCT endpoint), 0
+		FROM api_metrics WHERE time >= $1 GROUP BY 1
+		UNION ALL
+		SELECT COALESCE(site_id, ''), 'psp', COUNT(*), COUNT(DISTINCT psp_name), 0, 0
+		FROM psp_metrics WHERE time >= $1 GROUP BY 1
+		UNION ALL
+		SELECT COALESCE(site_id, ''), 'game', COUNT(*), COUNT(DISTINCT provider),
+		       COUNT(DISTINCT game_id), 0
+		FROM game_metrics WHERE time >= $1 GROUP BY 1
+		UNION ALL
+		SELECT COALESCE(site_id, ''), 'ws', COUNT(*), COUNT(DISTINCT connection_id), 0,
+		       COUNT(*) FILTER (WHERE latency_ms IS NOT NULL)
+		FROM websocket_metrics WHERE time >= $1 GROUP BY 1
+		ORDER BY 1, 2
+	`
+
+	rows, err := p.pool.Query(ctx, query, start)
+	if err != nil {
+		return nil, fmt.Errorf("query ingest profile: %w", err)
+	}
+	defer rows.Close()
+
+	var result []IngestProfileRow
+	for rows.Next() {
+		var r IngestProfileRow
+		if err := rows.Scan(&r.SiteID, &r.MetricType, &r.Events, &r.Sources, &r.Keys, &r.Samples); err != nil {
+			return nil, fmt.Errorf("scan row: %w", err)
+		}
+		result = append(result, r)
+	}
+
+	return result, rows.Err()
+}
+
+// ============================================
+// ERROR EXPLORER
+// ============================================
+
+// errorEvents selects failed API requests (server errors or an error_type),
+// failed payments and failed game launches since $1 for the sites in $2,
+// with a fingerprint of source, component, error type and the message with
+// IDs and numbers masked, so "timeout after 3012ms" and "timeout after
+// 2987ms" group together
+const errorEvents = `
+	WITH errors AS (
+		SELECT time, 'api' AS source, service_name AS component,
+		       COALESCE(error_type, 'http_' || status_code) AS error_type,
+		       COALESCE(error_message, '') AS message,
+		       player_id, site_id, method || ' ' || endpoint AS detail
+		FROM api_metrics
+		WHERE time >= $1 AND (status_code >= 500 OR error_type IS NOT NULL)
+		  AND ($2::text[] IS NULL OR site_id = ANY($2))
+		UNION ALL
+		SELECT time, 'psp', psp_name, COALESCE(error_code, 'unknown'),
+		       COALESCE(error_message, ''),
+		       player_id, site_id, operation || COALESCE(' ' || transaction_id::text, '')
+		FROM psp_metrics
+		WHERE time >= $1 AND NOT success
+		  AND ($2::text[] IS NULL OR site_id = ANY($2))
+		UNION ALL
+		SELECT time, 'game', provider, COALESCE(error_type, 'unknown'),
+		       COALESCE(error_message, ''),
+		       player_id, site_id, COALESCE(game_id, '')
+		FROM game_metrics
+		WHERE time >= $1 AND NOT launch_success
+		  AND ($2::text[] IS NULL OR site_id = ANY($2))
+	), patterned AS (
+		SELECT *, LEFT(regexp_replace(regexp_replace(message,
+		       '[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}', '<id>', 'gi'),
+		       '[0-9]+', '<n>', 'g'), 300) AS pattern
+		FROM errors
+	), fingerprinted AS (
+		SELECT *, LEFT(MD5(source || '|' || component || '|' || error_type || '|' || pattern), 16) AS fingerprint
+		FROM patterned
+		WHERE ($3 = '' OR source = $3) AND ($4 = '' OR component = $4)
+	)
+`
+
+// ErrorGroupRow is one error fingerprint with its occurrences
+type ErrorGroupRow struct {
+	Fingerprint     string    `json:"fingerprint"`
+	Source          string    `json:"source"`    // api, psp or game
+	Component       string    `json:"component"` // Service, PSP or provider
+	ErrorType       string    `json:"error_type"`
+	Pattern         string    `json:"pattern"` // Message with IDs and numbers masked
+	SampleMessage   string    `json:"sample_message"`
+	Count           int64     `json:"count"`
+	AffectedPlayers int64     `json:"affected_players"`
+	FirstSeen       time.Time `json:"first_seen"`
+	LastSeen        time.Time `json:"last_seen"`
+}
+
+// GetErrorGroups returns the most frequent error fingerprints since start,
+// optionally for one source and component
+func (p *Postgres) GetErrorGroups(ctx context.Context, start time.Time, source, component string, sites []string) ([]ErrorGroupRow, error) {
+	query := errorEvents + `
+		SELECT fingerprint, source, component, error_type, pattern, MAX(message),
+		       COUNT(*), COUNT(DISTINCT player_id), MIN(time), MAX(time)
+		FROM fingerprinted
+		GROUP BY fingerprint, source, component, error_type, pattern
+		ORDER BY COUNT(*) DESC, MAX(time) DESC
+		LIMIT 500
+	`
+
+	rows, err := p.pool.Query(ctx, query, start, sites, source, component)
+	if err != nil {
+		return nil, fmt.Errorf("query error groups: %w", err)
+	}
+	defer rows.Close()
+
+	var result []ErrorGroupRow
+	for rows.Next() {
+		var r ErrorGroupRow
+		if err := rows.Scan(
+			&r.Fingerprint, &r.Source, &r.Component, &r.ErrorType, &r.Pattern, &r.SampleMessage,
+			&r.Count, &r.AffectedPlayers, &r.FirstSeen, &r.LastSeen,
+		); err != nil {
+			return nil, fmt.Errorf("scan row: %w", err)
+		}
+		result = append(result, r)
+	}
+
+	return result, rows.Err()
+}
+
+// ErrorSampleRow is one occurrence of an error fingerprint
+type ErrorSampleRow struct {
+	Time      time.Time `json:"time"`
+	Source    string    `json:"source"`
+	Component string    `json:"component"`
+	ErrorType string    `json:"error_type"`
+	Message   string    `json:"error_message"`
+	PlayerID  *string   `json:"player_id"`
+	SiteID    *string   `json:"site_id"`
+	Detail    string    `json:"detail"` // Method and endpoint, operation and transaction, or game ID
+}
+
+// GetErrorSamples returns the latest occurrences of an error fingerprint
+// since start
+func (p *Postgres) GetErrorSamples(ctx context.Context, start time.Time, fingerprint string, limit int, sites []string) ([]ErrorSampleRow, error) {
+	query := errorEvents + `
+		SELECT time, source, component, error_type, message, player_id::text, site_id, detail
+		FROM fingerprinted
+		WHERE fingerprint = $5
+		ORDER BY time DESC
+		LIMIT $6
+	`
+
+	rows, err := p.pool.Query(ctx, query, start, sites, "", "", fingerprint, limit)
+	if err != nil {
+		return nil, fmt.Errorf("query error samples: %w", err)
+	}
+	defer rows.Close()
+
+	var result []ErrorSampleRow
+	for rows.Next() {
+		var r ErrorSampleRow
+		if err := rows.Scan(
+			&r.Time, &r.Source, &r.Component, &r.ErrorType, &r.Message,
+			&r.PlayerID, &r.SiteID, &r.Detail,
+		); err != nil {
+			return nil, fmt.Errorf("scan row: %w", err)
+		}
+		result = append(result, r)
+	}
+
+	return result, rows.Err()
+}
+
+// ============================================
+// PLAYER TIMELINE
+// ============================================
+
+// TimelineEntry is one event of a player from any metric table
+type TimelineEntry struct {
+	Time       time.Time `json:"time"`
+	Source     string    `json:"source"`              // frontend, api, psp, game or ws
+	Event      string    `json:"event"`               // Event type, method and endpoint, operation[/state], launch
+	Component  *string   `json:"component,omitempty"` // Page, service, PSP, provider or WebSocket endpoint
+	Success    bool      `json:"success"`
+	DurationMS *float64  `json:"duration_ms,omitempty"` // Request, payment, load time or latency
+	StatusCode *int      `json:"status_code,omitempty"` // API only
+	Error      *string   `json:"error,omitempty"`
+	Amount     *float64  `json:"amount,omitempty"` // PSP only
+	Currency   *string   `json:"currency,omitempty"`
+	SessionID  *string   `json:"session_id,omitempty"`
+	Reference  *string   `json:"reference,omitempty"` // Event, request, transaction, game or connection ID
+	SiteID     *string   `json:"site_id"`
+}
+
+// GetPlayerTimeline returns the events of a player in [from, to) across all
+// metric tables, oldest first, at most limit
+func (p *Postgres) GetPlayerTimeline(ctx context.Context, playerID string, from, to time.Time, limit int, sites []string) ([]TimelineEntry, error) {
+	query := `
+		SELECT time, 'frontend', event_type, page_path,
+		       event_type NOT IN ('error', 'crash', 'fatal_error'),
+		       NULL::float8, NULL::int, metadata->>'message', NULL::float8, NULL::text,
+		       session_id::text, event_id, site_id
+		FROM frontend_metrics
+		WHERE player_id = $1::uuid AND time >= $2 AND time < $3
+		  AND ($4::text[] IS NULL OR site_id = ANY($4))
+		UNION ALL
+		SELECT time, 'api', method || ' ' || endpoint, service_name,
+		       status_code < 400 AND error_type IS NULL,
+		       duration_ms::float8, status_code::int, COALESCE(error_message, error_type), NULL, NULL,
+		       NULL, request_id::text, site_id
+		FROM api_metrics
+		WHERE player_id = $1::uuid AND time >= $2 AND time < $3
+		  AND ($4::text[] IS NULL OR site_id = ANY($4))
+		UNION ALL
+		SELECT time, 'psp', operation || COALESCE('/' || state, ''), psp_name,
+		       success,
+		       duration_ms::float8, NULL, COALESCE(error_message, error_code), amount::float8, currency,
+		       NULL, transaction_id::text, site_id
+		FROM psp_metrics
+		WHERE player_id = $1::uuid AND time >= $2 AND time < $3
+		  AND ($4::text[] IS NULL OR site_id = ANY($4))
+		UNION ALL
+		SELECT time, 'game', 'launch', provider,
+		       launch_success,
+		       load_time_ms::float8, NULL, COALESCE(error_message, error_type), NULL, NULL,
+		       session_id::text, game_id, site_id
+		FROM game_metrics
+		WHERE player_id = $1::uuid AND time >= $2 AND time < $3
+		  AND ($4::text[] IS NULL OR site_id = ANY($4))
+		UNION ALL
+		SELECT time, 'ws', event_type, endpoint,
+		       event_type <> 'error',
+		       latency_ms::float8, NULL, close_reason, NULL, NULL,
+		       NULL, connection_id::text, site_id
+		FROM websocket_metrics
+		WHERE player_id = $1::uuid AND time >= $2 AND time < $3
+		  AND ($4::text[] IS NULL OR site_id = ANY($4))
+		ORDER BY 1
+		LIMIT $5
+	`
+
+	rows, err := p.pool.Query(ctx, query, playerID, from, to, sites, limit)
+	if err != nil {
+		return nil, fmt.Errorf("query player timeline: %w", err)
+	}
+	defer rows.Close()
+
+	var result []TimelineEntry
+	for rows.Next() {
+		var e TimelineEntry
+		if err := rows.Scan(
+			&e.Time, &e.Source, &e.Event, &e.Component,
+			&e.Success,
+			&e.DurationMS, &e.StatusCode, &e.Error, &e.Amount, &e.Currency,
+			&e.SessionID, &e.Reference, &e.SiteID,
+		); err != nil {
+			return nil, fmt.Errorf("scan row: %w", err)
+		}
+		result = append(result, e)
+	}
+
+	return result, rows.Err()
+}
+
+// ============================================
+// ALERT METRICS
+// ============================================
+
+// AlertMetric describes a value alert rules can aggregate
+type AlertMetric struct {
+	Table        string   // Source table, recorded with the alert
+	Target       string   // What a rule's target selects, e.g. "psp_name"
+	Aggregations []string // Aggregations the metric supports, the first is the default
+	value        string   // Column, or condition for rates and counts
+	filter       string   // Extra WHERE condition, may be empty
+	rollup       *alertRollup
+}
+
+// alertRollup is the continuous aggregate adaptive thresholds learn a
+// metric's baseline from, since the raw metrics are not kept for weeks
+type alertRollup struct {
+	view   string            // Continuous aggregate, keyed by the metric's target
+	width  time.Duration     // Bucket width
+	events string            // Column counting the events of a bucket
+	values map[string]string // Aggregation to value over the view's columns
+}
+
+// Aggregations of AlertMetrics: rate and count apply to conditions (share
+// in % and number of events matching), the others to columns
+var (
+	conditionAggregations = []string{"rate", "count"}
+	valueAggregations     = []string{"p95", "p50", "p75", "p99", "avg", "min", "max"}
+)
+
+// AlertMetrics are the metrics alert rules can use. Like the component
+// health queries they read raw metrics, so short windows are not hidden by
+// aggregate refresh lag.
+var AlertMetrics = map[string]AlertMetric{
+	"psp_success": {Table: "psp_metrics", Target: "psp_name", Aggregations: conditionAggregations, value: "success",
+		rollup: &alertRollup{view: "psp_success_5m", width: 5 * time.Minute, events: "total_count", values: map[string]string{
+			"rate":  "100.0 * SUM(success_count) / NULLIF(SUM(total_count), 0)",
+			"count": "SUM(success_count)",
+		}}},
+	"psp_duration_ms": {Table: "psp_metrics", Target: "psp_name", Aggregations: valueAggregations, value: "duration_ms",
+		rollup: &alertRollup{view: "psp_success_5m", width: 5 * time.Minute, events: "total_count", values: map[string]string{
+			"avg": "SUM(avg_duration_ms * total_count) / NULLIF(SUM(total_count), 0)",
+			"p95": "SUM(p95_duration_ms * total_count) / NULLIF(SUM(total_count), 0)",
+		}}},
+	"game_launch_success": {Table: "game_metrics", Target: "provider", Aggregations: conditionAggregations, value: "launch_success",
+		rollup: &alertRollup{view: "game_health_5m", width: 5 * time.Minute, events: "launch_count", values: map[string]string{
+			"rate":  "100.0 * SUM(success_count) / NULLIF(SUM(launch_count), 0)",
+			"count": "SUM(success_count)",
+		}}},
+	"game_load_time_ms": {Table: "game_metrics", Target: "provider", Aggregations: valueAggregations, value: "load_time_ms",
+		rollup: &alertRollup{view: "game_health_5m", width: 5 * time.Minute, events: "launch_count", values: map[string]string{
+			"avg": "SUM(avg_load_time_ms * launch_count) / NULLIF(SUM(launch_count), 0)",
+			"p95": "SUM(p95_load_time_ms * launch_count) / NULLIF(SUM(launch_count), 0)",
+		}}},
+	"api_errors": {Table: "api_metrics", Target: "service_name", Aggregations: conditionAggregations, value: "status_code >= 500",
+		rollup: &alertRollup{view: "api_performance_1m", width: time.Minute, events: "request_count", values: map[string]string{
+			"rate":  "100.0 * SUM(server_error_count) / NULLIF(SUM(request_count), 0)",
+			"count": "SUM(server_error_count)",
+		}}},
+	"api_duration_ms": {Table: "api_metrics", Target: "service_name", Aggregations: valueAggregations, value: "duration_ms",
+		rollup: &alertRollup{view: "api_performance_1m", width: time.Minute, events: "request_count", values: map[string]string{
+			"avg": "SUM(avg_duration_ms * request_count) / NULLIF(SUM(request_count), 0)",
+			"p95": "SUM(p95_duration_ms * request_count) / NULLIF(SUM(request_count), 0)",
+			"p99": "SUM(p99_duration_ms * request_count) / NULLIF(SUM(request_count), 0)",
+		}}},
+	"lcp_ms":  vitalMetric("lcp_ms", "lcp_ms"),
+	"inp_ms":  vitalMetric("inp_ms", "inp_ms"),
+	"cls":     vitalMetric("cls", "cls"),
+	"fcp_ms":  vitalMetric("fcp_ms", ""),
+	"ttfb_ms": vitalMetric("ttfb_ms", ""),
+}
+
+// vitalMetric is a Web Vitals column; the target selects a device type and
+// rules default to the p75 Google uses for its thresholds. rollupSuffix
+// names the column in web_vitals_hourly (avg_<suffix>, p75_<suffix>), empty
+// if it has none.
+func vitalMetric(column, rollupSuffix string) AlertMetric {
+	m := AlertMetric{
+		Table:        "frontend_metrics",
+		Target:       "device_type",
+		Aggregations: []string{"p75", "p50", "p95", "p99", "avg", "min", "max"},
+		value:        column,
+		filter:       "event_type = 'web_vital'",
+	}
+	if rollupSuffix != "" {
+		m.rollup = &alertRollup{view: "web_vitals_hourly", width: time.Hour, events: "sample_count", values: map[string]string{
+			"avg": "SUM(avg_" + rollupSuffix + " * sample_count) / NULLIF(SUM(sample_count), 0)",
+			"p75": "SUM(p75_" + rollupSuffix + " * sample_count) / NULLIF(SUM(sample_count), 0)",
+		}}
+	}
+	return m
+}
+
+// Supports reports whether aggregation applies to the metric
+func (m AlertMetric) Supports(aggregation string) bool {
+	return slices.Contains(m.Aggregations, aggregation)
+}
+
+// SupportsBaseline reports whether the metric's rollup has the aggregation,
+// so rules on it can use an adaptive threshold. Percentiles are averaged
+// over the rollup's buckets.
+func (m AlertMetric) SupportsBaseline(aggregation string) bool {
+	if m.rollup == nil {
+		return false
+	}
+	_, ok := m.rollup.values[aggregation]
+	return ok
+}
+
+// BaselineAggregations lists the aggregations SupportsBaseline accepts
+func (m AlertMetric) BaselineAggregations() []string {
+	var list []string
+	for _, a := range m.Aggregations {
+		if m.SupportsBaseline(a) {
+			list = append(list, a)
+		}
+	}
+	return list
+}
+
+// query selects the samples and the aggregated value; $1 is the target
+// (empty for all), $2 the start, $3 the site (empty for all)
+func (m AlertMetric) query(aggregation string) string {
+	samples := "COUNT(" + m.value + ")"
+	var value string
+	switch aggregation {
+	case "rate":
+		samples = "COUNT(*)"
+		value = "100.0 * COUNT(*) FILTER (WHERE " + m.value + ") / NULLIF(COUNT(*), 0)"
+	case "count":
+		samples = "COUNT(*)"
+		value = "COUNT(*) FILTER (WHERE " + m.value + ")"
+	case "avg", "min", "max":
+		value = strings.ToUpper(aggregation) + "(" + m.value + ")"
+	default: // pNN
+		value = "PERCENTILE_CONT(0." + strings.TrimPrefix(aggregation, "p") + ") WITHIN GROUP (ORDER BY " + m.value + ")"
+	}
+
+	query := `
+		SELECT ` + samples + `, COALESCE(` + value + `, 0)::float8
+		FROM ` + m.Table + `
+		WHERE time >= $2 AND ($1 = '' OR ` + m.Target + ` = $1) AND ($3 = '' OR site_id = $3)`
+	if m.filter != "" {
+		query += " AND " + m.filter
+	}
+	return query
+}
+
+// AlertMetricValue is a metric over an evaluation window
+type AlertMetricValue struct {
+	Value   float64
+	Samples int64
+}
+
+// GetAlertMetric returns one of the AlertMetrics of a site, aggregated
+// since start; an empty target or site covers everything
+func (p *Postgres) GetAlertMetric(ctx context.Context, metric, aggregation, target, site string, start time.Time) (AlertMetricValue, error) {
+	m, ok := AlertMetrics[metric]
+	if !ok {
+		return AlertMetricValue{}, fmt.Errorf("unknown alert metric %q", metric)
+	}
+	if !m.Supports(aggregation) {
+		return AlertMetricValue{}, fmt.Errorf("aggregation %q not supported by %s", aggregation, metric)
+	}
+
+	var v AlertMetricValue
+	if err := p.pool.QueryRow(ctx, m.query(aggregation), target, start, site).Scan(&v.Samples, &v.Value); err != nil {
+		return AlertMetricValue{}, fmt.Errorf("query %s %s: %w", aggregation, metric, err)
+	}
+	return v, nil
+}
+
+// GetAlertBaseline returns one of the AlertMetrics aggregated from its
+// rollup between start and end; an empty target covers everything. Counts
+// are scaled to the number expected in one window.
+func (p *Postgres) GetAlertBaseline(ctx context.Context, metric, aggregation, target string, start, end time.Time, window time.Duration) (AlertMetricValue, error) {
+	m, ok := AlertMetrics[metric]
+	if !ok {
+		return AlertMetricValue{}, fmt.Errorf("unknown alert metric %q", metric)
+	}
+	if !m.SupportsBaseline(aggregation) {
+		return AlertMetricValue{}, fmt.Errorf("aggregation %q has no baseline for %s", aggregation, metric)
+	}
+
+	var v AlertMetricValue
+	var covered float64
+	err := p.pool.QueryRow(ctx, `
+		SELECT COALESCE(SUM(`+m.rollup.events+`), 0)::bigint,
+		       COALESCE(`+m.rollup.values[aggregation]+`, 0)::float8,
+		       COALESCE(EXTRACT(EPOCH FROM MAX(bucket) - MIN(bucket)), 0)::float8
+		FROM `+m.rollup.view+`
+		WHERE bucket >= $2 AND bucket < $3 AND ($1 = '' OR `+m.Target+` = $1)
+	`, target, start, end).Scan(&v.Samples, &v.Value, &covered)
+	if err != nil {
+		return AlertMetricValue{}, fmt.Errorf("query %s %s baseline: %w", aggregation, metric, err)
+	}
+	if aggregation == "count" {
+		v.Value *= window.Seconds() / (covered + m.rollup.width.Seconds())
+	}
+	return v, nil
+}
+
+// ============================================
+// LIVE STATS
+// ============================================
+
+// LiveStats are rolling aggregates over the last few seconds to minutes
+type LiveStats struct {
+	Time            time.Time `json:"time"`
+	WindowSeconds   float64   `json:"window_seconds"`
+	Requests        int64     `json:"requests"`
+	RequestsPerSec  float64   `json:"requests_per_sec"`
+	ErrorRate       float64   `json:"error_rate"` // API responses with status >= 500 (%)
+	PSPTransactions int64     `json:"psp_transactions"`
+	PSPSuccessRate  *float64  `json:"psp_success_rate"` // nil without transactions
+	ActiveSessions  int64     `json:"active_sessions"`  // Sessions with frontend events in the session window
+}
+
+// GetLiveStats returns API throughput and errors and PSP success over the
+// last window, and sessions active within the last sessionWindow. It reads
+// raw metrics, since the aggregates lag behind by minutes.
+func (p *Postgres) GetLiveStats(ctx context.Context, now time.Time, window, sessionWindow time.Duration, sites []string) (LiveStats, error) {
+	s := LiveStats{Time: now, WindowSeconds: window.Seconds()}
+	err := p.pool.QueryRow(ctx, `
+		SELECT
+			(SELECT COUNT(*) FROM api_metrics
+			 WHERE time >= $1 AND time < $2 AND ($4::text[] IS NULL OR site_id = ANY($4))),
+			(SELECT COALESCE(100.0 * COUNT(*) FILTER (WHERE status_code >= 500) / NULLIF(COUNT(*), 0), 0)
+			 FROM api_metrics
+			 WHERE time >= $1 AND time < $2 AND ($4::text[] IS NULL OR site_id = ANY($4))),
+			(SELECT COUNT(*) FROM psp_metrics
+			 WHERE time >= $1 AND time < $2 AND ($4::text[] IS NULL OR site_id = ANY($4))),
+			(SELECT 100.0 * COUNT(*) FILTER (WHERE success) / NULLIF(COUNT(*), 0)
+			 FROM psp_metrics
+			 WHERE time >= $1 AND time < $2 AND ($4::text[] IS NULL OR site_id = ANY($4))),
+			(SELECT COUNT(DISTINCT session_id) FROM frontend_metrics
+			 WHERE time >= $3 AND time < $2 AND ($4::text[] IS NULL OR site_id = ANY($4)))
+	`, now.Add(-window), now, now.Add(-sessionWindow), sites).Scan(
+		&s.Requests, &s.ErrorRate, &s.PSPTransactions, &s.PSPSuccessRate, &s.ActiveSessions,
+	)
+	if err != nil {
+		return LiveStats{}, fmt.Errorf("query live stats: %w", err)
+	}
+	if window > 0 {
+		s.RequestsPerSec = float64(s.Requests) / window.Seconds()
+	}
+	return s, nil
+}
+
+// ============================================
+// DIAGNOSTIC CAPTURES
+// ============================================
+
+// Capture is a time-boxed recording of the requests of one site and/or
+// client IP range, enabled by an admin for a support escalation
+type Capture struct {
+	ID         int64      `json:"id"`
+	SiteID     string     `json:"site_id,omitempty"`
+	ClientIP   string     `json:"client_ip,omitempty"` // Address or CIDR range
+	Note       string     `json:"note,omitempty"`
+	CreatedBy  string     `json:"created_by"`
+	StartedAt  time.Time  `json:"started_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	StoppedAt  *time.Time `json:"stopped_at,omitempty"`
+	MaxRecords int        `json:"max_records"`
+	Records    int64      `json:"records"`
+}
+
+// CaptureRecord is one recorded request/response pair, with secrets
+// redacted
+type CaptureRecord struct {
+	ID                int64           `json:"id"`
+	CaptureID         int64           `json:"capture_id"`
+	Time              time.Time       `json:"time"`
+	Method            string          `json:"method"`
+	Path              string          `json:"path"` // With query string
+	ClientIP          string          `json:"client_ip"`
+	SiteID            string          `json:"site_id,omitempty"`
+	RequestHeaders    json.RawMessage `json:"request_headers"`
+	RequestBody       string          `json:"request_body"`
+	RequestTruncated  bool            `json:"request_truncated"`
+	Status            int             `json:"status"`
+	ResponseHeaders   json.RawMessage `json:"response_headers"`
+	ResponseBody      string          `json:"response_body"`
+	ResponseTruncated bool            `json:"response_truncated"`
+	DurationMS        float64         `json:"duration_ms"`
+}
+
+const captureColumns = `c.id, COALESCE(c.site_id, ''), COALESCE(c.client_ip, ''), COALESCE(c.note, ''),
+	c.created_by, c.started_at, c.expires_at, c.stopped_at, c.max_records,
+	(SELECT COUNT(*) FROM diagnostic_records r WHERE r.capture_id = c.id)`
+
+func scanCapture(row pgx.Row) (Capture, error) {
+	var c Capture
+	err := row.Scan(&c.ID, &c.SiteID, &c.ClientIP, &c.Note,
+		&c.CreatedBy, &c.StartedAt, &c.ExpiresAt, &c.StoppedAt, &c.MaxRecords, &c.Records)
+	return c, err
+}
+
+// CreateCapture starts a capture
+func (p *Postgres) CreateCapture(ctx context.Context, c Capture) (Capture, error) {
+	created, err := scanCapture(p.pool.QueryRow(ctx, `
+		WITH c AS (
+			INSERT INTO diagnostic_captures (site_id, client_ip, note, created_by, started_at, expires_at, max_records)
+			VALUES (NULLIF($1, ''), NULLIF($2, ''), NULLIF($3, ''), $4, $5, $6, $7)
+			RETURNING *
+		)
+		SELECT `+captureColumns+` FROM c
+	`, c.SiteID, c.ClientIP, c.Note, c.CreatedBy, c.StartedAt, c.ExpiresAt, c.MaxRecords))
+	if err != nil {
+		return Capture{}, fmt.Errorf("create capture: %w", err)
+	}
+	return created, nil
+}
+
+// GetCaptures lists all captures with their record counts, newest first
+func (p *Postgres) GetCaptures(ctx context.Context) ([]Capture, error) {
+	return p.queryCaptures(ctx, `
+		SELECT `+captureColumns+` FROM diagnostic_captures c ORDER BY c.started_at DESC
+	`)
+}
+
+// GetActiveCaptures returns captures that are neither stopped nor expired
+func (p *Postgres) GetActiveCaptures(ctx context.Context) ([]Capture, error) {
+	return p.queryCaptures(ctx, `
+		SELECT `+captureColumns+` FROM diagnostic_captures c
+		WHERE c.stopped_at IS NULL AND c.expires_at > NOW()
+	`)
+}
+
+func (p *Postgres) queryCaptures(ctx context.Context, sql string, args ...any) ([]Capture, error) {
+	rows, err := p.pool.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query captures: %w", err)
+	}
+	defer rows.Close()
+
+	var result []Capture
+	for rows.Next() {
+		c, err := scanCapture(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan row: %w", err)
+		}
+		result = append(result, c)
+	}
+
+	return result, rows.Err()
+}
+
+// StopCapture ends a capture before it expires. It returns false if there
+// is no such capture or it already ended.
+func (p *Postgres) StopCapture(ctx context.Context, id int64) (bool, error) {
+	tag, err := p.pool.Exec(ctx, `
+		UPDATE diagnostic_captures SET stopped_at = NOW()
+		WHERE id = $1 AND stopped_at IS NULL AND expires_at > NOW()
+	`, id)
+	if err != nil {
+		return false, fmt.Errorf("stop capture %d: %w", id, err)
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// InsertCaptureRecords stores recorded requests. Records beyond a capture's
+// max_records, counted across all collectors, are discarded.
+func (p *Postgres) InsertCaptureRecords(ctx context.Context, records []CaptureRecord) error {
+	if len(records) == 0 {
+		return nil
+	}
+
+	batch := &pgx.Batch{}
+	for _, r := range records {
+		batch.Queue(`
+			INSERT INTO diagnostic_records (
+				capture_id, time, method, path, client_ip, site_id,
+				request_headers, request_body, request_truncated,
+				status, response_headers, response_body, response_truncated, duration_ms
+			)
+			SELECT $1, $2, $3, $4, $5, NULLIF($6, ''), $7, $8, $9, $10, $11, $12, $13, $14
+			FROM diagnostic_captures c
+			WHERE c.id = $1
+			  AND (SELECT COUNT(*) FROM diagnostic_records WHERE capture_id = $1) < c.max_records
+		`, r.CaptureID, r.Time, r.Method, r.Path, r.ClientIP, r.SiteID,
+			r.RequestHeaders, r.RequestBody, r.RequestTruncated,
+			r.Status, r.ResponseHeaders, r.ResponseBody, r.ResponseTruncated, r.DurationMS)
+	}
+	if err := p.pool.SendBatch(ctx, batch).Close(); err != nil {
+		return fmt.Errorf("insert capture records: %w", classify(err))
+	}
+	return nil
+}
+
+// GetCaptureRecords returns the records of a capture, oldest first
+func (p *Postgres) GetCaptureRecords(ctx context.Context, captureID int64, limit, offset int) ([]CaptureRecord, error) {
+	rows, err := p.pool.Query(ctx, `
+		SELECT id, capture_id, time, method, path, client_ip, COALESCE(site_id, ''),
+		       request_headers, request_body, request_truncated,
+		       status, response_headers, response_body, response_truncated, duration_ms
+		FROM diagnostic_records
+		WHERE capture_id = $1
+		ORDER BY time, id
+		LIMIT $2 OFFSET $3
+	`, captureID, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("query capture records: %w", err)
+	}
+	defer rows.Close()
+
+	var result []CaptureRecord
+	for rows.Next() {
+		var r CaptureRecord
+		if err := rows.Scan(
+			&r.ID, &r.CaptureID, &r.Time, &r.Method, &r.Path, &r.ClientIP, &r.SiteID,
+			&r.RequestHeaders, &r.RequestBody, &r.RequestTruncated,
+			&r.Status, &r.ResponseHeaders, &r.ResponseBody, &r.ResponseTruncated, &r.DurationMS,
+		); err != nil {
+			return nil, fmt.Errorf("scan row: %w", err)
+		}
+		result = append(result, r)
+	}
+
+	return result, rows.Err()
+}
+
+// DeleteEndedCaptures removes captures, with their records, that ended
+// before the given time
+func (p *Postgres) DeleteEndedCaptures(ctx context.Context, before time.Time) (int64, error) {
+	tag, err := p.pool.Exec(ctx, `
+		DELETE FROM diagnostic_captures
+		WHERE LEAST(COALESCE(stopped_at, expires_at), expires_at) < $1
+	`, before)
+	if err != nil {
+		return 0, fmt.Errorf("delete ended captures: %w", err)
+	}
+	return tag.RowsAffected(), nil
+}
+
+// ============================================
+// SERVICE LEVEL OBJECTIVES
+// ============================================
+
+// SLI is an indicator objectives can be defined on: the share of good
+// events among all events of a target
+type SLI struct {
+	Target string // What an objective's target selects, e.g. "psp_name"
+	query  string // $1 target ('' for all), $2 start, $3 end; returns good, total
+}
+
+// SLIs are the indicators SLOs can use. They read the continuous
+// aggregates, since objective windows span weeks.
+var SLIs = map[string]SLI{
+	"psp_success": {Target: "psp_name", query: `
+		SELECT COALESCE(SUM(success_count), 0), COALESCE(SUM(total_count), 0)
+		FROM psp_success_5m
+		WHERE bucket >= $2 AND bucket < $3 AND ($1 = '' OR psp_name = $1)
+	`},
+	"psp_deposit_success": {Target: "psp_name", query: `
+		SELECT COALESCE(SUM(success_count), 0), COALESCE(SUM(total_count), 0)
+		FROM psp_success_5m
+		WHERE bucket >= $2 AND bucket < $3 AND operation = 'deposit' AND ($1 = '' OR psp_name = $1)
+	`},
+	"game_launch_success": {Target: "provider", query: `
+		SELECT COALESCE(SUM(success_count), 0), COALESCE(SUM(launch_count), 0)
+		FROM game_health_5m
+		WHERE bucket >= $2 AND bucket < $3 AND ($1 = '' OR provider = $1)
+	`},
+	"api_availability": {Target: "service_name", query: `
+		SELECT COALESCE(SUM(request_count - server_error_count), 0), COALESCE(SUM(request_count), 0)
+		FROM api_performance_1m
+		WHERE bucket >= $2 AND bucket < $3 AND ($1 = '' OR service_name = $1)
+	`},
+}
+
+// SLICounts are the good and total events of an SLI over a period
+type SLICounts struct {
+	Good  int64 `json:"good"`
+	Total int64 `json:"total"`
+}
+
+// SLO is a service level objective with the counts of its last evaluation
+type SLO struct {
+	Name        string     `json:"name"`
+	SLI         string     `json:"sli"`
+	Target      string     `json:"target,omitempty"` // Empty for all targets
+	Objective   float64    `json:"objective"`        // Good events (%)
+	WindowDays  int        `json:"window_days"`
+	Description string     `json:"description,omitempty"`
+	CreatedBy   string     `json:"created_by"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	EvaluatedAt *time.Time `json:"evaluated_at,omitempty"`
+	Window      SLICounts  `json:"window"` // Over the objective's window
+	Burn        SLICounts  `json:"burn"`   // Over the burn rate window
+}
+
+// ErrSLOExists is returned when an SLO name is taken. It matches ErrConflict.
+var ErrSLOExists = fmt.Errorf("slo already exists: %w", ErrConflict)
+
+// ErrSLONotFound is returned for updates of unknown SLOs
+var ErrSLONotFound = errors.New("slo not found")
+
+const sloColumns = `name, sli, target, objective, window_days, COALESCE(description, ''),
+	created_by, created_at, updated_at, evaluated_at,
+	window_good, window_total, burn_good, burn_total`
+
+func scanSLO(row pgx.Row) (SLO, error) {
+	var s SLO
+	err := row.Scan(&s.Name, &s.SLI, &s.Target, &s.Objective, &s.WindowDays, &s.Description,
+		&s.CreatedBy, &s.CreatedAt, &s.UpdatedAt, &s.EvaluatedAt,
+		&s.Window.Good, &s.Window.Total, &s.Burn.Good, &s.Burn.Total)
+	return s, err
+}
+
+// CreateSLO stores a new objective; it is evaluated by the next SLO run
+func (p *Postgres) CreateSLO(ctx context.Context, s SLO) (SLO, error) {
+	created, err := scanSLO(p.pool.QueryRow(ctx, `
+		INSERT INTO slo_objectives (name, sli, target, objective, window_days, description, created_by)
+		VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''), $7)
+		RETURNING `+sloColumns,
+		s.Name, s.SLI, s.Target, s.Objective, s.WindowDays, s.Description, s.CreatedBy))
+	if errors.Is(classify(err), ErrConflict) {
+		return created, ErrSLOExists
+	}
+	if err != nil {
+		return created, fmt.Errorf("create slo %s: %w", s.Name, err)
+	}
+	return created, nil
+}
+
+// UpdateSLO replaces the definition of an objective. Counts of the last
+// evaluation are cleared when the SLI, target or window change.
+func (p *Postgres) UpdateSLO(ctx context.Context, s SLO) (SLO, error) {
+	updated, err := scanSLO(p.pool.QueryRow(ctx, `
+		UPDATE slo_objectives o SET
+			sli = $2, target = $3, objective = $4, window_days = $5,
+			description = NULLIF($6, ''), updated_at = NOW(),
+			evaluated_at = CASE WHEN same THEN evaluated_at END,
+			window_good = CASE WHEN same THEN window_good ELSE 0 END,
+			window_total = CASE WHEN same THEN window_total ELSE 0 END,
+			burn_good = CASE WHEN same THEN burn_good ELSE 0 END,
+			burn_total = CASE WHEN same THEN burn_total ELSE 0 END
+		FROM (
+			SELECT name AS prev_name, (sli = $2 AND target = $3 AND window_days = $5) AS same
+			FROM slo_objectives WHERE name = $1
+		) prev
+		WHERE o.name = prev.prev_name
+		RETURNING `+sloColumns,
+		s.Name, s.SLI, s.Target, s.Objective, s.WindowDays, s.Description))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return updated, ErrSLONotFound
+	}
+	if err != nil {
+		return updated, fmt.Errorf("update slo %s: %w", s.Name, err)
+	}
+	return updated, nil
+}
+
+// DeleteSLO removes an objective
+func (p *Postgres) DeleteSLO(ctx context.Context, name string) (bool, error) {
+	tag, err := p.pool.Exec(ctx, `DELETE FROM slo_objectives WHERE name = $1`, name)
+	if err != nil {
+		return false, fmt.Errorf("delete slo %s: %w", name, err)
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// GetSLOs lists all objectives with their last evaluation
+func (p *Postgres) GetSLOs(ctx context.Context) ([]SLO, error) {
+	rows, err := p.pool.Query(ctx, `SELECT `+sloColumns+` FROM slo_objectives ORDER BY name`)
+	if err != nil {
+		return nil, fmt.Errorf("query slos: %w", err)
+	}
+	defer rows.Close()
+
+	var result []SLO
+	for rows.Next() {
+		s, err := scanSLO(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan row: %w", err)
+		}
+		result = append(result, s)
+	}
+
+	return result, rows.Err()
+}
+
+// GetSLICounts returns the good and total events of one of the SLIs in
+// [start, end); an empty target covers everything
+func (p *Postgres) GetSLICounts(ctx context.Context, sli, target string, start, end time.Time) (SLICounts, error) {
+	s, ok := SLIs[sli]
+	if !ok {
+		return SLICounts{}, fmt.Errorf("unknown sli %q", sli)
+	}
+
+	var c SLICounts
+	if err := p.pool.QueryRow(ctx, s.query, target, start, end).Scan(&c.Good, &c.Total); err != nil {
+		return SLICounts{}, fmt.Errorf("query %s: %w", sli, err)
+	}
+	return c, nil
+}
+
+// UpdateSLOStatus records the counts of an evaluation
+func (p *Postgres) UpdateSLOStatus(ctx context.Context, name string, at time.Time, window, burn SLICounts) error {
+	_, err := p.pool.Exec(ctx, `
+		UPDATE slo_objectives
+		SET evaluated_at = $2, window_good = $3, window_total = $4, burn_good = $5, burn_total = $6
+		WHERE name = $1
+	`, name, at, window.Good, window.Total, burn.Good, burn.Total)
+	if err != nil {
+		return fmt.Errorf("update slo status %s: %w", name, err)
+	}
+	return nil
+}
+
+// ============================================
+// SAVED DASHBOARDS
+// ============================================
+
+// Dashboard is a named dashboard configuration saved by a user
+type Dashboard struct {
+	ID        int64           `json:"id"`
+	Name      string          `json:"name"`
+	Owner     string          `json:"owner"`             // Email of the creating user
+	SiteID    string          `json:"site_id,omitempty"` // Empty for dashboards across sites
+	Shared    bool            `json:"shared"`            // Visible to every user who can see the site
+	Config    json.RawMessage `json:"config"`            // Panels, filters and time range, as the frontend stores them
+	CreatedAt time.Time       `json:"created_at"`
+	UpdatedAt time.Time       `json:"updated_at"`
+}
+
+// ErrDashboardNotFound is returned for unknown dashboard ids
+var ErrDashboardNotFound = errors.New("dashboard not found")
+
+const dashboardColumns = `id, name, owner_email, COALESCE(site_id, ''), shared, config, created_at, updated_at`
+
+func scanDashboard(row pgx.Row) (Dashboard, error) {
+	var d Dashboard
+	err := row.Scan(&d.ID, &d.Name, &d.Owner, &d.SiteID, &d.Shared, &d.Config, &d.CreatedAt, &d.UpdatedAt)
+	return d, err
+}
+
+// CreateDashboard stores a new dashboard
+func (p *Postgres) CreateDashboard(ctx context.Context, d Dashboard) (Dashboard, error) {
+	created, err := scanDashboard(p.pool.QueryRow(ctx, `
+		INSERT INTO dashboards (name, owner_email, site_id, shared, config)
+		VALUES ($1, $2, NULLIF($3, ''), $4, $5)
+		RETURNING `+dashboardColumns,
+		d.Name, d.Owner, d.SiteID, d.Shared, d.Config))
+	if err != nil {
+		return created, fmt.Errorf("create dashboard %s: %w", d.Name, classify(err))
+	}
+	return created, nil
+}
+
+// GetDashboard returns a dashboard by id
+func (p *Postgres) GetDashboard(ctx context.Context, id int64) (Dashboard, error) {
+	d, err := scanDashboard(p.pool.QueryRow(ctx, `
+		SELECT `+dashboardColumns+` FROM dashboards WHERE id = $1
+	`, id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return d, ErrDashboardNotFound
+	}
+	if err != nil {
+		return d, fmt.Errorf("query dashboard %d: %w", id, err)
+	}
+	return d, nil
+}
+
+// GetDashboards lists the dashboards of owner and the shared dashboards of
+// sites (nil for all sites; shared dashboards across sites are always
+// included)
+func (p *Postgres) GetDashboards(ctx context.Context, owner string, sites []string) ([]Dashboard, error) {
+	rows, err := p.pool.Query(ctx, `
+		SELECT `+dashboardColumns+`
+		FROM dashboards
+		WHERE owner_email = $1
+		   OR (shared AND (site_id IS NULL OR $2::text[] IS NULL OR site_id = ANY($2)))
+		ORDER BY name, id
+	`, owner, sites)
+	if err != nil {
+		return nil, fmt.Errorf("query dashboards: %w", err)
+	}
+	defer rows.Close()
+
+	var result []Dashboard
+	for rows.Next() {
+		d, err := scanDashboard(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan row: %w", err)
+		}
+		result = append(result, d)
+	}
+
+	return result, rows.Err()
+}
+
+// UpdateDashboard replaces the name, site, sharing and config of a
+// dashboard; the owner stays
+func (p *Postgres) UpdateDashboard(ctx context.Context, d Dashboard) (Dashboard, error) {
+	updated, err := scanDashboard(p.pool.QueryRow(ctx, `
+		UPDATE dashboards
+		SET name = $2, site_id = NULLIF($3, ''), shared = $4, config = $5, updated_at = NOW()
+		WHERE id = $1
+		RETURNING `+dashboardColumns,
+		d.ID, d.Name, d.SiteID, d.Shared, d.Config))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return updated, ErrDashboardNotFound
+	}
+	if err != nil {
+		return updated, fmt.Errorf("update dashboard %d: %w", d.ID, classify(err))
+	}
+	return updated, nil
+}
+
+// DeleteDashboard removes a dashboard
+func (p *Postgres) DeleteDashboard(ctx context.Context, id int64) (bool, error) {
+	tag, err := p.pool.Exec(ctx, `DELETE FROM dashboards WHERE id = $1`, id)
+	if err != nil {
+		return false, fmt.Errorf("delete dashboard %d: %w", id, err)
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// ============================================
+// CURRENCIES
+// ============================================
+
+// CurrencyUsageRow is a currency seen in PSP transactions
+type CurrencyUsageRow struct {
+	Currency     string    `json:"currency"`
+	Transactions int64     `json:"transactions"`
+	Fractional   bool      `json:"fractional"` // Some amounts have a fractional part
+	LastSeen     time.Time `json:"last_seen"`
+}
+
+// GetCurrencyUsage returns the currencies of PSP transactions since start,
+// most used first
+func (p *Postgres) GetCurrencyUsage(ctx context.Context, start time.Time, sites []string) ([]CurrencyUsageRow, error) {
+	rows, err := p.pool.Query(ctx, `
+		SELECT
+			UPPER(currency),
+			COUNT(*),
+			COALESCE(BOOL_OR(amount <> TRUNC(amount)), false),
+			MAX(time)
+		FROM psp_metrics
+		WHERE time >= $1
+		  AND currency IS NOT NULL AND currency <> ''
+		  AND ($2::text[] IS NULL OR site_id = ANY($2))
+		GROUP BY UPPER(currency)
+		ORDER BY COUNT(*) DESC, UPPER(currency)
+	`, start, sites)
+	if err != nil {
+		return nil, fmt.Errorf("query currency usage: %w", err)
+	}
+	defer rows.Close()
+
+	var result []CurrencyUsageRow
+	for rows.Next() {
+		var r CurrencyUsageRow
+		if err := rows.Scan(&r.Currency, &r.Transactions, &r.Fractional, &r.LastSeen); err != nil {
+			return nil, fmt.Errorf("scan row: %w", err)
+		}
+		result = append(result, r)
+	}
+
+	return result, rows.Err()
+}
+
+// ============================================
+// ALERT RULES
+// ============================================
+
+// States of alert rules
+const (
+	AlertRuleInactive = "inactive" // Not fired since created or enabled
+	AlertRuleFiring   = "firing"
+	AlertRuleResolved = "resolved"
+)
+
+// AlertRule is a threshold rule on one of the AlertMetrics with the
+// outcome of its last evaluation. Adaptive rules derive their threshold
+// from a baseline learned from the rollups: baseline * (1 + BaselinePercent/100).
+type AlertRule struct {
+	Name            string        `json:"name"`
+	Metric          string        `json:"metric"`
+	Aggregation     string        `json:"aggregation"`
+	Target          string        `json:"target,omitempty"`  // Empty for all targets
+	SiteID          string        `json:"site_id,omitempty"` // Empty for all sites
+	Operator        string        `json:"operator"`          // < or >
+	Threshold       float64       `json:"threshold"`         // Derived from the baseline for adaptive rules
+	Adaptive        bool          `json:"adaptive"`
+	BaselinePercent float64       `json:"baseline_percent,omitempty"` // Signed, e.g. 20 for baseline + 20%
+	BaselineWeeks   int           `json:"baseline_weeks,omitempty"`   // Trailing weeks the baseline is learned from
+	Baseline        *float64      `json:"baseline,omitempty"`         // nil until there is enough history
+	BaselineAt      *time.Time    `json:"baseline_at,omitempty"`
+	Severity        string        `json:"severity"`
+	Interval        time.Duration `json:"-"` // Evaluation interval
+	Window          time.Duration `json:"-"` // Lookback window
+	Enabled         bool          `json:"enabled"`
+	Webhooks        []string      `json:"webhooks"` // Webhooks notified of its alerts
+	CreatedBy       string        `json:"created_by"`
+	CreatedAt       time.Time     `json:"created_at"`
+	UpdatedAt       time.Time     `json:"updated_at"`
+	State           string        `json:"state"`
+	StateChangedAt  *time.Time    `json:"state_changed_at,omitempty"`
+	EvaluatedAt     *time.Time    `json:"evaluated_at,omitempty"`
+	LastValue       *float64      `json:"last_value,omitempty"`
+	LastSamples     int64         `json:"last_samples"`
+	LastError       string        `json:"last_error,omitempty"`
+}
+
+// ErrAlertRuleExists is returned when a rule name is taken. It matches
+// ErrConflict.
+var ErrAlertRuleExists = fmt.Errorf("alert rule already exists: %w", ErrConflict)
+
+// ErrAlertRuleNotFound is returned for unknown rule names
+var ErrAlertRuleNotFound = errors.New("alert rule not found")
+
+const alertRuleColumns = `name, metric, aggregation, target, site_id, operator, threshold,
+	adaptive, baseline_percent, baseline_weeks, baseline, baseline_at, severity,
+	interval_seconds, window_seconds, enabled, created_by, created_at, updated_at,
+	state, state_changed_at, evaluated_at, last_value, last_samples, COALESCE(last_error, ''), webhooks`
+
+func scanAlertRule(row pgx.Row) (AlertRule, error) {
+	var r AlertRule
+	var interval, window int64
+	err := row.Scan(&r.Name, &r.Metric, &r.Aggregation, &r.Target, &r.SiteID, &r.Operator, &r.Threshold,
+		&r.Adaptive, &r.BaselinePercent, &r.BaselineWeeks, &r.Baseline, &r.BaselineAt, &r.Severity,
+		&interval, &window, &r.Enabled, &r.CreatedBy, &r.CreatedAt, &r.UpdatedAt,
+		&r.State, &r.StateChangedAt, &r.EvaluatedAt, &r.LastValue, &r.LastSamples, &r.LastError, &r.Webhooks)
+	r.Interval, r.Window = time.Duration(interval)*time.Second, time.Duration(window)*time.Second
+	return r, err
+}
+
+// CreateAlertRule stores a new rule
+func (p *Postgres) CreateAlertRule(ctx context.Context, r AlertRule) (AlertRule, error) {
+	if r.Webhooks == nil {
+		r.Webhooks = []string{}
+	}
+	created, err := scanAlertRule(p.pool.QueryRow(ctx, `
+		INSERT INTO alert_rules (
+			name, metric, aggregation, target, operator, threshold,
+			adaptive, baseline_percent, baseline_weeks, baseline, baseline_at, severity,
+			interval_seconds, window_seconds, enabled, created_by, site_id, webhooks
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18)
+		RETURNING `+alertRuleColumns,
+		r.Name, r.Metric, r.Aggregation, r.Target, r.Operator, r.Threshold,
+		r.Adaptive, r.BaselinePercent, r.BaselineWeeks, r.Baseline, r.BaselineAt, r.Severity,
+		int64(r.Interval.Seconds()), int64(r.Window.Seconds()), r.Enabled, r.CreatedBy, r.SiteID, r.Webhooks))
+	if errors.Is(classify(err), ErrConflict) {
+		return created, ErrAlertRuleExists
+	}
+	if err != nil {
+		return created, fmt.Errorf("create alert rule %s: %w", r.Name, err)
+	}
+	return created, nil
+}
+
+// UpdateAlertRule replaces the definition of a rule. Disabling a rule makes
+// it inactive.
+func (p *Postgres) UpdateAlertRule(ctx context.Context, r AlertRule) (AlertRule, error) {
+	if r.Webhooks == nil {
+		r.Webhooks = []string{}
+	}
+	updated, err := scanAlertRule(p.pool.QueryRow(ctx, `
+		UPDATE alert_rules SET
+			metric = $2, aggregation = $3, target = $4, operator = $5, threshold = $6, severity = $7,
+			interval_seconds = $8, window_seconds = $9, enabled = $10, updated_at = NOW(),
+			adaptive = $11, baseline_percent = $12, baseline_weeks = $13, baseline = $14, baseline_at = $15,
+			site_id = $16, webhooks = $17,
+			state = CASE WHEN $10 THEN state ELSE 'inactive' END,
+			state_changed_at = CASE WHEN $10 OR state = 'inactive' THEN state_changed_at ELSE NOW() END
+		WHERE name = $1
+		RETURNING `+alertRuleColumns,
+		r.Name, r.Metric, r.Aggregation, r.Target, r.Operator, r.Threshold, r.Severity,
+		int64(r.Interval.Seconds()), int64(r.Window.Seconds()), r.Enabled,
+		r.Adaptive, r.BaselinePercent, r.BaselineWeeks, r.Baseline, r.BaselineAt, r.SiteID, r.Webhooks))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return updated, ErrAlertRuleNotFound
+	}
+	if err != nil {
+		return updated, fmt.Errorf("update alert rule %s: %w", r.Name, err)
+	}
+	return updated, nil
+}
+
+// DeleteAlertRule removes a rule
+func (p *Postgres) DeleteAlertRule(ctx context.Context, name string) (bool, error) {
+	tag, err := p.pool.Exec(ctx, `DELETE FROM alert_rules WHERE name = $1`, name)
+	if err != nil {
+		return false, fmt.Errorf("delete alert rule %s: %w", name, err)
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// GetAlertRule returns one rule
+func (p *Postgres) GetAlertRule(ctx context.Context, name string) (AlertRule, error) {
+	r, err := scanAlertRule(p.pool.QueryRow(ctx, `SELECT `+alertRuleColumns+` FROM alert_rules WHERE name = $1`, name))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return r, ErrAlertRuleNotFound
+	}
+	if err != nil {
+		return r, fmt.Errorf("query alert rule %s: %w", name, err)
+	}
+	return r, nil
+}
+
+// GetAlertRules lists all rules with their last evaluation
+func (p *Postgres) GetAlertRules(ctx context.Context) ([]AlertRule, error) {
+	rows, err := p.pool.Query(ctx, `SELECT `+alertRuleColumns+` FROM alert_rules ORDER BY name`)
+	if err != nil {
+		return nil, fmt.Errorf("query alert rules: %w", err)
+	}
+	defer rows.Close()
+
+	var result []AlertRule
+	for rows.Next() {
+		r, err := scanAlertRule(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan row: %w", err)
+		}
+		result = append(result, r)
+	}
+
+	return result, rows.Err()
+}
+
+// SetAlertRuleState moves an enabled rule to firing, or from firing to
+// resolved, and reports whether the state changed. Collectors evaluating
+// the same rule race on this update, so only one of them sees the change.
+func (p *Postgres) SetAlertRuleState(ctx context.Context, name, state string, at time.Time) (bool, error) {
+	tag, err := p.pool.Exec(ctx, `
+		UPDATE alert_rules
+		SET state = $2, state_changed_at = $3
+		WHERE name = $1 AND enabled AND state <> $2
+		  AND ($2 = 'firing' OR state = 'firing')
+	`, name, state, at)
+	if err != nil {
+		return false, fmt.Errorf("set alert rule state %s: %w", name, err)
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// SetAlertRuleBaseline stores a recomputed baseline and the threshold
+// derived from it; baseline is nil without enough history
+func (p *Postgres) SetAlertRuleBaseline(ctx context.Context, name string, baseline *float64, threshold float64, at time.Time) error {
+	_, err := p.pool.Exec(ctx, `
+		UPDATE alert_rules
+		SET baseline = $2, threshold = $3, baseline_at = $4
+		WHERE name = $1 AND adaptive
+	`, name, baseline, threshold, at)
+	if err != nil {
+		return fmt.Errorf("set alert rule baseline %s: %w", name, err)
+	}
+	return nil
+}
+
+// RecordAlertRuleEvaluation stores the outcome of an evaluation; value is
+// nil when it failed with errMsg
+func (p *Postgres) RecordAlertRuleEvaluation(ctx context.Context, name string, at time.Time, value *float64, samples int64, errMsg string) error {
+	_, err := p.pool.Exec(ctx, `
+		UPDATE alert_rules
+		SET evaluated_at = $2, last_value = $3, last_samples = $4, last_error = NULLIF($5, '')
+		WHERE name = $1
+	`, name, at, value, samples, errMsg)
+	if err != nil {
+		return fmt.Errorf("record alert rule evaluation %s: %w", name, err)
+	}
+	return nil
+}
+
+// ============================================
+// ANOMALY SERIES
+// ============================================
+
+// AnomalySource describes a series the anomaly detector learns a baseline
+// for, per target, in 5 minute buckets of the continuous aggregates
+type AnomalySource struct {
+	Table  string // Source table, recorded with the alert
+	Target string // What a point's key is, e.g. "psp_name"
+	query  string // $1 start, $2 end; returns bucket, key, value, events
+}
+
+// AnomalySources are the series anomaly detection watches
+var AnomalySources = map[string]AnomalySource{
+	"api_latency_ms": {Table: "api_metrics", Target: "service_name", query: `
+		SELECT time_bucket('5 minutes', bucket) AS b, service_name,
+		       (SUM(avg_duration_ms * request_count) / NULLIF(SUM(request_count), 0))::float8,
+		       SUM(request_count)
+		FROM api_performance_1m
+		WHERE bucket >= $1 AND bucket < $2
+		GROUP BY b, service_name
+		ORDER BY service_name, b
+	`},
+	"psp_failure_rate": {Table: "psp_metrics", Target: "psp_name", query: `
+		SELECT bucket, psp_name,
+		       (100.0 * SUM(total_count - success_count) / NULLIF(SUM(total_count), 0))::float8,
+		       SUM(total_count)
+		FROM psp_success_5m
+		WHERE bucket >= $1 AND bucket < $2
+		GROUP BY bucket, psp_name
+		ORDER BY psp_name, bucket
+	`},
+	"game_launch_failure_rate": {Table: "game_metrics", Target: "provider", query: `
+		SELECT bucket, provider,
+		       (100.0 * SUM(launch_count - success_count) / NULLIF(SUM(launch_count), 0))::float8,
+		       SUM(launch_count)
+		FROM game_health_5m
+		WHERE bucket >= $1 AND bucket < $2
+		GROUP BY bucket, provider
+		ORDER BY provider, bucket
+	`},
+}
+
+// AnomalyPoint is one bucket of an anomaly series
+type AnomalyPoint struct {
+	Bucket time.Time
+	Key    string
+	Value  float64
+	Events int64
+}
+
+// GetAnomalyPoints returns the buckets of one of the AnomalySources in
+// [start, end), ordered by key and bucket. Buckets without events are left
+// out.
+func (p *Postgres) GetAnomalyPoints(ctx context.Context, series string, start, end time.Time) ([]AnomalyPoint, error) {
+	s, ok := AnomalySources[series]
+	if !ok {
+		return nil, fmt.Errorf("unknown anomaly series %q", series)
+	}
+
+	rows, err := p.pool.Query(ctx, s.query, start, end)
+	if err != nil {
+		return nil, fmt.Errorf("query %s: %w", series, err)
+	}
+	defer rows.Close()
+
+	var result []AnomalyPoint
+	for rows.Next() {
+		var pt AnomalyPoint
+		var value *float64
+		if err := rows.Scan(&pt.Bucket, &pt.Key, &value, &pt.Events); err != nil {
+			return nil, fmt.Errorf("scan row: %w", err)
+		}
+		if value == nil {
+			continue
+		}
+		pt.Value = *value
+		result = append(result, pt)
+	}
+
+	return result, rows.Err()
+}
+
+// ============================================
+// QUERY LOG
+// ============================================
+
+// QueryLogEntry is one dashboard API query
+type QueryLogEntry struct {
+	Time       time.Time
+	User       string            // Empty without login
+	Endpoint   string            // Route pattern, e.g. "GET /api/metrics/psp"
+	Params     map[string]string // Query parameters and path values
+	Status     int
+	DurationMS float64
+	Rows       *int // nil when the handler does not report rows
+}
+
+// InsertQueryLog stores logged dashboard queries
+func (p *Postgres) InsertQueryLog(ctx context.Context, entries []QueryLogEntry) error {
+	rows := make([][]interface{}, 0, len(entries))
+	for _, e := range entries {
+		params, err := json.Marshal(e.Params)
+		if err != nil {
+			return fmt.Errorf("encode query params: %w", err)
+		}
+		var user *string
+		if e.User != "" {
+			user = &e.User
+		}
+		rows = append(rows, []interface{}{
+			e.Time, user, e.Endpoint, json.RawMessage(params), e.Status, e.DurationMS, e.Rows,
+		})
+	}
+	_, err := p.pool.CopyFrom(ctx, pgx.Identifier{"query_log"},
+		[]string{"time", "user_email", "endpoint", "params", "status", "duration_ms", "rows"},
+		pgx.CopyFromRows(rows))
+	if err != nil {
+		return fmt.Errorf("insert query log: %w", err)
+	}
+	return nil
+}
+
+// QueryEndpointStats is the usage and cost of one dashboard endpoint
+type QueryEndpointStats struct {
+	Endpoint    string    `json:"endpoint"`
+	Queries     int64     `json:"queries"`
+	Users       int64     `json:"users"`
+	Errors      int64     `json:"errors"` // Status >= 500
+	P50MS       float64   `json:"p50_ms"`
+	P95MS       float64   `json:"p95_ms"`
+	MaxMS       float64   `json:"max_ms"`
+	TotalMS     float64   `json:"total_ms"`
+	AvgRows     *float64  `json:"avg_rows"` // nil when the endpoint does not report rows
+	LastQueryAt time.Time `json:"last_query_at"`
+}
+
+// GetQueryEndpointStats returns the usage of dashboard endpoints since
+// start, most expensive in total first
+func (p *Postgres) GetQueryEndpointStats(ctx context.Context, start time.Time) ([]QueryEndpointStats, error) {
+	rows, err := p.pool.Query(ctx, `
+		SELECT
+			endpoint,
+			COUNT(*),
+			COUNT(DISTINCT user_email),
+			COUNT(*) FILTER (WHERE status >= 500),
+			PERCENTILE_CONT(0.5) WITHIN GROUP (ORDER BY duration_ms),
+			PERCENTILE_CONT(0.95) WITHIN GROUP (ORDER BY duration_ms),
+			MAX(duration_ms)::float8,
+			SUM(duration_ms)::float8,
+			AVG(rows)::float8,
+			MAX(time)
+		FROM query_log
+		WHERE time >= $1
+		GROUP BY endpoint
+		ORDER BY SUM(duration_ms) DESC
+	`, start)
+	if err != nil {
+		return nil, fmt.Errorf("query endpoint stats: %w", err)
+	}
+	defer rows.Close()
+
+	var result []QueryEndpointStats
+	for rows.Next() {
+		var s QueryEndpointStats
+		if err := rows.Scan(&s.Endpoint, &s.Queries, &s.Users, &s.Errors,
+			&s.P50MS, &s.P95MS, &s.MaxMS, &s.TotalMS, &s.AvgRows, &s.LastQueryAt); err != nil {
+			return nil, fmt.Errorf("scan row: %w", err)
+		}
+		result = append(result, s)
+	}
+
+	return result, rows.Err()
+}
+
+// DashboardUsage is how often a saved dashboard was opened
+type DashboardUsage struct {
+	ID           int64      `json:"id"`
+	Name         string     `json:"name"`
+	Owner        string     `json:"owner"`
+	Shared       bool       `json:"shared"`
+	Opens        int64      `json:"opens"`
+	Users        int64      `json:"users"`
+	LastOpenedAt *time.Time `json:"last_opened_at"`
+	CreatedAt    time.Time  `json:"created_at"`
+}
+
+// GetDashboardUsage returns every saved dashboard with its successful
+// opens (endpoint) since start, least used first
+func (p *Postgres) GetDashboardUsage(ctx context.Context, endpoint string, start time.Time) ([]DashboardUsage, error) {
+	rows, err := p.pool.Query(ctx, `
+		SELECT d.id, d.name, d.owner_email, d.shared,
+		       COUNT(q.time), COUNT(DISTINCT q.user_email), MAX(q.time), d.created_at
+		FROM dashboards d
+		LEFT JOIN query_log q
+		  ON q.endpoint = $1 AND q.params->>'id' = d.id::text
+		 AND q.time >= $2 AND q.status < 400
+		GROUP BY d.id
+		ORDER BY COUNT(q.time), d.created_at
+	`, endpoint, start)
+	if err != nil {
+		return nil, fmt.Errorf("query dashboard usage: %w", err)
+	}
+	defer rows.Close()
+
+	var result []DashboardUsage
+	for rows.Next() {
+		var u DashboardUsage
+		if err := rows.Scan(&u.ID, &u.Name, &u.Owner, &u.Shared,
+			&u.Opens, &u.Users, &u.LastOpenedAt, &u.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scan row: %w", err)
+		}
+		result = append(result, u)
+	}
+
+	return result, rows.Err()
+}
+
+// ============================================
+// PROVIDER CATALOG
+// ============================================
+
+// Kinds of catalog entries
+const (
+	ProviderPSP  = "psp"  // Named like psp_metrics.psp_name
+	ProviderGame = "game" // Named like game_metrics.provider
+)
+
+// ProviderContact is a person or desk to reach at a provider
+type ProviderContact struct {
+	Name  string `json:"name"`
+	Role  string `json:"role,omitempty"` // e.g. NOC, account manager
+	Email string `json:"email,omitempty"`
+	Phone string `json:"phone,omitempty"`
+}
+
+// Provider is the catalog entry of a PSP or game provider
+type Provider struct {
+	Kind        string            `json:"kind"`
+	Name        string            `json:"name"`
+	DisplayName string            `json:"display_name"`
+	LogoURL     string            `json:"logo_url,omitempty"`
+	Regions     []string          `json:"regions"`
+	Contacts    []ProviderContact `json:"contacts"`
+	Escalation  string            `json:"escalation,omitempty"` // How to escalate an incident, quoted in notifications
+	UpdatedBy   string            `json:"updated_by"`
+	UpdatedAt   time.Time         `json:"updated_at"`
+}
+
+// ErrProviderNotFound is returned for providers not in the catalog
+var ErrProviderNotFound = errors.New("provider not found")
+
+// ProviderKind returns the kind of provider alerts on sourceTable are
+// about, empty if none
+func ProviderKind(sourceTable string) string {
+	switch sourceTable {
+	case "psp_metrics":
+		return ProviderPSP
+	case "game_metrics":
+		return ProviderGame
+	}
+	return ""
+}
+
+const providerColumns = `kind, name, display_name, COALESCE(logo_url, ''), regions, contacts,
+	COALESCE(escalation, ''), updated_by, updated_at`
+
+func scanProvider(row pgx.Row) (Provider, error) {
+	var p Provider
+	err := row.Scan(&p.Kind, &p.Name, &p.DisplayName, &p.LogoURL, &p.Regions, &p.Contacts,
+		&p.Escalation, &p.UpdatedBy, &p.UpdatedAt)
+	return p, err
+}
+
+// UpsertProvider creates or replaces a catalog entry
+func (p *Postgres) UpsertProvider(ctx context.Context, pr Provider) (Provider, error) {
+	saved, err := scanProvider(p.pool.QueryRow(ctx, `
+		INSERT INTO provider_catalog (kind, name, display_name, logo_url, regions, contacts, escalation, updated_by)
+		VALUES ($1, $2, $3, NULLIF($4, ''), $5, $6, NULLIF($7, ''), $8)
+		ON CONFLICT (kind, name) DO UPDATE SET
+			display_name = EXCLUDED.display_name,
+			logo_url = EXCLUDED.logo_url,
+			regions = EXCLUDED.regions,
+			contacts = EXCLUDED.contacts,
+			escalation = EXCLUDED.escalation,
+			updated_by = EXCLUDED.updated_by,
+			updated_at = NOW()
+		RETURNING `+providerColumns,
+		pr.Kind, pr.Name, pr.DisplayName, pr.LogoURL, pr.Regions, pr.Contacts, pr.Escalation, pr.UpdatedBy))
+	if err != nil {
+		return saved, fmt.Errorf("upsert provider %s/%s: %w", pr.Kind, pr.Name, err)
+	}
+	return saved, nil
+}
+
+// GetProvider returns one catalog entry
+func (p *Postgres) GetProvider(ctx context.Context, kind, name string) (Provider, error) {
+	pr, err := scanProvider(p.pool.QueryRow(ctx, `
+		SELECT `+providerColumns+` FROM provider_catalog WHERE kind = $1 AND name = $2
+	`, kind, name))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return pr, ErrProviderNotFound
+	}
+	if err != nil {
+		return pr, fmt.Errorf("query provider %s/%s: %w", kind, name, err)
+	}
+	return pr, nil
+}
+
+// GetProviders lists the catalog by kind and name; an empty kind lists all
+func (p *Postgres) GetProviders(ctx context.Context, kind string) ([]Provider, error) {
+	rows, err := p.pool.Query(ctx, `
+		SELECT `+providerColumns+`
+		FROM provider_catalog
+		WHERE $1 = '' OR kind = $1
+		ORDER BY kind, name
+	`, kind)
+	if err != nil {
+		return nil, fmt.Errorf("query providers: %w", err)
+	}
+	defer rows.Close()
+
+	var result []Provider
+	for rows.Next() {
+		pr, err := scanProvider(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan row: %w", err)
+		}
+		result = append(result, pr)
+	}
+
+	return result, rows.Err()
+}
+
+// DeleteProvider removes a catalog entry
+func (p *Postgres) DeleteProvider(ctx context.Context, kind, name string) (bool, error) {
+	tag, err := p.pool.Exec(ctx, `DELETE FROM provider_catalog WHERE kind = $1 AND name = $2`, kind, name)
+	if err != nil {
+		return false, fmt.Errorf("delete provider %s/%s: %w", kind, name, err)
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// ============================================
+// EMAIL SUBSCRIPTIONS
+// ============================================
+
+// EmailSubscription is a user's choice of alert emails
+type EmailSubscription struct {
+	Email       string    `json:"email"`
+	Enabled     bool      `json:"enabled"`
+	MinSeverity string    `json:"min_severity"` // info, warning or critical
+	Sites       []string  `json:"sites"`        // Empty for all the user may see
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// EmailSubscriber is an enabled subscription with what its user may see
+type EmailSubscriber struct {
+	EmailSubscription
+	Role         string
+	GrantedSites []string
+}
+
+// ErrEmailSubscriptionNotFound is returned for users without subscription
+var ErrEmailSubscriptionNotFound = errors.New("email subscription not found")
+
+// GetEmailSubscription returns the subscription of a user
+func (p *Postgres) GetEmailSubscription(ctx context.Context, email string) (EmailSubscription, error) {
+	var s EmailSubscription
+	err := p.pool.QueryRow(ctx, `
+		SELECT email, enabled, min_severity, sites, updated_at
+		FROM email_subscriptions WHERE email = $1
+	`, email).Scan(&s.Email, &s.Enabled, &s.MinSeverity, &s.Sites, &s.UpdatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return s, ErrEmailSubscriptionNotFound
+	}
+	if err != nil {
+		return s, fmt.Errorf("query email subscription: %w", err)
+	}
+	return s, nil
+}
+
+// SetEmailSubscription creates or replaces the subscription of a user
+func (p *Postgres) SetEmailSubscription(ctx context.Context, s EmailSubscription) (EmailSubscription, error) {
+	if s.Sites == nil {
+		s.Sites = []string{}
+	}
+	err := p.pool.QueryRow(ctx, `
+		INSERT INTO email_subscriptions (email, enabled, min_severity, sites)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (email) DO UPDATE SET
+			enabled = EXCLUDED.enabled,
+			min_severity = EXCLUDED.min_severity,
+			sites = EXCLUDED.sites,
+			updated_at = NOW()
+		RETURNING updated_at
+	`, s.Email, s.Enabled, s.MinSeverity, s.Sites).Scan(&s.UpdatedAt)
+	if err != nil {
+		return s, fmt.Errorf("upsert email subscription: %w", err)
+	}
+	return s, nil
+}
+
+// GetEmailSubscribers returns the enabled subscriptions with the role and
+// granted sites of their users
+func (p *Postgres) GetEmailSubscribers(ctx context.Context) ([]EmailSubscriber, error) {
+	rows, err := p.pool.Query(ctx, `
+		SELECT s.email, s.enabled, s.min_severity, s.sites, s.updated_at, u.role,
+		       ARRAY(SELECT g.site_id FROM user_sites g WHERE g.email = u.email ORDER BY g.site_id)
+		FROM email_subscriptions s
+		JOIN users u ON u.email = s.email
+		WHERE s.enabled
+		ORDER BY s.email
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("query email subscribers: %w", err)
+	}
+	defer rows.Close()
+
+	var result []EmailSubscriber
+	for rows.Next() {
+		var s EmailSubscriber
+		if err := rows.Scan(&s.Email, &s.Enabled, &s.MinSeverity, &s.Sites, &s.UpdatedAt,
+			&s.Role, &s.GrantedSites); err != nil {
+			return nil, fmt.Errorf("scan row: %w", err)
+		}
+		result = append(result, s)
+	}
+
+	return result, rows.Err()
+}
+
+// ============================================
+// WEBHOOKS
+// ============================================
+
+// Webhook is an outbound webhook of the webhook channel
+type Webhook struct {
+	Name      string    `json:"name"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"-"`        // HMAC-SHA256 key of X-Pulse-Signature, empty for unsigned
+	Signed    bool      `json:"signed"`   // Whether a secret is set
+	Template  string    `json:"template"` // Empty for the default payload
+	AllAlerts bool      `json:"all_alerts"`
+	Enabled   bool      `json:"enabled"`
+	UpdatedBy string    `json:"updated_by"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// WebhookDelivery is one attempt to deliver an alert to a webhook
+type WebhookDelivery struct {
+	Time       time.Time `json:"time"`
+	Webhook    string    `json:"webhook"`
+	AlertID    int64     `json:"alert_id,omitempty"` // 0 for alerts not stored
+	AlertType  string    `json:"alert_type"`
+	MetricName string    `json:"metric_name"`
+	Event      string    `json:"event"`   // raised or resolved
+	Attempt    int       `json:"attempt"` // 1 for the first try
+	StatusCode int       `json:"status_code,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	DurationMS float64   `json:"duration_ms"`
+}
+
+// ErrWebhookNotFound is returned for unknown webhook names
+var ErrWebhookNotFound = errors.New("webhook not found")
+
+const webhookColumns = `name, url, secret, template, all_alerts, enabled, updated_by, updated_at`
+
+func scanWebhook(row pgx.Row) (Webhook, error) {
+	var w Webhook
+	err := row.Scan(&w.Name, &w.URL, &w.Secret, &w.Template, &w.AllAlerts, &w.Enabled, &w.UpdatedBy, &w.UpdatedAt)
+	w.Signed = w.Secret != ""
+	return w, err
+}
+
+// UpsertWebhook creates or replaces a webhook
+func (p *Postgres) UpsertWebhook(ctx context.Context, w Webhook) (Webhook, error) {
+	saved, err := scanWebhook(p.pool.QueryRow(ctx, `
+		INSERT INTO webhooks (name, url, secret, template, all_alerts, enabled, updated_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (name) DO UPDATE SET
+			url = EXCLUDED.url,
+			secret = EXCLUDED.secret,
+			template = EXCLUDED.template,
+			all_alerts = EXCLUDED.all_alerts,
+			enabled = EXCLUDED.enabled,
+			updated_by = EXCLUDED.updated_by,
+			updated_at = NOW()
+		RETURNING `+webhookColumns,
+		w.Name, w.URL, w.Secret, w.Template, w.AllAlerts, w.Enabled, w.UpdatedBy))
+	if err != nil {
+		return saved, fmt.Errorf("upsert webhook %s: %w", w.Name, err)
+	}
+	return saved, nil
+}
+
+// GetWebhook returns one webhook
+func (p *Postgres) GetWebhook(ctx context.Context, name string) (Webhook, error) {
+	w, err := scanWebhook(p.pool.QueryRow(ctx, `SELECT `+webhookColumns+` FROM webhooks WHERE name = $1`, name))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return w, ErrWebhookNotFound
+	}
+	if err != nil {
+		return w, fmt.Errorf("query webhook %s: %w", name, err)
+	}
+	return w, nil
+}
+
+// GetWebhooks lists all webhooks by name
+func (p *Postgres) GetWebhooks(ctx context.Context) ([]Webhook, error) {
+	return p.queryWebhooks(ctx, `SELECT `+webhookColumns+` FROM webhooks ORDER BY name`)
+}
+
+// GetAlertWebhooks returns the enabled webhooks an alert goes to: those
+// receiving all alerts and, for alerts of a rule, those the rule names.
+// rule is empty for alerts not raised by a rule.
+func (p *Postgres) GetAlertWebhooks(ctx context.Context, rule string) ([]Webhook, error) {
+	return p.queryWebhooks(ctx, `
+		SELECT `+webhookColumns+`
+		FROM webhooks w
+		WHERE w.enabled AND (w.all_alerts OR EXISTS (
+			SELECT 1 FROM alert_rules r WHERE r.name = $1 AND w.name = ANY(r.webhooks)
+		))
+		ORDER BY w.name
+	`, rule)
+}
+
+func (p *Postgres) queryWebhooks(ctx context.Context, query string, args ...interface{}) ([]Webhook, error) {
+	rows, err := p.pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query webhooks: %w", err)
+	}
+	defer rows.Close()
+
+	var result []Webhook
+	for rows.Next() {
+		w, err := scanWebhook(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan row: %w", err)
+		}
+		result = append(result, w)
+	}
+
+	return result, rows.Err()
+}
+
+// DeleteWebhook removes a webhook. Rules naming it keep the name and no
+// longer notify it.
+func (p *Postgres) DeleteWebhook(ctx context.Context, name string) (bool, error) {
+	tag, err := p.pool.Exec(ctx, `DELETE FROM webhooks WHERE name = $1`, name)
+	if err != nil {
+		return false, fmt.Errorf("delete webhook %s: %w", name, err)
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// InsertWebhookDelivery logs a delivery attempt
+func (p *Postgres) InsertWebhookDelivery(ctx context.Context, d WebhookDelivery) error {
+	_, err := p.pool.Exec(ctx, `
+		INSERT INTO webhook_deliveries (
+			time, webhook, alert_id, alert_type, metric_name, event, attempt, status_code, error, duration_ms
+		) VALUES ($1, $2, NULLIF($3, 0), $4, NULLIF($5, ''), $6, $7, NULLIF($8, 0), NULLIF($9, ''), $10)
+	`, d.Time, d.Webhook, d.AlertID, d.AlertType, d.MetricName, d.Event, d.Attempt, d.StatusCode, d.Error, d.DurationMS)
+	if err != nil {
+		return fmt.Errorf("insert webhook delivery: %w", err)
+	}
+	return nil
+}
+
+// GetWebhookDeliveries returns the latest delivery attempts of a webhook,
+// newest first, optionally only the failed ones
+func (p *Postgres) GetWebhookDeliveries(ctx context.Context, webhook string, failed bool, limit int) ([]WebhookDelivery, error) {
+	rows, err := p.pool.Query(ctx, `
+		SELECT time, webhook, COALESCE(alert_id, 0), alert_type, COALESCE(metric_name, ''), event,
+		       attempt, COALESCE(status_code, 0), COALESCE(error, ''), duration_ms
+		FROM webhook_deliveries
+		WHERE webhook = $1 AND (NOT $2 OR error IS NOT NULL)
+		ORDER BY time DESC
+		LIMIT $3
+	`, webhook, failed, limit)
+	if err != nil {
+		return nil, fmt.Errorf("query webhook deliveries: %w", err)
+	}
+	defer rows.Close()
+
+	var result []WebhookDelivery
+	for rows.Next() {
+		var d WebhookDelivery
+		if err := rows.Scan(&d.Time, &d.Webhook, &d.AlertID, &d.AlertType, &d.MetricName, &d.Event,
+			&d.Attempt, &d.StatusCode, &d.Error, &d.DurationMS); err != nil {
+			return nil, fmt.Errorf("scan row: %w", err)
+		}
+		result = append(result, d)
+	}
+
+	return result, rows.Err()
+}
+
+// ============================================
+// RAW EVENT EXPORT
+// ============================================
+
+// ExportTables maps the sources of raw event exports to their hypertables
+var ExportTables = map[string]string{
+	"frontend": "frontend_metrics",
+	"api":      "api_metrics",
+	"psp":      "psp_metrics",
+	"game":     "game_metrics",
+	"ws":       "websocket_metrics",
+}
+
+// StreamEvents passes the raw events of a source in [start, end), oldest
+// first, to fn as JSON objects of all their columns. Rows are read as fn
+// takes them, so a slow consumer slows the query down instead of the rows
+// piling up in memory. An error of fn stops the query and is returned.
+func (p *Postgres) StreamEvents(ctx context.Context, source string, start, end time.Time, sites []string, fn func(event json.RawMessage) error) error {
+	table, ok := ExportTables[source]
+	if !ok {
+		return fmt.Errorf("unknown export source %q", source)
+	}
+	rows, err := p.pool.Query(ctx, `
+		SELECT to_jsonb(t)::text
+		FROM `+table+` t
+		WHERE time >= $1 AND time < $2
+		  AND ($3::text[] IS NULL OR site_id = ANY($3))
+		ORDER BY time
+	`, start, end, sites)
+	if err != nil {
+		return fmt.Errorf("query %s export: %w", source, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var event []byte
+		if err := rows.Scan(&event); err != nil {
+			return fmt.Errorf("scan row: %w", err)
+		}
+		if err := fn(event); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// ============================================
+// AUDIT LOG
+// ============================================
+
+// AuditEvent is a security event of a dashboard login
+type AuditEvent struct {
+	Time      time.Time         `json:"time"`
+	Event     string            `json:"event"` // e.g. session_binding_mismatch
+	Email     string            `json:"email,omitempty"`
+	IP        string            `json:"ip,omitempty"`
+	UserAgent string            `json:"user_agent,omitempty"`
+	Detail    map[string]string `json:"detail,omitempty"`
+}
+
+// InsertAuditEvent stores an audit event
+func (p *Postgres) InsertAuditEvent(ctx context.Context, e AuditEvent) error {
+	if e.Detail == nil {
+		e.Detail = map[string]string{}
+	}
+	detail, err := json.Marshal(e.Detail)
+	if err != nil {
+		return fmt.Errorf("encode audit detail: %w", err)
+	}
+	_, err = p.pool.Exec(ctx, `
+		INSERT INTO audit_log (time, event, email, ip, user_agent, detail)
+		VALUES ($1, $2, NULLIF($3, ''), NULLIF($4, ''), NULLIF($5, ''), $6)
+	`, e.Time, e.Event, e.Email, e.IP, e.UserAgent, json.RawMessage(detail))
+	if err != nil {
+		return fmt.Errorf("insert audit event: %w", err)
+	}
+	return nil
+}
+
+// GetAuditEvents returns the latest audit events, newest first, optionally
+// only those of one event type or user
+func (p *Postgres) GetAuditEvents(ctx context.Context, event, email string, limit int) ([]AuditEvent, error) {
+	rows, err := p.pool.Query(ctx, `
+		SELECT time, event, COALESCE(email, ''), COALESCE(ip, ''), COALESCE(user_agent, ''), detail
+		FROM audit_log
+		WHERE ($1 = '' OR event = $1) AND ($2 = '' OR email = $2)
+		ORDER BY time DESC
+		LIMIT $3
+	`, event, email, limit)
+	if err != nil {
+		return nil, fmt.Errorf("query audit log: %w", err)
+	}
+	defer rows.Close()
+
+	var result []AuditEvent
+	for rows.Next() {
+		var e AuditEvent
+		if err := rows.Scan(&e.Time, &e.Event, &e.Email, &e.IP, &e.UserAgent, &e.Detail); err != nil {
+			return nil, fmt.Errorf("scan row: %w", err)
+		}
+		result = append(result, e)
+	}
+
+	return result, rows.Err()
+}
+
+// ============================================
+// TABLE STATISTICS
+// ============================================
+
+// AnalyzeTable sets the statistics target of columns where it differs from
+// target, then runs ANALYZE on the table. A hypertable's chunks take the
+// targets and are analyzed with it.
+func (p *Postgres) AnalyzeTable(ctx context.Context, table string, columns []string, target int) error {
+	rows, err := p.pool.Query(ctx, `
+		SELECT attname, COALESCE(attstattarget, -1)
+		FROM pg_attribute
+		WHERE attrelid = $1::regclass AND attname = ANY($2) AND NOT attisdropped
+	`, table, columns)
+	if err != nil {
+		return fmt.Errorf("query statistics targets: %w", err)
+	}
+	current := make(map[string]int)
+	for rows.Next() {
+		var name string
+		var n int
+		if err := rows.Scan(&name, &n); err != nil {
+			rows.Close()
+			return fmt.Errorf("scan row: %w", err)
+		}
+		current[name] = n
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("query statistics targets: %w", err)
+	}
+
+	ident := pgx.Identifier{table}.Sanitize()
+	for _, col := range columns {
+		n, ok := current[col]
+		if !ok {
+			return fmt.Errorf("column %s.%s does not exist", table, col)
+		}
+		if n == target {
+			continue
+		}
+		_, err := p.pool.Exec(ctx, fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s SET STATISTICS %d",
+			ident, pgx.Identifier{col}.Sanitize(), target))
+		if err != nil {
+			return fmt.Errorf("set statistics target of %s.%s: %w", table, col, err)
+		}
+	}
+
+	if _, err := p.pool.Exec(ctx, "ANALYZE "+ident); err != nil {
+		return fmt.Errorf("analyze %s: %w", table, err)
+	}
+	return nil
+}
+
+// ============================================
+// SITE DATA RESIDENCY
+// ============================================
+
+// SiteResidency pins a site's data to the collectors of one region
+type SiteResidency struct {
+	SiteID    string    `json:"site_id"`
+	Region    string    `json:"region"`
+	UpdatedBy string    `json:"updated_by"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// ErrResidencyNotFound is returned for sites without a residency
+var ErrResidencyNotFound = errors.New("site residency not found")
+
+const residencyColumns = `site_id, region, COALESCE(updated_by, ''), updated_at`
+
+func scanResidency(row pgx.Row) (SiteResidency, error) {
+	var r SiteResidency
+	err := row.Scan(&r.SiteID, &r.Region, &r.UpdatedBy, &r.UpdatedAt)
+	return r, err
+}
+
+// SetSiteResidency creates or replaces the residency of a site
+func (p *Postgres) SetSiteResidency(ctx context.Context, r SiteResidency) (SiteResidency, error) {
+	saved, err := scanResidency(p.pool.QueryRow(ctx, `
+		INSERT INTO site_residency (site_id, region, updated_by)
+		VALUES ($1, $2, NULLIF($3, ''))
+		ON CONFLICT (site_id) DO UPDATE SET
+			region = EXCLUDED.region,
+			updated_by = EXCLUDED.updated_by,
+			updated_at = NOW()
+		RETURNING `+residencyColumns,
+		r.SiteID, r.Region, r.UpdatedBy))
+	if err != nil {
+		return saved, fmt.Errorf("upsert site residency %s: %w", r.SiteID, err)
+	}
+	return saved, nil
+}
+
+// GetSiteResidency returns the residency of one site
+func (p *Postgres) GetSiteResidency(ctx context.Context, siteID string) (SiteResidency, error) {
+	r, err := scanResidency(p.pool.QueryRow(ctx, `
+		SELECT `+residencyColumns+` FROM site_residency WHERE site_id = $1
+	`, siteID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return r, ErrResidencyNotFound
+	}
+	if err != nil {
+		return r, fmt.Errorf("query site residency %s: %w", siteID, err)
+	}
+	return r, nil
+}
+
+// GetSiteResidencies lists every site with a residency, by site
+func (p *Postgres) GetSiteResidencies(ctx context.Context) ([]SiteResidency, error) {
+	rows, err := p.pool.Query(ctx, `
+		SELECT `+residencyColumns+` FROM site_residency ORDER BY site_id
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("query site residencies: %w", err)
+	}
+	defer rows.Close()
+
+	var result []SiteResidency
+	for rows.Next() {
+		r, err := scanResidency(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan row: %w", err)
+		}
+		result = append(result, r)
+	}
+
+	return result, rows.Err()
+}
+
+// DeleteSiteResidency removes the residency of a site, so any region may
+// store its data again
+func (p *Postgres) DeleteSiteResidency(ctx context.Context, siteID string) (bool, error) {
+	tag, err := p.pool.Exec(ctx, `DELETE FROM site_residency WHERE site_id = $1`, siteID)
+	if err != nil {
+		return false, fmt.Errorf("delete site residency %s: %w", siteID, err)
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// ============================================
+// SDK REMOTE CONFIG
+// ============================================
+
+// SDKConfig is the runtime directives of a site's SDKs and Go clients
+type SDKConfig struct {
+	SiteID        string    `json:"site_id"`
+	SampleRate    float64   `json:"sample_rate"`    // Share of sessions / metrics sent, 0-1
+	DisabledTypes []string  `json:"disabled_types"` // Event and metric types not sent
+	Endpoint      string    `json:"endpoint"`       // Collector base URL to switch to, empty keeps the current one
+	Disabled      bool      `json:"disabled"`       // Kill switch: nothing is sent
+	UpdatedBy     string    `json:"updated_by"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// ErrSDKConfigNotFound is returned for sites without an SDK config
+var ErrSDKConfigNotFound = errors.New("sdk config not found")
+
+const sdkConfigColumns = `site_id, sample_rate, disabled_types, COALESCE(endpoint, ''), disabled,
+	COALESCE(updated_by, ''), updated_at`
+
+func scanSDKConfig(row pgx.Row) (SDKConfig, error) {
+	var c SDKConfig
+	err := row.Scan(&c.SiteID, &c.SampleRate, &c.DisabledTypes, &c.Endpoint, &c.Disabled, &c.UpdatedBy, &c.UpdatedAt)
+	return c, err
+}
+
+// SetSDKConfig creates or replaces the SDK config of a site
+func (p *Postgres) SetSDKConfig(ctx context.Context, c SDKConfig) (SDKConfig, error) {
+	if c.DisabledTypes == nil {
+		c.DisabledTypes = []string{}
+	}
+	saved, err := scanSDKConfig(p.pool.QueryRow(ctx, `
+		INSERT INTO site_sdk_config (site_id, sample_rate, disabled_types, endpoint, disabled, updated_by)
+		VALUES ($1, $2, $3, NULLIF($4, ''), $5, NULLIF($6, ''))
+		ON CONFLICT (site_id) DO UPDATE SET
+			sample_rate = EXCLUDED.sample_rate,
+			disabled_types = EXCLUDED.disabled_types,
+			endpoint = EXCLUDED.endpoint,
+			disabled = EXCLUDED.disabled,
+			updated_by = EXCLUDED.updated_by,
+			updated_at = NOW()
+		RETURNING `+sdkConfigColumns,
+		c.SiteID, c.SampleRate, c.DisabledTypes, c.Endpoint, c.Disabled, c.UpdatedBy))
+	if err != nil {
+		return saved, fmt.Errorf("upsert sdk config %s: %w", c.SiteID, err)
+	}
+	return saved, nil
+}
+
+// GetSDKConfig returns the SDK config of one site
+func (p *Postgres) GetSDKConfig(ctx context.Context, siteID string) (SDKConfig, error) {
+	c, err := scanSDKConfig(p.pool.QueryRow(ctx, `
+		SELECT `+sdkConfigColumns+` FROM site_sdk_config WHERE site_id = $1
+	`, siteID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return c, ErrSDKConfigNotFound
+	}
+	if err != nil {
+		return c, fmt.Errorf("query sdk config %s: %w", siteID, err)
+	}
+	return c, nil
+}
+
+// GetSDKConfigs lists the SDK configs of every site, by site
+func (p *Postgres) GetSDKConfigs(ctx context.Context) ([]SDKConfig, error) {
+	rows, err := p.pool.Query(ctx, `
+		SELECT `+sdkConfigColumns+` FROM site_sdk_config ORDER BY site_id
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("query sdk configs: %w", err)
+	}
+	defer rows.Close()
+
+	var result []SDKConfig
+	for rows.Next() {
+		c, err := scanSDKConfig(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan row: %w", err)
+		}
+		result = append(result, c)
+	}
+
+	return result, rows.Err()
+}
+
+// DeleteSDKConfig removes the SDK config of a site, so its clients fall
+// back to their own settings
+func (p *Postgres) DeleteSDKConfig(ctx context.Context, siteID string) (bool, error) {
+	tag, err := p.pool.Exec(ctx, `DELETE FROM site_sdk_config WHERE site_id = $1`, siteID)
+	if err != nil {
+		return false, fmt.Errorf("delete sdk config %s: %w", siteID, err)
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// ============================================
+// KILL SWITCHES
+// ============================================
+
+// KillSwitch stops the collector from storing a site's events of one
+// metric type, or of all types
+type KillSwitch struct {
+	SiteID     string    `json:"site_id"`
+	MetricType string    `json:"metric_type"` // all, frontend, a frontend event type, api, psp, game or ws
+	Mode       string    `json:"mode"`        // reject or discard
+	Reason     string    `json:"reason"`
+	CreatedBy  string    `json:"created_by"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+const killSwitchColumns = `site_id, metric_type, mode, reason, COALESCE(created_by, ''), created_at`
+
+func scanKillSwitch(row pgx.Row) (KillSwitch, error) {
+	var k KillSwitch
+	err := row.Scan(&k.SiteID, &k.MetricType, &k.Mode, &k.Reason, &k.CreatedBy, &k.CreatedAt)
+	return k, err
+}
+
+// SetKillSwitch turns a kill switch on, or replaces its mode and reason
+func (p *Postgres) SetKillSwitch(ctx context.Context, k KillSwitch) (KillSwitch, error) {
+	saved, err := scanKillSwitch(p.pool.QueryRow(ctx, `
+		INSERT INTO kill_switches (site_id, metric_type, mode, reason, created_by)
+		VALUES ($1, $2, $3, $4, NULLIF($5, ''))
+		ON CONFLICT (site_id, metric_type) DO UPDATE SET
+			mode = EXCLUDED.mode,
+			reason = EXCLUDED.reason,
+			created_by = EXCLUDED.created_by,
+			created_at = NOW()
+		RETURNING `+killSwitchColumns,
+		k.SiteID, k.MetricType, k.Mode, k.Reason, k.CreatedBy))
+	if err != nil {
+		return saved, fmt.Errorf("upsert kill switch %s/%s: %w", k.SiteID, k.MetricType, err)
+	}
+	return saved, nil
+}
+
+// GetKillSwitches lists the kill switches that are on, by site and type
+func (p *Postgres) GetKillSwitches(ctx context.Context) ([]KillSwitch, error) {
+	rows, err := p.pool.Query(ctx, `
+		SELECT `+killSwitchColumns+` FROM kill_switches ORDER BY site_id, metric_type
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("query kill switches: %w", err)
+	}
+	defer rows.Close()
+
+	var result []KillSwitch
+	for rows.Next() {
+		k, err := scanKillSwitch(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan row: %w", err)
+		}
+		result = append(result, k)
+	}
+
+	return result, rows.Err()
+}
+
+// DeleteKillSwitch turns a kill switch off
+func (p *Postgres) DeleteKillSwitch(ctx context.Context, siteID, metricType string) (bool, error) {
+	tag, err := p.pool.Exec(ctx, `DELETE FROM kill_switches WHERE site_id = $1 AND metric_type = $2`, siteID, metricType)
+	if err != nil {
+		return false, fmt.Errorf("delete kill switch %s/%s: %w", siteID, metricType, err)
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// ============================================
+// SUPPORT BUNDLE
+// ============================================
+
+// PoolStats is a snapshot of the connection pool
+type PoolStats struct {
+	MaxConns                int32         `json:"max_conns"`
+	TotalConns              int32         `json:"total_conns"`
+	AcquiredConns           int32         `json:"acquired_conns"`
+	IdleConns               int32         `json:"idle_conns"`
+	ConstructingConns       int32         `json:"constructing_conns"`
+	AcquireCount            int64         `json:"acquire_count"`
+	AcquireDuration         time.Duration `json:"acquire_duration_ns"`
+	EmptyAcquireCount       int64         `json:"empty_acquire_count"` // Acquires that had to wait for a connection
+	CanceledAcquireCount    int64         `json:"canceled_acquire_count"`
+	NewConnsCount           int64         `json:"new_conns_count"`
+	MaxLifetimeDestroyCount int64         `json:"max_lifetime_destroy_count"`
+	MaxIdleDestroyCount     int64         `json:"max_idle_destroy_count"`
+}
+
+// PoolStats returns the connection pool's current state and counters
+func (p *Postgres) PoolStats() PoolStats {
+	s := p.pool.Stat()
+	return PoolStats{
+		MaxConns:                s.MaxConns(),
+		TotalConns:              s.TotalConns(),
+		AcquiredConns:           s.AcquiredConns(),
+		IdleConns:               s.IdleConns(),
+		ConstructingConns:       s.ConstructingConns(),
+		AcquireCount:            s.AcquireCount(),
+		AcquireDuration:         s.AcquireDuration(),
+		EmptyAcquireCount:       s.EmptyAcquireCount(),
+		CanceledAcquireCount:    s.CanceledAcquireCount(),
+		NewConnsCount:           s.NewConnsCount(),
+		MaxLifetimeDestroyCount: s.MaxLifetimeDestroyCount(),
+		MaxIdleDestroyCount:     s.MaxIdleDestroyCount(),
+	}
+}
+
+// SchemaInfo identifies the database and the schema the collector runs
+// against. The schema has no version table; Fingerprint is a hash of every
+// table's columns and types, so two databases with the same fingerprint
+// have the same schema.
+type SchemaInfo struct {
+	ServerVersion string            `json:"server_version"`
+	Extensions    map[string]string `json:"extensions"` // Installed extensions and their versions
+	Tables        []SchemaTable     `json:"tables"`
+	Fingerprint   string            `json:"fingerprint"`
+}
+
+// SchemaTable is a table of the public schema
+type SchemaTable struct {
+	Name    string   `json:"name"`
+	Columns []string `json:"columns"` // name type, in column order
+}
+
+// GetSchemaInfo reads the server version, extensions and the tables of
+// the public schema
+func (p *Postgres) GetSchemaInfo(ctx context.Context) (SchemaInfo, error) {
+	info := SchemaInfo{Extensions: make(map[string]string)}
+	if err := p.pool.QueryRow(ctx, `SHOW server_version`).Scan(&info.ServerVersion); err != nil {
+		return info, fmt.Errorf("query server version: %w", err)
+	}
+
+	rows, err := p.pool.Query(ctx, `SELECT extname, extversion FROM pg_extension ORDER BY extname`)
+	if err != nil {
+		return info, fmt.Errorf("query extensions: %w", err)
+	}
+	for rows.Next() {
+		var name, version string
+		if err := rows.Scan(&name, &version); err != nil {
+			rows.Close()
+			return info, fmt.Errorf("scan row: %w", err)
+		}
+		info.Extensions[name] = version
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return info, fmt.Errorf("query extensions: %w", err)
+	}
+
+	rows, err = p.pool.Query(ctx, `
+		SELECT c.table_name, c.column_name, c.data_type
+		FROM information_schema.columns c
+		JOIN information_schema.tables t
+			ON t.table_schema = c.table_schema AND t.table_name = c.table_name
+		WHERE c.table_schema = 'public' AND t.table_type = 'BASE TABLE'
+		ORDER BY c.table_name, c.ordinal_position
+	`)
+	if err != nil {
+		return info, fmt.Errorf("query schema columns: %w", err)
+	}
+	defer rows.Close()
+
+	h := sha256.New()
+	for rows.Next() {
+		var table, column, dataType string
+		if err := rows.Scan(&table, &column, &dataType); err != nil {
+			return info, fmt.Errorf("scan row: %w", err)
+		}
+		if n := len(info.Tables); n == 0 || info.Tables[n-1].Name != table {
+			info.Tables = append(info.Tables, SchemaTable{Name: table})
+		}
+		t := &info.Tables[len(info.Tables)-1]
+		t.Columns = append(t.Columns, column+" "+dataType)
+		fmt.Fprintf(h, "%s.%s %s\n", table, column, dataType)
+	}
+	if err := rows.Err(); err != nil {
+		return info, fmt.Errorf("query schema columns: %w", err)
+	}
+	info.Fingerprint = hex.EncodeToString(h.Sum(nil))
+	return info, nil
+}
+
+// ============================================
+// SITE USAGE AND QUOTAS
+// ============================================
+
+// SiteUsage is what a site sent on a UTC day for one metric type. WireBytes
+// are body bytes as sent, RawBytes as decoded: the same for uncompressed
+// requests.
+type SiteUsage struct {
+	Day        time.Time `json:"day"`
+	SiteID     string    `json:"site_id"`
+	MetricType string    `json:"metric_type"`
+	Requests   int64     `json:"requests"`
+	Events     int64     `json:"events"`
+	WireBytes  int64     `json:"wire_bytes"`
+	RawBytes   int64     `json:"raw_bytes"`
+}
+
+// RecordSiteUsage adds usage to the stored daily totals
+func (p *Postgres) RecordSiteUsage(ctx context.Context, usage []SiteUsage) error {
+	if len(usage) == 0 {
+		return nil
+	}
+
+	batch := &pgx.Batch{}
+	for _, u := range usage {
+		batch.Queue(`
+			INSERT INTO site_usage (day, site_id, metric_type, requests, events, wire_bytes, raw_bytes)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)
+			ON CONFLICT (day, site_id, metric_type) DO UPDATE SET
+				requests = site_usage.requests + EXCLUDED.requests,
+				events = site_usage.events + EXCLUDED.events,
+				wire_bytes = site_usage.wire_bytes + EXCLUDED.wire_bytes,
+				raw_bytes = site_usage.raw_bytes + EXCLUDED.raw_bytes
+		`, u.Day, u.SiteID, u.MetricType, u.Requests, u.Events, u.WireBytes, u.RawBytes)
+	}
+
+	return p.pool.SendBatch(ctx, batch).Close()
+}
+
+// GetSiteUsage returns daily usage from start to end (days, inclusive),
+// of one site or of all if siteID is empty
+func (p *Postgres) GetSiteUsage(ctx context.Context, start, end time.Time, siteID string) ([]SiteUsage, error) {
+	rows, err := p.pool.Query(ctx, `
+		SELECT day, site_id, metric_type, requests, events, wire_bytes, raw_bytes
+		FROM site_usage
+		WHERE day BETWEEN $1 AND $2 AND ($3 = '' OR site_id = $3)
+		ORDER BY day, site_id, metric_type
+	`, start, end, siteID)
+	if err != nil {
+		return nil, fmt.Errorf("query site usage: %w", err)
+	}
+	defer rows.Close()
+
+	var result []SiteUsage
+	for rows.Next() {
+		var u SiteUsage
+		if err := rows.Scan(&u.Day, &u.SiteID, &u.MetricType, &u.Requests, &u.Events, &u.WireBytes, &u.RawBytes); err != nil {
+			return nil, fmt.Errorf("scan row: %w", err)
+		}
+		result = append(result, u)
+	}
+
+	return result, rows.Err()
+}
+
+// SiteQuota limits what a site may send per UTC day. Zero limits are off.
+type SiteQuota struct {
+	SiteID    string    `json:"site_id"`
+	MaxEvents int64     `json:"max_events"`
+	MaxBytes  int64     `json:"max_bytes"` // Raw (decoded) body bytes
+	UpdatedBy string    `json:"updated_by"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+const siteQuotaColumns = `site_id, max_events, max_bytes, COALESCE(updated_by, ''), updated_at`
+
+func scanSiteQuota(row pgx.Row) (SiteQuota, error) {
+	var q SiteQuota
+	err := row.Scan(&q.SiteID, &q.MaxEvents, &q.MaxBytes, &q.UpdatedBy, &q.UpdatedAt)
+	return q, err
+}
+
+// SetSiteQuota creates or replaces a site's quota
+func (p *Postgres) SetSiteQuota(ctx context.Context, q SiteQuota) (SiteQuota, error) {
+	saved, err := scanSiteQuota(p.pool.QueryRow(ctx, `
+		INSERT INTO site_quotas (site_id, max_events, max_bytes, updated_by)
+		VALUES ($1, $2, $3, NULLIF($4, ''))
+		ON CONFLICT (site_id) DO UPDATE SET
+			max_events = EXCLUDED.max_events,
+			max_bytes = EXCLUDED.max_bytes,
+			updated_by = EXCLUDED.updated_by,
+			updated_at = NOW()
+		RETURNING `+siteQuotaColumns,
+		q.SiteID, q.MaxEvents, q.MaxBytes, q.UpdatedBy))
+	if err != nil {
+		return saved, fmt.Errorf("upsert site quota %s: %w", q.SiteID, err)
+	}
+	return saved, nil
+}
+
+// GetSiteQuotas lists the quotas of all sites
+func (p *Postgres) GetSiteQuotas(ctx context.Context) ([]SiteQuota, error) {
+	rows, err := p.pool.Query(ctx, `SELECT `+siteQuotaColumns+` FROM site_quotas ORDER BY site_id`)
+	if err != nil {
+		return nil, fmt.Errorf("query site quotas: %w", err)
+	}
+	defer rows.Close()
+
+	var result []SiteQuota
+	for rows.Next() {
+		q, err := scanSiteQuota(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan row: %w", err)
+		}
+		result = append(result, q)
+	}
+
+	return result, rows.Err()
+}
+
+// DeleteSiteQuota removes a site's quota
+func (p *Postgres) DeleteSiteQuota(ctx context.Context, siteID string) (bool, error) {
+	tag, err := p.pool.Exec(ctx, `DELETE FROM site_quotas WHERE site_id = $1`, siteID)
+	if err != nil {
+		return false, fmt.Errorf("delete site quota %s: %w", siteID, err)
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// ============================================
+// SCHEMA DRIFT
+// ============================================
+
+// Kinds of schema drift
+const (
+	SchemaDriftUnknown = "unknown" // Sent but not known to the collector
+	SchemaDriftMissing = "missing" // Expected by the collector but not sent, or null
+)
+
+// SchemaDrift counts the events of a minute in which a sender's field
+// drifted from the collector's model
+type SchemaDrift struct {
+	Bucket     time.Time `json:"bucket"`
+	SiteID     string    `json:"site_id"`
+	Producer   string    `json:"producer"`
+	SDK        string    `json:"sdk"`
+	SDKVersion string    `json:"sdk_version"`
+	MetricType string    `json:"metric_type"`
+	Field      string    `json:"field"`
+	Kind       string    `json:"kind"`
+	Events     int64     `json:"events"`
+}
+
+// RecordSchemaDrift adds drift to the stored per-minute counts
+func (p *Postgres) RecordSchemaDrift(ctx context.Context, drift []SchemaDrift) error {
+	if len(drift) == 0 {
+		return nil
+	}
+
+	batch := &pgx.Batch{}
+	for _, d := range drift {
+		batch.Queue(`
+			INSERT INTO schema_drift (bucket, site_id, producer, sdk, sdk_version, metric_type, field, kind, events)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+			ON CONFLICT (bucket, site_id, producer, sdk, sdk_version, metric_type, field, kind) DO UPDATE SET
+				events = schema_drift.events + EXCLUDED.events
+		`, d.Bucket, d.SiteID, d.Producer, d.SDK, d.SDKVersion, d.MetricType, d.Field, d.Kind, d.Events)
+	}
+
+	return p.pool.SendBatch(ctx, batch).Close()
+}
+
+// SchemaDriftRow is a drifting field of a sender over a time range
+type SchemaDriftRow struct {
+	SDK         string    `json:"sdk"`
+	SDKVersion  string    `json:"sdk_version"`
+	Producer    string    `json:"producer"`
+	SiteID      string    `json:"site_id"`
+	MetricType  string    `json:"metric_type"`
+	Field       string    `json:"field"`
+	Kind        string    `json:"kind"`
+	Events      int64     `json:"events"`
+	FirstSeenAt time.Time `json:"first_seen_at"`
+	LastSeenAt  time.Time `json:"last_seen_at"`
+}
+
+// GetSchemaDrift returns drifting fields since start per sender, of sites
+// (nil for all sites), the most frequent first
+func (p *Postgres) GetSchemaDrift(ctx context.Context, start time.Time, sites []string) ([]SchemaDriftRow, error) {
+	rows, err := p.pool.Query(ctx, `
+		SELECT sdk, sdk_version, producer, site_id, metric_type, field, kind,
+		       SUM(events)::bigint AS events, MIN(bucket), MAX(bucket) + INTERVAL '1 minute'
+		FROM schema_drift
+		WHERE bucket >= date_trunc('minute', $1::timestamptz) AND ($2::text[] IS NULL OR site_id = ANY($2))
+		GROUP BY sdk, sdk_version, producer, site_id, metric_type, field, kind
+		ORDER BY events DESC, sdk, sdk_version, field
+	`, start, sites)
+	if err != nil {
+		return nil, fmt.Errorf("query schema drift: %w", err)
+	}
+	defer rows.Close()
+
+	var result []SchemaDriftRow
+	for rows.Next() {
+		var d SchemaDriftRow
+		if err := rows.Scan(&d.SDK, &d.SDKVersion, &d.Producer, &d.SiteID, &d.MetricType,
+			&d.Field, &d.Kind, &d.Events, &d.FirstSeenAt, &d.LastSeenAt); err != nil {
+			return nil, fmt.Errorf("scan row: %w", err)
+		}
+		result = append(result, d)
+	}
+
+	return result, rows.Err()
 }
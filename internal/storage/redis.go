@@ -0,0 +1,190 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ============================================
+// REDIS SESSION STORE
+// ============================================
+
+// redisSessionPrefix namespaces session keys in a shared Redis
+const redisSessionPrefix = "pulse:session:"
+
+// UserLookup loads users for sessions kept outside Postgres
+type UserLookup interface {
+	GetUser(ctx context.Context, email string) (User, error)
+}
+
+// RedisSessions keeps dashboard sessions in Redis, as an alternative to
+// the sessions table (SESSION_STORE=redis). Keys expire with their tokens,
+// so no cleanup job is needed. Users and roles stay in Postgres.
+type RedisSessions struct {
+	client *redis.Client
+	users  UserLookup
+}
+
+// NewRedisSessions creates a session store on client
+func NewRedisSessions(client *redis.Client, users UserLookup) *RedisSessions {
+	return &RedisSessions{client: client, users: users}
+}
+
+// redisSession is stored under both token hashes: under the access token
+// to authenticate requests, under the refresh token to rotate the pair
+type redisSession struct {
+	Email         string `json:"email"`
+	TokenHash     string `json:"token_hash"`
+	RefreshHash   string `json:"refresh_hash"`
+	ClientNet     string `json:"client_net,omitempty"`
+	UserAgentHash string `json:"user_agent_hash,omitempty"`
+}
+
+func (s redisSession) binding() SessionBinding {
+	return SessionBinding{ClientNet: s.ClientNet, UserAgentHash: s.UserAgentHash}
+}
+
+func accessKey(tokenHash string) string    { return redisSessionPrefix + "access:" + tokenHash }
+func refreshKey(refreshHash string) string { return redisSessionPrefix + "refresh:" + refreshHash }
+
+// CreateSession stores a session
+func (s *RedisSessions) CreateSession(ctx context.Context, session Session) error {
+	if err := s.store(ctx, session); err != nil {
+		return fmt.Errorf("create session for %s: %w", session.Email, err)
+	}
+	return nil
+}
+
+func (s *RedisSessions) store(ctx context.Context, session Session) error {
+	value, err := json.Marshal(redisSession{
+		Email:         session.Email,
+		TokenHash:     session.TokenHash,
+		RefreshHash:   session.RefreshHash,
+		ClientNet:     session.Binding.ClientNet,
+		UserAgentHash: session.Binding.UserAgentHash,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, accessKey(session.TokenHash), value, time.Until(session.ExpiresAt))
+		pipe.Set(ctx, refreshKey(session.RefreshHash), value, time.Until(session.RefreshExpiresAt))
+		return nil
+	})
+	return err
+}
+
+// RefreshSession replaces the tokens and binding of the session with an
+// unexpired refresh token and extends it, returning its user and its
+// binding before the refresh. The old tokens stop working; unknown or
+// expired refresh tokens give ErrSessionNotFound.
+func (s *RedisSessions) RefreshSession(ctx context.Context, refreshHash string, next Session) (User, SessionBinding, error) {
+	// GETDEL makes sure a refresh token is only used once, even by
+	// concurrent requests to different collectors
+	value, err := s.client.GetDel(ctx, refreshKey(refreshHash)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return User{}, SessionBinding{}, ErrSessionNotFound
+	}
+	if err != nil {
+		return User{}, SessionBinding{}, fmt.Errorf("refresh session: %w", err)
+	}
+
+	var old redisSession
+	if err := json.Unmarshal(value, &old); err != nil {
+		return User{}, SessionBinding{}, fmt.Errorf("decode session: %w", err)
+	}
+	if err := s.client.Del(ctx, accessKey(old.TokenHash)).Err(); err != nil {
+		return User{}, SessionBinding{}, fmt.Errorf("refresh session: %w", err)
+	}
+
+	next.Email = old.Email
+	if err := s.store(ctx, next); err != nil {
+		return User{}, SessionBinding{}, fmt.Errorf("refresh session: %w", err)
+	}
+	u, err := s.user(ctx, old.Email)
+	return u, old.binding(), err
+}
+
+// GetSession returns the user and binding of an unexpired session
+func (s *RedisSessions) GetSession(ctx context.Context, tokenHash string) (User, SessionBinding, error) {
+	value, err := s.client.Get(ctx, accessKey(tokenHash)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return User{}, SessionBinding{}, ErrSessionNotFound
+	}
+	if err != nil {
+		return User{}, SessionBinding{}, fmt.Errorf("get session: %w", err)
+	}
+
+	var session redisSession
+	if err := json.Unmarshal(value, &session); err != nil {
+		return User{}, SessionBinding{}, fmt.Errorf("decode session: %w", err)
+	}
+	u, err := s.user(ctx, session.Email)
+	return u, session.binding(), err
+}
+
+// RebindSession moves a session to another client. The keys keep their
+// expiry.
+func (s *RedisSessions) RebindSession(ctx context.Context, tokenHash string, b SessionBinding) error {
+	value, err := s.client.Get(ctx, accessKey(tokenHash)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("rebind session: %w", err)
+	}
+
+	var session redisSession
+	if err := json.Unmarshal(value, &session); err != nil {
+		return fmt.Errorf("decode session: %w", err)
+	}
+	session.ClientNet, session.UserAgentHash = b.ClientNet, b.UserAgentHash
+	if value, err = json.Marshal(session); err != nil {
+		return err
+	}
+
+	_, err = s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.SetArgs(ctx, accessKey(session.TokenHash), value, redis.SetArgs{KeepTTL: true, Mode: "XX"})
+		pipe.SetArgs(ctx, refreshKey(session.RefreshHash), value, redis.SetArgs{KeepTTL: true, Mode: "XX"})
+		return nil
+	})
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return fmt.Errorf("rebind session: %w", err)
+	}
+	return nil
+}
+
+// user loads the user of a session; sessions of deleted users are gone
+func (s *RedisSessions) user(ctx context.Context, email string) (User, error) {
+	u, err := s.users.GetUser(ctx, email)
+	if errors.Is(err, ErrUserNotFound) {
+		return u, ErrSessionNotFound
+	}
+	return u, err
+}
+
+// DeleteSession ends a session
+func (s *RedisSessions) DeleteSession(ctx context.Context, tokenHash string) error {
+	value, err := s.client.GetDel(ctx, accessKey(tokenHash)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("delete session: %w", err)
+	}
+
+	var session redisSession
+	if err := json.Unmarshal(value, &session); err != nil {
+		return fmt.Errorf("decode session: %w", err)
+	}
+	if err := s.client.Del(ctx, refreshKey(session.RefreshHash)).Err(); err != nil {
+		return fmt.Errorf("delete session: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,188 @@
+package support
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"time"
+
+	"github.com/mcbile/product-pulse/internal/config"
+	"github.com/mcbile/product-pulse/internal/storage"
+)
+
+// Storage is the subset of storage read into a bundle
+type Storage interface {
+	GetSchemaInfo(ctx context.Context) (storage.SchemaInfo, error)
+	PoolStats() storage.PoolStats
+}
+
+// Sources are what a bundle is built from. Only Config is required: the
+// support-bundle command has no logs or stats of a running collector, and
+// may have no database.
+type Sources struct {
+	Origin    string         // What built the bundle, e.g. admin endpoint or cli
+	Config    *config.Config // Redacted before it is written
+	Storage   Storage
+	Jobs      func(ctx context.Context) (any, error)
+	Stats     func(ctx context.Context) (any, error) // Snapshot of the collectors' counters
+	Logs      *LogBuffer
+	StartedAt time.Time // Start of the collector, zero if unknown
+}
+
+// manifest describes a bundle and what could not be gathered
+type manifest struct {
+	GeneratedAt time.Time         `json:"generated_at"`
+	Origin      string            `json:"origin"`
+	Hostname    string            `json:"hostname"`
+	GoVersion   string            `json:"go_version"`
+	Module      string            `json:"module,omitempty"`
+	Revision    string            `json:"revision,omitempty"` // VCS revision of the build
+	Modified    bool              `json:"modified,omitempty"` // Built from a dirty tree
+	Files       []string          `json:"files"`
+	Errors      map[string]string `json:"errors,omitempty"` // Per file that could not be written
+}
+
+// runtimeInfo is the state of the process the bundle was built in
+type runtimeInfo struct {
+	OS            string  `json:"os"`
+	Arch          string  `json:"arch"`
+	NumCPU        int     `json:"num_cpu"`
+	GOMAXPROCS    int     `json:"gomaxprocs"`
+	Goroutines    int     `json:"goroutines"`
+	UptimeSeconds float64 `json:"uptime_seconds,omitempty"`
+	HeapAlloc     uint64  `json:"heap_alloc_bytes"`
+	HeapInuse     uint64  `json:"heap_inuse_bytes"`
+	Sys           uint64  `json:"sys_bytes"`
+	NumGC         uint32  `json:"num_gc"`
+	PauseTotalNs  uint64  `json:"gc_pause_total_ns"`
+}
+
+// Filename returns the name of a bundle built at t
+func Filename(t time.Time) string {
+	return "pulse-support-" + t.UTC().Format("20060102-150405") + ".zip"
+}
+
+// Write builds a bundle from src and writes it to w as a zip archive of
+// JSON files. A source that fails is recorded in manifest.json instead of
+// failing the bundle; the returned error is one of writing to w.
+func Write(ctx context.Context, w io.Writer, src Sources) error {
+	m := manifest{
+		GeneratedAt: time.Now().UTC(),
+		Origin:      src.Origin,
+		GoVersion:   runtime.Version(),
+		Errors:      make(map[string]string),
+	}
+	m.Hostname, _ = os.Hostname()
+	if info, ok := debug.ReadBuildInfo(); ok {
+		m.Module = info.Main.Path
+		for _, s := range info.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				m.Revision = s.Value
+			case "vcs.modified":
+				m.Modified = s.Value == "true"
+			}
+		}
+	}
+
+	zw := zip.NewWriter(w)
+	add := func(name string, v any, err error) error {
+		if err != nil {
+			m.Errors[name] = err.Error()
+			return nil
+		}
+		f, err := zw.Create(name)
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(v); err != nil {
+			return err
+		}
+		m.Files = append(m.Files, name)
+		return nil
+	}
+
+	redacted := src.Config.Redacted()
+	if err := add("config.json", redacted, nil); err != nil {
+		return err
+	}
+	if err := add("runtime.json", readRuntime(src.StartedAt), nil); err != nil {
+		return err
+	}
+
+	if src.Storage != nil {
+		schema, err := src.Storage.GetSchemaInfo(ctx)
+		if err := add("schema.json", schema, err); err != nil {
+			return err
+		}
+		if err := add("pool.json", src.Storage.PoolStats(), nil); err != nil {
+			return err
+		}
+	} else {
+		m.Errors["schema.json"] = "no database connection"
+		m.Errors["pool.json"] = "no database connection"
+	}
+
+	if src.Jobs != nil {
+		jobs, err := src.Jobs(ctx)
+		if err := add("jobs.json", jobs, err); err != nil {
+			return err
+		}
+	}
+	if src.Stats != nil {
+		stats, err := src.Stats(ctx)
+		if err := add("stats.json", stats, err); err != nil {
+			return err
+		}
+	}
+
+	if src.Logs != nil {
+		f, err := zw.Create("logs.jsonl")
+		if err != nil {
+			return err
+		}
+		for _, line := range src.Logs.Lines() {
+			if _, err := fmt.Fprintf(f, "%s\n", line); err != nil {
+				return err
+			}
+		}
+		m.Files = append(m.Files, "logs.jsonl")
+	}
+
+	if len(m.Errors) == 0 {
+		m.Errors = nil
+	}
+	if err := add("manifest.json", m, nil); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+func readRuntime(startedAt time.Time) runtimeInfo {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+
+	info := runtimeInfo{
+		OS:           runtime.GOOS,
+		Arch:         runtime.GOARCH,
+		NumCPU:       runtime.NumCPU(),
+		GOMAXPROCS:   runtime.GOMAXPROCS(0),
+		Goroutines:   runtime.NumGoroutine(),
+		HeapAlloc:    ms.HeapAlloc,
+		HeapInuse:    ms.HeapInuse,
+		Sys:          ms.Sys,
+		NumGC:        ms.NumGC,
+		PauseTotalNs: ms.PauseTotalNs,
+	}
+	if !startedAt.IsZero() {
+		info.UptimeSeconds = time.Since(startedAt).Seconds()
+	}
+	return info
+}
@@ -0,0 +1,62 @@
+package support
+
+import (
+	"bytes"
+	"sync"
+)
+
+// DefaultLogLines is how many log lines the collector keeps for bundles
+const DefaultLogLines = 1000
+
+// LogBuffer keeps the last lines written to it. The collector's logger
+// writes to it next to stdout, so a bundle carries the recent log without
+// access to wherever stdout ends up.
+type LogBuffer struct {
+	mu    sync.Mutex
+	lines [][]byte
+	next  int  // Slot of the next line
+	full  bool // Every slot holds a line
+}
+
+// NewLogBuffer creates a buffer of the last size lines
+func NewLogBuffer(size int) *LogBuffer {
+	if size <= 0 {
+		size = DefaultLogLines
+	}
+	return &LogBuffer{lines: make([][]byte, size)}
+}
+
+// Write stores p, which slog handlers write one record at a time
+func (b *LogBuffer) Write(p []byte) (int, error) {
+	line := bytes.TrimRight(p, "\n")
+	if len(line) == 0 {
+		return len(p), nil
+	}
+
+	b.mu.Lock()
+	b.lines[b.next] = append(b.lines[b.next][:0], line...)
+	b.next = (b.next + 1) % len(b.lines)
+	if b.next == 0 {
+		b.full = true
+	}
+	b.mu.Unlock()
+	return len(p), nil
+}
+
+// Lines returns the stored lines, oldest first
+func (b *LogBuffer) Lines() [][]byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var ordered [][]byte
+	if b.full {
+		ordered = append(ordered, b.lines[b.next:]...)
+	}
+	ordered = append(ordered, b.lines[:b.next]...)
+
+	result := make([][]byte, len(ordered))
+	for i, line := range ordered {
+		result[i] = bytes.Clone(line)
+	}
+	return result
+}
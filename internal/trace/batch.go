@@ -0,0 +1,72 @@
+package trace
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+)
+
+// Header carries the batch ID. Clients may set it to correlate their own
+// logs; the collector echoes the ID it used in the response.
+const Header = "X-Pulse-Batch-Id"
+
+// maxIDLength bounds client-supplied batch IDs
+const maxIDLength = 64
+
+// NewID returns a random batch ID
+func NewID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// FromRequest returns the batch ID supplied by the client, or a new one if
+// it is missing or malformed
+func FromRequest(r *http.Request) string {
+	if id := r.Header.Get(Header); validID(id) {
+		return id
+	}
+	return NewID()
+}
+
+func validID(id string) bool {
+	if id == "" || len(id) > maxIDLength {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_', c == '.', c == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// Flush identifies one collector flush and the request batches it holds
+type Flush struct {
+	ID        string
+	Collector string
+	BatchIDs  []string
+}
+
+type flushKey struct{}
+
+// WithFlush attaches flush identity to ctx so storage can log it
+func WithFlush(ctx context.Context, f Flush) context.Context {
+	return context.WithValue(ctx, flushKey{}, f)
+}
+
+// FlushFrom returns the flush attached to ctx
+func FlushFrom(ctx context.Context) (Flush, bool) {
+	f, ok := ctx.Value(flushKey{}).(Flush)
+	return f, ok
+}
+
+// Enabled reports whether batch timing should be recorded; tracing only
+// produces debug logs, so it is skipped unless debug logging is on
+func Enabled(ctx context.Context) bool {
+	return slog.Default().Enabled(ctx, slog.LevelDebug)
+}
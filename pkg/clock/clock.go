@@ -0,0 +1,194 @@
+// Package clock abstracts the passage of time. Code that flushes, expires
+// or schedules takes a Clock instead of calling time.Now and time.NewTicker
+// directly, so tests can substitute a Fake and move time forward
+// deterministically.
+package clock
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock tells the time and creates tickers and timers
+type Clock interface {
+	Now() time.Time
+	Since(t time.Time) time.Duration
+	NewTicker(d time.Duration) Ticker
+	NewTimer(d time.Duration) Timer
+}
+
+// Ticker delivers ticks at intervals, like time.Ticker
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// Timer delivers a single tick, like time.Timer
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+}
+
+// Real is the system clock
+var Real Clock = realClock{}
+
+// OrReal returns c, or Real if c is nil
+func OrReal(c Clock) Clock {
+	if c == nil {
+		return Real
+	}
+	return c
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                   { return time.Now() }
+func (realClock) Since(t time.Time) time.Duration  { return time.Since(t) }
+func (realClock) NewTicker(d time.Duration) Ticker { return realTicker{time.NewTicker(d)} }
+func (realClock) NewTimer(d time.Duration) Timer   { return realTimer{time.NewTimer(d)} }
+
+type realTicker struct{ t *time.Ticker }
+
+func (t realTicker) C() <-chan time.Time { return t.t.C }
+func (t realTicker) Stop()               { t.t.Stop() }
+
+type realTimer struct{ t *time.Timer }
+
+func (t realTimer) C() <-chan time.Time { return t.t.C }
+func (t realTimer) Stop() bool          { return t.t.Stop() }
+
+// ============================================
+// FAKE CLOCK
+// ============================================
+
+// Fake is a clock that only moves when advanced. Tickers and timers fire
+// during Advance, in time order; like their time counterparts they drop
+// ticks a slow receiver has not picked up.
+type Fake struct {
+	mu      sync.Mutex
+	changed *sync.Cond // Signalled when tickers or timers are added or removed
+	now     time.Time
+	waiters []*fakeWaiter
+}
+
+// NewFake creates a fake clock set to now
+func NewFake(now time.Time) *Fake {
+	f := &Fake{now: now}
+	f.changed = sync.NewCond(&f.mu)
+	return f
+}
+
+// Now returns the fake time
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+// Since returns the fake time elapsed since t
+func (f *Fake) Since(t time.Time) time.Duration {
+	return f.Now().Sub(t)
+}
+
+// NewTicker creates a ticker firing every d of fake time
+func (f *Fake) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("clock: non-positive interval for NewTicker")
+	}
+	return fakeTicker{f.add(d, d)}
+}
+
+// NewTimer creates a timer firing once after d of fake time
+func (f *Fake) NewTimer(d time.Duration) Timer {
+	return f.add(d, 0)
+}
+
+func (f *Fake) add(d, period time.Duration) *fakeWaiter {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	w := &fakeWaiter{clock: f, at: f.now.Add(d), period: period, ch: make(chan time.Time, 1)}
+	f.waiters = append(f.waiters, w)
+	f.changed.Broadcast()
+	return w
+}
+
+// BlockUntil waits until at least n tickers and timers are pending, so a
+// test can advance the clock once the code under test is waiting on it
+func (f *Fake) BlockUntil(n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for len(f.waiters) < n {
+		f.changed.Wait()
+	}
+}
+
+// Advance moves the clock forward by d, firing every ticker and timer that
+// comes due on the way
+func (f *Fake) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	target := f.now.Add(d)
+	for {
+		sort.SliceStable(f.waiters, func(i, j int) bool {
+			return f.waiters[i].at.Before(f.waiters[j].at)
+		})
+		if len(f.waiters) == 0 || f.waiters[0].at.After(target) {
+			break
+		}
+
+		w := f.waiters[0]
+		f.now = w.at
+		select {
+		case w.ch <- w.at:
+		default:
+		}
+		if w.period > 0 {
+			w.at = w.at.Add(w.period)
+		} else {
+			f.waiters = f.waiters[1:]
+		}
+	}
+	f.now = target
+}
+
+// Set moves the clock to t, firing like Advance. Times before the current
+// fake time are ignored.
+func (f *Fake) Set(t time.Time) {
+	if d := t.Sub(f.Now()); d > 0 {
+		f.Advance(d)
+	}
+}
+
+// remove stops w and reports whether it was still pending
+func (f *Fake) remove(w *fakeWaiter) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for i, other := range f.waiters {
+		if other == w {
+			f.waiters = append(f.waiters[:i], f.waiters[i+1:]...)
+			f.changed.Broadcast()
+			return true
+		}
+	}
+	return false
+}
+
+// fakeWaiter is a ticker (period > 0) or timer of a Fake
+type fakeWaiter struct {
+	clock  *Fake
+	at     time.Time
+	period time.Duration
+	ch     chan time.Time
+}
+
+func (w *fakeWaiter) C() <-chan time.Time { return w.ch }
+func (w *fakeWaiter) Stop() bool          { return w.clock.remove(w) }
+
+type fakeTicker struct{ w *fakeWaiter }
+
+func (t fakeTicker) C() <-chan time.Time { return t.w.ch }
+func (t fakeTicker) Stop()               { t.w.clock.remove(t.w) }
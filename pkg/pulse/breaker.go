@@ -0,0 +1,95 @@
+package pulse
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ============================================
+// CIRCUIT BREAKER
+// ============================================
+
+// ErrBreakerOpen means Flush sent nothing and dropped the metrics because
+// the collector failed too often in a row; see ClientConfig.BreakerThreshold
+var ErrBreakerOpen = errors.New("pulse: circuit breaker open, metrics dropped")
+
+// breaker stops sending after threshold consecutive flushes failed with
+// ErrRetryable. After the cooldown one flush is let through as a probe:
+// its success closes the breaker, its failure opens it for another
+// cooldown.
+type breaker struct {
+	mu        sync.Mutex
+	threshold int // Disabled when negative
+	cooldown  time.Duration
+	failures  int       // Consecutive failed flushes
+	openUntil time.Time // Open while set
+	probing   bool      // A probe is in flight
+
+	dropped atomic.Int64
+}
+
+func newBreaker(threshold int, cooldown time.Duration) *breaker {
+	return &breaker{threshold: threshold, cooldown: cooldown}
+}
+
+// allow reports whether a flush may be sent now
+func (br *breaker) allow(now time.Time) bool {
+	br.mu.Lock()
+	defer br.mu.Unlock()
+	if br.threshold < 0 || br.openUntil.IsZero() {
+		return true
+	}
+	if br.probing || now.Before(br.openUntil) {
+		return false
+	}
+	br.probing = true
+	return true
+}
+
+// record counts the outcome of a flush that allow let through. A flush
+// cut short by its context says nothing about the collector.
+func (br *breaker) record(ctx context.Context, now time.Time, err error) {
+	if br.threshold < 0 {
+		return
+	}
+	br.mu.Lock()
+	defer br.mu.Unlock()
+	probe := br.probing
+	br.probing = false
+
+	if ctx.Err() != nil {
+		return
+	}
+
+	if !errors.Is(err, ErrRetryable) {
+		if !br.openUntil.IsZero() {
+			slog.Info("pulse: collector reachable again, circuit breaker closed", "dropped", br.dropped.Load())
+		}
+		br.failures = 0
+		br.openUntil = time.Time{}
+		return
+	}
+
+	br.failures++
+	if probe || br.failures >= br.threshold {
+		if br.openUntil.IsZero() {
+			slog.Warn("pulse: collector failing, circuit breaker open", "failures", br.failures, "cooldown", br.cooldown)
+		}
+		br.openUntil = now.Add(br.cooldown)
+	}
+}
+
+// drop counts metrics dropped while the breaker is open
+func (br *breaker) drop(metrics int) {
+	br.dropped.Add(int64(metrics))
+}
+
+// DroppedByBreaker returns how many metrics were dropped since the client
+// was created because the circuit breaker was open
+func (c *Client) DroppedByBreaker() int64 {
+	return c.breaker.dropped.Load()
+}
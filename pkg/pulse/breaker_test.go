@@ -0,0 +1,143 @@
+package pulse
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mcbile/product-pulse/pkg/clock"
+)
+
+// flakyCollector answers 503 while down and counts the requests it gets
+type flakyCollector struct {
+	down     atomic.Bool
+	requests atomic.Int64
+}
+
+func (f *flakyCollector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.requests.Add(1)
+	if f.down.Load() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func TestBreakerTransitions(t *testing.T) {
+	collector := &flakyCollector{}
+	collector.down.Store(true)
+	srv := httptest.NewServer(collector)
+	defer srv.Close()
+
+	clk := clock.NewFake(time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC))
+	c := NewClient(ClientConfig{
+		Endpoint:         srv.URL,
+		FlushInterval:    time.Hour, // Only the test flushes
+		RetryBufferSize:  -1,
+		BreakerThreshold: 3,
+		BreakerCooldown:  30 * time.Second,
+		ConfigInterval:   -1,
+		Clock:            clk,
+	})
+	defer c.Close()
+
+	flush := func() error {
+		c.TrackAPI(APIMetric{ServiceName: "wallet", Endpoint: "/balance", Method: "GET", StatusCode: 200})
+		return c.Flush(context.Background())
+	}
+
+	// Closed: failures are sent and counted until the threshold
+	for i := 1; i <= 3; i++ {
+		if err := flush(); !errors.Is(err, ErrRetryable) {
+			t.Fatalf("flush %d: %v, want a retryable error", i, err)
+		}
+	}
+	if n := collector.requests.Load(); n != 3 {
+		t.Fatalf("%d requests while closed, want 3", n)
+	}
+
+	// Open: nothing is sent until the cooldown has passed
+	if err := flush(); !errors.Is(err, ErrBreakerOpen) {
+		t.Fatalf("after threshold: %v, want ErrBreakerOpen", err)
+	}
+	clk.Advance(30*time.Second - time.Millisecond)
+	if err := flush(); !errors.Is(err, ErrBreakerOpen) {
+		t.Fatalf("before cooldown: %v, want ErrBreakerOpen", err)
+	}
+	if n, dropped := collector.requests.Load(), c.DroppedByBreaker(); n != 3 || dropped != 2 {
+		t.Fatalf("%d requests and %d dropped while open, want 3 and 2", n, dropped)
+	}
+
+	// Half-open: one probe; its failure opens the breaker for another cooldown
+	clk.Advance(time.Millisecond)
+	if err := flush(); !errors.Is(err, ErrRetryable) {
+		t.Fatalf("probe: %v, want a retryable error", err)
+	}
+	if err := flush(); !errors.Is(err, ErrBreakerOpen) {
+		t.Fatalf("after failed probe: %v, want ErrBreakerOpen", err)
+	}
+	if n := collector.requests.Load(); n != 4 {
+		t.Fatalf("%d requests, want 4 with the probe", n)
+	}
+
+	// A successful probe closes it
+	collector.down.Store(false)
+	clk.Advance(30 * time.Second)
+	if err := flush(); err != nil {
+		t.Fatalf("probe: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := flush(); err != nil {
+			t.Fatalf("closed again, flush %d: %v", i, err)
+		}
+	}
+	if n := collector.requests.Load(); n != 8 {
+		t.Fatalf("%d requests, want 8", n)
+	}
+}
+
+func TestBreakerAllowsOneProbeAtATime(t *testing.T) {
+	now := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	br := newBreaker(1, time.Minute)
+	ctx := context.Background()
+
+	br.record(ctx, now, ErrRetryable)
+	if br.allow(now) {
+		t.Fatal("open breaker allowed a flush")
+	}
+
+	now = now.Add(time.Minute)
+	if !br.allow(now) {
+		t.Fatal("no probe after the cooldown")
+	}
+	if br.allow(now) {
+		t.Fatal("second flush allowed while probing")
+	}
+
+	// A probe cut short by its context neither closes nor reopens it
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	br.record(cancelled, now, context.Canceled)
+	if !br.allow(now) {
+		t.Fatal("no new probe after a cancelled one")
+	}
+	br.record(ctx, now, nil)
+	if !br.allow(now) || !br.allow(now) {
+		t.Fatal("breaker not closed after a successful probe")
+	}
+}
+
+func TestBreakerDisabled(t *testing.T) {
+	now := time.Now()
+	br := newBreaker(-1, time.Minute)
+	for i := 0; i < 10; i++ {
+		br.record(context.Background(), now, ErrRetryable)
+	}
+	if !br.allow(now) {
+		t.Fatal("disabled breaker opened")
+	}
+}
@@ -0,0 +1,269 @@
+package pulse
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ============================================
+// ERROR AND PANIC CAPTURE
+// ============================================
+
+// Bounds of captured errors
+const (
+	maxStackFrames    = 32
+	maxErrorMessage   = 1000
+	recoverFlushLimit = 2 * time.Second
+)
+
+// defaultClient is used by the package-level CaptureError and Recover
+var defaultClient atomic.Pointer[Client]
+
+// SetDefault makes c the client of the package-level CaptureError,
+// Recover and RecoverContext, e.g. right after NewClient in main
+func SetDefault(c *Client) {
+	defaultClient.Store(c)
+}
+
+type requestKey struct{}
+
+// requestInfo is what HTTPMiddleware knows about a request. Errors
+// captured while it is served are reported on the request's API metric
+// rather than as a metric of their own, so requests are not counted twice.
+type requestInfo struct {
+	client    *Client
+	service   string
+	method    string
+	endpoint  string
+	requestID *string
+
+	mu       sync.Mutex
+	captured *capturedError // The first error captured
+	extra    int            // Errors captured after the first
+}
+
+type capturedError struct {
+	errorType string
+	message   string
+	stack     string
+	panicked  bool
+}
+
+// CaptureError records err with its stack and, inside a request served by
+// HTTPMiddleware, the request's method, endpoint and request ID, through the
+// client of ctx's request or the default client. Errors show up in the
+// error explorer and its alerts like frontend errors. Without a client it
+// does nothing.
+func CaptureError(ctx context.Context, err error) {
+	if info, ok := ctx.Value(requestKey{}).(*requestInfo); ok {
+		info.client.captureError(ctx, err)
+		return
+	}
+	defaultClient.Load().captureError(ctx, err)
+}
+
+// CaptureError records err like the package-level CaptureError
+func (c *Client) CaptureError(ctx context.Context, err error) {
+	c.captureError(ctx, err)
+}
+
+func (c *Client) captureError(ctx context.Context, err error) {
+	if c == nil || err == nil {
+		return
+	}
+	c.capture(ctx, &capturedError{
+		errorType: errorType(err),
+		message:   err.Error(),
+		stack:     stackTrace(4, false),
+	}, callerName(4))
+}
+
+// Recover records a panic with its stack through the default client,
+// flushes it and panics again, so the program fails as it would have
+// without it. Defer it at the top of goroutines:
+//
+//	go func() {
+//		defer pulse.Recover()
+//		...
+//	}()
+//
+// Recover the panic yourself after it to keep running.
+func Recover() {
+	if v := recover(); v != nil {
+		defaultClient.Load().recovered(context.Background(), v)
+		panic(v)
+	}
+}
+
+// RecoverContext is Recover with the request context of ctx
+func RecoverContext(ctx context.Context) {
+	if v := recover(); v != nil {
+		if info, ok := ctx.Value(requestKey{}).(*requestInfo); ok {
+			info.client.recovered(ctx, v)
+		} else {
+			defaultClient.Load().recovered(ctx, v)
+		}
+		panic(v)
+	}
+}
+
+// Recover is the package-level Recover with this client
+func (c *Client) Recover() {
+	if v := recover(); v != nil {
+		c.recovered(context.Background(), v)
+		panic(v)
+	}
+}
+
+// recovered records a panic outside HTTPMiddleware and flushes, since the
+// process is likely about to exit
+func (c *Client) recovered(ctx context.Context, v interface{}) {
+	if c == nil || v == http.ErrAbortHandler {
+		return
+	}
+	c.capture(ctx, panicError(v), "")
+	if _, ok := ctx.Value(requestKey{}).(*requestInfo); ok {
+		return // Reported with the request by HTTPMiddleware
+	}
+	flushCtx, cancel := context.WithTimeout(context.Background(), recoverFlushLimit)
+	defer cancel()
+	c.Flush(flushCtx)
+}
+
+// capture attaches the error to the request of ctx, or records it as an
+// API metric of its own named after the function that captured it or
+// panicked
+func (c *Client) capture(ctx context.Context, e *capturedError, caller string) {
+	info, ok := ctx.Value(requestKey{}).(*requestInfo)
+	if ok && info.client == c {
+		info.attach(e)
+		return
+	}
+
+	m := APIMetric{
+		ServiceName: c.serviceName,
+		Endpoint:    caller,
+	}
+	if ok {
+		m.ServiceName, m.Method, m.Endpoint, m.RequestID = info.service, info.method, info.endpoint, info.requestID
+	}
+	if m.Endpoint == "" {
+		// The function that panicked
+		m.Endpoint, _, _ = strings.Cut(e.stack, "\n")
+	}
+	if e.panicked {
+		m.StatusCode = http.StatusInternalServerError
+	}
+	e.apply(&m, 0)
+	c.TrackAPI(m)
+}
+
+func (info *requestInfo) attach(e *capturedError) {
+	info.mu.Lock()
+	defer info.mu.Unlock()
+	// A panic outranks errors captured before it
+	if info.captured == nil || (e.panicked && !info.captured.panicked) {
+		if info.captured != nil {
+			info.extra++
+		}
+		info.captured = e
+		return
+	}
+	info.extra++
+}
+
+// apply reports the captured error, if any, on the request's metric
+func (info *requestInfo) apply(m *APIMetric) {
+	info.mu.Lock()
+	defer info.mu.Unlock()
+	if info.captured != nil {
+		info.captured.apply(m, info.extra)
+	}
+}
+
+func (e *capturedError) apply(m *APIMetric, extra int) {
+	message := e.message
+	if len(message) > maxErrorMessage {
+		message = message[:maxErrorMessage]
+	}
+	m.ErrorType = StringPtr(e.errorType)
+	m.ErrorMessage = StringPtr(message)
+	if m.Metadata == nil {
+		m.Metadata = make(map[string]interface{})
+	}
+	m.Metadata["stack"] = e.stack
+	if e.panicked {
+		m.Metadata["panic"] = true
+	}
+	if extra > 0 {
+		m.Metadata["more_errors"] = extra
+	}
+}
+
+func panicError(v interface{}) *capturedError {
+	e := &capturedError{errorType: "panic", stack: stackTrace(0, true), panicked: true}
+	if err, ok := v.(error); ok {
+		e.message = err.Error()
+	} else {
+		e.message = fmt.Sprint(v)
+	}
+	return e
+}
+
+// errorType is the Go type of err under its fmt.Errorf wrapping, e.g.
+// *fs.PathError, so errors group by cause rather than by message
+func errorType(err error) string {
+	for {
+		t := fmt.Sprintf("%T", err)
+		next := errors.Unwrap(err)
+		if next == nil || (t != "*fmt.wrapError" && t != "*fmt.wrapErrors") {
+			return t
+		}
+		err = next
+	}
+}
+
+// stackTrace formats the calling goroutine's stack, skipping skip frames.
+// For panics the frames up to runtime.gopanic (the deferred functions) are
+// left out, so the stack starts where the panic happened.
+func stackTrace(skip int, panicked bool) string {
+	pcs := make([]uintptr, maxStackFrames+16)
+	n := runtime.Callers(skip, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+
+	var lines []string
+	skipping := panicked
+	for {
+		f, more := frames.Next()
+		switch {
+		case skipping:
+			skipping = f.Function != "runtime.gopanic"
+		case len(lines) < maxStackFrames*2:
+			lines = append(lines, f.Function, fmt.Sprintf("\t%s:%d", f.File, f.Line))
+		}
+		if !more {
+			break
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// callerName is the function skip frames up the stack, e.g.
+// "main.(*worker).run"
+func callerName(skip int) string {
+	pc, _, _, ok := runtime.Caller(skip - 1)
+	if !ok {
+		return ""
+	}
+	if f := runtime.FuncForPC(pc); f != nil {
+		return f.Name()
+	}
+	return ""
+}
@@ -3,18 +3,67 @@ package pulse
 import (
 	"bytes"
 	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log/slog"
 	"net/http"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/mcbile/product-pulse/pkg/clock"
+)
+
+// Version of the Go client, reported to the collector on registration
+const Version = "1.4.0"
+
+// SDK identification headers. The collector answers requests from SDK
+// versions below its configured minimum with SDKDeprecationHeader.
+const (
+	SDKHeader            = "X-Pulse-SDK"
+	SDKDeprecationHeader = "X-Pulse-SDK-Deprecated"
+
+	sdkHeaderValue = "go/" + Version
+)
+
+// Credential headers, checked by the collector for sites with credentials
+const (
+	APIKeyHeader    = "X-Pulse-Key"
+	SignatureHeader = "X-Pulse-Signature"
+)
+
+// Classes of Flush errors, matched with errors.Is. A *StatusError matches
+// the class of its status code.
+var (
+	// ErrValidation means the collector rejected the request as invalid
+	// (400, 413, 415, 422); sending the same metrics again fails again
+	ErrValidation = errors.New("pulse: metrics rejected by collector")
+
+	// ErrRetryable means the request may succeed later: network errors,
+	// timeouts (408), backpressure (429) and server errors (5xx)
+	ErrRetryable = errors.New("pulse: temporary collector failure")
+
+	// ErrQueueFull means the collector is overloaded and refused the
+	// metrics (429, 503). It is also ErrRetryable; StatusError.RetryAfter
+	// tells when.
+	ErrQueueFull = errors.New("pulse: collector queue full")
 )
 
 // Client for Go services to report metrics directly to the collector
 type Client struct {
-	endpoint   string
-	httpClient *http.Client
-	siteID     string
+	endpoint    string
+	httpClient  *http.Client
+	siteID      string
+	serviceName string
+	apiKey      string
+	signingKey  []byte
+	token       string // Service account token
+	clock       clock.Clock
 
 	// Batching
 	mu            sync.Mutex
@@ -24,10 +73,33 @@ type Client struct {
 	wsMetrics     []WebSocketMetric
 	flushInterval time.Duration
 	batchSize     int
+	timeout       time.Duration
+
+	// Metrics of failed flushes, resent with backoff
+	retry    *retryBuffer
+	spoolDir string
+	breaker  *breaker
 
 	// Shutdown
 	done chan struct{}
 	wg   sync.WaitGroup
+
+	deprecationOnce sync.Once
+
+	// Set once the collector turned out not to support /collect/batch
+	legacyFlush atomic.Bool
+
+	// Bodies from compressThreshold bytes are gzipped, unless the collector
+	// turned out not to accept gzip (plainBodies)
+	compressThreshold int
+	plainBodies       atomic.Bool
+
+	// Runtime directives of the site from /collect/config, nil until fetched
+	remote         atomic.Pointer[remoteConfig]
+	configInterval time.Duration
+
+	// Share of metrics kept per type, 1 for types without one
+	sampleRates map[string]float64
 }
 
 type ClientConfig struct {
@@ -36,6 +108,68 @@ type ClientConfig struct {
 	FlushInterval time.Duration
 	BatchSize     int
 	Timeout       time.Duration
+
+	// Metrics of flushes that failed with ErrRetryable are kept and resent
+	// on later flushes, at most RetryBufferSize metrics (default 10000,
+	// oldest dropped first; negative disables), waiting RetryBackoff
+	// (default 1s) after the first failure and twice as long after every
+	// further one, up to a minute
+	RetryBufferSize int
+	RetryBackoff    time.Duration
+
+	// After BreakerThreshold consecutive flushes failed with ErrRetryable
+	// (default 5; negative disables) the client stops sending for
+	// BreakerCooldown (default 30s): Flush drops the metrics, counted by
+	// DroppedByBreaker, and returns ErrBreakerOpen, so a down collector
+	// does not tie up goroutines and connections. Then one flush probes
+	// the collector; if it fails, another cooldown starts.
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+
+	// SpoolDir keeps metrics across restarts: on Close the metrics of the
+	// retry buffer that could still not be sent are written to a file in
+	// it, and the next client with the same SpoolDir sends them. For batch
+	// jobs and CLIs that exit before the collector is reachable again.
+	// Needs the retry buffer.
+	SpoolDir string
+
+	// SampleRates keeps a share of the metrics of a type, by type (api,
+	// psp, game, ws), e.g. {"api": 0.1} keeps 10% of API metrics and all
+	// others. Metrics are kept or dropped by their request, transaction,
+	// session or connection ID, so the metrics of one request are sampled
+	// alike across services. A lower sample rate of the remote config wins.
+	SampleRates map[string]float64
+
+	// Request bodies of at least CompressThreshold bytes (default 4096;
+	// negative disables) are sent gzipped with Content-Encoding: gzip.
+	// Large batches of metrics compress to a fraction of their size.
+	CompressThreshold int
+
+	// The client polls the collector's /collect/config for its site's
+	// runtime directives: a kill switch, disabled metric types, a sample
+	// rate and an endpoint to send to instead. ConfigInterval overrides the
+	// poll interval the collector asks for (default 5m); negative disables
+	// remote config.
+	ConfigInterval time.Duration
+
+	// Producer registry. When ServiceName is set the client registers itself
+	// on startup and tags every request with it.
+	ServiceName string
+	OwnerTeam   string
+	MetricTypes []string // Expected metric types: api, psp, game, ws
+
+	// Site credentials issued via /api/sites/{site}/credentials. Requests
+	// carry the API key and/or an HMAC-SHA256 signature of the body.
+	APIKey        string
+	SigningSecret string
+
+	// Service account token issued via /api/service-accounts, sent as a
+	// bearer token. It replaces site credentials.
+	ServiceToken string
+
+	// Time source for metric timestamps, flush intervals and signatures;
+	// nil uses the system clock. Tests can pass a clock.Fake.
+	Clock clock.Clock
 }
 
 // Metric types for internal services
@@ -68,6 +202,8 @@ type PSPMetric struct {
 	ErrorCode       *string                `json:"error_code,omitempty"`
 	ErrorMessage    *string                `json:"error_message,omitempty"`
 	PSPResponseCode *string                `json:"psp_response_code,omitempty"`
+	State           *string                `json:"state,omitempty"`    // Withdrawal state: requested, approved, sent, settled, failed
+	Campaign        *string                `json:"campaign,omitempty"` // Bonus/promotion campaign the payment belongs to
 	Metadata        map[string]interface{} `json:"metadata,omitempty"`
 }
 
@@ -111,36 +247,100 @@ func NewClient(cfg ClientConfig) *Client {
 	if cfg.Timeout == 0 {
 		cfg.Timeout = 10 * time.Second
 	}
+	if cfg.RetryBufferSize == 0 {
+		cfg.RetryBufferSize = 10000
+	}
+	if cfg.RetryBackoff == 0 {
+		cfg.RetryBackoff = time.Second
+	}
+	if cfg.BreakerThreshold == 0 {
+		cfg.BreakerThreshold = 5
+	}
+	if cfg.BreakerCooldown == 0 {
+		cfg.BreakerCooldown = 30 * time.Second
+	}
+	if cfg.CompressThreshold == 0 {
+		cfg.CompressThreshold = defaultCompressThreshold
+	}
+
+	sampleRates := make(map[string]float64, len(cfg.SampleRates))
+	for typ, rate := range cfg.SampleRates {
+		sampleRates[typ] = min(max(rate, 0), 1)
+	}
 
 	c := &Client{
-		endpoint: cfg.Endpoint,
-		siteID:   cfg.SiteID,
+		endpoint:    cfg.Endpoint,
+		siteID:      cfg.SiteID,
+		serviceName: cfg.ServiceName,
+		apiKey:      cfg.APIKey,
+		signingKey:  []byte(cfg.SigningSecret),
+		token:       cfg.ServiceToken,
+		clock:       clock.OrReal(cfg.Clock),
 		httpClient: &http.Client{
 			Timeout: cfg.Timeout,
 		},
-		flushInterval: cfg.FlushInterval,
-		batchSize:     cfg.BatchSize,
-		done:          make(chan struct{}),
+		flushInterval:     cfg.FlushInterval,
+		batchSize:         cfg.BatchSize,
+		timeout:           cfg.Timeout,
+		retry:             newRetryBuffer(cfg.RetryBufferSize, cfg.RetryBackoff),
+		spoolDir:          cfg.SpoolDir,
+		breaker:           newBreaker(cfg.BreakerThreshold, cfg.BreakerCooldown),
+		configInterval:    cfg.ConfigInterval,
+		compressThreshold: cfg.CompressThreshold,
+		sampleRates:       sampleRates,
+		done:              make(chan struct{}),
+	}
+	if c.spoolDir != "" {
+		c.replaySpool(c.spoolDir)
+	}
+
+	if cfg.ServiceName != "" {
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), cfg.Timeout)
+			defer cancel()
+			c.register(ctx, cfg.OwnerTeam, cfg.MetricTypes)
+		}()
 	}
 
 	c.wg.Add(1)
 	go c.flushLoop()
 
+	if cfg.ConfigInterval >= 0 {
+		c.wg.Add(1)
+		go c.configLoop()
+	}
+
 	return c
 }
 
+// register announces this service to the collector's producer registry.
+// Failures are ignored: registration is informational only.
+func (c *Client) register(ctx context.Context, ownerTeam string, metricTypes []string) error {
+	return c.post(ctx, "/collect/register", map[string]interface{}{
+		"name":         c.serviceName,
+		"owner_team":   ownerTeam,
+		"sdk_version":  sdkHeaderValue,
+		"site_id":      c.siteID,
+		"metric_types": metricTypes,
+	})
+}
+
 func (c *Client) flushLoop() {
 	defer c.wg.Done()
 
-	ticker := time.NewTicker(c.flushInterval)
+	ticker := c.clock.NewTicker(c.flushInterval)
 	defer ticker.Stop()
 
 	for {
 		select {
-		case <-ticker.C:
+		case <-ticker.C():
+			c.resend(context.Background(), false)
 			c.Flush(context.Background())
 		case <-c.done:
 			c.Flush(context.Background())
+			ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+			c.resend(ctx, true)
+			cancel()
 			return
 		}
 	}
@@ -148,8 +348,11 @@ func (c *Client) flushLoop() {
 
 // TrackAPI records an API call metric
 func (c *Client) TrackAPI(m APIMetric) {
+	if !c.accept("api", sampleKey(m.RequestID)) {
+		return
+	}
 	if m.Time.IsZero() {
-		m.Time = time.Now().UTC()
+		m.Time = c.clock.Now().UTC()
 	}
 
 	c.mu.Lock()
@@ -164,8 +367,11 @@ func (c *Client) TrackAPI(m APIMetric) {
 
 // TrackPSP records a payment provider metric
 func (c *Client) TrackPSP(m PSPMetric) {
+	if !c.accept("psp", sampleKey(m.TransactionID)) {
+		return
+	}
 	if m.Time.IsZero() {
-		m.Time = time.Now().UTC()
+		m.Time = c.clock.Now().UTC()
 	}
 
 	c.mu.Lock()
@@ -180,8 +386,11 @@ func (c *Client) TrackPSP(m PSPMetric) {
 
 // TrackGame records a game provider metric
 func (c *Client) TrackGame(m GameMetric) {
+	if !c.accept("game", sampleKey(m.SessionID)) {
+		return
+	}
 	if m.Time.IsZero() {
-		m.Time = time.Now().UTC()
+		m.Time = c.clock.Now().UTC()
 	}
 
 	c.mu.Lock()
@@ -196,8 +405,11 @@ func (c *Client) TrackGame(m GameMetric) {
 
 // TrackWebSocket records a WebSocket connection metric
 func (c *Client) TrackWebSocket(m WebSocketMetric) {
+	if !c.accept("ws", m.ConnectionID) {
+		return
+	}
 	if m.Time.IsZero() {
-		m.Time = time.Now().UTC()
+		m.Time = c.clock.Now().UTC()
 	}
 
 	c.mu.Lock()
@@ -210,13 +422,18 @@ func (c *Client) TrackWebSocket(m WebSocketMetric) {
 	}
 }
 
-// Flush sends all buffered metrics
+// Flush sends all buffered metrics in one request to /collect/batch.
+// Collectors without the batch endpoint get one request per metric type.
+// Metrics that failed with ErrRetryable go to the retry buffer, so the
+// error does not mean they are lost.
 func (c *Client) Flush(ctx context.Context) error {
 	c.mu.Lock()
-	api := c.apiMetrics
-	psp := c.pspMetrics
-	game := c.gameMetrics
-	ws := c.wsMetrics
+	b := batch{
+		api:  c.apiMetrics,
+		psp:  c.pspMetrics,
+		game: c.gameMetrics,
+		ws:   c.wsMetrics,
+	}
 
 	c.apiMetrics = nil
 	c.pspMetrics = nil
@@ -224,72 +441,226 @@ func (c *Client) Flush(ctx context.Context) error {
 	c.wsMetrics = nil
 	c.mu.Unlock()
 
+	if b.len() == 0 {
+		return nil
+	}
+
+	if !c.breaker.allow(c.clock.Now()) {
+		c.breaker.drop(b.len())
+		return ErrBreakerOpen
+	}
+	failed, err := c.sendBatch(ctx, b)
+	c.breaker.record(ctx, c.clock.Now(), err)
+	if err == nil {
+		c.retry.succeeded()
+		return nil
+	}
+	if failed.len() > 0 {
+		c.retry.push(failed)
+		c.retry.failed(c.clock.Now(), err)
+	}
+	return fmt.Errorf("flush errors: %w", err)
+}
+
+// sendBatch sends a batch and returns its metrics that may be resent: those
+// of requests that failed with ErrRetryable or a cancelled context
+func (c *Client) sendBatch(ctx context.Context, b batch) (batch, error) {
+	if !c.legacyFlush.Load() {
+		payload := make(map[string]interface{}, 4)
+		if len(b.api) > 0 {
+			payload["api"] = b.api
+		}
+		if len(b.psp) > 0 {
+			payload["psp"] = b.psp
+		}
+		if len(b.game) > 0 {
+			payload["game"] = b.game
+		}
+		if len(b.ws) > 0 {
+			payload["ws"] = b.ws
+		}
+
+		var se *StatusError
+		switch err := c.post(ctx, "/collect/batch", payload); {
+		case err == nil:
+			return batch{}, nil
+		case !errors.As(err, &se) || (se.StatusCode != http.StatusNotFound && se.StatusCode != http.StatusMethodNotAllowed):
+			if resendable(ctx, err) {
+				return b, fmt.Errorf("batch: %w", err)
+			}
+			return batch{}, fmt.Errorf("batch: %w", err)
+		}
+
+		// Older collector without /collect/batch
+		c.legacyFlush.Store(true)
+	}
+
+	var failed batch
 	var errs []error
 
-	if len(api) > 0 {
-		if err := c.send(ctx, "/collect/api", api); err != nil {
+	if len(b.api) > 0 {
+		if err := c.send(ctx, "/collect/api", b.api); err != nil {
 			errs = append(errs, fmt.Errorf("api metrics: %w", err))
+			if resendable(ctx, err) {
+				failed.api = b.api
+			}
 		}
 	}
 
-	if len(psp) > 0 {
-		if err := c.send(ctx, "/collect/psp", psp); err != nil {
+	if len(b.psp) > 0 {
+		if err := c.send(ctx, "/collect/psp", b.psp); err != nil {
 			errs = append(errs, fmt.Errorf("psp metrics: %w", err))
+			if resendable(ctx, err) {
+				failed.psp = b.psp
+			}
 		}
 	}
 
-	if len(game) > 0 {
-		if err := c.send(ctx, "/collect/game", game); err != nil {
+	if len(b.game) > 0 {
+		if err := c.send(ctx, "/collect/game", b.game); err != nil {
 			errs = append(errs, fmt.Errorf("game metrics: %w", err))
+			if resendable(ctx, err) {
+				failed.game = b.game
+			}
 		}
 	}
 
-	if len(ws) > 0 {
-		if err := c.send(ctx, "/collect/ws", ws); err != nil {
+	if len(b.ws) > 0 {
+		if err := c.send(ctx, "/collect/ws", b.ws); err != nil {
 			errs = append(errs, fmt.Errorf("ws metrics: %w", err))
+			if resendable(ctx, err) {
+				failed.ws = b.ws
+			}
 		}
 	}
 
-	if len(errs) > 0 {
-		return fmt.Errorf("flush errors: %v", errs)
-	}
-
-	return nil
+	return failed, errors.Join(errs...)
 }
 
 func (c *Client) send(ctx context.Context, path string, data interface{}) error {
-	body, err := json.Marshal(map[string]interface{}{
+	return c.post(ctx, path, map[string]interface{}{
 		"metrics": data,
 	})
+}
+
+func (c *Client) post(ctx context.Context, path string, payload interface{}) error {
+	body, err := json.Marshal(payload)
 	if err != nil {
 		return err
 	}
 
-	req, err := http.NewRequestWithContext(ctx, "POST", c.endpoint+path, bytes.NewReader(body))
+	wire, compressed := c.compress(body)
+	err = c.postBody(ctx, path, wire, compressed)
+
+	// Collectors that do not inflate request bodies answer 415; send plain
+	// bodies to them from now on
+	var se *StatusError
+	if compressed && errors.As(err, &se) && se.StatusCode == http.StatusUnsupportedMediaType {
+		c.plainBodies.Store(true)
+		slog.Warn("pulse: collector does not accept gzip, sending uncompressed bodies")
+		err = c.postBody(ctx, path, body, false)
+	}
+	return err
+}
+
+// postBody sends an encoded body. Signatures cover the bytes on the wire,
+// compressed or not.
+func (c *Client) postBody(ctx context.Context, path string, body []byte, compressed bool) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", c.baseURL()+path, bytes.NewReader(body))
 	if err != nil {
 		return err
 	}
 
 	req.Header.Set("Content-Type", "application/json")
+	if compressed {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
 	req.Header.Set("X-Site-Id", c.siteID)
+	req.Header.Set(SDKHeader, sdkHeaderValue)
+	if c.serviceName != "" {
+		req.Header.Set("X-Pulse-Producer", c.serviceName)
+	}
+	if c.apiKey != "" {
+		req.Header.Set(APIKeyHeader, c.apiKey)
+	}
+	if len(c.signingKey) > 0 {
+		req.Header.Set(SignatureHeader, c.sign(c.clock.Now().Unix(), body))
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
 
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		return err
+		if ctx.Err() != nil {
+			return err
+		}
+		return fmt.Errorf("%w: %w", ErrRetryable, err)
 	}
 	defer resp.Body.Close()
 
+	if msg := resp.Header.Get(SDKDeprecationHeader); msg != "" {
+		c.deprecationOnce.Do(func() {
+			slog.Warn("pulse: SDK deprecated by collector", "sdk", sdkHeaderValue, "message", msg)
+		})
+	}
+
 	if resp.StatusCode >= 400 {
-		return fmt.Errorf("http error: %d", resp.StatusCode)
+		se := &StatusError{StatusCode: resp.StatusCode}
+		if s, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && s > 0 {
+			se.RetryAfter = time.Duration(s) * time.Second
+		}
+		return se
 	}
 
 	return nil
 }
 
-// Close shuts down the client gracefully
+// sign returns the signature header value: an HMAC-SHA256 of the timestamp
+// and body, so a captured request cannot be replayed later
+func (c *Client) sign(ts int64, body []byte) string {
+	t := strconv.FormatInt(ts, 10)
+	h := hmac.New(sha256.New, c.signingKey)
+	h.Write([]byte(t + "."))
+	h.Write(body)
+	return "t=" + t + ",v1=" + hex.EncodeToString(h.Sum(nil))
+}
+
+// StatusError is an HTTP error status returned by the collector
+type StatusError struct {
+	StatusCode int
+	RetryAfter time.Duration // From the Retry-After header, 0 without one
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("http error: %d", e.StatusCode)
+}
+
+// Is matches the error class of the status code
+func (e *StatusError) Is(target error) bool {
+	switch target {
+	case ErrValidation:
+		switch e.StatusCode {
+		case http.StatusBadRequest, http.StatusRequestEntityTooLarge,
+			http.StatusUnsupportedMediaType, http.StatusUnprocessableEntity:
+			return true
+		}
+	case ErrRetryable:
+		return e.StatusCode == http.StatusRequestTimeout || e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+	case ErrQueueFull:
+		return e.StatusCode == http.StatusTooManyRequests || e.StatusCode == http.StatusServiceUnavailable
+	}
+	return false
+}
+
+// Close shuts down the client gracefully: it flushes, makes a last attempt
+// at the retry buffer and, with SpoolDir, writes what is left to disk
 func (c *Client) Close() error {
 	close(c.done)
 	c.wg.Wait()
+	if c.spoolDir != "" {
+		return c.spool(c.spoolDir)
+	}
 	return nil
 }
 
@@ -297,26 +668,48 @@ func (c *Client) Close() error {
 // MIDDLEWARE HELPER
 // ============================================
 
-// HTTPMiddleware wraps http handlers to automatically track API metrics
+// HTTPMiddleware wraps http handlers to automatically track API metrics.
+// Errors passed to CaptureError with the request's context and panics of
+// the handler are reported on the request's metric; panics are recorded
+// with status 500 and passed on to the server.
 func (c *Client) HTTPMiddleware(serviceName string) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			start := time.Now()
+			start := c.clock.Now()
 
 			// Wrap response writer
 			wrapped := &responseWriter{ResponseWriter: w, status: http.StatusOK}
-
-			next.ServeHTTP(wrapped, r)
-
-			// Record metric
-			c.TrackAPI(APIMetric{
-				Time:        start,
-				ServiceName: serviceName,
-				Endpoint:    r.URL.Path,
-				Method:      r.Method,
-				DurationMS:  float64(time.Since(start).Milliseconds()),
-				StatusCode:  wrapped.status,
-			})
+			info := &requestInfo{client: c, service: serviceName, method: r.Method, endpoint: r.URL.Path}
+			if id := r.Header.Get("X-Request-Id"); id != "" {
+				info.requestID = &id
+			}
+
+			defer func() {
+				v := recover()
+				if v != nil && v != http.ErrAbortHandler {
+					info.attach(panicError(v))
+					wrapped.status = http.StatusInternalServerError
+				}
+
+				// Record metric
+				m := APIMetric{
+					Time:        start,
+					ServiceName: serviceName,
+					Endpoint:    r.URL.Path,
+					Method:      r.Method,
+					DurationMS:  float64(c.clock.Since(start).Milliseconds()),
+					StatusCode:  wrapped.status,
+					RequestID:   info.requestID,
+				}
+				info.apply(&m)
+				c.TrackAPI(m)
+
+				if v != nil {
+					panic(v)
+				}
+			}()
+
+			next.ServeHTTP(wrapped, r.WithContext(context.WithValue(r.Context(), requestKey{}, info)))
 		})
 	}
 }
@@ -0,0 +1,43 @@
+package pulse
+
+import (
+	"bytes"
+	"compress/gzip"
+	"sync"
+)
+
+// ============================================
+// REQUEST COMPRESSION
+// ============================================
+
+// defaultCompressThreshold is the body size from which requests are gzipped.
+// Smaller bodies gain little and cost CPU on every flush.
+const defaultCompressThreshold = 4 << 10
+
+var gzipWriters = sync.Pool{
+	New: func() any {
+		w, _ := gzip.NewWriterLevel(nil, gzip.BestSpeed)
+		return w
+	},
+}
+
+// compress gzips body if it reaches the client's threshold and the
+// collector accepts gzip. It reports whether the result is compressed.
+func (c *Client) compress(body []byte) ([]byte, bool) {
+	if c.compressThreshold < 0 || len(body) < c.compressThreshold || c.plainBodies.Load() {
+		return body, false
+	}
+
+	var buf bytes.Buffer
+	buf.Grow(len(body) / 4)
+	zw := gzipWriters.Get().(*gzip.Writer)
+	defer gzipWriters.Put(zw)
+	zw.Reset(&buf)
+	if _, err := zw.Write(body); err != nil {
+		return body, false
+	}
+	if err := zw.Close(); err != nil {
+		return body, false
+	}
+	return buf.Bytes(), true
+}
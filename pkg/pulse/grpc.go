@@ -0,0 +1,278 @@
+package pulse
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// ============================================
+// GRPC INTERCEPTORS
+// ============================================
+
+// grpcMethod is the method of gRPC API metrics, so they can be told apart
+// from HTTP calls of the same service
+const grpcMethod = "GRPC"
+
+// UnaryServerInterceptor tracks unary gRPC calls as API metrics, like
+// HTTPMiddleware does for HTTP: the endpoint is the full method, e.g.
+// "/wallet.Wallet/Debit", the status code the HTTP equivalent of the gRPC
+// code, which is in the metadata as grpc_code. Errors passed to
+// CaptureError with the call's context and panics of the handler are
+// reported on the call's metric; panics are recorded as Internal and
+// passed on.
+//
+//	grpc.NewServer(
+//		grpc.ChainUnaryInterceptor(pc.UnaryServerInterceptor("wallet")),
+//		grpc.ChainStreamInterceptor(pc.StreamServerInterceptor("wallet")),
+//	)
+func (c *Client) UnaryServerInterceptor(serviceName string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+		call := c.startServerCall(ctx, serviceName, info.FullMethod)
+		defer func() {
+			call.finish(recover(), err, "unary")
+		}()
+		return handler(call.ctx, req)
+	}
+}
+
+// StreamServerInterceptor tracks streaming gRPC calls like
+// UnaryServerInterceptor; the duration is the life of the stream
+func (c *Client) StreamServerInterceptor(serviceName string) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+		call := c.startServerCall(ss.Context(), serviceName, info.FullMethod)
+		defer func() {
+			call.finish(recover(), err, streamType(info.IsClientStream, info.IsServerStream))
+		}()
+		return handler(srv, &serverStream{ServerStream: ss, ctx: call.ctx})
+	}
+}
+
+// serverCall is a gRPC call being served
+type serverCall struct {
+	client *Client
+	info   *requestInfo
+	ctx    context.Context
+	start  time.Time
+}
+
+func (c *Client) startServerCall(ctx context.Context, serviceName, fullMethod string) *serverCall {
+	info := &requestInfo{client: c, service: serviceName, method: grpcMethod, endpoint: fullMethod}
+	if id := incomingRequestID(ctx); id != "" {
+		info.requestID = &id
+	}
+	return &serverCall{
+		client: c,
+		info:   info,
+		ctx:    context.WithValue(ctx, requestKey{}, info),
+		start:  c.clock.Now(),
+	}
+}
+
+// finish records the call and panics again with v, if the handler panicked
+func (call *serverCall) finish(v interface{}, err error, callType string) {
+	code := status.Code(err)
+	if v != nil {
+		call.info.attach(panicError(v))
+		code = codes.Internal
+	}
+
+	c := call.client
+	m := APIMetric{
+		Time:        call.start,
+		ServiceName: call.info.service,
+		Endpoint:    call.info.endpoint,
+		Method:      grpcMethod,
+		DurationMS:  float64(c.clock.Since(call.start).Milliseconds()),
+		StatusCode:  httpStatus(code),
+		RequestID:   call.info.requestID,
+		Metadata: map[string]interface{}{
+			"grpc_code": code.String(),
+			"grpc_type": callType,
+		},
+	}
+	call.info.apply(&m)
+	// Errors returned by the handler are reported unless one was captured
+	if m.ErrorType == nil && err != nil {
+		setCallError(&m, err)
+	}
+	c.TrackAPI(m)
+
+	if v != nil {
+		panic(v)
+	}
+}
+
+// serverStream carries the call's context to the handler
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context { return s.ctx }
+
+// UnaryClientInterceptor tracks outbound unary gRPC calls as API metrics
+// of serviceName, like WrapTransport does for HTTP: the endpoint is the
+// full method and the metadata carries direction "outbound", the target of
+// the connection and the gRPC code. Calls made while serving a request
+// share its request ID.
+//
+//	grpc.NewClient(addr, grpc.WithChainUnaryInterceptor(pc.UnaryClientInterceptor("cashier")))
+func (c *Client) UnaryClientInterceptor(serviceName string) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		start := c.clock.Now()
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		c.trackClientCall(ctx, serviceName, method, cc.Target(), "unary", start, err)
+		return err
+	}
+}
+
+// StreamClientInterceptor tracks outbound streaming gRPC calls like
+// UnaryClientInterceptor. A stream is recorded when it ends: when a
+// receive fails, including with io.EOF, or, for streams with a single
+// response, once it has been received.
+func (c *Client) StreamClientInterceptor(serviceName string) grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		start := c.clock.Now()
+		callType := streamType(desc.ClientStreams, desc.ServerStreams)
+		cs, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			c.trackClientCall(ctx, serviceName, method, cc.Target(), callType, start, err)
+			return nil, err
+		}
+		return &clientStream{
+			ClientStream: cs,
+			single:       !desc.ServerStreams,
+			finish: func(err error) {
+				c.trackClientCall(ctx, serviceName, method, cc.Target(), callType, start, err)
+			},
+		}, nil
+	}
+}
+
+// clientStream records the call once, when it ends
+type clientStream struct {
+	grpc.ClientStream
+	single bool
+	once   sync.Once
+	finish func(err error)
+}
+
+func (s *clientStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	if err != nil || s.single {
+		if errors.Is(err, io.EOF) {
+			err = nil
+		}
+		s.once.Do(func() { s.finish(err) })
+	}
+	return err
+}
+
+func (c *Client) trackClientCall(ctx context.Context, serviceName, method, target, callType string, start time.Time, err error) {
+	code := status.Code(err)
+	m := APIMetric{
+		Time:        start,
+		ServiceName: serviceName,
+		Endpoint:    method,
+		Method:      grpcMethod,
+		DurationMS:  float64(c.clock.Since(start).Milliseconds()),
+		StatusCode:  httpStatus(code),
+		Metadata: map[string]interface{}{
+			"direction": "outbound",
+			"target":    target,
+			"grpc_code": code.String(),
+			"grpc_type": callType,
+		},
+	}
+	if info, ok := ctx.Value(requestKey{}).(*requestInfo); ok && info.requestID != nil {
+		m.RequestID = info.requestID
+	} else if md, ok := metadata.FromOutgoingContext(ctx); ok {
+		if ids := md.Get("x-request-id"); len(ids) > 0 && ids[0] != "" {
+			m.RequestID = &ids[0]
+		}
+	}
+	if err != nil {
+		setCallError(&m, err)
+	}
+	c.TrackAPI(m)
+}
+
+// incomingRequestID is the x-request-id metadata of a call being served
+func incomingRequestID(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	if ids := md.Get("x-request-id"); len(ids) > 0 {
+		return ids[0]
+	}
+	return ""
+}
+
+// setCallError reports a call's error: its gRPC status message, typed by
+// the code, or the Go type of errors without a status
+func setCallError(m *APIMetric, err error) {
+	message := err.Error()
+	errType := errorType(err)
+	if s, ok := status.FromError(err); ok {
+		message = s.Message()
+		errType = "grpc." + s.Code().String()
+	}
+	if len(message) > maxErrorMessage {
+		message = message[:maxErrorMessage]
+	}
+	m.ErrorType = StringPtr(errType)
+	m.ErrorMessage = StringPtr(message)
+}
+
+func streamType(clientStreams, serverStreams bool) string {
+	switch {
+	case clientStreams && serverStreams:
+		return "bidi_stream"
+	case clientStreams:
+		return "client_stream"
+	case serverStreams:
+		return "server_stream"
+	}
+	return "unary"
+}
+
+// httpStatus maps a gRPC code to the HTTP status of the same meaning, so
+// gRPC calls count towards error rates, SLOs and alerts like HTTP calls
+func httpStatus(code codes.Code) int {
+	switch code {
+	case codes.OK:
+		return http.StatusOK
+	case codes.Canceled:
+		return 499 // Client closed request
+	case codes.InvalidArgument, codes.FailedPrecondition, codes.OutOfRange:
+		return http.StatusBadRequest
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.AlreadyExists, codes.Aborted:
+		return http.StatusConflict
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.Unimplemented:
+		return http.StatusNotImplemented
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	}
+	// Unknown, Internal, DataLoss
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,452 @@
+package pulse
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/codes"
+)
+
+// ============================================
+// OPENTELEMETRY BRIDGE
+// ============================================
+
+// errExporterShutdown is returned by exporters used after Shutdown
+var errExporterShutdown = errors.New("pulse: exporter is shut down")
+
+// otelDurationMetrics are the request duration histograms of the semantic
+// conventions the metric exporter records, and whether they are outbound
+var otelDurationMetrics = map[string]bool{
+	"http.server.request.duration": false,
+	"http.server.duration":         false, // Before semantic conventions 1.20
+	"rpc.server.duration":          false,
+	"http.client.request.duration": true,
+	"http.client.duration":         true,
+	"rpc.client.duration":          true,
+	"db.client.operation.duration": true,
+}
+
+// SpanExporter is an OpenTelemetry span exporter recording the server and
+// client spans of a service as API metrics. See NewSpanExporter.
+type SpanExporter struct {
+	client   *Client
+	shutdown atomic.Bool
+}
+
+// NewSpanExporter returns a span exporter recording spans through client,
+// or the default client if nil, so services instrumented with OpenTelemetry
+// report to product-pulse without instrumenting twice. Server spans become
+// API metrics of the resource's service.name, client spans outbound ones;
+// HTTP, gRPC, SQL and Redis spans are named as HTTPMiddleware, the gRPC
+// interceptors, WrapConnector and NewRedisHook name them, from their
+// semantic convention attributes. The trace ID is the request ID. Exception
+// events are reported on their span's metric like CaptureError, or, on
+// other spans, as error metrics of their own.
+//
+//	tp := sdktrace.NewTracerProvider(sdktrace.WithBatcher(pulse.NewSpanExporter(pc)))
+func NewSpanExporter(client *Client) *SpanExporter {
+	return &SpanExporter{client: client}
+}
+
+// ExportSpans implements sdktrace.SpanExporter
+func (e *SpanExporter) ExportSpans(ctx context.Context, spans []sdktrace.ReadOnlySpan) error {
+	if e.shutdown.Load() {
+		return errExporterShutdown
+	}
+	c := e.clientOrDefault()
+	if c == nil {
+		return nil
+	}
+	for _, span := range spans {
+		c.trackSpan(span)
+	}
+	return nil
+}
+
+// Shutdown implements sdktrace.SpanExporter; it flushes the client, which
+// stays open
+func (e *SpanExporter) Shutdown(ctx context.Context) error {
+	if e.shutdown.Swap(true) {
+		return nil
+	}
+	if c := e.clientOrDefault(); c != nil {
+		return c.Flush(ctx)
+	}
+	return nil
+}
+
+func (e *SpanExporter) clientOrDefault() *Client {
+	if e.client != nil {
+		return e.client
+	}
+	return defaultClient.Load()
+}
+
+// trackSpan records a server or client span as an API metric and the
+// exceptions of other spans as error metrics
+func (c *Client) trackSpan(span sdktrace.ReadOnlySpan) {
+	kind := span.SpanKind()
+	request := kind == trace.SpanKindServer || kind == trace.SpanKindClient
+	serviceName := c.otelServiceName(span.Resource())
+	traceID := span.SpanContext().TraceID().String()
+	spanID := span.SpanContext().SpanID().String()
+
+	var exceptions []*capturedError
+	for _, event := range span.Events() {
+		if event.Name != "exception" {
+			continue
+		}
+		attrs := attribute.NewSet(event.Attributes...)
+		e := &capturedError{
+			errorType: otelString(&attrs, "exception.type"),
+			message:   otelString(&attrs, "exception.message"),
+			stack:     otelString(&attrs, "exception.stacktrace"),
+		}
+		if e.errorType == "" {
+			e.errorType = "exception"
+		}
+		if !request {
+			m := APIMetric{
+				Time:        event.Time,
+				ServiceName: serviceName,
+				Endpoint:    span.Name(),
+				RequestID:   StringPtr(traceID),
+			}
+			e.apply(&m, 0)
+			m.Metadata["trace_id"], m.Metadata["span_id"] = traceID, spanID
+			c.TrackAPI(m)
+			continue
+		}
+		exceptions = append(exceptions, e)
+	}
+
+	if !request {
+		return
+	}
+	attrs := attribute.NewSet(span.Attributes()...)
+	m := otelAPIMetric(&attrs, span.Name(), kind == trace.SpanKindClient)
+	m.Time = span.StartTime()
+	m.ServiceName = serviceName
+	m.DurationMS = float64(span.EndTime().Sub(span.StartTime()).Milliseconds())
+	m.RequestID = StringPtr(traceID)
+	m.Metadata["trace_id"], m.Metadata["span_id"] = traceID, spanID
+
+	if status := span.Status(); status.Code == otelcodes.Error {
+		if m.StatusCode < http.StatusBadRequest {
+			m.StatusCode = http.StatusInternalServerError
+		}
+		if status.Description != "" {
+			message := status.Description
+			if len(message) > maxErrorMessage {
+				message = message[:maxErrorMessage]
+			}
+			m.ErrorMessage = StringPtr(message)
+		}
+	}
+	if len(exceptions) > 0 {
+		exceptions[0].apply(&m, len(exceptions)-1)
+	}
+	c.TrackAPI(m)
+}
+
+// MetricExporter is an OpenTelemetry metric exporter recording the request
+// duration histograms of a service as API metrics. See NewMetricExporter.
+type MetricExporter struct {
+	client   *Client
+	shutdown atomic.Bool
+}
+
+// NewMetricExporter returns a metric exporter recording the HTTP, gRPC and
+// database request duration histograms of the semantic conventions, e.g.
+// http.server.request.duration, through client, or the default client if
+// nil. It is meant for services with OpenTelemetry metrics but no tracing;
+// with NewSpanExporter requests would be recorded twice. Each request
+// counted by a histogram becomes an API metric named from the point's
+// attributes like spans are, so request rates, error rates and SLOs are
+// exact; durations are spread evenly over their bucket, narrowed to the
+// point's min and max, and times over the export interval. Histograms
+// are asked for with delta temporality.
+//
+//	reader := sdkmetric.NewPeriodicReader(pulse.NewMetricExporter(pc))
+//	mp := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+func NewMetricExporter(client *Client) *MetricExporter {
+	return &MetricExporter{client: client}
+}
+
+// Temporality implements sdkmetric.Exporter
+func (e *MetricExporter) Temporality(sdkmetric.InstrumentKind) metricdata.Temporality {
+	return metricdata.DeltaTemporality
+}
+
+// Aggregation implements sdkmetric.Exporter
+func (e *MetricExporter) Aggregation(kind sdkmetric.InstrumentKind) sdkmetric.Aggregation {
+	return sdkmetric.DefaultAggregationSelector(kind)
+}
+
+// Export implements sdkmetric.Exporter
+func (e *MetricExporter) Export(ctx context.Context, rm *metricdata.ResourceMetrics) error {
+	if e.shutdown.Load() {
+		return errExporterShutdown
+	}
+	c := e.clientOrDefault()
+	if c == nil {
+		return nil
+	}
+	serviceName := c.otelServiceName(rm.Resource)
+	for _, sm := range rm.ScopeMetrics {
+		for _, metric := range sm.Metrics {
+			outbound, ok := otelDurationMetrics[metric.Name]
+			if !ok {
+				continue
+			}
+			scale := otelUnitScale(metric.Unit)
+			switch data := metric.Data.(type) {
+			case metricdata.Histogram[float64]:
+				for _, p := range data.DataPoints {
+					trackHistogram(c, serviceName, metric.Name, outbound, scale, p)
+				}
+			case metricdata.Histogram[int64]:
+				for _, p := range data.DataPoints {
+					trackHistogram(c, serviceName, metric.Name, outbound, scale, p)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// ForceFlush implements sdkmetric.Exporter
+func (e *MetricExporter) ForceFlush(ctx context.Context) error {
+	if c := e.clientOrDefault(); c != nil {
+		return c.Flush(ctx)
+	}
+	return nil
+}
+
+// Shutdown implements sdkmetric.Exporter; it flushes the client, which
+// stays open
+func (e *MetricExporter) Shutdown(ctx context.Context) error {
+	if e.shutdown.Swap(true) {
+		return nil
+	}
+	return e.ForceFlush(ctx)
+}
+
+func (e *MetricExporter) clientOrDefault() *Client {
+	if e.client != nil {
+		return e.client
+	}
+	return defaultClient.Load()
+}
+
+// trackHistogram records every request counted by a histogram point, with
+// durations in the point's unit times scale milliseconds
+func trackHistogram[N int64 | float64](c *Client, serviceName, name string, outbound bool, scale float64, p metricdata.HistogramDataPoint[N]) {
+	if p.Count == 0 {
+		return
+	}
+	fallback := ""
+	if outbound {
+		fallback = otelString(&p.Attributes, "server.address", "net.peer.name")
+	}
+	if fallback == "" {
+		fallback = "unknown"
+	}
+	metric := otelAPIMetric(&p.Attributes, fallback, outbound)
+	metric.ServiceName = serviceName
+	metric.Metadata["otel_metric"] = name
+
+	low, high := 0.0, 0.0
+	if v, ok := p.Min.Value(); ok {
+		low = float64(v)
+	}
+	if v, ok := p.Max.Value(); ok {
+		high = float64(v)
+	}
+
+	// Without buckets, or for a single request, the mean is exact
+	mean := float64(p.Sum) / float64(p.Count)
+	interval := p.Time.Sub(p.StartTime)
+	var i uint64
+	for bucket, count := range p.BucketCounts {
+		if count == 0 {
+			continue
+		}
+		lower, upper := mean, mean
+		if len(p.Bounds) > 0 && p.Count > 1 {
+			lower, upper = bucketRange(p.Bounds, bucket, low, high)
+		}
+		for n := uint64(0); n < count; n++ {
+			duration := lower + (float64(n)+0.5)/float64(count)*(upper-lower)
+			m := metric // The metrics of a point share their metadata
+			m.Time = p.StartTime.Add(time.Duration((float64(i) + 0.5) / float64(p.Count) * float64(interval)))
+			m.DurationMS = duration * scale
+			c.TrackAPI(m)
+			i++
+		}
+	}
+}
+
+// bucketRange is the range of bucket of an explicit bucket histogram,
+// narrowed to the point's min and max if recorded (high > 0)
+func bucketRange(bounds []float64, bucket int, low, high float64) (lower, upper float64) {
+	switch {
+	case bucket == 0:
+		lower, upper = min(low, bounds[0]), bounds[0]
+	case bucket >= len(bounds):
+		lower, upper = bounds[len(bounds)-1], max(high, bounds[len(bounds)-1])
+	default:
+		lower, upper = bounds[bucket-1], bounds[bucket]
+	}
+	if high > 0 {
+		lower, upper = max(lower, low), min(upper, high)
+	}
+	return lower, upper
+}
+
+// otelUnitScale converts durations of unit to milliseconds
+func otelUnitScale(unit string) float64 {
+	switch unit {
+	case "s":
+		return 1000
+	case "us", "µs":
+		return 0.001
+	case "ns":
+		return 0.000001
+	}
+	return 1 // ms
+}
+
+// otelServiceName is the service.name of res, or the client's service for
+// resources without one
+func (c *Client) otelServiceName(res *resource.Resource) string {
+	if res != nil {
+		if v, ok := res.Set().Value("service.name"); ok {
+			if name := v.Emit(); name != "" && !strings.HasPrefix(name, "unknown_service") {
+				return name
+			}
+		}
+	}
+	return c.serviceName
+}
+
+// otelAPIMetric maps the semantic convention attributes of a request span
+// or histogram point to an API metric: gRPC calls are named by full method,
+// database calls like WrapConnector and NewRedisHook name them, HTTP calls
+// by route. Without one the endpoint is fallback.
+func otelAPIMetric(attrs *attribute.Set, fallback string, outbound bool) APIMetric {
+	m := APIMetric{
+		StatusCode: http.StatusOK,
+		Metadata:   map[string]interface{}{},
+	}
+	target := otelString(attrs, "server.address", "net.peer.name")
+
+	switch {
+	case otelString(attrs, "rpc.system") != "":
+		m.Method = strings.ToUpper(otelString(attrs, "rpc.system"))
+		if m.Method == "GRPC" {
+			m.Method = grpcMethod
+		}
+		if service, method := otelString(attrs, "rpc.service"), otelString(attrs, "rpc.method"); service != "" && method != "" {
+			m.Endpoint = "/" + service + "/" + method
+		}
+		if code, ok := otelInt(attrs, "rpc.grpc.status_code"); ok {
+			m.StatusCode = httpStatus(codes.Code(code))
+			m.Metadata["grpc_code"] = codes.Code(code).String()
+		}
+
+	case otelString(attrs, "db.system") == "redis":
+		m.Method = redisMethod
+		if target == "" {
+			target = defaultRedisCluster
+		}
+		command := otelString(attrs, "db.operation.name", "db.operation")
+		if command == "" {
+			command, _, _ = strings.Cut(otelString(attrs, "db.query.text", "db.statement"), " ")
+		}
+		if command != "" {
+			m.Endpoint = target + " " + strings.ToUpper(command)
+		}
+
+	case otelString(attrs, "db.system") != "":
+		m.Method = sqlMethod
+		if db := otelString(attrs, "db.namespace", "db.name"); db != "" {
+			target = db
+		}
+		var name string
+		if query := otelString(attrs, "db.query.text", "db.statement"); query != "" {
+			name, m.Metadata["statement"] = parseStatement(query)
+		} else {
+			name = strings.TrimSpace(strings.ToUpper(otelString(attrs, "db.operation.name", "db.operation")) + " " + otelString(attrs, "db.collection.name", "db.sql.table"))
+		}
+		if name != "" {
+			m.Endpoint = strings.TrimSpace(target + " " + name)
+		}
+
+	default:
+		m.Method = otelString(attrs, "http.request.method", "http.method")
+		m.Endpoint = otelString(attrs, "http.route")
+		if code, ok := otelInt(attrs, "http.response.status_code", "http.status_code"); ok {
+			m.StatusCode = int(code)
+		}
+	}
+
+	if m.Endpoint == "" {
+		m.Endpoint = fallback
+	}
+	if outbound {
+		m.Metadata["direction"] = "outbound"
+		m.Metadata["target"] = target
+	}
+	if errType := otelString(attrs, "error.type"); errType != "" {
+		m.ErrorType = StringPtr(errType)
+		if m.StatusCode < http.StatusBadRequest {
+			m.StatusCode = http.StatusInternalServerError
+		}
+	}
+	return m
+}
+
+// otelString is the first of keys set in attrs, as a string
+func otelString(attrs *attribute.Set, keys ...string) string {
+	for _, key := range keys {
+		if v, ok := attrs.Value(attribute.Key(key)); ok {
+			if s := v.Emit(); s != "" {
+				return s
+			}
+		}
+	}
+	return ""
+}
+
+// otelInt is the first of keys set in attrs as an integer, or a string
+// holding one
+func otelInt(attrs *attribute.Set, keys ...string) (int64, bool) {
+	for _, key := range keys {
+		v, ok := attrs.Value(attribute.Key(key))
+		if !ok {
+			continue
+		}
+		switch v.Type() {
+		case attribute.INT64:
+			return v.AsInt64(), true
+		case attribute.STRING:
+			if n, err := strconv.ParseInt(v.AsString(), 10, 64); err == nil {
+				return n, true
+			}
+		}
+	}
+	return 0, false
+}
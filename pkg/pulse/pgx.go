@@ -0,0 +1,72 @@
+package pulse
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// QueryTracer is a pgx.QueryTracer recording every query as an API metric
+// of its service, with latency, rows and errors. See NewQueryTracer.
+type QueryTracer struct {
+	client  *Client
+	service string
+	target  string
+}
+
+// NewQueryTracer returns a tracer recording the queries of a pgx connection
+// or pool through client, or the default client if nil, as API metrics of
+// serviceName. The endpoint is targetName, or the connection's database if
+// empty, and the statement name, e.g. "ledger SELECT accounts"; see
+// WrapConnector for naming. The metadata carries direction "outbound", the
+// target, the normalized statement, the rows returned or affected and, for
+// Postgres errors, the SQLSTATE.
+//
+//	config, _ := pgxpool.ParseConfig(dsn)
+//	config.ConnConfig.Tracer = pulse.NewQueryTracer(pc, "wallet", "ledger")
+func NewQueryTracer(client *Client, serviceName, targetName string) *QueryTracer {
+	return &QueryTracer{client: client, service: serviceName, target: targetName}
+}
+
+type queryTraceKey struct{}
+
+type queryTrace struct {
+	start time.Time
+	sql   string
+}
+
+// TraceQueryStart implements pgx.QueryTracer
+func (t *QueryTracer) TraceQueryStart(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	c := t.clientOrDefault()
+	if c == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, queryTraceKey{}, &queryTrace{start: c.clock.Now(), sql: data.SQL})
+}
+
+// TraceQueryEnd implements pgx.QueryTracer
+func (t *QueryTracer) TraceQueryEnd(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryEndData) {
+	trace, ok := ctx.Value(queryTraceKey{}).(*queryTrace)
+	c := t.clientOrDefault()
+	if !ok || c == nil {
+		return
+	}
+
+	target := t.target
+	if target == "" && conn != nil {
+		target = conn.Config().Database
+	}
+	rows := int64(-1)
+	if data.Err == nil {
+		rows = data.CommandTag.RowsAffected()
+	}
+	c.trackQuery(ctx, t.service, target, trace.sql, trace.start, rows, data.Err)
+}
+
+func (t *QueryTracer) clientOrDefault() *Client {
+	if t.client != nil {
+		return t.client
+	}
+	return defaultClient.Load()
+}
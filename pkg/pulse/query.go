@@ -0,0 +1,276 @@
+package pulse
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ============================================
+// DATABASE QUERY INSTRUMENTATION
+// ============================================
+
+// sqlMethod is the method of database query metrics
+const sqlMethod = "SQL"
+
+// maxStatementLength bounds the normalized statement kept in metadata
+const maxStatementLength = 500
+
+// sqlVerbs are the statements whose main table names them
+var sqlVerbs = map[string]string{
+	"SELECT": "FROM",
+	"DELETE": "FROM",
+	"INSERT": "INTO",
+	"MERGE":  "INTO",
+	"UPDATE": "",
+	"COPY":   "",
+}
+
+// trackQuery records a database query of serviceName against target as an
+// API metric named after its statement; rows is -1 if unknown
+func (c *Client) trackQuery(ctx context.Context, serviceName, target, query string, start time.Time, rows int64, err error) {
+	name, statement := parseStatement(query)
+	m := APIMetric{
+		Time:        start,
+		ServiceName: serviceName,
+		Endpoint:    strings.TrimSpace(target + " " + name),
+		Method:      sqlMethod,
+		DurationMS:  float64(c.clock.Since(start).Milliseconds()),
+		StatusCode:  http.StatusOK,
+		Metadata: map[string]interface{}{
+			"direction": "outbound",
+			"target":    target,
+			"statement": statement,
+		},
+	}
+	if rows >= 0 {
+		m.Metadata["rows"] = rows
+	}
+	if info, ok := ctx.Value(requestKey{}).(*requestInfo); ok && info.requestID != nil {
+		m.RequestID = info.requestID
+	}
+
+	if err != nil {
+		switch {
+		case errors.Is(err, context.Canceled):
+			m.StatusCode = 499 // Client closed request
+		case errors.Is(err, context.DeadlineExceeded):
+			m.StatusCode = http.StatusGatewayTimeout
+		default:
+			m.StatusCode = http.StatusInternalServerError
+		}
+		message := err.Error()
+		if len(message) > maxErrorMessage {
+			message = message[:maxErrorMessage]
+		}
+		m.ErrorType = StringPtr(errorType(err))
+		m.ErrorMessage = StringPtr(message)
+		var coded interface{ SQLState() string }
+		if errors.As(err, &coded) {
+			m.Metadata["sqlstate"] = coded.SQLState()
+		}
+	}
+
+	c.TrackAPI(m)
+}
+
+// parseStatement names a query and normalizes it: comments left out,
+// literals replaced by ?, whitespace collapsed. The name is that of an sqlc
+// "-- name: GetUser :one" comment, or else the statement and its main
+// table, e.g. "SELECT users" or "INSERT payments", so the same query with
+// other literals is one endpoint.
+func parseStatement(query string) (name, normalized string) {
+	var (
+		out       strings.Builder
+		words     []string // Upper-cased words and "(" outside parentheses
+		depth     int
+		sqlcName  string
+		pendingWS bool
+	)
+	emit := func(s string) {
+		if pendingWS && out.Len() > 0 {
+			out.WriteByte(' ')
+		}
+		pendingWS = false
+		out.WriteString(s)
+	}
+
+	for i := 0; i < len(query); {
+		ch := query[i]
+		switch {
+		case ch == '-' && strings.HasPrefix(query[i:], "--"):
+			end := strings.IndexByte(query[i:], '\n')
+			if end < 0 {
+				end = len(query) - i
+			}
+			comment := strings.TrimSpace(query[i+2 : i+end])
+			if n, ok := strings.CutPrefix(comment, "name:"); ok && sqlcName == "" {
+				if fields := strings.Fields(n); len(fields) > 0 {
+					sqlcName = fields[0]
+				}
+			}
+			i += end
+			pendingWS = true
+
+		case ch == '/' && strings.HasPrefix(query[i:], "/*"):
+			end := strings.Index(query[i+2:], "*/")
+			if end < 0 {
+				i = len(query)
+			} else {
+				i += end + 4
+			}
+			pendingWS = true
+
+		case ch == '\'':
+			// String literal, '' escapes a quote
+			i++
+			for i < len(query) {
+				if query[i] == '\'' {
+					if i+1 < len(query) && query[i+1] == '\'' {
+						i += 2
+						continue
+					}
+					break
+				}
+				i++
+			}
+			i++
+			emit("?")
+
+		case ch == '$' && i+1 < len(query) && query[i+1] >= '0' && query[i+1] <= '9':
+			// Placeholder
+			j := i + 1
+			for j < len(query) && query[j] >= '0' && query[j] <= '9' {
+				j++
+			}
+			emit(query[i:j])
+			i = j
+
+		case ch == '$':
+			// Dollar-quoted string, $tag$...$tag$
+			end := strings.IndexByte(query[i+1:], '$')
+			if end < 0 {
+				emit("$")
+				i++
+				break
+			}
+			tag := query[i : i+end+2]
+			closing := strings.Index(query[i+len(tag):], tag)
+			if closing < 0 {
+				i = len(query)
+			} else {
+				i += len(tag) + closing + len(tag)
+			}
+			emit("?")
+
+		case ch >= '0' && ch <= '9':
+			j := i
+			for j < len(query) && (query[j] >= '0' && query[j] <= '9' || query[j] == '.') {
+				j++
+			}
+			emit("?")
+			i = j
+
+		case ch == '"' || ch == '_' || ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z':
+			// Identifier or keyword, possibly quoted and schema-qualified
+			j := i
+			for j < len(query) {
+				c := query[j]
+				if c == '"' {
+					end := strings.IndexByte(query[j+1:], '"')
+					if end < 0 {
+						j = len(query)
+						break
+					}
+					j += end + 2
+					continue
+				}
+				if c == '_' || c == '.' || c == '$' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' {
+					j++
+					continue
+				}
+				break
+			}
+			word := query[i:j]
+			emit(word)
+			if depth == 0 {
+				words = append(words, strings.ToUpper(strings.ReplaceAll(word, `"`, "")))
+			}
+			i = j
+
+		case ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r':
+			pendingWS = true
+			i++
+
+		default:
+			switch ch {
+			case '(':
+				if depth == 0 {
+					words = append(words, "(")
+				}
+				depth++
+			case ')':
+				if depth > 0 {
+					depth--
+				}
+			}
+			emit(string(ch))
+			i++
+		}
+	}
+
+	normalized = out.String()
+	if len(normalized) > maxStatementLength {
+		normalized = normalized[:maxStatementLength]
+	}
+	if sqlcName != "" {
+		return sqlcName, normalized
+	}
+	return statementName(words), normalized
+}
+
+// statementName is the statement of a query's top-level words and its main
+// table; statements without table are named by their verb
+func statementName(words []string) string {
+	if len(words) == 0 {
+		return "QUERY"
+	}
+
+	// WITH ... AS (...) SELECT: the main statement follows the CTEs
+	verbAt := 0
+	if words[0] == "WITH" {
+		for i, w := range words {
+			if _, ok := sqlVerbs[w]; ok {
+				verbAt = i
+				break
+			}
+		}
+	}
+	verb := words[verbAt]
+	marker, ok := sqlVerbs[verb]
+	if !ok {
+		return words[0]
+	}
+
+	rest := words[verbAt+1:]
+	if marker != "" {
+		i := 0
+		for i < len(rest) && rest[i] != marker {
+			i++
+		}
+		if i == len(rest) {
+			return verb
+		}
+		rest = rest[i+1:]
+	}
+	if len(rest) > 0 && rest[0] == "ONLY" {
+		rest = rest[1:]
+	}
+	// Subqueries have no table of their own
+	if len(rest) == 0 || rest[0] == "(" {
+		return verb
+	}
+	return verb + " " + strings.ToLower(rest[0])
+}
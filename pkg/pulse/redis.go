@@ -0,0 +1,158 @@
+package pulse
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ============================================
+// REDIS COMMAND INSTRUMENTATION
+// ============================================
+
+// redisMethod is the method of Redis command metrics
+const redisMethod = "REDIS"
+
+// defaultRedisCluster names the cluster of hooks created without one
+const defaultRedisCluster = "redis"
+
+// RedisHook is a go-redis hook recording every command and pipeline as an
+// API metric of its service, with latency and errors. See NewRedisHook.
+type RedisHook struct {
+	client  *Client
+	service string
+	cluster string
+}
+
+// NewRedisHook returns a hook recording the commands of a go-redis client,
+// cluster client or ring through client, or the default client if nil, as
+// API metrics of serviceName. The endpoint is clusterName ("redis" if
+// empty) and the command, e.g. "sessions GET"; pipelines are one metric,
+// "sessions PIPELINE" or "sessions MULTI" for transactions, with the number
+// of commands in the metadata. redis.Nil is a cache miss, not an error: the
+// command succeeds with "miss" in the metadata.
+//
+//	rdb := redis.NewClient(opts)
+//	rdb.AddHook(pulse.NewRedisHook(pc, "wallet", "sessions"))
+func NewRedisHook(client *Client, serviceName, clusterName string) *RedisHook {
+	if clusterName == "" {
+		clusterName = defaultRedisCluster
+	}
+	return &RedisHook{client: client, service: serviceName, cluster: clusterName}
+}
+
+// DialHook implements redis.Hook; failed dials fail the command, which is
+// recorded
+func (h *RedisHook) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+// ProcessHook implements redis.Hook
+func (h *RedisHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		c := h.clientOrDefault()
+		if c == nil {
+			return next(ctx, cmd)
+		}
+		start := c.clock.Now()
+		err := next(ctx, cmd)
+		c.trackRedis(ctx, h.service, h.cluster, strings.ToUpper(cmd.FullName()), start, 1, err)
+		return err
+	}
+}
+
+// ProcessPipelineHook implements redis.Hook
+func (h *RedisHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		c := h.clientOrDefault()
+		if c == nil {
+			return next(ctx, cmds)
+		}
+		start := c.clock.Now()
+		err := next(ctx, cmds)
+
+		// Transactions are wrapped in MULTI and EXEC
+		name, n := "PIPELINE", len(cmds)
+		if n >= 2 && cmds[0].Name() == "multi" && cmds[n-1].Name() == "exec" {
+			name, n = "MULTI", n-2
+		}
+		// The pipeline fails with its first failed command, which may only
+		// be a miss
+		if err == nil || errors.Is(err, redis.Nil) {
+			err = nil
+			for _, cmd := range cmds {
+				if cmdErr := cmd.Err(); cmdErr != nil && !errors.Is(cmdErr, redis.Nil) {
+					err = cmdErr
+					break
+				}
+			}
+		}
+		c.trackRedis(ctx, h.service, h.cluster, name, start, n, err)
+		return err
+	}
+}
+
+func (h *RedisHook) clientOrDefault() *Client {
+	if h.client != nil {
+		return h.client
+	}
+	return defaultClient.Load()
+}
+
+// trackRedis records a command, or a pipeline of commands, of serviceName
+// against cluster as an API metric
+func (c *Client) trackRedis(ctx context.Context, serviceName, cluster, command string, start time.Time, commands int, err error) {
+	m := APIMetric{
+		Time:        start,
+		ServiceName: serviceName,
+		Endpoint:    cluster + " " + command,
+		Method:      redisMethod,
+		DurationMS:  float64(c.clock.Since(start).Milliseconds()),
+		StatusCode:  http.StatusOK,
+		Metadata: map[string]interface{}{
+			"direction": "outbound",
+			"target":    cluster,
+		},
+	}
+	if commands != 1 {
+		m.Metadata["commands"] = commands
+	}
+	if info, ok := ctx.Value(requestKey{}).(*requestInfo); ok && info.requestID != nil {
+		m.RequestID = info.requestID
+	}
+
+	if errors.Is(err, redis.Nil) {
+		m.Metadata["miss"] = true
+		err = nil
+	}
+	if err != nil {
+		var netErr net.Error
+		switch {
+		case errors.Is(err, context.Canceled):
+			m.StatusCode = 499 // Client closed request
+		case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+			m.StatusCode = http.StatusGatewayTimeout
+		default:
+			m.StatusCode = http.StatusInternalServerError
+		}
+		message := err.Error()
+		if len(message) > maxErrorMessage {
+			message = message[:maxErrorMessage]
+		}
+		m.ErrorType = StringPtr(errorType(err))
+		m.ErrorMessage = StringPtr(message)
+		// Server errors start with their kind, e.g. WRONGTYPE or MOVED
+		var redisErr redis.Error
+		if errors.As(err, &redisErr) {
+			kind, _, _ := strings.Cut(message, " ")
+			m.Metadata["redis_error"] = kind
+		}
+	}
+
+	c.TrackAPI(m)
+}
@@ -0,0 +1,132 @@
+package pulse
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// ============================================
+// REMOTE CONFIG
+// ============================================
+
+// defaultConfigInterval is the poll interval when the collector sends none
+const defaultConfigInterval = 5 * time.Minute
+
+// remoteConfig is the runtime directives of the client's site, set by the
+// collector's admins and fetched from /collect/config
+type remoteConfig struct {
+	SampleRate    float64  `json:"sample_rate"`
+	DisabledTypes []string `json:"disabled_types"`
+	Endpoint      string   `json:"endpoint"`
+	Disabled      bool     `json:"disabled"`
+	PollInterval  int      `json:"poll_interval"` // Seconds
+}
+
+// baseURL returns the collector that metrics are sent to: the endpoint of
+// the remote config when it rotates the site to another collector, the
+// configured one otherwise
+func (c *Client) baseURL() string {
+	if rc := c.remote.Load(); rc != nil && rc.Endpoint != "" {
+		return rc.Endpoint
+	}
+	return c.endpoint
+}
+
+// configLoop fetches the remote config on start and then periodically.
+// The config is always fetched from the configured endpoint, so rotating
+// to another collector can be undone there.
+func (c *Client) configLoop() {
+	defer c.wg.Done()
+
+	etag := ""
+	next := defaultConfigInterval
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+		interval, err := c.fetchConfig(ctx, &etag)
+		cancel()
+		switch {
+		case err != nil:
+			// Keep the last config; a collector restart must not undo a
+			// kill switch
+			slog.Debug("pulse: failed to fetch remote config", "error", err)
+		case c.configInterval > 0:
+			next = c.configInterval
+		case interval > 0:
+			next = interval
+		}
+
+		timer := c.clock.NewTimer(next)
+		select {
+		case <-timer.C():
+		case <-c.done:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// fetchConfig gets the remote config and applies it. An unchanged config
+// (304 for etag) is not applied again. It returns the poll interval the
+// collector asks for.
+func (c *Client) fetchConfig(ctx context.Context, etag *string) (time.Duration, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", c.endpoint+"/collect/config", nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("X-Site-Id", c.siteID)
+	req.Header.Set(SDKHeader, sdkHeaderValue)
+	if *etag != "" {
+		req.Header.Set("If-None-Match", *etag)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotModified:
+		if rc := c.remote.Load(); rc != nil {
+			return time.Duration(rc.PollInterval) * time.Second, nil
+		}
+		return 0, nil
+	default:
+		return 0, &StatusError{StatusCode: resp.StatusCode}
+	}
+
+	var rc remoteConfig
+	if err := json.NewDecoder(resp.Body).Decode(&rc); err != nil {
+		return 0, fmt.Errorf("decode remote config: %w", err)
+	}
+	*etag = resp.Header.Get("ETag")
+	c.applyConfig(&rc)
+	return time.Duration(rc.PollInterval) * time.Second, nil
+}
+
+// applyConfig makes rc the remote config. When it disables the site, the
+// metrics buffered and waiting for a resend are dropped too.
+func (c *Client) applyConfig(rc *remoteConfig) {
+	prev := c.remote.Swap(rc)
+
+	if rc.Disabled {
+		c.mu.Lock()
+		c.apiMetrics, c.pspMetrics, c.gameMetrics, c.wsMetrics = nil, nil, nil, nil
+		c.mu.Unlock()
+		c.retry.drain()
+	}
+
+	var wasDisabled bool
+	var wasEndpoint string
+	if prev != nil {
+		wasDisabled, wasEndpoint = prev.Disabled, prev.Endpoint
+	}
+	if rc.Disabled != wasDisabled || rc.Endpoint != wasEndpoint {
+		slog.Warn("pulse: remote config changed", "site_id", c.siteID, "disabled", rc.Disabled, "endpoint", c.baseURL())
+	}
+}
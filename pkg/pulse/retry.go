@@ -0,0 +1,166 @@
+package pulse
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// ============================================
+// RETRY BUFFER
+// ============================================
+
+// maxRetryBackoff caps the wait between resends
+const maxRetryBackoff = time.Minute
+
+// batch is the metrics of one flush
+type batch struct {
+	api  []APIMetric
+	psp  []PSPMetric
+	game []GameMetric
+	ws   []WebSocketMetric
+}
+
+func (b batch) len() int {
+	return len(b.api) + len(b.psp) + len(b.game) + len(b.ws)
+}
+
+// resendable reports whether the metrics of a failed request may be sent
+// again: the collector was unreachable or overloaded, or the caller gave up
+// waiting
+func resendable(ctx context.Context, err error) bool {
+	return errors.Is(err, ErrRetryable) || ctx.Err() != nil
+}
+
+// retryBuffer keeps the batches of failed flushes, oldest first, up to
+// limit metrics
+type retryBuffer struct {
+	mu      sync.Mutex
+	batches []batch
+	metrics int
+	limit   int // Disabled when negative
+
+	initialBackoff time.Duration
+	backoff        time.Duration // Wait after the next failure
+	nextAttempt    time.Time     // No resend before
+
+	sending sync.Mutex // Held while batches are resent
+}
+
+func newRetryBuffer(limit int, backoff time.Duration) *retryBuffer {
+	return &retryBuffer{limit: limit, initialBackoff: backoff, backoff: backoff}
+}
+
+// push adds a failed batch, dropping the oldest metrics beyond the limit
+func (rb *retryBuffer) push(b batch) {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	if rb.limit < 0 {
+		slog.Warn("pulse: metrics dropped after failed flush", "metrics", b.len())
+		return
+	}
+
+	rb.batches = append(rb.batches, b)
+	rb.metrics += b.len()
+	dropped := 0
+	for rb.metrics > rb.limit && len(rb.batches) > 0 {
+		n := rb.batches[0].len()
+		rb.batches = rb.batches[1:]
+		rb.metrics -= n
+		dropped += n
+	}
+	if dropped > 0 {
+		slog.Warn("pulse: retry buffer full, oldest metrics dropped", "metrics", dropped, "limit", rb.limit)
+	}
+}
+
+// pushFront puts a batch that failed again back in front
+func (rb *retryBuffer) pushFront(b batch) {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	rb.batches = append([]batch{b}, rb.batches...)
+	rb.metrics += b.len()
+}
+
+// pop takes the oldest batch once the backoff has passed, or regardless of
+// it with force
+func (rb *retryBuffer) pop(now time.Time, force bool) (batch, bool) {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	if len(rb.batches) == 0 || (!force && now.Before(rb.nextAttempt)) {
+		return batch{}, false
+	}
+	b := rb.batches[0]
+	rb.batches = rb.batches[1:]
+	rb.metrics -= b.len()
+	return b, true
+}
+
+// failed postpones the next resend by the backoff, or the collector's
+// Retry-After when longer, and doubles the backoff
+func (rb *retryBuffer) failed(now time.Time, err error) {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	wait := rb.backoff
+	var se *StatusError
+	if errors.As(err, &se) && se.RetryAfter > wait {
+		wait = se.RetryAfter
+	}
+	rb.nextAttempt = now.Add(wait)
+	rb.backoff = min(rb.backoff*2, maxRetryBackoff)
+}
+
+// succeeded resets the backoff: the collector is back, so buffered
+// metrics are resent on the next flush
+func (rb *retryBuffer) succeeded() {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	rb.backoff = rb.initialBackoff
+	rb.nextAttempt = time.Time{}
+}
+
+// Pending returns how many metrics wait in the retry buffer
+func (c *Client) Pending() int {
+	c.retry.mu.Lock()
+	defer c.retry.mu.Unlock()
+	return c.retry.metrics
+}
+
+// resend sends the batches of the retry buffer, oldest first, until one
+// fails again or the buffer is empty. Unless force, it waits for the
+// backoff of the last failure and for the circuit breaker. Only one resend
+// runs at a time.
+func (c *Client) resend(ctx context.Context, force bool) error {
+	if !c.retry.sending.TryLock() {
+		return nil
+	}
+	defer c.retry.sending.Unlock()
+
+	for {
+		b, ok := c.retry.pop(c.clock.Now(), force)
+		if !ok {
+			return nil
+		}
+		if !force && !c.breaker.allow(c.clock.Now()) {
+			c.retry.pushFront(b)
+			return nil
+		}
+		failed, err := c.sendBatch(ctx, b)
+		if !force {
+			c.breaker.record(ctx, c.clock.Now(), err)
+		}
+		if err == nil {
+			c.retry.succeeded()
+			continue
+		}
+		if failed.len() > 0 {
+			c.retry.pushFront(failed)
+			c.retry.failed(c.clock.Now(), err)
+			return err
+		}
+		// Rejected by the collector; sending again would fail again
+		slog.Warn("pulse: resent metrics rejected by collector", "metrics", b.len(), "error", err)
+	}
+}
@@ -0,0 +1,56 @@
+package pulse
+
+import (
+	"hash/fnv"
+	"math/rand/v2"
+	"slices"
+)
+
+// ============================================
+// SAMPLING
+// ============================================
+
+// accept reports whether a metric of type typ (api, psp, game, ws) is kept:
+// the site and the type are not disabled by the remote config, and the
+// metric is in the sample. The sample rate is the lower of SampleRates and
+// the remote one. Metrics are drawn by key (request, transaction, session
+// or connection ID), so every client keeps or drops the metrics of one
+// request alike; metrics without a key are drawn at random.
+func (c *Client) accept(typ, key string) bool {
+	rate := 1.0
+	if r, ok := c.sampleRates[typ]; ok {
+		rate = r
+	}
+	if rc := c.remote.Load(); rc != nil {
+		if rc.Disabled || slices.Contains(rc.DisabledTypes, typ) {
+			return false
+		}
+		rate = min(rate, rc.SampleRate)
+	}
+	return rate >= 1 || sampleDraw(key) < rate
+}
+
+// sampleDraw maps key to [0, 1), the same value for the same key
+func sampleDraw(key string) float64 {
+	if key == "" {
+		return rand.Float64()
+	}
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	// FNV leaves similar keys close together; mix the bits (splitmix64)
+	x := h.Sum64()
+	x = (x ^ x>>30) * 0xbf58476d1ce4e5b9
+	x = (x ^ x>>27) * 0x94d049bb133111eb
+	x ^= x >> 31
+	return float64(x>>11) / (1 << 53)
+}
+
+// sampleKey returns the ID a metric is sampled by, empty without one
+func sampleKey(ids ...*string) string {
+	for _, id := range ids {
+		if id != nil && *id != "" {
+			return *id
+		}
+	}
+	return ""
+}
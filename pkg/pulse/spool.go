@@ -0,0 +1,119 @@
+package pulse
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ============================================
+// DISK SPOOL
+// ============================================
+
+// spoolExt is the extension of spool files; files being written have
+// spoolExt+".tmp" and are never read
+const spoolExt = ".json"
+
+// spoolFile is the metrics of one spool file
+type spoolFile struct {
+	API  []APIMetric       `json:"api,omitempty"`
+	PSP  []PSPMetric       `json:"psp,omitempty"`
+	Game []GameMetric      `json:"game,omitempty"`
+	WS   []WebSocketMetric `json:"ws,omitempty"`
+}
+
+// drain takes every batch out of the buffer, oldest first
+func (rb *retryBuffer) drain() []batch {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	batches := rb.batches
+	rb.batches = nil
+	rb.metrics = 0
+	return batches
+}
+
+// spool writes the metrics still in the retry buffer to a new file in dir,
+// so the next client with the same SpoolDir sends them. The file appears
+// complete or not at all.
+func (c *Client) spool(dir string) error {
+	var f spoolFile
+	for _, b := range c.retry.drain() {
+		f.API = append(f.API, b.api...)
+		f.PSP = append(f.PSP, b.psp...)
+		f.Game = append(f.Game, b.game...)
+		f.WS = append(f.WS, b.ws...)
+	}
+	n := len(f.API) + len(f.PSP) + len(f.Game) + len(f.WS)
+	if n == 0 {
+		return nil
+	}
+
+	data, err := json.Marshal(f)
+	if err != nil {
+		return fmt.Errorf("pulse: encode spool: %w", err)
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return fmt.Errorf("pulse: create spool dir: %w", err)
+	}
+	// Named by time, so files are replayed oldest first
+	name := filepath.Join(dir, fmt.Sprintf("%020d-%d%s", c.clock.Now().UnixNano(), os.Getpid(), spoolExt))
+	if err := os.WriteFile(name+".tmp", data, 0o600); err != nil {
+		os.Remove(name + ".tmp")
+		return fmt.Errorf("pulse: write spool: %w", err)
+	}
+	if err := os.Rename(name+".tmp", name); err != nil {
+		os.Remove(name + ".tmp")
+		return fmt.Errorf("pulse: write spool: %w", err)
+	}
+	slog.Info("pulse: unsent metrics spooled to disk", "metrics", n, "file", name)
+	return nil
+}
+
+// replaySpool moves the metrics of the spool files in dir to the retry
+// buffer, oldest first, and removes the files. A file is taken by the
+// client that removes it, so clients sharing a SpoolDir send each file
+// once. Unreadable files are left in place.
+func (c *Client) replaySpool(dir string) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			slog.Warn("pulse: cannot read spool dir", "dir", dir, "error", err)
+		}
+		return
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+
+	replayed := 0
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), spoolExt) {
+			continue
+		}
+		name := filepath.Join(dir, e.Name())
+		data, err := os.ReadFile(name)
+		if err != nil {
+			slog.Warn("pulse: cannot read spool file", "file", name, "error", err)
+			continue
+		}
+		var f spoolFile
+		if err := json.Unmarshal(data, &f); err != nil {
+			slog.Warn("pulse: invalid spool file left in place", "file", name, "error", err)
+			continue
+		}
+		if err := os.Remove(name); err != nil {
+			continue // Taken by another client
+		}
+
+		b := batch{api: f.API, psp: f.PSP, game: f.Game, ws: f.WS}
+		c.retry.push(b)
+		replayed += b.len()
+	}
+	if replayed > 0 {
+		slog.Info("pulse: spooled metrics queued for sending", "metrics", replayed, "dir", dir)
+	}
+}
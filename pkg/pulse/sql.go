@@ -0,0 +1,388 @@
+package pulse
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"reflect"
+	"time"
+)
+
+// ============================================
+// DATABASE/SQL DRIVER WRAPPER
+// ============================================
+
+// sqlTracer records the queries of a wrapped driver
+type sqlTracer struct {
+	client  *Client
+	service string
+	target  string
+}
+
+func (t *sqlTracer) track(ctx context.Context, query string, start time.Time, rows int64, err error) {
+	if errors.Is(err, driver.ErrSkip) {
+		return // database/sql retries another way, which is recorded
+	}
+	if c := t.clientOrDefault(); c != nil {
+		c.trackQuery(ctx, t.service, t.target, query, start, rows, err)
+	}
+}
+
+func (t *sqlTracer) now() (time.Time, bool) {
+	c := t.clientOrDefault()
+	if c == nil {
+		return time.Time{}, false
+	}
+	return c.clock.Now(), true
+}
+
+func (t *sqlTracer) clientOrDefault() *Client {
+	if t.client != nil {
+		return t.client
+	}
+	return defaultClient.Load()
+}
+
+// WrapConnector returns a connector whose connections record every query
+// and statement execution through client, or the default client if nil, as
+// API metrics of serviceName, like NewQueryTracer does for pgx. Queries are
+// named by an sqlc "-- name: GetUser :one" comment, or else by statement
+// and main table, e.g. "ledger SELECT accounts", with literals replaced in
+// the statement kept in metadata. Exec records the rows affected; queries
+// record the rows read once closed, and their duration includes reading.
+//
+//	db := sql.OpenDB(pulse.WrapConnector(pc, "wallet", "ledger", connector))
+func WrapConnector(client *Client, serviceName, targetName string, connector driver.Connector) driver.Connector {
+	return &sqlConnector{base: connector, tracer: &sqlTracer{client: client, service: serviceName, target: targetName}}
+}
+
+// WrapDriver wraps a database/sql driver like WrapConnector, for drivers
+// opened by name:
+//
+//	sql.Register("postgres-pulse", pulse.WrapDriver(pc, "wallet", "ledger", &pq.Driver{}))
+//	db, err := sql.Open("postgres-pulse", dsn)
+func WrapDriver(client *Client, serviceName, targetName string, d driver.Driver) driver.Driver {
+	return &sqlDriver{base: d, tracer: &sqlTracer{client: client, service: serviceName, target: targetName}}
+}
+
+type sqlDriver struct {
+	base   driver.Driver
+	tracer *sqlTracer
+}
+
+func (d *sqlDriver) Open(name string) (driver.Conn, error) {
+	conn, err := d.base.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &sqlConn{base: conn, tracer: d.tracer}, nil
+}
+
+// OpenConnector implements driver.DriverContext
+func (d *sqlDriver) OpenConnector(name string) (driver.Connector, error) {
+	if dc, ok := d.base.(driver.DriverContext); ok {
+		connector, err := dc.OpenConnector(name)
+		if err != nil {
+			return nil, err
+		}
+		return &sqlConnector{base: connector, tracer: d.tracer}, nil
+	}
+	return &sqlConnector{base: dsnConnector{name: name, driver: d.base}, tracer: d.tracer}, nil
+}
+
+// dsnConnector opens connections of drivers without connectors
+type dsnConnector struct {
+	name   string
+	driver driver.Driver
+}
+
+func (c dsnConnector) Connect(context.Context) (driver.Conn, error) { return c.driver.Open(c.name) }
+func (c dsnConnector) Driver() driver.Driver                        { return c.driver }
+
+type sqlConnector struct {
+	base   driver.Connector
+	tracer *sqlTracer
+}
+
+func (c *sqlConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.base.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &sqlConn{base: conn, tracer: c.tracer}, nil
+}
+
+func (c *sqlConnector) Driver() driver.Driver {
+	return &sqlDriver{base: c.base.Driver(), tracer: c.tracer}
+}
+
+// sqlConn implements the optional connection interfaces, answering
+// driver.ErrSkip or the default where the wrapped connection does not, so
+// database/sql falls back as it would without the wrapper
+type sqlConn struct {
+	base   driver.Conn
+	tracer *sqlTracer
+}
+
+func (c *sqlConn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+func (c *sqlConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	var stmt driver.Stmt
+	var err error
+	if p, ok := c.base.(driver.ConnPrepareContext); ok {
+		stmt, err = p.PrepareContext(ctx, query)
+	} else {
+		stmt, err = c.base.Prepare(query)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &sqlStmt{base: stmt, query: query, tracer: c.tracer}, nil
+}
+
+func (c *sqlConn) Close() error { return c.base.Close() }
+
+func (c *sqlConn) Begin() (driver.Tx, error) { return c.base.Begin() }
+
+func (c *sqlConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if b, ok := c.base.(driver.ConnBeginTx); ok {
+		return b.BeginTx(ctx, opts)
+	}
+	if opts.Isolation != driver.IsolationLevel(0) || opts.ReadOnly {
+		return nil, errors.New("pulse: driver does not support transaction options")
+	}
+	return c.base.Begin()
+}
+
+func (c *sqlConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	e, ok := c.base.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	start, tracked := c.tracer.now()
+	result, err := e.ExecContext(ctx, query, args)
+	if tracked {
+		c.tracer.track(ctx, query, start, rowsAffected(result, err), err)
+	}
+	return result, err
+}
+
+func (c *sqlConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	q, ok := c.base.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	start, tracked := c.tracer.now()
+	rows, err := q.QueryContext(ctx, query, args)
+	if !tracked {
+		return rows, err
+	}
+	if err != nil {
+		c.tracer.track(ctx, query, start, -1, err)
+		return nil, err
+	}
+	return newSQLRows(ctx, rows, c.tracer, query, start), nil
+}
+
+func (c *sqlConn) Ping(ctx context.Context) error {
+	if p, ok := c.base.(driver.Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+func (c *sqlConn) ResetSession(ctx context.Context) error {
+	if r, ok := c.base.(driver.SessionResetter); ok {
+		return r.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c *sqlConn) IsValid() bool {
+	if v, ok := c.base.(driver.Validator); ok {
+		return v.IsValid()
+	}
+	return true
+}
+
+func (c *sqlConn) CheckNamedValue(nv *driver.NamedValue) error {
+	if n, ok := c.base.(driver.NamedValueChecker); ok {
+		return n.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+type sqlStmt struct {
+	base   driver.Stmt
+	query  string
+	tracer *sqlTracer
+}
+
+func (s *sqlStmt) Close() error  { return s.base.Close() }
+func (s *sqlStmt) NumInput() int { return s.base.NumInput() }
+
+func (s *sqlStmt) Exec(args []driver.Value) (driver.Result, error) { return s.base.Exec(args) }
+func (s *sqlStmt) Query(args []driver.Value) (driver.Rows, error)  { return s.base.Query(args) }
+
+func (s *sqlStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	start, tracked := s.tracer.now()
+	var result driver.Result
+	var err error
+	if e, ok := s.base.(driver.StmtExecContext); ok {
+		result, err = e.ExecContext(ctx, args)
+	} else {
+		var values []driver.Value
+		if values, err = namedValues(args); err == nil {
+			result, err = s.base.Exec(values)
+		}
+	}
+	if tracked {
+		s.tracer.track(ctx, s.query, start, rowsAffected(result, err), err)
+	}
+	return result, err
+}
+
+func (s *sqlStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	start, tracked := s.tracer.now()
+	var rows driver.Rows
+	var err error
+	if q, ok := s.base.(driver.StmtQueryContext); ok {
+		rows, err = q.QueryContext(ctx, args)
+	} else {
+		var values []driver.Value
+		if values, err = namedValues(args); err == nil {
+			rows, err = s.base.Query(values)
+		}
+	}
+	if !tracked {
+		return rows, err
+	}
+	if err != nil {
+		s.tracer.track(ctx, s.query, start, -1, err)
+		return nil, err
+	}
+	return newSQLRows(ctx, rows, s.tracer, s.query, start), nil
+}
+
+func (s *sqlStmt) CheckNamedValue(nv *driver.NamedValue) error {
+	if n, ok := s.base.(driver.NamedValueChecker); ok {
+		return n.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+// sqlRows counts the rows read and records the query when they are
+// closed, so like with pgx the duration includes reading them
+type sqlRows struct {
+	base   driver.Rows
+	ctx    context.Context
+	tracer *sqlTracer
+	query  string
+	start  time.Time
+	rows   int64
+	err    error
+	done   bool
+}
+
+func newSQLRows(ctx context.Context, rows driver.Rows, tracer *sqlTracer, query string, start time.Time) *sqlRows {
+	return &sqlRows{base: rows, ctx: ctx, tracer: tracer, query: query, start: start}
+}
+
+func (r *sqlRows) Columns() []string { return r.base.Columns() }
+
+func (r *sqlRows) Next(dest []driver.Value) error {
+	err := r.base.Next(dest)
+	switch {
+	case err == nil:
+		r.rows++
+	case !errors.Is(err, io.EOF):
+		r.err = err
+	}
+	return err
+}
+
+func (r *sqlRows) Close() error {
+	err := r.base.Close()
+	if !r.done {
+		r.done = true
+		r.tracer.track(r.ctx, r.query, r.start, r.rows, r.err)
+	}
+	return err
+}
+
+func (r *sqlRows) HasNextResultSet() bool {
+	if n, ok := r.base.(driver.RowsNextResultSet); ok {
+		return n.HasNextResultSet()
+	}
+	return false
+}
+
+func (r *sqlRows) NextResultSet() error {
+	if n, ok := r.base.(driver.RowsNextResultSet); ok {
+		return n.NextResultSet()
+	}
+	return io.EOF
+}
+
+func (r *sqlRows) ColumnTypeScanType(index int) reflect.Type {
+	if t, ok := r.base.(driver.RowsColumnTypeScanType); ok {
+		return t.ColumnTypeScanType(index)
+	}
+	return reflect.TypeOf(new(interface{})).Elem()
+}
+
+func (r *sqlRows) ColumnTypeDatabaseTypeName(index int) string {
+	if t, ok := r.base.(driver.RowsColumnTypeDatabaseTypeName); ok {
+		return t.ColumnTypeDatabaseTypeName(index)
+	}
+	return ""
+}
+
+func (r *sqlRows) ColumnTypeLength(index int) (int64, bool) {
+	if t, ok := r.base.(driver.RowsColumnTypeLength); ok {
+		return t.ColumnTypeLength(index)
+	}
+	return 0, false
+}
+
+func (r *sqlRows) ColumnTypeNullable(index int) (bool, bool) {
+	if t, ok := r.base.(driver.RowsColumnTypeNullable); ok {
+		return t.ColumnTypeNullable(index)
+	}
+	return false, false
+}
+
+func (r *sqlRows) ColumnTypePrecisionScale(index int) (int64, int64, bool) {
+	if t, ok := r.base.(driver.RowsColumnTypePrecisionScale); ok {
+		return t.ColumnTypePrecisionScale(index)
+	}
+	return 0, 0, false
+}
+
+// rowsAffected is the row count of an Exec result, -1 if unknown
+func rowsAffected(result driver.Result, err error) int64 {
+	if err != nil || result == nil {
+		return -1
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return -1
+	}
+	return n
+}
+
+// namedValues converts arguments for drivers without context methods,
+// which take no named arguments
+func namedValues(args []driver.NamedValue) ([]driver.Value, error) {
+	values := make([]driver.Value, len(args))
+	for i, arg := range args {
+		if arg.Name != "" {
+			return nil, errors.New("pulse: driver does not support named arguments")
+		}
+		values[i] = arg.Value
+	}
+	return values, nil
+}
@@ -0,0 +1,121 @@
+package pulse
+
+import (
+	"net/http"
+	"strings"
+)
+
+// ============================================
+// OUTBOUND HTTP INSTRUMENTATION
+// ============================================
+
+// Transport is an http.RoundTripper that records every outbound call as an
+// API metric of its service, so dependencies (PSP APIs, game providers)
+// can be monitored from the caller's side. See WrapTransport.
+type Transport struct {
+	// Base sends the requests; nil uses http.DefaultTransport
+	Base http.RoundTripper
+
+	// Route names the endpoint of a request, e.g. "/v1/payments/:id". nil
+	// uses the URL path with IDs replaced by :id, so IDs in paths do not
+	// make every call an endpoint of its own.
+	Route func(r *http.Request) string
+
+	client  *Client
+	service string
+	target  string
+}
+
+// WrapTransport returns a transport recording outbound calls to targetName
+// through client, or the default client if nil, as API metrics of
+// serviceName. The endpoint is targetName and the call's route, e.g.
+// "trustly /v1/payments/:id"; the metadata carries direction "outbound",
+// the target and the host. Calls that fail without a response are recorded
+// with status 0 and their error.
+//
+//	httpClient := &http.Client{Transport: pulse.WrapTransport(pc, "cashier", "trustly")}
+//
+// Set Base to instrument a transport of your own. The duration is the time
+// to the response headers; reading the body is not included.
+func WrapTransport(client *Client, serviceName, targetName string) *Transport {
+	return &Transport{client: client, service: serviceName, target: targetName}
+}
+
+// RoundTrip sends r through Base and records the call
+func (t *Transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	c := t.client
+	if c == nil {
+		c = defaultClient.Load()
+	}
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if c == nil {
+		return base.RoundTrip(r)
+	}
+
+	start := c.clock.Now()
+	resp, err := base.RoundTrip(r)
+
+	route := t.Route
+	if route == nil {
+		route = defaultRoute
+	}
+	m := APIMetric{
+		Time:        start,
+		ServiceName: t.service,
+		Endpoint:    strings.TrimSpace(t.target + " " + route(r)),
+		Method:      r.Method,
+		DurationMS:  float64(c.clock.Since(start).Milliseconds()),
+		Metadata: map[string]interface{}{
+			"direction": "outbound",
+			"target":    t.target,
+			"host":      r.URL.Host,
+		},
+	}
+
+	// Calls made while serving a request share its request ID, so they
+	// are sampled with it and can be traced back to it
+	if info, ok := r.Context().Value(requestKey{}).(*requestInfo); ok && info.requestID != nil {
+		m.RequestID = info.requestID
+	} else if id := r.Header.Get("X-Request-Id"); id != "" {
+		m.RequestID = &id
+	}
+	if r.ContentLength > 0 {
+		m.RequestSize = IntPtr(int(r.ContentLength))
+	}
+
+	if err != nil {
+		message := err.Error()
+		if len(message) > maxErrorMessage {
+			message = message[:maxErrorMessage]
+		}
+		m.ErrorType = StringPtr(errorType(err))
+		m.ErrorMessage = StringPtr(message)
+	} else {
+		m.StatusCode = resp.StatusCode
+		if resp.ContentLength >= 0 {
+			m.ResponseSize = IntPtr(int(resp.ContentLength))
+		}
+	}
+
+	c.TrackAPI(m)
+	return resp, err
+}
+
+// defaultRoute is the URL path of r with path segments that look like IDs
+// replaced by :id: numbers, and segments of 8 or more characters holding
+// digits (UUIDs, hashes, prefixed IDs). Version segments such as v1 stay.
+func defaultRoute(r *http.Request) string {
+	segments := strings.Split(r.URL.Path, "/")
+	for i, s := range segments {
+		if s == "" || !strings.ContainsAny(s, "0123456789") {
+			continue
+		}
+		if len(s) >= 8 || strings.Trim(s, "0123456789") == "" {
+			segments[i] = ":id"
+		}
+	}
+	return strings.Join(segments, "/")
+}
@@ -21,8 +21,10 @@ CREATE TABLE frontend_metrics (
     country         VARCHAR(2),
     
     -- Event identification
-    event_type      VARCHAR(50) NOT NULL,  -- page_load, web_vital, interaction, error
+    event_type      VARCHAR(50) NOT NULL,  -- page_load, web_vital, interaction, error, crash
     page_path       VARCHAR(255),
+    release         VARCHAR(50),   -- app/site release version
+    platform        VARCHAR(20),   -- web, ios, android, webview
     
     -- Web Vitals
     lcp_ms          DECIMAL(10,2),  -- Largest Contentful Paint
@@ -37,7 +39,10 @@ CREATE TABLE frontend_metrics (
     metric_value    DECIMAL(15,4),
     
     -- Context
-    metadata        JSONB DEFAULT '{}'
+    metadata        JSONB DEFAULT '{}',
+    event_id        VARCHAR(64),  -- Client-generated, retries are deduplicated by the collector
+    campaign        VARCHAR(100), -- Bonus/promotion campaign tag set by the SDK
+    site_id         VARCHAR(100)  -- X-Site-Id of the sender; dashboard users see their granted sites
 );
 
 SELECT create_hypertable('frontend_metrics', 'time',
@@ -66,7 +71,8 @@ CREATE TABLE api_metrics (
     request_size    INTEGER,
     response_size   INTEGER,
     
-    metadata        JSONB DEFAULT '{}'
+    metadata        JSONB DEFAULT '{}',
+    site_id         VARCHAR(100)   -- X-Site-Id of the sender
 );
 
 SELECT create_hypertable('api_metrics', 'time',
@@ -97,7 +103,14 @@ CREATE TABLE psp_metrics (
     -- PSP response
     psp_response_code VARCHAR(50),
     
-    metadata        JSONB DEFAULT '{}'
+    -- Withdrawal lifecycle: requested, approved, sent, settled, failed
+    state           VARCHAR(20),
+    
+    -- Bonus/promotion campaign tag set by the client
+    campaign        VARCHAR(100),
+    
+    metadata        JSONB DEFAULT '{}',
+    site_id         VARCHAR(100)   -- X-Site-Id of the sender
 );
 
 SELECT create_hypertable('psp_metrics', 'time',
@@ -125,7 +138,8 @@ CREATE TABLE game_metrics (
     error_type      VARCHAR(100),
     error_message   TEXT,
     
-    metadata        JSONB DEFAULT '{}'
+    metadata        JSONB DEFAULT '{}',
+    site_id         VARCHAR(100)   -- X-Site-Id of the sender
 );
 
 SELECT create_hypertable('game_metrics', 'time',
@@ -154,7 +168,8 @@ CREATE TABLE websocket_metrics (
     endpoint        VARCHAR(100),
     device_type     VARCHAR(20),
     
-    metadata        JSONB DEFAULT '{}'
+    metadata        JSONB DEFAULT '{}',
+    site_id         VARCHAR(100)   -- X-Site-Id of the sender
 );
 
 SELECT create_hypertable('websocket_metrics', 'time',
@@ -186,6 +201,7 @@ SELECT create_hypertable('business_metrics', 'time',
 -- 7. Alert Events
 -- Anomalies, threshold breaches
 CREATE TABLE alert_events (
+    id              BIGSERIAL,              -- Cursor of /api/alerts/stream
     time            TIMESTAMPTZ NOT NULL,
     alert_type      VARCHAR(50) NOT NULL,
     severity        VARCHAR(10) NOT NULL,  -- info, warning, critical
@@ -193,11 +209,14 @@ CREATE TABLE alert_events (
     -- Context
     source_table    VARCHAR(50),
     metric_name     VARCHAR(100),
+    target          VARCHAR(255),           -- PSP, game provider or service, for the provider catalog
+    site_id         VARCHAR(100),           -- NULL: across sites
     threshold_value DECIMAL(15,4),
     actual_value    DECIMAL(15,4),
     
     -- Status
     acknowledged    BOOLEAN DEFAULT FALSE,
+    acknowledged_at TIMESTAMPTZ,            -- First acknowledgement, for MTTA
     resolved_at     TIMESTAMPTZ,
     
     message         TEXT,
@@ -208,6 +227,431 @@ SELECT create_hypertable('alert_events', 'time',
     chunk_time_interval => INTERVAL '7 days'
 );
 
+-- 8. CSP Violation Reports
+-- Content-Security-Policy report-uri / report-to payloads
+CREATE TABLE csp_reports (
+    time                TIMESTAMPTZ NOT NULL,
+    document_uri        TEXT,
+    referrer            TEXT,
+    violated_directive  VARCHAR(255),
+    effective_directive VARCHAR(100) NOT NULL,
+    original_policy     TEXT,
+    disposition         VARCHAR(10),  -- enforce, report
+    blocked_uri         TEXT,
+    status_code         INTEGER,
+    source_file         TEXT,
+    line_number         INTEGER,
+    column_number       INTEGER,
+    script_sample       TEXT,
+    user_agent          TEXT
+);
+
+SELECT create_hypertable('csp_reports', 'time',
+    chunk_time_interval => INTERVAL '1 day'
+);
+
+-- 9. Dashboard Query Log
+-- Every dashboard API query, for /api/admin/query-stats
+CREATE TABLE query_log (
+    time            TIMESTAMPTZ NOT NULL,
+    user_email      VARCHAR(255),           -- NULL without login
+    endpoint        VARCHAR(255) NOT NULL,  -- Route pattern, e.g. GET /api/metrics/psp
+    params          JSONB DEFAULT '{}',     -- Query parameters and path values
+    status          SMALLINT NOT NULL,
+    duration_ms     DECIMAL(10,2) NOT NULL,
+    rows            INTEGER                 -- NULL when the endpoint does not report rows
+);
+
+SELECT create_hypertable('query_log', 'time',
+    chunk_time_interval => INTERVAL '1 day'
+);
+
+-- 10. Webhook Deliveries
+-- Every delivery attempt of the webhook channel, for /api/webhooks/{name}/deliveries
+CREATE TABLE webhook_deliveries (
+    time            TIMESTAMPTZ NOT NULL,
+    webhook         VARCHAR(100) NOT NULL,
+    alert_id        BIGINT,                 -- NULL for alerts not stored
+    alert_type      VARCHAR(50) NOT NULL,
+    metric_name     VARCHAR(255),
+    event           VARCHAR(10) NOT NULL,   -- raised, resolved
+    attempt         SMALLINT NOT NULL,      -- 1 for the first try
+    status_code     SMALLINT,               -- NULL when no response arrived
+    error           TEXT,                   -- NULL for delivered attempts
+    duration_ms     DECIMAL(10,2) NOT NULL
+);
+
+SELECT create_hypertable('webhook_deliveries', 'time',
+    chunk_time_interval => INTERVAL '1 day'
+);
+
+-- 11. Audit Log
+-- Security events of dashboard logins, for /api/admin/audit
+CREATE TABLE audit_log (
+    time            TIMESTAMPTZ NOT NULL,
+    event           VARCHAR(50) NOT NULL,   -- e.g. session_binding_mismatch
+    email           VARCHAR(255),           -- NULL when the user is unknown
+    ip              VARCHAR(45),            -- Client IP of the request
+    user_agent      TEXT,
+    detail          JSONB DEFAULT '{}'
+);
+
+SELECT create_hypertable('audit_log', 'time',
+    chunk_time_interval => INTERVAL '7 days'
+);
+
+-- 12. Schema Drift
+-- Events per minute whose fields differ from the collector's model, per
+-- sender and field: unknown fields (not read) and missing fields (read as
+-- zero values). Checked for the first 20 events of each batch section.
+CREATE TABLE schema_drift (
+    bucket          TIMESTAMPTZ NOT NULL,   -- Minute
+    site_id         VARCHAR(100) NOT NULL DEFAULT '',
+    producer        VARCHAR(100) NOT NULL DEFAULT '',
+    sdk             VARCHAR(100) NOT NULL DEFAULT '',
+    sdk_version     VARCHAR(100) NOT NULL DEFAULT '',
+    metric_type     VARCHAR(20) NOT NULL,   -- frontend, api, psp, game, ws
+    field           VARCHAR(100) NOT NULL,
+    kind            VARCHAR(10) NOT NULL,   -- unknown, missing
+    events          BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (bucket, site_id, producer, sdk, sdk_version, metric_type, field, kind)
+);
+
+SELECT create_hypertable('schema_drift', 'bucket',
+    chunk_time_interval => INTERVAL '1 day'
+);
+
+-- ============================================
+-- REGISTRY TABLES (regular tables)
+-- ============================================
+
+-- Producer registry: services sending metrics, their owners and last activity
+CREATE TABLE producers (
+    name            VARCHAR(100) PRIMARY KEY,
+    owner_team      VARCHAR(100),
+    sdk_version     VARCHAR(50),
+    site_id         VARCHAR(100),
+    metric_types    TEXT[] DEFAULT '{}',
+    observed_types  TEXT[] DEFAULT '{}',
+    registered_at   TIMESTAMPTZ,
+    last_seen_at    TIMESTAMPTZ
+);
+
+-- SDK version usage: daily request counts per SDK version, site and producer
+CREATE TABLE sdk_usage (
+    day             DATE NOT NULL,
+    sdk             VARCHAR(20) NOT NULL,
+    version         VARCHAR(50) NOT NULL,
+    site_id         VARCHAR(100) NOT NULL DEFAULT '',
+    producer        VARCHAR(100) NOT NULL DEFAULT '',
+    requests        BIGINT NOT NULL DEFAULT 0,
+    last_seen_at    TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (day, sdk, version, site_id, producer)
+);
+
+-- Scheduled jobs: definitions registered by the collector and their last run
+CREATE TABLE scheduled_jobs (
+    name                  VARCHAR(100) PRIMARY KEY,
+    schedule              VARCHAR(100) NOT NULL,
+    paused                BOOLEAN NOT NULL DEFAULT FALSE,
+    last_run_at           TIMESTAMPTZ,
+    last_status           VARCHAR(20),
+    last_error            TEXT,
+    last_duration_ms      BIGINT,
+    run_count             BIGINT NOT NULL DEFAULT 0,
+    failure_count         BIGINT NOT NULL DEFAULT 0,
+    consecutive_failures  INT NOT NULL DEFAULT 0,
+    updated_at            TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- Site credentials: API keys (stored hashed) and HMAC signing secrets for
+-- collect requests. Rotated credentials keep working until expires_at.
+CREATE TABLE site_credentials (
+    id              BIGSERIAL PRIMARY KEY,
+    site_id         VARCHAR(100) NOT NULL,
+    kind            VARCHAR(20) NOT NULL,   -- api_key, hmac_secret
+    scopes          TEXT[] NOT NULL DEFAULT '{}',  -- Metric types it may send; empty allows all
+    prefix          VARCHAR(20) NOT NULL,   -- First characters, to identify the credential
+    key_hash        VARCHAR(64),            -- SHA-256 of the API key
+    signing_secret  TEXT,                   -- HMAC secret
+    created_at      TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    expires_at      TIMESTAMPTZ,
+    revoked_at      TIMESTAMPTZ,
+    last_used_at    TIMESTAMPTZ,
+    use_count       BIGINT NOT NULL DEFAULT 0
+);
+
+CREATE INDEX idx_site_credentials_site ON site_credentials (site_id, created_at DESC);
+
+-- Data residency: sites whose data may only be stored in one region.
+-- Collectors of other regions forward or reject their collect requests.
+CREATE TABLE site_residency (
+    site_id         VARCHAR(100) PRIMARY KEY,
+    region          VARCHAR(32) NOT NULL,   -- DATA_REGION of the collectors allowed to store it, e.g. eu
+    updated_at      TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_by      VARCHAR(255)
+);
+
+-- Runtime directives for the SDKs and Go clients of a site, fetched from
+-- GET /collect/config, so instrumentation changes without redeploys
+CREATE TABLE site_sdk_config (
+    site_id         VARCHAR(100) PRIMARY KEY,
+    sample_rate     DOUBLE PRECISION NOT NULL DEFAULT 1,  -- Share of sessions / metrics sent, 0-1
+    disabled_types  TEXT[] NOT NULL DEFAULT '{}',         -- Event types (page_load, ...) and metric types (api, psp, game, ws) not sent
+    endpoint        TEXT,                                 -- Collector base URL clients switch to; NULL keeps theirs
+    disabled        BOOLEAN NOT NULL DEFAULT false,       -- Kill switch: clients send nothing
+    updated_at      TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_by      VARCHAR(255)
+);
+
+-- Kill switches: the collector stops accepting (reject) or silently drops
+-- (discard) a site's events of one type, or all of them (metric_type all),
+-- e.g. when a buggy release floods it with garbage
+CREATE TABLE kill_switches (
+    site_id         VARCHAR(100) NOT NULL,
+    metric_type     VARCHAR(20) NOT NULL,   -- all, frontend, page_load, ..., api, psp, game, ws
+    mode            VARCHAR(10) NOT NULL,   -- reject or discard
+    reason          TEXT NOT NULL,
+    created_at      TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    created_by      VARCHAR(255),
+    PRIMARY KEY (site_id, metric_type)
+);
+
+-- Ingest usage per UTC day, site and collect endpoint type: requests,
+-- events, and body bytes as sent (wire, possibly compressed) and as decoded
+-- (raw). Batch requests are split across their metric types by events.
+CREATE TABLE site_usage (
+    day             DATE NOT NULL,
+    site_id         VARCHAR(100) NOT NULL,
+    metric_type     VARCHAR(20) NOT NULL,   -- frontend, api, psp, game, ws, csp
+    requests        BIGINT NOT NULL DEFAULT 0,
+    events          BIGINT NOT NULL DEFAULT 0,
+    wire_bytes      BIGINT NOT NULL DEFAULT 0,
+    raw_bytes       BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (day, site_id, metric_type)
+);
+
+CREATE INDEX idx_site_usage_site ON site_usage (site_id, day DESC);
+
+-- Daily ingest quotas per site: collect requests are refused with 429 once
+-- the site's events or raw bytes of the UTC day reach a limit (0 = none)
+CREATE TABLE site_quotas (
+    site_id         VARCHAR(100) PRIMARY KEY,
+    max_events      BIGINT NOT NULL DEFAULT 0,
+    max_bytes       BIGINT NOT NULL DEFAULT 0,
+    updated_at      TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_by      VARCHAR(255)
+);
+
+-- Service accounts: tokens for internal services, limited to the collect
+-- endpoints listed in scopes (frontend, api, psp, game, ws, register)
+CREATE TABLE service_accounts (
+    id              BIGSERIAL PRIMARY KEY,
+    name            VARCHAR(100) NOT NULL UNIQUE,
+    site_id         VARCHAR(100),           -- NULL: any site
+    scopes          TEXT[] NOT NULL DEFAULT '{}',
+    prefix          VARCHAR(20) NOT NULL,
+    token_hash      VARCHAR(64) NOT NULL,   -- SHA-256 of the token
+    created_at      TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    revoked_at      TIMESTAMPTZ,
+    last_used_at    TIMESTAMPTZ,
+    use_count       BIGINT NOT NULL DEFAULT 0
+);
+
+-- Dashboard users. ADMIN_USERS entries are seeded as super_admin on
+-- startup; Google sign-ins create client users.
+CREATE TABLE users (
+    email           VARCHAR(255) PRIMARY KEY,
+    name            VARCHAR(255) NOT NULL DEFAULT '',
+    nickname        VARCHAR(100) NOT NULL DEFAULT '',
+    role            VARCHAR(20) NOT NULL DEFAULT 'client',  -- super_admin, admin, viewer, client
+    picture         TEXT,
+    password_hash   VARCHAR(64),            -- SHA-256; NULL for Google-only users
+    created_at      TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_login_at   TIMESTAMPTZ
+);
+
+-- Dashboard sessions, shared by all collector replicas
+CREATE TABLE sessions (
+    token_hash          VARCHAR(64) PRIMARY KEY,  -- SHA-256 of the bearer (access) token
+    refresh_hash        VARCHAR(64) NOT NULL UNIQUE,  -- SHA-256 of the refresh token
+    email               VARCHAR(255) NOT NULL REFERENCES users (email) ON DELETE CASCADE,
+    created_at          TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    expires_at          TIMESTAMPTZ NOT NULL,  -- Access token expiry
+    refresh_expires_at  TIMESTAMPTZ NOT NULL,  -- Moves forward on every refresh
+    client_net          VARCHAR(50),           -- IP prefix of the client, see SESSION_BINDING
+    user_agent_hash     VARCHAR(64)            -- SHA-256 of the client's User-Agent
+);
+
+CREATE INDEX idx_sessions_expires ON sessions (refresh_expires_at);
+
+-- OIDC logins between the redirect to the provider and its callback. The
+-- PKCE code verifier stays on the server.
+CREATE TABLE oidc_logins (
+    state_hash      VARCHAR(64) PRIMARY KEY,  -- SHA-256 of the state parameter
+    code_verifier   VARCHAR(128) NOT NULL,
+    nonce           VARCHAR(64) NOT NULL,
+    expires_at      TIMESTAMPTZ NOT NULL
+);
+
+-- Sites whose metrics a user may see; only roles without access to all
+-- sites (client) are restricted by them
+CREATE TABLE user_sites (
+    email           VARCHAR(255) NOT NULL REFERENCES users (email) ON DELETE CASCADE,
+    site_id         VARCHAR(100) NOT NULL,
+    granted_by      VARCHAR(255),
+    granted_at      TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (email, site_id)
+);
+
+-- Notifications held back by quiet hours or rate limits, sent as a digest
+CREATE TABLE notification_queue (
+    id              BIGSERIAL PRIMARY KEY,
+    channel         VARCHAR(50) NOT NULL,
+    reason          VARCHAR(20) NOT NULL,  -- quiet_hours, rate_limited
+    alert           JSONB NOT NULL,
+    queued_at       TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_notification_queue_channel ON notification_queue (channel, id);
+
+-- Time-boxed request recordings for support escalations (admin only)
+CREATE TABLE diagnostic_captures (
+    id              BIGSERIAL PRIMARY KEY,
+    site_id         VARCHAR(100),           -- NULL: any site
+    client_ip       VARCHAR(50),            -- Address or CIDR range, NULL: any client
+    note            TEXT,
+    created_by      VARCHAR(255) NOT NULL,
+    started_at      TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    expires_at      TIMESTAMPTZ NOT NULL,
+    stopped_at      TIMESTAMPTZ,
+    max_records     INTEGER NOT NULL
+);
+
+-- Recorded request/response pairs, secrets redacted. Deleted with their
+-- capture 7 days after it ended.
+CREATE TABLE diagnostic_records (
+    id                  BIGSERIAL PRIMARY KEY,
+    capture_id          BIGINT NOT NULL REFERENCES diagnostic_captures (id) ON DELETE CASCADE,
+    time                TIMESTAMPTZ NOT NULL,
+    method              VARCHAR(10) NOT NULL,
+    path                TEXT NOT NULL,
+    client_ip           VARCHAR(50) NOT NULL,
+    site_id             VARCHAR(100),
+    request_headers     JSONB NOT NULL,
+    request_body        TEXT NOT NULL,
+    request_truncated   BOOLEAN NOT NULL DEFAULT FALSE,
+    status              SMALLINT NOT NULL,
+    response_headers    JSONB NOT NULL,
+    response_body       TEXT NOT NULL,
+    response_truncated  BOOLEAN NOT NULL DEFAULT FALSE,
+    duration_ms         DECIMAL(10,2) NOT NULL
+);
+
+CREATE INDEX idx_diagnostic_records_capture ON diagnostic_records (capture_id, time);
+
+-- Service level objectives, defined via /api/slo, with the counts of their
+-- last evaluation
+CREATE TABLE slo_objectives (
+    name            VARCHAR(100) PRIMARY KEY,
+    sli             VARCHAR(50) NOT NULL,
+    target          VARCHAR(255) NOT NULL DEFAULT '',   -- '' for all targets
+    objective       DECIMAL(7,4) NOT NULL CHECK (objective > 0 AND objective < 100),
+    window_days     INTEGER NOT NULL CHECK (window_days > 0),
+    description     TEXT,
+    created_by      VARCHAR(255) NOT NULL,
+    created_at      TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at      TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    evaluated_at    TIMESTAMPTZ,
+    window_good     BIGINT NOT NULL DEFAULT 0,
+    window_total    BIGINT NOT NULL DEFAULT 0,
+    burn_good       BIGINT NOT NULL DEFAULT 0,
+    burn_total      BIGINT NOT NULL DEFAULT 0
+);
+
+-- Saved dashboard configurations (panels, filters, time range) of users
+CREATE TABLE dashboards (
+    id              BIGSERIAL PRIMARY KEY,
+    name            VARCHAR(200) NOT NULL,
+    owner_email     VARCHAR(255) NOT NULL,
+    site_id         VARCHAR(100),           -- NULL: across sites
+    shared          BOOLEAN NOT NULL DEFAULT FALSE,
+    config          JSONB NOT NULL DEFAULT '{}',
+    created_at      TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at      TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_dashboards_owner ON dashboards (owner_email);
+CREATE INDEX idx_dashboards_shared ON dashboards (site_id) WHERE shared;
+
+-- Threshold alert rules evaluated by the rule engine, with the state and
+-- outcome of their last evaluation
+CREATE TABLE alert_rules (
+    name             VARCHAR(100) PRIMARY KEY,
+    metric           VARCHAR(50) NOT NULL,
+    aggregation      VARCHAR(10) NOT NULL,    -- rate, count, avg, min, max, p50, p75, p95, p99
+    target           VARCHAR(255) NOT NULL DEFAULT '',   -- '' for all targets
+    site_id          VARCHAR(100) NOT NULL DEFAULT '',   -- '' for all sites
+    webhooks         TEXT[] NOT NULL DEFAULT '{}',       -- webhooks notified of its alerts
+    operator         VARCHAR(1) NOT NULL CHECK (operator IN ('<', '>')),
+    threshold        DOUBLE PRECISION NOT NULL,            -- derived from baseline for adaptive rules
+    adaptive         BOOLEAN NOT NULL DEFAULT FALSE,
+    baseline_percent DOUBLE PRECISION NOT NULL DEFAULT 0,  -- threshold = baseline * (1 + baseline_percent / 100)
+    baseline_weeks   INTEGER NOT NULL DEFAULT 0,
+    baseline         DOUBLE PRECISION,                     -- recomputed nightly from the rollups
+    baseline_at      TIMESTAMPTZ,
+    severity         VARCHAR(10) NOT NULL DEFAULT 'warning',
+    interval_seconds INTEGER NOT NULL CHECK (interval_seconds > 0),
+    window_seconds   INTEGER NOT NULL CHECK (window_seconds > 0),
+    enabled          BOOLEAN NOT NULL DEFAULT TRUE,
+    created_by       VARCHAR(255) NOT NULL,
+    created_at       TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at       TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    state            VARCHAR(10) NOT NULL DEFAULT 'inactive',  -- inactive, firing, resolved
+    state_changed_at TIMESTAMPTZ,
+    evaluated_at     TIMESTAMPTZ,
+    last_value       DOUBLE PRECISION,
+    last_samples     BIGINT NOT NULL DEFAULT 0,
+    last_error       TEXT
+);
+
+-- Catalog of PSPs and game providers for dashboards and notifications
+CREATE TABLE provider_catalog (
+    kind            VARCHAR(10) NOT NULL CHECK (kind IN ('psp', 'game')),
+    name            VARCHAR(100) NOT NULL,  -- psp_name or game provider as reported in metrics
+    display_name    VARCHAR(200) NOT NULL,
+    logo_url        TEXT,
+    regions         TEXT[] NOT NULL DEFAULT '{}',
+    contacts        JSONB NOT NULL DEFAULT '[]',  -- [{name, role, email, phone}]
+    escalation      TEXT,                   -- Quoted in alert notifications
+    updated_by      VARCHAR(255) NOT NULL,
+    updated_at      TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (kind, name)
+);
+
+-- Alert emails users subscribed to (NOTIFY_CHANNELS=email)
+CREATE TABLE email_subscriptions (
+    email           VARCHAR(255) PRIMARY KEY REFERENCES users (email) ON DELETE CASCADE,
+    enabled         BOOLEAN NOT NULL DEFAULT TRUE,
+    min_severity    VARCHAR(20) NOT NULL DEFAULT 'warning',  -- info, warning, critical
+    sites           TEXT[] NOT NULL DEFAULT '{}',  -- Empty: all sites the user may see
+    updated_at      TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- Outbound webhooks of the webhook channel (NOTIFY_CHANNELS=webhook).
+-- Alert rules name theirs in alert_rules.webhooks; all_alerts webhooks
+-- receive every alert.
+CREATE TABLE webhooks (
+    name            VARCHAR(100) PRIMARY KEY,
+    url             TEXT NOT NULL,
+    secret          TEXT NOT NULL DEFAULT '',  -- HMAC-SHA256 key of X-Pulse-Signature, '' unsigned
+    template        TEXT NOT NULL DEFAULT '',  -- text/template of the JSON payload, '' for the default
+    all_alerts      BOOLEAN NOT NULL DEFAULT FALSE,
+    enabled         BOOLEAN NOT NULL DEFAULT TRUE,
+    updated_by      VARCHAR(255) NOT NULL,
+    updated_at      TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
 -- ============================================
 -- INDEXES FOR COMMON QUERIES
 -- ============================================
@@ -217,30 +661,55 @@ CREATE INDEX idx_frontend_session ON frontend_metrics (session_id, time DESC);
 CREATE INDEX idx_frontend_player ON frontend_metrics (player_id, time DESC) WHERE player_id IS NOT NULL;
 CREATE INDEX idx_frontend_event_type ON frontend_metrics (event_type, time DESC);
 CREATE INDEX idx_frontend_page ON frontend_metrics (page_path, time DESC);
+CREATE INDEX idx_frontend_release ON frontend_metrics (release, platform, time DESC) WHERE release IS NOT NULL;
+CREATE INDEX idx_frontend_campaign ON frontend_metrics (campaign, time DESC) WHERE campaign IS NOT NULL;
+CREATE INDEX idx_frontend_site ON frontend_metrics (site_id, time DESC) WHERE site_id IS NOT NULL;
 
 -- API
 CREATE INDEX idx_api_service ON api_metrics (service_name, time DESC);
 CREATE INDEX idx_api_endpoint ON api_metrics (endpoint, time DESC);
 CREATE INDEX idx_api_errors ON api_metrics (status_code, time DESC) WHERE status_code >= 400;
+CREATE INDEX idx_api_site ON api_metrics (site_id, time DESC) WHERE site_id IS NOT NULL;
+CREATE INDEX idx_api_player ON api_metrics (player_id, time DESC) WHERE player_id IS NOT NULL;
 
 -- PSP
 CREATE INDEX idx_psp_provider ON psp_metrics (psp_name, time DESC);
 CREATE INDEX idx_psp_operation ON psp_metrics (operation, success, time DESC);
 CREATE INDEX idx_psp_errors ON psp_metrics (psp_name, time DESC) WHERE NOT success;
+CREATE INDEX idx_psp_withdrawals ON psp_metrics (transaction_id, time) WHERE state IS NOT NULL;
+CREATE INDEX idx_psp_campaign ON psp_metrics (campaign, time DESC) WHERE campaign IS NOT NULL;
+CREATE INDEX idx_psp_site ON psp_metrics (site_id, time DESC) WHERE site_id IS NOT NULL;
+CREATE INDEX idx_psp_player ON psp_metrics (player_id, time DESC) WHERE player_id IS NOT NULL;
 
 -- Games
 CREATE INDEX idx_game_provider ON game_metrics (provider, time DESC);
 CREATE INDEX idx_game_errors ON game_metrics (provider, time DESC) WHERE NOT launch_success;
+CREATE INDEX idx_game_site ON game_metrics (site_id, time DESC) WHERE site_id IS NOT NULL;
+CREATE INDEX idx_game_player ON game_metrics (player_id, time DESC) WHERE player_id IS NOT NULL;
 
 -- WebSocket
 CREATE INDEX idx_ws_player ON websocket_metrics (player_id, time DESC) WHERE player_id IS NOT NULL;
 CREATE INDEX idx_ws_errors ON websocket_metrics (time DESC) WHERE event_type = 'error';
+CREATE INDEX idx_ws_site ON websocket_metrics (site_id, time DESC) WHERE site_id IS NOT NULL;
 
 -- Business
 CREATE INDEX idx_business_type ON business_metrics (metric_type, time DESC);
 
 -- Alerts
 CREATE INDEX idx_alerts_unresolved ON alert_events (severity, time DESC) WHERE resolved_at IS NULL;
+CREATE INDEX idx_alerts_id ON alert_events (id);
+
+-- CSP
+CREATE INDEX idx_csp_directive ON csp_reports (effective_directive, time DESC);
+
+-- Query log
+CREATE INDEX idx_query_log_endpoint ON query_log (endpoint, time DESC);
+
+-- Webhook deliveries
+CREATE INDEX idx_webhook_deliveries_webhook ON webhook_deliveries (webhook, time DESC);
+
+-- Audit log
+CREATE INDEX idx_audit_log_email ON audit_log (email, time DESC);
 
 -- ============================================
 -- RETENTION POLICIES
@@ -267,6 +736,21 @@ SELECT add_retention_policy('business_metrics', INTERVAL '365 days');
 -- Alerts: 90 days
 SELECT add_retention_policy('alert_events', INTERVAL '90 days');
 
+-- CSP reports: 30 days
+SELECT add_retention_policy('csp_reports', INTERVAL '30 days');
+
+-- Dashboard query log: 30 days
+SELECT add_retention_policy('query_log', INTERVAL '30 days');
+
+-- Webhook deliveries: 30 days
+SELECT add_retention_policy('webhook_deliveries', INTERVAL '30 days');
+
+-- Audit log: 1 year
+SELECT add_retention_policy('audit_log', INTERVAL '365 days');
+
+-- Schema drift: 30 days
+SELECT add_retention_policy('schema_drift', INTERVAL '30 days');
+
 -- ============================================
 -- COMPRESSION POLICIES
 -- ============================================
@@ -0,0 +1,118 @@
+-- ============================================
+-- PRODUCT PULSE - ClickHouse shadow schema
+-- ============================================
+-- Candidate tables for shadow writes (SHADOW_CLICKHOUSE_URL). Column names
+-- match the JSON fields the collector sends; unknown fields are skipped and
+-- metadata is stored as a JSON string.
+--
+--   clickhouse-client --multiquery < scripts/clickhouse_shadow_schema.sql
+
+CREATE DATABASE IF NOT EXISTS pulse;
+
+CREATE TABLE IF NOT EXISTS pulse.frontend_metrics (
+    time            DateTime64(3, 'UTC'),
+    session_id      String,
+    player_id       Nullable(String),
+    device_type     LowCardinality(String),
+    browser         LowCardinality(String),
+    country         LowCardinality(String),
+    event_type      LowCardinality(String),
+    page_path       String,
+    release         Nullable(String),
+    platform        LowCardinality(Nullable(String)),
+    lcp_ms          Nullable(Float64),
+    fid_ms          Nullable(Float64),
+    cls             Nullable(Float64),
+    ttfb_ms         Nullable(Float64),
+    fcp_ms          Nullable(Float64),
+    inp_ms          Nullable(Float64),
+    metric_name     Nullable(String),
+    metric_value    Nullable(Float64),
+    metadata        String DEFAULT '{}',
+    event_id        Nullable(String),
+    campaign        Nullable(String),
+    site_id         LowCardinality(Nullable(String))
+) ENGINE = MergeTree
+PARTITION BY toDate(time)
+ORDER BY (event_type, time)
+TTL toDateTime(time) + INTERVAL 7 DAY;
+
+CREATE TABLE IF NOT EXISTS pulse.api_metrics (
+    time            DateTime64(3, 'UTC'),
+    service_name    LowCardinality(String),
+    endpoint        String,
+    method          LowCardinality(String),
+    duration_ms     Float64,
+    status_code     UInt16,
+    player_id       Nullable(String),
+    request_id      Nullable(String),
+    error_type      Nullable(String),
+    error_message   Nullable(String),
+    request_size    Nullable(Int32),
+    response_size   Nullable(Int32),
+    metadata        String DEFAULT '{}',
+    site_id         LowCardinality(Nullable(String))
+) ENGINE = MergeTree
+PARTITION BY toDate(time)
+ORDER BY (service_name, endpoint, time)
+TTL toDateTime(time) + INTERVAL 14 DAY;
+
+CREATE TABLE IF NOT EXISTS pulse.psp_metrics (
+    time              DateTime64(3, 'UTC'),
+    psp_name          LowCardinality(String),
+    operation         LowCardinality(String),
+    duration_ms       Float64,
+    success           Bool,
+    player_id         Nullable(String),
+    transaction_id    Nullable(String),
+    amount            Nullable(Decimal(15, 2)),
+    currency          LowCardinality(Nullable(String)),
+    error_code        Nullable(String),
+    error_message     Nullable(String),
+    psp_response_code Nullable(String),
+    state             LowCardinality(Nullable(String)),
+    campaign          Nullable(String),
+    metadata          String DEFAULT '{}',
+    site_id           LowCardinality(Nullable(String))
+) ENGINE = MergeTree
+PARTITION BY toDate(time)
+ORDER BY (psp_name, operation, time)
+TTL toDateTime(time) + INTERVAL 90 DAY;
+
+CREATE TABLE IF NOT EXISTS pulse.game_metrics (
+    time            DateTime64(3, 'UTC'),
+    provider        LowCardinality(String),
+    game_id         Nullable(String),
+    game_type       LowCardinality(Nullable(String)),
+    load_time_ms    Nullable(Float64),
+    launch_success  Bool,
+    player_id       Nullable(String),
+    session_id      Nullable(String),
+    device_type     LowCardinality(Nullable(String)),
+    error_type      Nullable(String),
+    error_message   Nullable(String),
+    metadata        String DEFAULT '{}',
+    site_id         LowCardinality(Nullable(String))
+) ENGINE = MergeTree
+PARTITION BY toDate(time)
+ORDER BY (provider, time)
+TTL toDateTime(time) + INTERVAL 30 DAY;
+
+CREATE TABLE IF NOT EXISTS pulse.websocket_metrics (
+    time              DateTime64(3, 'UTC'),
+    connection_id     String,
+    player_id         Nullable(String),
+    event_type        LowCardinality(String),
+    latency_ms        Nullable(Float64),
+    messages_sent     Nullable(Int32),
+    messages_received Nullable(Int32),
+    close_code        Nullable(Int16),
+    close_reason      Nullable(String),
+    endpoint          Nullable(String),
+    device_type       LowCardinality(Nullable(String)),
+    metadata          String DEFAULT '{}',
+    site_id           LowCardinality(Nullable(String))
+) ENGINE = MergeTree
+PARTITION BY toDate(time)
+ORDER BY (event_type, time)
+TTL toDateTime(time) + INTERVAL 7 DAY;